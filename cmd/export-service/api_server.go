@@ -10,13 +10,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	chi "github.com/go-chi/chi/v5"
 	middleware "github.com/go-chi/chi/v5/middleware"
 	redoc "github.com/go-openapi/runtime/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/redhatinsights/platform-go-middlewares/identity"
 	"github.com/redhatinsights/platform-go-middlewares/request_id"
 	"go.uber.org/zap"
 
@@ -25,50 +26,83 @@ import (
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/db"
 	"github.com/redhatinsights/export-service-go/exports"
+	"github.com/redhatinsights/export-service-go/faults"
+	"github.com/redhatinsights/export-service-go/health"
 	ekafka "github.com/redhatinsights/export-service-go/kafka"
 	"github.com/redhatinsights/export-service-go/logger"
 	metrics "github.com/redhatinsights/export-service-go/metrics"
 	emiddleware "github.com/redhatinsights/export-service-go/middleware"
 	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+	"github.com/redhatinsights/export-service-go/openapi"
 	es3 "github.com/redhatinsights/export-service-go/s3"
+	"github.com/redhatinsights/export-service-go/tlsconfig"
 )
 
 // func serveWeb(cfg *config.ExportConfig, consumers []services.ConsumerService) *http.Server {
-func createPublicServer(cfg *config.ExportConfig, external exports.Export) *http.Server {
+func createPublicServer(cfg *config.ExportConfig, external exports.Export, privateSpec http.Handler, log *zap.SugaredLogger) *http.Server {
 	// Initialize router
 	router := chi.NewRouter()
 
 	// setup middleware
 	router.Use(
+		emiddleware.Trace, // Trace starts the span of the request, continuing the trace of the caller.
 		request_id.RequestID,
 		emiddleware.JSONContentType, // Set content-Type headers as application/json
 		logger.ResponseLogger,
+		emiddleware.NewCORS(cfg).Handler, // Handler answers the preflight requests of every route.
 		setupDocsMiddleware,
 		metrics.PrometheusMiddleware,
 		middleware.Recoverer,
+		emiddleware.ReportPanics, // ReportPanics reports the panics of the handlers before the recoverer answers them.
 	)
 
+	rateLimiter := external.RateLimits
+	rbac := emiddleware.NewRBAC(cfg)
+
+	publicSpec := &openapi.Handler{}
+	publicSpecV2 := &openapi.Handler{}
+
 	router.Get("/", statusOK)
-	router.Get("/api/export/v1/openapi.json", servePublicOpenAPISpec(cfg)) // OpenAPI Specs
-	router.Get("/app/export/v1/openapi.json", servePrivateOpenAPISpec(cfg))
-
-	router.Route("/api/export/v1", func(r chi.Router) {
-		// add authentication middleware
-		r.Use(
-			identity.EnforceIdentity,        // EnforceIdentity extracts the X-Rh-Identity header and places the contents into the request context.
-			emiddleware.EnforceUserIdentity, // EnforceUserIdentity extracts account_number, org_id, and username from the X-Rh-Identity context.
-		)
-
-		// add external routes
-		r.Get("/ping", helloWorld) // Hello World endpoint
-		r.Route("/exports", external.ExportRouter)
-	})
+	router.With(rateLimiter.Limit).Get(exports.PublicBasePath+"/openapi.json", serveOpenAPISpec(cfg.OpenAPIPublicPath, publicSpec)) // OpenAPI Specs
+	router.With(rateLimiter.Limit).Get(exports.PublicBasePathV2+"/openapi.json", publicSpecV2.ServeHTTP)
+	router.With(rateLimiter.Limit).Get(exports.PrivateBasePath+"/openapi.json", serveOpenAPISpec(cfg.OpenAPIPrivatePath, privateSpec))
+
+	// both versions of the api share their handlers, only the responses differ
+	publicAPI := func(exportRouter func(chi.Router)) func(chi.Router) {
+		return func(r chi.Router) {
+			// add authentication middleware
+			r.Use(
+				emiddleware.EnforceIdentity,     // EnforceIdentity extracts the X-Rh-Identity header and places the contents into the request context.
+				emiddleware.EnforceUserIdentity, // EnforceUserIdentity extracts account_number, org_id, and username from the X-Rh-Identity context.
+				rateLimiter.Limit,               // Limit throttles the requests of each organization.
+				rbac.Enforce,                    // Enforce requires the export-service permission of the request from the users.
+				emiddleware.MaxBodySize(cfg.LimitsConfig.MaxRequestBodyBytes),
+			)
+
+			// add external routes
+			r.With(emiddleware.Timeout(cfg.HTTPConfig.RequestTimeout)).Get("/ping", helloWorld) // Hello World endpoint
+			r.With(emiddleware.Timeout(cfg.HTTPConfig.RequestTimeout)).Get("/limits", external.GetLimits)
+			r.Route("/exports", exportRouter)
+			r.Route("/schedules", external.ScheduleRouter)
+		}
+	}
+	router.Route(exports.PublicBasePath, publicAPI(external.ExportRouter))
+	router.Route(exports.PublicBasePathV2, publicAPI(external.ExportRouterV2))
+
+	if err := publicSpec.Build(exports.PublicSpec(), router, exports.PublicBasePath); err != nil {
+		log.Errorw("the public OpenAPI spec does not match the public api", "error", err)
+	}
+	if err := publicSpecV2.Build(exports.PublicSpecV2(), router, exports.PublicBasePathV2); err != nil {
+		log.Errorw("the v2 public OpenAPI spec does not match the public api", "error", err)
+	}
 
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.PublicPort),
 		Handler:      router,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  cfg.HTTPConfig.ReadTimeout,
+		WriteTimeout: cfg.HTTPConfig.WriteTimeout(),
+		IdleTimeout:  cfg.HTTPConfig.IdleTimeout,
 	}
 	// server.RegisterOnShutdown(func() {
 	// 	// initialize Kafka producers/consumers here
@@ -81,45 +115,62 @@ func createPublicServer(cfg *config.ExportConfig, external exports.Export) *http
 	return &server
 }
 
-func createPrivateServer(cfg *config.ExportConfig, internal exports.Internal) *http.Server {
+func createPrivateServer(cfg *config.ExportConfig, internal exports.Internal, producer *ekafka.Producer, msgChan chan *kafka.Message, privateSpec *openapi.Handler, log *zap.SugaredLogger) *http.Server {
 	// Initialize router
 	router := chi.NewRouter()
 
 	// setup middleware
 	router.Use(
+		emiddleware.Trace, // Trace starts the span of the request, continuing the trace of the caller.
 		request_id.RequestID,
 		emiddleware.JSONContentType, // Set content-Type headers as application/json
 		logger.ResponseLogger,
 		metrics.PrometheusMiddleware,
 		middleware.Recoverer,
+		emiddleware.ReportPanics, // ReportPanics reports the panics of the handlers before the recoverer answers them.
 	)
 
 	router.Get("/", statusOK)
 
-	router.Route("/app/export/v1", func(r chi.Router) {
-		r.Use(emiddleware.EnforcePSK)
+	// the uses of the psks are recorded for their rotations
+	emiddleware.PSKUses = emiddleware.NewPSKUseTracker(internal.DB)
+
+	router.Route(exports.PrivateBasePath, func(r chi.Router) {
+		r.Use(emiddleware.NewInternalAuth(cfg).Enforce)
 		// add internal routes
-		r.Get("/ping", helloWorld) // Hello World endpoint
+		r.With(emiddleware.Timeout(cfg.HTTPConfig.RequestTimeout)).Get("/ping", helloWorld) // Hello World endpoint
+		r.With(emiddleware.Timeout(cfg.HTTPConfig.RequestTimeout)).Get("/debug/kafka", producer.DebugHandler)
+		// a redrive waits for the messages of the dead letter topic
+		r.With(emiddleware.Timeout(cfg.HTTPConfig.DownloadTimeout)).Post("/kafka/dlq/redrive", ekafka.RedriveHandler(ekafka.NewDLQConsumer, msgChan))
 		r.Route("/", internal.InternalRouter)
 	})
 
+	if err := privateSpec.Build(exports.PrivateSpec(), router, exports.PrivateBasePath); err != nil {
+		log.Errorw("the private OpenAPI spec does not match the private api", "error", err)
+	}
+
 	return &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.PrivatePort),
 		Handler: router,
-		// TODO: tune these timeouts. This server is repsonsible for writing to s3.
-		// It is possible these values are way too low depending on the dataset received.
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		// the uploads are read within the deadline of their route, only the headers
+		// are held to the read timeout
+		ReadHeaderTimeout: cfg.HTTPConfig.ReadTimeout,
+		ReadTimeout:       cfg.HTTPConfig.DownloadTimeout,
+		WriteTimeout:      cfg.HTTPConfig.WriteTimeout(),
+		IdleTimeout:       cfg.HTTPConfig.IdleTimeout,
 	}
 }
 
-func createMetricsServer(cfg *config.ExportConfig) *http.Server {
+func createMetricsServer(cfg *config.ExportConfig, readiness http.Handler) *http.Server {
 	// Router for metrics
 	mr := chi.NewRouter()
 	mr.Get("/", statusOK)
-	mr.Get("/readyz", statusOK)  // for readiness probe
-	mr.Get("/healthz", statusOK) // for liveness probe
+	mr.Method(http.MethodGet, "/readyz", readiness) // for readiness probe
+	mr.Get("/healthz", statusOK)                    // for liveness probe, it must not depend on the dependencies
 	mr.Handle("/metrics", promhttp.Handler())
+	if cfg.DebugPprof {
+		mr.Mount("/debug", middleware.Profiler())
+	}
 
 	return &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
@@ -127,9 +178,34 @@ func createMetricsServer(cfg *config.ExportConfig) *http.Server {
 	}
 }
 
+// minFlushTimeout is how long the producer is flushed for on shutdown, even once the
+// grace period passed
+const minFlushTimeout = 1500 * time.Millisecond
+
+// drainServers stops the servers from accepting connections and waits for their
+// in-flight requests until the context is done, the requests still running then are
+// cut off.
+func drainServers(ctx context.Context, log *zap.SugaredLogger, servers map[string]*http.Server) {
+	var wg sync.WaitGroup
+	for name, srv := range servers {
+		wg.Add(1)
+		go func(name string, srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Errorw("the in-flight requests were not drained within the grace period, cutting them off", "server", name, "error", err)
+				if err := srv.Close(); err != nil {
+					log.Errorw("http server close failed", "server", name, "error", err)
+				}
+			}
+			log.Infof("%s server shutdown", name)
+		}(name, srv)
+	}
+	wg.Wait()
+}
+
 func setupDocsMiddleware(handler http.Handler) http.Handler {
 	opt := redoc.RedocOpts{
-		SpecURL: "/api/export/v1/openapi.json",
+		SpecURL: exports.PublicBasePath + "/openapi.json",
 	}
 	return redoc.Redoc(opt, handler)
 }
@@ -145,30 +221,19 @@ func statusOK(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// Serve OpenAPI spec json
-func servePublicOpenAPISpec(cfg *config.ExportConfig) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, cfg.OpenAPIPublicPath)
-	}
-}
-
-func servePrivateOpenAPISpec(cfg *config.ExportConfig) http.HandlerFunc {
+// serveOpenAPISpec serves the spec generated from the routes, or the override file
+// when one is configured.
+func serveOpenAPISpec(overridePath string, generated http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, cfg.OpenAPIPrivatePath)
+		if overridePath != "" {
+			http.ServeFile(w, r, overridePath)
+			return
+		}
+		generated.ServeHTTP(w, r)
 	}
 }
 
 func startApiServer(cfg *config.ExportConfig, log *zap.SugaredLogger) {
-	log.Infow("configuration values",
-		"hostname", cfg.Hostname,
-		"publicport", cfg.PublicPort,
-		"metricsport", cfg.MetricsPort,
-		"loglevel", cfg.LogLevel,
-		"debug", cfg.Debug,
-		"publicopenapifilepath", cfg.OpenAPIPublicPath,
-		"privateopenapifilepath", cfg.OpenAPIPrivatePath,
-	)
-
 	kafkaProducerMessagesChan := make(chan *kafka.Message) // TODO: determine an appropriate buffer (if one is actually necessary)
 
 	producer, err := ekafka.NewProducer()
@@ -176,59 +241,210 @@ func startApiServer(cfg *config.ExportConfig, log *zap.SugaredLogger) {
 		log.Panic("failed to create kafka producer", "error", err)
 	}
 	log.Infof("created kafka producer: %s", producer.String())
-	go producer.StartProducer(kafkaProducerMessagesChan)
 
 	DB, err := db.OpenDB(*cfg)
 	if err != nil {
 		log.Panic("failed to open database", "error", err)
 	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		log.Panic("failed to open database", "error", err)
+	}
+	// the schema is migrated by the init container, a pod does not serve until then
+	status, err := db.CheckSchemaVersion(sqlDB, log)
+	if err != nil {
+		log.Panicw("REFUSING TO SERVE, THE SCHEMA OF THE DATABASE DOES NOT MATCH THE BINARY", "error", err)
+	}
+	log.Infof("the schema of the database is at %s", status)
+	// the cleanups create the partitions of the months ahead as well, the exports fall
+	// in the default partition until then
+	if err := (&models.ExportDB{DB: DB, Cfg: cfg}).CreatePartitions(models.PartitionMonthsAhead); err != nil {
+		log.Errorw("failed to create the partitions of the months ahead", "error", err)
+	}
+	replica, err := db.UseReplica(DB, *cfg)
+	if err != nil {
+		log.Panicw("failed to open the replica of the database", "error", err)
+	}
+	if replica != nil {
+		log.Infow("reading the lists and the statuses of the exports from the replica of the database", "hostname", cfg.DBConfig.Replica.Hostname)
+	}
 
-	kafkaRequestAppResources := exports.KafkaRequestApplicationResources(kafkaProducerMessagesChan)
+	producer.OnDelivery = exports.RecordSourceDelivery(&models.ExportDB{DB: DB, Cfg: cfg}, log)
+	producer.OnDeadLetter = exports.RecordSourceDeadLetter(&models.ExportDB{DB: DB, Cfg: cfg}, log)
+	if cfg.KafkaConfig.SchemaRegistry.Validate {
+		validator, err := ekafka.NewSchemaValidator(context.Background(), ekafka.NewSchemaRegistry(cfg), cfg)
+		if err != nil {
+			log.Panic("failed to get the schemas of the events", "error", err)
+		}
+		producer.Validator = validator
+		log.Infof("validating the events against the schemas of %s", cfg.KafkaConfig.SchemaRegistry.URL)
+	}
+	go producer.StartProducer(kafkaProducerMessagesChan)
+
+	announceSource := exports.KafkaAnnounceSource(kafkaProducerMessagesChan, producer.Breaker)
+
+	// the faults can only be injected when debugging
+	var injector *faults.Injector
+	if cfg.Debug {
+		injector = faults.NewInjector()
+		announceSource = exports.FaultyAnnounceSource(injector, announceSource)
+		log.Warn("DEBUG is enabled, faults can be injected with /app/export/v1/debug/faults")
+	}
 
-	s3Client := es3.NewS3Client(*cfg, log)
+	// the requests for the data of the sources are written to the outbox with their
+	// export, the relay sends them
+	relay := exports.NewOutboxRelay(&models.ExportDB{DB: DB, Cfg: cfg}, announceSource, cfg, log)
+	kafkaRequestAppResources := relay.RequestApplicationResources()
+
+	storage, err := es3.NewStorage(context.Background(), *cfg, log, es3.PrometheusStats{})
+	if err != nil {
+		log.Panic("failed to create the storage client", "error", err)
+	}
+
+	if cfg.StorageConfig.Bootstrap {
+		s3Storage, ok := storage.(*es3.S3Storage)
+		if !ok {
+			log.Errorw("STORAGE_BOOTSTRAP is not supported by the storage provider, the container must be created beforehand", "provider", cfg.StorageConfig.Provider)
+		} else if err := es3.BootstrapBucket(context.Background(), s3Storage.Client, *cfg, log); err != nil {
+			// the bucket may be managed by someone else, keep serving
+			log.Errorw("FAILED TO BOOTSTRAP THE EXPORTS BUCKET, CHECK THE STORAGE CREDENTIALS AND PERMISSIONS", "error", err)
+		}
+	}
+
+	if err := storage.HealthCheck(context.Background()); err != nil {
+		log.Errorw("the exports bucket can not be reached", "provider", cfg.StorageConfig.Provider, "error", err)
+	}
+
+	// the transitions of the exports are published when the status events are enabled
+	var events notify.StatusPublisher
+	if cfg.KafkaConfig.StatusEvents {
+		events = &notify.KafkaStatusPublisher{Cfg: cfg, Chan: kafkaProducerMessagesChan, Breaker: producer.Breaker, Log: log}
+		log.Infof("publishing the status events to %s", cfg.KafkaConfig.StatusTopic)
+	}
 
 	storageHandler := es3.Compressor{
-		Bucket: cfg.StorageConfig.Bucket,
-		Log:    log,
-		Client: *s3Client,
-		Cfg:    *cfg,
+		Log:     log,
+		Storage: storage,
+		Cfg:     *cfg,
+		Notifier: &notify.WebhookNotifier{
+			Cfg: *cfg,
+			Log: log,
+		},
+		Events: events,
+		Faults: injector,
 	}
 
 	external := exports.Export{
+		Cfg:                 cfg,
 		Bucket:              cfg.StorageConfig.Bucket,
 		StorageHandler:      &storageHandler,
 		DB:                  &models.ExportDB{DB: DB, Cfg: cfg},
 		RequestAppResources: kafkaRequestAppResources,
+		CancelSources:       exports.KafkaCancelSources(kafkaProducerMessagesChan, producer.Breaker),
+		Downloads:           emiddleware.NewDownloadLimiter(cfg),
+		RateLimits:          emiddleware.NewRateLimiter(cfg),
+		Events:              events,
 		Log:                 log,
 	}
-	wsrv := createPublicServer(cfg, external)
+	// the private spec is served by the public server but generated from the
+	// private routes
+	privateSpec := &openapi.Handler{}
+	wsrv := createPublicServer(cfg, external, privateSpec, log)
 
 	internal := exports.Internal{
 		Cfg:        cfg,
 		Compressor: &storageHandler,
 		DB:         &models.ExportDB{DB: DB, Cfg: cfg},
 		Log:        log,
+		Announce:   announceSource,
+		Cleaner: &exports.Cleaner{
+			DB:         &models.ExportDB{DB: DB, Cfg: cfg},
+			Compressor: &storageHandler,
+			Log:        log,
+			Events:     events,
+		},
+		Events: events,
+		Faults: injector,
+	}
+	psrv := createPrivateServer(cfg, internal, producer, kafkaProducerMessagesChan, privateSpec, log)
+	var privateTLS *tlsconfig.MutualTLS
+	if cfg.PrivateTLSConfig.Enabled() {
+		privateTLS, err = tlsconfig.New(cfg.PrivateTLSConfig.CertFile, cfg.PrivateTLSConfig.KeyFile, cfg.PrivateTLSConfig.ClientCAFile, log)
+		if err != nil {
+			log.Panicw("failed to load the certificates of the private server", "error", err)
+		}
+		psrv.TLSConfig = privateTLS.TLSConfig()
+	}
+	readiness := &health.Readiness{
+		Checks: map[string]health.Check{
+			"database": health.Database(sqlDB),
+			"kafka":    producer.HealthCheck,
+			"storage":  storage.HealthCheck,
+		},
+		Timeout: cfg.ReadinessTimeout,
+		Log:     log,
+	}
+	if replica != nil {
+		readiness.Checks["database_replica"] = health.Database(replica)
+	}
+	msrv := createMetricsServer(cfg, readiness)
+
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	if cfg.SchedulerInterval > 0 {
+		scheduler := exports.Scheduler{Export: &external, Interval: cfg.SchedulerInterval}
+		go scheduler.Start(schedulerCtx)
+		log.Infof("scheduler started with an interval of %s", cfg.SchedulerInterval)
+	}
+
+	if cfg.SourceTimeoutInterval > 0 {
+		timeouts := exports.SourceTimeouts{Internal: &internal, Interval: cfg.SourceTimeoutInterval}
+		go timeouts.Start(schedulerCtx)
+		log.Infof("source timeouts checked with an interval of %s", cfg.SourceTimeoutInterval)
+	}
+
+	if privateTLS != nil && cfg.PrivateTLSConfig.ReloadInterval > 0 {
+		go privateTLS.Watch(schedulerCtx, cfg.PrivateTLSConfig.ReloadInterval)
+	}
+
+	if cfg.CleanupInterval > 0 {
+		go internal.Cleaner.Loop(schedulerCtx, cfg.CleanupInterval)
+		log.Infof("cleanups started with an interval of %s", cfg.CleanupInterval)
+	}
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	go relay.Start(relayCtx)
+	log.Infof("outbox relay started with an interval of %s", cfg.KafkaConfig.OutboxInterval)
+
+	if cfg.KafkaConfig.ConsumeResponses {
+		consumer, err := ekafka.NewResponsesConsumer()
+		if err != nil {
+			log.Panic("failed to create the responses consumer", "error", err)
+		}
+		responses := exports.ResponseConsumer{Internal: &internal, Consumer: consumer, Log: log}
+		go responses.Start(relayCtx)
+		log.Infof("consuming the responses of the sources from %s", cfg.KafkaConfig.ResponsesTopic)
 	}
-	psrv := createPrivateServer(cfg, internal)
-	msrv := createMetricsServer(cfg)
 
 	idleConnsClosed := make(chan struct{})
+	// shutdownDeadline bounds the whole shutdown, it is set before idleConnsClosed is
+	// closed
+	var shutdownDeadline time.Time
 	go func() {
 		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
-		if err := wsrv.Shutdown(context.Background()); err != nil {
-			log.Errorw("http server shutdown failed", "error", err)
-		}
-		log.Info("public server shutdown")
-		if err := psrv.Shutdown(context.Background()); err != nil {
-			log.Errorw("http server shutdown failed", "error", err)
-		}
-		log.Info("private server shutdown")
-		if err := msrv.Shutdown(context.Background()); err != nil {
-			log.Errorw("http server shutdown failed", "error", err)
-		}
-		log.Info("metrics server shutdown")
+		// kubernetes stops the pods with a SIGTERM
+		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigint
+		log.Infow("shutting down, draining the in-flight requests", "signal", sig.String(), "grace_period", cfg.ShutdownGracePeriod)
+		shutdownDeadline = time.Now().Add(cfg.ShutdownGracePeriod)
+		ctx, cancel := context.WithDeadline(context.Background(), shutdownDeadline)
+		defer cancel()
+
+		stopScheduler()
+		stopRelay()
+		// the uploads and downloads are drained together, the metrics are served
+		// until they are done
+		drainServers(ctx, log, map[string]*http.Server{"public": wsrv, "private": psrv})
+		drainServers(ctx, log, map[string]*http.Server{"metrics": msrv})
 		close(idleConnsClosed)
 	}()
 
@@ -238,6 +454,9 @@ func startApiServer(cfg *config.ExportConfig, log *zap.SugaredLogger) {
 		}
 	}()
 	log.Infof("metrics server started on %s", msrv.Addr)
+	if cfg.DebugPprof {
+		log.Warnf("DEBUG_PPROF is enabled, the profiles are served on %s/debug/pprof/", msrv.Addr)
+	}
 
 	go func() {
 		if err := wsrv.ListenAndServe(); err != http.ErrServerClosed {
@@ -247,21 +466,48 @@ func startApiServer(cfg *config.ExportConfig, log *zap.SugaredLogger) {
 	log.Infof("public server started on %s", wsrv.Addr)
 
 	go func() {
-		if err := psrv.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if privateTLS != nil {
+			// the certificates are served by the TLSConfig
+			err = psrv.ListenAndServeTLS("", "")
+		} else {
+			err = psrv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.Panicw("private server stopped unexpectedly", "error", err)
 		}
 	}()
-	log.Infof("private server started on %s", psrv.Addr)
+	log.Infow("private server started", "addr", psrv.Addr, "mutual_tls", privateTLS != nil)
 
 	<-idleConnsClosed
 
 	close(kafkaProducerMessagesChan)
 
-	log.Info("flushing kafka producer")
-	producer.Flush(1500) // 1.5 second timeout
+	// the producer has what is left of the grace period to deliver its messages, and
+	// at least the time of a delivery
+	flushTimeout := time.Until(shutdownDeadline)
+	if flushTimeout < minFlushTimeout {
+		flushTimeout = minFlushTimeout
+	}
+	log.Infow("flushing kafka producer", "timeout", flushTimeout)
+	if pending := producer.Flush(int(flushTimeout.Milliseconds())); pending > 0 {
+		log.Errorw("the kafka producer was closed before it delivered every message", "pending", pending)
+	}
 	producer.Close()
 	log.Info("closed kafka producer")
 
+	// the delivery reports are recorded in the database until the producer is closed
+	if err := sqlDB.Close(); err != nil {
+		log.Errorw("failed to close the database connections", "error", err)
+	}
+	if replica != nil {
+		if err := replica.Close(); err != nil {
+			log.Errorw("failed to close the connections to the replica of the database", "error", err)
+		}
+	}
+	es3.CloseIdleConnections()
+	log.Info("closed the database and storage connections")
+
 	log.Info("syncing logger")
 	if err := log.Sync(); err != nil {
 		log.Errorw("failed to sync logger", "error", err)
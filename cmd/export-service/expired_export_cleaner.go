@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/db"
+	"github.com/redhatinsights/export-service-go/exports"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
 	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+	es3 "github.com/redhatinsights/export-service-go/s3"
 
 	"go.uber.org/zap"
 )
@@ -17,13 +25,52 @@ func startExpiredExportCleaner(cfg *config.ExportConfig, log *zap.SugaredLogger)
 		log.Panic("failed to open database", "error", err)
 	}
 
-	exportsDB := models.ExportDB{
-		DB:  dbConnection,
-		Cfg: cfg,
+	cleaner := exports.Cleaner{
+		DB: &models.ExportDB{
+			DB:  dbConnection,
+			Cfg: cfg,
+		},
+		Log: log,
+	}
+
+	// the objects of the purged exports are removed even when the source objects are
+	// kept
+	storage, err := es3.NewStorage(context.Background(), *cfg, log, es3.PrometheusStats{})
+	if err != nil {
+		// the expired exports are deleted nonetheless
+		log.Errorw("failed to create the storage client", "error", err)
+	} else {
+		cleaner.Compressor = &es3.Compressor{
+			Log:     log,
+			Storage: storage,
+			Cfg:     *cfg,
+		}
+	}
+
+	if cfg.KafkaConfig.StatusEvents {
+		producer, err := ekafka.NewProducer()
+		if err != nil {
+			// the expired exports are deleted nonetheless
+			log.Errorw("failed to create kafka producer, the expiry of the exports is not published", "error", err)
+		} else {
+			msgChan := make(chan *kafka.Message)
+			go producer.StartProducer(msgChan)
+			cleaner.Events = &notify.KafkaStatusPublisher{Cfg: cfg, Chan: msgChan, Breaker: producer.Breaker, Log: log}
+			defer func() {
+				// the job exits once the events are delivered
+				log.Info("flushing kafka producer")
+				producer.Flush(int(cfg.KafkaConfig.ProduceTimeout.Milliseconds()))
+				producer.Close()
+			}()
+		}
 	}
 
-	err = exportsDB.DeleteExpiredExports()
+	report, err := cleaner.Run(context.Background(), false)
 	if err != nil {
-		log.Error("Expired export cleaner failed", "error", err)
+		log.Errorw("Expired export cleaner failed", "error", err)
+		return
+	}
+	if len(report.Errors) > 0 {
+		log.Errorw("Expired export cleaner failed", "errors", report.Errors)
 	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/db"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+func startExpiryWarner(cfg *config.ExportConfig, log *zap.SugaredLogger) {
+	if cfg.ExpiryWarningConfig.Window <= 0 {
+		log.Info("The expiry warnings are disabled")
+		return
+	}
+
+	log.Info("Starting expiry warner")
+
+	dbConnection, err := db.OpenDB(*cfg)
+	if err != nil {
+		log.Panic("failed to open database", "error", err)
+	}
+
+	producer, err := ekafka.NewProducer()
+	if err != nil {
+		log.Panic("failed to create kafka producer", "error", err)
+	}
+	msgChan := make(chan *kafka.Message)
+	go producer.StartProducer(msgChan)
+	defer func() {
+		// the job exits once the warnings are delivered
+		log.Info("flushing kafka producer")
+		producer.Flush(int(cfg.KafkaConfig.ProduceTimeout.Milliseconds()))
+		producer.Close()
+	}()
+
+	warner := notify.ExpiryWarner{
+		DB: &models.ExportDB{
+			DB:  dbConnection,
+			Cfg: cfg,
+		},
+		Cfg:     cfg,
+		Chan:    msgChan,
+		Breaker: producer.Breaker,
+		Log:     log,
+	}
+
+	sent, err := warner.Run(context.Background())
+	if err != nil {
+		log.Errorw("Expiry warner failed", "sent", sent, "error", err)
+		return
+	}
+	log.Infow("Expiry warner finished", "sent", sent)
+}
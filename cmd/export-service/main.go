@@ -1,11 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
 
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/reporting"
+	"github.com/redhatinsights/export-service-go/tracing"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -28,6 +30,28 @@ func createRootCommand(cfg *config.ExportConfig, log *zap.SugaredLogger) *cobra.
 
 	rootCmd.AddCommand(expiredExportCleanerCmd)
 
+	var expiryWarnerCmd = &cobra.Command{
+		Use:   "expiry_warner",
+		Short: "Warn the owners of the exports about to be deleted",
+		Run: func(cmd *cobra.Command, args []string) {
+			startExpiryWarner(cfg, log)
+		},
+	}
+
+	rootCmd.AddCommand(expiryWarnerCmd)
+
+	var orphanDryRun bool
+	var orphanCollectorCmd = &cobra.Command{
+		Use:   "orphan_collector",
+		Short: "Delete the objects of the bucket that belong to no export",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collectOrphanedObjects(cfg, log, orphanDryRun)
+		},
+	}
+	orphanCollectorCmd.Flags().BoolVar(&orphanDryRun, "dry-run", false, "list the orphaned objects without deleting them")
+
+	rootCmd.AddCommand(orphanCollectorCmd)
+
 	var apiServerCmd = &cobra.Command{
 		Use:   "api_server",
 		Short: "Run the api server",
@@ -38,35 +62,58 @@ func createRootCommand(cfg *config.ExportConfig, log *zap.SugaredLogger) *cobra.
 
 	rootCmd.AddCommand(apiServerCmd)
 
-	var migrateDbCmd = &cobra.Command{
-		Use:   "migrate_db",
-		Short: "Run the db migration",
+	var replayExportID string
+	var replayCmd = &cobra.Command{
+		Use:   "replay",
+		Short: "Announce the pending sources of an export again",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return replayExport(cfg, log, replayExportID)
+		},
+	}
+	replayCmd.Flags().StringVar(&replayExportID, "export-id", "", "the UUID of the export")
+	_ = replayCmd.MarkFlagRequired("export-id")
+
+	rootCmd.AddCommand(replayCmd)
+
+	// the migrations are embedded in the binary, migrate_db upgrade and downgrade
+	// are the former names of the commands
+	var migrateCmd = &cobra.Command{
+		Use:     "migrate",
+		Aliases: []string{"migrate_db"},
+		Short:   "Migrate the schema of the database",
 	}
 
-	// upCmd represents the up command
 	var upCmd = &cobra.Command{
-		Use:   "upgrade",
-		Short: "Upgrade to a later version",
+		Use:     "up",
+		Aliases: []string{"upgrade"},
+		Short:   "Apply the pending migrations",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("up called")
 			return performDbMigration(cfg, log, "up")
 		},
 	}
 
-	// downCmd represents the down command
 	var downCmd = &cobra.Command{
-		Use:   "downgrade",
-		Short: "Revert to a previous version",
+		Use:     "down",
+		Aliases: []string{"downgrade"},
+		Short:   "Revert the last migration",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("down called")
 			return performDbMigration(cfg, log, "down")
 		},
 	}
 
-	rootCmd.AddCommand(migrateDbCmd)
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Print the version of the schema against the migrations of the binary",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printMigrationStatus(cfg, log, cmd.OutOrStdout())
+		},
+	}
 
-	migrateDbCmd.AddCommand(upCmd)
-	migrateDbCmd.AddCommand(downCmd)
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.AddCommand(upCmd)
+	migrateCmd.AddCommand(downCmd)
+	migrateCmd.AddCommand(statusCmd)
 
 	return rootCmd
 }
@@ -74,9 +121,25 @@ func createRootCommand(cfg *config.ExportConfig, log *zap.SugaredLogger) *cobra.
 func main() {
 	cfg := config.Get()
 	log := logger.Get()
+	// the secrets of the configuration are masked in its dump
+	log.Infow("configuration values", "config", cfg)
+
+	// the spans of every command are exported, and flushed once it is done
+	shutdownTracing := tracing.Init(cfg, log)
+	flushReports := reporting.Init(cfg, log)
 
 	cmd := createRootCommand(cfg, log)
-	if err := cmd.Execute(); err != nil {
+	err := cmd.Execute()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.TracingConfig.Timeout)
+	if err := shutdownTracing(ctx); err != nil {
+		log.Errorw("failed to flush the spans", "error", err)
+	}
+	cancel()
+	if !flushReports() {
+		log.Error("failed to send the reported errors")
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
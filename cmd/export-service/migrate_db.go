@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/db"
 
@@ -11,9 +14,25 @@ func performDbMigration(cfg *config.ExportConfig, log *zap.SugaredLogger, direct
 
 	databaseConn, err := db.OpenPostgresDB(*cfg)
 	if err != nil {
-		log.Error("Unable to initialize database connection", "error", err)
+		log.Errorw("Unable to initialize database connection", "error", err)
+		return err
+	}
+	defer databaseConn.Close()
+
+	return db.PerformDbMigration(databaseConn, log, direction)
+}
+
+// printMigrationStatus prints the version of the schema, it fails when the api server
+// would refuse to start with it.
+func printMigrationStatus(cfg *config.ExportConfig, log *zap.SugaredLogger, out io.Writer) error {
+	databaseConn, err := db.OpenPostgresDB(*cfg)
+	if err != nil {
+		log.Errorw("Unable to initialize database connection", "error", err)
 		return err
 	}
+	defer databaseConn.Close()
 
-	return db.PerformDbMigration(databaseConn, log, "file://db/migrations", direction)
+	status, err := db.CheckSchemaVersion(databaseConn, log)
+	fmt.Fprintf(out, "schema %s\n", status)
+	return err
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/db"
+	"github.com/redhatinsights/export-service-go/models"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+func collectOrphanedObjects(cfg *config.ExportConfig, log *zap.SugaredLogger, dryRun bool) error {
+	log.Infow("Starting orphaned object collector", "dry_run", dryRun)
+
+	dbConnection, err := db.OpenDB(*cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	storage, err := es3.NewStorage(context.Background(), *cfg, log, es3.PrometheusStats{})
+	if err != nil {
+		return fmt.Errorf("failed to create the storage client: %w", err)
+	}
+
+	collector := es3.OrphanCollector{
+		DB:      &models.ExportDB{DB: dbConnection, Cfg: cfg},
+		Storage: storage,
+		MinAge:  cfg.StorageConfig.OrphanMinAge,
+		Log:     log,
+	}
+	_, err = collector.Run(context.Background(), dryRun)
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/db"
+	"github.com/redhatinsights/export-service-go/exports"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/models"
+
+	"go.uber.org/zap"
+)
+
+// replayExport sends the requests for the data of the pending sources of the export
+// to their applications again, then waits for their delivery.
+func replayExport(cfg *config.ExportConfig, log *zap.SugaredLogger, exportID string) error {
+	exportUUID, err := uuid.Parse(exportID)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid export UUID", exportID)
+	}
+	log = log.With("export_id", exportUUID)
+
+	dbConnection, err := db.OpenDB(*cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	exportDB := &models.ExportDB{DB: dbConnection, Cfg: cfg}
+
+	payload, err := exportDB.Get(exportUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get the export: %w", err)
+	}
+
+	producer, err := ekafka.NewProducer()
+	if err != nil {
+		return fmt.Errorf("failed to create kafka producer: %w", err)
+	}
+	// the deliveries are recorded on the sources like those of the api server
+	producer.OnDelivery = exports.RecordSourceDelivery(exportDB, log)
+	producer.OnDeadLetter = exports.RecordSourceDeadLetter(exportDB, log)
+	if cfg.KafkaConfig.SchemaRegistry.Validate {
+		validator, err := ekafka.NewSchemaValidator(context.Background(), ekafka.NewSchemaRegistry(cfg), cfg)
+		if err != nil {
+			producer.Close()
+			return fmt.Errorf("failed to get the schemas of the events: %w", err)
+		}
+		producer.Validator = validator
+	}
+
+	msgChan := make(chan *kafka.Message)
+	go producer.StartProducer(msgChan)
+	defer func() {
+		log.Info("flushing kafka producer")
+		producer.Flush(int(cfg.KafkaConfig.ProduceTimeout.Milliseconds()))
+		producer.Close()
+	}()
+
+	announce := exports.KafkaAnnounceSource(msgChan, producer.Breaker)
+	announced, err := exports.AnnouncePendingSources(context.Background(), announce, log, *payload)
+	if err != nil {
+		return err
+	}
+	log.Infow("announced the pending sources of the export again", "sources", len(announced))
+	return nil
+}
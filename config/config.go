@@ -5,10 +5,16 @@ SPDX-License-Identifier: Apache-2.0
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	clowder "github.com/redhatinsights/app-common-go/pkg/api/v1"
 	"github.com/spf13/viper"
@@ -16,6 +22,18 @@ import (
 
 const ExportTopic string = "platform.export.requests"
 
+// DLQTopic is the default topic of the messages that could not be delivered.
+const DLQTopic string = "platform.export.requests.dlq"
+
+// ResponsesTopic is the default topic the applications report their sources to.
+const ResponsesTopic string = "platform.export.responses"
+
+// StatusTopic is the default topic of the events of the transitions of the exports.
+const StatusTopic string = "platform.export.status"
+
+// NotificationsTopic is the default ingress topic of the notifications service.
+const NotificationsTopic string = "platform.notifications.ingress"
+
 // ExportConfig represents the runtime configuration
 type ExportConfig struct {
 	Hostname           string
@@ -31,7 +49,107 @@ type ExportConfig struct {
 	OpenAPIPrivatePath string
 	OpenAPIPublicPath  string
 	Psks               []string
-	ExportExpiryDays   int
+	// PskApplications scopes psks, identified by their id, to their applications
+	PskApplications map[string][]string
+	// PskExpiries are when psks, identified by their id, stop being accepted, so that
+	// the previous psk of a rotation expires on its own
+	PskExpiries      map[string]time.Time
+	ExportExpiryDays int
+	// ArchiveFormat is the format of the archives of the exports that do not request
+	// one, one of `zip`, `tar.gz` or `tar.zst`
+	ArchiveFormat string
+	// OrgStorageQuotaBytes limits the bytes each organization may keep in the bucket,
+	// 0 is unlimited
+	OrgStorageQuotaBytes int64
+	// OrgMaxExports limits the exports each organization may have that have not
+	// expired, and OrgMaxInFlightExports those that are still pending or running, 0 is
+	// unlimited
+	OrgMaxExports         int64
+	OrgMaxInFlightExports int64
+	// ExternalBaseURL is where clients reach the public api, the links of the
+	// responses are built from the X-Forwarded-* headers of the request when empty
+	ExternalBaseURL string
+	// TrustedProxies is the number of proxies in front of the public api that append
+	// the address of their client to X-Forwarded-For, the entries before theirs are
+	// sent by the client and are never trusted
+	TrustedProxies     int
+	NotificationConfig notificationConfig
+	RateLimitConfig    rateLimitConfig
+	CORSConfig         corsConfig
+	HTTPConfig         httpConfig
+	DownloadConfig     downloadConfig
+	LimitsConfig       limitsConfig
+	TracingConfig      tracingConfig
+	SentryConfig       sentryConfig
+	// SchedulerInterval is how often the due schedules are run, 0 disables the
+	// scheduler
+	SchedulerInterval time.Duration
+	// CancelledExportRetention is how long the exports deleted before they finished
+	// are remembered, so that their applications are told they were cancelled
+	CancelledExportRetention time.Duration
+	// IdempotencyKeyTTL is how long the Idempotency-Key of a created export replays it
+	IdempotencyKeyTTL time.Duration
+	// ReadinessTimeout bounds the checks of the dependencies of the readiness probe
+	ReadinessTimeout time.Duration
+	// ShutdownGracePeriod is how long the in-flight requests, the uploads and downloads
+	// among them, are waited for on shutdown before they are cut off
+	ShutdownGracePeriod time.Duration
+	// DebugPprof serves the profiles of net/http/pprof under /debug on the metrics port
+	DebugPprof bool
+	// DeletedExportRetention is how long the deleted exports can be restored before
+	// they and their objects are purged
+	DeletedExportRetention time.Duration
+	// ExpiryWarningConfig warns the owners of the exports about to be deleted
+	ExpiryWarningConfig expiryWarningConfig
+	// CleanupInterval is how often the replicas try to clean up, one of them at a time,
+	// 0 leaves the cleanups to the cleaner job
+	CleanupInterval time.Duration
+	// SourceTimeout is how long the sources may stay pending before they fail, unless
+	// the policy of their application sets its own, 0 lets them wait forever
+	SourceTimeout time.Duration
+	// SourceTimeoutInterval is how often the pending sources are checked for a timeout,
+	// 0 disables the checks
+	SourceTimeoutInterval time.Duration
+	// ApplicationPolicies restricts the sources of some applications, keyed by the
+	// name of the application
+	ApplicationPolicies map[string]ApplicationPolicy
+	// RBACConfig checks the permissions of the users of the public api with RBAC
+	RBACConfig rbacConfig
+	// InternalAuthConfig authenticates the callers of the internal api
+	InternalAuthConfig internalAuthConfig
+	// PrivateTLSConfig serves the private port with mutual TLS
+	PrivateTLSConfig privateTLSConfig
+}
+
+// ApplicationPolicy restricts the sources of an application.
+type ApplicationPolicy struct {
+	// MaxUploadBytes caps the size of the payload of each source, 0 is unlimited
+	MaxUploadBytes int64
+	// AllowedFormats are the formats the sources may be exported in, empty allows
+	// every format
+	AllowedFormats []string
+	// SourceTimeout is how long the sources may stay pending, 0 is the SourceTimeout of
+	// the service
+	SourceTimeout time.Duration
+}
+
+// ApplicationPolicy returns the policy of the application, applications without one
+// are only restricted by the limits of the service.
+func (c *ExportConfig) ApplicationPolicy(application string) ApplicationPolicy {
+	policy := c.ApplicationPolicies[application]
+	if policy.MaxUploadBytes == 0 {
+		policy.MaxUploadBytes = c.LimitsConfig.MaxUploadBytes
+	}
+	return policy
+}
+
+// ApplicationSourceTimeout returns how long the sources of the application may stay
+// pending, 0 when they never time out.
+func (c *ExportConfig) ApplicationSourceTimeout(application string) time.Duration {
+	if timeout := c.ApplicationPolicy(application).SourceTimeout; timeout > 0 {
+		return timeout
+	}
+	return c.SourceTimeout
 }
 
 type dbConfig struct {
@@ -41,6 +159,25 @@ type dbConfig struct {
 	Port     string
 	Name     string
 	SSLCfg   dbSSLConfig
+	Pool     dbPoolConfig
+	Replica  dbReplicaConfig
+}
+
+// dbReplicaConfig is the read-only replica of the database, it shares the name, the
+// credentials and the pool settings of the primary. There is no replica when its
+// Hostname is empty.
+type dbReplicaConfig struct {
+	Hostname string
+	Port     string
+}
+
+// dbPoolConfig bounds the connections of each replica to the database, so that the
+// replicas together stay below its max_connections.
+type dbPoolConfig struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime closes the connections older than it, 0 keeps them forever
+	ConnMaxLifetime time.Duration
 }
 
 type dbSSLConfig struct {
@@ -64,8 +201,91 @@ type kafkaConfig struct {
 	EventSpecVersion string
 	EventType        string
 	EventDataSchema  string
+	// CancelEventType is the type of the events telling the applications to stop
+	// working on the sources of a deleted export
+	CancelEventType string
+	// ApplicationTopics announces the requests of some applications, and their
+	// cancellations, on dedicated topics rather than the ExportsTopic, keyed by the
+	// name of the application
+	ApplicationTopics map[string]string
+	// ProduceTimeout is how long sending a message to the producer may block
+	ProduceTimeout time.Duration
+	// OutboxInterval is how often the outbox is polled for the requests to send,
+	// OutboxRetryAfter how long a sent request may go without a delivery report
+	// before it is sent again
+	OutboxInterval   time.Duration
+	OutboxRetryAfter time.Duration
+	// DLQTopic receives the messages that could not be delivered within
+	// MaxDeliveryAttempts, 0 retries them until they are delivered
+	DLQTopic            string
+	MaxDeliveryAttempts int
+	// RetryBackoff delays a message that failed before it is produced again, it is
+	// doubled with every attempt up to RetryMaxBackoff, a constant delay when it is 0
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// BreakerThreshold failed deliveries in a row open the circuit breaker, the new
+	// messages then fail fast for BreakerCooldown. 0 disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// ConsumeResponses starts a consumer of the ResponsesTopic, where the
+	// applications may report their sources instead of calling the internal api
+	ConsumeResponses bool
+	ResponsesTopic   string
+	// StatusEvents publishes an event of StatusEventType to the StatusTopic on every
+	// transition of the lifecycle of an export
+	StatusEvents    bool
+	StatusTopic     string
+	StatusEventType string
+
+	SchemaRegistry kafkaSchemaRegistryConfig
+	// MessageKey selects the key of the messages, one of `export`, `org` or `none`.
+	// The messages sharing a key are delivered to the same partition, in order.
+	MessageKey string
+	// ContentMode encodes the CloudEvents in the `structured` content mode, the whole
+	// event in the value of the messages, or in the `binary` one, the attributes in
+	// ce_* headers and the data in the value
+	ContentMode string
+
+	ProducerConfig kafkaProducerConfig
+}
+
+// RequestsTopic returns the topic the requests for the data of the sources of the
+// application are announced on.
+func (k kafkaConfig) RequestsTopic(application string) string {
+	if topic := k.ApplicationTopics[application]; topic != "" {
+		return topic
+	}
+	return k.ExportsTopic
+}
+
+// kafkaProducerConfig tunes the producer, the zero values keep the defaults of the
+// library.
+type kafkaProducerConfig struct {
+	// RequiredAcks is one of `0`, `1` or `all`
+	RequiredAcks string
+	// CompressionCodec is one of `none`, `gzip`, `snappy`, `lz4` or `zstd`
+	CompressionCodec string
+	BatchSize        int
+	// LingerMs is how long messages are batched for, a negative value keeps the default
+	LingerMs        int
+	MessageMaxBytes int
 }
 
+// kafkaSchemaRegistryConfig is the Confluent compatible schema registry the events
+// are validated against before they are published.
+type kafkaSchemaRegistryConfig struct {
+	// Validate toggles the validation, the registry is only used when it is set
+	Validate bool
+	URL      string
+	Username string
+	Password string
+	// Timeout bounds every request to the registry
+	Timeout time.Duration
+}
+
+// kafkaSSLConfig secures the connections to the brokers, it is read from the
+// KAFKA_SSL_CA, KAFKA_SASL_* and KAFKA_SECURITY_PROTOCOL variables unless clowder
+// provides the credentials of the brokers.
 type kafkaSSLConfig struct {
 	CA            string
 	Username      string
@@ -74,12 +294,336 @@ type kafkaSSLConfig struct {
 	Protocol      string
 }
 
+type notificationConfig struct {
+	// AllowedDomains are the hosts (and their subdomains) that may receive webhook notifications
+	AllowedDomains []string
+	// SigningKey is the default key used to sign notification payloads
+	SigningKey string
+	// OrgSigningKeys overrides the SigningKey for specific organizations
+	OrgSigningKeys  map[string]string
+	DownloadBaseURL string
+	MaxAttempts     int
+	InitialBackoff  time.Duration
+	Timeout         time.Duration
+}
+
+// rateLimitConfig limits the requests of each organization to the public api. The
+// limits are enforced by every pod on its own.
+type rateLimitConfig struct {
+	// ReadsPerSecond is the rate at which GET requests are allowed, 0 disables the limit
+	ReadsPerSecond float64
+	ReadBurst      int
+	// WritesPerSecond is the rate at which POST and DELETE requests are allowed, 0
+	// disables the limit
+	WritesPerSecond float64
+	WriteBurst      int
+	// CreatesPerSecond is the rate at which exports are created, on top of the
+	// writes, 0 disables the limit
+	CreatesPerSecond float64
+	CreateBurst      int
+	// DownloadsPerSecond is the rate at which the archives and the sources are
+	// downloaded, on top of the reads, 0 disables the limit
+	DownloadsPerSecond float64
+	DownloadBurst      int
+	// Key is the identity the requests are counted against, one of `org`, `account`
+	// or `user`
+	Key string
+	// Backend keeps the token buckets, `memory` in each pod or `redis` across them
+	Backend string
+	Redis   redisConfig
+}
+
+// The backends of the rate limiter.
+const (
+	RateLimitMemory = "memory"
+	RateLimitRedis  = "redis"
+)
+
+// The identities the requests are rate limited by.
+const (
+	RateLimitByOrg     = "org"
+	RateLimitByAccount = "account"
+	RateLimitByUser    = "user"
+)
+
+// validate rejects the unknown keys and backends, and a redis backend without redis.
+func (c rateLimitConfig) validate() error {
+	switch c.Key {
+	case RateLimitByOrg, RateLimitByAccount, RateLimitByUser:
+	default:
+		return fmt.Errorf("invalid RATE_LIMIT_KEY '%s', expected one of org, account or user", c.Key)
+	}
+	switch c.Backend {
+	case RateLimitMemory:
+	case RateLimitRedis:
+		if c.Redis.Addr == "" {
+			return errors.New("RATE_LIMIT_REDIS_ADDR is required with RATE_LIMIT_BACKEND=redis")
+		}
+	default:
+		return fmt.Errorf("invalid RATE_LIMIT_BACKEND '%s', expected memory or redis", c.Backend)
+	}
+	return nil
+}
+
+// redisConfig connects to the redis keeping the token buckets of the rate limiter.
+type redisConfig struct {
+	// Addr is the host:port of redis
+	Addr     string
+	Password string
+	DB       int
+	TLS      bool
+	// Timeout bounds the dial and every command
+	Timeout time.Duration
+}
+
+// corsConfig allows browsers on other origins to call the public api. CORS is
+// disabled without allowed origins.
+type corsConfig struct {
+	// AllowedOrigins are the origins, e.g. `https://example.com`, that may call the
+	// public api, `*` allows any origin
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// AllowCredentials lets the browsers send cookies and authorization headers, it
+	// can not be combined with any origin
+	AllowCredentials bool
+	// MaxAge is how long the browsers may cache the response to a preflight request
+	MaxAge time.Duration
+}
+
+// validate rejects the settings the browsers would refuse.
+func (c corsConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" && c.AllowCredentials {
+			return errors.New("CORS_ALLOWED_ORIGINS can not allow any origin with CORS_ALLOW_CREDENTIALS, list the allowed origins")
+		}
+	}
+	return nil
+}
+
+// httpConfig bounds how long the servers spend on a request. The route timeouts
+// cancel the context of the request, aborting its database, storage and kafka calls.
+type httpConfig struct {
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+	// RequestTimeout is the deadline of every route but the downloads and uploads
+	RequestTimeout time.Duration
+	// DownloadTimeout is the deadline of the routes streaming exports and uploads
+	DownloadTimeout time.Duration
+}
+
+// downloadConfig limits the downloads streamed through the service, so that a few
+// large archives can not starve the rest of the api.
+type downloadConfig struct {
+	// MaxConcurrent is the number of downloads streamed at once, 0 is unlimited
+	MaxConcurrent int
+	// QueueTimeout is how long a download waits for one of the others to finish
+	// before it is refused
+	QueueTimeout time.Duration
+	// PresignExpiry is how long the presigned urls are valid that the downloads are
+	// redirected to once the limit is reached, 0 disables the redirects
+	PresignExpiry time.Duration
+}
+
+// WriteTimeout is the deadline of the servers to write a response. It outlasts the
+// route timeouts, so that the handlers can report a timeout before the connection is
+// closed.
+func (c httpConfig) WriteTimeout() time.Duration {
+	timeout := c.RequestTimeout
+	if c.DownloadTimeout > timeout {
+		timeout = c.DownloadTimeout
+	}
+	return timeout + 5*time.Second
+}
+
+// limitsConfig bounds the size of the requests to the public api, 0 disables a limit.
+type limitsConfig struct {
+	// MaxSourcesPerExport is the number of sources an export may have
+	MaxSourcesPerExport int
+	// MaxRequestBodyBytes is the size of the body of a request
+	MaxRequestBodyBytes int64
+	// MaxFiltersBytes is the size of the json encoded filters of a source
+	MaxFiltersBytes int
+	// MaxStoredRequestBytes is the size of the normalized request kept with an export
+	MaxStoredRequestBytes int
+	// MaxExpiryDays is how far from its creation an export may request to expire
+	MaxExpiryDays int
+	// MaxUploadBytes is the size of the payload of a source, for the applications
+	// whose policy does not set one
+	MaxUploadBytes int64
+	// MaxExportBytes is the size of the payloads of all the sources of an export
+	MaxExportBytes int64
+}
+
+// tracingConfig exports the spans of the service to an OTLP collector, the tracing
+// is disabled when Endpoint is empty.
+type tracingConfig struct {
+	// Endpoint is the base url of the collector, the spans are sent to /v1/traces
+	Endpoint string
+	// Headers are sent with every export, e.g. the credentials of the collector
+	Headers map[string]string
+	Timeout time.Duration
+	// ServiceName is the service.name of the spans
+	ServiceName string
+	// SampleRatio is the share of the traces started by the service that are kept,
+	// the traces started by the callers keep their sampling decision
+	SampleRatio float64
+}
+
+// sentryConfig reports the errors of the service to a Sentry, or Glitchtip, project,
+// the reporting is disabled without a DSN.
+type sentryConfig struct {
+	DSN string
+	// Environment tells the errors of the deployments apart
+	Environment string
+	// SampleRate is the share of the errors that are reported
+	SampleRate float64
+}
+
+// rbacConfig checks the export-service permissions of the users of the public api
+// with insights-rbac, the api is open to every user of the org when it is disabled.
+type rbacConfig struct {
+	Enabled bool
+	// URL is the base url of the rbac service, without its /api/rbac/v1 path
+	URL string
+	// CacheTTL is how long the permissions of a user are cached
+	CacheTTL time.Duration
+	Timeout  time.Duration
+}
+
+// internalAuthConfig authenticates the callers of the internal api with the psks, with
+// the signed JWTs of their service accounts, or with both while they move to the JWTs.
+type internalAuthConfig struct {
+	PSKEnabled bool
+	JWTEnabled bool
+	// JWTIssuer is the `iss` of the tokens, JWTAudience one of their `aud`
+	JWTIssuer   string
+	JWTAudience string
+	// JWTKeysetURL serves the JSON Web Key Set of the issuer, it is fetched again every
+	// JWTKeysetRefresh
+	JWTKeysetURL     string
+	JWTKeysetRefresh time.Duration
+	// JWTClientApplications scopes the clients of the tokens, by client id, to their
+	// applications
+	JWTClientApplications map[string][]string
+}
+
+// validate rejects the configurations that would lock every caller out of the internal
+// api, or accept tokens from any issuer or for any audience.
+func (c internalAuthConfig) validate() error {
+	if !c.PSKEnabled && !c.JWTEnabled {
+		return errors.New("invalid internal auth: INTERNAL_AUTH_PSK or INTERNAL_AUTH_JWT must be enabled")
+	}
+	if c.JWTEnabled && (c.JWTIssuer == "" || c.JWTAudience == "" || c.JWTKeysetURL == "") {
+		return errors.New("invalid internal auth: INTERNAL_AUTH_JWT requires JWT_ISSUER, JWT_AUDIENCE and JWT_KEYSET_URL")
+	}
+	return nil
+}
+
+// privateTLSConfig serves the private port with mutual TLS from the mounted files of
+// the certificates, which are reloaded once they change. The port is served in plain
+// text without them.
+type privateTLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CA the certificates of the callers must be signed by
+	ClientCAFile string
+	// ReloadInterval is how often the files are checked for a change
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether the private port is served with mutual TLS.
+func (c privateTLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientCAFile != ""
+}
+
+// validate rejects the partial configurations, the port is never served with TLS
+// but without the verification of the clients.
+func (c privateTLSConfig) validate() error {
+	if c.Enabled() && (c.CertFile == "" || c.KeyFile == "" || c.ClientCAFile == "") {
+		return errors.New("invalid private TLS: PRIVATE_TLS_CERT_FILE, PRIVATE_TLS_KEY_FILE and PRIVATE_TLS_CLIENT_CA_FILE must be set together")
+	}
+	return nil
+}
+
+// expiryWarningConfig warns the owners of the exports about to be deleted through the
+// notifications service, once per export. The warnings are disabled when Window is 0.
+type expiryWarningConfig struct {
+	// Window is how long before their deletion the exports are warned about
+	Window time.Duration
+	// Topic is the ingress topic of the notifications service, EventType the type of
+	// the events registered with it
+	Topic     string
+	EventType string
+}
+
 type storageConfig struct {
+	// Provider selects the storage implementation, one of `minio`, `aws`, `gcs` or
+	// `azure`. The `aws` provider ignores the endpoint and static keys and resolves
+	// credentials through the default AWS credential chain. The `gcs` provider uses
+	// the static keys as the HMAC keys of a gcs service account, it only supports
+	// HMAC keys and not service account keys or workload identity. The `azure` provider
+	// ignores the S3 settings and uses the blob storage configured in Azure. The
+	// `filesystem` provider keeps the objects below FilesystemRoot, for development.
+	Provider  string
+	Region    string
 	Bucket    string
 	Endpoint  string
 	AccessKey string
 	SecretKey string
 	UseSSL    bool
+	// SSEType is the server-side encryption applied to every object, one of
+	// `SSE-S3` or `SSE-KMS`. Objects are not encrypted by the service when empty.
+	SSEType string
+	// KMSKeyID is the ARN of the key used when SSEType is `SSE-KMS`
+	KMSKeyID string
+	// AmbientCredentials accesses the bucket with the credentials of the pod, e.g. an
+	// IRSA role, rather than the static keys. It is set for the clowder buckets
+	// provisioned without keys.
+	AmbientCredentials bool
+	// Bootstrap creates the bucket and applies its lifecycle configuration on startup
+	Bootstrap bool
+	// SourceObjectGraceDays is how much longer than the archives the raw per-source
+	// objects are kept by the lifecycle configuration
+	SourceObjectGraceDays int
+	// KeepSourceObjects keeps the raw per-source objects in the bucket after the export
+	// has been packaged
+	KeepSourceObjects bool
+	// ConnectTimeout limits establishing a connection to the storage endpoint
+	ConnectTimeout time.Duration
+	// RequestTimeout limits waiting for the response of a single storage request
+	RequestTimeout time.Duration
+	// OperationTimeout limits a whole upload, download or stat, including retries
+	OperationTimeout time.Duration
+	// MaxRetries is the number of times a failed storage request is retried
+	MaxRetries int
+	// RetryMaxBackoff caps the exponential backoff between retries
+	RetryMaxBackoff time.Duration
+	// Azure is the blob storage used by the `azure` provider
+	Azure azureStorageConfig
+	// FilesystemRoot is the directory of the `filesystem` provider
+	FilesystemRoot string
+	// MaxUploadPartBytes is the size of a part of a multipart upload, the parts are
+	// held in memory so that they can be retried
+	MaxUploadPartBytes int64
+	// PresignDownloads redirects every download to a presigned url of the object
+	// valid for PresignTTL, so that the bytes are not proxied through the service
+	PresignDownloads bool
+	PresignTTL       time.Duration
+	// OrphanMinAge is how old the orphaned objects are before they are collected, so
+	// that the uploads in flight are not mistaken for orphans
+	OrphanMinAge time.Duration
+}
+
+type azureStorageConfig struct {
+	// Account is the name of the storage account
+	Account string
+	// AccountKey is the base64 encoded shared key of the storage account
+	AccountKey string
+	// Container holds the objects, the bucket of the other providers
+	Container string
+	// Endpoint is the blob endpoint, https://<account>.blob.core.windows.net when empty
+	Endpoint string
 }
 
 var config *ExportConfig
@@ -94,10 +638,44 @@ func Get() *ExportConfig {
 		options.SetDefault("PRIVATE_PORT", 10000)
 		options.SetDefault("LOG_LEVEL", "INFO")
 		options.SetDefault("DEBUG", false)
-		options.SetDefault("OPEN_API_FILE_PATH", "./static/spec/openapi.json")
-		options.SetDefault("OPEN_API_PRIVATE_PATH", "./static/spec/private.json")
+		options.SetDefault("DEBUG_PPROF", false)
+		// the specs are generated from the routes unless an override file is set
+		options.SetDefault("OPEN_API_FILE_PATH", "")
+		options.SetDefault("OPEN_API_PRIVATE_PATH", "")
 		options.SetDefault("PSKS", strings.Split(os.Getenv("EXPORTS_PSKS"), ","))
 		options.SetDefault("EXPORT_EXPIRY_DAYS", 7)
+		options.SetDefault("ARCHIVE_FORMAT", "tar.gz")
+		options.SetDefault("ORG_STORAGE_QUOTA_BYTES", 0)
+		options.SetDefault("ORG_MAX_EXPORTS", 0)
+		options.SetDefault("ORG_MAX_IN_FLIGHT_EXPORTS", 0)
+		options.SetDefault("EXTERNAL_BASE_URL", "")
+		options.SetDefault("TRUSTED_PROXIES", 1)
+		options.SetDefault("SCHEDULER_INTERVAL", "1m")
+		options.SetDefault("CANCELLED_EXPORT_RETENTION", "24h")
+		options.SetDefault("IDEMPOTENCY_KEY_TTL", "24h")
+		options.SetDefault("READINESS_TIMEOUT", "2s")
+		options.SetDefault("SHUTDOWN_GRACE_PERIOD", "25s")
+		options.SetDefault("DELETED_EXPORT_RETENTION", "168h")
+		options.SetDefault("EXPIRY_WARNING_WINDOW", "48h")
+		options.SetDefault("CLEANUP_INTERVAL", "0")
+		options.SetDefault("SOURCE_TIMEOUT", "24h")
+		options.SetDefault("SOURCE_TIMEOUT_INTERVAL", "1m")
+		options.SetDefault("KAFKA_NOTIFICATIONS_TOPIC", NotificationsTopic)
+		options.SetDefault("KAFKA_EXPIRY_WARNING_EVENT_TYPE", "com.redhat.console.export-service.export-expiring")
+		options.SetDefault("RBAC_ENABLED", false)
+		options.SetDefault("RBAC_URL", "http://localhost:8111")
+		options.SetDefault("RBAC_CACHE_TTL", "1m")
+		options.SetDefault("RBAC_TIMEOUT", "5s")
+		options.SetDefault("INTERNAL_AUTH_PSK", true)
+		options.SetDefault("INTERNAL_AUTH_JWT", false)
+		options.SetDefault("JWT_ISSUER", "")
+		options.SetDefault("JWT_AUDIENCE", "")
+		options.SetDefault("JWT_KEYSET_URL", "")
+		options.SetDefault("JWT_KEYSET_REFRESH", "1h")
+		options.SetDefault("PRIVATE_TLS_CERT_FILE", "")
+		options.SetDefault("PRIVATE_TLS_KEY_FILE", "")
+		options.SetDefault("PRIVATE_TLS_CLIENT_CA_FILE", "")
+		options.SetDefault("PRIVATE_TLS_RELOAD_INTERVAL", "1m")
 
 		// DB defaults
 		options.SetDefault("PGSQL_USER", "postgres")
@@ -105,19 +683,136 @@ func Get() *ExportConfig {
 		options.SetDefault("PGSQL_HOSTNAME", "localhost")
 		options.SetDefault("PGSQL_PORT", "15433")
 		options.SetDefault("PGSQL_DATABASE", "postgres")
+		options.SetDefault("PGSQL_MAX_OPEN_CONNS", 20)
+		options.SetDefault("PGSQL_MAX_IDLE_CONNS", 10)
+		options.SetDefault("PGSQL_CONN_MAX_LIFETIME", "30m")
+		options.SetDefault("PGSQL_REPLICA_HOSTNAME", "")
+		options.SetDefault("PGSQL_REPLICA_PORT", "")
 
 		// Minio defaults
 		options.SetDefault("MINIO_HOST", "localhost")
 		options.SetDefault("MINIO_PORT", "9099")
 		options.SetDefault("MINIO_SSL", false)
+		options.SetDefault("STORAGE_PROVIDER", "minio")
+		options.SetDefault("STORAGE_REGION", "us-east-1")
+		options.SetDefault("STORAGE_BOOTSTRAP", false)
+		options.SetDefault("STORAGE_SOURCE_OBJECT_GRACE_DAYS", 1)
+		options.SetDefault("KEEP_SOURCE_OBJECTS", false)
+		options.SetDefault("STORAGE_ORPHAN_MIN_AGE", "24h")
+		options.SetDefault("STORAGE_CONNECT_TIMEOUT", "5s")
+		options.SetDefault("STORAGE_REQUEST_TIMEOUT", "30s")
+		options.SetDefault("STORAGE_OPERATION_TIMEOUT", "10m")
+		options.SetDefault("STORAGE_MAX_RETRIES", 3)
+		options.SetDefault("STORAGE_RETRY_MAX_BACKOFF", "5s")
+		options.SetDefault("AZURE_STORAGE_CONTAINER", "exports")
+		options.SetDefault("STORAGE_FILESYSTEM_ROOT", filepath.Join(os.TempDir(), "export-service"))
+		options.SetDefault("UPLOAD_MAX_PART_BYTES", 64*1024*1024)
+		options.SetDefault("STORAGE_PRESIGN_DOWNLOADS", false)
+		options.SetDefault("STORAGE_PRESIGN_TTL", "5m")
+
+		// Notification defaults
+		options.SetDefault("NOTIFICATION_ALLOWED_DOMAINS", strings.Split(os.Getenv("NOTIFICATION_ALLOWED_DOMAINS"), ","))
+		options.SetDefault("NOTIFICATION_DOWNLOAD_BASE_URL", "https://console.redhat.com")
+		options.SetDefault("NOTIFICATION_MAX_ATTEMPTS", 5)
+		options.SetDefault("NOTIFICATION_INITIAL_BACKOFF", "1s")
+		options.SetDefault("NOTIFICATION_TIMEOUT", "10s")
+
+		// Rate limit defaults
+		options.SetDefault("RATE_LIMIT_READS_PER_SECOND", 20)
+		options.SetDefault("RATE_LIMIT_READ_BURST", 100)
+		options.SetDefault("RATE_LIMIT_WRITES_PER_SECOND", 1)
+		options.SetDefault("RATE_LIMIT_WRITE_BURST", 20)
+		options.SetDefault("RATE_LIMIT_CREATES_PER_SECOND", 0.2)
+		options.SetDefault("RATE_LIMIT_CREATE_BURST", 10)
+		options.SetDefault("RATE_LIMIT_DOWNLOADS_PER_SECOND", 2)
+		options.SetDefault("RATE_LIMIT_DOWNLOAD_BURST", 20)
+		options.SetDefault("RATE_LIMIT_KEY", RateLimitByOrg)
+		options.SetDefault("RATE_LIMIT_BACKEND", RateLimitMemory)
+		options.SetDefault("RATE_LIMIT_REDIS_ADDR", "")
+		options.SetDefault("RATE_LIMIT_REDIS_PASSWORD", "")
+		options.SetDefault("RATE_LIMIT_REDIS_DB", 0)
+		options.SetDefault("RATE_LIMIT_REDIS_TLS", false)
+		options.SetDefault("RATE_LIMIT_REDIS_TIMEOUT", "500ms")
+
+		// CORS defaults
+		options.SetDefault("CORS_ALLOWED_ORIGINS", "")
+		options.SetDefault("CORS_ALLOWED_METHODS", "GET,POST,DELETE")
+		options.SetDefault("CORS_ALLOWED_HEADERS", "Content-Type,If-None-Match,X-Rh-Identity")
+		options.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+		options.SetDefault("CORS_MAX_AGE", "10m")
+
+		// HTTP defaults
+		options.SetDefault("HTTP_READ_TIMEOUT", "5s")
+		options.SetDefault("HTTP_IDLE_TIMEOUT", "120s")
+		options.SetDefault("HTTP_REQUEST_TIMEOUT", "10s")
+		options.SetDefault("HTTP_DOWNLOAD_TIMEOUT", "5m")
+
+		// Download defaults
+		options.SetDefault("MAX_CONCURRENT_DOWNLOADS", 0)
+		options.SetDefault("DOWNLOAD_QUEUE_TIMEOUT", "2s")
+		options.SetDefault("DOWNLOAD_PRESIGN_EXPIRY", "0s")
+
+		// Limits defaults
+		options.SetDefault("MAX_SOURCES_PER_EXPORT", 100)
+		options.SetDefault("MAX_REQUEST_BODY_BYTES", 1024*1024)
+		options.SetDefault("MAX_FILTERS_BYTES", 16*1024)
+		options.SetDefault("MAX_STORED_REQUEST_BYTES", 64*1024)
+		options.SetDefault("MAX_EXPIRY_DAYS", 90)
+		options.SetDefault("MAX_UPLOAD_BYTES", 10*1024*1024*1024)
+		options.SetDefault("MAX_EXPORT_BYTES", 50*1024*1024*1024)
+
+		// the standard variables of the OpenTelemetry SDKs, the timeout is in milliseconds
+		options.SetDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+		options.SetDefault("OTEL_EXPORTER_OTLP_HEADERS", "")
+		options.SetDefault("OTEL_EXPORTER_OTLP_TIMEOUT", 10000)
+		options.SetDefault("OTEL_SERVICE_NAME", "export-service")
+		options.SetDefault("OTEL_TRACES_SAMPLER_ARG", 1.0)
+
+		// the errors are only reported with a DSN
+		options.SetDefault("SENTRY_DSN", "")
+		options.SetDefault("SENTRY_ENVIRONMENT", "")
+		options.SetDefault("SENTRY_SAMPLE_RATE", 1.0)
 
 		// Kafka defaults
+		options.SetDefault("KAFKA_PRODUCE_TIMEOUT", "10s")
+		options.SetDefault("KAFKA_OUTBOX_INTERVAL", "5s")
+		options.SetDefault("KAFKA_OUTBOX_RETRY_AFTER", "1m")
 		options.SetDefault("KAFKA_ANNOUNCE_TOPIC", ExportTopic)
+		options.SetDefault("KAFKA_APPLICATION_TOPICS", "")
+		options.SetDefault("KAFKA_DLQ_TOPIC", DLQTopic)
+		options.SetDefault("KAFKA_MAX_DELIVERY_ATTEMPTS", 5)
+		options.SetDefault("KAFKA_RETRY_BACKOFF", "100ms")
+		options.SetDefault("KAFKA_RETRY_MAX_BACKOFF", "10s")
+		options.SetDefault("KAFKA_BREAKER_THRESHOLD", 10)
+		options.SetDefault("KAFKA_BREAKER_COOLDOWN", "30s")
+		options.SetDefault("KAFKA_CONSUME_RESPONSES", false)
+		options.SetDefault("KAFKA_RESPONSES_TOPIC", ResponsesTopic)
+		options.SetDefault("KAFKA_STATUS_EVENTS", false)
+		options.SetDefault("KAFKA_STATUS_TOPIC", StatusTopic)
+		options.SetDefault("KAFKA_SCHEMA_VALIDATION", false)
+		options.SetDefault("KAFKA_SCHEMA_REGISTRY_URL", "http://localhost:8081")
+		options.SetDefault("KAFKA_SCHEMA_REGISTRY_USERNAME", "")
+		options.SetDefault("KAFKA_SCHEMA_REGISTRY_PASSWORD", "")
+		options.SetDefault("KAFKA_SCHEMA_REGISTRY_TIMEOUT", "10s")
 		options.SetDefault("KAFKA_BROKERS", strings.Split(os.Getenv("KAFKA_BROKERS"), ","))
 		options.SetDefault("KAFKA_GROUP_ID", "export")
+		options.SetDefault("KAFKA_SECURITY_PROTOCOL", "")
+		options.SetDefault("KAFKA_SASL_MECHANISM", "")
+		options.SetDefault("KAFKA_SASL_USERNAME", "")
+		options.SetDefault("KAFKA_SASL_PASSWORD", "")
+		options.SetDefault("KAFKA_SSL_CA", "")
 		options.SetDefault("KAFKA_EVENT_SOURCE", "urn:redhat:source:export-service")
 		options.SetDefault("KAFKA_EVENT_SPECVERSION", "1.0")
 		options.SetDefault("KAFKA_EVENT_TYPE", "com.redhat.console.export-service.request")
+		options.SetDefault("KAFKA_CANCEL_EVENT_TYPE", "com.redhat.console.export-service.cancel")
+		options.SetDefault("KAFKA_STATUS_EVENT_TYPE", "com.redhat.console.export-service.status")
+		options.SetDefault("KAFKA_MESSAGE_KEY", "export")
+		options.SetDefault("KAFKA_CONTENT_MODE", "structured")
+		options.SetDefault("KAFKA_REQUIRED_ACKS", "")
+		options.SetDefault("KAFKA_COMPRESSION_CODEC", "")
+		options.SetDefault("KAFKA_BATCH_SIZE", 0)
+		options.SetDefault("KAFKA_LINGER_MS", -1)
+		options.SetDefault("KAFKA_MESSAGE_MAX_BYTES", 0)
 		options.SetDefault("KAFKA_EVENT_DATASCHEMA", "https://github.com/RedHatInsights/event-schemas/blob/main/schemas/apps/export-service/v1/export-request.json")
 
 		options.AutomaticEnv()
@@ -126,16 +821,45 @@ func Get() *ExportConfig {
 		kubenv.AutomaticEnv()
 
 		config = &ExportConfig{
-			Hostname:           kubenv.GetString("Hostname"),
-			PublicPort:         options.GetInt("PUBLIC_PORT"),
-			MetricsPort:        options.GetInt("METRICS_PORT"),
-			PrivatePort:        options.GetInt("PRIVATE_PORT"),
-			Debug:              options.GetBool("DEBUG"),
-			LogLevel:           options.GetString("LOG_LEVEL"),
-			OpenAPIPublicPath:  options.GetString("OPEN_API_FILE_PATH"),
-			OpenAPIPrivatePath: options.GetString("OPEN_API_PRIVATE_PATH"),
-			Psks:               options.GetStringSlice("PSKS"),
-			ExportExpiryDays:   options.GetInt("EXPORT_EXPIRY_DAYS"),
+			Hostname:              kubenv.GetString("Hostname"),
+			PublicPort:            options.GetInt("PUBLIC_PORT"),
+			MetricsPort:           options.GetInt("METRICS_PORT"),
+			PrivatePort:           options.GetInt("PRIVATE_PORT"),
+			Debug:                 options.GetBool("DEBUG"),
+			LogLevel:              options.GetString("LOG_LEVEL"),
+			OpenAPIPublicPath:     options.GetString("OPEN_API_FILE_PATH"),
+			OpenAPIPrivatePath:    options.GetString("OPEN_API_PRIVATE_PATH"),
+			Psks:                  options.GetStringSlice("PSKS"),
+			PskApplications:       parsePskApplications(options.GetString("PSK_APPLICATIONS")),
+			ExportExpiryDays:      options.GetInt("EXPORT_EXPIRY_DAYS"),
+			ArchiveFormat:         options.GetString("ARCHIVE_FORMAT"),
+			OrgStorageQuotaBytes:  options.GetInt64("ORG_STORAGE_QUOTA_BYTES"),
+			OrgMaxExports:         options.GetInt64("ORG_MAX_EXPORTS"),
+			OrgMaxInFlightExports: options.GetInt64("ORG_MAX_IN_FLIGHT_EXPORTS"),
+			ExternalBaseURL:       strings.TrimSuffix(options.GetString("EXTERNAL_BASE_URL"), "/"),
+			TrustedProxies:        options.GetInt("TRUSTED_PROXIES"),
+			SchedulerInterval:     options.GetDuration("SCHEDULER_INTERVAL"),
+
+			CancelledExportRetention: options.GetDuration("CANCELLED_EXPORT_RETENTION"),
+			IdempotencyKeyTTL:        options.GetDuration("IDEMPOTENCY_KEY_TTL"),
+			ReadinessTimeout:         options.GetDuration("READINESS_TIMEOUT"),
+			ShutdownGracePeriod:      options.GetDuration("SHUTDOWN_GRACE_PERIOD"),
+			DebugPprof:               options.GetBool("DEBUG_PPROF"),
+			DeletedExportRetention:   options.GetDuration("DELETED_EXPORT_RETENTION"),
+			CleanupInterval:          options.GetDuration("CLEANUP_INTERVAL"),
+			SourceTimeout:            options.GetDuration("SOURCE_TIMEOUT"),
+			SourceTimeoutInterval:    options.GetDuration("SOURCE_TIMEOUT_INTERVAL"),
+		}
+
+		policies, err := parseApplicationPolicies(options.GetString("APPLICATION_MAX_UPLOAD_BYTES"), options.GetString("APPLICATION_ALLOWED_FORMATS"), options.GetString("APPLICATION_SOURCE_TIMEOUTS"))
+		if err != nil {
+			panic(err.Error())
+		}
+		config.ApplicationPolicies = policies
+
+		config.PskExpiries, err = parsePskExpiries(options.GetString("PSK_EXPIRIES"))
+		if err != nil {
+			panic(err.Error())
 		}
 
 		config.DBConfig = dbConfig{
@@ -147,24 +871,199 @@ func Get() *ExportConfig {
 			SSLCfg: dbSSLConfig{
 				SSLMode: "disable",
 			},
+			Pool:    dbPoolConfigFrom(options),
+			Replica: dbReplicaConfigFrom(options, options.GetString("PGSQL_PORT")),
 		}
 
 		config.StorageConfig = storageConfig{
+			Provider:  options.GetString("STORAGE_PROVIDER"),
+			Region:    options.GetString("STORAGE_REGION"),
 			Bucket:    "exports-bucket",
 			Endpoint:  buildBaseHttpUrl(options.GetBool("MINIO_SSL"), options.GetString("MINIO_HOST"), options.GetInt("MINIO_PORT")),
 			AccessKey: options.GetString("AWS_ACCESS_KEY"),
 			SecretKey: options.GetString("AWS_SECRET_ACCESS_KEY"),
 			UseSSL:    options.GetBool("MINIO_SSL"),
+			SSEType:   options.GetString("STORAGE_SSE_TYPE"),
+			KMSKeyID:  options.GetString("STORAGE_KMS_KEY_ID"),
+
+			Bootstrap:             options.GetBool("STORAGE_BOOTSTRAP"),
+			SourceObjectGraceDays: options.GetInt("STORAGE_SOURCE_OBJECT_GRACE_DAYS"),
+			KeepSourceObjects:     options.GetBool("KEEP_SOURCE_OBJECTS"),
+			ConnectTimeout:        options.GetDuration("STORAGE_CONNECT_TIMEOUT"),
+			RequestTimeout:        options.GetDuration("STORAGE_REQUEST_TIMEOUT"),
+			OperationTimeout:      options.GetDuration("STORAGE_OPERATION_TIMEOUT"),
+			MaxRetries:            options.GetInt("STORAGE_MAX_RETRIES"),
+			RetryMaxBackoff:       options.GetDuration("STORAGE_RETRY_MAX_BACKOFF"),
+			Azure:                 azureStorageConfigFrom(options),
+			FilesystemRoot:        options.GetString("STORAGE_FILESYSTEM_ROOT"),
+			MaxUploadPartBytes:    options.GetInt64("UPLOAD_MAX_PART_BYTES"),
+			PresignDownloads:      options.GetBool("STORAGE_PRESIGN_DOWNLOADS"),
+			PresignTTL:            options.GetDuration("STORAGE_PRESIGN_TTL"),
+			OrphanMinAge:          options.GetDuration("STORAGE_ORPHAN_MIN_AGE"),
+		}
+
+		config.NotificationConfig = notificationConfig{
+			AllowedDomains:  options.GetStringSlice("NOTIFICATION_ALLOWED_DOMAINS"),
+			SigningKey:      options.GetString("NOTIFICATION_SIGNING_KEY"),
+			OrgSigningKeys:  parseKeyValuePairs(options.GetString("NOTIFICATION_ORG_SIGNING_KEYS")),
+			DownloadBaseURL: options.GetString("NOTIFICATION_DOWNLOAD_BASE_URL"),
+			MaxAttempts:     options.GetInt("NOTIFICATION_MAX_ATTEMPTS"),
+			InitialBackoff:  options.GetDuration("NOTIFICATION_INITIAL_BACKOFF"),
+			Timeout:         options.GetDuration("NOTIFICATION_TIMEOUT"),
+		}
+
+		config.RateLimitConfig = rateLimitConfig{
+			ReadsPerSecond:  options.GetFloat64("RATE_LIMIT_READS_PER_SECOND"),
+			ReadBurst:       options.GetInt("RATE_LIMIT_READ_BURST"),
+			WritesPerSecond: options.GetFloat64("RATE_LIMIT_WRITES_PER_SECOND"),
+			WriteBurst:      options.GetInt("RATE_LIMIT_WRITE_BURST"),
+
+			CreatesPerSecond:   options.GetFloat64("RATE_LIMIT_CREATES_PER_SECOND"),
+			CreateBurst:        options.GetInt("RATE_LIMIT_CREATE_BURST"),
+			DownloadsPerSecond: options.GetFloat64("RATE_LIMIT_DOWNLOADS_PER_SECOND"),
+			DownloadBurst:      options.GetInt("RATE_LIMIT_DOWNLOAD_BURST"),
+			Key:                options.GetString("RATE_LIMIT_KEY"),
+			Backend:            options.GetString("RATE_LIMIT_BACKEND"),
+			Redis: redisConfig{
+				Addr:     options.GetString("RATE_LIMIT_REDIS_ADDR"),
+				Password: options.GetString("RATE_LIMIT_REDIS_PASSWORD"),
+				DB:       options.GetInt("RATE_LIMIT_REDIS_DB"),
+				TLS:      options.GetBool("RATE_LIMIT_REDIS_TLS"),
+				Timeout:  options.GetDuration("RATE_LIMIT_REDIS_TIMEOUT"),
+			},
+		}
+
+		config.CORSConfig = corsConfig{
+			AllowedOrigins:   splitList(options.GetString("CORS_ALLOWED_ORIGINS")),
+			AllowedMethods:   splitList(options.GetString("CORS_ALLOWED_METHODS")),
+			AllowedHeaders:   splitList(options.GetString("CORS_ALLOWED_HEADERS")),
+			AllowCredentials: options.GetBool("CORS_ALLOW_CREDENTIALS"),
+			MaxAge:           options.GetDuration("CORS_MAX_AGE"),
+		}
+		if err := config.CORSConfig.validate(); err != nil {
+			panic(err.Error())
 		}
 
 		config.KafkaConfig = kafkaConfig{
-			Brokers:          options.GetStringSlice("KAFKA_BROKERS"),
-			GroupID:          options.GetString("KAFKA_GROUP_ID"),
-			ExportsTopic:     options.GetString("KAFKA_ANNOUNCE_TOPIC"),
-			EventSource:      options.GetString("KAFKA_EVENT_SOURCE"),
-			EventSpecVersion: options.GetString("KAFKA_EVENT_SPECVERSION"),
-			EventType:        options.GetString("KAFKA_EVENT_TYPE"),
-			EventDataSchema:  options.GetString("KAFKA_EVENT_DATASCHEMA"),
+			Brokers:             options.GetStringSlice("KAFKA_BROKERS"),
+			GroupID:             options.GetString("KAFKA_GROUP_ID"),
+			ExportsTopic:        options.GetString("KAFKA_ANNOUNCE_TOPIC"),
+			EventSource:         options.GetString("KAFKA_EVENT_SOURCE"),
+			EventSpecVersion:    options.GetString("KAFKA_EVENT_SPECVERSION"),
+			EventType:           options.GetString("KAFKA_EVENT_TYPE"),
+			EventDataSchema:     options.GetString("KAFKA_EVENT_DATASCHEMA"),
+			CancelEventType:     options.GetString("KAFKA_CANCEL_EVENT_TYPE"),
+			ProduceTimeout:      options.GetDuration("KAFKA_PRODUCE_TIMEOUT"),
+			OutboxInterval:      options.GetDuration("KAFKA_OUTBOX_INTERVAL"),
+			OutboxRetryAfter:    options.GetDuration("KAFKA_OUTBOX_RETRY_AFTER"),
+			DLQTopic:            options.GetString("KAFKA_DLQ_TOPIC"),
+			MaxDeliveryAttempts: options.GetInt("KAFKA_MAX_DELIVERY_ATTEMPTS"),
+			RetryBackoff:        options.GetDuration("KAFKA_RETRY_BACKOFF"),
+			RetryMaxBackoff:     options.GetDuration("KAFKA_RETRY_MAX_BACKOFF"),
+			BreakerThreshold:    options.GetInt("KAFKA_BREAKER_THRESHOLD"),
+			BreakerCooldown:     options.GetDuration("KAFKA_BREAKER_COOLDOWN"),
+			ConsumeResponses:    options.GetBool("KAFKA_CONSUME_RESPONSES"),
+			ResponsesTopic:      options.GetString("KAFKA_RESPONSES_TOPIC"),
+			StatusEvents:        options.GetBool("KAFKA_STATUS_EVENTS"),
+			StatusTopic:         options.GetString("KAFKA_STATUS_TOPIC"),
+			StatusEventType:     options.GetString("KAFKA_STATUS_EVENT_TYPE"),
+			ApplicationTopics:   parseKeyValuePairs(options.GetString("KAFKA_APPLICATION_TOPICS")),
+			SSLConfig: kafkaSSLConfig{
+				CA:            options.GetString("KAFKA_SSL_CA"),
+				Username:      options.GetString("KAFKA_SASL_USERNAME"),
+				Password:      options.GetString("KAFKA_SASL_PASSWORD"),
+				SASLMechanism: options.GetString("KAFKA_SASL_MECHANISM"),
+				Protocol:      options.GetString("KAFKA_SECURITY_PROTOCOL"),
+			},
+			SchemaRegistry: kafkaSchemaRegistryConfig{
+				Validate: options.GetBool("KAFKA_SCHEMA_VALIDATION"),
+				URL:      options.GetString("KAFKA_SCHEMA_REGISTRY_URL"),
+				Username: options.GetString("KAFKA_SCHEMA_REGISTRY_USERNAME"),
+				Password: options.GetString("KAFKA_SCHEMA_REGISTRY_PASSWORD"),
+				Timeout:  options.GetDuration("KAFKA_SCHEMA_REGISTRY_TIMEOUT"),
+			},
+			MessageKey:  options.GetString("KAFKA_MESSAGE_KEY"),
+			ContentMode: options.GetString("KAFKA_CONTENT_MODE"),
+			ProducerConfig: kafkaProducerConfig{
+				RequiredAcks:     options.GetString("KAFKA_REQUIRED_ACKS"),
+				CompressionCodec: options.GetString("KAFKA_COMPRESSION_CODEC"),
+				BatchSize:        options.GetInt("KAFKA_BATCH_SIZE"),
+				LingerMs:         options.GetInt("KAFKA_LINGER_MS"),
+				MessageMaxBytes:  options.GetInt("KAFKA_MESSAGE_MAX_BYTES"),
+			},
+		}
+
+		config.HTTPConfig = httpConfig{
+			ReadTimeout:     options.GetDuration("HTTP_READ_TIMEOUT"),
+			IdleTimeout:     options.GetDuration("HTTP_IDLE_TIMEOUT"),
+			RequestTimeout:  options.GetDuration("HTTP_REQUEST_TIMEOUT"),
+			DownloadTimeout: options.GetDuration("HTTP_DOWNLOAD_TIMEOUT"),
+		}
+
+		config.DownloadConfig = downloadConfig{
+			MaxConcurrent: options.GetInt("MAX_CONCURRENT_DOWNLOADS"),
+			QueueTimeout:  options.GetDuration("DOWNLOAD_QUEUE_TIMEOUT"),
+			PresignExpiry: options.GetDuration("DOWNLOAD_PRESIGN_EXPIRY"),
+		}
+
+		config.LimitsConfig = limitsConfig{
+			MaxSourcesPerExport:   options.GetInt("MAX_SOURCES_PER_EXPORT"),
+			MaxRequestBodyBytes:   options.GetInt64("MAX_REQUEST_BODY_BYTES"),
+			MaxFiltersBytes:       options.GetInt("MAX_FILTERS_BYTES"),
+			MaxStoredRequestBytes: options.GetInt("MAX_STORED_REQUEST_BYTES"),
+			MaxExpiryDays:         options.GetInt("MAX_EXPIRY_DAYS"),
+			MaxUploadBytes:        options.GetInt64("MAX_UPLOAD_BYTES"),
+			MaxExportBytes:        options.GetInt64("MAX_EXPORT_BYTES"),
+		}
+
+		config.TracingConfig = tracingConfig{
+			Endpoint:    strings.TrimSuffix(options.GetString("OTEL_EXPORTER_OTLP_ENDPOINT"), "/"),
+			Headers:     parseHeaders(options.GetString("OTEL_EXPORTER_OTLP_HEADERS")),
+			Timeout:     time.Duration(options.GetInt("OTEL_EXPORTER_OTLP_TIMEOUT")) * time.Millisecond,
+			ServiceName: options.GetString("OTEL_SERVICE_NAME"),
+			SampleRatio: options.GetFloat64("OTEL_TRACES_SAMPLER_ARG"),
+		}
+
+		config.ExpiryWarningConfig = expiryWarningConfig{
+			Window:    options.GetDuration("EXPIRY_WARNING_WINDOW"),
+			Topic:     options.GetString("KAFKA_NOTIFICATIONS_TOPIC"),
+			EventType: options.GetString("KAFKA_EXPIRY_WARNING_EVENT_TYPE"),
+		}
+
+		config.RBACConfig = rbacConfig{
+			Enabled:  options.GetBool("RBAC_ENABLED"),
+			URL:      strings.TrimSuffix(options.GetString("RBAC_URL"), "/"),
+			CacheTTL: options.GetDuration("RBAC_CACHE_TTL"),
+			Timeout:  options.GetDuration("RBAC_TIMEOUT"),
+		}
+
+		config.InternalAuthConfig = internalAuthConfig{
+			PSKEnabled:            options.GetBool("INTERNAL_AUTH_PSK"),
+			JWTEnabled:            options.GetBool("INTERNAL_AUTH_JWT"),
+			JWTIssuer:             options.GetString("JWT_ISSUER"),
+			JWTAudience:           options.GetString("JWT_AUDIENCE"),
+			JWTKeysetURL:          options.GetString("JWT_KEYSET_URL"),
+			JWTKeysetRefresh:      options.GetDuration("JWT_KEYSET_REFRESH"),
+			JWTClientApplications: parsePskApplications(options.GetString("JWT_CLIENT_APPLICATIONS")),
+		}
+		if err := config.InternalAuthConfig.validate(); err != nil {
+			panic(err.Error())
+		}
+
+		config.PrivateTLSConfig = privateTLSConfig{
+			CertFile:       options.GetString("PRIVATE_TLS_CERT_FILE"),
+			KeyFile:        options.GetString("PRIVATE_TLS_KEY_FILE"),
+			ClientCAFile:   options.GetString("PRIVATE_TLS_CLIENT_CA_FILE"),
+			ReloadInterval: options.GetDuration("PRIVATE_TLS_RELOAD_INTERVAL"),
+		}
+		if err := config.PrivateTLSConfig.validate(); err != nil {
+			panic(err.Error())
+		}
+
+		config.SentryConfig = sentryConfig{
+			DSN:         options.GetString("SENTRY_DSN"),
+			Environment: options.GetString("SENTRY_ENVIRONMENT"),
+			SampleRate:  options.GetFloat64("SENTRY_SAMPLE_RATE"),
 		}
 
 		if clowder.IsClowderEnabled() {
@@ -174,8 +1073,10 @@ func Get() *ExportConfig {
 			config.MetricsPort = cfg.MetricsPort
 			config.PrivatePort = *cfg.PrivatePort
 
-			exportBucket := options.GetString("EXPORT_SERVICE_BUCKET")
-			exportBucketInfo := clowder.ObjectBuckets[exportBucket]
+			bucket, err := FindClowderBucket(cfg.ObjectStore, options.GetString("EXPORT_SERVICE_BUCKET"))
+			if err != nil {
+				panic(err.Error())
+			}
 
 			rdsCaPath, err := getRdsCaPath(cfg)
 			if err != nil {
@@ -192,6 +1093,20 @@ func Get() *ExportConfig {
 					SSLMode: cfg.Database.SslMode,
 					RdsCa:   rdsCaPath,
 				},
+				Pool:    dbPoolConfigFrom(options),
+				Replica: dbReplicaConfigFrom(options, fmt.Sprint(cfg.Database.Port)),
+			}
+
+			// the in-memory db of the environment keeps the token buckets
+			if cfg.InMemoryDb != nil && config.RateLimitConfig.Redis.Addr == "" {
+				config.RateLimitConfig.Redis.Addr = net.JoinHostPort(cfg.InMemoryDb.Hostname, strconv.Itoa(cfg.InMemoryDb.Port))
+				if cfg.InMemoryDb.Password != nil {
+					config.RateLimitConfig.Redis.Password = *cfg.InMemoryDb.Password
+				}
+			}
+
+			if rbac, ok := clowder.DependencyEndpoints["rbac"]["service"]; ok {
+				config.RBACConfig.URL = buildBaseHttpUrl(false, rbac.Hostname, rbac.Port)
 			}
 
 			config.KafkaConfig.Brokers = clowder.KafkaServers
@@ -221,20 +1136,117 @@ func Get() *ExportConfig {
 				Region:          cfg.Logging.Cloudwatch.Region,
 			}
 
-			bucket := cfg.ObjectStore.Buckets[0]
+			region := options.GetString("STORAGE_REGION")
+			if region == "" {
+				region = stringValue(bucket.Region)
+			}
 			config.StorageConfig = storageConfig{
-				Bucket:    exportBucketInfo.RequestedName,
+				Provider:  options.GetString("STORAGE_PROVIDER"),
+				Region:    region,
+				Bucket:    bucket.Name,
 				Endpoint:  buildBaseHttpUrl(cfg.ObjectStore.Tls, cfg.ObjectStore.Hostname, cfg.ObjectStore.Port),
-				AccessKey: *bucket.AccessKey,
-				SecretKey: *bucket.SecretKey,
+				AccessKey: stringValue(bucket.AccessKey),
+				SecretKey: stringValue(bucket.SecretKey),
 				UseSSL:    cfg.ObjectStore.Tls,
+				SSEType:   options.GetString("STORAGE_SSE_TYPE"),
+				KMSKeyID:  options.GetString("STORAGE_KMS_KEY_ID"),
+
+				AmbientCredentials:    bucket.AccessKey == nil && bucket.SecretKey == nil,
+				Bootstrap:             options.GetBool("STORAGE_BOOTSTRAP"),
+				SourceObjectGraceDays: options.GetInt("STORAGE_SOURCE_OBJECT_GRACE_DAYS"),
+				KeepSourceObjects:     options.GetBool("KEEP_SOURCE_OBJECTS"),
+				ConnectTimeout:        options.GetDuration("STORAGE_CONNECT_TIMEOUT"),
+				RequestTimeout:        options.GetDuration("STORAGE_REQUEST_TIMEOUT"),
+				OperationTimeout:      options.GetDuration("STORAGE_OPERATION_TIMEOUT"),
+				MaxRetries:            options.GetInt("STORAGE_MAX_RETRIES"),
+				RetryMaxBackoff:       options.GetDuration("STORAGE_RETRY_MAX_BACKOFF"),
+				Azure:                 azureStorageConfigFrom(options),
+				FilesystemRoot:        options.GetString("STORAGE_FILESYSTEM_ROOT"),
+				MaxUploadPartBytes:    options.GetInt64("UPLOAD_MAX_PART_BYTES"),
+				PresignDownloads:      options.GetBool("STORAGE_PRESIGN_DOWNLOADS"),
+				PresignTTL:            options.GetDuration("STORAGE_PRESIGN_TTL"),
+				OrphanMinAge:          options.GetDuration("STORAGE_ORPHAN_MIN_AGE"),
 			}
 		}
+
+		// the address of redis may come from clowder
+		if err := config.RateLimitConfig.validate(); err != nil {
+			panic(err.Error())
+		}
 	})
 
 	return config
 }
 
+// dbPoolConfigFrom reads the PGSQL_* variables of the connection pool, clowder only
+// provides the database.
+func dbPoolConfigFrom(options *viper.Viper) dbPoolConfig {
+	return dbPoolConfig{
+		MaxOpenConns:    options.GetInt("PGSQL_MAX_OPEN_CONNS"),
+		MaxIdleConns:    options.GetInt("PGSQL_MAX_IDLE_CONNS"),
+		ConnMaxLifetime: options.GetDuration("PGSQL_CONN_MAX_LIFETIME"),
+	}
+}
+
+// dbReplicaConfigFrom reads the PGSQL_REPLICA_* variables, clowder does not provide
+// the replicas of the database. The replica listens on the port of the primary unless
+// told otherwise.
+func dbReplicaConfigFrom(options *viper.Viper, primaryPort string) dbReplicaConfig {
+	port := options.GetString("PGSQL_REPLICA_PORT")
+	if port == "" {
+		port = primaryPort
+	}
+	return dbReplicaConfig{
+		Hostname: options.GetString("PGSQL_REPLICA_HOSTNAME"),
+		Port:     port,
+	}
+}
+
+// azureStorageConfigFrom reads the AZURE_STORAGE_* variables, clowder does not
+// provision Azure containers.
+func azureStorageConfigFrom(options *viper.Viper) azureStorageConfig {
+	return azureStorageConfig{
+		Account:    options.GetString("AZURE_STORAGE_ACCOUNT"),
+		AccountKey: options.GetString("AZURE_STORAGE_KEY"),
+		Container:  options.GetString("AZURE_STORAGE_CONTAINER"),
+		Endpoint:   options.GetString("AZURE_STORAGE_ENDPOINT"),
+	}
+}
+
+// FindClowderBucket returns the bucket of the object store that was requested as
+// requestedName, which needs not be the first one. A bucket without credentials gets
+// those of the object store, and is accessed with the ambient credentials of the pod
+// when the object store has none either.
+func FindClowderBucket(objectStore *clowder.ObjectStoreConfig, requestedName string) (clowder.ObjectStoreBucket, error) {
+	if objectStore == nil {
+		return clowder.ObjectStoreBucket{}, fmt.Errorf("the clowder config has no object store for the export bucket '%s'", requestedName)
+	}
+
+	var names []string
+	for _, bucket := range objectStore.Buckets {
+		if bucket.RequestedName != requestedName {
+			names = append(names, bucket.RequestedName)
+			continue
+		}
+		if bucket.Name == "" {
+			bucket.Name = bucket.RequestedName
+		}
+		if bucket.AccessKey == nil && bucket.SecretKey == nil {
+			bucket.AccessKey = objectStore.AccessKey
+			bucket.SecretKey = objectStore.SecretKey
+		}
+		return bucket, nil
+	}
+	return clowder.ObjectStoreBucket{}, fmt.Errorf("the export bucket '%s' (EXPORT_SERVICE_BUCKET) is not in the clowder config, its buckets are: %s", requestedName, strings.Join(names, ", "))
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func getRdsCaPath(cfg *clowder.AppConfig) (*string, error) {
 	var rdsCaPath *string
 
@@ -249,6 +1261,112 @@ func getRdsCaPath(cfg *clowder.AppConfig) (*string, error) {
 	return rdsCaPath, nil
 }
 
+// splitList splits a comma separated list, leaving out the empty items.
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseKeyValuePairs parses a comma separated list of `key:value` pairs into a map.
+// Malformed pairs are ignored.
+func parseKeyValuePairs(s string) map[string]string {
+	result := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(pair, ":")
+		if !found || key == "" {
+			continue
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+// parsePskApplications parses the `id:application` pairs scoping the psks, a psk
+// repeated with several applications may act for each of them.
+func parsePskApplications(s string) map[string][]string {
+	result := map[string][]string{}
+	for _, pair := range strings.Split(s, ",") {
+		id, application, found := strings.Cut(pair, ":")
+		id, application = strings.TrimSpace(id), strings.TrimSpace(application)
+		if !found || id == "" || application == "" {
+			continue
+		}
+		result[id] = append(result[id], application)
+	}
+	return result
+}
+
+// parsePskExpiries parses the `id:timestamp` pairs of the expiries of the psks, the
+// timestamps are RFC 3339.
+func parsePskExpiries(s string) (map[string]time.Time, error) {
+	result := map[string]time.Time{}
+	for id, value := range parseKeyValuePairs(s) {
+		expiry, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PSK_EXPIRIES: the expiry of the psk '%s' must be a RFC 3339 timestamp: %w", id, err)
+		}
+		result[id] = expiry
+	}
+	return result, nil
+}
+
+// parseHeaders parses the `key=value` pairs of the OTLP headers, their values are URL
+// encoded.
+func parseHeaders(s string) map[string]string {
+	result := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			continue
+		}
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+// parseApplicationPolicies builds the policies from the `app:bytes` pairs of the upload
+// limits, the `app:format;format` pairs of the allowed formats and the `app:duration`
+// pairs of the source timeouts.
+func parseApplicationPolicies(maxUploadBytes, allowedFormats, sourceTimeouts string) (map[string]ApplicationPolicy, error) {
+	policies := map[string]ApplicationPolicy{}
+	for application, value := range parseKeyValuePairs(maxUploadBytes) {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid APPLICATION_MAX_UPLOAD_BYTES for '%s': '%s' is not a number of bytes", application, value)
+		}
+		policy := policies[application]
+		policy.MaxUploadBytes = limit
+		policies[application] = policy
+	}
+	for application, value := range parseKeyValuePairs(allowedFormats) {
+		policy := policies[application]
+		for _, format := range strings.Split(value, ";") {
+			if format = strings.TrimSpace(format); format != "" {
+				policy.AllowedFormats = append(policy.AllowedFormats, format)
+			}
+		}
+		policies[application] = policy
+	}
+	for application, value := range parseKeyValuePairs(sourceTimeouts) {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid APPLICATION_SOURCE_TIMEOUTS for '%s': '%s' is not a duration", application, value)
+		}
+		policy := policies[application]
+		policy.SourceTimeout = timeout
+		policies[application] = policy
+	}
+	return policies, nil
+}
+
 func buildBaseHttpUrl(tlsEnabled bool, hostname string, port int) string {
 	var protocol string = "http"
 	if tlsEnabled {
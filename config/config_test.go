@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clowder "github.com/redhatinsights/app-common-go/pkg/api/v1"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+func ptr(s string) *string {
+	return &s
+}
+
+var _ = Describe("Finding the export bucket in the clowder config", func() {
+	It("finds the export bucket when it is not the first one", func() {
+		objectStore := &clowder.ObjectStoreConfig{
+			Buckets: []clowder.ObjectStoreBucket{
+				{RequestedName: "other-bucket", Name: "other-bucket-abc", AccessKey: ptr("other-key"), SecretKey: ptr("other-secret")},
+				{RequestedName: "exports-bucket", Name: "exports-bucket-def", AccessKey: ptr("key"), SecretKey: ptr("secret"), Region: ptr("us-west-2")},
+			},
+		}
+
+		bucket, err := config.FindClowderBucket(objectStore, "exports-bucket")
+		Expect(err).To(BeNil())
+		Expect(bucket.Name).To(Equal("exports-bucket-def"))
+		Expect(*bucket.AccessKey).To(Equal("key"))
+		Expect(*bucket.SecretKey).To(Equal("secret"))
+		Expect(*bucket.Region).To(Equal("us-west-2"))
+	})
+
+	It("falls back to the credentials of the object store", func() {
+		objectStore := &clowder.ObjectStoreConfig{
+			AccessKey: ptr("store-key"),
+			SecretKey: ptr("store-secret"),
+			Buckets:   []clowder.ObjectStoreBucket{{RequestedName: "exports-bucket"}},
+		}
+
+		bucket, err := config.FindClowderBucket(objectStore, "exports-bucket")
+		Expect(err).To(BeNil())
+		Expect(bucket.Name).To(Equal("exports-bucket"))
+		Expect(*bucket.AccessKey).To(Equal("store-key"))
+		Expect(*bucket.SecretKey).To(Equal("store-secret"))
+	})
+
+	It("leaves the buckets provisioned without credentials to the ambient credentials", func() {
+		objectStore := &clowder.ObjectStoreConfig{
+			Buckets: []clowder.ObjectStoreBucket{{RequestedName: "exports-bucket", Name: "exports-bucket-abc"}},
+		}
+
+		bucket, err := config.FindClowderBucket(objectStore, "exports-bucket")
+		Expect(err).To(BeNil())
+		Expect(bucket.AccessKey).To(BeNil())
+		Expect(bucket.SecretKey).To(BeNil())
+	})
+
+	It("names the buckets of the config when the export bucket is missing", func() {
+		objectStore := &clowder.ObjectStoreConfig{
+			Buckets: []clowder.ObjectStoreBucket{{RequestedName: "bucket-a"}, {RequestedName: "bucket-b"}},
+		}
+
+		_, err := config.FindClowderBucket(objectStore, "exports-bucket")
+		Expect(err).To(MatchError("the export bucket 'exports-bucket' (EXPORT_SERVICE_BUCKET) is not in the clowder config, its buckets are: bucket-a, bucket-b"))
+
+		_, err = config.FindClowderBucket(nil, "exports-bucket")
+		Expect(err).To(MatchError(ContainSubstring("the clowder config has no object store")))
+	})
+})
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Redacted replaces the secrets in the dumps of the configuration and in the logs
+const Redacted = "[REDACTED]"
+
+// String dumps the effective configuration as json with its secrets masked, so that
+// it can be logged. The secrets that are not set are left empty.
+func (c ExportConfig) String() string {
+	out, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("failed to encode the configuration: %v", err)
+	}
+	return string(out)
+}
+
+// MarshalJSON encodes the configuration with its secrets masked.
+func (c ExportConfig) MarshalJSON() ([]byte, error) {
+	// plain does not have the methods of the configuration, it is encoded as is
+	type plain ExportConfig
+	return json.Marshal(plain(c.redacted()))
+}
+
+// redacted returns a copy of the configuration without its secrets, the
+// configuration itself is left untouched.
+func (c ExportConfig) redacted() ExportConfig {
+	r := c
+	r.Psks = redactAll(c.Psks)
+	if c.Logging != nil {
+		logging := *c.Logging
+		logging.AccessKeyID = redact(logging.AccessKeyID)
+		logging.SecretAccessKey = redact(logging.SecretAccessKey)
+		r.Logging = &logging
+	}
+	r.DBConfig.Password = redact(c.DBConfig.Password)
+	r.RateLimitConfig.Redis.Password = redact(c.RateLimitConfig.Redis.Password)
+	r.StorageConfig.AccessKey = redact(c.StorageConfig.AccessKey)
+	r.StorageConfig.SecretKey = redact(c.StorageConfig.SecretKey)
+	r.StorageConfig.Azure.AccountKey = redact(c.StorageConfig.Azure.AccountKey)
+	r.KafkaConfig.SSLConfig.Password = redact(c.KafkaConfig.SSLConfig.Password)
+	r.KafkaConfig.SchemaRegistry.Password = redact(c.KafkaConfig.SchemaRegistry.Password)
+	r.NotificationConfig.SigningKey = redact(c.NotificationConfig.SigningKey)
+	r.NotificationConfig.OrgSigningKeys = redactValues(c.NotificationConfig.OrgSigningKeys)
+	// the headers of the collector carry its credentials
+	r.TracingConfig.Headers = redactValues(c.TracingConfig.Headers)
+	// the dsn carries the key of the project
+	r.SentryConfig.DSN = redact(c.SentryConfig.DSN)
+	return r
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func redactAll(secrets []string) []string {
+	if secrets == nil {
+		return nil
+	}
+	redacted := make([]string, len(secrets))
+	for i, secret := range secrets {
+		redacted[i] = redact(secret)
+	}
+	return redacted
+}
+
+func redactValues(secrets map[string]string) map[string]string {
+	if secrets == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(secrets))
+	for key, secret := range secrets {
+		redacted[key] = redact(secret)
+	}
+	return redacted
+}
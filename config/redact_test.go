@@ -0,0 +1,57 @@
+package config_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+var _ = Describe("Dumping the configuration", func() {
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		cfg = config.ExportConfig{Hostname: "export-service-1", Psks: []string{"psk-1", "psk-2"}}
+		cfg.DBConfig.User = "exports"
+		cfg.DBConfig.Password = "db-password"
+		cfg.StorageConfig.Bucket = "exports-bucket"
+		cfg.StorageConfig.AccessKey = "access-key"
+		cfg.StorageConfig.SecretKey = "secret-key"
+		cfg.KafkaConfig.SSLConfig.Password = "sasl-password"
+		cfg.RateLimitConfig.Redis.Password = "redis-password"
+		cfg.NotificationConfig.OrgSigningKeys = map[string]string{"12345": "org-signing-key"}
+		cfg.TracingConfig.Headers = map[string]string{"Authorization": "Bearer collector-token"}
+		cfg.SentryConfig.DSN = "https://public@sentry.example.com/1"
+	})
+
+	It("masks the secrets", func() {
+		dump := cfg.String()
+		for _, secret := range []string{"psk-1", "psk-2", "db-password", "access-key", "secret-key", "sasl-password", "redis-password", "org-signing-key", "collector-token", "public@sentry"} {
+			Expect(dump).NotTo(ContainSubstring(secret))
+		}
+
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal([]byte(dump), &decoded)).To(Succeed())
+		Expect(decoded["Hostname"]).To(Equal("export-service-1"))
+		Expect(decoded["Psks"]).To(Equal([]interface{}{config.Redacted, config.Redacted}))
+		Expect(decoded["DBConfig"]).To(HaveKeyWithValue("User", "exports"))
+		Expect(decoded["DBConfig"]).To(HaveKeyWithValue("Password", config.Redacted))
+		Expect(decoded["StorageConfig"]).To(HaveKeyWithValue("Bucket", "exports-bucket"))
+		Expect(decoded["NotificationConfig"]).To(HaveKeyWithValue("OrgSigningKeys", map[string]interface{}{"12345": config.Redacted}))
+	})
+
+	It("leaves the secrets that are not set empty", func() {
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal([]byte(cfg.String()), &decoded)).To(Succeed())
+		Expect(decoded["KafkaConfig"]).To(HaveKeyWithValue("SchemaRegistry", HaveKeyWithValue("Password", "")))
+	})
+
+	It("does not change the configuration", func() {
+		_ = cfg.String()
+		Expect(cfg.DBConfig.Password).To(Equal("db-password"))
+		Expect(cfg.Psks).To(Equal([]string{"psk-1", "psk-2"}))
+		Expect(cfg.TracingConfig.Headers).To(HaveKeyWithValue("Authorization", "Bearer collector-token"))
+	})
+})
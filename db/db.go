@@ -6,17 +6,83 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 
 	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/tracing"
 )
 
+// OpenDB opens the database, its statements are traced and the stats of its
+// connection pool are exposed as metrics.
 func OpenDB(cfg config.ExportConfig) (*gorm.DB, error) {
-	dsn := buildPostgresDSN(cfg)
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	return open(buildPostgresDSN(cfg), cfg, cfg.DBConfig.Name)
+}
+
+// UseReplica registers the read-only replica of the database as the read source of
+// the models.ReplicaResolver, the statements that do not use the resolver stay on the
+// primary. The pool of the replica is bounded like the one of the primary and exposed
+// with the `_replica` suffix. It returns nil when no replica is configured.
+func UseReplica(db *gorm.DB, cfg config.ExportConfig) (*sql.DB, error) {
+	replica := cfg.DBConfig.Replica
+	if replica.Hostname == "" {
+		return nil, nil
+	}
+	cfg.DBConfig.Hostname = replica.Hostname
+	cfg.DBConfig.Port = replica.Port
+	// the pgx driver is the one of gorm.io/driver/postgres
+	sqlDB, err := sql.Open("pgx", buildPostgresDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+	configurePool(sqlDB, cfg, cfg.DBConfig.Name+"_replica")
+
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{postgres.New(postgres.Config{Conn: sqlDB})},
+	}, models.ReplicaResolver)
+	if err := db.Use(resolver); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return sqlDB, nil
+}
+
+func open(dsn string, cfg config.ExportConfig, name string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	configurePool(sqlDB, cfg, name)
+	return db, db.Use(tracing.GormPlugin{})
+}
+
+// configurePool bounds the connection pool and registers the collector of its stats,
+// the go_sql_* metrics labelled with the name. Only the first pool of each name is
+// collected.
+func configurePool(sqlDB *sql.DB, cfg config.ExportConfig, name string) {
+	pool := cfg.DBConfig.Pool
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	err := prometheus.Register(collectors.NewDBStatsCollector(sqlDB, name))
+	var registered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &registered) {
+		// the pool works without its metrics
+		logger.Get().Errorw("failed to register the metrics of the database pool", "error", err)
+	}
 }
 
 func OpenPostgresDB(cfg config.ExportConfig) (*sql.DB, error) {
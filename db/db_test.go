@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/db"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+var _ = Describe("The replica of the database", func() {
+	var primary *gorm.DB
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		// the connections are opened lazily, the databases are never reached
+		var err error
+		primary, err = gorm.Open(postgres.Open("postgres://localhost:1/export"), &gorm.Config{DisableAutomaticPing: true})
+		Expect(err).To(BeNil())
+		cfg = *config.Get()
+	})
+
+	It("is not used without a hostname", func() {
+		cfg.DBConfig.Replica.Hostname = ""
+		replica, err := db.UseReplica(primary, cfg)
+		Expect(err).To(BeNil())
+		Expect(replica).To(BeNil())
+		Expect(primary.Config.Plugins).ToNot(HaveKey("gorm:db_resolver"))
+	})
+
+	It("only serves the reads of the replica resolver", func() {
+		cfg.DBConfig.Replica.Hostname = "localhost"
+		cfg.DBConfig.Replica.Port = "2"
+		replica, err := db.UseReplica(primary, cfg)
+		Expect(err).To(BeNil())
+		Expect(replica).ToNot(BeNil())
+		DeferCleanup(replica.Close)
+
+		fromReplica := primary.Model(&models.ExportPayload{}).Clauses(dbresolver.Use(models.ReplicaResolver), dbresolver.Read)
+		Expect(fromReplica.Statement.ConnPool).To(BeIdenticalTo(replica))
+
+		// the writes of the resolver and the other statements stay on the primary
+		Expect(primary.Model(&models.ExportPayload{}).Clauses(dbresolver.Use(models.ReplicaResolver), dbresolver.Write).Statement.ConnPool).ToNot(BeIdenticalTo(replica))
+		Expect(primary.Model(&models.ExportPayload{}).Statement.ConnPool).ToNot(BeIdenticalTo(replica))
+	})
+})
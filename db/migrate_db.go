@@ -2,16 +2,26 @@ package db
 
 import (
 	"database/sql"
+	"embed"
 	"errors"
+	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/lib/pq"
 
 	"go.uber.org/zap"
 )
 
+// migrationFiles are the migrations of the schema, they are embedded in the binary so
+// that it always migrates to the schema it was built for.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
 type loggerWrapper struct {
 	*zap.SugaredLogger
 }
@@ -24,23 +34,92 @@ func (lw loggerWrapper) Printf(format string, v ...interface{}) {
 	lw.Infof(format, v...)
 }
 
-func PerformDbMigration(databaseConn *sql.DB, log *zap.SugaredLogger, pathToMigrationFiles string, direction string) error {
+// MigrationStatus is the version of the schema of the database against the latest
+// migration of the binary.
+type MigrationStatus struct {
+	// Version is the last migration applied to the database, 0 when none was
+	Version uint
+	// Dirty is set when the last migration failed halfway, it must be fixed by hand
+	Dirty  bool
+	Latest uint
+}
 
-	log.Info("Starting Export Service DB migration")
+// Pending reports whether migrations of the binary were not applied yet.
+func (s MigrationStatus) Pending() bool {
+	return s.Version < s.Latest
+}
+
+func (s MigrationStatus) String() string {
+	status := fmt.Sprintf("version %d of %d", s.Version, s.Latest)
+	switch {
+	case s.Dirty:
+		status += ", dirty"
+	case s.Pending():
+		status += fmt.Sprintf(", %d pending", s.Latest-s.Version)
+	case s.Version > s.Latest:
+		status += ", ahead of the binary"
+	}
+	return status
+}
+
+func migrationSource() (source.Driver, error) {
+	return iofs.New(migrationFiles, "migrations")
+}
+
+// LatestMigration returns the version of the last migration of the binary.
+func LatestMigration() (uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	for err == nil {
+		var next uint
+		next, err = src.Next(version)
+		if err == nil {
+			version = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return version, nil
+}
+
+// newMigrate returns the migrations of the binary applied to the database. Closing it
+// leaves the database connection open.
+func newMigrate(databaseConn *sql.DB, log *zap.SugaredLogger) (*migrate.Migrate, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return nil, err
+	}
 
 	driver, err := postgres.WithInstance(databaseConn, &postgres.Config{})
 	if err != nil {
-		log.Error("Unable to get postgres driver from database connection", "error", err)
-		return err
+		return nil, fmt.Errorf("unable to get postgres driver from database connection: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(pathToMigrationFiles, "postgres", driver)
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
 	if err != nil {
-		log.Error("Unable to intialize database migration util", "error", err)
-		return err
+		return nil, fmt.Errorf("unable to initialize database migration util: %w", err)
 	}
 
 	m.Log = loggerWrapper{log}
+	return m, nil
+}
+
+func PerformDbMigration(databaseConn *sql.DB, log *zap.SugaredLogger, direction string) error {
+
+	log.Info("Starting Export Service DB migration")
+
+	m, err := newMigrate(databaseConn, log)
+	if err != nil {
+		log.Errorw("Unable to prepare the DB migration", "error", err)
+		return err
+	}
+	defer m.Close()
 
 	if direction == "up" {
 		err = m.Up()
@@ -53,9 +132,49 @@ func PerformDbMigration(databaseConn *sql.DB, log *zap.SugaredLogger, pathToMigr
 	if errors.Is(err, migrate.ErrNoChange) {
 		log.Info("DB migration resulted in no changes")
 	} else if err != nil {
-		log.Error("DB migration resulted in an error", "error", err)
+		log.Errorw("DB migration resulted in an error", "error", err)
 		return err
 	}
 
 	return nil
 }
+
+// GetMigrationStatus returns the version of the schema of the database.
+func GetMigrationStatus(databaseConn *sql.DB, log *zap.SugaredLogger) (MigrationStatus, error) {
+	latest, err := LatestMigration()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	m, err := newMigrate(databaseConn, log)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{Latest: latest}, nil
+	} else if err != nil {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{Version: version, Dirty: dirty, Latest: latest}, nil
+}
+
+// CheckSchemaVersion fails when the schema of the database is behind the binary or
+// dirty, the binary would fail on the tables and columns it lacks. A schema ahead of
+// the binary is accepted, so that a deployment can be rolled back without its
+// migrations.
+func CheckSchemaVersion(databaseConn *sql.DB, log *zap.SugaredLogger) (MigrationStatus, error) {
+	status, err := GetMigrationStatus(databaseConn, log)
+	if err != nil {
+		return status, fmt.Errorf("failed to get the version of the schema: %w", err)
+	}
+	if status.Dirty {
+		return status, fmt.Errorf("the schema is dirty at version %d, the migration failed halfway and must be fixed by hand", status.Version)
+	}
+	if status.Pending() {
+		return status, fmt.Errorf("the schema is at version %d but the binary needs version %d, run `export-service migrate up`", status.Version, status.Latest)
+	}
+	return status, nil
+}
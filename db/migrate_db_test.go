@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/db"
+)
+
+var _ = Describe("The migrations of the binary", func() {
+	It("embeds every migration of the repository", func() {
+		files, err := filepath.Glob("migrations/*.up.sql")
+		Expect(err).To(BeNil())
+		Expect(files).NotTo(BeEmpty())
+
+		var highest uint
+		for _, file := range files {
+			version, err := strconv.ParseUint(strings.SplitN(filepath.Base(file), "_", 2)[0], 10, 64)
+			Expect(err).To(BeNil())
+			if uint(version) > highest {
+				highest = uint(version)
+			}
+
+			// every migration can be reverted
+			_, err = os.Stat(strings.TrimSuffix(file, ".up.sql") + ".down.sql")
+			Expect(err).To(BeNil(), "%s has no down migration", file)
+		}
+
+		latest, err := db.LatestMigration()
+		Expect(err).To(BeNil())
+		Expect(latest).To(Equal(highest))
+	})
+
+	DescribeTable("describes the status of the schema",
+		func(status db.MigrationStatus, pending bool, description string) {
+			Expect(status.Pending()).To(Equal(pending))
+			Expect(status.String()).To(Equal(description))
+		},
+		Entry("up to date", db.MigrationStatus{Version: 19, Latest: 19}, false, "version 19 of 19"),
+		Entry("behind", db.MigrationStatus{Version: 17, Latest: 19}, true, "version 17 of 19, 2 pending"),
+		Entry("never migrated", db.MigrationStatus{Latest: 19}, true, "version 0 of 19, 19 pending"),
+		Entry("dirty", db.MigrationStatus{Version: 19, Dirty: true, Latest: 19}, false, "version 19 of 19, dirty"),
+		Entry("ahead", db.MigrationStatus{Version: 20, Latest: 19}, false, "version 20 of 19, ahead of the binary"),
+	)
+})
@@ -0,0 +1,5 @@
+ALTER TABLE sources ADD COLUMN format text;
+
+UPDATE sources SET format = export_payloads.format
+FROM export_payloads
+WHERE sources.export_payload_id = export_payloads.id;
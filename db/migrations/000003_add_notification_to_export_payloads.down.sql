@@ -0,0 +1,6 @@
+ALTER TABLE export_payloads
+    DROP COLUMN notification_url,
+    DROP COLUMN notification_status,
+    DROP COLUMN notification_attempts,
+    DROP COLUMN notification_error,
+    DROP COLUMN notified_at;
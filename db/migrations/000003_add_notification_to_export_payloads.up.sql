@@ -0,0 +1,6 @@
+ALTER TABLE export_payloads
+    ADD COLUMN notification_url text,
+    ADD COLUMN notification_status text,
+    ADD COLUMN notification_attempts int NOT NULL DEFAULT 0,
+    ADD COLUMN notification_error text,
+    ADD COLUMN notified_at timestamp with time zone;
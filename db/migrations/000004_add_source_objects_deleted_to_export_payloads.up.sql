@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    ADD COLUMN source_objects_deleted boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    ADD COLUMN identity_type text NOT NULL DEFAULT 'User';
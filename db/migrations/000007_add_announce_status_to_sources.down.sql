@@ -0,0 +1,6 @@
+ALTER TABLE export_payloads
+    DROP COLUMN identity;
+
+ALTER TABLE sources
+    DROP COLUMN announced_at,
+    DROP COLUMN delivery_error;
@@ -0,0 +1,6 @@
+ALTER TABLE sources
+    ADD COLUMN announced_at timestamp with time zone,
+    ADD COLUMN delivery_error text;
+
+ALTER TABLE export_payloads
+    ADD COLUMN identity text;
@@ -0,0 +1,7 @@
+DROP TABLE org_storage;
+
+ALTER TABLE sources
+    DROP COLUMN size;
+
+ALTER TABLE export_payloads
+    DROP COLUMN stored_bytes;
@@ -0,0 +1,12 @@
+ALTER TABLE export_payloads
+    ADD COLUMN stored_bytes bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE sources
+    ADD COLUMN size bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE org_storage (
+    organization_id text PRIMARY KEY,
+    stored_bytes bigint NOT NULL DEFAULT 0,
+    quota_bytes bigint,
+    updated_at timestamp with time zone
+);
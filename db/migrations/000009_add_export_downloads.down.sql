@@ -0,0 +1,5 @@
+ALTER TABLE export_payloads
+    DROP COLUMN last_downloaded_at,
+    DROP COLUMN download_count;
+
+DROP TABLE export_downloads;
@@ -0,0 +1,18 @@
+CREATE TABLE export_downloads (
+    id bigserial PRIMARY KEY,
+    export_payload_id uuid NOT NULL REFERENCES export_payloads(id) ON DELETE CASCADE,
+    source_id uuid,
+    downloaded_at timestamp with time zone NOT NULL,
+    account_id text,
+    organization_id text,
+    username text,
+    identity_type text,
+    bytes bigint NOT NULL DEFAULT 0,
+    presigned boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX export_downloads_export_payload_id_idx ON export_downloads (export_payload_id);
+
+ALTER TABLE export_payloads
+    ADD COLUMN download_count bigint NOT NULL DEFAULT 0,
+    ADD COLUMN last_downloaded_at timestamp with time zone;
@@ -0,0 +1,20 @@
+CREATE TABLE schedules (
+    id uuid PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    frequency text NOT NULL,
+    hour int NOT NULL DEFAULT 0,
+    weekday int NOT NULL DEFAULT 0,
+    enabled boolean NOT NULL DEFAULT true,
+    template jsonb NOT NULL,
+    identity text,
+    account_id text,
+    organization_id text,
+    username text,
+    identity_type text,
+    last_run timestamp with time zone,
+    next_run timestamp with time zone NOT NULL,
+    last_export_id uuid
+);
+
+CREATE INDEX schedules_next_run_idx ON schedules (next_run) WHERE enabled;
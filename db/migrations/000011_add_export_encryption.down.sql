@@ -0,0 +1,3 @@
+ALTER TABLE export_payloads
+    DROP COLUMN encryption_public_key,
+    DROP COLUMN encryption_fingerprint;
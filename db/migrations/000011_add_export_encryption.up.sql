@@ -0,0 +1,3 @@
+ALTER TABLE export_payloads
+    ADD COLUMN encryption_public_key text NOT NULL DEFAULT '',
+    ADD COLUMN encryption_fingerprint text NOT NULL DEFAULT '';
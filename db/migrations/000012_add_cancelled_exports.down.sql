@@ -0,0 +1 @@
+DROP TABLE cancelled_exports;
@@ -0,0 +1,8 @@
+CREATE TABLE cancelled_exports (
+    id uuid PRIMARY KEY,
+    organization_id text,
+    cancelled_at timestamp with time zone NOT NULL,
+    expires timestamp with time zone NOT NULL
+);
+
+CREATE INDEX cancelled_exports_expires_idx ON cancelled_exports (expires);
@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    DROP COLUMN original_request;
@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    ADD COLUMN original_request jsonb;
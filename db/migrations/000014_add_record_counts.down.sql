@@ -0,0 +1,4 @@
+ALTER TABLE sources
+    DROP COLUMN record_count;
+ALTER TABLE export_payloads
+    DROP COLUMN record_count;
@@ -0,0 +1,4 @@
+ALTER TABLE sources
+    ADD COLUMN record_count bigint;
+ALTER TABLE export_payloads
+    ADD COLUMN record_count bigint;
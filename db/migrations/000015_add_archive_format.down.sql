@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    DROP COLUMN archive_format;
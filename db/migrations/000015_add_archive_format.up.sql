@@ -0,0 +1,2 @@
+ALTER TABLE export_payloads
+    ADD COLUMN archive_format text NOT NULL DEFAULT 'tar.gz';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS sources_export_payload_id_idx;
+DROP INDEX IF EXISTS export_payloads_organization_id_created_at_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX export_payloads_organization_id_created_at_idx ON export_payloads (organization_id, created_at);
+CREATE INDEX sources_export_payload_id_idx ON sources (export_payload_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS export_payloads_idempotency_key_idx;
+
+ALTER TABLE export_payloads
+    DROP COLUMN idempotency_fingerprint,
+    DROP COLUMN idempotency_key;
@@ -0,0 +1,7 @@
+ALTER TABLE export_payloads
+    ADD COLUMN idempotency_key text,
+    ADD COLUMN idempotency_fingerprint text NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX export_payloads_idempotency_key_idx
+    ON export_payloads (organization_id, account_id, username, identity_type, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
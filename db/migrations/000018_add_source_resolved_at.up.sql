@@ -0,0 +1,2 @@
+ALTER TABLE sources
+    ADD COLUMN resolved_at timestamp with time zone;
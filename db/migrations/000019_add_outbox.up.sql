@@ -0,0 +1,12 @@
+CREATE TABLE outbox (
+    id bigserial PRIMARY KEY,
+    export_payload_id uuid NOT NULL REFERENCES export_payloads(id) ON DELETE CASCADE,
+    source_id uuid NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    sent_at timestamp with time zone,
+    attempts int NOT NULL DEFAULT 0,
+    published_at timestamp with time zone
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+CREATE INDEX outbox_source_id_idx ON outbox (source_id);
@@ -0,0 +1,43 @@
+DROP TABLE export_idempotency_keys;
+
+-- dropping the columns drops the foreign keys on the partitioned exports
+ALTER TABLE export_downloads DROP COLUMN export_created_at;
+ALTER TABLE outbox DROP COLUMN export_created_at;
+
+ALTER TABLE sources RENAME TO sources_partitioned;
+ALTER TABLE export_payloads RENAME TO export_payloads_partitioned;
+ALTER TABLE sources_partitioned DROP CONSTRAINT sources_export_payload_id_fkey;
+ALTER TABLE sources_partitioned DROP CONSTRAINT sources_pkey;
+ALTER TABLE export_payloads_partitioned DROP CONSTRAINT export_payloads_pkey;
+DROP INDEX sources_export_payload_id_idx;
+DROP INDEX export_payloads_organization_id_created_at_idx;
+
+CREATE TABLE export_payloads (LIKE export_payloads_partitioned INCLUDING DEFAULTS);
+ALTER TABLE export_payloads ALTER COLUMN created_at DROP NOT NULL;
+INSERT INTO export_payloads SELECT * FROM export_payloads_partitioned;
+ALTER TABLE export_payloads ADD PRIMARY KEY (id);
+CREATE INDEX export_payloads_organization_id_created_at_idx ON export_payloads (organization_id, created_at);
+CREATE UNIQUE INDEX export_payloads_idempotency_key_idx
+    ON export_payloads (organization_id, account_id, username, identity_type, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
+
+CREATE TABLE sources (LIKE sources_partitioned INCLUDING DEFAULTS);
+ALTER TABLE sources DROP COLUMN created_at;
+INSERT INTO sources
+SELECT id, export_payload_id, application, status, resource, filters, code, message, format,
+    checksum, announced_at, delivery_error, size, record_count, resolved_at
+FROM sources_partitioned;
+ALTER TABLE sources
+    ADD PRIMARY KEY (id),
+    ADD CONSTRAINT sources_export_payload_id_fkey FOREIGN KEY (export_payload_id)
+        REFERENCES export_payloads (id) ON DELETE CASCADE;
+CREATE INDEX sources_export_payload_id_idx ON sources (export_payload_id);
+
+ALTER TABLE export_downloads ADD CONSTRAINT export_downloads_export_payload_id_fkey
+    FOREIGN KEY (export_payload_id) REFERENCES export_payloads (id) ON DELETE CASCADE;
+ALTER TABLE outbox ADD CONSTRAINT outbox_export_payload_id_fkey
+    FOREIGN KEY (export_payload_id) REFERENCES export_payloads (id) ON DELETE CASCADE;
+
+DROP TABLE sources_partitioned;
+DROP TABLE export_payloads_partitioned;
+DROP FUNCTION create_export_partitions(timestamp with time zone, timestamp with time zone);
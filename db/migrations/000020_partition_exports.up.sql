@@ -0,0 +1,113 @@
+-- The exports and their sources are partitioned by the month the export was created
+-- in, so that the expired months are dropped rather than deleted row by row. The
+-- unique indexes of a partitioned table include its partition key: the created_at of
+-- the export is part of the keys of the exports and of the rows referencing them.
+
+-- create_export_partitions creates the monthly partitions of the exports and of their
+-- sources from the month of from_time to the month of until_time, in UTC. The
+-- partitions that exist are left untouched, and so are the months with exports in the
+-- default partition, their exports are deleted row by row.
+CREATE FUNCTION create_export_partitions(from_time timestamp with time zone, until_time timestamp with time zone) RETURNS void AS $$
+DECLARE
+    partition_month timestamp := date_trunc('month', from_time AT TIME ZONE 'UTC');
+    suffix text;
+BEGIN
+    WHILE partition_month <= until_time AT TIME ZONE 'UTC' LOOP
+        suffix := to_char(partition_month, '"y"YYYY"m"MM');
+        BEGIN
+            EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF export_payloads FOR VALUES FROM (%L) TO (%L)',
+                'export_payloads_' || suffix, partition_month AT TIME ZONE 'UTC', (partition_month + interval '1 month') AT TIME ZONE 'UTC');
+            EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF sources FOR VALUES FROM (%L) TO (%L)',
+                'sources_' || suffix, partition_month AT TIME ZONE 'UTC', (partition_month + interval '1 month') AT TIME ZONE 'UTC');
+        EXCEPTION WHEN check_violation THEN
+            RAISE WARNING 'the default partitions have rows of %, its partitions were not created', suffix;
+        END;
+        partition_month := partition_month + interval '1 month';
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE export_downloads DROP CONSTRAINT export_downloads_export_payload_id_fkey;
+ALTER TABLE outbox DROP CONSTRAINT outbox_export_payload_id_fkey;
+
+-- the tables are copied into their partitioned successors, which take their names
+ALTER TABLE sources RENAME TO sources_unpartitioned;
+ALTER TABLE export_payloads RENAME TO export_payloads_unpartitioned;
+ALTER TABLE sources_unpartitioned DROP CONSTRAINT sources_export_payload_id_fkey;
+ALTER TABLE sources_unpartitioned DROP CONSTRAINT sources_pkey;
+ALTER TABLE export_payloads_unpartitioned DROP CONSTRAINT export_payloads_pkey;
+DROP INDEX sources_export_payload_id_idx;
+DROP INDEX export_payloads_organization_id_created_at_idx;
+DROP INDEX export_payloads_idempotency_key_idx;
+
+UPDATE export_payloads_unpartitioned SET created_at = COALESCE(updated_at, now()) WHERE created_at IS NULL;
+
+CREATE TABLE export_payloads (LIKE export_payloads_unpartitioned INCLUDING DEFAULTS) PARTITION BY RANGE (created_at);
+ALTER TABLE export_payloads ADD PRIMARY KEY (id, created_at);
+CREATE INDEX export_payloads_organization_id_created_at_idx ON export_payloads (organization_id, created_at);
+
+-- the sources are partitioned by the created_at of their export
+CREATE TABLE sources (
+    LIKE sources_unpartitioned INCLUDING DEFAULTS,
+    created_at timestamp with time zone NOT NULL
+) PARTITION BY RANGE (created_at);
+ALTER TABLE sources
+    ADD PRIMARY KEY (id, created_at),
+    ADD CONSTRAINT sources_export_payload_id_fkey FOREIGN KEY (export_payload_id, created_at)
+        REFERENCES export_payloads (id, created_at) ON DELETE CASCADE ON UPDATE CASCADE;
+CREATE INDEX sources_export_payload_id_idx ON sources (export_payload_id);
+
+SELECT create_export_partitions(
+    (SELECT COALESCE(min(created_at), now()) FROM export_payloads_unpartitioned),
+    now() + interval '3 months');
+-- the rows outside of the monthly partitions, they are deleted row by row
+CREATE TABLE export_payloads_default PARTITION OF export_payloads DEFAULT;
+CREATE TABLE sources_default PARTITION OF sources DEFAULT;
+
+INSERT INTO export_payloads SELECT * FROM export_payloads_unpartitioned;
+INSERT INTO sources
+SELECT sources_unpartitioned.*, COALESCE(export_payloads_unpartitioned.created_at, now())
+FROM sources_unpartitioned
+LEFT JOIN export_payloads_unpartitioned ON export_payloads_unpartitioned.id = sources_unpartitioned.export_payload_id;
+
+DROP TABLE sources_unpartitioned;
+DROP TABLE export_payloads_unpartitioned;
+
+-- the rows referencing the exports carry the created_at of their export
+ALTER TABLE export_downloads ADD COLUMN export_created_at timestamp with time zone;
+UPDATE export_downloads SET export_created_at = export_payloads.created_at
+FROM export_payloads WHERE export_payloads.id = export_downloads.export_payload_id;
+ALTER TABLE export_downloads
+    ALTER COLUMN export_created_at SET NOT NULL,
+    ADD CONSTRAINT export_downloads_export_payload_id_fkey FOREIGN KEY (export_payload_id, export_created_at)
+        REFERENCES export_payloads (id, created_at) ON DELETE CASCADE ON UPDATE CASCADE;
+CREATE INDEX export_downloads_export_created_at_idx ON export_downloads (export_created_at);
+
+ALTER TABLE outbox ADD COLUMN export_created_at timestamp with time zone;
+UPDATE outbox SET export_created_at = export_payloads.created_at
+FROM export_payloads WHERE export_payloads.id = outbox.export_payload_id;
+ALTER TABLE outbox
+    ALTER COLUMN export_created_at SET NOT NULL,
+    ADD CONSTRAINT outbox_export_payload_id_fkey FOREIGN KEY (export_payload_id, export_created_at)
+        REFERENCES export_payloads (id, created_at) ON DELETE CASCADE ON UPDATE CASCADE;
+CREATE INDEX outbox_export_created_at_idx ON outbox (export_created_at);
+
+-- the Idempotency-Keys are unique across the partitions, they are reserved in a table
+-- of their own
+CREATE TABLE export_idempotency_keys (
+    idempotency_key text NOT NULL,
+    account_id text,
+    organization_id text,
+    username text,
+    identity_type text,
+    export_payload_id uuid NOT NULL,
+    export_created_at timestamp with time zone NOT NULL,
+    CONSTRAINT export_idempotency_keys_export_payload_id_fkey FOREIGN KEY (export_payload_id, export_created_at)
+        REFERENCES export_payloads (id, created_at) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX export_idempotency_keys_idx
+    ON export_idempotency_keys (organization_id, account_id, username, identity_type, idempotency_key);
+CREATE INDEX export_idempotency_keys_export_created_at_idx ON export_idempotency_keys (export_created_at);
+INSERT INTO export_idempotency_keys
+SELECT idempotency_key, account_id, organization_id, username, identity_type, id, created_at
+FROM export_payloads WHERE idempotency_key IS NOT NULL;
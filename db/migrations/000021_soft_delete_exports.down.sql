@@ -0,0 +1,5 @@
+DELETE FROM export_payloads WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS export_payloads_deleted_at_idx;
+
+ALTER TABLE export_payloads DROP COLUMN IF EXISTS deleted_at;
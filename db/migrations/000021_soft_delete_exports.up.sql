@@ -0,0 +1,4 @@
+ALTER TABLE export_payloads ADD COLUMN deleted_at timestamp with time zone;
+
+-- the cleanup looks for the deleted exports past their retention
+CREATE INDEX export_payloads_deleted_at_idx ON export_payloads (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS export_payloads_expiry_warning_idx;
+
+ALTER TABLE export_payloads DROP COLUMN IF EXISTS expiry_warned_at;
@@ -0,0 +1,4 @@
+ALTER TABLE export_payloads ADD COLUMN expiry_warned_at timestamp with time zone;
+
+-- the expiry warnings look for the exports not warned about yet
+CREATE INDEX export_payloads_expiry_warning_idx ON export_payloads (expires) WHERE expiry_warned_at IS NULL;
@@ -0,0 +1 @@
+UPDATE export_payloads SET status = 'partial' WHERE status = 'complete_with_errors';
@@ -0,0 +1,2 @@
+-- the exports some of whose sources failed are complete with errors
+UPDATE export_payloads SET status = 'complete_with_errors' WHERE status = 'partial';
@@ -0,0 +1,4 @@
+CREATE TABLE psk_uses (
+    psk_id text PRIMARY KEY,
+    last_used_at timestamp with time zone NOT NULL
+);
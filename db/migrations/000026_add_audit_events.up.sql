@@ -0,0 +1,31 @@
+CREATE TABLE audit_events (
+    id bigserial PRIMARY KEY,
+    occurred_at timestamp with time zone NOT NULL,
+    action text NOT NULL,
+    outcome text NOT NULL,
+    status_code integer NOT NULL,
+    export_id uuid,
+    source_id uuid,
+    account_id text,
+    organization_id text,
+    username text,
+    identity_type text,
+    client text,
+    source_ip text,
+    request_id text
+);
+
+CREATE INDEX audit_events_export_id_idx ON audit_events (export_id, occurred_at);
+CREATE INDEX audit_events_organization_id_idx ON audit_events (organization_id, occurred_at);
+
+-- the audit trail is append only, the events are never updated or deleted
+CREATE FUNCTION audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'the audit events are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_immutable BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_immutable();
+CREATE TRIGGER audit_events_not_truncated BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_immutable();
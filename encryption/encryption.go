@@ -0,0 +1,69 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+
+	// the hashes openpgp picks from must be linked in, RIPEMD160 is its choice for
+	// the keys that state no preference
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+const (
+	// ContentType is the media type of the encrypted archives
+	ContentType = "application/pgp-encrypted"
+	// Extension is appended to the name of the encrypted archives
+	Extension = ".gpg"
+)
+
+// PublicKey is an armored OpenPGP public key the archive of an export is encrypted to.
+type PublicKey struct {
+	entities openpgp.EntityList
+}
+
+// ParsePublicKey parses an armored OpenPGP public key. The key is rejected unless an
+// archive can be encrypted to it, so that a bad key fails the request of the export
+// rather than its packaging.
+func ParsePublicKey(armored string) (*PublicKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(armored), "age1") {
+		return nil, errors.New("age keys are not supported, provide an armored OpenPGP public key")
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP public key: %w", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected a single OpenPGP public key, got %d", len(entities))
+	}
+
+	key := &PublicKey{entities: entities}
+	w, err := key.Encrypt(io.Discard)
+	if err != nil {
+		return nil, fmt.Errorf("the OpenPGP public key can not be used for encryption: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("the OpenPGP public key can not be used for encryption: %w", err)
+	}
+	return key, nil
+}
+
+// Fingerprint returns the hex encoded fingerprint of the primary key.
+func (k *PublicKey) Fingerprint() string {
+	return fmt.Sprintf("%X", k.entities[0].PrimaryKey.Fingerprint)
+}
+
+// Encrypt returns a writer encrypting what is written to it into w. The writer must
+// be closed to flush the encrypted data.
+func (k *PublicKey) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return openpgp.Encrypt(w, k.entities, nil, &openpgp.FileHints{IsBinary: true}, nil)
+}
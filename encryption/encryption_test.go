@@ -0,0 +1,62 @@
+package encryption_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/redhatinsights/export-service-go/encryption"
+)
+
+func armoredPublicKey(entity *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	Expect(err).To(BeNil())
+	Expect(entity.Serialize(w)).To(Succeed())
+	Expect(w.Close()).To(Succeed())
+	return buf.String()
+}
+
+var _ = Describe("Encrypting the archives", func() {
+	var entity *openpgp.Entity
+
+	BeforeEach(func() {
+		var err error
+		entity, err = openpgp.NewEntity("export", "", "export@example.com", nil)
+		Expect(err).To(BeNil())
+	})
+
+	It("encrypts to the public key", func() {
+		key, err := encryption.ParsePublicKey(armoredPublicKey(entity))
+		Expect(err).To(BeNil())
+		Expect(key.Fingerprint()).To(Equal(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)))
+
+		var encrypted bytes.Buffer
+		w, err := key.Encrypt(&encrypted)
+		Expect(err).To(BeNil())
+		_, err = w.Write([]byte("the archive"))
+		Expect(err).To(BeNil())
+		Expect(w.Close()).To(Succeed())
+		Expect(encrypted.String()).ToNot(ContainSubstring("the archive"))
+
+		md, err := openpgp.ReadMessage(&encrypted, openpgp.EntityList{entity}, nil, nil)
+		Expect(err).To(BeNil())
+		decrypted, err := io.ReadAll(md.UnverifiedBody)
+		Expect(err).To(BeNil())
+		Expect(string(decrypted)).To(Equal("the archive"))
+	})
+
+	DescribeTable("rejects the keys that can not be used",
+		func(key, message string) {
+			_, err := encryption.ParsePublicKey(key)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("garbage", "not a key", "invalid OpenPGP public key"),
+		Entry("age keys", "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "age keys are not supported"),
+	)
+})
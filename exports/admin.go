@@ -0,0 +1,453 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.uber.org/zap"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/s3"
+)
+
+// AdminRouter lets support engineers inspect the exports of any organization and
+// resolve sources that will never be delivered. Every request is audit logged.
+func (i *Internal) AdminRouter(r chi.Router) {
+	r.Use(i.auditAdminAccess)
+	r.With(middleware.PaginationCtx).Get("/", i.AdminListExports)
+	r.Get("/{exportUUID}", i.AdminGetExport)
+	r.Post("/{exportUUID}/sources/{sourceUUID}/resolve", i.ResolveSource)
+	r.With(i.audit(models.AuditRetry)).Post("/{exportUUID}/sources/{sourceUUID}/announce", i.RepublishSource)
+	r.With(i.audit(models.AuditRetry)).Post("/{exportUUID}/announce", i.RepublishExport)
+}
+
+// OrgStorageRouter lets ops view the storage used by an organization and override
+// its quota. Every request is audit logged.
+func (i *Internal) OrgStorageRouter(r chi.Router) {
+	r.Use(i.auditAdminAccess)
+	r.Get("/", i.GetOrgStorage)
+	r.Put("/", i.PutOrgQuota)
+}
+
+// auditAdminAccess logs every request to the admin endpoints along with the id of
+// the psk that made it.
+func (i *Internal) auditAdminAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.Log.Infow("admin access",
+			export_logger.RequestIDField(request_id.GetReqID(r.Context())),
+			"psk_id", middleware.GetPSKID(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+		)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminListExports handles GET requests to the private /exports endpoint. Exports of
+// all organizations are listed unless filtered by the `org_id` query param, the
+// `request_id` param finds the export created by a request.
+func (i *Internal) AdminListExports(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	page := middleware.GetPagination(r.Context())
+
+	logger := i.Log.With(export_logger.RequestIDField(reqID))
+
+	q := r.URL.Query()
+
+	params, err := initQuery(q)
+	if err != nil {
+		logger.Errorw("error while parsing params", "error", err)
+		BadRequestError(w, err.Error())
+		return
+	}
+	params.RequestID = q.Get("request_id")
+
+	exports, count, err := i.DB.WithContext(r.Context()).AdminList(q.Get("org_id"), &params, page.Offset, page.Limit, page.SortBy, page.Dir)
+	if err != nil {
+		logger.Errorw("error while retrieving list from database", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	data := []AdminExportPayload{}
+	for _, export := range exports {
+		data = append(data, DBExportToAdminAPI(*export))
+	}
+
+	resp, err := middleware.GetPaginatedResponse(r.URL, page, count, data)
+	if err != nil {
+		logger.Errorw("error while paginating data", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+		InternalServerError(w, err.Error())
+	}
+}
+
+// AdminGetExport handles GET requests to the private /exports/{exportUUID} endpoint,
+// returning the full export regardless of the organization that owns it.
+func (i *Internal) AdminGetExport(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	logger := i.Log.With(export_logger.RequestIDField(reqID), export_logger.ExportIDField(uid))
+
+	export, err := i.DB.WithContext(r.Context()).Get(exportUUID)
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			logger.Infof("record '%s' not found", exportUUID)
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		default:
+			logger.Errorw("error querying for payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	downloads, err := i.DB.WithContext(r.Context()).ListDownloads(exportUUID)
+	if err != nil {
+		logger.Errorw("error querying for the downloads", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	apiExport := DBExportToAdminAPI(*export)
+	apiExport.Downloads = DBDownloadsToAdminAPI(downloads)
+
+	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+		InternalServerError(w, err.Error())
+	}
+}
+
+// ResolveSource handles POST requests to the private
+// /exports/{exportUUID}/sources/{sourceUUID}/resolve endpoint. It forces a pending
+// source into its final status, as if its application had reported it, and packages
+// the export if it was the last outstanding source.
+func (i *Internal) ResolveSource(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	sid := chi.URLParam(r, "sourceUUID")
+	sourceUUID, err := uuid.Parse(sid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid resource UUID", sid))
+		return
+	}
+
+	logger := i.Log.With(
+		export_logger.RequestIDField(reqID),
+		export_logger.ExportIDField(uid),
+		"source_id", sid,
+		"psk_id", middleware.GetPSKID(r.Context()),
+	)
+
+	var resolve ResolveSource
+	if err := json.NewDecoder(r.Body).Decode(&resolve); err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+
+	var status models.ResourceStatus
+	var sourceError *models.SourceError
+	switch resolve.Status {
+	case "failed":
+		if resolve.Code == nil {
+			BadRequestError(w, "an error code is required to resolve a source as failed")
+			return
+		}
+		status = models.RFailed
+		sourceError = &models.SourceError{Code: *resolve.Code}
+		if resolve.Message != nil {
+			sourceError.Message = *resolve.Message
+		}
+	case "complete":
+		status = models.RSuccess
+	default:
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid status, must be one of 'failed' or 'complete'", resolve.Status))
+		return
+	}
+
+	payload, err := i.DB.WithContext(r.Context()).Get(exportUUID)
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		default:
+			logger.Errorw("error querying for payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	_, source, err := payload.GetSource(sourceUUID)
+	if err != nil {
+		NotFoundError(w, fmt.Sprintf("source '%s' not found", sourceUUID))
+		return
+	}
+
+	if source.Status != models.RPending {
+		logger.Warnw("refused to resolve a source that is not pending", "current_status", source.Status, "requested_status", status)
+		ConflictError(w, fmt.Sprintf("source '%s' is already '%s'", sourceUUID, source.Status))
+		return
+	}
+
+	// a complete source is packaged, so its object must have been stored
+	if status == models.RSuccess {
+		if _, err := i.Compressor.StatObject(r.Context(), s3.SourceObjectKey(payload, *source)); err != nil {
+			if errors.Is(err, s3.ErrObjectNotFound) {
+				logger.Warnw("refused to complete a source whose object was not stored")
+				ConflictError(w, fmt.Sprintf("source '%s' has no stored object, it can only be resolved as failed", sourceUUID))
+				return
+			}
+			storageError(w, logger, err, "")
+			return
+		}
+	}
+
+	logger.Warnw("manually resolving source", "org_id", payload.OrganizationID, "requested_status", status)
+
+	if err := i.resolveSource(i.DB.WithContext(r.Context()), payload, sourceUUID, status, sourceError); err != nil {
+		logger.Errorw("failed to resolve source", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	payload, err = i.DB.WithContext(r.Context()).Get(exportUUID)
+	if err != nil {
+		logger.Errorw("error querying for payload entry", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	apiExport := DBExportToAdminAPI(*payload)
+	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// RepublishSource handles POST requests to the private
+// /exports/{exportUUID}/sources/{sourceUUID}/announce endpoint. It sends the request
+// for the data of a pending source to its application again, e.g. when the original
+// message was never delivered.
+func (i *Internal) RepublishSource(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	sid := chi.URLParam(r, "sourceUUID")
+	sourceUUID, err := uuid.Parse(sid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid resource UUID", sid))
+		return
+	}
+
+	logger := i.Log.With(
+		export_logger.RequestIDField(reqID),
+		export_logger.ExportIDField(uid),
+		"source_id", sid,
+		"psk_id", middleware.GetPSKID(r.Context()),
+	)
+
+	payload, err := i.DB.WithContext(r.Context()).Get(exportUUID)
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		default:
+			logger.Errorw("error querying for payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	_, source, err := payload.GetSource(sourceUUID)
+	if err != nil {
+		NotFoundError(w, fmt.Sprintf("source '%s' not found", sourceUUID))
+		return
+	}
+
+	if source.Status != models.RPending {
+		logger.Warnw("refused to announce a source that is not pending", "current_status", source.Status)
+		ConflictError(w, fmt.Sprintf("source '%s' is already '%s'", sourceUUID, source.Status))
+		return
+	}
+	if payload.Identity == "" {
+		// exports created before the identity was stored can not be announced again
+		ConflictError(w, fmt.Sprintf("the identity of the requester of '%s' was not recorded", exportUUID))
+		return
+	}
+
+	logger.Warnw("announcing source again", "org_id", payload.OrganizationID, "application", source.Application)
+
+	if err := i.Announce(r.Context(), logger, payload.Identity, *payload, *source); err != nil {
+		logger.Errorw("failed to announce source", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	apiExport := DBExportToAdminAPI(*payload)
+	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// RepublishExport handles POST requests to the private /exports/{exportUUID}/announce
+// endpoint. It sends the requests for the data of every pending source of a stuck
+// export to their applications again.
+func (i *Internal) RepublishExport(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	logger := i.Log.With(
+		export_logger.RequestIDField(reqID),
+		export_logger.ExportIDField(uid),
+		"psk_id", middleware.GetPSKID(r.Context()),
+	)
+
+	payload, err := i.DB.WithContext(r.Context()).Get(exportUUID)
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		default:
+			logger.Errorw("error querying for payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	logger.Warnw("announcing the pending sources again", "org_id", payload.OrganizationID)
+
+	announced, err := AnnouncePendingSources(r.Context(), i.Announce, logger, *payload)
+	switch {
+	case errors.Is(err, ErrExportNotWaiting), errors.Is(err, ErrNoPendingSources), errors.Is(err, ErrIdentityNotRecorded):
+		logger.Warnw("refused to announce the export", "error", err)
+		ConflictError(w, fmt.Sprintf("export '%s' can not be announced: %s", exportUUID, err))
+		return
+	case err != nil:
+		logger.Errorw("failed to announce the pending sources", "announced", len(announced), "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	apiExport := DBExportToAdminAPI(*payload)
+	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// GetOrgStorage handles GET requests to the private /orgs/{orgID}/storage endpoint.
+func (i *Internal) GetOrgStorage(w http.ResponseWriter, r *http.Request) {
+	orgID := chi.URLParam(r, "orgID")
+	logger := i.Log.With(export_logger.RequestIDField(request_id.GetReqID(r.Context())), export_logger.OrgIDField(orgID))
+
+	usage, err := i.DB.WithContext(r.Context()).GetOrgStorage(orgID)
+	if err != nil {
+		logger.Errorw("error querying for the org storage", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	i.writeOrgStorage(w, logger, usage)
+}
+
+// PutOrgQuota handles PUT requests to the private /orgs/{orgID}/storage endpoint,
+// overriding the storage quota of the organization.
+func (i *Internal) PutOrgQuota(w http.ResponseWriter, r *http.Request) {
+	orgID := chi.URLParam(r, "orgID")
+	logger := i.Log.With(
+		export_logger.RequestIDField(request_id.GetReqID(r.Context())),
+		export_logger.OrgIDField(orgID),
+		"psk_id", middleware.GetPSKID(r.Context()),
+	)
+
+	var quota OrgQuota
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	if quota.QuotaBytes != nil && *quota.QuotaBytes < 0 {
+		BadRequestError(w, "the quota can not be negative")
+		return
+	}
+
+	logger.Warnw("overriding the storage quota of the org", "quota_bytes", quota.QuotaBytes)
+
+	usage, err := i.DB.WithContext(r.Context()).SetOrgQuota(orgID, quota.QuotaBytes)
+	if err != nil {
+		logger.Errorw("failed to set the org quota", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	i.writeOrgStorage(w, logger, usage)
+}
+
+func (i *Internal) writeOrgStorage(w http.ResponseWriter, logger *zap.SugaredLogger, usage models.OrgStorage) {
+	resp := OrgStorage{
+		OrganizationID: usage.OrganizationID,
+		StorageUsage: StorageUsage{
+			UsedBytes:  usage.StoredBytes,
+			QuotaBytes: usage.Quota(i.Cfg.OrgStorageQuotaBytes),
+		},
+		QuotaOverride: usage.QuotaBytes,
+	}
+	if !usage.UpdatedAt.IsZero() {
+		updatedAt := usage.UpdatedAt.UTC()
+		resp.UpdatedAt = &updatedAt
+	}
+
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+var (
+	// ErrExportNotWaiting is returned when the sources of an export that is no longer
+	// waiting for its applications are announced again.
+	ErrExportNotWaiting = errors.New("the export is not waiting for its sources")
+	// ErrNoPendingSources is returned when every source of the export was resolved.
+	ErrNoPendingSources = errors.New("the export has no pending source")
+	// ErrIdentityNotRecorded is returned for the exports created before the identity
+	// of their requester was stored, their sources can not be announced again.
+	ErrIdentityNotRecorded = errors.New("the identity of the requester was not recorded")
+)
+
+// AnnouncePendingSources sends the requests for the data of every pending source of
+// the export to their applications again, e.g. when the original messages were lost
+// by the consumers. The sources announced before a failure are returned with the
+// error.
+func AnnouncePendingSources(ctx context.Context, announce AnnounceSource, log *zap.SugaredLogger, payload models.ExportPayload) ([]models.Source, error) {
+	if payload.Status != models.Pending && payload.Status != models.Running {
+		return nil, fmt.Errorf("%w: it is '%s'", ErrExportNotWaiting, payload.Status)
+	}
+	if payload.Identity == "" {
+		return nil, ErrIdentityNotRecorded
+	}
+
+	var pending []models.Source
+	for _, source := range payload.Sources {
+		if source.Status == models.RPending {
+			pending = append(pending, source)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, ErrNoPendingSources
+	}
+
+	var announced []models.Source
+	for _, source := range pending {
+		logger := log.With("source_id", source.ID, "application", source.Application)
+		if err := announce(ctx, logger, payload.Identity, payload, source); err != nil {
+			return announced, fmt.Errorf("failed to announce source '%s': %w", source.ID, err)
+		}
+		logger.Warnw("announced source again")
+		announced = append(announced, source)
+	}
+	return announced, nil
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
+
+	"github.com/redhatinsights/export-service-go/models"
 )
 
 type ExportPayload struct {
@@ -12,22 +14,415 @@ type ExportPayload struct {
 	CreatedAt   time.Time  `json:"created_at"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 	Expires     *time.Time `json:"expires_at,omitempty"`
-	Name        string     `json:"name"`
-	Format      string     `json:"format"`
-	Status      string     `json:"status"`
+	Name        string     `json:"name" description:"The name of the export, its {date}, {datetime} and {application} placeholders are expanded when the export is created"`
+	Format      string     `json:"format" enum:"json,csv"`
+	Status      string     `json:"status" enum:"partial,complete_with_errors,pending,running,packaging,complete,failed,cancelled" description:"The exports some of whose sources failed are complete_with_errors, partial in the version 1 of the api"`
 	Sources     []Source   `json:"sources"`
+	// Failures are the sources that failed, they are listed in the failures.json of the
+	// archive too
+	Failures []models.SourceFailure `json:"failures,omitempty" description:"The sources that failed, along with their error"`
+	// ArchiveFormat defaults to the one configured for the service
+	ArchiveFormat string `json:"archive_format,omitempty" enum:"zip,tar.gz,tar.zst" description:"The format of the archive of the export, the default of the service when omitted"`
+	// ExpiresInDays is only read from the requests, the exports return their expires_at
+	ExpiresInDays *int `json:"expires_in_days,omitempty" description:"The number of days from its creation the export expires in, rather than the default of the service, exclusive with expires_at"`
+	// NotificationURL receives a signed webhook once the export finishes
+	NotificationURL string              `json:"notification_url,omitempty" description:"An https url, on an allowed domain, that receives a signed webhook once the export finishes"`
+	Notification    *NotificationStatus `json:"notification,omitempty"`
+
+	// RecordCount is advisory, the applications may not report the counts of their
+	// sources
+	RecordCount        *int64 `json:"record_count,omitempty" description:"The number of records reported by the applications of the sources, advisory"`
+	RecordCountPartial bool   `json:"record_count_partial,omitempty" description:"Some of the sources that did not fail have not reported their number of records, the record_count is a lower bound"`
+
+	DownloadCount    int64      `json:"download_count" description:"The number of downloads of the export and of its sources"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at,omitempty"`
+
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Request is only included when asked for with `include=request`
+	Request *ExportRequest `json:"request,omitempty" description:"The normalized request the export was created from, only included with include=request"`
+}
+
+// ExportRequest is the normalized request an export was created from: the defaults
+// are applied, so that the export can be reproduced exactly.
+type ExportRequest struct {
+	Name            string            `json:"name"`
+	Format          string            `json:"format" enum:"json,csv"`
+	ArchiveFormat   string            `json:"archive_format" enum:"zip,tar.gz,tar.zst"`
+	Expires         *time.Time        `json:"expires_at,omitempty"`
+	Sources         []RequestedSource `json:"sources"`
+	NotificationURL string            `json:"notification_url,omitempty"`
+	Encryption      *Encryption       `json:"encryption,omitempty"`
+}
+
+// RequestedSource is a source of the request an export was created from.
+type RequestedSource struct {
+	Application string         `json:"application"`
+	Resource    string         `json:"resource"`
+	Format      string         `json:"format" enum:"json,csv"`
+	Filters     datatypes.JSON `json:"filters"`
+}
+
+// Encryption requests the archive of an export to be encrypted to a key of the
+// requester, so that only the holder of the private key can read it.
+type Encryption struct {
+	PublicKey   string `json:"public_key,omitempty" description:"An armored OpenPGP public key the archive is encrypted to, it is not returned"`
+	Fingerprint string `json:"fingerprint,omitempty" description:"The fingerprint of the public key the archive is encrypted to"`
+}
+
+// ExportPayloadV2 is the view of an export in the version 2 of the public api, which
+// embeds the links of the export and of its sources.
+type ExportPayloadV2 struct {
+	ExportPayload
+	Sources []SourceV2  `json:"sources"`
+	Links   ExportLinks `json:"links"`
+}
+
+// APIExportV2 is an export listed by the version 2 of the public api.
+type APIExportV2 struct {
+	models.APIExport
+	Links ExportLinks `json:"links"`
+}
+
+// ExportLinks are the absolute urls of an export.
+type ExportLinks struct {
+	Self     string `json:"self" description:"The export, which is removed with a DELETE request"`
+	Status   string `json:"status" description:"The status of the export"`
+	Download string `json:"download,omitempty" description:"The archive of the export, only set once the export is complete or partial"`
+}
+
+// SourceV2 is a source in the version 2 of the public api.
+type SourceV2 struct {
+	Source
+	Links SourceLinks `json:"links"`
+}
+
+type SourceLinks struct {
+	Download string `json:"download,omitempty" description:"The payload of the source on its own, only set for complete sources that are still available"`
+}
+
+// NotificationStatus reports the delivery of the webhook requested for an export.
+type NotificationStatus struct {
+	Status      string     `json:"status" enum:"pending,delivered,failed"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// AdminExportPayload is the view of an export returned by the private api. Along with
+// the public fields it includes the owner of the export and internal details.
+type AdminExportPayload struct {
+	ExportPayload
+	UpdatedAt      time.Time `json:"updated_at"`
+	RequestID      string    `json:"request_id"`
+	S3Key          string    `json:"s3_key,omitempty"`
+	AccountID      string    `json:"account_id,omitempty"`
+	OrganizationID string    `json:"org_id"`
+	Username       string    `json:"username" description:"The requester of the export, the username of a user, the client id of a service account or the common name of a system certificate"`
+	IdentityType   string    `json:"identity_type" enum:"User,ServiceAccount,System"`
+	// Sources replaces the sources of the embedded ExportPayload with their
+	// announce status
+	Sources []AdminSource `json:"sources"`
+	// Downloads is only included when a single export is requested
+	Downloads []AdminDownload `json:"downloads,omitempty" description:"The downloads of the export, the latest first. Only included when a single export is requested."`
+}
+
+// AdminDownload is a download of an export, or of one of its sources, in the private
+// api.
+type AdminDownload struct {
+	DownloadedAt   time.Time  `json:"downloaded_at"`
+	SourceID       *uuid.UUID `json:"source_id,omitempty" description:"The source whose payload was downloaded, omitted for the archive of the export"`
+	AccountID      string     `json:"account_id,omitempty"`
+	OrganizationID string     `json:"org_id"`
+	Username       string     `json:"username"`
+	IdentityType   string     `json:"identity_type" enum:"User,ServiceAccount,System"`
+	Bytes          int64      `json:"bytes" description:"The bytes sent to the user, 0 for a presigned redirect"`
+	Presigned      bool       `json:"presigned" description:"The user was redirected to a presigned url, the redirect is counted as the download"`
+}
+
+// AdminSource is a source along with the delivery status of the message announcing
+// it to its application.
+type AdminSource struct {
+	Source
+	AnnouncedAt   *time.Time `json:"announced_at" description:"When kafka last confirmed the delivery of the message announcing the source"`
+	DeliveryError string     `json:"delivery_error,omitempty" description:"The last error reported while delivering the message announcing the source"`
+}
+
+// Summary describes the exports of an organization.
+type Summary struct {
+	Storage StorageUsage `json:"storage"`
+	Exports ExportsUsage `json:"exports"`
+}
+
+// StorageUsage is the storage the exports of an organization use. A quota of 0 is
+// unlimited.
+type StorageUsage struct {
+	UsedBytes  int64 `json:"used_bytes" description:"The bytes the exports of the organization keep in storage"`
+	QuotaBytes int64 `json:"quota_bytes" description:"The bytes the exports of the organization may keep in storage, 0 is unlimited"`
+}
+
+// ExportsUsage is the number of exports of an organization and its quotas on them. A
+// quota of 0 is unlimited.
+type ExportsUsage struct {
+	Exports            int64 `json:"exports" description:"The exports of the organization that have not expired"`
+	MaxExports         int64 `json:"max_exports" description:"The exports the organization may have that have not expired, 0 is unlimited"`
+	InFlightExports    int64 `json:"in_flight_exports" description:"The exports of the organization that are pending, running or packaging"`
+	MaxInFlightExports int64 `json:"max_in_flight_exports" description:"The exports the organization may have pending, running or packaging at once, 0 is unlimited"`
+}
+
+// ExportQuotaError is the body of the 403 of an export request by an organization
+// that reached one of its quotas on the number of exports.
+type ExportQuotaError struct {
+	Msg   string       `json:"message"`
+	Code  int          `json:"code"`
+	Quota string       `json:"quota" enum:"exports,in_flight_exports" description:"The quota the export would exceed"`
+	Usage ExportsUsage `json:"usage"`
+}
+
+// OrgStorage is the private view of the storage of an organization.
+type OrgStorage struct {
+	OrganizationID string `json:"org_id"`
+	StorageUsage
+	// QuotaOverride is the quota set for the organization in place of the
+	// configured default
+	QuotaOverride *int64     `json:"quota_override" description:"The quota set for the organization in place of the configured default"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// OrgQuota is the body of a request overriding the quota of an organization. A
+// null quota restores the configured default.
+type OrgQuota struct {
+	QuotaBytes *int64 `json:"quota_bytes" description:"The quota of the organization, 0 is unlimited and null restores the configured ORG_STORAGE_QUOTA_BYTES"`
+}
+
+// FaultRequest is the body of a request injecting a fault, only available with
+// DEBUG=true.
+type FaultRequest struct {
+	Kind        string     `json:"kind" enum:"announce_delay,announce_drop,upload_error,packaging_error"`
+	Application string     `json:"application,omitempty" description:"The application whose announces are delayed or dropped, or whose next upload fails"`
+	ExportID    *uuid.UUID `json:"export_id,omitempty" description:"The export whose packaging fails once"`
+	Delay       string     `json:"delay,omitempty" description:"How long the announces are delayed, e.g. 30s"`
+	TTL         string     `json:"ttl" description:"How long the fault is kept, e.g. 10m, at most 24h"`
+}
+
+// InjectedFault is a fault injected into the replica that served the request.
+type InjectedFault struct {
+	ID          uuid.UUID  `json:"id"`
+	Kind        string     `json:"kind" enum:"announce_delay,announce_drop,upload_error,packaging_error"`
+	Application string     `json:"application,omitempty"`
+	ExportID    *uuid.UUID `json:"export_id,omitempty"`
+	Delay       string     `json:"delay,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// PSK describes a psk of the internal api, so that the operators rotating it can
+// tell when the previous one is no longer used.
+type PSK struct {
+	ID           string     `json:"id" description:"The first 8 hex characters of the sha-256 of the psk, as logged by the service"`
+	Applications []string   `json:"applications,omitempty" description:"The applications the psk is scoped to, every application when empty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" description:"When the psk stops being accepted"`
+	Expired      bool       `json:"expired"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" description:"When the psk last authenticated a request, recorded at most once a minute by each replica"`
+	// Current is the psk that authenticated the request
+	Current bool `json:"current" description:"Whether the psk authenticated this request"`
+}
+
+// AuditEvent is an action taken on an export, as recorded in the audit trail.
+type AuditEvent struct {
+	ID             int64      `json:"id"`
+	OccurredAt     time.Time  `json:"occurred_at"`
+	Action         string     `json:"action" enum:"create,download,delete,cancel,restore,retry"`
+	Outcome        string     `json:"outcome" enum:"success,denied,failure"`
+	StatusCode     int        `json:"status_code" description:"The status of the response to the request of the action"`
+	ExportID       *uuid.UUID `json:"export_id,omitempty" description:"Omitted for the actions that failed before their export was known"`
+	SourceID       *uuid.UUID `json:"source_id,omitempty" description:"The source the action was taken on, omitted for the whole export"`
+	AccountID      string     `json:"account_id,omitempty"`
+	OrganizationID string     `json:"org_id,omitempty"`
+	Username       string     `json:"username,omitempty"`
+	IdentityType   string     `json:"identity_type,omitempty" enum:"User,ServiceAccount,System"`
+	Client         string     `json:"client,omitempty" description:"The psk id or service account of the requests to the internal api"`
+	SourceIP       string     `json:"source_ip"`
+	RequestID      string     `json:"request_id,omitempty"`
 }
 
 type Source struct {
 	ID          uuid.UUID      `json:"id"`
 	Application string         `json:"application"`
-	Status      string         `json:"status"`
+	Status      string         `json:"status" enum:"pending,success,failed,cancelled"`
 	Resource    string         `json:"resource"`
-	Filters     datatypes.JSON `json:"filters"`
+	Format      string         `json:"format,omitempty" enum:"json,csv" description:"The format of the source, the format of the export when omitted"`
+	Filters     datatypes.JSON `json:"filters" description:"Application specific filters of the exported data"`
+	RecordCount *int64         `json:"record_count,omitempty" description:"The number of records of the payload, as reported by the application"`
+	Checksum    string         `json:"checksum,omitempty" description:"The hex encoded sha-256 checksum of the payload, verified against the Digest the application sent with it"`
+	// DownloadHref is where the payload of a completed source can be downloaded on
+	// its own
+	DownloadHref string `json:"download_href,omitempty" description:"Where the payload of the source can be downloaded on its own, only set for complete sources that are still available"`
 	SourceError
 }
 
+// SourceStatus is the status of a single source, with the details of its delivery
+// and upload that help the developers of its application debug their integration.
+type SourceStatus struct {
+	Source
+	ExportID      uuid.UUID  `json:"export_id"`
+	Size          int64      `json:"size" description:"The size in bytes of the uploaded payload, 0 until it is uploaded"`
+	DeliveryError string     `json:"delivery_error,omitempty" description:"The last error delivering the request for the data of the source to its application"`
+	RequestedAt   time.Time  `json:"requested_at" description:"When the export of the source was requested"`
+	AnnouncedAt   *time.Time `json:"announced_at,omitempty" description:"When the request for the data of the source was delivered to its application"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty" description:"When the application reported the final status of the source"`
+}
+
 type SourceError struct {
+	Message *string `json:"message,omitempty" description:"A human-readable message describing the error"`
+	Code    *int    `json:"error,omitempty" description:"The http status code of the error"`
+	// Retriable is nil when the application did not tell
+	Retriable *bool `json:"retriable,omitempty" description:"Whether the source may succeed in a new export, e.g. once a transient outage of its application is over"`
+}
+
+// MultipartUpload is a multipart upload of the payload of a source.
+type MultipartUpload struct {
+	UploadID     string `json:"upload_id" description:"The id of the upload in the paths of its parts"`
+	MinPartBytes int64  `json:"min_part_bytes" description:"The size every part but the last must have at least"`
+	MaxPartBytes int64  `json:"max_part_bytes" description:"The size a part may have at most, 0 is unlimited"`
+}
+
+// UploadedPart is a stored part of a multipart upload.
+type UploadedPart struct {
+	PartNumber int   `json:"part_number"`
+	Size       int64 `json:"size"`
+}
+
+// ResolveSource is the body of a request to force the final status of a source that
+// will never be delivered by its application.
+type ResolveSource struct {
+	// Status is either `failed` or `complete`
+	Status  string  `json:"status" enum:"failed,complete"`
 	Message *string `json:"message,omitempty"`
-	Code    *int    `json:"error,omitempty"`
+	Code    *int    `json:"error,omitempty" description:"The error code of the source, required when the status is failed"`
+}
+
+// Schedule creates an export from its template at every run.
+type Schedule struct {
+	ID           uuid.UUID      `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Frequency    string         `json:"frequency" enum:"daily,weekly"`
+	Hour         int            `json:"hour" description:"The hour of the day, in UTC, of the runs"`
+	Weekday      string         `json:"weekday,omitempty" enum:"sunday,monday,tuesday,wednesday,thursday,friday,saturday" description:"The day of the weekly runs"`
+	Enabled      *bool          `json:"enabled" description:"Disabled schedules do not run, schedules are enabled when omitted"`
+	Export       ExportTemplate `json:"export" description:"The export created at every run"`
+	LastRun      *time.Time     `json:"last_run" description:"When the schedule last ran"`
+	NextRun      *time.Time     `json:"next_run" description:"When the schedule runs next, null when it is disabled"`
+	LastExportID *uuid.UUID     `json:"last_export_id,omitempty" description:"The export created by the latest run"`
+}
+
+// ExportTemplate is the export created at every run of a schedule.
+type ExportTemplate struct {
+	Name            string           `json:"name"`
+	Format          string           `json:"format" enum:"json,csv"`
+	Sources         []TemplateSource `json:"sources"`
+	NotificationURL string           `json:"notification_url,omitempty" description:"An https url, on an allowed domain, that receives a signed webhook once each export finishes"`
+	ExpiresInDays   *int             `json:"expires_in_days,omitempty" description:"The number of days from its creation each export expires in, rather than the default of the service"`
+}
+
+type TemplateSource struct {
+	Application string         `json:"application"`
+	Resource    string         `json:"resource"`
+	Format      string         `json:"format,omitempty" enum:"json,csv" description:"The format of the source, the format of the export when omitted"`
+	Filters     datatypes.JSON `json:"filters" description:"Application specific filters of the exported data"`
+}
+
+// Limits bound the requests to the public api, a limit of 0 is disabled.
+type Limits struct {
+	MaxSourcesPerExport int   `json:"max_sources_per_export" description:"The number of sources an export may have"`
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes" description:"The size of the body of a request"`
+	MaxFiltersBytes     int   `json:"max_filters_bytes" description:"The size of the json encoded filters of a source"`
+	// MaxStoredRequestBytes bounds the request once normalized, as it is kept with
+	// the export
+	MaxStoredRequestBytes int `json:"max_stored_request_bytes" description:"The size of the json encoded request, once normalized"`
+	// MaxExpiryDays bounds both expires_in_days and expires_at
+	MaxExpiryDays int `json:"max_expiry_days" description:"The number of days from its creation an export may request to expire in"`
+}
+
+// ApplicationPolicy is the effective policy of the sources of an application.
+type ApplicationPolicy struct {
+	Application    string   `json:"application"`
+	MaxUploadBytes int64    `json:"max_upload_bytes" description:"The size of the payload of a source, 0 is unlimited"`
+	MaxExportBytes int64    `json:"max_export_bytes" description:"The size of the payloads of all the sources of an export, 0 is unlimited"`
+	AllowedFormats []string `json:"allowed_formats" description:"The formats the sources may be exported in"`
+	// SourceTimeoutSeconds is the timeout of the application, or the one of the service
+	SourceTimeoutSeconds int64 `json:"source_timeout_seconds" description:"How long a source may stay pending before it fails with a timed_out error, 0 is never"`
+}
+
+// CleanupReport describes a run of the cleanup.
+type CleanupReport struct {
+	DryRun          bool            `json:"dry_run"`
+	StartedAt       time.Time       `json:"started_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	ExpiredExports  []CleanupExport `json:"expired_exports" description:"The expired exports that were deleted, or would be deleted by a dry run"`
+	SourceObjects   []CleanupExport `json:"source_objects" description:"The packaged exports whose source objects were deleted, or would be deleted by a dry run"`
+	DeletedObjects  int             `json:"deleted_objects" description:"The number of objects removed from the bucket"`
+	Errors          []string        `json:"errors,omitempty"`
+
+	ForgottenCancelledExports int64 `json:"forgotten_cancelled_exports" description:"The number of cancelled exports forgotten past their retention, they are kept by a dry run"`
+	// DroppedPartitions are named after their month, e.g. y2022m05
+	DroppedPartitions []string `json:"dropped_partitions" description:"The months whose exports all expired, their partitions were dropped, or would be dropped by a dry run, along with their exports"`
+	// PurgedExports are the deleted exports past DELETED_EXPORT_RETENTION
+	PurgedExports []CleanupExport `json:"purged_exports" description:"The deleted exports that were purged along with their objects, or would be purged by a dry run, they can no longer be restored"`
+	Skipped       bool            `json:"skipped,omitempty" description:"The cleanup was skipped, another replica or the cleaner job was cleaning up"`
+}
+
+type CleanupExport struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID string     `json:"org_id"`
+	Expires        *time.Time `json:"expires_at,omitempty"`
+	StoredBytes    int64      `json:"stored_bytes"`
+}
+
+// CleanupStatus describes the cleanups of the replica serving the request.
+type CleanupStatus struct {
+	Running bool           `json:"running"`
+	LastRun *CleanupReport `json:"last_run" description:"The last cleanup since the replica started"`
+}
+
+// BulkDelete selects the exports deleted by DELETE /exports, either by their ids or by
+// the filters of the export list.
+type BulkDelete struct {
+	IDs    []string          `json:"ids,omitempty" description:"The ids of the exports to delete"`
+	Filter *BulkDeleteFilter `json:"filter,omitempty" description:"Deletes the exports matching the filters, at least one of which must be set"`
+}
+
+// BulkDeleteFilter holds the filters of the export list, with the same formats.
+type BulkDeleteFilter struct {
+	Name         string `json:"name,omitempty"`
+	Status       string `json:"status,omitempty" enum:"partial,pending,running,complete,failed,cancelled"`
+	Application  string `json:"application,omitempty"`
+	Resource     string `json:"resource,omitempty"`
+	CreatedAt    string `json:"created_at,omitempty"`
+	CreatedAtGTE string `json:"created_at_gte,omitempty"`
+	CreatedAtLTE string `json:"created_at_lte,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	ExpiresAtGTE string `json:"expires_at_gte,omitempty"`
+	ExpiresAtLTE string `json:"expires_at_lte,omitempty"`
+}
+
+// BulkDeleteResult lists the deleted exports, and the ids that could not be deleted
+// with their error.
+type BulkDeleteResult struct {
+	Deleted []uuid.UUID          `json:"deleted" description:"The ids of the deleted exports"`
+	Errors  []ExportStatusResult `json:"errors" description:"The ids that could not be deleted, with their error"`
+	More    bool                 `json:"more" description:"More exports may match the filter, the request can be repeated to delete them"`
+}
+
+// ExportStatusBatch is the status of the exports requested from /exports/status, in
+// the order of their ids.
+type ExportStatusBatch struct {
+	Data []ExportStatusResult `json:"data"`
+}
+
+// ExportStatusResult is either the export of an id or the error that kept it from
+// being returned.
+type ExportStatusResult struct {
+	ID     string      `json:"id"`
+	Export interface{} `json:"export,omitempty"`
+	Error  *Error      `json:"error,omitempty"`
 }
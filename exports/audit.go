@@ -0,0 +1,204 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.uber.org/zap"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+type auditCtxKeyType int
+
+const auditCtxKey auditCtxKeyType = iota
+
+// auditedExports collects the exports of an audited request whose ids are not in its
+// path.
+type auditedExports struct {
+	ids []uuid.UUID
+}
+
+// auditExports adds the exports to the audit events of the request, for the actions
+// whose exports are not in the path of their request, e.g. the creation of an export.
+func auditExports(r *http.Request, exportIDs ...uuid.UUID) {
+	if audited, ok := r.Context().Value(auditCtxKey).(*auditedExports); ok {
+		audited.ids = append(audited.ids, exportIDs...)
+	}
+}
+
+// auditTrail is a middleware recording the action of the requests in the audit trail
+// once they are handled, one event for each export the action was taken on. The event
+// is recorded before the request completes, and the failure to record it is logged
+// with the event so that it is not lost.
+func auditTrail(db models.DBInterface, log *zap.SugaredLogger, trustedProxies int, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			audited := &auditedExports{}
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditCtxKey, audited)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			event := models.AuditEvent{
+				OccurredAt: time.Now(),
+				Action:     action,
+				Outcome:    auditOutcome(status),
+				StatusCode: status,
+				SourceID:   parseAuditedID(chi.URLParam(r, "sourceUUID")),
+				Client:     middleware.GetPSKID(r.Context()),
+				SourceIP:   middleware.ClientIP(r, trustedProxies),
+				RequestID:  request_id.GetReqID(r.Context()),
+			}
+			if user, ok := r.Context().Value(middleware.UserIdentityKey).(middleware.User); ok {
+				event.User = mapUsertoModelUser(user)
+			}
+
+			exportIDs := audited.ids
+			if exportID := parseAuditedID(chi.URLParam(r, "exportUUID")); exportID != nil {
+				exportIDs = append([]uuid.UUID{*exportID}, exportIDs...)
+			}
+			events := []models.AuditEvent{}
+			for i := range exportIDs {
+				exportEvent := event
+				exportEvent.ExportID = &exportIDs[i]
+				events = append(events, exportEvent)
+			}
+			if len(events) == 0 {
+				events = append(events, event)
+			}
+
+			if err := db.RecordAuditEvents(events); err != nil {
+				log.Errorw("failed to record the audit events",
+					export_logger.RequestIDField(event.RequestID),
+					"error", err,
+					"action", event.Action,
+					"outcome", event.Outcome,
+					"export_ids", exportIDs,
+					"org_id", event.OrganizationID,
+					"username", event.Username,
+					"client", event.Client,
+					"source_ip", event.SourceIP,
+				)
+			}
+		})
+	}
+}
+
+// parseAuditedID returns the id of the path parameter, nil when it is missing or
+// invalid.
+func parseAuditedID(param string) *uuid.UUID {
+	id, err := uuid.Parse(param)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// auditOutcome returns the outcome of the action whose response has the status.
+func auditOutcome(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return models.AuditSuccess
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return models.AuditDenied
+	default:
+		return models.AuditFailure
+	}
+}
+
+func (e *Export) audit(action string) func(http.Handler) http.Handler {
+	return auditTrail(e.DB, e.Log, e.Cfg.TrustedProxies, action)
+}
+
+func (i *Internal) audit(action string) func(http.Handler) http.Handler {
+	// the applications call the private port without a proxy in between
+	return auditTrail(i.DB, i.Log, 0, action)
+}
+
+// ListAuditEvents handles GET requests to the /audit endpoint, listing the events of
+// the audit trail matching the query, the latest first.
+func (i *Internal) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	page := middleware.GetPagination(r.Context())
+	logger := i.Log.With(export_logger.RequestIDField(request_id.GetReqID(r.Context())))
+
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		OrganizationID: q.Get("org_id"),
+		Username:       q.Get("username"),
+		Client:         q.Get("client"),
+		Action:         q.Get("action"),
+		Outcome:        q.Get("outcome"),
+	}
+	if exportID := q.Get("export_id"); exportID != "" {
+		if filter.ExportID = parseAuditedID(exportID); filter.ExportID == nil {
+			BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", exportID))
+			return
+		}
+	}
+	for param, bound := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := q.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				BadRequestError(w, fmt.Sprintf("invalid %s: '%s' is not a RFC 3339 date-time", param, value))
+				return
+			}
+			*bound = &t
+		}
+	}
+
+	events, count, err := i.DB.WithContext(r.Context()).ListAuditEvents(filter, page.Offset, page.Limit)
+	if err != nil {
+		logger.Errorw("failed to list the audit events", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	data := []AuditEvent{}
+	for _, event := range events {
+		data = append(data, DBAuditEventToAPI(event))
+	}
+	resp, err := middleware.GetPaginatedResponse(r.URL, page, count, data)
+	if err != nil {
+		logger.Errorw("error while paginating data", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+func DBAuditEventToAPI(event models.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:             event.ID,
+		OccurredAt:     event.OccurredAt,
+		Action:         event.Action,
+		Outcome:        event.Outcome,
+		StatusCode:     event.StatusCode,
+		ExportID:       event.ExportID,
+		SourceID:       event.SourceID,
+		AccountID:      event.AccountID,
+		OrganizationID: event.OrganizationID,
+		Username:       event.Username,
+		IdentityType:   event.IdentityType,
+		Client:         event.Client,
+		SourceIP:       event.SourceIP,
+		RequestID:      event.RequestID,
+	}
+}
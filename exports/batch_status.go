@@ -0,0 +1,119 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// maxBatchStatusIDs is the number of exports whose status can be requested at once.
+const maxBatchStatusIDs = 100
+
+// GetExportsStatus handles GET requests to the /exports/status endpoint, returning the
+// status of each export of the comma separated `ids`. The exports that can not be
+// returned carry their error in place of the export, rather than failing the batch.
+// The response has an ETag, so that an unchanged batch gets a 304.
+func (e *Export) GetExportsStatus(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+	modelUser := mapUsertoModelUser(user)
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		BadRequestError(w, "ids must list the ids of the exports, separated by commas")
+		return
+	}
+	if len(ids) > maxBatchStatusIDs {
+		BadRequestError(w, fmt.Sprintf("the status of at most %d exports can be requested at once, got %d", maxBatchStatusIDs, len(ids)))
+		return
+	}
+
+	var exportUUIDs []uuid.UUID
+	for _, id := range ids {
+		if exportUUID, err := uuid.Parse(id); err == nil {
+			exportUUIDs = append(exportUUIDs, exportUUID)
+		}
+	}
+	exports := map[uuid.UUID]*models.ExportPayload{}
+	if len(exportUUIDs) > 0 {
+		found, err := e.DB.WithContext(r.Context()).GetManyWithOrgFromReplica(exportUUIDs, user.OrganizationID)
+		if err != nil {
+			logger.Errorw("error querying for payload entries", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+		for _, export := range found {
+			exports[export.ID] = export
+		}
+	}
+
+	s := getSerializer(r)
+	batch := ExportStatusBatch{Data: make([]ExportStatusResult, 0, len(ids))}
+	for _, id := range ids {
+		result := ExportStatusResult{ID: id}
+		exportUUID, err := uuid.Parse(id)
+		export := exports[exportUUID]
+		switch {
+		case err != nil:
+			result.Error = &Error{Msg: fmt.Sprintf("'%s' is not a valid export UUID", id), Code: http.StatusBadRequest}
+		case export == nil:
+			result.Error = &Error{Msg: fmt.Sprintf("record '%s' not found", id), Code: http.StatusNotFound}
+		case !export.IsOwnedBy(modelUser):
+			// the export belongs to another user of the organization
+			result.Error = &Error{Msg: fmt.Sprintf("record '%s' belongs to another user", id), Code: http.StatusForbidden}
+		default:
+			result.Export = s.export(r, *export)
+		}
+		batch.Data = append(batch.Data, result)
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(batch); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+		InternalServerError(w, err.Error())
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body.Bytes()))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	Logerr(w.Write(body.Bytes()))
+}
+
+// etagMatches reports whether the If-None-Match header lists the etag. The weak
+// comparison is used, as for any GET request.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+// maxBulkDeleteExports is the number of exports deleted by a request at most.
+const maxBulkDeleteExports = 1000
+
+// DeleteExports handles DELETE requests to the /exports endpoint, deleting the exports
+// of the ids of the body, or those matching its filter. Their objects are removed once
+// they are purged. The ids that can not be deleted carry their error rather than
+// failing the request.
+func (e *Export) DeleteExports(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+	modelUser := mapUsertoModelUser(user)
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	var bulk BulkDelete
+	if err := json.NewDecoder(r.Body).Decode(&bulk); err != nil {
+		decodeError(w, err)
+		return
+	}
+
+	var ids []string
+	var exportUUIDs []uuid.UUID
+	var params models.QueryParams
+	switch {
+	case len(bulk.IDs) > 0 && bulk.Filter != nil:
+		BadRequestError(w, "either ids or a filter can be given, not both")
+		return
+	case len(bulk.IDs) > 0:
+		seen := map[string]bool{}
+		for _, id := range bulk.IDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > maxBulkDeleteExports {
+			BadRequestError(w, fmt.Sprintf("at most %d exports can be deleted at once, got %d", maxBulkDeleteExports, len(ids)))
+			return
+		}
+		for _, id := range ids {
+			if exportUUID, err := uuid.Parse(id); err == nil {
+				exportUUIDs = append(exportUUIDs, exportUUID)
+			}
+		}
+	case bulk.Filter != nil:
+		values := bulk.Filter.values()
+		if len(values) == 0 {
+			BadRequestError(w, "the filter must set at least one field, it would delete every export")
+			return
+		}
+		var err error
+		if params, err = initQuery(values); err != nil {
+			BadRequestError(w, err.Error())
+			return
+		}
+	default:
+		BadRequestError(w, "ids or a filter is required")
+		return
+	}
+
+	result := BulkDeleteResult{Deleted: []uuid.UUID{}, Errors: []ExportStatusResult{}}
+	var deleted []models.ExportPayload
+	if len(ids) == 0 || len(exportUUIDs) > 0 {
+		var err error
+		deleted, err = e.DB.WithContext(r.Context()).DeleteMany(modelUser, exportUUIDs, &params, maxBulkDeleteExports)
+		if err != nil {
+			logger.Errorw("error deleting payload entries", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+	for _, export := range deleted {
+		result.Deleted = append(result.Deleted, export.ID)
+	}
+	auditExports(r, result.Deleted...)
+	result.More = len(ids) == 0 && len(deleted) == maxBulkDeleteExports
+
+	if len(ids) > 0 && len(deleted) < len(ids) {
+		results, err := e.bulkDeleteErrors(r, ids, deleted, modelUser)
+		if err != nil {
+			logger.Errorw("error querying for payload entries", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+		result.Errors = results
+	}
+
+	logger.Infow("deleted exports", "count", len(deleted), "errors", len(result.Errors))
+	if err := json.NewEncoder(w).Encode(&result); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+
+	for i := range deleted {
+		export := &deleted[i]
+		notify.PublishStatus(e.Events, notify.StatusDeleted, *export, nil)
+		if e.CancelSources != nil && len(export.Sources) > 0 {
+			// the applications still working on the export can stop
+			e.CancelSources(r.Context(), logger, export.Identity, *export, export.Sources)
+		}
+	}
+}
+
+// bulkDeleteErrors returns the errors of the ids that were not deleted, in their
+// order.
+func (e *Export) bulkDeleteErrors(r *http.Request, ids []string, deleted []models.ExportPayload, user models.User) ([]ExportStatusResult, error) {
+	done := map[uuid.UUID]bool{}
+	for _, export := range deleted {
+		done[export.ID] = true
+	}
+	var remaining []uuid.UUID
+	for _, id := range ids {
+		if exportUUID, err := uuid.Parse(id); err == nil && !done[exportUUID] {
+			remaining = append(remaining, exportUUID)
+		}
+	}
+	exports := map[uuid.UUID]*models.ExportPayload{}
+	if len(remaining) > 0 {
+		found, err := e.DB.WithContext(r.Context()).GetManyWithOrg(remaining, user.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		for _, export := range found {
+			exports[export.ID] = export
+		}
+	}
+
+	results := []ExportStatusResult{}
+	for _, id := range ids {
+		exportUUID, err := uuid.Parse(id)
+		if done[exportUUID] {
+			continue
+		}
+		export := exports[exportUUID]
+		result := ExportStatusResult{ID: id}
+		switch {
+		case err != nil:
+			result.Error = &Error{Msg: fmt.Sprintf("'%s' is not a valid export UUID", id), Code: http.StatusBadRequest}
+		case export == nil:
+			result.Error = &Error{Msg: fmt.Sprintf("record '%s' not found", id), Code: http.StatusNotFound}
+		case !export.IsOwnedBy(user):
+			result.Error = &Error{Msg: fmt.Sprintf("record '%s' belongs to another user", id), Code: http.StatusForbidden}
+		default:
+			// the export was being packaged
+			result.Error = &Error{Msg: fmt.Sprintf("'%s' is being packaged, it can be deleted once it is complete", id), Code: http.StatusConflict}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// values returns the filter as the query of the export list.
+func (f BulkDeleteFilter) values() url.Values {
+	values := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	set("name", f.Name)
+	set("status", f.Status)
+	set("application", f.Application)
+	set("resource", f.Resource)
+	set("created_at", f.CreatedAt)
+	set("created_at_gte", f.CreatedAtGTE)
+	set("created_at_lte", f.CreatedAtLTE)
+	set("expires_at", f.ExpiresAt)
+	set("expires_at_gte", f.ExpiresAtGTE)
+	set("expires_at_lte", f.ExpiresAtLTE)
+	return values
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.uber.org/zap"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+// ErrCleanupRunning is returned when a cleanup is started while another one runs.
+var ErrCleanupRunning = errors.New("a cleanup is already running")
+
+// Cleaner deletes the expired exports, dropping at once the months whose exports all
+// expired, purges the deleted exports past their retention along with their objects,
+// deletes the raw per-source objects of the packaged exports that could not be removed
+// right after packaging, and forgets the cancelled exports past their retention. Its
+// runs are serialized within the process, and across the replicas and the cleaner job
+// by an advisory lock of the database: a run finding it held is skipped.
+type Cleaner struct {
+	DB models.DBInterface
+	// Compressor removes the source objects and the objects of the purged exports, they
+	// are kept when it is nil
+	Compressor *es3.Compressor
+	Log        *zap.SugaredLogger
+	// Events publishes the expiry of the exports, it may be nil
+	Events notify.StatusPublisher
+
+	mu      sync.Mutex
+	running bool
+	last    *CleanupReport
+}
+
+// Run cleans up once. A dry run reports what would be deleted without deleting it.
+func (c *Cleaner) Run(ctx context.Context, dryRun bool) (*CleanupReport, error) {
+	if !c.begin() {
+		return nil, ErrCleanupRunning
+	}
+	return c.run(ctx, dryRun), nil
+}
+
+// Start cleans up once in the background.
+func (c *Cleaner) Start(dryRun bool) error {
+	if !c.begin() {
+		return ErrCleanupRunning
+	}
+	go c.run(context.Background(), dryRun)
+	return nil
+}
+
+// Loop cleans up every interval until the context is done. Every replica may loop,
+// only the one holding the lock deletes.
+func (c *Cleaner) Loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a cleanup started on demand is not waited for
+			_, _ = c.Run(ctx, false)
+		}
+	}
+}
+
+// Status reports whether a cleanup runs, and the report of the last one.
+func (c *Cleaner) Status() CleanupStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CleanupStatus{Running: c.running, LastRun: c.last}
+}
+
+func (c *Cleaner) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return false
+	}
+	c.running = true
+	return true
+}
+
+func (c *Cleaner) run(ctx context.Context, dryRun bool) *CleanupReport {
+	report := &CleanupReport{
+		DryRun:            dryRun,
+		StartedAt:         time.Now().UTC(),
+		ExpiredExports:    []CleanupExport{},
+		DroppedPartitions: []string{},
+		SourceObjects:     []CleanupExport{},
+		PurgedExports:     []CleanupExport{},
+	}
+	db := c.DB.WithContext(ctx)
+	fail := func(msg string, err error) {
+		c.Log.Errorw(msg, "error", err)
+		report.Errors = append(report.Errors, msg+": "+err.Error())
+	}
+
+	// the deletions are left to a single replica, or the cleaner job, at a time
+	if dryRun {
+		c.clean(ctx, db, report, fail)
+	} else if unlock, err := db.TryLock(models.CleanupLock); err == nil {
+		c.clean(ctx, db, report, fail)
+		unlock()
+	} else if errors.Is(err, models.ErrLocked) {
+		report.Skipped = true
+	} else {
+		fail("failed to take the cleanup lock", err)
+	}
+
+	report.DurationSeconds = time.Since(report.StartedAt).Seconds()
+	c.Log.Infow("cleanup finished",
+		"dry_run", dryRun,
+		"expired_exports", len(report.ExpiredExports),
+		"dropped_partitions", report.DroppedPartitions,
+		"source_objects", len(report.SourceObjects),
+		"deleted_objects", report.DeletedObjects,
+		"forgotten_cancelled_exports", report.ForgottenCancelledExports,
+		"purged_exports", len(report.PurgedExports),
+		"skipped", report.Skipped,
+		"errors", len(report.Errors),
+	)
+	observeCleanup(report)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+	c.last = report
+	return report
+}
+
+func (c *Cleaner) clean(ctx context.Context, db models.DBInterface, report *CleanupReport, fail func(string, error)) {
+	dryRun := report.DryRun
+	// the months whose exports all expired are dropped at once, rather than deleted row
+	// by row
+	partitions, err := db.ListExpiredPartitions()
+	if err != nil {
+		fail("failed to list the expired partitions", err)
+	}
+	if dryRun {
+		for _, p := range partitions {
+			report.DroppedPartitions = append(report.DroppedPartitions, p.String())
+		}
+		expired, err := db.ListExpiredExports()
+		if err != nil {
+			fail("failed to list the expired exports", err)
+		}
+		for _, export := range expired {
+			report.ExpiredExports = append(report.ExpiredExports, newCleanupExport(*export))
+		}
+		purgeable, err := db.ListPurgeableExports()
+		if err != nil {
+			fail("failed to list the deleted exports past their retention", err)
+		}
+		for _, export := range purgeable {
+			report.PurgedExports = append(report.PurgedExports, newCleanupExport(*export))
+		}
+	} else {
+		if err := db.CreatePartitions(models.PartitionMonthsAhead); err != nil {
+			fail("failed to create the partitions of the months ahead", err)
+		}
+		for _, p := range partitions {
+			dropped, err := db.DropPartition(p)
+			if err != nil {
+				fail("failed to drop the partition "+p.String(), err)
+				continue
+			}
+			report.DroppedPartitions = append(report.DroppedPartitions, p.String())
+			for _, export := range dropped {
+				report.ExpiredExports = append(report.ExpiredExports, newCleanupExport(export))
+				notify.PublishStatus(c.Events, notify.StatusExpired, export, nil)
+			}
+		}
+		deleted, err := db.DeleteExpiredExports()
+		if err != nil {
+			fail("failed to delete the expired exports", err)
+		}
+		for _, export := range deleted {
+			report.ExpiredExports = append(report.ExpiredExports, newCleanupExport(export))
+			notify.PublishStatus(c.Events, notify.StatusExpired, export, nil)
+		}
+		if report.ForgottenCancelledExports, err = db.DeleteExpiredCancelledExports(); err != nil {
+			fail("failed to delete the expired cancelled exports", err)
+		}
+		c.purge(ctx, db, report, fail)
+	}
+
+	if c.Compressor != nil && !c.Compressor.Cfg.StorageConfig.KeepSourceObjects {
+		exports, err := db.ListWithSourceObjects()
+		if err != nil {
+			fail("failed to list exports with source objects", err)
+		}
+		for _, export := range exports {
+			if !dryRun {
+				n, err := c.Compressor.DeleteSourceObjects(ctx, db, export)
+				report.DeletedObjects += n
+				if err != nil {
+					fail("failed to delete the source objects of export "+export.ID.String(), err)
+					continue
+				}
+			}
+			report.SourceObjects = append(report.SourceObjects, newCleanupExport(*export))
+		}
+	}
+}
+
+// purge deletes for good the deleted exports past their retention, then their objects.
+// The objects that can not be removed expire with the lifecycle rules of the bucket.
+func (c *Cleaner) purge(ctx context.Context, db models.DBInterface, report *CleanupReport, fail func(string, error)) {
+	purged, err := db.PurgeDeletedExports()
+	if err != nil {
+		fail("failed to purge the deleted exports", err)
+		return
+	}
+	for i := range purged {
+		export := &purged[i]
+		report.PurgedExports = append(report.PurgedExports, newCleanupExport(*export))
+		if c.Compressor == nil {
+			continue
+		}
+		n, err := c.Compressor.DeleteExportObjects(ctx, export)
+		report.DeletedObjects += n
+		if err != nil {
+			fail("failed to delete the objects of the purged export "+export.ID.String(), err)
+		}
+	}
+}
+
+func newCleanupExport(export models.ExportPayload) CleanupExport {
+	return CleanupExport{
+		ID:             export.ID,
+		OrganizationID: export.OrganizationID,
+		Expires:        export.Expires,
+		StoredBytes:    export.StoredBytes,
+	}
+}
+
+// CleanupRouter lets ops run the cleanup on demand. Every request is audit logged.
+func (i *Internal) CleanupRouter(r chi.Router) {
+	r.Use(i.auditAdminAccess)
+	r.Post("/", i.PostCleanup)
+	r.Get("/status", i.GetCleanupStatus)
+}
+
+// PostCleanup starts a cleanup. A dry run, requested with `dry_run=true`, runs
+// within the request and returns what would be deleted.
+func (i *Internal) PostCleanup(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	logger := i.Log.With(export_logger.RequestIDField(reqID))
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			BadRequestError(w, "dry_run must be a boolean")
+			return
+		}
+	}
+
+	if dryRun {
+		report, err := i.Cleaner.Run(r.Context(), true)
+		if errors.Is(err, ErrCleanupRunning) {
+			ConflictError(w, err.Error())
+			return
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			logger.Errorw("error while encoding", "error", err)
+		}
+		return
+	}
+
+	if err := i.Cleaner.Start(false); err != nil {
+		ConflictError(w, err.Error())
+		return
+	}
+	logger.Infow("started a cleanup")
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(i.Cleaner.Status()); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// GetCleanupStatus handles GET requests to the /cleanup/status endpoint.
+func (i *Internal) GetCleanupStatus(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(i.Cleaner.Status()); err != nil {
+		i.Log.Errorw("error while encoding", "error", err)
+	}
+}
@@ -5,48 +5,90 @@ SPDX-License-Identifier: Apache-2.0
 package exports
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/redhatinsights/platform-go-middlewares/request_id"
 	"go.uber.org/zap"
 
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/encryption"
 	export_logger "github.com/redhatinsights/export-service-go/logger"
 	"github.com/redhatinsights/export-service-go/middleware"
 	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
 	es3 "github.com/redhatinsights/export-service-go/s3"
 )
 
 // Export holds any dependencies necessary for the external api endpoints
 type Export struct {
+	Cfg                 *config.ExportConfig
 	Bucket              string
 	StorageHandler      es3.StorageHandler
 	DB                  models.DBInterface
 	Log                 *zap.SugaredLogger
 	RequestAppResources RequestApplicationResources
+	// CancelSources tells the applications that the export was deleted, it may be nil
+	CancelSources CancelSources
+	// Downloads limits the downloads streamed at once, nil is unlimited
+	Downloads *middleware.DownloadLimiter
+	// RateLimits limits the creations and the downloads of each identity, nil is
+	// unlimited
+	RateLimits *middleware.RateLimiter
+	// Events publishes the creation, the cancellation and the deletion of the
+	// exports, it may be nil
+	Events notify.StatusPublisher
 }
 
 // ExportRouter is a router for all of the external routes for the /exports endpoint.
+// The downloads are allowed more time than the other routes. The actions taken on the
+// exports are recorded in the audit trail.
 func (e *Export) ExportRouter(r chi.Router) {
-	r.Post("/", e.PostExport)
-	r.With(middleware.PaginationCtx).Get("/", e.ListExports)
+	timeout := middleware.Timeout(e.Cfg.HTTPConfig.RequestTimeout)
+	downloadTimeout := middleware.Timeout(e.Cfg.HTTPConfig.DownloadTimeout)
+
+	r.With(e.audit(models.AuditCreate), e.RateLimits.LimitClass(middleware.CreateRequests), timeout).Post("/", e.PostExport)
+	r.With(timeout, middleware.PaginationCtx).Get("/", e.ListExports)
+	r.With(e.audit(models.AuditDelete), timeout).Delete("/", e.DeleteExports)
+	r.With(timeout).Get("/summary", e.GetSummary)
+	r.With(timeout).Get("/status", e.GetExportsStatus)
 	r.Route("/{exportUUID}", func(sub chi.Router) {
-		sub.With(middleware.GZIPContentType).Get("/", e.GetExport)
-		sub.Delete("/", e.DeleteExport)
-		sub.Get("/status", e.GetExportStatus)
+		sub.With(e.audit(models.AuditDownload), e.RateLimits.LimitClass(middleware.DownloadRequests), downloadTimeout).Get("/", e.GetExport)
+		sub.With(e.audit(models.AuditDelete), timeout).Delete("/", e.DeleteExport)
+		sub.With(e.audit(models.AuditCancel), timeout).Post("/cancel", e.CancelExport)
+		sub.With(e.audit(models.AuditRestore), timeout).Post("/restore", e.RestoreExport)
+		sub.With(timeout).Get("/status", e.GetExportStatus)
+		sub.With(e.audit(models.AuditDownload), e.RateLimits.LimitClass(middleware.DownloadRequests), downloadTimeout).Get("/sources/{sourceUUID}", e.GetExportSource)
+		sub.With(timeout).Get("/sources/{sourceUUID}/status", e.GetExportSourceStatus)
 	})
 }
 
+// publicExportsPath is where the ExportRouter is mounted on the public server.
+const publicExportsPath = PublicBasePath + "/exports"
+
+func exportHref(exportID uuid.UUID) string {
+	return fmt.Sprintf("%s/%s", publicExportsPath, exportID)
+}
+
+func sourceHref(exportID, sourceID uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/sources/%s", publicExportsPath, exportID, sourceID)
+}
+
 func mapUsertoModelUser(user middleware.User) models.User {
 	modelUser := models.User{
 		AccountID:      user.AccountID,
 		OrganizationID: user.OrganizationID,
 		Username:       user.Username,
+		IdentityType:   user.Type,
 	}
 	return modelUser
 }
@@ -60,30 +102,80 @@ func (e *Export) PostExport(w http.ResponseWriter, r *http.Request) {
 
 	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
 
+	idempotencyKey, err := parseIdempotencyKey(r)
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+
 	var apiExport ExportPayload
-	err := json.NewDecoder(r.Body).Decode(&apiExport)
+	err = json.NewDecoder(r.Body).Decode(&apiExport)
 	if err != nil {
 		logger.Errorw("error while parsing params", "error", err)
-		BadRequestError(w, err.Error())
+		decodeError(w, err)
 		return
 	}
 
-	dbExport, err := APIExportToDBExport(apiExport)
+	var fingerprint string
+	if idempotencyKey != "" {
+		if fingerprint, err = idempotencyFingerprint(apiExport); err != nil {
+			InternalServerError(w, err)
+			return
+		}
+		// a retry gets the export of the first request
+		if e.replayExport(w, r, logger, idempotencyKey, fingerprint, modelUser) {
+			return
+		}
+	}
+
+	dbExport, err := e.newExport(apiExport)
 	if err != nil {
-		logger.Errorw("unable to convert api export into db export", "error", err)
+		logger.Errorw("invalid export request", "error", err)
 		BadRequestError(w, err.Error())
 		return
 	}
-	if len(apiExport.Sources) == 0 {
-		logger.Errorw("no sources provided", "error", err)
-		BadRequestError(w, "no sources provided")
+
+	usage, err := e.DB.WithContext(r.Context()).GetOrgStorage(user.OrganizationID)
+	if err != nil {
+		logger.Errorw("error querying for the org storage", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+	if usage.OverQuota(e.Cfg.OrgStorageQuotaBytes) {
+		logger.Infow("org is over its storage quota", "stored_bytes", usage.StoredBytes, "quota_bytes", usage.Quota(e.Cfg.OrgStorageQuotaBytes))
+		TooManyRequestsError(w, fmt.Sprintf("insufficient storage: the exports of the organization use %d of %d bytes, delete exports to free space", usage.StoredBytes, usage.Quota(e.Cfg.OrgStorageQuotaBytes)))
 		return
 	}
+	if e.exportQuotasEnabled() {
+		exportsUsage, quota, err := e.exportsUsage(e.DB.WithContext(r.Context()), user.OrganizationID)
+		if err != nil {
+			logger.Errorw("error counting the exports of the org", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+		if quota != "" {
+			logger.Infow("org reached its export quota", "quota", quota, "exports", exportsUsage.Exports, "in_flight_exports", exportsUsage.InFlightExports)
+			ExportQuotaExceededError(w, quota, exportsUsage)
+			return
+		}
+	}
 
 	dbExport.RequestID = reqID
 	dbExport.User = modelUser
+	dbExport.Identity = r.Header["X-Rh-Identity"][0]
+	if idempotencyKey != "" {
+		dbExport.IdempotencyKey = &idempotencyKey
+		dbExport.IdempotencyFingerprint = fingerprint
+	}
 
-	dbExport, err = e.DB.Create(dbExport)
+	dbExport, err = e.DB.WithContext(r.Context()).Create(dbExport)
+	if errors.Is(err, models.ErrDuplicateIdempotencyKey) {
+		// a retry created the export concurrently
+		if !e.replayExport(w, r, logger, idempotencyKey, fingerprint, modelUser) {
+			ConflictError(w, fmt.Sprintf("an export is being created with the %s, retry the request", idempotencyKeyHeader))
+		}
+		return
+	}
 	if err != nil {
 		logger.Errorw("error creating payload entry", "error", err)
 		InternalServerError(w, err)
@@ -91,18 +183,194 @@ func (e *Export) PostExport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger = logger.With(export_logger.ExportIDField(dbExport.ID.String()))
+	auditExports(r, dbExport.ID)
 
 	w.WriteHeader(http.StatusAccepted)
 
-	apiExport = DBExportToAPI(*dbExport)
-	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+	if err := json.NewEncoder(w).Encode(getSerializer(r).export(r, *dbExport)); err != nil {
 		logger.Errorw("error while trying to encode", "error", err)
 		InternalServerError(w, err.Error())
 	}
+	notify.PublishStatus(e.Events, notify.StatusCreated, *dbExport, nil)
 
 	// send the payload to the producer with a goroutine so
 	// that we do not block the response
-	e.RequestAppResources(r.Context(), logger, r.Header["X-Rh-Identity"][0], *dbExport)
+	e.RequestAppResources(r.Context(), logger, dbExport.Identity, *dbExport)
+}
+
+// newExport validates the requested export and converts it into a db export.
+func (e *Export) newExport(apiExport ExportPayload) (*models.ExportPayload, error) {
+	dbExport, err := APIExportToDBExport(apiExport)
+	if err != nil {
+		return nil, err
+	}
+	if len(apiExport.Sources) == 0 {
+		return nil, errors.New("no sources provided")
+	}
+	limits := e.Cfg.LimitsConfig
+	if limits.MaxSourcesPerExport > 0 && len(apiExport.Sources) > limits.MaxSourcesPerExport {
+		return nil, fmt.Errorf("too many sources: an export may have at most %d sources, got %d", limits.MaxSourcesPerExport, len(apiExport.Sources))
+	}
+	for i, source := range apiExport.Sources {
+		if limits.MaxFiltersBytes > 0 && len(source.Filters) > limits.MaxFiltersBytes {
+			return nil, fmt.Errorf("the filters of source %d are larger than the limit of %d bytes", i, limits.MaxFiltersBytes)
+		}
+	}
+	now := time.Now()
+	if dbExport.Expires, err = e.expiry(apiExport, now); err != nil {
+		return nil, err
+	}
+	name, err := expandName(dbExport.Name, now, dbExport.Sources)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	dbExport.Name = name
+	if dbExport.ArchiveFormat == "" {
+		dbExport.ArchiveFormat = models.ArchiveFormat(e.Cfg.ArchiveFormat)
+	}
+	if !dbExport.ArchiveFormat.IsValid() {
+		return nil, fmt.Errorf("unknown archive format: '%s', the supported formats are %s", dbExport.ArchiveFormat, archiveFormats())
+	}
+	for _, source := range dbExport.Sources {
+		if !formatAllowed(e.Cfg.ApplicationPolicy(source.Application), source.Format) {
+			return nil, fmt.Errorf("the '%s' format is not allowed for the sources of the application '%s'", source.Format, source.Application)
+		}
+	}
+	if apiExport.NotificationURL != "" {
+		if err := notify.ValidateURL(apiExport.NotificationURL, e.Cfg.NotificationConfig.AllowedDomains); err != nil {
+			return nil, err
+		}
+	}
+	if apiExport.Encryption != nil {
+		if apiExport.Encryption.PublicKey == "" {
+			return nil, errors.New("the encryption of the archive requires a public key")
+		}
+		// the key is checked now, so that a bad key does not fail the packaging
+		key, err := encryption.ParsePublicKey(apiExport.Encryption.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		dbExport.EncryptionPublicKey = apiExport.Encryption.PublicKey
+		dbExport.EncryptionFingerprint = key.Fingerprint()
+	}
+
+	originalRequest, err := json.Marshal(newExportRequest(*dbExport))
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxStoredRequestBytes > 0 && len(originalRequest) > limits.MaxStoredRequestBytes {
+		return nil, fmt.Errorf("the request is larger than the limit of %d bytes once normalized, reduce the filters of its sources", limits.MaxStoredRequestBytes)
+	}
+	dbExport.OriginalRequest = originalRequest
+	return dbExport, nil
+}
+
+// expiry returns when the requested export expires, expires_in_days from now or
+// expires_at in the future, both bounded by MaxExpiryDays. It is nil when neither is requested, the
+// export then expires after EXPORT_EXPIRY_DAYS.
+func (e *Export) expiry(apiExport ExportPayload, now time.Time) (*time.Time, error) {
+	maxDays := e.Cfg.LimitsConfig.MaxExpiryDays
+	if apiExport.ExpiresInDays == nil {
+		if apiExport.Expires != nil && !apiExport.Expires.After(now) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+		if apiExport.Expires != nil && maxDays > 0 && apiExport.Expires.After(now.AddDate(0, 0, maxDays)) {
+			return nil, fmt.Errorf("expires_at may be at most %d days ahead", maxDays)
+		}
+		return apiExport.Expires, nil
+	}
+
+	if apiExport.Expires != nil {
+		return nil, errors.New("expires_at and expires_in_days can not be both set")
+	}
+	days := *apiExport.ExpiresInDays
+	if days < 1 {
+		return nil, fmt.Errorf("expires_in_days must be at least 1, got %d", days)
+	}
+	if maxDays > 0 && days > maxDays {
+		return nil, fmt.Errorf("expires_in_days may be at most %d, got %d", maxDays, days)
+	}
+	expires := now.AddDate(0, 0, days)
+	return &expires, nil
+}
+
+// newExportRequest returns the normalized request of the export, with the format of
+// every source resolved.
+func newExportRequest(export models.ExportPayload) ExportRequest {
+	request := ExportRequest{
+		Name:            export.Name,
+		Format:          string(export.Format),
+		ArchiveFormat:   string(export.ArchiveFormat),
+		Sources:         []RequestedSource{},
+		NotificationURL: export.NotificationURL,
+	}
+	if export.Expires != nil {
+		expires := export.Expires.UTC()
+		request.Expires = &expires
+	}
+	for _, source := range export.Sources {
+		request.Sources = append(request.Sources, RequestedSource{
+			Application: source.Application,
+			Resource:    source.Resource,
+			Format:      string(source.Format),
+			Filters:     source.Filters,
+		})
+	}
+	if export.IsEncrypted() {
+		request.Encryption = &Encryption{PublicKey: export.EncryptionPublicKey}
+	}
+	return request
+}
+
+// archiveFormats returns the supported archive formats, for the error messages.
+func archiveFormats() string {
+	formats := make([]string, len(models.ArchiveFormats))
+	for i, format := range models.ArchiveFormats {
+		formats[i] = fmt.Sprintf("'%s'", format)
+	}
+	return strings.Join(formats, ", ")
+}
+
+// formatAllowed reports whether the policy of an application allows its sources to
+// be exported in the format.
+func formatAllowed(policy config.ApplicationPolicy, format models.PayloadFormat) bool {
+	if len(policy.AllowedFormats) == 0 {
+		return true
+	}
+	for _, allowed := range policy.AllowedFormats {
+		if models.PayloadFormat(allowed) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeError reports why the body of a request could not be decoded.
+func decodeError(w http.ResponseWriter, err error) {
+	var tooLarge *middleware.BodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		RequestEntityTooLargeError(w, tooLarge.Error())
+		return
+	}
+	BadRequestError(w, err.Error())
+}
+
+// GetLimits handles GET requests to the /limits endpoint, so that clients can
+// validate their requests.
+func (e *Export) GetLimits(w http.ResponseWriter, r *http.Request) {
+	limits := Limits{
+		MaxSourcesPerExport:   e.Cfg.LimitsConfig.MaxSourcesPerExport,
+		MaxRequestBodyBytes:   e.Cfg.LimitsConfig.MaxRequestBodyBytes,
+		MaxFiltersBytes:       e.Cfg.LimitsConfig.MaxFiltersBytes,
+		MaxStoredRequestBytes: e.Cfg.LimitsConfig.MaxStoredRequestBytes,
+		MaxExpiryDays:         e.Cfg.LimitsConfig.MaxExpiryDays,
+	}
+	if err := json.NewEncoder(w).Encode(limits); err != nil {
+		e.Log.Errorw("error while encoding", export_logger.RequestIDField(request_id.GetReqID(r.Context())), "error", err)
+	}
 }
 
 // ListExports handle GET requests to the /exports endpoint.
@@ -124,14 +392,14 @@ func (e *Export) ListExports(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exports, count, err := e.DB.APIList(modelUser, &params, page.Offset, page.Limit, page.SortBy, page.Dir)
+	exports, count, err := e.DB.WithContext(r.Context()).APIList(modelUser, &params, page.Offset, page.Limit, page.SortBy, page.Dir)
 
 	if err != nil {
 		logger.Errorw("error while retrieving list from database", "error", err)
 		InternalServerError(w, err)
 		return
 	}
-	resp, err := middleware.GetPaginatedResponse(r.URL, page, count, exports)
+	resp, err := middleware.GetPaginatedResponse(r.URL, page, count, getSerializer(r).list(r, exports))
 	if err != nil {
 		logger.Errorw("error while paginating data", "error", err)
 		InternalServerError(w, err)
@@ -158,34 +426,251 @@ func (e *Export) GetExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if export.Status != models.Complete && export.Status != models.Partial {
+	if export.Status != models.Complete && export.Status != models.CompleteWithErrors {
+		allowPartial, err := parseAllowPartial(r)
+		if err != nil {
+			BadRequestError(w, err.Error())
+			return
+		}
+		if allowPartial {
+			e.downloadPartialArchive(w, r, logger, export)
+			return
+		}
+		if export.Status == models.Cancelled {
+			ConflictError(w, fmt.Sprintf("'%s' was cancelled, the sources that completed can be downloaded with allow_partial=true", export.ID))
+			return
+		}
 		logger.Infof("'%s' not ready for download", export.ID)
 		BadRequestError(w, fmt.Sprintf("'%s' is not ready for download", export.ID))
 		return
 	}
 
-	out, err := e.StorageHandler.GetObject(r.Context(), export.S3Key)
+	filename := archiveFilename(export.Name, export.S3Key)
+	if e.redirectDownload(w, r, logger, export, nil, export.S3Key, filename) {
+		return
+	}
+
+	release, ok := e.acquireDownload(w, r, logger, export, nil, export.S3Key, filename)
+	if !ok {
+		return
+	}
+	defer release()
+
+	out, byteRange, err := e.openDownload(w, r, logger, export.S3Key)
 	if err != nil {
 		logger.Errorw("failed to get object", "error", err)
+		if es3.IsTimeout(err) {
+			GatewayTimeoutError(w, err.Error())
+			return
+		}
+		if errors.Is(err, es3.ErrObjectNotFound) {
+			NotFoundError(w, fmt.Sprintf("the archive of '%s' was not found", export.ID))
+			return
+		}
+		InternalServerError(w, err)
+		return
+	}
+	if out == nil {
+		// the range can not be satisfied
+		return
+	}
+
+	defer func() {
+		if err := out.Close(); err != nil {
+			logger.Errorw("failed to close body", "error", err)
+		}
+	}()
+
+	contentType := export.ArchiveFormat.ContentType()
+	if export.IsEncrypted() {
+		contentType = encryption.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	writeDownloadHeader(w, byteRange)
+	// stream the archive, it may be too large to be held in memory
+	n, err := io.Copy(w, out)
+	if err != nil {
+		logger.Errorw("failed to stream body", "error", err)
+		return
+	}
+	// a resumed download is recorded once it reaches the end of the archive
+	if byteRange == nil || byteRange.complete() {
+		e.recordDownload(logger, r, export, nil, n, false)
+	}
+}
+
+// GetExportSource handles GET requests to the /exports/{exportUUID}/sources/{sourceUUID}
+// endpoint. It streams the payload of a single completed source, so that it can be
+// downloaded before the other sources of the export are done.
+func (e *Export) GetExportSource(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	export := e.getExportWithUser(w, r, logger)
+	if export == nil {
+		return
+	}
+
+	source := getSourceOfExport(w, r, export)
+	if source == nil {
+		return
+	}
+
+	if source.Status != models.RSuccess {
+		ConflictError(w, fmt.Sprintf("source '%s' is '%s' and can not be downloaded", source.ID, source.Status))
+		return
+	}
+
+	if export.IsEncrypted() {
+		// the payloads of the sources are not encrypted, only the archive is
+		ConflictError(w, fmt.Sprintf("the archive of '%s' is encrypted, its sources can only be downloaded within it from %s", export.ID, exportHref(export.ID)))
+		return
+	}
+
+	goneMessage := fmt.Sprintf("source '%s' is no longer available on its own, download the export archive from %s", source.ID, exportHref(export.ID))
+	if export.SourceObjectsDeleted {
+		GoneError(w, goneMessage)
+		return
+	}
+
+	key := es3.SourceObjectKey(export, *source)
+	filename := fmt.Sprintf("%s-%s-%s.%s", source.Application, source.Resource, source.ID, source.Format)
+	if e.redirectDownload(w, r, logger, export, &source.ID, key, filename) {
+		return
+	}
+
+	release, ok := e.acquireDownload(w, r, logger, export, &source.ID, key, filename)
+	if !ok {
+		return
+	}
+	defer release()
+
+	out, byteRange, err := e.openDownload(w, r, logger, key)
+	if err != nil {
+		logger.Errorw("failed to get source object", "error", err)
+		if es3.IsTimeout(err) {
+			GatewayTimeoutError(w, err.Error())
+			return
+		}
+		if errors.Is(err, es3.ErrObjectNotFound) {
+			GoneError(w, goneMessage)
+			return
+		}
 		InternalServerError(w, err)
 		return
 	}
+	if out == nil {
+		// the range can not be satisfied
+		return
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			logger.Errorw("failed to close body", "error", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", source.Format.ContentType())
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	if digest := checksumDigest(source.Checksum); digest != "" && byteRange == nil {
+		w.Header().Set("Digest", digest)
+	}
+	writeDownloadHeader(w, byteRange)
+	n, err := io.Copy(w, out)
+	if err != nil {
+		logger.Errorw("failed to stream body", "error", err)
+		return
+	}
+	if byteRange == nil || byteRange.complete() {
+		e.recordDownload(logger, r, export, &source.ID, n, false)
+	}
+}
 
-	baseName := filepath.Base(export.S3Key)
-	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", baseName))
-	w.WriteHeader(http.StatusOK)
-	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(out); err != nil {
-		logger.Errorf("failed to read body: %w", err)
+// redirectDownload redirects the download to a presigned url of the object when
+// STORAGE_PRESIGN_DOWNLOADS is set, so that its bytes are not proxied through the
+// service. It returns false when the download is to be streamed instead.
+func (e *Export) redirectDownload(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, export *models.ExportPayload, sourceID *uuid.UUID, key, filename string) bool {
+	scfg := e.Cfg.StorageConfig
+	if !scfg.PresignDownloads {
+		return false
 	}
-	err = out.Close()
+	url, err := e.StorageHandler.PresignObject(r.Context(), key, scfg.PresignTTL, filename)
 	if err != nil {
-		logger.Errorf("failed to close body: %w", err)
+		// e.g. the filesystem storage, the download is streamed
+		logger.Errorw("failed to presign object", "error", err)
+		return false
 	}
-	Logerr(w.Write(buf.Bytes()))
+	http.Redirect(w, r, url, http.StatusFound)
+	e.recordDownload(logger, r, export, sourceID, 0, true)
+	return true
 }
 
-// DeleteExport handles DELETE requests to the /exports/{exportUUID} endpoint.
+// acquireDownload takes a download slot, the returned release func must be called
+// once the download is over. When every slot is taken the user is redirected to a
+// presigned url of the object, if there is one and presigning is configured, else the
+// download waits for a slot before it is refused with a 503. It returns false once the
+// response was written.
+func (e *Export) acquireDownload(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, export *models.ExportPayload, sourceID *uuid.UUID, key, filename string) (func(), bool) {
+	if release, ok := e.Downloads.TryAcquire(); ok {
+		return release, true
+	}
+
+	// the downloads without an object, like the partial archives, can not be presigned
+	if expiry := e.Cfg.DownloadConfig.PresignExpiry; expiry > 0 && key != "" {
+		url, err := e.StorageHandler.PresignObject(r.Context(), key, expiry, filename)
+		if err == nil {
+			logger.Infow("too many downloads in progress, redirecting to a presigned url", "export_id", export.ID)
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+			e.recordDownload(logger, r, export, sourceID, 0, true)
+			return nil, false
+		}
+		// the download can still be streamed once a slot frees up
+		logger.Errorw("failed to presign object", "error", err)
+	}
+
+	release, err := e.Downloads.Acquire(r.Context())
+	if err != nil {
+		logger.Infow("too many downloads in progress", "export_id", export.ID, "error", err)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(e.Downloads.QueueTimeout)))
+		ServiceUnavailableError(w, "too many downloads in progress, retry later")
+		return nil, false
+	}
+	return release, true
+}
+
+// retryAfterSeconds rounds the duration up to whole seconds, at least one.
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// recordDownload adds the download to the history of the export in the background,
+// so that recording it does not slow down the download.
+func (e *Export) recordDownload(logger *zap.SugaredLogger, r *http.Request, export *models.ExportPayload, sourceID *uuid.UUID, bytes int64, presigned bool) {
+	download := models.Download{
+		ExportPayloadID: export.ID,
+		ExportCreatedAt: export.CreatedAt,
+		SourceID:        sourceID,
+		DownloadedAt:    time.Now(),
+		User:            mapUsertoModelUser(middleware.GetUserIdentity(r.Context())),
+		Bytes:           bytes,
+		Presigned:       presigned,
+	}
+	go func() {
+		if err := e.DB.RecordDownload(download); err != nil {
+			logger.Errorw("failed to record the download", "error", err)
+		}
+	}()
+}
+
+// DeleteExport handles DELETE requests to the /exports/{exportUUID} endpoint. An
+// export deleted before it finished is cancelled: the applications of its outstanding
+// sources are told to stop working on them.
 func (e *Export) DeleteExport(w http.ResponseWriter, r *http.Request) {
 
 	user := middleware.GetUserIdentity(r.Context())
@@ -202,17 +687,154 @@ func (e *Export) DeleteExport(w http.ResponseWriter, r *http.Request) {
 
 	modelUser := mapUsertoModelUser(user)
 
-	if err := e.DB.Delete(exportUUID, modelUser); err != nil {
+	export, err := e.DB.WithContext(r.Context()).Delete(exportUUID, modelUser)
+	if err != nil {
 		switch err {
 		case models.ErrRecordNotFound:
 			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
 			return
+		case models.ErrStatusConflict:
+			ConflictError(w, fmt.Sprintf("'%s' is being packaged, it can be deleted once it is complete", exportUUID))
+			return
 		default:
 			logger.Errorw("error deleting payload entry", "error", err)
 			InternalServerError(w, err)
 			return
 		}
 	}
+	notify.PublishStatus(e.Events, notify.StatusDeleted, *export, nil)
+
+	if export.Status != models.Pending && export.Status != models.Running {
+		return
+	}
+	// the applications still working on the export can stop
+	var outstanding []models.Source
+	for _, source := range export.Sources {
+		if source.Status == models.RPending {
+			outstanding = append(outstanding, source)
+		}
+	}
+	logger.Infow("cancelled the export", "outstanding_sources", len(outstanding))
+	if e.CancelSources != nil && len(outstanding) > 0 {
+		e.CancelSources(r.Context(), logger, export.Identity, *export, outstanding)
+	}
+}
+
+// CancelExport handles POST requests to the /exports/{exportUUID}/cancel endpoint. It
+// stops the export while it is pending or running, the applications of its pending
+// sources are told to stop working on them. The export is kept, with the sources that
+// completed.
+func (e *Export) CancelExport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserIdentity(r.Context())
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.ExportIDField(uid), export_logger.OrgIDField(user.OrganizationID))
+
+	export, outstanding, err := e.DB.WithContext(r.Context()).Cancel(exportUUID, mapUsertoModelUser(user))
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		case models.ErrStatusConflict:
+			ConflictError(w, fmt.Sprintf("'%s' is no longer pending and can not be cancelled", exportUUID))
+			return
+		default:
+			logger.Errorw("error cancelling payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	logger.Infow("cancelled the export", "outstanding_sources", len(outstanding))
+	notify.PublishStatus(e.Events, notify.StatusCancelled, *export, nil)
+	if err := json.NewEncoder(w).Encode(getSerializer(r).export(r, *export)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+	if e.CancelSources != nil && len(outstanding) > 0 {
+		e.CancelSources(r.Context(), logger, export.Identity, *export, outstanding)
+	}
+}
+
+// RestoreExport handles POST requests to the /exports/{exportUUID}/restore endpoint. It
+// restores the deleted export until it is purged, an export deleted before it finished
+// stays cancelled.
+func (e *Export) RestoreExport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserIdentity(r.Context())
+	reqID := request_id.GetReqID(r.Context())
+
+	uid := chi.URLParam(r, "exportUUID")
+	exportUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid export UUID", uid))
+		return
+	}
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.ExportIDField(uid), export_logger.OrgIDField(user.OrganizationID))
+
+	export, err := e.DB.WithContext(r.Context()).Restore(exportUUID, mapUsertoModelUser(user))
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
+			return
+		case models.ErrStatusConflict:
+			ConflictError(w, fmt.Sprintf("'%s' is not deleted", exportUUID))
+			return
+		default:
+			logger.Errorw("error restoring payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	logger.Infow("restored the export")
+	notify.PublishStatus(e.Events, notify.StatusRestored, *export, nil)
+	if err := json.NewEncoder(w).Encode(getSerializer(r).export(r, *export)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// GetSummary handles GET requests to the /exports/summary endpoint, returning the
+// storage the exports of the organization use and their number.
+func (e *Export) GetSummary(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserIdentity(r.Context())
+	reqID := request_id.GetReqID(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	usage, err := e.DB.WithContext(r.Context()).GetOrgStorage(user.OrganizationID)
+	if err != nil {
+		logger.Errorw("error querying for the org storage", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	exportsUsage, _, err := e.exportsUsage(e.DB.WithContext(r.Context()), user.OrganizationID)
+	if err != nil {
+		logger.Errorw("error counting the exports of the org", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	summary := Summary{
+		Storage: StorageUsage{
+			UsedBytes:  usage.StoredBytes,
+			QuotaBytes: usage.Quota(e.Cfg.OrgStorageQuotaBytes),
+		},
+		Exports: exportsUsage,
+	}
+	if err := json.NewEncoder(w).Encode(&summary); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+		InternalServerError(w, err.Error())
+	}
 }
 
 // GetExportStatus handles GET requests to the /exports/{exportUUID}/status endpoint.
@@ -222,20 +844,79 @@ func (e *Export) GetExportStatus(w http.ResponseWriter, r *http.Request) {
 
 	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
 
-	export := e.getExportWithUser(w, r, logger)
+	export := e.getExportStatusWithUser(w, r, logger)
 	if export == nil {
 		return
 	}
 
-	apiExport := DBExportToAPI(*export)
+	if err := json.NewEncoder(w).Encode(getSerializer(r).export(r, *export)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+		InternalServerError(w, err.Error())
+	}
+}
 
-	if err := json.NewEncoder(w).Encode(&apiExport); err != nil {
+// GetExportSourceStatus handles GET requests to the
+// /exports/{exportUUID}/sources/{sourceUUID}/status endpoint. It returns the status of
+// a single source with the details of its delivery and upload.
+func (e *Export) GetExportSourceStatus(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserIdentity(r.Context())
+	reqID := request_id.GetReqID(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	export := e.getExportStatusWithUser(w, r, logger)
+	if export == nil {
+		return
+	}
+	source := getSourceOfExport(w, r, export)
+	if source == nil {
+		return
+	}
+
+	status := SourceStatus{
+		Source:        sourceToAPI(*export, *source),
+		ExportID:      export.ID,
+		Size:          source.Size,
+		DeliveryError: source.DeliveryError,
+		RequestedAt:   export.CreatedAt,
+		AnnouncedAt:   source.AnnouncedAt,
+		ResolvedAt:    source.ResolvedAt,
+	}
+	if err := json.NewEncoder(w).Encode(&status); err != nil {
 		logger.Errorw("error while encoding", "error", err)
 		InternalServerError(w, err.Error())
 	}
 }
 
+// getSourceOfExport returns the source of the export in the url, it responds itself
+// when the source does not exist.
+func getSourceOfExport(w http.ResponseWriter, r *http.Request, export *models.ExportPayload) *models.Source {
+	uid := chi.URLParam(r, "sourceUUID")
+	sourceUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid source UUID", uid))
+		return nil
+	}
+
+	_, source, err := export.GetSource(sourceUUID)
+	if err != nil {
+		NotFoundError(w, fmt.Sprintf("source '%s' not found", sourceUUID))
+		return nil
+	}
+	return source
+}
+
 func (e *Export) getExportWithUser(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) *models.ExportPayload {
+	return e.findExportWithUser(w, r, logger, false)
+}
+
+// getExportStatusWithUser is getExportWithUser for the polled status endpoints, the
+// export is read from the replica of the database.
+func (e *Export) getExportStatusWithUser(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) *models.ExportPayload {
+	return e.findExportWithUser(w, r, logger, true)
+}
+
+func (e *Export) findExportWithUser(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, fromReplica bool) *models.ExportPayload {
 	uid := chi.URLParam(r, "exportUUID")
 	exportUUID, err := uuid.Parse(uid)
 	if err != nil {
@@ -248,7 +929,13 @@ func (e *Export) getExportWithUser(w http.ResponseWriter, r *http.Request, logge
 	user := middleware.GetUserIdentity(r.Context())
 	modelUser := mapUsertoModelUser(user)
 
-	export, err := e.DB.GetWithUser(exportUUID, modelUser)
+	db := e.DB.WithContext(r.Context())
+	var export *models.ExportPayload
+	if fromReplica {
+		export, err = db.GetWithUserFromReplica(exportUUID, modelUser)
+	} else {
+		export, err = db.GetWithUser(exportUUID, modelUser)
+	}
 	if err != nil {
 		switch err {
 		case models.ErrRecordNotFound:
@@ -283,38 +970,143 @@ func DBExportToAPI(payload models.ExportPayload) ExportPayload {
 		Name:        payload.Name,
 		Format:      string(payload.Format),
 		Status:      string(payload.Status),
+
+		ArchiveFormat: string(payload.ArchiveFormat),
+
+		NotificationURL: payload.NotificationURL,
+		DownloadCount:   payload.DownloadCount,
 	}
-	for _, source := range payload.Sources {
-		newSource := Source{
-			ID:          source.ID,
-			Application: source.Application,
-			Status:      string(source.Status),
-			Resource:    source.Resource,
-			Filters:     source.Filters,
+	if payload.RecordCount != nil {
+		apiPayload.RecordCount = payload.RecordCount
+		apiPayload.RecordCountPartial = !payload.RecordCountComplete()
+	}
+	if payload.LastDownloadedAt != nil {
+		lastDownloadedAt := payload.LastDownloadedAt.UTC()
+		apiPayload.LastDownloadedAt = &lastDownloadedAt
+	}
+	if payload.IsEncrypted() {
+		apiPayload.Encryption = &Encryption{Fingerprint: payload.EncryptionFingerprint}
+	}
+	if payload.NotificationURL != "" {
+		if payload.NotifiedAt != nil {
+			*payload.NotifiedAt = payload.NotifiedAt.UTC()
+		}
+		apiPayload.Notification = &NotificationStatus{
+			Status:      string(payload.NotificationStatus),
+			Attempts:    payload.NotificationAttempts,
+			LastError:   payload.NotificationError,
+			DeliveredAt: payload.NotifiedAt,
 		}
+	}
+	for _, source := range payload.Sources {
+		apiPayload.Sources = append(apiPayload.Sources, sourceToAPI(payload, source))
+	}
+	apiPayload.Failures = models.SourceFailures(payload.Sources)
 
-		if source.SourceError != nil {
-			newSource.Message = &source.SourceError.Message
-			newSource.Code = &source.SourceError.Code
+	return apiPayload
+}
+
+// sourceToAPI converts the db source of the export into the view returned by the api.
+func sourceToAPI(payload models.ExportPayload, source models.Source) Source {
+	newSource := Source{
+		ID:          source.ID,
+		Application: source.Application,
+		Status:      string(source.Status),
+		Resource:    source.Resource,
+		Format:      string(source.Format),
+		Filters:     source.Filters,
+		RecordCount: source.RecordCount,
+		Checksum:    source.Checksum,
+	}
+
+	if source.SourceError != nil {
+		newSource.Message = &source.SourceError.Message
+		newSource.Code = &source.SourceError.Code
+		newSource.Retriable = source.SourceError.Retriable
+	}
+	if source.Status == models.RSuccess && !payload.SourceObjectsDeleted && !payload.IsEncrypted() {
+		newSource.DownloadHref = sourceHref(payload.ID, source.ID)
+	}
+	return newSource
+}
+
+// DBExportToAdminAPI converts the db export into the view returned by the private api.
+func DBExportToAdminAPI(payload models.ExportPayload) AdminExportPayload {
+	apiExport := DBExportToAPI(payload)
+
+	sources := []AdminSource{}
+	for idx, source := range payload.Sources {
+		adminSource := AdminSource{Source: apiExport.Sources[idx], DeliveryError: source.DeliveryError}
+		if source.AnnouncedAt != nil {
+			announcedAt := source.AnnouncedAt.UTC()
+			adminSource.AnnouncedAt = &announcedAt
 		}
+		sources = append(sources, adminSource)
+	}
 
-		apiPayload.Sources = append(apiPayload.Sources, newSource)
+	return AdminExportPayload{
+		ExportPayload:  apiExport,
+		Sources:        sources,
+		UpdatedAt:      payload.UpdatedAt.UTC(),
+		RequestID:      payload.RequestID,
+		S3Key:          payload.S3Key,
+		AccountID:      payload.AccountID,
+		OrganizationID: payload.OrganizationID,
+		Username:       payload.Username,
+		IdentityType:   payload.IdentityType,
 	}
+}
 
-	return apiPayload
+// DBDownloadsToAdminAPI converts the download history of an export for the private api.
+func DBDownloadsToAdminAPI(downloads []models.Download) []AdminDownload {
+	result := make([]AdminDownload, 0, len(downloads))
+	for _, download := range downloads {
+		result = append(result, AdminDownload{
+			DownloadedAt:   download.DownloadedAt.UTC(),
+			SourceID:       download.SourceID,
+			AccountID:      download.AccountID,
+			OrganizationID: download.OrganizationID,
+			Username:       download.Username,
+			IdentityType:   download.IdentityType,
+			Bytes:          download.Bytes,
+			Presigned:      download.Presigned,
+		})
+	}
+	return result
 }
 
 func APIExportToDBExport(apiPayload ExportPayload) (*models.ExportPayload, error) {
 	payload := models.ExportPayload{
-		Name: apiPayload.Name,
+		Name:          apiPayload.Name,
+		Format:        models.PayloadFormat(apiPayload.Format),
+		ArchiveFormat: models.ArchiveFormat(apiPayload.ArchiveFormat),
+	}
+
+	if apiPayload.NotificationURL != "" {
+		payload.NotificationURL = apiPayload.NotificationURL
+		payload.NotificationStatus = models.NPending
+	}
+
+	if !payload.Format.IsValid() {
+		return nil, fmt.Errorf("unknown payload format: %s", apiPayload.Format)
 	}
 
 	var sources []models.Source
 	for _, source := range apiPayload.Sources {
+		// the export format is the default for any source that does not request its own
+		format := payload.Format
+		if source.Format != "" {
+			format = models.PayloadFormat(source.Format)
+		}
+		if !format.IsValid() {
+			return nil, fmt.Errorf("unknown payload format for source '%s/%s': %s", source.Application, source.Resource, source.Format)
+		}
+
 		sources = append(sources, models.Source{
 			Application: source.Application,
 			Status:      models.RPending,
 			Resource:    source.Resource,
+			Format:      format,
 			Filters:     source.Filters,
 		})
 	}
@@ -325,20 +1117,11 @@ func APIExportToDBExport(apiPayload ExportPayload) (*models.ExportPayload, error
 		payload.Expires = apiPayload.Expires
 	}
 
-	switch apiPayload.Format {
-	case "csv":
-		payload.Format = models.CSV
-	case "json":
-		payload.Format = models.JSON
-	default:
-		return nil, fmt.Errorf("unknown payload format: %s", apiPayload.Format)
-	}
-
 	switch apiPayload.Status {
 	case "complete":
 		payload.Status = models.Complete
-	case "partial":
-		payload.Status = models.Partial
+	case v1CompleteWithErrors, string(models.CompleteWithErrors):
+		payload.Status = models.CompleteWithErrors
 	case "failed":
 		payload.Status = models.Failed
 	default:
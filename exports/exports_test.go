@@ -1,25 +1,32 @@
 package exports_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"time"
 
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
-
-	"github.com/redhatinsights/platform-go-middlewares/identity"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/exports"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
 	"github.com/redhatinsights/export-service-go/logger"
 	emiddleware "github.com/redhatinsights/export-service-go/middleware"
 	"github.com/redhatinsights/export-service-go/models"
@@ -27,8 +34,18 @@ import (
 )
 
 const debugHeader string = "eyJpZGVudGl0eSI6eyJhY2NvdW50X251bWJlciI6IjEwMDAxIiwib3JnX2lkIjoiMTAwMDAwMDEiLCJpbnRlcm5hbCI6eyJvcmdfaWQiOiIxMDAwMDAwMSJ9LCJ0eXBlIjoiVXNlciIsInVzZXIiOnsidXNlcm5hbWUiOiJ1c2VyX2RldiJ9fX0K"
+
+// otherUserHeader identifies a different user of the same organization
+const otherUserHeader string = "eyJpZGVudGl0eSI6eyJhY2NvdW50X251bWJlciI6IjEwMDAxIiwib3JnX2lkIjoiMTAwMDAwMDEiLCJpbnRlcm5hbCI6eyJvcmdfaWQiOiIxMDAwMDAwMSJ9LCJ0eXBlIjoiVXNlciIsInVzZXIiOnsidXNlcm5hbWUiOiJvdGhlcl91c2VyIn19fQo="
+
+// serviceAccountHeader identifies a service account of the same organization
+const serviceAccountHeader string = "eyJpZGVudGl0eSI6eyJvcmdfaWQiOiIxMDAwMDAwMSIsImF1dGhfdHlwZSI6Imp3dC1hdXRoIiwiaW50ZXJuYWwiOnsib3JnX2lkIjoiMTAwMDAwMDEifSwidHlwZSI6IlNlcnZpY2VBY2NvdW50Iiwic2VydmljZV9hY2NvdW50Ijp7ImNsaWVudF9pZCI6ImI2OWVhZjllLWU2YTYtNGY5ZS04MDVlLTAyOTg3ZGFkZGZiZCIsInVzZXJuYW1lIjoic2VydmljZS1hY2NvdW50LWI2OWVhZjllLWU2YTYtNGY5ZS04MDVlLTAyOTg3ZGFkZGZiZCJ9fX0="
+
 const formatDateTime string = "2006-01-02T15:04:05Z" // ISO 8601
 
+// nextMonth is an expires_at within the limit of the expiry of the exports
+var nextMonth = time.Now().UTC().AddDate(0, 0, 30).Truncate(24 * time.Hour).Format(formatDateTime)
+
 func AddDebugUserIdentity(req *http.Request) {
 	req.Header.Add("x-rh-identity", debugHeader)
 }
@@ -61,12 +78,302 @@ var _ = Describe("The public API", func() {
 		Expect(rr.Code).To(Equal(expectedStatus))
 		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
 	},
-		Entry("with valid request", "Test Export Request", "json", "2023-01-01T00:00:00Z", `{"application":"exampleApp", "resource":"exampleResource"}`, "", http.StatusAccepted),
+		Entry("with valid request", "Test Export Request", "json", nextMonth, `{"application":"exampleApp", "resource":"exampleResource"}`, "", http.StatusAccepted),
 		Entry("with no expiration", "Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`, "", http.StatusAccepted),
-		Entry("with an invalid format", "Test Export Request", "abcde", "2023-01-01T00:00:00Z", `{"application":"exampleApp", "resource":"exampleResource"}`, "unknown payload format", http.StatusBadRequest),
-		Entry("With no sources", "Test Export Request", "json", "2023-01-01T00:00:00Z", "", "no sources provided", http.StatusBadRequest),
+		Entry("with an invalid format", "Test Export Request", "abcde", nextMonth, `{"application":"exampleApp", "resource":"exampleResource"}`, "unknown payload format", http.StatusBadRequest),
+		Entry("With no sources", "Test Export Request", "json", nextMonth, "", "no sources provided", http.StatusBadRequest),
+		Entry("with sources in different formats", "Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource", "format":"csv"}, {"application":"exampleApp", "resource":"exampleResource2"}`, `"format":"csv"`, http.StatusAccepted),
+		Entry("with an invalid source format", "Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource", "format":"abcde"}`, "unknown payload format for source", http.StatusBadRequest),
+		Entry("with more sources than the limit", "Test Export Request", "json", "", strings.TrimSuffix(strings.Repeat(`{"application":"exampleApp", "resource":"exampleResource"},`, 101), ","), "an export may have at most 100 sources", http.StatusBadRequest),
+		Entry("with filters larger than the limit", "Test Export Request", "json", "", fmt.Sprintf(`{"application":"exampleApp", "resource":"exampleResource", "filters":{"name":"%s"}}`, strings.Repeat("a", 16*1024)), "the filters of source 0 are larger than the limit of 16384 bytes", http.StatusBadRequest),
+		Entry("with an unknown placeholder in the name", "Export {nope}", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`, "unknown placeholder '{nope}' in the name, the supported placeholders are {date}, {datetime}, {application}", http.StatusBadRequest),
+		Entry("with unbalanced braces in the name", "Export {date", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`, "unbalanced braces in the name", http.StatusBadRequest),
+		Entry("with a name too long once expanded", strings.Repeat("{application}", 20), "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`, "the name may have at most 255 characters", http.StatusBadRequest),
+		Entry("with a normalized request larger than the limit", "Test Export Request", "json", "", strings.TrimSuffix(strings.Repeat(fmt.Sprintf(`{"application":"exampleApp", "resource":"exampleResource", "filters":{"name":"%s"}},`, strings.Repeat("a", 15*1024)), 5), ","), "the request is larger than the limit of 65536 bytes once normalized", http.StatusBadRequest),
+	)
+
+	It("expands the placeholders of the name", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("{application} export {date} ({datetime})", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"otherApp", "resource":"otherResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+		Expect(exportResponse.Name).To(MatchRegexp(`^exampleApp-otherApp export \d{4}-\d{2}-\d{2} \(\d{8}T\d{6}Z\)$`))
+	})
+
+	It("keeps the normalized request of the export", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("Test Export Request", "csv", nextMonth, `{"application":"exampleApp", "resource":"exampleResource", "filters": {"name": "a", "size": 2}}, {"application":"exampleApp", "resource":"exampleResource2", "format":"json"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+		Expect(exportResponse.Request).To(BeNil())
+
+		getStatus := func(query string) exports.ExportPayload {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status%s", exportResponse.ID, query), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var status exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &status)).To(Succeed())
+			return status
+		}
+
+		Expect(getStatus("").Request).To(BeNil())
+
+		request := getStatus("?include=request").Request
+		Expect(request).ToNot(BeNil())
+		Expect(request.Name).To(Equal("Test Export Request"))
+		Expect(request.Format).To(Equal("csv"))
+		// the archive format is the default of the service when not requested
+		Expect(request.ArchiveFormat).To(Equal("tar.gz"))
+		Expect(request.Expires.Format(formatDateTime)).To(Equal(nextMonth))
+		Expect(request.Sources).To(HaveLen(2))
+		// the sources get the format of the export when they do not request their own
+		Expect(request.Sources[0].Format).To(Equal("csv"))
+		Expect(request.Sources[0].Filters).To(MatchJSON(`{"name": "a", "size": 2}`))
+		Expect(request.Sources[1].Resource).To(Equal("exampleResource2"))
+		Expect(request.Sources[1].Format).To(Equal("json"))
+	})
+
+	DescribeTable("validates the notification url", func(notificationURL, expectedBody string, expectedStatus int) {
+		router := setupTest(mockRequestApplicationResources)
+		config.Get().NotificationConfig.AllowedDomains = []string{"example.com"}
+
+		body := fmt.Sprintf(`{"name": "Test Export Request", "format": "json", "notification_url": "%s", "sources": [{"application":"exampleApp", "resource":"exampleResource"}]}`, notificationURL)
+		req, err := http.NewRequest("POST", "/api/export/v1/exports", bytes.NewBufferString(body))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(expectedStatus))
+		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
+	},
+		Entry("with an allowed domain", "https://hooks.example.com/exports", `"status":"pending"`, http.StatusAccepted),
+		Entry("with plain http", "http://hooks.example.com/exports", "notification_url must use https", http.StatusBadRequest),
+		Entry("with a domain that is not allowed", "https://example.org/exports", "is not allowed", http.StatusBadRequest),
+	)
+
+	DescribeTable("chooses the expiry of the export", func(expiry, expectedBody string, expectedStatus int) {
+		router := setupTest(mockRequestApplicationResources)
+
+		body := fmt.Sprintf(`{"name": "Test Export Request", "format": "json", %s, "sources": [{"application":"exampleApp", "resource":"exampleResource"}]}`, expiry)
+		req, err := http.NewRequest("POST", "/api/export/v1/exports", bytes.NewBufferString(body))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(expectedStatus))
+		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
+	},
+		Entry("in a number of days", `"expires_in_days": 30`, time.Now().UTC().AddDate(0, 0, 30).Format(`"expires_at":"2006-01-02`), http.StatusAccepted),
+		Entry("with fewer than a day", `"expires_in_days": 0`, "expires_in_days must be at least 1, got 0", http.StatusBadRequest),
+		Entry("with more days than the limit", `"expires_in_days": 91`, "expires_in_days may be at most 90, got 91", http.StatusBadRequest),
+		Entry("with a date further than the limit", fmt.Sprintf(`"expires_at": "%s"`, time.Now().UTC().AddDate(0, 0, 91).Format(formatDateTime)), "expires_at may be at most 90 days ahead", http.StatusBadRequest),
+		Entry("with a date in the past", `"expires_at": "2023-01-01T00:00:00Z"`, "expires_at must be in the future", http.StatusBadRequest),
+		Entry("with both a date and a number of days", `"expires_at": "2023-01-01T00:00:00Z", "expires_in_days": 1`, "expires_at and expires_in_days can not be both set", http.StatusBadRequest),
+	)
+
+	It("rejects the formats the application does not allow", func() {
+		router := setupTest(mockRequestApplicationResources)
+		config.Get().ApplicationPolicies = map[string]config.ApplicationPolicy{"exampleApp": {AllowedFormats: []string{"json"}}}
+		DeferCleanup(func() { config.Get().ApplicationPolicies = nil })
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource", "format":"csv"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(ContainSubstring("the 'csv' format is not allowed for the sources of the application 'exampleApp'"))
+
+		rr = httptest.NewRecorder()
+		req = createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+	})
+
+	DescribeTable("chooses the format of the archive", func(archiveFormat, expectedBody string, expectedStatus int) {
+		router := setupTest(mockRequestApplicationResources)
+
+		body := fmt.Sprintf(`{"name": "Test Export Request", "format": "json", "archive_format": %q, "sources": [{"application":"exampleApp", "resource":"exampleResource"}]}`, archiveFormat)
+		req, err := http.NewRequest("POST", "/api/export/v1/exports", bytes.NewBufferString(body))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(expectedStatus))
+		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
+	},
+		Entry("with the default", "", `"archive_format":"tar.gz"`, http.StatusAccepted),
+		Entry("with zip", "zip", `"archive_format":"zip"`, http.StatusAccepted),
+		Entry("with tar.zst", "tar.zst", `"archive_format":"tar.zst"`, http.StatusAccepted),
+		Entry("with an unknown format", "rar", "unknown archive format: 'rar', the supported formats are 'zip', 'tar.gz', 'tar.zst'", http.StatusBadRequest),
+	)
+
+	DescribeTable("validates the encryption key", func(encryption, expectedBody string, expectedStatus int) {
+		router := setupTest(mockRequestApplicationResources)
+
+		body := fmt.Sprintf(`{"name": "Test Export Request", "format": "json", "encryption": %s, "sources": [{"application":"exampleApp", "resource":"exampleResource"}]}`, encryption)
+		req, err := http.NewRequest("POST", "/api/export/v1/exports", bytes.NewBufferString(body))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(expectedStatus))
+		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
+	},
+		Entry("without a key", `{}`, "requires a public key", http.StatusBadRequest),
+		Entry("with an invalid key", `{"public_key": "not a key"}`, "invalid OpenPGP public key", http.StatusBadRequest),
+		Entry("with an age key", `{"public_key": "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}`, "age keys are not supported", http.StatusBadRequest),
 	)
 
+	It("returns the fingerprint of the encryption key rather than the key", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		entity, err := openpgp.NewEntity("export", "", "export@example.com", nil)
+		Expect(err).To(BeNil())
+		var armored bytes.Buffer
+		w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+		Expect(err).To(BeNil())
+		Expect(entity.Serialize(w)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		payload := map[string]interface{}{
+			"name":       "Test Export Request",
+			"format":     "json",
+			"encryption": map[string]string{"public_key": armored.String()},
+			"sources":    []map[string]string{{"application": "exampleApp", "resource": "exampleResource"}},
+		}
+		body, err := json.Marshal(payload)
+		Expect(err).To(BeNil())
+		req, err := http.NewRequest("POST", "/api/export/v1/exports", bytes.NewBuffer(body))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		Expect(rr.Body.String()).To(ContainSubstring(fmt.Sprintf(`"encryption":{"fingerprint":"%X"}`, entity.PrimaryKey.Fingerprint)))
+		Expect(rr.Body.String()).ToNot(ContainSubstring("public_key"))
+	})
+
+	It("reports the format of each source in the status", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"systems", "format":"csv"}, {"application":"exampleApp", "resource":"policies"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportResponse.ID), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rr = httptest.NewRecorder()
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var statusResponse exports.ExportPayload
+		err = json.Unmarshal(rr.Body.Bytes(), &statusResponse)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		formats := map[string]string{}
+		for _, source := range statusResponse.Sources {
+			formats[source.Resource] = source.Format
+		}
+		Expect(formats).To(Equal(map[string]string{"systems": "csv", "policies": "json"}))
+	})
+
+	It("embeds the links of the exports in the responses of the version 2", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		body := generateExportRequestBody("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		req, err := http.NewRequest("POST", "/api/export/v2/exports", bytes.NewBuffer(body))
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "gateway.example.com, proxy.internal")
+		req.Header.Set("X-Forwarded-Prefix", "/exports-gw/")
+		AddDebugUserIdentity(req)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var created exports.ExportPayloadV2
+		Expect(json.Unmarshal(rr.Body.Bytes(), &created)).To(Succeed())
+		exportURL := fmt.Sprintf("https://gateway.example.com/exports-gw/api/export/v2/exports/%s", created.ID)
+		Expect(created.Links).To(Equal(exports.ExportLinks{Self: exportURL, Status: exportURL + "/status"}))
+		Expect(created.Sources).To(HaveLen(1))
+		Expect(created.Sources[0].Links.Download).To(BeEmpty())
+
+		config.Get().ExternalBaseURL = "https://console.example.com"
+		defer func() { config.Get().ExternalBaseURL = "" }()
+
+		req, err = http.NewRequest("GET", "/api/export/v2/exports", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		AddDebugUserIdentity(req)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var list struct {
+			Data []exports.APIExportV2 `json:"data"`
+		}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &list)).To(Succeed())
+		Expect(list.Data).To(HaveLen(1))
+		Expect(list.Data[0].Links.Status).To(Equal(fmt.Sprintf("https://console.example.com/api/export/v2/exports/%s/status", created.ID)))
+
+		// the responses of the version 1 are unchanged
+		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", created.ID), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		AddDebugUserIdentity(req)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).ToNot(ContainSubstring(`"links"`))
+	})
+
 	It("can list all export requests", func() {
 		router := setupTest(mockRequestApplicationResources)
 
@@ -123,6 +430,9 @@ var _ = Describe("The public API", func() {
 		}
 	},
 		Entry("by name", "name=Test Export Request 1", []string{"Test Export Request 1"}, 1, http.StatusOK),
+		Entry("by part of the name, ignoring the case", "name=export+request+2", []string{"Test Export Request 2"}, 1, http.StatusOK),
+		Entry("by a part of the name shared by all", "name=TEST", []string{"Test Export Request 1", "Test Export Request 6"}, 6, http.StatusOK),
+		Entry("by a name with wildcards, which match literally", "name=Test%25Request", []string{}, 0, http.StatusOK),
 		Entry("by status", "status=pending", []string{"Test Export Request 1", "Test Export Request 2", "Test Export Request 3", "Test Export Request 4", "Test Export Request 5", "Test Export Request 6"}, 6, http.StatusOK),
 		Entry("by created at (given date)", "created_at=2021-01-01", []string{}, 0, http.StatusOK),
 		Entry("by created at (given date-time)", "created_at=2021-01-01T00:00:00Z", []string{}, 0, http.StatusOK),
@@ -136,6 +446,8 @@ var _ = Describe("The public API", func() {
 		Entry("by resource that doesn't exist", "resource=notAResource", []string{}, 0, http.StatusOK),
 		Entry("by application and resource that don't exist", "application=notAnApp&resource=notAResource", []string{}, 0, http.StatusOK),
 		Entry("by application and resource combination that doesn't exist", "application=exampleApp&resource=exampleResource2", []string{}, 0, http.StatusOK),
+		Entry("by improper created at range", "created_at_gte=spring", []string{"'spring' is not a valid date in ISO 8601"}, 1, http.StatusBadRequest),
+		Entry("by inverted expires range", "expires_at_gte=2023-01-02&expires_at_lte=2023-01-01", []string{"invalid range: expires_at_gte is after expires_at_lte"}, 1, http.StatusBadRequest),
 	)
 
 	Describe("can filter exports by date", func() {
@@ -239,6 +551,43 @@ var _ = Describe("The public API", func() {
 		})
 	})
 
+	Describe("can filter exports by date range", func() {
+		list := func(query string) string {
+			router := populateTestData()
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports?%s", query), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			return rr.Body.String()
+		}
+
+		It("with both bounds of created at as dates", func() {
+			yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+			today := time.Now().UTC().Format("2006-01-02")
+
+			// the lte date includes the whole day
+			body := list(fmt.Sprintf("created_at_gte=%s&created_at_lte=%s", yesterday, today))
+			Expect(body).To(ContainSubstring("count\":5"))
+			Expect(body).To(ContainSubstring("Test Export Request 1"))
+			Expect(body).ToNot(ContainSubstring("Test Export Request 2"))
+		})
+
+		It("with the lower bound of created at", func() {
+			body := list(fmt.Sprintf("created_at_gte=%s", time.Now().UTC().Add(-time.Hour).Format(formatDateTime)))
+			Expect(body).To(ContainSubstring("count\":5"))
+			Expect(body).ToNot(ContainSubstring("Test Export Request 1"))
+			Expect(body).To(ContainSubstring("Test Export Request 2"))
+		})
+
+		It("with the upper bound of expires at", func() {
+			body := list(fmt.Sprintf("expires_at_lte=%s", time.Now().UTC().AddDate(0, 0, 2).Format("2006-01-02")))
+			Expect(body).To(ContainSubstring("count\":1"))
+			Expect(body).To(ContainSubstring("Test Export Request 3"))
+		})
+	})
+
 	DescribeTable("can offset and limit exports", func(param string, expectedFirst, expectedLast string) {
 		// make a large amount of data
 		router := setupTest(mockRequestApplicationResources)
@@ -474,9 +823,110 @@ var _ = Describe("The public API", func() {
 		Expect(wasKafkaMessageSent).To(BeTrue())
 	})
 
-	// It("can get a completed export request by ID and download it")
+	Describe("with an idempotency key", func() {
+		var announced int
+		var router chi.Router
 
-	It("can delete a specific export request by ID", func() {
+		BeforeEach(func() {
+			announced = 0
+			router = setupTest(func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
+				announced++
+			})
+		})
+
+		post := func(key, name string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := createExportRequest(name, "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			req.Header.Set("Idempotency-Key", key)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		It("replays the export to the retries of the request", func() {
+			key := uuid.NewString()
+			first := post(key, "Test Export Request")
+			Expect(first.Code).To(Equal(http.StatusAccepted))
+			Expect(first.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+
+			retry := post(key, "Test Export Request")
+			Expect(retry.Code).To(Equal(http.StatusAccepted))
+			Expect(retry.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+
+			var created, replayed exports.ExportPayload
+			Expect(json.Unmarshal(first.Body.Bytes(), &created)).To(Succeed())
+			Expect(json.Unmarshal(retry.Body.Bytes(), &replayed)).To(Succeed())
+			Expect(replayed.ID).To(Equal(created.ID))
+			Expect(announced).To(Equal(1))
+
+			// another key creates another export
+			other := post(uuid.NewString(), "Test Export Request")
+			Expect(other.Code).To(Equal(http.StatusAccepted))
+			Expect(other.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+			Expect(announced).To(Equal(2))
+		})
+
+		It("rejects the key reused for another request", func() {
+			key := uuid.NewString()
+			Expect(post(key, "Test Export Request").Code).To(Equal(http.StatusAccepted))
+
+			rr := post(key, "Another Export Request")
+			Expect(rr.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rr.Body.String()).To(ContainSubstring("the Idempotency-Key was already used for another export request"))
+		})
+
+		It("creates a new export once the key expired", func() {
+			key := uuid.NewString()
+			first := post(key, "Test Export Request")
+			Expect(first.Code).To(Equal(http.StatusAccepted))
+
+			var created exports.ExportPayload
+			Expect(json.Unmarshal(first.Body.Bytes(), &created)).To(Succeed())
+			testGormDB.Exec("UPDATE export_payloads SET created_at = ? WHERE id = ?", time.Now().Add(-config.Get().IdempotencyKeyTTL-time.Minute), created.ID)
+
+			retry := post(key, "Test Export Request")
+			Expect(retry.Code).To(Equal(http.StatusAccepted))
+			Expect(retry.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+			Expect(announced).To(Equal(2))
+		})
+
+		It("rejects keys that are too long", func() {
+			Expect(post(strings.Repeat("k", 256), "Test Export Request").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	It("keys the request messages with their export", func() {
+		messages := make(chan *confluent.Message, 1)
+		export := models.ExportPayload{ID: uuid.New(), User: models.User{OrganizationID: "10000001"}}
+		source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "exampleResource", Format: models.JSON, Filters: []byte("{}")}
+
+		announce := exports.KafkaAnnounceSource(messages, nil)
+		Expect(announce(context.Background(), logger.Get(), debugHeader, export, source)).To(Succeed())
+
+		msg := <-messages
+		Expect(config.Get().KafkaConfig.MessageKey).To(Equal(ekafka.KeyExport))
+		Expect(msg.Key).To(Equal([]byte(export.ID.String())))
+	})
+
+	It("announces the requests of an application on its topic", func() {
+		cfg := config.Get()
+		cfg.KafkaConfig.ApplicationTopics = map[string]string{"exampleApp": "platform.export.requests.example"}
+		DeferCleanup(func() { cfg.KafkaConfig.ApplicationTopics = nil })
+
+		messages := make(chan *confluent.Message, 2)
+		export := models.ExportPayload{ID: uuid.New(), User: models.User{OrganizationID: "10000001"}}
+		source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "exampleResource", Format: models.JSON, Filters: []byte("{}")}
+		other := models.Source{ID: uuid.New(), Application: "otherApp", Resource: "exampleResource", Format: models.JSON, Filters: []byte("{}")}
+
+		announce := exports.KafkaAnnounceSource(messages, nil)
+		Expect(announce(context.Background(), logger.Get(), debugHeader, export, source)).To(Succeed())
+		Expect(announce(context.Background(), logger.Get(), debugHeader, export, other)).To(Succeed())
+
+		Expect(*(<-messages).TopicPartition.Topic).To(Equal("platform.export.requests.example"))
+		Expect(*(<-messages).TopicPartition.Topic).To(Equal(cfg.KafkaConfig.ExportsTopic))
+	})
+
+	It("can get a completed export request by ID and download it", func() {
 		router := setupTest(mockRequestApplicationResources)
 
 		rr := httptest.NewRecorder()
@@ -491,70 +941,973 @@ var _ = Describe("The public API", func() {
 		router.ServeHTTP(rr, req)
 		Expect(rr.Code).To(Equal(http.StatusAccepted))
 
-		// Grab the 'id' from the export request
 		var exportResponse map[string]interface{}
 		err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
 		Expect(err).ShouldNot(HaveOccurred())
 		exportUUID := exportResponse["id"].(string)
 
-		// Delete the export request
-		req, err = http.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
-		req.Header.Set("Content-Type", "application/json")
+		s3key := fmt.Sprintf("10000001/%s.tar.gz", exportUUID)
+		testGormDB.Exec("UPDATE export_payloads SET status = ?, s3_key = ? WHERE id = ?", models.Complete, s3key, exportUUID)
+		err = testStorage.Put(context.Background(), s3key, strings.NewReader("archive"), "application/gzip", es3.ObjectTags{})
 		Expect(err).ShouldNot(HaveOccurred())
 
-		AddDebugUserIdentity(req)
-		router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusAccepted))
-
-		// Check that the export was deleted
 		rr = httptest.NewRecorder()
 		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
-		req.Header.Set("Content-Type", "application/json")
 		Expect(err).ShouldNot(HaveOccurred())
 
 		AddDebugUserIdentity(req)
 		router.ServeHTTP(rr, req)
-		Expect(rr.Code).To(Equal(http.StatusNotFound))
-		Expect(rr.Body.String()).To(ContainSubstring("not found"))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Disposition")).To(Equal(fmt.Sprintf("attachment; filename=\"Test_Export_Request-%s.tar.gz\"", exportUUID)))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/gzip"))
+		Expect(rr.Body.String()).To(Equal("archive"))
+
+		// the download is recorded in the background
+		getStatus := func() exports.ExportPayload {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var status exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &status)).ShouldNot(HaveOccurred())
+			return status
+		}
+		Eventually(func() int64 { return getStatus().DownloadCount }).Should(Equal(int64(1)))
+		Expect(getStatus().LastDownloadedAt).ToNot(BeNil())
+
+		downloads, err := (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(downloads).To(HaveLen(1))
+		Expect(downloads[0].SourceID).To(BeNil())
+		Expect(downloads[0].Bytes).To(Equal(int64(len("archive"))))
+		Expect(downloads[0].OrganizationID).To(Equal("10000001"))
+		Expect(downloads[0].Presigned).To(BeFalse())
 	})
-})
 
-func mockRequestApplicationResources(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
-	// fmt.Println("MOCKED !!  KAFKA SENT: TRUE ")
-}
+	It("resumes the downloads with ranges", func() {
+		router := setupTest(mockRequestApplicationResources)
 
-func setupTest(requestAppResources exports.RequestApplicationResources) chi.Router {
-	var exportHandler *exports.Export
-	var router *chi.Mux
-	config := config.Get()
-	log := logger.Get()
+		rr := httptest.NewRecorder()
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"exampleResource"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
 
-	fmt.Println("STARTING TEST")
+		var exportResponse map[string]interface{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).ShouldNot(HaveOccurred())
+		exportUUID := exportResponse["id"].(string)
+
+		s3key := fmt.Sprintf("10000001/%s.tar.gz", exportUUID)
+		testGormDB.Exec("UPDATE export_payloads SET status = ?, s3_key = ? WHERE id = ?", models.Complete, s3key, exportUUID)
+		err := testStorage.Put(context.Background(), s3key, strings.NewReader("0123456789"), "application/gzip", es3.ObjectTags{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		download := func(byteRange, ifRange string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+			req.Header.Set("Range", byteRange)
+			if ifRange != "" {
+				req.Header.Set("If-Range", ifRange)
+			}
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr = download("bytes=0-3", "")
+		Expect(rr.Code).To(Equal(http.StatusPartialContent))
+		Expect(rr.Body.String()).To(Equal("0123"))
+		Expect(rr.Header().Get("Content-Range")).To(Equal("bytes 0-3/10"))
+		Expect(rr.Header().Get("Content-Length")).To(Equal("4"))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/gzip"))
+		lastModified := rr.Header().Get("Last-Modified")
+		Expect(lastModified).ToNot(BeEmpty())
+
+		rr = download("bytes=4-", lastModified)
+		Expect(rr.Code).To(Equal(http.StatusPartialContent))
+		Expect(rr.Body.String()).To(Equal("456789"))
+		Expect(rr.Header().Get("Content-Range")).To(Equal("bytes 4-9/10"))
+
+		rr = download("bytes=-3", "")
+		Expect(rr.Code).To(Equal(http.StatusPartialContent))
+		Expect(rr.Body.String()).To(Equal("789"))
+
+		// a changed archive is sent whole
+		rr = download("bytes=4-", `"an-etag"`)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("0123456789"))
+		Expect(rr.Header().Get("Accept-Ranges")).To(Equal("bytes"))
+
+		// several ranges are not served
+		rr = download("bytes=0-1,4-5", "")
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("0123456789"))
+
+		rr = download("bytes=10-", "")
+		Expect(rr.Code).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+		Expect(rr.Header().Get("Content-Range")).To(Equal("bytes */10"))
+
+		// only the responses reaching the end of the archive are recorded
+		Eventually(func() ([]models.Download, error) {
+			return (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		}).Should(HaveLen(4))
+	})
+
+	It("limits the downloads in progress", func() {
+		cfg := config.Get()
+		downloadConfig := cfg.DownloadConfig
+		DeferCleanup(func() { cfg.DownloadConfig = downloadConfig })
+		cfg.DownloadConfig.MaxConcurrent = 1
+		cfg.DownloadConfig.QueueTimeout = 10 * time.Millisecond
+
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"exampleResource"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse map[string]interface{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).ShouldNot(HaveOccurred())
+		exportUUID := exportResponse["id"].(string)
+
+		s3key := fmt.Sprintf("10000001/%s.tar.gz", exportUUID)
+		testGormDB.Exec("UPDATE export_payloads SET status = ?, s3_key = ? WHERE id = ?", models.Complete, s3key, exportUUID)
+		err := testStorage.Put(context.Background(), s3key, strings.NewReader("archive"), "application/gzip", es3.ObjectTags{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		download := func() *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		release, ok := testDownloads.TryAcquire()
+		Expect(ok).To(BeTrue())
+
+		rr = download()
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rr.Header().Get("Retry-After")).To(Equal("1"))
+
+		// the status is not limited
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportUUID), nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		cfg.DownloadConfig.PresignExpiry = time.Minute
+		rr = download()
+		Expect(rr.Code).To(Equal(http.StatusTemporaryRedirect))
+		Expect(rr.Header().Get("Location")).To(HavePrefix("memory://" + s3key))
+
+		release()
+		rr = download()
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal("archive"))
+
+		Eventually(func() ([]models.Download, error) {
+			return (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		}).Should(HaveLen(2))
+		downloads, err := (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect([]bool{downloads[0].Presigned, downloads[1].Presigned}).To(ConsistOf(true, false))
+	})
+
+	It("redirects the downloads to presigned urls when configured", func() {
+		cfg := config.Get()
+		storageConfig := cfg.StorageConfig
+		DeferCleanup(func() { cfg.StorageConfig = storageConfig })
+		cfg.StorageConfig.PresignDownloads = true
+		cfg.StorageConfig.PresignTTL = time.Minute
+
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"exampleResource"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse map[string]interface{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).ShouldNot(HaveOccurred())
+		exportUUID := exportResponse["id"].(string)
+
+		s3key := fmt.Sprintf("10000001/%s.tar.gz", exportUUID)
+		testGormDB.Exec("UPDATE export_payloads SET status = ?, s3_key = ? WHERE id = ?", models.Complete, s3key, exportUUID)
+		err := testStorage.Put(context.Background(), s3key, strings.NewReader("archive"), "application/gzip", es3.ObjectTags{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusFound))
+		location, err := url.Parse(rr.Header().Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(location.Host + location.Path).To(Equal(s3key))
+		Expect(location.Query().Get("filename")).To(Equal(fmt.Sprintf("Test_Export_Request-%s.tar.gz", exportUUID)))
+
+		Eventually(func() ([]models.Download, error) {
+			return (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		}).Should(HaveLen(1))
+		downloads, err := (&models.ExportDB{DB: testGormDB}).ListDownloads(uuid.MustParse(exportUUID))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(downloads[0].Presigned).To(BeTrue())
+		Expect(downloads[0].Bytes).To(BeZero())
+	})
+
+	It("returns not found when the archive of a completed export is missing", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"exampleResource"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse map[string]interface{}
+		err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+		Expect(err).ShouldNot(HaveOccurred())
+		exportUUID := exportResponse["id"].(string)
+
+		testGormDB.Exec("UPDATE export_payloads SET status = ?, s3_key = ? WHERE id = ?", models.Complete, "10000001/missing.tar.gz", exportUUID)
+
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("rejects new exports once the org is over its storage quota", func() {
+		router := setupTest(mockRequestApplicationResources)
+		exportDB := &models.ExportDB{DB: testGormDB, Cfg: config.Get()}
+
+		summary := func() exports.Summary {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/export/v1/exports/summary", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var summary exports.Summary
+			Expect(json.Unmarshal(rr.Body.Bytes(), &summary)).To(Succeed())
+			return summary
+		}
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+		export, err := exportDB.Get(uuid.MustParse(exportResponse.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(export.AddStoredBytes(exportDB, 100)).To(Succeed())
+
+		quota := int64(100)
+		_, err = exportDB.SetOrgQuota("10000001", &quota)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(summary().Storage).To(Equal(exports.StorageUsage{UsedBytes: 100, QuotaBytes: 100}))
+
+		rr = httptest.NewRecorder()
+		req = createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rr.Body.String()).To(ContainSubstring("insufficient storage"))
+
+		// deleting the export frees its storage
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", exportResponse.ID), nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(summary().Storage.UsedBytes).To(BeZero())
+
+		rr = httptest.NewRecorder()
+		req = createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("rejects new exports once the org reached its export quotas", func() {
+		router := setupTest(mockRequestApplicationResources)
+		cfg := config.Get()
+		DeferCleanup(func() { cfg.OrgMaxExports, cfg.OrgMaxInFlightExports = 0, 0 })
+		cfg.OrgMaxExports, cfg.OrgMaxInFlightExports = 3, 1
+
+		create := func() *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+		quotaError := func(rr *httptest.ResponseRecorder) exports.ExportQuotaError {
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			var body exports.ExportQuotaError
+			Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(Succeed())
+			return body
+		}
+
+		rr := create()
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		var first exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &first)).To(Succeed())
+
+		body := quotaError(create())
+		Expect(body.Quota).To(Equal(models.InFlightExportsQuota))
+		Expect(body.Usage).To(Equal(exports.ExportsUsage{Exports: 1, MaxExports: 3, InFlightExports: 1, MaxInFlightExports: 1}))
+		Expect(body.Msg).To(ContainSubstring("too many exports in flight"))
+
+		// the exports that completed no longer count as in flight
+		testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE organization_id = ?", models.Complete, "10000001")
+		Expect(create().Code).To(Equal(http.StatusAccepted))
+		testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE organization_id = ?", models.Complete, "10000001")
+		Expect(create().Code).To(Equal(http.StatusAccepted))
+		testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE organization_id = ?", models.Complete, "10000001")
+
+		body = quotaError(create())
+		Expect(body.Quota).To(Equal(models.ExportsQuota))
+		Expect(body.Usage.Exports).To(Equal(int64(3)))
+		Expect(body.Msg).To(ContainSubstring("too many exports"))
+
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/export/v1/exports/summary", nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		var summary exports.Summary
+		Expect(json.Unmarshal(rr.Body.Bytes(), &summary)).To(Succeed())
+		Expect(summary.Exports).To(Equal(exports.ExportsUsage{Exports: 3, MaxExports: 3, InFlightExports: 0, MaxInFlightExports: 1}))
+
+		// neither the expired nor the deleted exports count
+		testGormDB.Exec("UPDATE export_payloads SET expires = ? WHERE id = ?", time.Now().Add(-time.Hour), first.ID)
+		rr = create()
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		var latest exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &latest)).To(Succeed())
+		testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE organization_id = ?", models.Complete, "10000001")
+		Expect(quotaError(create()).Quota).To(Equal(models.ExportsQuota))
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", latest.ID), nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(create().Code).To(Equal(http.StatusAccepted))
+	})
+
+	Describe("relays the outbox", func() {
+		var router chi.Router
+		var relay *exports.OutboxRelay
+		var announced []string
+
+		BeforeEach(func() {
+			router = setupTest(mockRequestApplicationResources)
+			announced = nil
+			relay = &exports.OutboxRelay{
+				DB: &models.ExportDB{DB: testGormDB},
+				Announce: func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error {
+					announced = append(announced, source.ID.String())
+					return nil
+				},
+				RetryAfter: time.Minute,
+				Log:        logger.Get(),
+			}
+		})
+
+		It("until the requests for the sources are delivered", func() {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"otherResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			first, second := exportResponse.Sources[0].ID.String(), exportResponse.Sources[1].ID.String()
+
+			relay.Relay(context.Background())
+			Expect(announced).To(ConsistOf(first, second))
+
+			// the sent requests are not sent again before they are due
+			relay.Relay(context.Background())
+			Expect(announced).To(HaveLen(2))
+
+			db := &models.ExportDB{DB: testGormDB}
+			Expect(models.SetSourceDelivery(db, exportResponse.Sources[0].ID, nil)).To(Succeed())
+			relay.RetryAfter = 0
+			relay.Relay(context.Background())
+			Expect(announced).To(ConsistOf(first, second, second))
+
+			// the resolved sources are no longer requested
+			testGormDB.Exec("UPDATE sources SET status = ? WHERE id = ?", models.RFailed, second)
+			relay.Relay(context.Background())
+			relay.Relay(context.Background())
+			Expect(announced).To(HaveLen(3))
+		})
+	})
+
+	Describe("can delete many exports at once", func() {
+		var router chi.Router
+
+		createExport := func(name string) string {
+			rr := httptest.NewRecorder()
+			req := createExportRequest(name, "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			return exportResponse.ID
+		}
+
+		deleteExports := func(body string) (*httptest.ResponseRecorder, exports.BulkDeleteResult) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("DELETE", "/api/export/v1/exports", strings.NewReader(body))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+
+			var result exports.BulkDeleteResult
+			if rr.Code == http.StatusOK {
+				Expect(json.Unmarshal(rr.Body.Bytes(), &result)).To(Succeed())
+			}
+			return rr, result
+		}
+
+		BeforeEach(func() {
+			router = setupTest(mockRequestApplicationResources)
+		})
+
+		It("by their ids, with the errors of the others", func() {
+			first := createExport("first")
+			second := createExport("second")
+			createExport("kept")
+			packaging := createExport("packaging")
+			testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE id = ?", models.Packaging, packaging)
+			missing := uuid.NewString()
+
+			key := fmt.Sprintf("10000001/%s/%s.json", first, uuid.NewString())
+			Expect(testStorage.Put(context.Background(), key, strings.NewReader(`[{"id": 1}]`), "application/json", es3.ObjectTags{})).To(Succeed())
+
+			rr, result := deleteExports(fmt.Sprintf(`{"ids": [%q, %q, %q, %q, "not-a-uuid"]}`, first, missing, second, packaging))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(result.Deleted).To(HaveLen(2))
+			Expect(result.More).To(BeFalse())
+			Expect(result.Errors).To(HaveLen(3))
+			Expect(result.Errors[0].ID).To(Equal(missing))
+			Expect(result.Errors[0].Error.Code).To(Equal(http.StatusNotFound))
+			Expect(result.Errors[1].Error.Code).To(Equal(http.StatusConflict))
+			Expect(result.Errors[2].Error.Code).To(Equal(http.StatusBadRequest))
+
+			// the objects are kept until the exports are purged
+			_, err := testStorage.Get(context.Background(), key)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rr = httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/export/v1/exports", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(getExportNames(rr)).To(ConsistOf("kept", "packaging"))
+		})
+
+		It("matching a filter", func() {
+			createExport("stale one")
+			createExport("stale two")
+			createExport("fresh")
+
+			rr, result := deleteExports(`{"filter": {"name": "STALE"}}`)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(result.Deleted).To(HaveLen(2))
+			Expect(result.Errors).To(BeEmpty())
+
+			rr = httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/export/v1/exports", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(getExportNames(rr)).To(Equal([]string{"fresh"}))
+		})
+
+		DescribeTable("unless the request is invalid", func(body string) {
+			rr, _ := deleteExports(body)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		},
+			Entry("without ids or filter", `{}`),
+			Entry("with an empty filter", `{"filter": {}}`),
+			Entry("with both ids and a filter", `{"ids": ["a"], "filter": {"name": "a"}}`),
+			Entry("with an invalid date", `{"filter": {"created_at_lte": "yesterday"}}`),
+		)
+	})
+
+	Describe("can return the status of many exports at once", func() {
+		var router chi.Router
+
+		createExport := func() string {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			return exportResponse.ID
+		}
+
+		getStatus := func(ids, etag string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/export/v1/exports/status?ids="+ids, nil)
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		BeforeEach(func() {
+			router = setupTest(mockRequestApplicationResources)
+		})
+
+		It("returns the exports and the errors of the ids in order", func() {
+			owned := createExport()
+			other := createExport()
+			testGormDB.Exec("UPDATE export_payloads SET username = ? WHERE id = ?", "someone-else", other)
+			missing := uuid.NewString()
+
+			rr := getStatus(strings.Join([]string{missing, owned, "not-a-uuid", other, owned}, ","), "")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var batch struct {
+				Data []struct {
+					ID     string                 `json:"id"`
+					Export *exports.ExportPayload `json:"export"`
+					Error  *exports.Error         `json:"error"`
+				} `json:"data"`
+			}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &batch)).To(Succeed())
+			Expect(batch.Data).To(HaveLen(4))
+
+			Expect(batch.Data[0].ID).To(Equal(missing))
+			Expect(batch.Data[0].Error.Code).To(Equal(http.StatusNotFound))
+			Expect(batch.Data[1].ID).To(Equal(owned))
+			Expect(batch.Data[1].Error).To(BeNil())
+			Expect(batch.Data[1].Export.ID).To(Equal(owned))
+			Expect(batch.Data[1].Export.Status).To(Equal("pending"))
+			Expect(batch.Data[2].Error.Code).To(Equal(http.StatusBadRequest))
+			Expect(batch.Data[3].ID).To(Equal(other))
+			Expect(batch.Data[3].Export).To(BeNil())
+			Expect(batch.Data[3].Error.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("returns not modified while the status is unchanged", func() {
+			id := createExport()
+
+			rr := getStatus(id, "")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			etag := rr.Header().Get("ETag")
+			Expect(etag).ToNot(BeEmpty())
+
+			rr = getStatus(id, etag)
+			Expect(rr.Code).To(Equal(http.StatusNotModified))
+			Expect(rr.Body.Len()).To(BeZero())
+
+			testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE id = ?", models.Running, id)
+			rr = getStatus(id, etag)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("ETag")).ToNot(Equal(etag))
+		})
+
+		It("rejects batches without ids or with too many", func() {
+			Expect(getStatus("", "").Code).To(Equal(http.StatusBadRequest))
+
+			ids := make([]string, 101)
+			for i := range ids {
+				ids[i] = uuid.NewString()
+			}
+			Expect(getStatus(strings.Join(ids, ","), "").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("can download a single source", func() {
+		var router chi.Router
+		var exportUUID string
+		var completeSource, pendingSource map[string]interface{}
+
+		getSource := func(sourceUUID string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/sources/%s", exportUUID, sourceUUID), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		BeforeEach(func() {
+			router = setupTest(mockRequestApplicationResources)
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest(
+				"Test Export Request",
+				"json",
+				"",
+				`{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"slowResource", "format":"csv"}`,
+			)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			exportUUID = exportResponse["id"].(string)
+
+			sources := exportResponse["sources"].([]interface{})
+			completeSource = sources[0].(map[string]interface{})
+			pendingSource = sources[1].(map[string]interface{})
+
+			testGormDB.Exec("UPDATE sources SET status = ? WHERE id = ?", models.RSuccess, completeSource["id"])
+			key := fmt.Sprintf("10000001/%s/%s.json", exportUUID, completeSource["id"])
+			err = testStorage.Put(context.Background(), key, strings.NewReader(`[{"id": 1}]`), "application/json", es3.ObjectTags{})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("while the rest of the export is pending", func() {
+			rr := getSource(completeSource["id"].(string))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rr.Header().Get("Content-Disposition")).To(Equal(fmt.Sprintf("attachment; filename=\"exampleApp-exampleResource-%s.json\"", completeSource["id"])))
+			Expect(rr.Body.String()).To(Equal(`[{"id": 1}]`))
+		})
+
+		It("and links to it from the status", func() {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportUUID), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(fmt.Sprintf(`"download_href":"/api/export/v1/exports/%s/sources/%s"`, exportUUID, completeSource["id"])))
+			Expect(strings.Count(rr.Body.String(), "download_href")).To(Equal(1))
+		})
+
+		It("in a partial archive of the completed sources", func() {
+			getArchive := func(query string) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s%s", exportUUID, query), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			Expect(getArchive("").Code).To(Equal(http.StatusBadRequest))
+			Expect(getArchive("?allow_partial=maybe").Code).To(Equal(http.StatusBadRequest))
+
+			rr := getArchive("?allow_partial=true")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("Content-Type")).To(Equal("application/gzip"))
+			Expect(rr.Header().Get("Content-Disposition")).To(MatchRegexp(`^attachment; filename="Test_Export_Request-.*-%s-partial\.tar\.gz"$`, exportUUID))
+			Expect(rr.Header().Get("Accept-Ranges")).To(BeEmpty())
+
+			gzipReader, err := gzip.NewReader(rr.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			tarReader := tar.NewReader(gzipReader)
+			var names []string
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).ShouldNot(HaveOccurred())
+				names = append(names, header.Name)
+			}
+			Expect(names).To(ConsistOf(fmt.Sprintf("%s.json", completeSource["id"]), "meta.json", "README.md"))
+		})
+
+		It("and return the status of a single source", func() {
+			getStatus := func(sourceUUID string) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/sources/%s/status", exportUUID, sourceUUID), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			testGormDB.Exec("UPDATE sources SET size = 11, resolved_at = now() WHERE id = ?", completeSource["id"])
+			rr := getStatus(completeSource["id"].(string))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var status map[string]interface{}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &status)).To(Succeed())
+			Expect(status["id"]).To(Equal(completeSource["id"]))
+			Expect(status["export_id"]).To(Equal(exportUUID))
+			Expect(status["status"]).To(Equal("success"))
+			Expect(status["size"]).To(BeEquivalentTo(11))
+			Expect(status["requested_at"]).ToNot(BeEmpty())
+			Expect(status["resolved_at"]).ToNot(BeNil())
+			Expect(status["download_href"]).To(Equal(fmt.Sprintf("/api/export/v1/exports/%s/sources/%s", exportUUID, completeSource["id"])))
+
+			testGormDB.Exec("UPDATE sources SET status = ?, code = 400, message = 'bad filter', resolved_at = now() WHERE id = ?", models.RFailed, pendingSource["id"])
+			rr = getStatus(pendingSource["id"].(string))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"failed"`))
+			Expect(rr.Body.String()).To(ContainSubstring(`"message":"bad filter","error":400`))
+			Expect(rr.Body.String()).ToNot(ContainSubstring("download_href"))
+
+			Expect(getStatus("not-a-uuid").Code).To(Equal(http.StatusBadRequest))
+			Expect(getStatus("00000000-0000-0000-0000-000000000000").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("unless the source is not complete", func() {
+			rr := getSource(pendingSource["id"].(string))
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(rr.Body.String()).To(ContainSubstring("is 'pending'"))
+		})
+
+		It("unless the source objects were removed after packaging", func() {
+			testGormDB.Exec("UPDATE export_payloads SET source_objects_deleted = true WHERE id = ?", exportUUID)
+
+			rr := getSource(completeSource["id"].(string))
+			Expect(rr.Code).To(Equal(http.StatusGone))
+			Expect(rr.Body.String()).To(ContainSubstring(fmt.Sprintf("/api/export/v1/exports/%s", exportUUID)))
+		})
+
+		It("only for the owner of the export", func() {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/sources/%s", exportUUID, completeSource["id"]), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			req.Header.Add("x-rh-identity", otherUserHeader)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	It("scopes exports to the service account that requested them", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("User Export", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse map[string]interface{}
+		err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+		Expect(err).ShouldNot(HaveOccurred())
+		userExportUUID := exportResponse["id"].(string)
+
+		rr = httptest.NewRecorder()
+		req = createExportRequest("Service Account Export", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		req.Header.Add("x-rh-identity", serviceAccountHeader)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var stored models.ExportPayload
+		testGormDB.Where("name = ?", "Service Account Export").First(&stored)
+		Expect(stored.Username).To(Equal("b69eaf9e-e6a6-4f9e-805e-02987daddfbd"))
+		Expect(stored.IdentityType).To(Equal("ServiceAccount"))
+
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/api/export/v1/exports", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Add("x-rh-identity", serviceAccountHeader)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(getExportNames(rr)).To(Equal([]string{"Service Account Export"}))
+
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", userExportUUID), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Add("x-rh-identity", serviceAccountHeader)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("can delete a specific export request by ID", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+
+		req := createExportRequest(
+			"Test Export Request",
+			"json",
+			"",
+			`{"application":"exampleApp", "resource":"exampleResource"}`,
+		)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		// Grab the 'id' from the export request
+		var exportResponse map[string]interface{}
+		err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+		Expect(err).ShouldNot(HaveOccurred())
+		exportUUID := exportResponse["id"].(string)
+
+		// Delete the export request
+		req, err = http.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+		req.Header.Set("Content-Type", "application/json")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		// Check that the export was deleted
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+		req.Header.Set("Content-Type", "application/json")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		Expect(rr.Body.String()).To(ContainSubstring("not found"))
+	})
+
+	It("can restore a deleted export", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := httptest.NewRecorder()
+		req := createExportRequest("Test Export Request", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+
+		restore := func(id string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/api/export/v1/exports/%s/restore", id), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		Expect(restore(exportResponse.ID).Code).To(Equal(http.StatusConflict))
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", exportResponse.ID), nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/api/export/v1/exports", nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(getExportNames(rr)).To(BeEmpty())
+
+		// the export was deleted while pending, it stays cancelled
+		rr = restore(exportResponse.ID)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		var restored exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &restored)).To(Succeed())
+		Expect(restored.ID).To(Equal(exportResponse.ID))
+		Expect(restored.Status).To(Equal(string(models.Cancelled)))
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/api/export/v1/exports", nil)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(getExportNames(rr)).To(Equal([]string{"Test Export Request"}))
+
+		Expect(restore(uuid.NewString()).Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+func mockRequestApplicationResources(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
+	// fmt.Println("MOCKED !!  KAFKA SENT: TRUE ")
+}
+
+// testStorage holds the objects of the test exports, it is emptied by setupTest
+var testStorage *es3.MemoryStorage
+
+// testDownloads limits the downloads of the test exports, it is nil unless
+// MAX_CONCURRENT_DOWNLOADS is configured
+var testDownloads *emiddleware.DownloadLimiter
+
+func setupTest(requestAppResources exports.RequestApplicationResources) chi.Router {
+	var exportHandler *exports.Export
+	var router *chi.Mux
+	config := config.Get()
+	log := logger.Get()
+
+	fmt.Println("STARTING TEST")
+
+	testStorage = es3.NewMemoryStorage()
+	testDownloads = emiddleware.NewDownloadLimiter(config)
 
 	exportHandler = &exports.Export{
+		Cfg:                 config,
 		Bucket:              "cfg.StorageConfig.Bucket",
-		StorageHandler:      &es3.MockStorageHandler{},
+		StorageHandler:      &es3.Compressor{Log: log, Storage: testStorage, Cfg: *config},
 		DB:                  &models.ExportDB{DB: testGormDB, Cfg: config},
 		RequestAppResources: requestAppResources,
+		Downloads:           testDownloads,
 		Log:                 log,
 	}
 
 	router = chi.NewRouter()
 	router.Use(
-		identity.EnforceIdentity,
+		emiddleware.EnforceIdentity,
 		emiddleware.EnforceUserIdentity,
 	)
 
 	router.Route("/api/export/v1", func(sub chi.Router) {
 		sub.Post("/exports", exportHandler.PostExport)
 		sub.With(emiddleware.PaginationCtx).Get("/exports", exportHandler.ListExports)
+		sub.Delete("/exports", exportHandler.DeleteExports)
+		sub.Get("/exports/summary", exportHandler.GetSummary)
+		sub.Get("/exports/status", exportHandler.GetExportsStatus)
 		sub.Get("/exports/{exportUUID}/status", exportHandler.GetExportStatus)
 		sub.Delete("/exports/{exportUUID}", exportHandler.DeleteExport)
+		sub.Post("/exports/{exportUUID}/restore", exportHandler.RestoreExport)
 		sub.Get("/exports/{exportUUID}", exportHandler.GetExport)
+		sub.Get("/exports/{exportUUID}/sources/{sourceUUID}", exportHandler.GetExportSource)
+		sub.Get("/exports/{exportUUID}/sources/{sourceUUID}/status", exportHandler.GetExportSourceStatus)
 	})
+	router.Route("/api/export/v2/exports", exportHandler.ExportRouterV2)
+	router.Route("/api/export/v1/schedules", exportHandler.ScheduleRouter)
 
 	fmt.Println("...CLEANING DB...")
+	testGormDB.Exec("DELETE FROM schedules")
 	testGormDB.Exec("DELETE FROM export_payloads")
+	testGormDB.Exec("DELETE FROM org_storage")
 
 	return router
 }
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/faults"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// FaultsRouter lets the applications inject faults to test their consumers and
+// upload clients against. It is only routed with DEBUG=true, the faults are kept in
+// the memory of the replica that served the request.
+func (i *Internal) FaultsRouter(r chi.Router) {
+	r.Use(i.auditAdminAccess)
+	r.Get("/", i.ListFaults)
+	r.Post("/", i.PostFault)
+	r.Delete("/", i.ClearFaults)
+}
+
+// ListFaults handles GET requests to the /debug/faults endpoint.
+func (i *Internal) ListFaults(w http.ResponseWriter, r *http.Request) {
+	resp := []InjectedFault{}
+	for _, fault := range i.Faults.List() {
+		resp = append(resp, faultToAPI(fault))
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		i.Log.Errorw("error while encoding", "error", err)
+	}
+}
+
+// PostFault handles POST requests to the /debug/faults endpoint, injecting a fault
+// until its ttl is over.
+func (i *Internal) PostFault(w http.ResponseWriter, r *http.Request) {
+	logger := i.Log.With(export_logger.RequestIDField(request_id.GetReqID(r.Context())), "psk_id", middleware.GetPSKID(r.Context()))
+
+	var req FaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	fault := faults.Fault{Kind: faults.Kind(req.Kind), Application: req.Application, ExportID: req.ExportID}
+	if req.Delay != "" {
+		delay, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			BadRequestError(w, fmt.Sprintf("'%s' is not a valid delay", req.Delay))
+			return
+		}
+		fault.Delay = delay
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid ttl", req.TTL))
+		return
+	}
+
+	fault, err = i.Faults.Add(fault, ttl)
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	logger.Warnw("injected a fault", "fault_id", fault.ID, "kind", fault.Kind, "application", fault.Application, "export_id", fault.ExportID, "expires_at", fault.ExpiresAt)
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(faultToAPI(fault)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// ClearFaults handles DELETE requests to the /debug/faults endpoint, removing every
+// fault.
+func (i *Internal) ClearFaults(w http.ResponseWriter, r *http.Request) {
+	i.Faults.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func faultToAPI(fault faults.Fault) InjectedFault {
+	resp := InjectedFault{
+		ID:          fault.ID,
+		Kind:        string(fault.Kind),
+		Application: fault.Application,
+		ExportID:    fault.ExportID,
+		ExpiresAt:   fault.ExpiresAt,
+	}
+	if fault.Delay > 0 {
+		resp.Delay = fault.Delay.String()
+	}
+	return resp
+}
+
+// FaultyAnnounceSource drops or delays the announces of the sources whose
+// applications have announce faults. A dropped or delayed announce is reported as
+// sent.
+func FaultyAnnounceSource(injector *faults.Injector, next AnnounceSource) AnnounceSource {
+	return func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error {
+		drop, delay := injector.Announce(source.Application)
+		switch {
+		case drop:
+			log.Infow("dropped the announce of the source with an injected fault", "source_id", source.ID, "application", source.Application)
+			return nil
+		case delay > 0:
+			log.Infow("delaying the announce of the source with an injected fault", "source_id", source.ID, "application", source.Application, "delay", delay)
+			time.AfterFunc(delay, func() {
+				if err := next(detachedContext{ctx}, log, identity, payload, source); err != nil {
+					log.Errorw("failed to send the delayed announce of the source", "source_id", source.ID, "error", err)
+				}
+			})
+			return nil
+		}
+		return next(ctx, log, identity, payload, source)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+const (
+	// idempotencyKeyHeader carries the key that makes the retries of a request create
+	// a single export
+	idempotencyKeyHeader = "Idempotency-Key"
+	// replayedHeader is set on the responses replaying the creation of an export
+	replayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// parseIdempotencyKey returns the Idempotency-Key of the request, empty when there is
+// none.
+func parseIdempotencyKey(r *http.Request) (string, error) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("the %s may have at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+	return key, nil
+}
+
+// idempotencyFingerprint identifies the requested export, regardless of the formatting
+// of the request.
+func idempotencyFingerprint(apiExport ExportPayload) (string, error) {
+	request, err := json.Marshal(apiExport)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(request)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// replayExport responds with the export the user created with the Idempotency-Key
+// within the IdempotencyKeyTTL, the keys of the older exports are released. It
+// reports whether it responded, which it does as well when the lookup failed.
+func (e *Export) replayExport(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, key, fingerprint string, user models.User) bool {
+	db := e.DB.WithContext(r.Context())
+	if err := db.ReleaseIdempotencyKeys(key, user, time.Now().Add(-e.Cfg.IdempotencyKeyTTL)); err != nil {
+		logger.Errorw("failed to release the expired idempotency keys", "error", err)
+		InternalServerError(w, err)
+		return true
+	}
+
+	export, err := db.GetByIdempotencyKey(key, user)
+	if errors.Is(err, models.ErrRecordNotFound) {
+		return false
+	}
+	if err != nil {
+		logger.Errorw("error querying for the export of the idempotency key", "error", err)
+		InternalServerError(w, err)
+		return true
+	}
+
+	auditExports(r, export.ID)
+	if export.IdempotencyFingerprint != fingerprint {
+		logger.Infow("idempotency key reused for another request", "export_id", export.ID)
+		JSONError(w, fmt.Sprintf("the %s was already used for another export request", idempotencyKeyHeader), http.StatusUnprocessableEntity)
+		return true
+	}
+
+	logger.Infow("replaying the creation of the export", "export_id", export.ID)
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(getSerializer(r).export(r, *export)); err != nil {
+		logger.Errorw("error while trying to encode", "error", err)
+	}
+	return true
+}
@@ -5,18 +5,30 @@ SPDX-License-Identifier: Apache-2.0
 package exports
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/redhatinsights/platform-go-middlewares/request_id"
 	"go.uber.org/zap"
 
 	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/faults"
 	export_logger "github.com/redhatinsights/export-service-go/logger"
 	"github.com/redhatinsights/export-service-go/middleware"
 	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
 	"github.com/redhatinsights/export-service-go/s3"
 )
 
@@ -26,16 +38,63 @@ type Internal struct {
 	Compressor s3.StorageHandler
 	DB         models.DBInterface
 	Log        *zap.SugaredLogger
+	// Announce asks an application for the data of a source again
+	Announce AnnounceSource
+	Cleaner  *Cleaner
+	// Events publishes the resolution of the sources, it may be nil
+	Events notify.StatusPublisher
+	// Faults are injected on demand with DEBUG=true, it must be set along with DEBUG
+	Faults *faults.Injector
 }
 
 // InternalRouter is a router for all of the internal routes which require exportuuid,
 // application name, and resourceuuid.
 func (i *Internal) InternalRouter(r chi.Router) {
+	timeout := middleware.Timeout(i.Cfg.HTTPConfig.RequestTimeout)
+	// the uploads and their downloads stream whole payloads
+	uploadTimeout := middleware.Timeout(i.Cfg.HTTPConfig.DownloadTimeout)
+
 	r.Route("/{exportUUID}/{application}/{resourceUUID}", func(sub chi.Router) {
 		sub.Use(middleware.URLParamsCtx)
-		sub.Post("/upload", i.PostUpload)
-		sub.Post("/error", i.PostError)
+		sub.With(uploadTimeout).Post("/upload", i.PostUpload)
+		sub.With(uploadTimeout).Get("/upload", i.GetUpload)
+		sub.Route("/upload/multipart", i.MultipartRouter)
+		sub.With(timeout).Post("/error", i.PostError)
 	})
+	r.With(timeout).Route("/exports", i.AdminRouter)
+	r.With(timeout).Route("/orgs/{orgID}/storage", i.OrgStorageRouter)
+	r.With(timeout).Route("/cleanup", i.CleanupRouter)
+	r.With(timeout).Get("/applications/{application}/policy", i.GetApplicationPolicy)
+	r.With(timeout, i.auditAdminAccess).Get("/psks", i.ListPSKs)
+	r.With(timeout, i.auditAdminAccess, middleware.PaginationCtx).Get("/audit", i.ListAuditEvents)
+	// the faults are compiled in but never reachable in production
+	if i.Cfg.Debug {
+		r.With(timeout).Route("/debug/faults", i.FaultsRouter)
+	}
+}
+
+// GetApplicationPolicy handles GET requests to the /applications/{application}/policy
+// endpoint, so that the applications can check the limits that apply to them.
+func (i *Internal) GetApplicationPolicy(w http.ResponseWriter, r *http.Request) {
+	application := chi.URLParam(r, "application")
+	policy := i.Cfg.ApplicationPolicy(application)
+
+	effective := ApplicationPolicy{
+		Application:    application,
+		MaxUploadBytes: policy.MaxUploadBytes,
+		MaxExportBytes: i.Cfg.LimitsConfig.MaxExportBytes,
+		AllowedFormats: []string{},
+
+		SourceTimeoutSeconds: int64(i.Cfg.ApplicationSourceTimeout(application).Seconds()),
+	}
+	for _, format := range models.PayloadFormats {
+		if formatAllowed(policy, format) {
+			effective.AllowedFormats = append(effective.AllowedFormats, string(format))
+		}
+	}
+	if err := json.NewEncoder(w).Encode(effective); err != nil {
+		i.Log.Errorw("error while encoding", "error", err)
+	}
 }
 
 // PostError receives a POST request from the export source which contains the
@@ -60,18 +119,23 @@ func (i *Internal) PostError(w http.ResponseWriter, r *http.Request) {
 		BadRequestError(w, err.Error())
 		return
 	}
+	if sourceError.Code == nil || sourceError.Message == nil {
+		BadRequestError(w, "the error must have an error code and a message")
+		return
+	}
 	// convert the json error to a db error (because 'error' is called 'code' in the db)
 	modelError := models.SourceError{
-		Message: *sourceError.Message,
-		Code:    *sourceError.Code,
+		Message:   *sourceError.Message,
+		Code:      *sourceError.Code,
+		Retriable: sourceError.Retriable,
 	}
 
-	payload, err := i.DB.Get(params.ExportUUID)
+	payload, err := i.DB.WithContext(r.Context()).Get(params.ExportUUID)
 	if err != nil {
 		switch err {
 		case models.ErrRecordNotFound:
 			logger.Debugw("export not found", "error", err)
-			NotFoundError(w, fmt.Sprintf("record '%s' not found", params.ExportUUID))
+			i.exportNotFound(w, r, logger, params.ExportUUID)
 			return
 		default:
 			logger.Errorw("error querying for payload entry", "error", err)
@@ -87,27 +151,96 @@ func (i *Internal) PostError(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if source.Status == models.RSuccess || source.Status == models.RFailed {
-		// TODO: revisit this logic and response. Do we want to allow a re-write of an already completed source?
-		w.WriteHeader(http.StatusGone)
-		Logerr(w.Write([]byte("this resource has already been processed")))
+	if pskForbidden(w, r, logger, source) {
+		return
+	}
+
+	// TODO: revisit this logic and response. Do we want to allow a re-write of an already completed source?
+	if sourceResolved(w, payload, source) {
 		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 
-	if err := payload.SetSourceStatus(i.DB, params.ResourceUUID, models.RFailed, &modelError); err != nil {
-		logger.Errorw("failed to set source status for failed export", "error", err)
+	if err := i.resolveSource(i.DB.WithContext(r.Context()), payload, params.ResourceUUID, models.RFailed, &modelError); err != nil {
+		logger.Errorw("failed to resolve source for failed export", "error", err)
 		InternalServerError(w, err)
+	}
+}
+
+// exportNotFound tells the application that the export is gone, with a 410 when the
+// export was cancelled so that it can tell a cancellation from an error.
+func (i *Internal) exportNotFound(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, exportUUID uuid.UUID) {
+	cancelled, err := i.DB.WithContext(r.Context()).GetCancelledExport(exportUUID)
+	if err != nil {
+		if !errors.Is(err, models.ErrRecordNotFound) {
+			logger.Errorw("error querying for the cancelled export", "error", err)
+		}
+		NotFoundError(w, fmt.Sprintf("record '%s' not found", exportUUID))
 		return
 	}
+	GoneError(w, fmt.Sprintf("export cancelled: '%s' was deleted at %s", exportUUID, cancelled.CancelledAt.UTC().Format(time.RFC3339)))
+}
 
-	if err := payload.SetStatusRunning(i.DB); err != nil {
-		logger.Errorw("failed to save status update for failed export", "error", err)
-		InternalServerError(w, err)
+// pskForbidden responds with a 403 to the reports for a source of an application the
+// psk is not scoped to, and reports whether it responded. The application is the one
+// of the source rather than the one of the url, which the caller chooses.
+func pskForbidden(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, source *models.Source) bool {
+	if middleware.PSKAllows(r.Context(), source.Application) {
+		return false
 	}
+	logger.Warnw("psk is not scoped to the application of the source", "application", source.Application, "psk_id", middleware.GetPSKID(r.Context()))
+	ForbiddenError(w, fmt.Sprintf("the psk may not report the sources of '%s'", source.Application))
+	return true
+}
 
-	i.Compressor.ProcessSources(i.DB, params.ExportUUID)
+// sourceResolved responds with a 410 to the reports for a source that is no longer
+// pending, and reports whether it responded.
+func sourceResolved(w http.ResponseWriter, payload *models.ExportPayload, source *models.Source) bool {
+	switch source.Status {
+	case models.RPending:
+		return false
+	case models.RCancelled:
+		GoneError(w, fmt.Sprintf("export cancelled: '%s' was cancelled by its user", payload.ID))
+	default:
+		w.WriteHeader(http.StatusGone)
+		Logerr(w.Write([]byte("this resource has already been processed")))
+	}
+	return true
+}
+
+// resolveSource moves a source into its final status and, once every source of the
+// export has reported, starts packaging the export.
+// The statuses are updated with the db of the request, the packaging outlives it.
+func (i *Internal) resolveSource(db models.DBInterface, payload *models.ExportPayload, sourceUUID uuid.UUID, status models.ResourceStatus, sourceError *models.SourceError) error {
+	if err := payload.SetSourceStatus(db, sourceUUID, status, sourceError); err != nil {
+		return fmt.Errorf("failed to set source status: %w", err)
+	}
+	if _, source, err := payload.GetSource(sourceUUID); err == nil {
+		resolved := *source
+		resolved.Status, resolved.SourceError = status, sourceError
+		event := notify.StatusSourceCompleted
+		if status == models.RFailed {
+			event = notify.StatusSourceFailed
+		}
+		notify.PublishStatus(i.Events, event, *payload, &resolved)
+	}
+	return i.packageResolved(db, payload)
+}
+
+// packageResolved packages the export once its last source was resolved, it moves on
+// to running while sources are pending.
+func (i *Internal) packageResolved(db models.DBInterface, payload *models.ExportPayload) error {
+	if err := payload.SetStatusRunning(db); err != nil {
+		if errors.Is(err, models.ErrStatusConflict) {
+			// the last sources were resolved at once, another request packages the export
+			return nil
+		}
+		return fmt.Errorf("failed to set export status running: %w", err)
+	}
+
+	i.Compressor.ProcessSources(i.DB, payload.ID)
+	return nil
 }
 
 // PostUpload receives a POST request from the export source containing
@@ -127,12 +260,19 @@ func (i *Internal) PostUpload(w http.ResponseWriter, r *http.Request) {
 
 	logger = logger.With(export_logger.ExportIDField(params.ExportUUID.String()))
 
-	payload, err := i.DB.Get(params.ExportUUID)
+	if i.Faults.TakeUploadError(params.Application) {
+		// the source is still pending, the application may retry the upload
+		logger.Infow("failed the upload with an injected fault", "application", params.Application)
+		InternalServerError(w, fmt.Sprintf("payload failed to upload: %v", faults.ErrInjected))
+		return
+	}
+
+	payload, err := i.DB.WithContext(r.Context()).Get(params.ExportUUID)
 	if err != nil {
 		switch err {
 		case models.ErrRecordNotFound:
 			logger.Debugw("export not found", "error", err)
-			NotFoundError(w, fmt.Sprintf("record '%s' not found", params.ExportUUID))
+			i.exportNotFound(w, r, logger, params.ExportUUID)
 			return
 		default:
 			logger.Errorw("error querying for payload entry", "error", err)
@@ -148,25 +288,313 @@ func (i *Internal) PostUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if source.Status == models.RSuccess || source.Status == models.RFailed {
-		// TODO: revisit this logic and response. Do we want to allow a re-write of an already zipped package?
-		w.WriteHeader(http.StatusGone)
-		Logerr(w.Write([]byte("this resource has already been processed")))
+	if pskForbidden(w, r, logger, source) {
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	// TODO: revisit this logic and response. Do we want to allow a re-write of an already zipped package?
+	if sourceResolved(w, payload, source) {
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); !uploadMatchesFormat(contentType, source.Format) {
+		logger.Infow("upload content type does not match the requested format", "content_type", contentType, "format", source.Format)
+		UnsupportedMediaTypeError(w, fmt.Sprintf("'%s' does not match the requested format '%s'", contentType, source.Format))
+		return
+	}
+
+	recordCount, err := parseRecordCount(r.Header.Get(recordCountHeader))
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	checksum, err := parseDigest(r.Header.Get(digestHeader))
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+
+	limit := i.uploadLimit(payload, source)
+	if limit.full() {
+		i.rejectUpload(w, r, logger, payload, source, limit)
+		return
+	}
+	if limit.Bytes > 0 {
+		if r.ContentLength > limit.Bytes {
+			i.rejectUpload(w, r, logger, payload, source, limit)
+			return
+		}
+		r.Body = middleware.MaxBytesReader(r.Body, limit.Bytes)
+	}
 
-	if err := i.Compressor.CreateObject(r.Context(), i.DB, r.Body, params.Application, params.ResourceUUID, payload); err != nil {
+	if err := i.Compressor.CreateObject(r.Context(), i.DB.WithContext(r.Context()), r.Body, checksum, params.Application, params.ResourceUUID, payload); err != nil {
+		var tooLarge *middleware.BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			i.rejectUpload(w, r, logger, payload, source, limit)
+			return
+		}
+		var mismatch *s3.ChecksumMismatchError
+		if errors.As(err, &mismatch) {
+			checksumMismatch(w, logger, mismatch)
+			return
+		}
+		if s3.IsTimeout(err) {
+			// the source is still pending, the application may retry the upload
+			logger.Errorw("upload timed out", "error", err)
+			GatewayTimeoutError(w, fmt.Sprintf("payload failed to upload: %v", err))
+			return
+		}
+		if errors.Is(err, models.ErrStatusConflict) {
+			logger.Infow("the export no longer accepts uploads", "error", err)
+			GoneError(w, fmt.Sprintf("'%s' is packaged and no longer accepts uploads", params.ExportUUID))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
 		Logerr(w.Write([]byte(fmt.Sprintf("payload failed to upload: %v", err))))
-	} else {
-		Logerr(w.Write([]byte("payload delivered")))
+		// the storage handler has marked the source as failed
+		i.Compressor.ProcessSources(i.DB, params.ExportUUID)
+		return
+	}
+
+	delivered := "payload delivered"
+	if recordCount == nil {
+		// the body has been read, the count may follow it as a trailer
+		recordCount, err = parseRecordCount(r.Trailer.Get(recordCountHeader))
+		if err != nil {
+			// the payload is stored already, only the count is rejected
+			logger.Infow("rejected the record count trailer of the upload", "error", err)
+			delivered = fmt.Sprintf("payload delivered, the %s trailer was ignored: %v", recordCountHeader, err)
+		}
+	}
+	if recordCount != nil {
+		if err := payload.SetSourceRecordCount(i.DB.WithContext(r.Context()), params.ResourceUUID, *recordCount); err != nil {
+			logger.Errorw("failed to set the record count of the source", "error", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	Logerr(w.Write([]byte(delivered)))
+
+	if err := i.resolveSource(i.DB.WithContext(r.Context()), payload, params.ResourceUUID, models.RSuccess, nil); err != nil {
+		logger.Errorw("failed to resolve source for successful export", "error", err)
+		InternalServerError(w, err)
 	}
+}
+
+// recordCountHeader optionally carries the number of records of an upload, either as
+// a header or as a trailer once the application is done streaming the payload.
+const recordCountHeader = "X-Record-Count"
+
+// parseRecordCount parses the record count reported for an upload, nil when it was
+// not reported.
+func parseRecordCount(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("'%s' is not a valid %s, it must be a non-negative integer", value, recordCountHeader)
+	}
+	return &count, nil
+}
+
+// digestHeader optionally carries the sha-256 checksum of an upload, e.g.
+// `sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=`, which the upload is verified
+// against.
+const digestHeader = "Digest"
+
+// parseDigest returns the hex encoded sha256 checksum of the Digest header of an
+// upload, or an empty string when it has no sha-256 digest. Digests with other
+// algorithms are ignored.
+func parseDigest(value string) (string, error) {
+	for _, digest := range strings.Split(value, ",") {
+		algorithm, encoded, ok := strings.Cut(strings.TrimSpace(digest), "=")
+		if !ok || !strings.EqualFold(algorithm, "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sum) != sha256.Size {
+			return "", fmt.Errorf("'%s' is not a valid %s, the sha-256 must be a base64 encoded sha-256 checksum", value, digestHeader)
+		}
+		return hex.EncodeToString(sum), nil
+	}
+	return "", nil
+}
+
+// checksumMismatch responds to an upload whose checksum is not the one of its
+// Digest, the source is still pending so that the application can upload it again.
+func checksumMismatch(w http.ResponseWriter, logger *zap.SugaredLogger, err *s3.ChecksumMismatchError) {
+	logger.Infow("rejected an upload whose checksum does not match its digest", "error", err)
+	BadRequestError(w, err.Error())
+}
+
+// uploadLimit is the size the payload of a source may have.
+type uploadLimit struct {
+	// Bytes is the size, 0 is unlimited unless ExportBytes is set
+	Bytes int64
+	// ExportBytes is the limit of the payloads of all the sources of the export, set
+	// when the bytes the export may still store bound the payload rather than the
+	// limit of its application
+	ExportBytes int64
+}
+
+// full reports whether the export stores its limit already, no payload fits.
+func (l uploadLimit) full() bool {
+	return l.ExportBytes > 0 && l.Bytes <= 0
+}
+
+// uploadLimit returns the size the payload of the source may have: the limit of its
+// application, bounded by what is left of the limit of the export once the payloads
+// of its other sources are counted. The uploads of the sources of an export that run
+// at the same time are each bounded by what is left when they start.
+func (i *Internal) uploadLimit(payload *models.ExportPayload, source *models.Source) uploadLimit {
+	limit := uploadLimit{Bytes: i.Cfg.ApplicationPolicy(source.Application).MaxUploadBytes}
+	maxExportBytes := i.Cfg.LimitsConfig.MaxExportBytes
+	if maxExportBytes <= 0 {
+		return limit
+	}
+	left := maxExportBytes - payload.UploadedBytes(source.ID)
+	if limit.Bytes <= 0 || left < limit.Bytes {
+		limit = uploadLimit{Bytes: left, ExportBytes: maxExportBytes}
+	}
+	return limit
+}
 
-	if err := payload.SetSourceStatus(i.DB, params.ResourceUUID, models.RSuccess, nil); err != nil {
-		logger.Errorw("failed to set source status for successful export", "error", err)
+// rejectUpload fails the source whose payload is larger than allowed for its
+// application or its export, the error tells the user why.
+func (i *Internal) rejectUpload(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, payload *models.ExportPayload, source *models.Source, limit uploadLimit) {
+	logger.Infow("rejected an upload larger than its limit", "application", source.Application, "limit", limit.Bytes, "export_limit", limit.ExportBytes)
+
+	sourceError := uploadTooLarge(source, limit)
+	if err := i.resolveSource(i.DB.WithContext(r.Context()), payload, source.ID, models.RFailed, &sourceError); err != nil {
+		logger.Errorw("failed to resolve source for rejected upload", "error", err)
 		InternalServerError(w, err)
+		return
+	}
+	RequestEntityTooLargeError(w, sourceError.Message)
+}
+
+// uploadTooLarge is the error of a source whose payload is larger than allowed.
+func uploadTooLarge(source *models.Source, limit uploadLimit) models.SourceError {
+	message := fmt.Sprintf("the payload of the source is larger than the limit of %d bytes of the application '%s'", limit.Bytes, source.Application)
+	if limit.ExportBytes > 0 {
+		message = fmt.Sprintf("the payloads of the sources of the export are larger than the limit of %d bytes of an export", limit.ExportBytes)
+	}
+	return models.SourceError{Message: message, Code: http.StatusRequestEntityTooLarge}
+}
+
+// GetUpload streams the payload uploaded for a source back to the application that
+// uploaded it, so that it can verify or re-fetch it. Only psks scoped to the
+// application may read its uploads.
+func (i *Internal) GetUpload(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+
+	logger := i.Log.With(export_logger.RequestIDField(reqID))
+
+	params := middleware.GetURLParams(r.Context())
+	if params == nil {
+		InternalServerError(w, "unable to parse url params")
+		return
+	}
+
+	logger = logger.With(export_logger.ExportIDField(params.ExportUUID.String()))
+
+	if applications, ok := middleware.GetPSKApplications(r.Context()); !ok || !middleware.SliceContainsString(applications, params.Application) {
+		logger.Infow("psk is not scoped to the application", "application", params.Application, "psk_id", middleware.GetPSKID(r.Context()))
+		ForbiddenError(w, fmt.Sprintf("the psk may not read the uploads of '%s'", params.Application))
+		return
 	}
 
-	i.Compressor.ProcessSources(i.DB, params.ExportUUID)
+	payload, err := i.DB.WithContext(r.Context()).Get(params.ExportUUID)
+	if err != nil {
+		switch err {
+		case models.ErrRecordNotFound:
+			NotFoundError(w, fmt.Sprintf("record '%s' not found", params.ExportUUID))
+			return
+		default:
+			logger.Errorw("error querying for payload entry", "error", err)
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	_, source, err := payload.GetSource(params.ResourceUUID)
+	if err != nil || source.Application != params.Application {
+		NotFoundError(w, fmt.Sprintf("source '%s' not found", params.ResourceUUID))
+		return
+	}
+
+	notFound := fmt.Sprintf("the upload for source '%s' is not available", params.ResourceUUID)
+	if source.Status != models.RSuccess || payload.SourceObjectsDeleted {
+		NotFoundError(w, notFound)
+		return
+	}
+
+	key := s3.SourceObjectKey(payload, *source)
+	info, err := i.Compressor.StatObject(r.Context(), key)
+	if err != nil {
+		storageError(w, logger, err, notFound)
+		return
+	}
+	out, err := i.Compressor.GetObject(r.Context(), key)
+	if err != nil {
+		storageError(w, logger, err, notFound)
+		return
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			logger.Errorw("failed to close body", "error", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", source.Format.ContentType())
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if digest := checksumDigest(source.Checksum); digest != "" {
+		w.Header().Set("Digest", digest)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, out); err != nil {
+		logger.Errorw("failed to stream body", "error", err)
+	}
+}
+
+// storageError writes the response for a failed read of an object from storage.
+func storageError(w http.ResponseWriter, logger *zap.SugaredLogger, err error, notFound string) {
+	logger.Errorw("failed to get object", "error", err)
+	switch {
+	case errors.Is(err, s3.ErrObjectNotFound):
+		NotFoundError(w, notFound)
+	case s3.IsTimeout(err):
+		GatewayTimeoutError(w, err.Error())
+	default:
+		InternalServerError(w, err)
+	}
+}
+
+// checksumDigest returns the Digest header value for a hex encoded sha256 checksum,
+// or an empty string when the checksum is unknown.
+func checksumDigest(checksum string) string {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// uploadMatchesFormat reports whether the Content-Type of an upload agrees with the
+// format requested for the source. Uploads without a Content-Type, or with one that
+// does not describe a known format, are accepted.
+func uploadMatchesFormat(contentType string, format models.PayloadFormat) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	uploadFormat, ok := models.FormatFromContentType(mediaType)
+	if !ok {
+		return true
+	}
+	return uploadFormat == format
 }
@@ -3,19 +3,32 @@ package exports_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"github.com/redhatinsights/platform-go-middlewares/identity"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"gorm.io/datatypes"
 
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/exports"
+	"github.com/redhatinsights/export-service-go/faults"
 	"github.com/redhatinsights/export-service-go/logger"
 	emiddleware "github.com/redhatinsights/export-service-go/middleware"
 	"github.com/redhatinsights/export-service-go/models"
@@ -28,36 +41,58 @@ var _ = Context("Set up internal handler", func() {
 
 	var internalHandler *exports.Internal
 	var router *chi.Mux
+	var announced []models.Source
+	var announcedIdentity string
+	var cancelled []models.Source
 
 	BeforeEach(func() {
+		announced = nil
+		announcedIdentity = ""
+		cancelled = nil
+		mockAnnounce := func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error {
+			announced = append(announced, source)
+			announcedIdentity = identity
+			return nil
+		}
 
 		internalHandler = &exports.Internal{
 			Cfg:        cfg,
 			Compressor: &es3.MockStorageHandler{},
 			DB:         &models.ExportDB{DB: testGormDB, Cfg: cfg},
 			Log:        log,
+			Announce:   mockAnnounce,
+			Cleaner:    &exports.Cleaner{DB: &models.ExportDB{DB: testGormDB, Cfg: cfg}, Log: log},
 		}
 
 		mockKafkaCall := func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
 		}
 
 		exportHandler := &exports.Export{
+			Cfg:                 cfg,
 			Bucket:              "cfg.StorageConfig.Bucket",
 			StorageHandler:      &es3.MockStorageHandler{},
 			DB:                  &models.ExportDB{DB: testGormDB, Cfg: cfg},
 			RequestAppResources: mockKafkaCall,
-			Log:                 log,
+			CancelSources: func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, sources []models.Source) {
+				cancelled = append(cancelled, sources...)
+			},
+			Log: log,
 		}
 
 		router = chi.NewRouter()
 		router.Use(
-			identity.EnforceIdentity,
+			emiddleware.EnforceIdentity,
 			emiddleware.EnforceUserIdentity,
 		)
 
 		router.Route("/app/export/v1", func(sub chi.Router) {
 			sub.With(emiddleware.URLParamsCtx).Post("/upload/{exportUUID}/{application}/{resourceUUID}", internalHandler.PostUpload)
 			sub.With(emiddleware.URLParamsCtx).Post("/error/{exportUUID}/{application}/{resourceUUID}", internalHandler.PostError)
+			sub.Route("/exports", internalHandler.AdminRouter)
+			sub.Route("/orgs/{orgID}/storage", internalHandler.OrgStorageRouter)
+			sub.Route("/cleanup", internalHandler.CleanupRouter)
+			sub.Get("/applications/{application}/policy", internalHandler.GetApplicationPolicy)
+			sub.With(emiddleware.PaginationCtx).Get("/audit", internalHandler.ListAuditEvents)
 		})
 
 		router.Route("/api/export/v1", func(sub chi.Router) {
@@ -65,8 +100,10 @@ var _ = Context("Set up internal handler", func() {
 			sub.With(emiddleware.PaginationCtx).Get("/exports", exportHandler.ListExports)
 			sub.Get("/exports/{exportUUID}/status", exportHandler.GetExportStatus)
 			sub.Delete("/exports/{exportUUID}", exportHandler.DeleteExport)
+			sub.Post("/exports/{exportUUID}/cancel", exportHandler.CancelExport)
 			sub.Get("/exports/{exportUUID}", exportHandler.GetExport)
 		})
+		router.Route("/api/export/v2/exports", exportHandler.ExportRouterV2)
 	})
 
 	Describe("The internal API", func() {
@@ -75,6 +112,332 @@ var _ = Context("Set up internal handler", func() {
 			testGormDB.Exec("DELETE FROM export_payloads")
 		})
 
+		It("cancels the export deleted before it finished", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp2", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			exportUUID := exportResponse["id"].(string)
+			sources := exportResponse["sources"].([]interface{})
+			resourceUUID := sources[0].(map[string]interface{})["id"].(string)
+			otherResourceUUID := sources[1].(map[string]interface{})["id"].(string)
+
+			// the first source is done, only the second one is cancelled
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", exportUUID, resourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(cancelled).To(HaveLen(1))
+			Expect(cancelled[0].ID.String()).To(Equal(otherResourceUUID))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp2/%s", exportUUID, otherResourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusGone))
+			Expect(rr.Body.String()).To(ContainSubstring("export cancelled"))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/error/%s/exampleApp2/%s", exportUUID, otherResourceUUID), bytes.NewBuffer([]byte(`{"message": "failed", "error": 1}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusGone))
+
+			// exports that never existed are still not found
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp2/%s", uuid.NewString(), otherResourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("cancels the export on request and keeps it", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp2", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			exportUUID := exportResponse["id"].(string)
+			sources := exportResponse["sources"].([]interface{})
+			resourceUUID := sources[0].(map[string]interface{})["id"].(string)
+			otherResourceUUID := sources[1].(map[string]interface{})["id"].(string)
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", exportUUID, resourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			cancel := func() *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/api/export/v1/exports/%s/cancel", exportUUID), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+			rr = cancel()
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"cancelled"`))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"success"`))
+			Expect(cancelled).To(HaveLen(1))
+			Expect(cancelled[0].ID.String()).To(Equal(otherResourceUUID))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp2/%s", exportUUID, otherResourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusGone))
+			Expect(rr.Body.String()).To(ContainSubstring("export cancelled"))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/error/%s/exampleApp2/%s", exportUUID, otherResourceUUID), bytes.NewBuffer([]byte(`{"message": "failed", "error": 1}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusGone))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+
+			// a cancelled export can not be cancelled again
+			Expect(cancel().Code).To(Equal(http.StatusConflict))
+			Expect(cancelled).To(HaveLen(1))
+		})
+
+		DescribeTable("rejects the uploads larger than the limit of their application", func(knownLength bool) {
+			cfg.ApplicationPolicies = map[string]config.ApplicationPolicy{"exampleApp": {MaxUploadBytes: 5}}
+			DeferCleanup(func() { cfg.ApplicationPolicies = nil })
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+			exportUUID := exportResponse["id"].(string)
+			resourceUUID := exportResponse["sources"].([]interface{})[0].(map[string]interface{})["id"].(string)
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", exportUUID, resourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			if !knownLength {
+				req.ContentLength = -1
+			}
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusRequestEntityTooLarge))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"failed"`))
+			Expect(rr.Body.String()).To(ContainSubstring("larger than the limit of 5 bytes of the application 'exampleApp'"))
+		},
+			Entry("announced by the request", true),
+			Entry("of unknown length", false),
+		)
+
+		It("rejects the uploads past the limit of their export", func() {
+			limits := cfg.LimitsConfig
+			DeferCleanup(func() { cfg.LimitsConfig = limits })
+			cfg.LimitsConfig.MaxExportBytes = 30
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp2", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var export exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+
+			upload := func(application string, sourceID uuid.UUID) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/%s/%s", export.ID, application, sourceID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+				req.ContentLength = -1
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			Expect(upload("exampleApp", export.Sources[0].ID).Code).To(Equal(http.StatusAccepted))
+			// 8 of the 30 bytes of the export are left for the second source
+			rr = upload("exampleApp2", export.Sources[1].ID)
+			Expect(rr.Code).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(rr.Body.String()).To(ContainSubstring("larger than the limit of 30 bytes of an export"))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", export.ID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring("the payloads of the sources of the export are larger than the limit of 30 bytes"))
+		})
+
+		It("reports the effective policy of the applications", func() {
+			cfg.ApplicationPolicies = map[string]config.ApplicationPolicy{"exampleApp": {MaxUploadBytes: 1024, AllowedFormats: []string{"csv"}, SourceTimeout: time.Hour}}
+			DeferCleanup(func() { cfg.ApplicationPolicies = nil })
+
+			policy := func(application string) exports.ApplicationPolicy {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", fmt.Sprintf("/app/export/v1/applications/%s/policy", application), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusOK))
+
+				var result exports.ApplicationPolicy
+				Expect(json.Unmarshal(rr.Body.Bytes(), &result)).To(Succeed())
+				return result
+			}
+
+			Expect(policy("exampleApp")).To(Equal(exports.ApplicationPolicy{Application: "exampleApp", MaxUploadBytes: 1024, MaxExportBytes: cfg.LimitsConfig.MaxExportBytes, AllowedFormats: []string{"csv"}, SourceTimeoutSeconds: 3600}))
+			Expect(policy("otherApp")).To(Equal(exports.ApplicationPolicy{
+				Application:          "otherApp",
+				MaxUploadBytes:       cfg.LimitsConfig.MaxUploadBytes,
+				MaxExportBytes:       cfg.LimitsConfig.MaxExportBytes,
+				AllowedFormats:       []string{"csv", "json"},
+				SourceTimeoutSeconds: int64(cfg.SourceTimeout.Seconds()),
+			}))
+		})
+
+		It("fails the sources pending past the timeout of their application", func() {
+			cfg.ApplicationPolicies = map[string]config.ApplicationPolicy{"exampleApp2": {SourceTimeout: time.Nanosecond}}
+			DeferCleanup(func() { cfg.ApplicationPolicies = nil })
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp2", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var export exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", export.ID, export.Sources[0].ID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			(&exports.SourceTimeouts{Internal: internalHandler}).Run(context.Background())
+
+			payload, err := internalHandler.DB.Get(uuid.MustParse(export.ID))
+			Expect(err).To(BeNil())
+			Expect(payload.Status).To(Equal(models.CompleteWithErrors))
+			_, source, err := payload.GetSource(export.Sources[1].ID)
+			Expect(err).To(BeNil())
+			Expect(source.Status).To(Equal(models.RFailed))
+			Expect(*source.SourceError).To(Equal(models.SourceTimedOut))
+			Expect(exports.DBExportToAPI(*payload).Failures).To(Equal([]models.SourceFailure{{
+				ID:          source.ID,
+				Application: source.Application,
+				Resource:    source.Resource,
+				Code:        models.SourceTimedOut.Code,
+				Message:     models.SourceTimedOut.Message,
+			}}))
+
+			// the sources of the other applications wait for the default timeout
+			sources, err := internalHandler.DB.TimeOutSources(nil, cfg.SourceTimeout)
+			Expect(err).To(BeNil())
+			Expect(sources).To(BeEmpty())
+		})
+
+		DescribeTable("checks the upload content type against the requested format", func(format, contentType string, expectedStatus int) {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", format, "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			exportUUID := exportResponse["id"].(string)
+			sources := exportResponse["sources"].([]interface{})
+			resourceUUID := sources[0].(map[string]interface{})["id"].(string)
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", exportUUID, resourceUUID), bytes.NewBuffer([]byte(`{"data": "dummy data"}`)))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(expectedStatus))
+		},
+			Entry("json upload for a json export", "json", "application/json", http.StatusAccepted),
+			Entry("json upload with charset for a json export", "json", "application/json; charset=utf-8", http.StatusAccepted),
+			Entry("csv upload for a csv export", "csv", "text/csv", http.StatusAccepted),
+			Entry("upload without a content type", "csv", "", http.StatusAccepted),
+			Entry("csv upload for a json export", "json", "text/csv", http.StatusUnsupportedMediaType),
+			Entry("json upload for a csv export", "csv", "application/json", http.StatusUnsupportedMediaType),
+		)
+
+		It("exports json from upload through download", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse map[string]interface{}
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(exportResponse["format"]).To(Equal("json"))
+			exportUUID := exportResponse["id"].(string)
+			sources := exportResponse["sources"].([]interface{})
+			resourceUUID := sources[0].(map[string]interface{})["id"].(string)
+
+			// the stored source keeps the requested format so that packaging uses the right extension
+			var source models.Source
+			Expect(testGormDB.Where("id = ?", resourceUUID).First(&source).Error).ShouldNot(HaveOccurred())
+			Expect(source.Format).To(Equal(models.JSON))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/upload/%s/exampleApp/%s", exportUUID, resourceUUID), bytes.NewBuffer([]byte(`[{"data": "dummy data"}]`)))
+			req.Header.Set("Content-Type", "application/json")
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"complete"`))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s", exportUUID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("Content-Disposition")).To(ContainSubstring("attachment"))
+		})
+
 		It("allows the user to upload a payload", func() {
 			rr := httptest.NewRecorder()
 
@@ -119,7 +482,7 @@ var _ = Context("Set up internal handler", func() {
 		It("allows the user to return an error when the export request is invalid", func() {
 			rr := httptest.NewRecorder()
 
-			req := createExportRequest("testRequest", "json", "2023-01-01T00:00:00Z", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			req := createExportRequest("testRequest", "json", nextMonth, `{"application":"exampleApp", "resource":"exampleResource"}`)
 			AddDebugUserIdentity(req)
 			router.ServeHTTP(rr, req)
 			Expect(rr.Code).To(Equal(http.StatusAccepted))
@@ -160,5 +523,1248 @@ var _ = Context("Set up internal handler", func() {
 			Expect(source["message"].(string)).To(Equal("test error"))
 			Expect(source["error"].(float64)).To(Equal(123.0))
 		})
-	})
-})
+
+		It("shows the structured error of a failed source in the status of the export", func() {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var export exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+			errorURL := fmt.Sprintf("/app/export/v1/error/%s/exampleApp/%s", export.ID, export.Sources[0].ID)
+
+			// an error without a code is refused
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", errorURL, bytes.NewBuffer([]byte(`{"message": "RBAC denied for resource exampleResource"}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", errorURL, bytes.NewBuffer([]byte(`{"message": "RBAC denied for resource exampleResource", "error": 403, "retriable": false}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/exports/%s/status", export.ID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var status exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &status)).To(Succeed())
+			Expect(status.Status).To(Equal("failed"))
+			source := status.Sources[0]
+			Expect(*source.Message).To(Equal("RBAC denied for resource exampleResource"))
+			Expect(*source.Code).To(Equal(http.StatusForbidden))
+			Expect(source.Retriable).NotTo(BeNil())
+			Expect(*source.Retriable).To(BeFalse())
+			Expect(status.Failures).To(ConsistOf(models.SourceFailure{
+				ID:          source.ID,
+				Application: "exampleApp",
+				Resource:    "exampleResource",
+				Code:        http.StatusForbidden,
+				Message:     "RBAC denied for resource exampleResource",
+			}))
+		})
+
+		It("counts the exports created and failed in the metrics", func() {
+			created := metricValue("export_service_exports_created_total", "format", "json")
+			failed := metricValue("export_service_exports_finished_total", "status", "failed")
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(metricValue("export_service_exports_created_total", "format", "json")).To(Equal(created + 1))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/error/%s/exampleApp/%s", exportResponse.ID, exportResponse.Sources[0].ID), bytes.NewBuffer([]byte(`{"message": "test error", "error": 123}`)))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(metricValue("export_service_exports_finished_total", "status", "failed")).To(Equal(failed + 1))
+		})
+
+		It("lets support engineers inspect exports of any organization", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// list the exports of the organization
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/app/export/v1/exports?org_id=10000001&status=pending", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"count":1`))
+			Expect(rr.Body.String()).To(ContainSubstring(exportResponse.ID))
+
+			// other organizations have no exports
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/app/export/v1/exports?org_id=12345", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"count":0`))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/app/export/v1/exports/%s", exportResponse.ID), nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var adminResponse exports.AdminExportPayload
+			err = json.Unmarshal(rr.Body.Bytes(), &adminResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(adminResponse.ID).To(Equal(exportResponse.ID))
+			Expect(adminResponse.OrganizationID).To(Equal("10000001"))
+			Expect(adminResponse.Username).To(Equal("user_dev"))
+			Expect(adminResponse.Sources).To(HaveLen(1))
+		})
+
+		It("lets support engineers resolve sources that will never be delivered", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(exportResponse.Sources).To(HaveLen(2))
+
+			resolve := func(sourceID, body string) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/sources/%s/resolve", exportResponse.ID, sourceID), bytes.NewBufferString(body))
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			// a failed source requires an error code
+			rr = resolve(exportResponse.Sources[0].ID.String(), `{"status": "failed"}`)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+			rr = resolve(exportResponse.Sources[0].ID.String(), `{"status": "failed", "error": 410, "message": "resource decommissioned"}`)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(rr.Body.String()).To(ContainSubstring(`"status":"running"`))
+
+			// a resolved source can not be resolved again
+			rr = resolve(exportResponse.Sources[0].ID.String(), `{"status": "complete"}`)
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+
+			// resolving the last outstanding source packages the export
+			rr = resolve(exportResponse.Sources[1].ID.String(), `{"status": "complete"}`)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var adminResponse exports.AdminExportPayload
+			err = json.Unmarshal(rr.Body.Bytes(), &adminResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(adminResponse.Status).To(Equal("complete"))
+		})
+
+		It("refuses to complete a source whose object was not stored", func() {
+			internalHandler.Compressor = &missingObjects{}
+
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).ShouldNot(HaveOccurred())
+			sourceID := exportResponse.Sources[0].ID
+
+			resolve := func(body string) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/sources/%s/resolve", exportResponse.ID, sourceID), bytes.NewBufferString(body))
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			rr = resolve(`{"status": "complete"}`)
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(rr.Body.String()).To(ContainSubstring("has no stored object"))
+
+			// the source is still pending, it can be resolved as failed
+			rr = resolve(`{"status": "failed", "error": 410, "message": "resource decommissioned"}`)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+		})
+
+		It("lets support engineers announce a pending source again", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			sourceID := exportResponse.Sources[0].ID
+
+			announce := func() *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/sources/%s/announce", exportResponse.ID, sourceID), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+
+			rr = announce()
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(announced).To(HaveLen(1))
+			Expect(announced[0].ID).To(Equal(sourceID))
+			Expect(announcedIdentity).To(Equal(debugHeader))
+
+			var adminResponse exports.AdminExportPayload
+			err = json.Unmarshal(rr.Body.Bytes(), &adminResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(adminResponse.Sources[0].AnnouncedAt).To(BeNil())
+
+			// the delivery reports of the producer are shown on the admin view
+			getSource := func() exports.AdminSource {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", fmt.Sprintf("/app/export/v1/exports/%s", exportResponse.ID), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusOK))
+
+				var adminResponse exports.AdminExportPayload
+				Expect(json.Unmarshal(rr.Body.Bytes(), &adminResponse)).To(Succeed())
+				return adminResponse.Sources[0]
+			}
+
+			db := &models.ExportDB{DB: testGormDB, Cfg: cfg}
+			Expect(models.SetSourceDelivery(db, sourceID, errors.New("broker down"))).To(Succeed())
+			source := getSource()
+			Expect(source.AnnouncedAt).To(BeNil())
+			Expect(source.DeliveryError).To(Equal("broker down"))
+
+			Expect(models.SetSourceDelivery(db, sourceID, nil)).To(Succeed())
+			source = getSource()
+			Expect(source.AnnouncedAt).ShouldNot(BeNil())
+			Expect(source.DeliveryError).To(BeEmpty())
+
+			// a complete source is not announced again
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/sources/%s/resolve", exportResponse.ID, sourceID), bytes.NewBufferString(`{"status": "complete"}`))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			rr = announce()
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(announced).To(HaveLen(1))
+		})
+
+		It("lets support engineers announce the pending sources of an export again", func() {
+			rr := httptest.NewRecorder()
+
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp2", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			err := json.Unmarshal(rr.Body.Bytes(), &exportResponse)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(exportResponse.Sources).To(HaveLen(2))
+
+			announce := func() *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/announce", exportResponse.ID), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				return rr
+			}
+			resolve := func(sourceID uuid.UUID) {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/sources/%s/resolve", exportResponse.ID, sourceID), bytes.NewBufferString(`{"status": "complete"}`))
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusAccepted))
+			}
+
+			rr = announce()
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(announced).To(HaveLen(2))
+			Expect(announcedIdentity).To(Equal(debugHeader))
+
+			// only the sources still pending are announced
+			announced = nil
+			resolve(exportResponse.Sources[0].ID)
+			rr = announce()
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			Expect(announced).To(HaveLen(1))
+			Expect(announced[0].ID).To(Equal(exportResponse.Sources[1].ID))
+
+			// a complete export is not announced again
+			announced = nil
+			resolve(exportResponse.Sources[1].ID)
+			rr = announce()
+			Expect(rr.Code).To(Equal(http.StatusConflict))
+			Expect(announced).To(BeEmpty())
+
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/exports/%s/announce", uuid.NewString()), nil)
+			AddDebugUserIdentity(req)
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("lets ops override the storage quota of an org", func() {
+			testGormDB.Exec("DELETE FROM org_storage")
+
+			request := func(method, body string) exports.OrgStorage {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest(method, "/app/export/v1/orgs/12345/storage", bytes.NewBufferString(body))
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusOK))
+
+				var usage exports.OrgStorage
+				Expect(json.Unmarshal(rr.Body.Bytes(), &usage)).To(Succeed())
+				return usage
+			}
+
+			usage := request("GET", "")
+			Expect(usage.OrganizationID).To(Equal("12345"))
+			Expect(usage.UsedBytes).To(BeZero())
+			Expect(usage.QuotaBytes).To(Equal(cfg.OrgStorageQuotaBytes))
+			Expect(usage.QuotaOverride).To(BeNil())
+
+			usage = request("PUT", `{"quota_bytes": 1048576}`)
+			Expect(usage.QuotaBytes).To(Equal(int64(1048576)))
+			Expect(*usage.QuotaOverride).To(Equal(int64(1048576)))
+			Expect(request("GET", "").QuotaBytes).To(Equal(int64(1048576)))
+
+			// a null quota restores the default
+			usage = request("PUT", `{"quota_bytes": null}`)
+			Expect(usage.QuotaBytes).To(Equal(cfg.OrgStorageQuotaBytes))
+			Expect(usage.QuotaOverride).To(BeNil())
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/app/export/v1/orgs/12345/storage", bytes.NewBufferString(`{"quota_bytes": -1}`))
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("lets ops run the cleanup on demand", func() {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			var export exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+			testGormDB.Exec("UPDATE export_payloads SET expires = ? WHERE id = ?", time.Now().AddDate(0, 0, -cfg.ExportExpiryDays-1), export.ID)
+
+			cleanup := func(method, path string, expectedStatus int, result interface{}) {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest(method, path, nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(expectedStatus))
+				Expect(json.Unmarshal(rr.Body.Bytes(), result)).To(Succeed())
+			}
+
+			var report exports.CleanupReport
+			cleanup("POST", "/app/export/v1/cleanup?dry_run=true", http.StatusOK, &report)
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.ExpiredExports).To(HaveLen(1))
+			Expect(report.ExpiredExports[0].ID).To(Equal(export.ID))
+			var count int64
+			testGormDB.Model(&models.ExportPayload{}).Where("id = ?", export.ID).Count(&count)
+			Expect(count).To(Equal(int64(1)))
+
+			var status exports.CleanupStatus
+			cleanup("POST", "/app/export/v1/cleanup", http.StatusAccepted, &status)
+			Eventually(func() bool {
+				cleanup("GET", "/app/export/v1/cleanup/status", http.StatusOK, &status)
+				return status.Running
+			}).Should(BeFalse())
+			Expect(status.LastRun.DryRun).To(BeFalse())
+			Expect(status.LastRun.ExpiredExports).To(HaveLen(1))
+			Expect(status.LastRun.Errors).To(BeEmpty())
+			testGormDB.Model(&models.ExportPayload{}).Where("id = ?", export.ID).Count(&count)
+			Expect(count).To(BeZero())
+		})
+
+		It("drops the months whose exports all expired", func() {
+			rr := httptest.NewRecorder()
+			req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+			var export exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+
+			// the export is moved, with its sources, to a month of its own
+			created := time.Now().AddDate(0, -2, 0)
+			partition := models.PartitionOf(created)
+			Expect(testGormDB.Exec("SELECT create_export_partitions(?, ?)", created, created).Error).To(Succeed())
+			Expect(testGormDB.Exec("UPDATE export_payloads SET created_at = ?, expires = ? WHERE id = ?",
+				created, time.Now().AddDate(0, 0, -cfg.ExportExpiryDays-1), export.ID).Error).To(Succeed())
+
+			partitions, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).ListExpiredPartitions()
+			Expect(err).To(BeNil())
+			Expect(partitions).To(ConsistOf(partition))
+
+			report, err := internalHandler.Cleaner.Run(context.Background(), false)
+			Expect(err).To(BeNil())
+			Expect(report.Errors).To(BeEmpty())
+			Expect(report.DroppedPartitions).To(ConsistOf(partition.String()))
+			Expect(report.ExpiredExports).To(HaveLen(1))
+			Expect(report.ExpiredExports[0].ID.String()).To(Equal(export.ID))
+
+			var tables int64
+			testGormDB.Raw("SELECT count(*) FROM pg_class WHERE relname IN (?, ?)", partition.Table("export_payloads"), partition.Table("sources")).Scan(&tables)
+			Expect(tables).To(BeZero())
+			var count int64
+			testGormDB.Model(&models.ExportPayload{}).Where("id = ?", export.ID).Count(&count)
+			Expect(count).To(BeZero())
+		})
+
+		It("purges the deleted exports past their retention", func() {
+			var deleted []exports.ExportPayload
+			for _, age := range []time.Duration{cfg.DeletedExportRetention + time.Hour, time.Hour} {
+				rr := httptest.NewRecorder()
+				req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusAccepted))
+				var export exports.ExportPayload
+				Expect(json.Unmarshal(rr.Body.Bytes(), &export)).To(Succeed())
+				Expect(testGormDB.Exec("UPDATE export_payloads SET deleted_at = ? WHERE id = ?", time.Now().Add(-age), export.ID).Error).To(Succeed())
+				deleted = append(deleted, export)
+			}
+
+			report, err := internalHandler.Cleaner.Run(context.Background(), true)
+			Expect(err).To(BeNil())
+			Expect(report.PurgedExports).To(HaveLen(1))
+			Expect(report.PurgedExports[0].ID.String()).To(Equal(deleted[0].ID))
+
+			report, err = internalHandler.Cleaner.Run(context.Background(), false)
+			Expect(err).To(BeNil())
+			Expect(report.Errors).To(BeEmpty())
+			Expect(report.PurgedExports).To(HaveLen(1))
+			Expect(report.PurgedExports[0].ID.String()).To(Equal(deleted[0].ID))
+
+			// the export deleted recently can still be restored
+			var ids []string
+			testGormDB.Unscoped().Model(&models.ExportPayload{}).Pluck("id", &ids)
+			Expect(ids).To(ConsistOf(deleted[1].ID))
+		})
+
+		It("skips the cleanup while another replica holds the lock", func() {
+			unlock, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).TryLock(models.CleanupLock)
+			Expect(err).To(BeNil())
+
+			_, err = (&models.ExportDB{DB: testGormDB, Cfg: cfg}).TryLock(models.CleanupLock)
+			Expect(err).To(MatchError(models.ErrLocked))
+
+			report, err := internalHandler.Cleaner.Run(context.Background(), false)
+			Expect(err).To(BeNil())
+			Expect(report.Skipped).To(BeTrue())
+			Expect(report.Errors).To(BeEmpty())
+
+			// dry runs delete nothing, they are not skipped
+			report, err = internalHandler.Cleaner.Run(context.Background(), true)
+			Expect(err).To(BeNil())
+			Expect(report.Skipped).To(BeFalse())
+
+			unlock()
+			report, err = internalHandler.Cleaner.Run(context.Background(), false)
+			Expect(err).To(BeNil())
+			Expect(report.Skipped).To(BeFalse())
+		})
+
+		It("records who took the actions on an export in the audit trail", func() {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/api/export/v2/exports", bytes.NewBuffer(generateExportRequestBody("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)))
+			// the first entry is sent by the client, the gateway appends its address
+			req.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.10")
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+			var exportResponse exports.ExportPayload
+			Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).To(Succeed())
+
+			request := func(method, path string) {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest(method, path, nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+			}
+			// the export is not ready for download yet
+			request("GET", fmt.Sprintf("/api/export/v2/exports/%s", exportResponse.ID))
+			request("DELETE", fmt.Sprintf("/api/export/v2/exports/%s", exportResponse.ID))
+			// the status is not audited
+			request("GET", fmt.Sprintf("/api/export/v2/exports/%s/status", exportResponse.ID))
+
+			list := func(query string) []exports.AuditEvent {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", fmt.Sprintf("/app/export/v1/audit?export_id=%s&%s", exportResponse.ID, query), nil)
+				AddDebugUserIdentity(req)
+				router.ServeHTTP(rr, req)
+				Expect(rr.Code).To(Equal(http.StatusOK))
+
+				var page struct {
+					Meta emiddleware.Meta     `json:"meta"`
+					Data []exports.AuditEvent `json:"data"`
+				}
+				Expect(json.Unmarshal(rr.Body.Bytes(), &page)).To(Succeed())
+				Expect(page.Meta.Count).To(Equal(int64(len(page.Data))))
+				return page.Data
+			}
+
+			events := list("")
+			Expect(events).To(HaveLen(3))
+			Expect(events[0].Action).To(Equal("delete"))
+			Expect(events[0].Outcome).To(Equal("success"))
+			Expect(events[1].Action).To(Equal("download"))
+			Expect(events[1].Outcome).To(Equal("failure"))
+			Expect(events[1].StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(events[2].Action).To(Equal("create"))
+			Expect(events[2].Outcome).To(Equal("success"))
+			Expect(events[2].SourceIP).To(Equal("192.0.2.10"))
+			for _, event := range events {
+				Expect(*event.ExportID).To(Equal(exportResponse.ID))
+				Expect(event.OrganizationID).To(Equal("10000001"))
+				Expect(event.Username).To(Equal("user_dev"))
+			}
+
+			Expect(list("action=download")).To(HaveLen(1))
+			Expect(list("outcome=success")).To(HaveLen(2))
+			Expect(list("username=other_user")).To(BeEmpty())
+			Expect(list("since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))).To(BeEmpty())
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/app/export/v1/audit?since=yesterday", nil)
+			AddDebugUserIdentity(req)
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+			// the audit trail can not be rewritten
+			Expect(testGormDB.Exec("UPDATE audit_events SET username = 'someone_else'").Error).NotTo(BeNil())
+			Expect(testGormDB.Exec("DELETE FROM audit_events").Error).NotTo(BeNil())
+			Expect(list("")).To(HaveLen(3))
+		})
+	})
+})
+
+// missingObjects is a storage that has none of the objects of the sources.
+type missingObjects struct {
+	es3.MockStorageHandler
+}
+
+func (m *missingObjects) StatObject(ctx context.Context, key string) (es3.ObjectInfo, error) {
+	return es3.ObjectInfo{}, es3.ErrObjectNotFound
+}
+
+var _ = Describe("Reading back an upload", func() {
+	cfg := config.Get()
+	log := logger.Get()
+
+	var router *chi.Mux
+	var originalCfg *config.ExportConfig
+
+	BeforeEach(func() {
+		fmt.Println("...CLEANING DB...")
+		testGormDB.Exec("DELETE FROM export_payloads")
+		testGormDB.Exec("DELETE FROM org_storage")
+
+		originalCfg = emiddleware.Cfg
+		pskCfg := *cfg
+		pskCfg.Psks = []string{"example-psk", "other-psk", "shared-psk"}
+		pskCfg.PskApplications = map[string][]string{
+			emiddleware.PSKID("example-psk"): {"exampleApp"},
+			emiddleware.PSKID("other-psk"):   {"otherApp"},
+		}
+		emiddleware.Cfg = &pskCfg
+
+		compressor := &es3.Compressor{Log: log, Storage: es3.NewMemoryStorage(), Cfg: *cfg}
+		internalHandler := &exports.Internal{
+			Cfg:        cfg,
+			Compressor: compressor,
+			DB:         &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			Log:        log,
+		}
+		exportHandler := &exports.Export{
+			Cfg:                 cfg,
+			StorageHandler:      compressor,
+			DB:                  &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			RequestAppResources: mockRequestApplicationResources,
+			Log:                 log,
+		}
+
+		router = chi.NewRouter()
+		router.Route("/app/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforcePSK)
+			sub.Route("/", internalHandler.InternalRouter)
+		})
+		router.Route("/api/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforceIdentity, emiddleware.EnforceUserIdentity)
+			sub.Post("/exports", exportHandler.PostExport)
+		})
+	})
+
+	AfterEach(func() {
+		emiddleware.Cfg = originalCfg
+	})
+
+	// createUploadedExport creates an export with two sources and uploads the first
+	// one, so that the export stays running and the upload is kept.
+	createUploadedExport := func(body string) exports.ExportPayload {
+		rr := httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var exportResponse exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &exportResponse)).ShouldNot(HaveOccurred())
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload", exportResponse.ID, exportResponse.Sources[0].ID), bytes.NewBufferString(body))
+		req.Header.Set("X-Rh-Exports-Psk", "example-psk")
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		return exportResponse
+	}
+
+	getUpload := func(exportUUID, application, resourceUUID, psk string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/app/export/v1/%s/%s/%s/upload", exportUUID, application, resourceUUID), nil)
+		req.Header.Set("X-Rh-Exports-Psk", psk)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	It("streams the upload with its checksum", func() {
+		body := `[{"data": "dummy data"}]`
+		export := createUploadedExport(body)
+
+		rr := getUpload(export.ID, "exampleApp", export.Sources[0].ID.String(), "example-psk")
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(Equal(body))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rr.Header().Get("Content-Length")).To(Equal(fmt.Sprint(len(body))))
+
+		sum := sha256.Sum256([]byte(body))
+		Expect(rr.Header().Get("Digest")).To(Equal("sha-256=" + base64.StdEncoding.EncodeToString(sum[:])))
+
+		// the upload counts against the storage of the org
+		usage, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).GetOrgStorage("10000001")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(usage.StoredBytes).To(Equal(int64(len(body))))
+	})
+
+	It("records the counts reported with the uploads", func() {
+		rr := httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var export exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &export)).ShouldNot(HaveOccurred())
+
+		upload := func(sourceID uuid.UUID, header, trailer string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload", export.ID, sourceID), bytes.NewBufferString(`[{"data": "dummy data"}]`))
+			req.Header.Set("X-Rh-Exports-Psk", "example-psk")
+			if header != "" {
+				req.Header.Set("X-Record-Count", header)
+			}
+			if trailer != "" {
+				req.Trailer = http.Header{"X-Record-Count": []string{trailer}}
+			}
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+		getExport := func() *models.ExportPayload {
+			payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+			Expect(err).ShouldNot(HaveOccurred())
+			return payload
+		}
+
+		rr = upload(export.Sources[0].ID, "-1", "")
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		rr = upload(export.Sources[0].ID, "42000", "")
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		// the other source has not reported its count yet
+		status := exports.DBExportToAPI(*getExport())
+		Expect(*status.RecordCount).To(Equal(int64(42000)))
+		Expect(status.RecordCountPartial).To(BeTrue())
+
+		// an invalid trailer only drops the count
+		rr = upload(export.Sources[1].ID, "", "many")
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		Expect(rr.Body.String()).To(ContainSubstring("X-Record-Count trailer was ignored"))
+
+		payload := getExport()
+		Expect(payload.Status).To(Equal(models.Complete))
+		status = exports.DBExportToAPI(*payload)
+		Expect(*status.RecordCount).To(Equal(int64(42000)))
+		Expect(status.RecordCountPartial).To(BeTrue())
+		for _, source := range status.Sources {
+			if source.ID == export.Sources[0].ID {
+				Expect(*source.RecordCount).To(Equal(int64(42000)))
+			} else {
+				Expect(source.RecordCount).To(BeNil())
+			}
+		}
+	})
+
+	It("verifies the uploads against their digest", func() {
+		rr := httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var export exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &export)).ShouldNot(HaveOccurred())
+
+		body := `[{"data": "dummy data"}]`
+		sum := sha256.Sum256([]byte(body))
+		upload := func(digest string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload", export.ID, export.Sources[0].ID), bytes.NewBufferString(body))
+			req.Header.Set("X-Rh-Exports-Psk", "example-psk")
+			req.Header.Set("Digest", digest)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr = upload("sha-256=not-base64")
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		other := sha256.Sum256([]byte("other data"))
+		rr = upload("sha-256=" + base64.StdEncoding.EncodeToString(other[:]))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		Expect(rr.Body.String()).To(ContainSubstring(hex.EncodeToString(sum[:])))
+
+		// the source is still pending and the payload was not kept
+		rr = getUpload(export.ID, "exampleApp", export.Sources[0].ID.String(), "example-psk")
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		rr = upload("md5=ignored, sha-256=" + base64.StdEncoding.EncodeToString(sum[:]))
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		status := exports.DBExportToAPI(*payload)
+		for _, source := range status.Sources {
+			if source.ID == export.Sources[0].ID {
+				Expect(source.Checksum).To(Equal(hex.EncodeToString(sum[:])))
+			} else {
+				Expect(source.Checksum).To(BeEmpty())
+			}
+		}
+	})
+
+	It("only serves the upload to psks scoped to the application", func() {
+		export := createUploadedExport(`[{"data": "dummy data"}]`)
+		resourceUUID := export.Sources[0].ID.String()
+
+		rr := getUpload(export.ID, "exampleApp", resourceUUID, "other-psk")
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+		rr = getUpload(export.ID, "exampleApp", resourceUUID, "shared-psk")
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+		// the source belongs to exampleApp, so otherApp can not read it either
+		rr = getUpload(export.ID, "otherApp", resourceUUID, "other-psk")
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("only lets the scoped psks report the sources of their applications", func() {
+		export := createUploadedExport(`[{"data": "dummy data"}]`)
+		resourceUUID := export.Sources[1].ID.String()
+
+		// naming its own application in the url does not let a psk report the source
+		// of another one
+		for _, application := range []string{"exampleApp", "otherApp"} {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/%s/%s/error", export.ID, application, resourceUUID), bytes.NewBufferString(`{"message": "failed", "error": 500}`))
+			req.Header.Set("X-Rh-Exports-Psk", "other-psk")
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/%s/%s/upload", export.ID, application, resourceUUID), bytes.NewBufferString(`[{"data": "dummy data"}]`))
+			req.Header.Set("X-Rh-Exports-Psk", "other-psk")
+			router.ServeHTTP(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+		}
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/error", export.ID, resourceUUID), bytes.NewBufferString(`{"message": "failed", "error": 500}`))
+		req.Header.Set("X-Rh-Exports-Psk", "shared-psk")
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("does not serve sources that were not uploaded or were deleted", func() {
+		export := createUploadedExport(`[{"data": "dummy data"}]`)
+
+		// the second source has not been uploaded yet
+		rr := getUpload(export.ID, "exampleApp", export.Sources[1].ID.String(), "example-psk")
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		Expect(testGormDB.Model(&models.ExportPayload{}).Where("id = ?", export.ID).Update("source_objects_deleted", true).Error).ShouldNot(HaveOccurred())
+		rr = getUpload(export.ID, "exampleApp", export.Sources[0].ID.String(), "example-psk")
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Multipart uploads", func() {
+	cfg := config.Get()
+	log := logger.Get()
+
+	var router *chi.Mux
+	var storage *es3.MemoryStorage
+	var originalCfg *config.ExportConfig
+
+	BeforeEach(func() {
+		fmt.Println("...CLEANING DB...")
+		testGormDB.Exec("DELETE FROM export_payloads")
+
+		originalCfg = emiddleware.Cfg
+		pskCfg := *cfg
+		pskCfg.Psks = []string{"example-psk"}
+		emiddleware.Cfg = &pskCfg
+
+		storage = es3.NewMemoryStorage()
+		compressor := &es3.Compressor{Log: log, Storage: storage, Cfg: *cfg}
+		internalHandler := &exports.Internal{
+			Cfg:        cfg,
+			Compressor: compressor,
+			DB:         &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			Log:        log,
+		}
+		exportHandler := &exports.Export{
+			Cfg:                 cfg,
+			StorageHandler:      compressor,
+			DB:                  &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			RequestAppResources: mockRequestApplicationResources,
+			Log:                 log,
+		}
+
+		router = chi.NewRouter()
+		router.Route("/app/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforcePSK)
+			sub.Route("/", internalHandler.InternalRouter)
+		})
+		router.Route("/api/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforceIdentity, emiddleware.EnforceUserIdentity)
+			sub.Post("/exports", exportHandler.PostExport)
+		})
+	})
+
+	AfterEach(func() {
+		emiddleware.Cfg = originalCfg
+	})
+
+	createExport := func() exports.ExportPayload {
+		rr := httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var export exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &export)).ShouldNot(HaveOccurred())
+		return export
+	}
+
+	multipart := func(method, path, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("X-Rh-Exports-Psk", "example-psk")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	It("assembles the uploaded parts into the payload of the source", func() {
+		export := createExport()
+		base := fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload/multipart", export.ID, export.Sources[0].ID)
+
+		rr := multipart("POST", base, "text/csv", nil)
+		Expect(rr.Code).To(Equal(http.StatusUnsupportedMediaType))
+
+		rr = multipart("POST", base, "application/json", nil)
+		Expect(rr.Code).To(Equal(http.StatusCreated))
+		var upload exports.MultipartUpload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &upload)).ShouldNot(HaveOccurred())
+		Expect(upload.UploadID).ToNot(BeEmpty())
+		Expect(upload.MinPartBytes).To(Equal(int64(es3.MinPartBytes)))
+
+		rr = multipart("PUT", fmt.Sprintf("%s/%s/parts/0", base, upload.UploadID), "", bytes.NewBufferString(`[]`))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		rr = multipart("PUT", fmt.Sprintf("%s/%s/parts/1", base, "unknown"), "", bytes.NewBufferString(`[]`))
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		// the parts may be uploaded in any order
+		rr = multipart("PUT", fmt.Sprintf("%s/%s/parts/2", base, upload.UploadID), "", bytes.NewBufferString(`"dummy data"}]`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		rr = multipart("PUT", fmt.Sprintf("%s/%s/parts/1", base, upload.UploadID), "", bytes.NewBufferString(`[{"data": `))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		var part exports.UploadedPart
+		Expect(json.Unmarshal(rr.Body.Bytes(), &part)).ShouldNot(HaveOccurred())
+		Expect(part).To(Equal(exports.UploadedPart{PartNumber: 1, Size: 10}))
+
+		rr = multipart("POST", fmt.Sprintf("%s/%s/complete", base, upload.UploadID), "", nil)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		_, source, err := payload.GetSource(export.Sources[0].ID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(source.Status).To(Equal(models.RSuccess))
+
+		body, err := storage.Get(context.Background(), es3.SourceObjectKey(payload, *source))
+		Expect(err).ShouldNot(HaveOccurred())
+		data, err := io.ReadAll(body)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(Equal(`[{"data": "dummy data"}]`))
+
+		// the source no longer accepts uploads
+		rr = multipart("POST", base, "application/json", nil)
+		Expect(rr.Code).To(Equal(http.StatusGone))
+	})
+
+	It("leaves the source pending when the upload is discarded", func() {
+		export := createExport()
+		base := fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload/multipart", export.ID, export.Sources[0].ID)
+
+		rr := multipart("POST", base, "", nil)
+		Expect(rr.Code).To(Equal(http.StatusCreated))
+		var upload exports.MultipartUpload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &upload)).ShouldNot(HaveOccurred())
+
+		rr = multipart("PUT", fmt.Sprintf("%s/%s/parts/1", base, upload.UploadID), "", bytes.NewBufferString(`[]`))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		rr = multipart("DELETE", fmt.Sprintf("%s/%s", base, upload.UploadID), "", nil)
+		Expect(rr.Code).To(Equal(http.StatusNoContent))
+		rr = multipart("POST", fmt.Sprintf("%s/%s/complete", base, upload.UploadID), "", nil)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		_, source, err := payload.GetSource(export.Sources[0].ID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(source.Status).To(Equal(models.RPending))
+	})
+})
+
+// countingStorage counts the archives written to the storage.
+type countingStorage struct {
+	*es3.MemoryStorage
+	archives int32
+}
+
+func (s *countingStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, tags es3.ObjectTags) error {
+	if tags.Type == es3.ArchiveObject {
+		atomic.AddInt32(&s.archives, 1)
+	}
+	return s.MemoryStorage.Put(ctx, key, body, contentType, tags)
+}
+
+var _ = Describe("Concurrent status transitions", func() {
+	cfg := config.Get()
+	log := logger.Get()
+	user := models.User{AccountID: "540155", OrganizationID: "10000001", Username: "user", IdentityType: "User"}
+
+	var exportDB *models.ExportDB
+	var storage *countingStorage
+	var compressor *es3.Compressor
+
+	BeforeEach(func() {
+		exportDB = &models.ExportDB{DB: testGormDB, Cfg: cfg}
+		storage = &countingStorage{MemoryStorage: es3.NewMemoryStorage()}
+		compressor = &es3.Compressor{Log: log, Storage: storage, Cfg: *cfg}
+	})
+
+	// createRunningExport creates an export whose sources were all uploaded.
+	createRunningExport := func() *models.ExportPayload {
+		export, err := exportDB.Create(&models.ExportPayload{
+			Name:   "concurrent",
+			Format: models.JSON,
+			Status: models.Running,
+			Sources: []models.Source{
+				{Application: "exampleApp", Resource: "first", Format: models.JSON, Status: models.RSuccess, Filters: datatypes.JSON(`{}`)},
+				{Application: "exampleApp", Resource: "second", Format: models.JSON, Status: models.RSuccess, Filters: datatypes.JSON(`{}`)},
+			},
+			User: user,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, source := range export.Sources {
+			Expect(storage.Put(context.Background(), es3.SourceObjectKey(export, source), strings.NewReader(`[]`), "application/json", es3.ObjectTags{})).To(Succeed())
+		}
+		return export
+	}
+
+	getStatus := func(exportUUID uuid.UUID) models.PayloadStatus {
+		export, err := exportDB.Get(exportUUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		return export.Status
+	}
+
+	It("packages the export once when its last source is processed by several replicas", func() {
+		export := createRunningExport()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				compressor.ProcessSources(exportDB, export.ID)
+			}()
+		}
+		wg.Wait()
+
+		Eventually(func() models.PayloadStatus { return getStatus(export.ID) }).Should(Equal(models.Complete))
+		Consistently(func() int32 { return atomic.LoadInt32(&storage.archives) }).Should(Equal(int32(1)))
+	})
+
+	It("only lets one of the concurrent transitions win", func() {
+		export := createRunningExport()
+
+		var wins int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				payload, err := exportDB.Get(export.ID)
+				Expect(err).ShouldNot(HaveOccurred())
+				if err := payload.SetStatusPackaging(exportDB); err != nil {
+					Expect(errors.Is(err, models.ErrStatusConflict)).To(BeTrue())
+					return
+				}
+				atomic.AddInt32(&wins, 1)
+			}()
+		}
+		wg.Wait()
+
+		Expect(wins).To(Equal(int32(1)))
+		Expect(getStatus(export.ID)).To(Equal(models.Packaging))
+
+		// the export no longer accepts uploads, nor can it be deleted until packaged
+		Expect(export.SetStatusRunning(exportDB)).To(MatchError(models.ErrStatusConflict))
+		_, err := exportDB.Delete(export.ID, user)
+		Expect(err).To(MatchError(models.ErrStatusConflict))
+
+		Expect(export.SetStatusComplete(exportDB, nil, "")).To(Succeed())
+		_, err = exportDB.Delete(export.ID, user)
+		Expect(err).To(BeNil())
+		Expect(export.SetStatusFailed(exportDB)).To(MatchError(models.ErrStatusConflict))
+	})
+})
+
+var _ = Describe("Fault injection", func() {
+	cfg := config.Get()
+	log := logger.Get()
+
+	var router *chi.Mux
+	var injector *faults.Injector
+	var originalCfg *config.ExportConfig
+
+	setup := func(debug bool) {
+		debugCfg := *cfg
+		debugCfg.Debug = debug
+		debugCfg.Psks = []string{"example-psk"}
+		emiddleware.Cfg = &debugCfg
+
+		injector = faults.NewInjector()
+		compressor := &es3.Compressor{Log: log, Storage: es3.NewMemoryStorage(), Cfg: debugCfg, Faults: injector}
+		internalHandler := &exports.Internal{
+			Cfg:        &debugCfg,
+			Compressor: compressor,
+			DB:         &models.ExportDB{DB: testGormDB, Cfg: &debugCfg},
+			Log:        log,
+			Faults:     injector,
+		}
+		exportHandler := &exports.Export{
+			Cfg:                 &debugCfg,
+			StorageHandler:      compressor,
+			DB:                  &models.ExportDB{DB: testGormDB, Cfg: &debugCfg},
+			RequestAppResources: mockRequestApplicationResources,
+			Log:                 log,
+		}
+
+		router = chi.NewRouter()
+		router.Route("/app/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforcePSK)
+			sub.Route("/", internalHandler.InternalRouter)
+		})
+		router.Route("/api/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforceIdentity, emiddleware.EnforceUserIdentity)
+			sub.Post("/exports", exportHandler.PostExport)
+		})
+	}
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Rh-Exports-Psk", "example-psk")
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	BeforeEach(func() {
+		testGormDB.Exec("DELETE FROM export_payloads")
+		originalCfg = emiddleware.Cfg
+	})
+
+	AfterEach(func() {
+		emiddleware.Cfg = originalCfg
+	})
+
+	It("is not routed without DEBUG", func() {
+		setup(false)
+		rr := serve("POST", "/app/export/v1/debug/faults", `{"kind": "upload_error", "application": "exampleApp", "ttl": "1m"}`)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		rr = serve("GET", "/app/export/v1/debug/faults", "")
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+		Expect(injector.List()).To(BeEmpty())
+	})
+
+	It("fails the next upload of an application", func() {
+		setup(true)
+
+		rr := serve("POST", "/app/export/v1/debug/faults", `{"kind": "upload_error", "ttl": "1m"}`)
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		rr = serve("POST", "/app/export/v1/debug/faults", `{"kind": "upload_error", "application": "exampleApp", "ttl": "1m"}`)
+		Expect(rr.Code).To(Equal(http.StatusCreated))
+
+		rr = serve("GET", "/app/export/v1/debug/faults", "")
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		var listed []exports.InjectedFault
+		Expect(json.Unmarshal(rr.Body.Bytes(), &listed)).ShouldNot(HaveOccurred())
+		Expect(listed).To(HaveLen(1))
+		Expect(listed[0].Kind).To(Equal("upload_error"))
+
+		rr = httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		var export exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &export)).ShouldNot(HaveOccurred())
+
+		uploadPath := fmt.Sprintf("/app/export/v1/%s/exampleApp/%s/upload", export.ID, export.Sources[0].ID)
+		rr = serve("POST", uploadPath, `[{"data": "dummy data"}]`)
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(payload.Sources[0].Status).To(Equal(models.RPending))
+
+		// the fault is injected once, the upload can be retried
+		rr = serve("POST", uploadPath, `[{"data": "dummy data"}]`)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+		Expect(injector.List()).To(BeEmpty())
+	})
+
+	It("clears the faults", func() {
+		setup(true)
+		rr := serve("POST", "/app/export/v1/debug/faults", `{"kind": "announce_delay", "application": "exampleApp", "delay": "30s", "ttl": "1m"}`)
+		Expect(rr.Code).To(Equal(http.StatusCreated))
+		var fault exports.InjectedFault
+		Expect(json.Unmarshal(rr.Body.Bytes(), &fault)).ShouldNot(HaveOccurred())
+		Expect(fault.Delay).To(Equal("30s"))
+
+		rr = serve("DELETE", "/app/export/v1/debug/faults", "")
+		Expect(rr.Code).To(Equal(http.StatusNoContent))
+		Expect(injector.List()).To(BeEmpty())
+	})
+
+	It("drops and delays the requests for the sources of the faulted applications", func() {
+		injector := faults.NewInjector()
+		_, err := injector.Add(faults.Fault{Kind: faults.AnnounceDrop, Application: "droppedApp"}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: faults.AnnounceDelay, Application: "delayedApp", Delay: 10 * time.Millisecond}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var mu sync.Mutex
+		var requested []string
+		next := func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error {
+			mu.Lock()
+			defer mu.Unlock()
+			requested = append(requested, source.Application)
+			return nil
+		}
+		requestedApps := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, requested...)
+		}
+
+		payload := models.ExportPayload{Sources: []models.Source{
+			{ID: uuid.New(), Application: "exampleApp"},
+			{ID: uuid.New(), Application: "droppedApp"},
+			{ID: uuid.New(), Application: "delayedApp"},
+		}}
+		announce := exports.FaultyAnnounceSource(injector, next)
+		for _, source := range payload.Sources {
+			Expect(announce(context.Background(), log, "identity", payload, source)).To(Succeed())
+		}
+		Expect(requestedApps()).To(Equal([]string{"exampleApp"}))
+		Eventually(requestedApps).Should(Equal([]string{"exampleApp", "delayedApp"}))
+		Consistently(requestedApps, 50*time.Millisecond).Should(HaveLen(2))
+	})
+})
+
+// metricValue returns the value of the counter with the label, 0 until it is first
+// incremented.
+func metricValue(name, label, value string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	Expect(err).ShouldNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label && pair.GetValue() == value {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
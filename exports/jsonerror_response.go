@@ -44,11 +44,51 @@ func InternalServerError(w http.ResponseWriter, err interface{}) {
 	JSONError(w, err, http.StatusInternalServerError)
 }
 
+// ForbiddenError returns a 403 json response
+func ForbiddenError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusForbidden)
+}
+
 // NotFoundError returns a 404 json response
 func NotFoundError(w http.ResponseWriter, err interface{}) {
 	JSONError(w, err, http.StatusNotFound)
 }
 
+// ConflictError returns a 409 json response
+func ConflictError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusConflict)
+}
+
+// GoneError returns a 410 json response
+func GoneError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusGone)
+}
+
+// RequestEntityTooLargeError returns a 413 json response
+func RequestEntityTooLargeError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusRequestEntityTooLarge)
+}
+
+// UnsupportedMediaTypeError returns a 415 json response
+func UnsupportedMediaTypeError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusUnsupportedMediaType)
+}
+
+// TooManyRequestsError returns a 429 json response
+func TooManyRequestsError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusTooManyRequests)
+}
+
+// ServiceUnavailableError returns a 503 json response
+func ServiceUnavailableError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusServiceUnavailable)
+}
+
+// GatewayTimeoutError returns a 504 json response
+func GatewayTimeoutError(w http.ResponseWriter, err interface{}) {
+	JSONError(w, err, http.StatusGatewayTimeout)
+}
+
 // NotImplementedError returns a 501 json response
 func NotImplementedError(w http.ResponseWriter) {
 	JSONError(w, "not implemented", http.StatusNotImplemented)
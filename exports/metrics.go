@@ -0,0 +1,53 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cleanupRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_cleanup_runs_total",
+	Help: "The total number of cleanups run by this process, by result: cleaned, skipped while another replica cleaned up, or failed when a step failed. Dry runs are not counted.",
+}, []string{"result"})
+
+var cleanupRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "export_service_cleanup_rows",
+	Help: "The rows deleted by each cleanup of this process, by kind: expired, purged or cancelled exports.",
+	// from a row to a million
+	Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+}, []string{"kind"})
+
+var cleanupObjects = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "export_service_cleanup_objects",
+	Help:    "The objects removed from the bucket by each cleanup of this process.",
+	Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+})
+
+// observeCleanup records the rows and objects deleted by a cleanup. The skipped
+// cleanups deleted nothing, only their run is counted.
+func observeCleanup(report *CleanupReport) {
+	switch {
+	case report.DryRun:
+		return
+	case report.Skipped:
+		cleanupRuns.WithLabelValues("skipped").Inc()
+		return
+	case len(report.Errors) > 0:
+		cleanupRuns.WithLabelValues("failed").Inc()
+	default:
+		cleanupRuns.WithLabelValues("cleaned").Inc()
+	}
+	cleanupRows.WithLabelValues("expired").Observe(float64(len(report.ExpiredExports)))
+	cleanupRows.WithLabelValues("purged").Observe(float64(len(report.PurgedExports)))
+	cleanupRows.WithLabelValues("cancelled").Observe(float64(report.ForgottenCancelledExports))
+	cleanupObjects.Observe(float64(report.DeletedObjects))
+}
+
+func init() {
+	prometheus.MustRegister(cleanupRuns)
+	prometheus.MustRegister(cleanupRows)
+	prometheus.MustRegister(cleanupObjects)
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/faults"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/s3"
+)
+
+// MultipartRouter routes the multipart uploads of the payload of a source, for the
+// payloads too large to be uploaded reliably in one request.
+func (i *Internal) MultipartRouter(r chi.Router) {
+	timeout := middleware.Timeout(i.Cfg.HTTPConfig.RequestTimeout)
+	// the parts and the completion, which reads the assembled payload back, stream
+	// payloads
+	uploadTimeout := middleware.Timeout(i.Cfg.HTTPConfig.DownloadTimeout)
+
+	r.With(timeout).Post("/", i.PostMultipartUpload)
+	r.With(uploadTimeout).Put("/{uploadID}/parts/{partNumber}", i.PutUploadPart)
+	r.With(uploadTimeout).Post("/{uploadID}/complete", i.PostCompleteMultipartUpload)
+	r.With(timeout).Delete("/{uploadID}", i.DeleteMultipartUpload)
+}
+
+// PostMultipartUpload starts a multipart upload of the payload of a source. The
+// Content-Type of the request is the one of the payload.
+func (i *Internal) PostMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	logger, params, payload, source, ok := i.pendingSource(w, r)
+	if !ok {
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); !uploadMatchesFormat(contentType, source.Format) {
+		logger.Infow("upload content type does not match the requested format", "content_type", contentType, "format", source.Format)
+		UnsupportedMediaTypeError(w, fmt.Sprintf("'%s' does not match the requested format '%s'", contentType, source.Format))
+		return
+	}
+
+	uploadID, err := i.Compressor.CreateMultipartObject(r.Context(), params.ResourceUUID, payload)
+	if err != nil {
+		multipartError(w, logger, err)
+		return
+	}
+
+	logger.Infow("started a multipart upload", "upload_id", uploadID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	upload := MultipartUpload{
+		UploadID:     uploadID,
+		MinPartBytes: s3.MinPartBytes,
+		MaxPartBytes: i.Cfg.StorageConfig.MaxUploadPartBytes,
+	}
+	if err := json.NewEncoder(w).Encode(&upload); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// PutUploadPart stores a part of a multipart upload. A part that failed may be
+// uploaded again, the last upload of a part number replaces the previous ones.
+func (i *Internal) PutUploadPart(w http.ResponseWriter, r *http.Request) {
+	logger, params, payload, _, ok := i.pendingSource(w, r)
+	if !ok {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(chi.URLParam(r, "partNumber"))
+	if err != nil || partNumber < s3.MinPartNumber || partNumber > s3.MaxPartNumber {
+		BadRequestError(w, fmt.Sprintf("the part number must be between %d and %d", s3.MinPartNumber, s3.MaxPartNumber))
+		return
+	}
+
+	// the part is buffered so that the storage client can retry it
+	maxPartBytes := i.Cfg.StorageConfig.MaxUploadPartBytes
+	if maxPartBytes > 0 && r.ContentLength > maxPartBytes {
+		RequestEntityTooLargeError(w, fmt.Sprintf("a part may have at most %d bytes", maxPartBytes))
+		return
+	}
+	body := r.Body
+	if maxPartBytes > 0 {
+		body = middleware.MaxBytesReader(body, maxPartBytes)
+	}
+	part, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *middleware.BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			RequestEntityTooLargeError(w, fmt.Sprintf("a part may have at most %d bytes", maxPartBytes))
+			return
+		}
+		BadRequestError(w, fmt.Sprintf("failed to read the part: %v", err))
+		return
+	}
+	if len(part) == 0 {
+		BadRequestError(w, "the part is empty")
+		return
+	}
+
+	uploadID := chi.URLParam(r, "uploadID")
+	if err := i.Compressor.UploadObjectPart(r.Context(), params.ResourceUUID, payload, uploadID, partNumber, bytes.NewReader(part)); err != nil {
+		multipartError(w, logger, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(UploadedPart{PartNumber: partNumber, Size: int64(len(part))}); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// PostCompleteMultipartUpload assembles the parts of a multipart upload into the
+// payload of the source, which is then resolved like one uploaded in one request.
+func (i *Internal) PostCompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	logger, params, payload, source, ok := i.pendingSource(w, r)
+	if !ok {
+		return
+	}
+
+	if i.Faults.TakeUploadError(params.Application) {
+		// the source is still pending, the application may complete the upload again
+		logger.Infow("failed the upload with an injected fault", "application", params.Application)
+		InternalServerError(w, fmt.Sprintf("payload failed to upload: %v", faults.ErrInjected))
+		return
+	}
+
+	recordCount, err := parseRecordCount(r.Header.Get(recordCountHeader))
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	// the digest is the one of the whole payload
+	checksum, err := parseDigest(r.Header.Get(digestHeader))
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+
+	uploadID := chi.URLParam(r, "uploadID")
+	limit := i.uploadLimit(payload, source)
+	if limit.full() {
+		i.rejectUpload(w, r, logger, payload, source, limit)
+		return
+	}
+	err = i.Compressor.CompleteMultipartObject(r.Context(), i.DB.WithContext(r.Context()), params.Application, params.ResourceUUID, payload, uploadID, checksum, limit.Bytes)
+	var mismatch *s3.ChecksumMismatchError
+	switch {
+	case err == nil:
+	case errors.As(err, &mismatch):
+		checksumMismatch(w, logger, mismatch)
+		return
+	case errors.Is(err, s3.ErrUploadTooLarge):
+		i.rejectUpload(w, r, logger, payload, source, limit)
+		return
+	case errors.Is(err, models.ErrStatusConflict):
+		logger.Infow("the export no longer accepts uploads", "error", err)
+		GoneError(w, fmt.Sprintf("'%s' is packaged and no longer accepts uploads", params.ExportUUID))
+		return
+	default:
+		multipartError(w, logger, err)
+		return
+	}
+
+	if recordCount != nil {
+		if err := payload.SetSourceRecordCount(i.DB.WithContext(r.Context()), params.ResourceUUID, *recordCount); err != nil {
+			logger.Errorw("failed to set the record count of the source", "error", err)
+		}
+	}
+
+	logger.Infow("completed a multipart upload", "upload_id", uploadID)
+	w.WriteHeader(http.StatusAccepted)
+	Logerr(w.Write([]byte("payload delivered")))
+
+	if err := i.resolveSource(i.DB.WithContext(r.Context()), payload, params.ResourceUUID, models.RSuccess, nil); err != nil {
+		logger.Errorw("failed to resolve source for successful export", "error", err)
+		InternalServerError(w, err)
+	}
+}
+
+// DeleteMultipartUpload discards a multipart upload and its parts, the source is
+// still pending.
+func (i *Internal) DeleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	logger, params, payload, _, ok := i.pendingSource(w, r)
+	if !ok {
+		return
+	}
+
+	if err := i.Compressor.AbortMultipartObject(r.Context(), params.ResourceUUID, payload, chi.URLParam(r, "uploadID")); err != nil {
+		multipartError(w, logger, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingSource returns the export and the source of the request, and responds
+// itself when the source no longer accepts uploads.
+func (i *Internal) pendingSource(w http.ResponseWriter, r *http.Request) (*zap.SugaredLogger, *middleware.URLParams, *models.ExportPayload, *models.Source, bool) {
+	logger := i.Log.With(export_logger.RequestIDField(request_id.GetReqID(r.Context())))
+
+	params := middleware.GetURLParams(r.Context())
+	if params == nil {
+		InternalServerError(w, "unable to parse url params")
+		return nil, nil, nil, nil, false
+	}
+	logger = logger.With(export_logger.ExportIDField(params.ExportUUID.String()))
+
+	payload, err := i.DB.WithContext(r.Context()).Get(params.ExportUUID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			logger.Debugw("export not found", "error", err)
+			i.exportNotFound(w, r, logger, params.ExportUUID)
+			return nil, nil, nil, nil, false
+		}
+		logger.Errorw("error querying for payload entry", "error", err)
+		InternalServerError(w, err)
+		return nil, nil, nil, nil, false
+	}
+
+	_, source, err := payload.GetSource(params.ResourceUUID)
+	if err != nil {
+		logger.Errorw("failed to get source", "error", err)
+		InternalServerError(w, err.Error())
+		return nil, nil, nil, nil, false
+	}
+	if pskForbidden(w, r, logger, source) || sourceResolved(w, payload, source) {
+		return nil, nil, nil, nil, false
+	}
+	return logger, params, payload, source, true
+}
+
+// multipartError responds with the error of a multipart upload, the source is still
+// pending so that the upload may be retried.
+func multipartError(w http.ResponseWriter, logger *zap.SugaredLogger, err error) {
+	switch {
+	case errors.Is(err, s3.ErrMultipartNotSupported):
+		JSONError(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, s3.ErrUploadNotFound):
+		NotFoundError(w, err.Error())
+	case errors.Is(err, s3.ErrInvalidParts):
+		BadRequestError(w, err.Error())
+	case s3.IsTimeout(err):
+		logger.Errorw("multipart upload timed out", "error", err)
+		GatewayTimeoutError(w, fmt.Sprintf("payload failed to upload: %v", err))
+	default:
+		logger.Errorw("multipart upload failed", "error", err)
+		InternalServerError(w, fmt.Sprintf("payload failed to upload: %v", err))
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// maxNameLength is the number of characters of the name of an export, once its
+// placeholders are expanded.
+const maxNameLength = 255
+
+// namePlaceholders expand the placeholders of the name of an export when it is
+// created, so that the exports of a schedule can be told apart.
+var namePlaceholders = map[string]func(now time.Time, sources []models.Source) string{
+	"{date}":     func(now time.Time, _ []models.Source) string { return now.UTC().Format("2006-01-02") },
+	"{datetime}": func(now time.Time, _ []models.Source) string { return now.UTC().Format("20060102T150405Z") },
+	"{application}": func(_ time.Time, sources []models.Source) string {
+		var applications []string
+		seen := map[string]bool{}
+		for _, source := range sources {
+			if !seen[source.Application] {
+				seen[source.Application] = true
+				applications = append(applications, source.Application)
+			}
+		}
+		return strings.Join(applications, "-")
+	},
+}
+
+// supportedPlaceholders lists the placeholders in the errors of the invalid names.
+const supportedPlaceholders = "{date}, {datetime}, {application}"
+
+// expandName replaces the placeholders of the name. Names without braces are kept
+// as they are.
+func expandName(name string, now time.Time, sources []models.Source) (string, error) {
+	if !strings.ContainsAny(name, "{}") {
+		return name, nil
+	}
+
+	var expanded strings.Builder
+	rest := name
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start == -1 {
+			expanded.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if rest[start] == '}' || end == -1 {
+			return "", fmt.Errorf("unbalanced braces in the name, the supported placeholders are %s", supportedPlaceholders)
+		}
+		placeholder := rest[start : start+end+1]
+		expand, ok := namePlaceholders[placeholder]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder '%s' in the name, the supported placeholders are %s", placeholder, supportedPlaceholders)
+		}
+		expanded.WriteString(rest[:start])
+		expanded.WriteString(expand(now, sources))
+		rest = rest[start+end+1:]
+	}
+	return expanded.String(), nil
+}
+
+// validateName rejects the names that are too long or hold control characters.
+func validateName(name string) error {
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("the name may have at most %d characters once its placeholders are expanded", maxNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errors.New("the name may not hold control characters")
+		}
+	}
+	return nil
+}
+
+// archiveFilename returns the name the archive of the export is downloaded as: the
+// name of the export, with the characters that are unsafe in a filename replaced,
+// ahead of the name of the object.
+func archiveFilename(name, s3Key string) string {
+	baseName := path.Base(s3Key)
+	safeName := strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.') {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(safeName, "_.") == "" {
+		return baseName
+	}
+	return safeName + "-" + baseName
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// outboxBatchSize is the number of outbox messages claimed at once.
+const outboxBatchSize = 100
+
+// OutboxRelay sends the requests for the data of the sources that were written to the
+// outbox along with their export. A request is sent again until kafka reports its
+// delivery, so that every source is requested at least once.
+type OutboxRelay struct {
+	DB       models.DBInterface
+	Announce AnnounceSource
+	// Interval is how often the outbox is polled, RetryAfter how long a sent
+	// message may go without a delivery report before it is sent again
+	Interval   time.Duration
+	RetryAfter time.Duration
+	Log        *zap.SugaredLogger
+
+	wake chan struct{}
+}
+
+// NewOutboxRelay returns the relay of the outbox of the db, sending the requests with
+// announce.
+func NewOutboxRelay(db models.DBInterface, announce AnnounceSource, cfg *config.ExportConfig, log *zap.SugaredLogger) *OutboxRelay {
+	return &OutboxRelay{
+		DB:         db,
+		Announce:   announce,
+		Interval:   cfg.KafkaConfig.OutboxInterval,
+		RetryAfter: cfg.KafkaConfig.OutboxRetryAfter,
+		Log:        log,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Start relays the outbox every interval, and whenever an export is created, until
+// the context is done.
+func (o *OutboxRelay) Start(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+		o.Relay(ctx)
+	}
+}
+
+// RequestApplicationResources returns the RequestApplicationResources of the exports
+// whose requests are in the outbox, it wakes the relay up so that they are sent right
+// away.
+func (o *OutboxRelay) RequestApplicationResources() RequestApplicationResources {
+	return func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+			// the relay is already woken up
+		}
+	}
+}
+
+// Relay sends the messages of the outbox that are due. The messages are claimed in the
+// db, so that the replicas of the service can relay the outbox concurrently.
+func (o *OutboxRelay) Relay(ctx context.Context) {
+	db := o.DB.WithContext(ctx)
+	for {
+		messages, err := db.ClaimOutbox(time.Now().Add(-o.RetryAfter), outboxBatchSize)
+		if err != nil {
+			o.Log.Errorw("error claiming the outbox messages", "error", err)
+			return
+		}
+
+		exports := map[uuid.UUID]*models.ExportPayload{}
+		for _, message := range messages {
+			if err := o.send(ctx, db, message, exports); errors.Is(err, ekafka.ErrCircuitOpen) {
+				// the claimed messages are sent again once they are due
+				o.Log.Errorw("stopped relaying the outbox while the kafka circuit breaker is open")
+				return
+			}
+		}
+		if len(messages) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// send announces the source of the message and returns the error of the announce, the
+// exports are cached across the messages of a batch.
+func (o *OutboxRelay) send(ctx context.Context, db models.DBInterface, message models.OutboxMessage, exports map[uuid.UUID]*models.ExportPayload) error {
+	logger := o.Log.With(export_logger.ExportIDField(message.ExportPayloadID.String()), "source_id", message.SourceID, "attempts", message.Attempts)
+
+	export, ok := exports[message.ExportPayloadID]
+	if !ok {
+		var err error
+		export, err = db.Get(message.ExportPayloadID)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				// the message is claimed again once it is due
+				logger.Errorw("error querying for the export of the outbox message", "error", err)
+			}
+			// the messages of a deleted export are discarded along with it
+			return nil
+		}
+		exports[message.ExportPayloadID] = export
+	}
+
+	_, source, err := export.GetSource(message.SourceID)
+	if err != nil || source.Status != models.RPending {
+		// the source is resolved or cancelled, its application no longer needs the request
+		if err := db.DiscardOutboxMessage(message.ID); err != nil {
+			logger.Errorw("failed to discard the outbox message", "error", err)
+		}
+		return nil
+	}
+
+	logger = logger.With(export_logger.RequestIDField(export.RequestID))
+	err = o.Announce(ctx, logger, export.Identity, *export, *source)
+	if err != nil {
+		logger.Errorw("failed to send the request for the source to the producer", "error", err)
+	}
+	return err
+}
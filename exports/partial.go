@@ -0,0 +1,82 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/encryption"
+	"github.com/redhatinsights/export-service-go/models"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+// parseAllowPartial parses the allow_partial query param of a download.
+func parseAllowPartial(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("allow_partial")
+	if value == "" {
+		return false, nil
+	}
+	allowPartial, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("'%s' is not a valid allow_partial, it must be true or false", value)
+	}
+	return allowPartial, nil
+}
+
+// downloadPartialArchive streams an archive of the sources of the export that have
+// completed, while the others are not resolved yet. The archive is built for the
+// download, so it can not be resumed with a range.
+func (e *Export) downloadPartialArchive(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, export *models.ExportPayload) {
+	archive, err := e.StorageHandler.PartialArchive(r.Context(), export)
+	if err != nil {
+		if errors.Is(err, es3.ErrNoCompletedSources) {
+			BadRequestError(w, fmt.Sprintf("'%s' has no completed source to download yet", export.ID))
+			return
+		}
+		logger.Errorw("failed to prepare the partial archive", "error", err)
+		if es3.IsTimeout(err) {
+			GatewayTimeoutError(w, err.Error())
+			return
+		}
+		InternalServerError(w, err)
+		return
+	}
+
+	format := export.ArchiveFormat
+	if format == "" {
+		format = models.TarGz
+	}
+	name := fmt.Sprintf("%s-%s-partial.%s", time.Now().UTC().Format(formatDateTime), export.ID, format)
+	contentType := format.ContentType()
+	if export.IsEncrypted() {
+		name += encryption.Extension
+		contentType = encryption.ContentType
+	}
+	filename := archiveFilename(export.Name, name)
+
+	release, ok := e.acquireDownload(w, r, logger, export, nil, "", filename)
+	if !ok {
+		return
+	}
+	defer release()
+
+	logger.Infow("streaming the partial archive", "export_id", export.ID)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.WriteHeader(http.StatusOK)
+	n, err := archive.WriteTo(w)
+	if err != nil {
+		// the status is sent, the download is cut short
+		logger.Errorw("failed to stream the partial archive", "error", err)
+		return
+	}
+	e.recordDownload(logger, r, export, nil, n, false)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+// ListPSKs handles GET requests to the /psks endpoint. The psks are listed by id, the
+// last used first, the psks themselves are never returned.
+func (i *Internal) ListPSKs(w http.ResponseWriter, r *http.Request) {
+	logger := i.Log.With(export_logger.RequestIDField(request_id.GetReqID(r.Context())))
+
+	uses, err := i.DB.WithContext(r.Context()).ListPSKUses()
+	if err != nil {
+		logger.Errorw("failed to list the uses of the psks", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	now := time.Now()
+	current := middleware.GetPSKID(r.Context())
+	psks := []PSK{}
+	seen := map[string]bool{}
+	for _, psk := range i.Cfg.Psks {
+		id := middleware.PSKID(psk)
+		if psk == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		resp := PSK{ID: id, Applications: i.Cfg.PskApplications[id], Current: id == current}
+		if expiry, ok := i.Cfg.PskExpiries[id]; ok {
+			resp.ExpiresAt = &expiry
+			resp.Expired = !now.Before(expiry)
+		}
+		if lastUsed, ok := uses[id]; ok {
+			resp.LastUsedAt = &lastUsed
+		}
+		psks = append(psks, resp)
+	}
+	sort.SliceStable(psks, func(a, b int) bool {
+		if psks[a].LastUsedAt == nil || psks[b].LastUsedAt == nil {
+			return psks[b].LastUsedAt == nil && psks[a].LastUsedAt != nil
+		}
+		return psks[a].LastUsedAt.After(*psks[b].LastUsedAt)
+	})
+
+	if err := json.NewEncoder(w).Encode(&psks); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
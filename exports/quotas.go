@@ -0,0 +1,53 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// exportsUsage returns the exports of the organization and its quotas on them, with
+// the quota another export would exceed, empty when it is allowed.
+func (e *Export) exportsUsage(db models.DBInterface, orgID string) (ExportsUsage, string, error) {
+	usage := ExportsUsage{MaxExports: e.Cfg.OrgMaxExports, MaxInFlightExports: e.Cfg.OrgMaxInFlightExports}
+	counts, err := db.CountOrgExports(orgID)
+	if err != nil {
+		return usage, "", err
+	}
+	usage.Exports, usage.InFlightExports = counts.Exports, counts.InFlight
+	return usage, counts.Exceeded(usage.MaxExports, usage.MaxInFlightExports), nil
+}
+
+// exportQuotasEnabled reports whether the number of exports of the organizations is
+// limited, the exports are not counted otherwise.
+func (e *Export) exportQuotasEnabled() bool {
+	return e.Cfg.OrgMaxExports > 0 || e.Cfg.OrgMaxInFlightExports > 0
+}
+
+// exportQuotaMessage describes the quota the organization reached.
+func exportQuotaMessage(quota string, usage ExportsUsage) string {
+	if quota == models.InFlightExportsQuota {
+		return fmt.Sprintf("too many exports in flight: the organization has %d of its %d exports pending or running, retry once they complete", usage.InFlightExports, usage.MaxInFlightExports)
+	}
+	return fmt.Sprintf("too many exports: the organization has %d of its %d exports, delete exports or wait for them to expire", usage.Exports, usage.MaxExports)
+}
+
+// ExportQuotaExceededError returns a 403 json response with the usage of the quota
+// that was reached.
+func ExportQuotaExceededError(w http.ResponseWriter, quota string, usage ExportsUsage) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(ExportQuotaError{
+		Msg:   exportQuotaMessage(quota, usage),
+		Code:  http.StatusForbidden,
+		Quota: quota,
+		Usage: usage,
+	})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errRangeNotSatisfiable is returned when the requested range is outside of the object.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is the part of an object requested with a Range header.
+type byteRange struct {
+	start  int64
+	length int64
+	// size is the size of the whole object
+	size int64
+}
+
+// contentRange returns the Content-Range header of the range.
+func (b *byteRange) contentRange() string {
+	return fmt.Sprintf("bytes %d-%d/%d", b.start, b.start+b.length-1, b.size)
+}
+
+// complete reports whether the range reaches the end of the object, i.e. whether it
+// finishes a download.
+func (b *byteRange) complete() bool {
+	return b.start+b.length == b.size
+}
+
+// parseRange parses the Range header against the size of the object. Only a single
+// range of bytes is served, nil is returned for the headers to be ignored, which get
+// the whole object: other units, several ranges and invalid syntax.
+func parseRange(header string, size int64) (*byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// a suffix range, the last bytes of the object
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+		if suffix == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return &byteRange{start: size - suffix, length: suffix, size: size}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	return &byteRange{start: start, length: end - start + 1, size: size}, nil
+}
+
+// openDownload opens the object of a download, or the range of it requested with the
+// Range header. The range is nil when the whole object is served. When the range can
+// not be satisfied the 416 is written and a nil body is returned without error.
+func (e *Export) openDownload(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, key string) (io.ReadCloser, *byteRange, error) {
+	header := r.Header.Get("Range")
+	if header == "" {
+		body, err := e.StorageHandler.GetObject(r.Context(), key)
+		return body, nil, err
+	}
+
+	info, err := e.StorageHandler.StatObject(r.Context(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rangeIsCurrent(r.Header.Get("If-Range"), info.LastModified) {
+		// the object changed since the download started, it is sent again
+		body, err := e.StorageHandler.GetObject(r.Context(), key)
+		return body, nil, err
+	}
+
+	byteRange, err := parseRange(header, info.Size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		logger.Infow("requested range is not satisfiable", "range", header, "size", info.Size)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		JSONError(w, fmt.Sprintf("'%s' is outside of the %d bytes of the download", header, info.Size), http.StatusRequestedRangeNotSatisfiable)
+		return nil, nil, nil
+	}
+	if byteRange == nil {
+		body, err := e.StorageHandler.GetObject(r.Context(), key)
+		return body, nil, err
+	}
+
+	if !info.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if info.ContentType != "" {
+		// a part of the object can not be sniffed
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	body, err := e.StorageHandler.GetObjectRange(r.Context(), key, byteRange.start, byteRange.length)
+	return body, byteRange, err
+}
+
+// rangeIsCurrent reports whether the range is to be served for the If-Range header,
+// which the downloads carry with the Last-Modified date of the response they resume.
+// Entity tags are not issued, so an If-Range holding one gets the whole object.
+func rangeIsCurrent(ifRange string, lastModified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(date)
+}
+
+// writeDownloadHeader writes the status of a download with the Content-Range of the
+// served range, if any.
+func writeDownloadHeader(w http.ResponseWriter, byteRange *byteRange) {
+	w.Header().Set("Accept-Ranges", "bytes")
+	if byteRange == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Range", byteRange.contentRange())
+	w.Header().Set("Content-Length", strconv.FormatInt(byteRange.length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+}
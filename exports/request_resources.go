@@ -6,6 +6,7 @@ package exports
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
@@ -18,75 +19,192 @@ import (
 	"github.com/redhatinsights/export-service-go/models"
 )
 
+// RequestApplicationResources is called once an export is created, its requests for
+// the data of the sources are in the outbox.
 type RequestApplicationResources func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload)
 
-func KafkaRequestApplicationResources(kafkaChan chan *kafka.Message) RequestApplicationResources {
-	var kafkaConfig = config.Get().KafkaConfig
-	// sendPayload converts the individual sources of a payload into
-	// kafka messages which are then sent to the producer through the
-	// `messagesChan`
-	return func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
-		go func() {
-			sources, err := payload.GetSources()
-			if err != nil {
-				log.Errorw("failed unmarshalling sources", "error", err)
-				// FIXME:
-				// return err
-				return
-			}
+// AnnounceSource asks the application of a single source for its data again.
+type AnnounceSource func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error
 
-			for _, source := range sources {
-				filters, err := ekafka.JsonToMap(source.Filters)
-				if err != nil {
-					log.Errorw("failed unmarshalling filters", "error", err)
-					// FIXME:
-					// return err
-					continue // Skip this source and continue with the next one
-				}
+// CancelSources tells the applications of the sources of a deleted export to stop
+// working on them.
+type CancelSources func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, sources []models.Source)
 
-				format, ok := ekafka.ParseFormat(string(payload.Format))
-				if !ok {
-					log.Errorw("failed parsing format", "error", err)
-					// FIXME:
-					// return err
-					continue // Skip this source and continue with the next one
-				}
+// KafkaAnnounceSource sends a new request for the data of the source to the producer.
+// The message is a new CloudEvent carrying the same data as the original request. It
+// fails fast while the circuit breaker, which may be nil, is open.
+func KafkaAnnounceSource(kafkaChan chan *kafka.Message, breaker *ekafka.CircuitBreaker) AnnounceSource {
+	var cfg = config.Get()
+	return func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, source models.Source) error {
+		msg, err := newSourceMessage(cfg, identity, payload, source)
+		if err != nil {
+			return fmt.Errorf("failed to create kafka message: %w", err)
+		}
 
-				headers := ekafka.KafkaHeader{
-					Application: source.Application,
-					IDheader:    identity,
-				}
-				kpayload := ekafka.KafkaMessage{
-					ID:          uuid.New(),
-					Source:      kafkaConfig.EventSource,
-					Subject:     payload.ID.String(),
-					SpecVersion: kafkaConfig.EventSpecVersion,
-					Type:        kafkaConfig.EventType,
-					Time:        time.Now().UTC().Format(formatDateTime),
-					OrgID:       payload.OrganizationID,
-					DataSchema:  kafkaConfig.EventDataSchema,
-					Data: cloudEventSchema.ExportRequestClass{
-						Application: source.Application,
-						Filters:     filters,
-						Format:      format,
-						Resource:    source.Resource,
-						UUID:        source.ID.String(),
-						XRhIdentity: identity,
-					},
-				}
+		ctx, cancel := context.WithTimeout(ctx, cfg.KafkaConfig.ProduceTimeout)
+		defer cancel()
+
+		if err := ekafka.Send(ctx, breaker, kafkaChan, msg); err != nil {
+			return err
+		}
+		log.Infof("sent kafka message to the producer: %+v", msg)
+		return nil
+	}
+}
 
-				msg, err := kpayload.ToMessage(headers, kafkaConfig.ExportsTopic)
+// KafkaCancelSources sends a cancellation event for each source to the producer. The
+// events are sent in the background, like the requests for the data of the sources,
+// and dropped while the circuit breaker is open.
+func KafkaCancelSources(kafkaChan chan *kafka.Message, breaker *ekafka.CircuitBreaker) CancelSources {
+	var cfg = config.Get()
+	return func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload, sources []models.Source) {
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, cfg.KafkaConfig.ProduceTimeout)
+		go func() {
+			defer cancel()
+
+			for _, source := range sources {
+				msg, err := newCancelMessage(cfg, identity, payload, source)
 				if err != nil {
 					log.Errorw("failed to create kafka message", "error", err)
-					// FIXME:
-					// return err
-					continue // Skip this source and continue with the next one
+					continue
 				}
 
-				log.Debug("sending kafka message to the producer")
-				kafkaChan <- msg // TODO: what should we do if the message is never sent to the producer?
-				log.Infof("sent kafka message to the producer: %+v", msg)
+				if err := ekafka.Send(ctx, breaker, kafkaChan, msg); err != nil {
+					// the application finds out once it reports the source
+					log.Errorw("failed to send the cancellation of the source to the producer", "source_id", source.ID, "error", err)
+					continue
+				}
+				log.Infow("sent the cancellation of the source to the producer", "source_id", source.ID)
 			}
 		}()
 	}
 }
+
+// detachedContext keeps the values of its parent but not its deadline nor its
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// newSourceMessage creates the CloudEvent requesting the data of the source from its
+// application, on the topic of the application. Every call creates an event with a new
+// id.
+func newSourceMessage(cfg *config.ExportConfig, identity string, payload models.ExportPayload, source models.Source) (*kafka.Message, error) {
+	kafkaConfig := cfg.KafkaConfig
+
+	filters, err := ekafka.JsonToMap(source.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling filters: %w", err)
+	}
+
+	format, ok := ekafka.ParseFormat(string(source.Format))
+	if !ok {
+		return nil, fmt.Errorf("failed parsing format: %s", source.Format)
+	}
+
+	headers := ekafka.KafkaHeader{
+		Application: source.Application,
+		IDheader:    identity,
+	}
+	kpayload := ekafka.KafkaMessage{
+		ID:          uuid.New(),
+		Source:      kafkaConfig.EventSource,
+		Subject:     payload.ID.String(),
+		SpecVersion: kafkaConfig.EventSpecVersion,
+		Type:        kafkaConfig.EventType,
+		Time:        time.Now().UTC().Format(formatDateTime),
+		OrgID:       payload.OrganizationID,
+		RequestID:   payload.RequestID,
+		DataSchema:  kafkaConfig.EventDataSchema,
+		Data: cloudEventSchema.ExportRequestClass{
+			Application: source.Application,
+			Filters:     filters,
+			Format:      format,
+			Resource:    source.Resource,
+			UUID:        source.ID.String(),
+			XRhIdentity: identity,
+		},
+	}
+
+	msg, err := kpayload.ToMessage(headers, kafkaConfig.RequestsTopic(source.Application))
+	if err != nil {
+		return nil, err
+	}
+	msg.Key = kpayload.Key(kafkaConfig.MessageKey)
+	return msg, nil
+}
+
+// newCancelMessage creates the CloudEvent telling the application of the source that
+// its export was cancelled.
+func newCancelMessage(cfg *config.ExportConfig, identity string, payload models.ExportPayload, source models.Source) (*kafka.Message, error) {
+	kafkaConfig := cfg.KafkaConfig
+
+	headers := ekafka.KafkaHeader{
+		Application: source.Application,
+		IDheader:    identity,
+	}
+	kpayload := ekafka.KafkaCancelMessage{
+		ID:          uuid.New(),
+		Source:      kafkaConfig.EventSource,
+		Subject:     payload.ID.String(),
+		SpecVersion: kafkaConfig.EventSpecVersion,
+		Type:        kafkaConfig.CancelEventType,
+		Time:        time.Now().UTC().Format(formatDateTime),
+		OrgID:       payload.OrganizationID,
+		RequestID:   payload.RequestID,
+		Data: ekafka.ExportCancelClass{
+			Application: source.Application,
+			Resource:    source.Resource,
+			UUID:        source.ID.String(),
+		},
+	}
+
+	msg, err := kpayload.ToMessage(headers, kafkaConfig.RequestsTopic(source.Application))
+	if err != nil {
+		return nil, err
+	}
+	msg.Key = kpayload.Key(kafkaConfig.MessageKey)
+	return msg, nil
+}
+
+// RecordSourceDelivery returns a kafka delivery handler that stores the delivery
+// status of the message announcing each source, the other events are ignored.
+func RecordSourceDelivery(db models.DBInterface, log *zap.SugaredLogger) ekafka.DeliveryHandler {
+	return func(msg *kafka.Message, deliveryError error) {
+		if !ekafka.IsSourceRequest(msg) {
+			return
+		}
+		sourceID, err := ekafka.MessageSourceID(msg)
+		if err != nil {
+			log.Errorw("failed to read the source of a delivered message", "error", err)
+			return
+		}
+		if err := models.SetSourceDelivery(db, sourceID, deliveryError); err != nil {
+			log.Errorw("failed to record the delivery of a source", "source_id", sourceID, "error", err)
+		}
+	}
+}
+
+// RecordSourceDeadLetter returns a kafka delivery handler that stops the outbox from
+// sending the requests that were sent to the dead letter topic.
+func RecordSourceDeadLetter(db models.DBInterface, log *zap.SugaredLogger) ekafka.DeliveryHandler {
+	return func(msg *kafka.Message, deliveryError error) {
+		if !ekafka.IsSourceRequest(msg) {
+			return
+		}
+		sourceID, err := ekafka.MessageSourceID(msg)
+		if err != nil {
+			log.Errorw("failed to read the source of a dead lettered message", "error", err)
+			return
+		}
+		if err := models.SetSourceDeadLettered(db, sourceID, deliveryError); err != nil {
+			log.Errorw("failed to record the dead letter of a source", "source_id", sourceID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/s3"
+	"github.com/redhatinsights/export-service-go/tracing"
+)
+
+// errInvalidResponse is wrapped by the errors of the responses that are dropped rather
+// than handled again, the application has to send a new response.
+var errInvalidResponse = errors.New("invalid source response")
+
+const (
+	// responseReadTimeout is how long the consumer waits for a response before it
+	// checks whether it is stopped
+	responseReadTimeout = time.Second
+	// responseAttempts is how many times a response is handled before it is dropped
+	responseAttempts   = 3
+	responseRetryDelay = time.Second
+)
+
+// ResponseConsumer resolves the sources reported by their applications on the
+// responses topic, like the reports to the internal api.
+type ResponseConsumer struct {
+	Internal *Internal
+	Consumer ekafka.Consumer
+	Log      *zap.SugaredLogger
+}
+
+// Start handles the responses until the context is done, then closes the consumer.
+// A response is committed once it was handled or dropped.
+func (c *ResponseConsumer) Start(ctx context.Context) {
+	defer func() {
+		if err := c.Consumer.Close(); err != nil {
+			c.Log.Errorw("failed to close the responses consumer", "error", err)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		msg, err := c.Consumer.ReadMessage(responseReadTimeout)
+		if err != nil {
+			if !ekafka.IsTimeout(err) {
+				c.Log.Errorw("error reading the responses topic", "error", err)
+			}
+			continue
+		}
+		ekafka.RecordLag(c.Consumer, msg)
+		c.handle(ctx, msg)
+		if _, err := c.Consumer.CommitMessage(msg); err != nil {
+			// the response is handled again once the partition is reassigned
+			c.Log.Errorw("failed to commit the response", "error", err)
+		}
+	}
+}
+
+// handle resolves the source of the response, retrying the failures that are not the
+// fault of the response.
+func (c *ResponseConsumer) handle(ctx context.Context, msg *kafka.Message) {
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	// the span continues the trace of the application that sent the response
+	ctx, span := tracing.Tracer().Start(ekafka.ExtractTraceContext(ctx, msg), topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingOperationProcess,
+		),
+	)
+	defer span.End()
+
+	value, err := ekafka.StructuredEvent(msg)
+	if err != nil {
+		c.Log.Errorw("dropped a response that is not a valid event", "error", err)
+		tracing.Fail(span, err)
+		return
+	}
+	var response ekafka.KafkaResponseMessage
+	if err := json.Unmarshal(value, &response); err != nil {
+		c.Log.Errorw("dropped a response that is not a valid event", "error", err)
+		tracing.Fail(span, err)
+		return
+	}
+	span.SetAttributes(attribute.String("export.id", response.Subject), attribute.String("export.application", response.Data.Application))
+	logger := c.Log.With(export_logger.ExportIDField(response.Subject), "source_id", response.Data.UUID, "application", response.Data.Application)
+
+	for attempt := 1; ; attempt++ {
+		err := c.Internal.HandleSourceResponse(ctx, logger, response)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errInvalidResponse) || attempt == responseAttempts {
+			logger.Errorw("dropped the response", "status", response.Data.Status, "attempts", attempt, "error", err)
+			tracing.Fail(span, err)
+			return
+		}
+		logger.Errorw("failed to handle the response, it is handled again", "attempts", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(responseRetryDelay):
+		}
+	}
+}
+
+// HandleSourceResponse resolves the source of a response. A complete source has stored
+// its payload at its upload key, the payload is verified like a multipart upload. The
+// responses for the sources that are no longer pending are ignored.
+func (i *Internal) HandleSourceResponse(ctx context.Context, logger *zap.SugaredLogger, response ekafka.KafkaResponseMessage) error {
+	exportID, err := uuid.Parse(response.Subject)
+	if err != nil {
+		return fmt.Errorf("%w: the subject '%s' is not the id of an export", errInvalidResponse, response.Subject)
+	}
+	sourceID, err := uuid.Parse(response.Data.UUID)
+	if err != nil {
+		return fmt.Errorf("%w: '%s' is not the id of a source", errInvalidResponse, response.Data.UUID)
+	}
+
+	db := i.DB.WithContext(ctx)
+	payload, err := db.Get(exportID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return fmt.Errorf("%w: export '%s' not found", errInvalidResponse, exportID)
+		}
+		return err
+	}
+	logger = logger.With(export_logger.RequestIDField(payload.RequestID))
+	_, source, err := payload.GetSource(sourceID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidResponse, err)
+	}
+	if source.Application != response.Data.Application {
+		return fmt.Errorf("%w: the source belongs to '%s'", errInvalidResponse, source.Application)
+	}
+	if source.Status != models.RPending {
+		logger.Infow("ignored the response for a source that is no longer pending", "status", source.Status)
+		return nil
+	}
+
+	switch response.Data.Status {
+	case ekafka.ResponseError:
+		if response.Data.Error == nil {
+			return fmt.Errorf("%w: an error response must carry the error", errInvalidResponse)
+		}
+		sourceError := models.SourceError{Message: response.Data.Error.Message, Code: response.Data.Error.Code, Retriable: response.Data.Error.Retriable}
+		return i.resolveSource(db, payload, sourceID, models.RFailed, &sourceError)
+	case ekafka.ResponseComplete:
+		return i.completeStoredSource(ctx, logger, db, payload, source, response.Data)
+	default:
+		return fmt.Errorf("%w: unknown status '%s'", errInvalidResponse, response.Data.Status)
+	}
+}
+
+// completeStoredSource records the payload the application stored for the source and
+// resolves the source.
+func (i *Internal) completeStoredSource(ctx context.Context, logger *zap.SugaredLogger, db models.DBInterface, payload *models.ExportPayload, source *models.Source, data ekafka.ExportResponseClass) error {
+	checksum := strings.ToLower(data.Checksum)
+	if sum, err := hex.DecodeString(checksum); err != nil || (checksum != "" && len(sum) != 32) {
+		return fmt.Errorf("%w: the checksum must be a hex encoded sha256 checksum", errInvalidResponse)
+	}
+	if data.RecordCount != nil && *data.RecordCount < 0 {
+		return fmt.Errorf("%w: the record count must not be negative", errInvalidResponse)
+	}
+
+	var err error
+	limit := i.uploadLimit(payload, source)
+	if limit.full() {
+		err = s3.ErrUploadTooLarge
+	} else {
+		err = i.Compressor.CompleteStoredObject(ctx, db, source.Application, source.ID, payload, checksum, limit.Bytes)
+	}
+	var mismatch *s3.ChecksumMismatchError
+	switch {
+	case err == nil:
+	case errors.Is(err, s3.ErrUploadTooLarge):
+		logger.Infow("rejected a payload larger than its limit", "limit", limit.Bytes, "export_limit", limit.ExportBytes)
+		sourceError := uploadTooLarge(source, limit)
+		return i.resolveSource(db, payload, source.ID, models.RFailed, &sourceError)
+	case errors.As(err, &mismatch), errors.Is(err, s3.ErrObjectNotFound):
+		// the source is still pending, the application may store the payload again
+		return fmt.Errorf("%w: %v", errInvalidResponse, err)
+	case errors.Is(err, models.ErrStatusConflict):
+		logger.Infow("the export no longer accepts uploads", "error", err)
+		return nil
+	default:
+		return err
+	}
+
+	if data.RecordCount != nil {
+		if err := payload.SetSourceRecordCount(db, source.ID, *data.RecordCount); err != nil {
+			logger.Errorw("failed to set the record count of the source", "error", err)
+		}
+	}
+	logger.Infow("completed the source from its response")
+	return i.resolveSource(db, payload, source.ID, models.RSuccess, nil)
+}
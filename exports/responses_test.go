@@ -0,0 +1,206 @@
+package exports_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/exports"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/logger"
+	emiddleware "github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+// responsesConsumer serves its responses, then cancels the consumer once they were
+// all committed.
+type responsesConsumer struct {
+	messages  []*kafka.Message
+	committed int
+	cancel    context.CancelFunc
+	closed    bool
+}
+
+func (c *responsesConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	if len(c.messages) == 0 {
+		c.cancel()
+		return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	}
+	msg := c.messages[0]
+	c.messages = c.messages[1:]
+	return msg, nil
+}
+
+func (c *responsesConsumer) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	c.committed++
+	return nil, nil
+}
+
+func (c *responsesConsumer) Close() error {
+	c.closed = true
+	return nil
+}
+
+// statusRecorder records the transitions it is told about.
+type statusRecorder struct {
+	statuses []string
+	sources  []*models.Source
+}
+
+func (r *statusRecorder) Publish(status string, payload models.ExportPayload, source *models.Source) {
+	r.statuses = append(r.statuses, status)
+	r.sources = append(r.sources, source)
+}
+
+var _ = Describe("Source responses from kafka", func() {
+	cfg := config.Get()
+	log := logger.Get()
+
+	var router *chi.Mux
+	var storage *es3.MemoryStorage
+	var internalHandler *exports.Internal
+	var events *statusRecorder
+
+	BeforeEach(func() {
+		fmt.Println("...CLEANING DB...")
+		testGormDB.Exec("DELETE FROM export_payloads")
+
+		storage = es3.NewMemoryStorage()
+		events = &statusRecorder{}
+		compressor := &es3.Compressor{Log: log, Storage: storage, Cfg: *cfg}
+		internalHandler = &exports.Internal{
+			Cfg:        cfg,
+			Compressor: compressor,
+			DB:         &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			Log:        log,
+			Events:     events,
+		}
+		exportHandler := &exports.Export{
+			Cfg:                 cfg,
+			StorageHandler:      compressor,
+			DB:                  &models.ExportDB{DB: testGormDB, Cfg: cfg},
+			RequestAppResources: mockRequestApplicationResources,
+			Log:                 log,
+			Events:              events,
+		}
+
+		router = chi.NewRouter()
+		router.Route("/api/export/v1", func(sub chi.Router) {
+			sub.Use(emiddleware.EnforceIdentity, emiddleware.EnforceUserIdentity)
+			sub.Post("/exports", exportHandler.PostExport)
+		})
+	})
+
+	createExport := func() exports.ExportPayload {
+		rr := httptest.NewRecorder()
+		req := createExportRequest("testRequest", "json", "", `{"application":"exampleApp", "resource":"exampleResource"}, {"application":"exampleApp", "resource":"exampleResource2"}`)
+		AddDebugUserIdentity(req)
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusAccepted))
+
+		var export exports.ExportPayload
+		Expect(json.Unmarshal(rr.Body.Bytes(), &export)).ShouldNot(HaveOccurred())
+		return export
+	}
+
+	response := func(export exports.ExportPayload, sourceID uuid.UUID, data ekafka.ExportResponseClass) ekafka.KafkaResponseMessage {
+		data.Application = "exampleApp"
+		data.UUID = sourceID.String()
+		return ekafka.KafkaResponseMessage{ID: uuid.NewString(), Subject: export.ID, Data: data}
+	}
+
+	source := func(export exports.ExportPayload, sourceID uuid.UUID) (*models.ExportPayload, *models.Source) {
+		payload, err := (&models.ExportDB{DB: testGormDB, Cfg: cfg}).Get(uuid.MustParse(export.ID))
+		Expect(err).ShouldNot(HaveOccurred())
+		_, source, err := payload.GetSource(sourceID)
+		Expect(err).ShouldNot(HaveOccurred())
+		return payload, source
+	}
+
+	It("completes a source whose payload was stored by its application", func() {
+		export := createExport()
+		sourceID := export.Sources[0].ID
+		payload, pending := source(export, sourceID)
+		data := []byte(`[{"data": "dummy data"}]`)
+		sum := sha256.Sum256(data)
+		complete := response(export, sourceID, ekafka.ExportResponseClass{Status: ekafka.ResponseComplete, Checksum: hex.EncodeToString(sum[:])})
+
+		// the payload must be stored before the response is sent
+		err := internalHandler.HandleSourceResponse(context.Background(), log, complete)
+		Expect(err).Should(HaveOccurred())
+		_, pending = source(export, sourceID)
+		Expect(pending.Status).To(Equal(models.RPending))
+
+		key := es3.SourceObjectKey(payload, *pending)
+		Expect(storage.Put(context.Background(), key, bytes.NewReader(data), "application/json", es3.ObjectTags{})).To(Succeed())
+		Expect(internalHandler.HandleSourceResponse(context.Background(), log, complete)).To(Succeed())
+
+		_, completed := source(export, sourceID)
+		Expect(completed.Status).To(Equal(models.RSuccess))
+		Expect(completed.Checksum).To(Equal(hex.EncodeToString(sum[:])))
+		Expect(events.statuses).To(Equal([]string{notify.StatusCreated, notify.StatusSourceCompleted}))
+		Expect(events.sources[1].ID).To(Equal(sourceID))
+
+		// the source was already resolved
+		failed := response(export, sourceID, ekafka.ExportResponseClass{Status: ekafka.ResponseError, Error: &ekafka.ExportSourceError{Code: 1, Message: "too late"}})
+		Expect(internalHandler.HandleSourceResponse(context.Background(), log, failed)).To(Succeed())
+		_, completed = source(export, sourceID)
+		Expect(completed.Status).To(Equal(models.RSuccess))
+	})
+
+	It("rejects the responses that do not match their source", func() {
+		export := createExport()
+		sourceID := export.Sources[0].ID
+
+		otherApp := response(export, sourceID, ekafka.ExportResponseClass{Status: ekafka.ResponseError, Error: &ekafka.ExportSourceError{Message: "failed"}})
+		otherApp.Data.Application = "otherApp"
+		Expect(internalHandler.HandleSourceResponse(context.Background(), log, otherApp)).ShouldNot(Succeed())
+
+		noError := response(export, sourceID, ekafka.ExportResponseClass{Status: ekafka.ResponseError})
+		Expect(internalHandler.HandleSourceResponse(context.Background(), log, noError)).ShouldNot(Succeed())
+
+		unknown := response(export, sourceID, ekafka.ExportResponseClass{Status: "done"})
+		Expect(internalHandler.HandleSourceResponse(context.Background(), log, unknown)).ShouldNot(Succeed())
+
+		_, pending := source(export, sourceID)
+		Expect(pending.Status).To(Equal(models.RPending))
+	})
+
+	It("consumes the responses of the topic", func() {
+		export := createExport()
+		failed := response(export, export.Sources[1].ID, ekafka.ExportResponseClass{Status: ekafka.ResponseError, Error: &ekafka.ExportSourceError{Code: 400, Message: "unknown resource"}})
+		value, err := json.Marshal(failed)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		consumer := &responsesConsumer{
+			messages: []*kafka.Message{{Value: []byte("not an event")}, {Value: value}},
+			cancel:   cancel,
+		}
+		responses := exports.ResponseConsumer{Internal: internalHandler, Consumer: consumer, Log: log}
+		responses.Start(ctx)
+
+		Expect(consumer.committed).To(Equal(2))
+		Expect(consumer.closed).To(BeTrue())
+		_, resolved := source(export, export.Sources[1].ID)
+		Expect(resolved.Status).To(Equal(models.RFailed))
+		Expect(resolved.SourceError).ToNot(BeNil())
+		Expect(resolved.SourceError.Message).To(Equal("unknown resource"))
+		Expect(events.statuses).To(ContainElement(notify.StatusSourceFailed))
+	})
+})
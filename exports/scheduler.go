@@ -0,0 +1,138 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+// Scheduler creates the exports of the schedules once their runs are due.
+type Scheduler struct {
+	Export   *Export
+	Interval time.Duration
+	// Now returns the current time, it defaults to time.Now
+	Now func() time.Time
+}
+
+// Start runs the due schedules every interval until the context is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RunDue(ctx)
+		}
+	}
+}
+
+// RunDue creates the exports of the schedules that are due. Runs are claimed in the
+// db, so that the replicas of the service can run the scheduler concurrently.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+
+	schedules, err := s.Export.DB.WithContext(ctx).DueSchedules(t)
+	if err != nil {
+		s.Export.Log.Errorw("error querying for the due schedules", "error", err)
+		return
+	}
+	for _, schedule := range schedules {
+		s.run(ctx, schedule, t)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, schedule *models.Schedule, t time.Time) {
+	db := s.Export.DB.WithContext(ctx)
+	logger := s.Export.Log.With(export_logger.OrgIDField(schedule.OrganizationID), "schedule_id", schedule.ID)
+
+	claimed, err := db.ClaimScheduleRun(schedule, t, schedule.NextRunAfter(t))
+	if err != nil {
+		logger.Errorw("error claiming the run of the schedule", "error", err)
+		return
+	}
+	if !claimed {
+		// another replica runs it
+		return
+	}
+
+	if schedule.LastExportID != nil {
+		last, err := db.Get(*schedule.LastExportID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			logger.Errorw("error querying for the last export of the schedule", "error", err)
+			return
+		}
+		if err == nil && (last.Status == models.Pending || last.Status == models.Running || last.Status == models.Packaging) {
+			logger.Infow("skipping the run, the last export of the schedule is not finished", "export_id", last.ID)
+			return
+		}
+	}
+
+	var template ExportTemplate
+	if err := json.Unmarshal(schedule.Template, &template); err != nil {
+		logger.Errorw("error parsing the template of the schedule", "error", err)
+		return
+	}
+	dbExport, err := s.Export.newExport(template.exportPayload())
+	if err != nil {
+		logger.Errorw("the template of the schedule is no longer valid", "error", err)
+		return
+	}
+
+	usage, err := db.GetOrgStorage(schedule.OrganizationID)
+	if err != nil {
+		logger.Errorw("error querying for the org storage", "error", err)
+		return
+	}
+	if usage.OverQuota(s.Export.Cfg.OrgStorageQuotaBytes) {
+		logger.Infow("skipping the run, the org is over its storage quota", "stored_bytes", usage.StoredBytes, "quota_bytes", usage.Quota(s.Export.Cfg.OrgStorageQuotaBytes))
+		return
+	}
+	if s.Export.exportQuotasEnabled() {
+		exportsUsage, quota, err := s.Export.exportsUsage(db, schedule.OrganizationID)
+		if err != nil {
+			logger.Errorw("error counting the exports of the org", "error", err)
+			return
+		}
+		if quota != "" {
+			logger.Infow("skipping the run, the org reached its export quota", "quota", quota, "exports", exportsUsage.Exports, "in_flight_exports", exportsUsage.InFlightExports)
+			return
+		}
+	}
+
+	dbExport.RequestID = uuid.NewString()
+	dbExport.User = schedule.User
+	dbExport.Identity = schedule.Identity
+
+	dbExport, err = db.Create(dbExport)
+	if err != nil {
+		logger.Errorw("error creating payload entry", "error", err)
+		return
+	}
+	logger = logger.With(export_logger.ExportIDField(dbExport.ID.String()))
+
+	if err := db.SetScheduleExport(schedule.ID, dbExport.ID); err != nil {
+		logger.Errorw("error recording the export of the schedule", "error", err)
+	}
+
+	logger.Infow("created the export of the schedule")
+	notify.PublishStatus(s.Export.Events, notify.StatusCreated, *dbExport, nil)
+	s.Export.RequestAppResources(ctx, logger, dbExport.Identity, *dbExport)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// ScheduleRouter is a router for the /schedules endpoint, which manages the recurring
+// exports of the user.
+func (e *Export) ScheduleRouter(r chi.Router) {
+	timeout := middleware.Timeout(e.Cfg.HTTPConfig.RequestTimeout)
+
+	r.With(timeout).Post("/", e.PostSchedule)
+	r.With(timeout, middleware.PaginationCtx).Get("/", e.ListSchedules)
+	r.With(timeout).Get("/{scheduleUUID}", e.GetSchedule)
+	r.With(timeout).Delete("/{scheduleUUID}", e.DeleteSchedule)
+}
+
+// PostSchedule handles POST requests to the /schedules endpoint.
+func (e *Export) PostSchedule(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	var apiSchedule Schedule
+	if err := json.NewDecoder(r.Body).Decode(&apiSchedule); err != nil {
+		logger.Errorw("error while parsing params", "error", err)
+		decodeError(w, err)
+		return
+	}
+
+	schedule, err := APIScheduleToDBSchedule(apiSchedule)
+	if err != nil {
+		BadRequestError(w, err.Error())
+		return
+	}
+	// the template is validated as the exports it creates will be
+	if _, err := e.newExport(apiSchedule.Export.exportPayload()); err != nil {
+		BadRequestError(w, fmt.Sprintf("invalid export: %s", err))
+		return
+	}
+
+	schedule.User = mapUsertoModelUser(user)
+	schedule.Identity = r.Header.Get("X-Rh-Identity")
+	schedule.NextRun = schedule.NextRunAfter(time.Now())
+
+	schedule, err = e.DB.WithContext(r.Context()).CreateSchedule(schedule)
+	if err != nil {
+		logger.Errorw("error creating schedule", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(DBScheduleToAPI(*schedule)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// ListSchedules handles GET requests to the /schedules endpoint.
+func (e *Export) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+	page := middleware.GetPagination(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	schedules, count, err := e.DB.WithContext(r.Context()).ListSchedules(mapUsertoModelUser(user), page.Offset, page.Limit, page.Dir)
+	if err != nil {
+		logger.Errorw("error while retrieving list from database", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	data := make([]Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		data = append(data, DBScheduleToAPI(*schedule))
+	}
+	resp, err := middleware.GetPaginatedResponse(r.URL, page, count, data)
+	if err != nil {
+		logger.Errorw("error while paginating data", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// GetSchedule handles GET requests to the /schedules/{scheduleUUID} endpoint.
+func (e *Export) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	scheduleUUID, ok := scheduleUUIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	schedule, err := e.DB.WithContext(r.Context()).GetSchedule(scheduleUUID, mapUsertoModelUser(user))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			NotFoundError(w, fmt.Sprintf("schedule '%s' not found", scheduleUUID))
+			return
+		}
+		logger.Errorw("error querying for schedule", "error", err)
+		InternalServerError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(DBScheduleToAPI(*schedule)); err != nil {
+		logger.Errorw("error while encoding", "error", err)
+	}
+}
+
+// DeleteSchedule handles DELETE requests to the /schedules/{scheduleUUID} endpoint.
+// The exports the schedule already created are kept.
+func (e *Export) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	reqID := request_id.GetReqID(r.Context())
+	user := middleware.GetUserIdentity(r.Context())
+
+	logger := e.Log.With(export_logger.RequestIDField(reqID), export_logger.OrgIDField(user.OrganizationID))
+
+	scheduleUUID, ok := scheduleUUIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := e.DB.WithContext(r.Context()).DeleteSchedule(scheduleUUID, mapUsertoModelUser(user)); err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			NotFoundError(w, fmt.Sprintf("schedule '%s' not found", scheduleUUID))
+			return
+		}
+		logger.Errorw("error deleting schedule", "error", err)
+		InternalServerError(w, err)
+	}
+}
+
+func scheduleUUIDParam(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	uid := chi.URLParam(r, "scheduleUUID")
+	scheduleUUID, err := uuid.Parse(uid)
+	if err != nil {
+		BadRequestError(w, fmt.Sprintf("'%s' is not a valid schedule UUID", uid))
+		return uuid.Nil, false
+	}
+	return scheduleUUID, true
+}
+
+// exportPayload returns the request for the export created by a run of the schedule.
+func (t ExportTemplate) exportPayload() ExportPayload {
+	payload := ExportPayload{
+		Name:            t.Name,
+		Format:          t.Format,
+		NotificationURL: t.NotificationURL,
+		ExpiresInDays:   t.ExpiresInDays,
+	}
+	for _, source := range t.Sources {
+		payload.Sources = append(payload.Sources, Source{
+			Application: source.Application,
+			Resource:    source.Resource,
+			Format:      source.Format,
+			Filters:     source.Filters,
+		})
+	}
+	return payload
+}
+
+var weekdays = map[string]time.Weekday{}
+
+func init() {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekdays[strings.ToLower(day.String())] = day
+	}
+}
+
+func APIScheduleToDBSchedule(apiSchedule Schedule) (*models.Schedule, error) {
+	schedule := models.Schedule{
+		Frequency: models.ScheduleFrequency(apiSchedule.Frequency),
+		Hour:      apiSchedule.Hour,
+		Enabled:   apiSchedule.Enabled == nil || *apiSchedule.Enabled,
+	}
+
+	if !schedule.Frequency.IsValid() {
+		return nil, fmt.Errorf("'%s' is not a valid frequency, must be one of 'daily' or 'weekly'", apiSchedule.Frequency)
+	}
+	if schedule.Hour < 0 || schedule.Hour > 23 {
+		return nil, fmt.Errorf("the hour must be between 0 and 23, got %d", schedule.Hour)
+	}
+	if schedule.Frequency == models.Weekly {
+		weekday, ok := weekdays[strings.ToLower(apiSchedule.Weekday)]
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not a valid weekday, weekly schedules require the day of their runs", apiSchedule.Weekday)
+		}
+		schedule.Weekday = weekday
+	}
+
+	template, err := json.Marshal(apiSchedule.Export)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Template = template
+
+	return &schedule, nil
+}
+
+func DBScheduleToAPI(schedule models.Schedule) Schedule {
+	apiSchedule := Schedule{
+		ID:           schedule.ID,
+		CreatedAt:    schedule.CreatedAt.UTC(),
+		Frequency:    string(schedule.Frequency),
+		Hour:         schedule.Hour,
+		Enabled:      &schedule.Enabled,
+		LastExportID: schedule.LastExportID,
+	}
+	if schedule.Frequency == models.Weekly {
+		apiSchedule.Weekday = strings.ToLower(schedule.Weekday.String())
+	}
+	// the template was validated when the schedule was created
+	_ = json.Unmarshal(schedule.Template, &apiSchedule.Export)
+	if schedule.LastRun != nil {
+		lastRun := schedule.LastRun.UTC()
+		apiSchedule.LastRun = &lastRun
+	}
+	if schedule.Enabled {
+		nextRun := schedule.NextRun.UTC()
+		apiSchedule.NextRun = &nextRun
+	}
+	return apiSchedule
+}
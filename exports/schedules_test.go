@@ -0,0 +1,161 @@
+package exports_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/exports"
+	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+const scheduleTemplate = `{"name": "Scheduled Export", "format": "json", "sources": [{"application": "exampleApp", "resource": "exampleResource"}]}`
+
+func createSchedule(router http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/export/v1/schedules", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	AddDebugUserIdentity(req)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+var _ = Describe("Schedules", func() {
+	DescribeTable("can be created", func(body, expectedBody string, expectedStatus int) {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := createSchedule(router, body)
+		Expect(rr.Code).To(Equal(expectedStatus))
+		Expect(rr.Body.String()).To(ContainSubstring(expectedBody))
+	},
+		Entry("daily", fmt.Sprintf(`{"frequency": "daily", "hour": 6, "export": %s}`, scheduleTemplate), `"enabled":true`, http.StatusCreated),
+		Entry("weekly", fmt.Sprintf(`{"frequency": "weekly", "hour": 6, "weekday": "Monday", "export": %s}`, scheduleTemplate), `"weekday":"monday"`, http.StatusCreated),
+		Entry("disabled", fmt.Sprintf(`{"frequency": "daily", "hour": 6, "enabled": false, "export": %s}`, scheduleTemplate), `"next_run":null`, http.StatusCreated),
+		Entry("unless the frequency is invalid", fmt.Sprintf(`{"frequency": "hourly", "hour": 6, "export": %s}`, scheduleTemplate), "not a valid frequency", http.StatusBadRequest),
+		Entry("unless the hour is invalid", fmt.Sprintf(`{"frequency": "daily", "hour": 24, "export": %s}`, scheduleTemplate), "the hour must be between 0 and 23", http.StatusBadRequest),
+		Entry("unless a weekly schedule has no weekday", fmt.Sprintf(`{"frequency": "weekly", "hour": 6, "export": %s}`, scheduleTemplate), "not a valid weekday", http.StatusBadRequest),
+		Entry("unless the export is invalid", `{"frequency": "daily", "hour": 6, "export": {"name": "Scheduled Export", "format": "json", "sources": []}}`, "no sources provided", http.StatusBadRequest),
+	)
+
+	It("can be listed, retrieved and deleted by their owner", func() {
+		router := setupTest(mockRequestApplicationResources)
+
+		rr := createSchedule(router, fmt.Sprintf(`{"frequency": "daily", "hour": 6, "export": %s}`, scheduleTemplate))
+		Expect(rr.Code).To(Equal(http.StatusCreated))
+		var schedule exports.Schedule
+		Expect(json.Unmarshal(rr.Body.Bytes(), &schedule)).To(Succeed())
+		Expect(schedule.Export.Name).To(Equal("Scheduled Export"))
+		Expect(schedule.NextRun).ToNot(BeNil())
+
+		req := httptest.NewRequest("GET", "/api/export/v1/schedules", nil)
+		AddDebugUserIdentity(req)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Body.String()).To(ContainSubstring(`"count":1`))
+		Expect(rr.Body.String()).To(ContainSubstring(schedule.ID.String()))
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/schedules/%s", schedule.ID), nil)
+		req.Header.Add("x-rh-identity", otherUserHeader)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/export/v1/schedules/%s", schedule.ID), nil)
+		AddDebugUserIdentity(req)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/export/v1/schedules/%s", schedule.ID), nil)
+		AddDebugUserIdentity(req)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+
+	Describe("are run by the scheduler", func() {
+		var (
+			scheduler *exports.Scheduler
+			requested []uuid.UUID
+			now       time.Time
+		)
+
+		BeforeEach(func() {
+			router := setupTest(mockRequestApplicationResources)
+			rr := createSchedule(router, fmt.Sprintf(`{"frequency": "daily", "hour": 6, "export": %s}`, scheduleTemplate))
+			Expect(rr.Code).To(Equal(http.StatusCreated))
+
+			var mu sync.Mutex
+			requested = nil
+			cfg := config.Get()
+			scheduler = &exports.Scheduler{
+				Export: &exports.Export{
+					Cfg: cfg,
+					DB:  &models.ExportDB{DB: testGormDB, Cfg: cfg},
+					RequestAppResources: func(ctx context.Context, log *zap.SugaredLogger, identity string, payload models.ExportPayload) {
+						mu.Lock()
+						defer mu.Unlock()
+						requested = append(requested, payload.ID)
+					},
+					Log: logger.Get(),
+				},
+				Now: func() time.Time { return now },
+			}
+			// the first run is due by tomorrow
+			now = time.Now().AddDate(0, 0, 1)
+		})
+
+		It("once per run, however many replicas run it", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					scheduler.RunDue(context.Background())
+				}()
+			}
+			wg.Wait()
+
+			Expect(requested).To(HaveLen(1))
+			var export models.ExportPayload
+			Expect(testGormDB.Preload("Sources").Take(&export, "id = ?", requested[0]).Error).To(Succeed())
+			Expect(export.Name).To(Equal("Scheduled Export"))
+			Expect(export.Sources).To(HaveLen(1))
+			Expect(export.OrganizationID).To(Equal("10000001"))
+			Expect(export.Identity).To(Equal(debugHeader))
+
+			var schedule models.Schedule
+			Expect(testGormDB.Take(&schedule).Error).To(Succeed())
+			Expect(*schedule.LastExportID).To(Equal(requested[0]))
+			Expect(schedule.NextRun.After(now)).To(BeTrue())
+		})
+
+		It("skipping the runs while the last export is not finished", func() {
+			scheduler.RunDue(context.Background())
+			Expect(requested).To(HaveLen(1))
+
+			now = now.AddDate(0, 0, 1)
+			scheduler.RunDue(context.Background())
+			Expect(requested).To(HaveLen(1))
+
+			testGormDB.Exec("UPDATE export_payloads SET status = ? WHERE id = ?", models.Complete, requested[0])
+			now = now.AddDate(0, 0, 1)
+			scheduler.RunDue(context.Background())
+			Expect(requested).To(HaveLen(2))
+		})
+	})
+})
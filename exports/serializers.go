@@ -0,0 +1,209 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/openapi"
+)
+
+type serializerKey int
+
+const serializerCtxKey serializerKey = iota
+
+// serializer turns exports into the response bodies of a version of the public api,
+// so that the handlers are shared between the versions.
+type serializer interface {
+	export(r *http.Request, payload models.ExportPayload) interface{}
+	list(r *http.Request, exports []*models.APIExport) interface{}
+	// schemas documents the bodies returned by export and list
+	schemas(b *openapi.Builder) (export *openapi.Schema, listItem *openapi.Schema)
+}
+
+// ExportRouterV2 is a router for the /exports endpoint of the version 2 of the
+// public api, whose responses embed the links of the exports.
+func (e *Export) ExportRouterV2(r chi.Router) {
+	r.Use(serializerCtx(v2Serializer{cfg: e.Cfg}))
+	e.ExportRouter(r)
+}
+
+// serializerCtx is a middleware that sets the serializer of the responses.
+func serializerCtx(s serializer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), serializerCtxKey, s)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// getSerializer returns the serializer of the request, the responses of the version 1
+// of the api are the default.
+func getSerializer(r *http.Request) serializer {
+	if s, ok := r.Context().Value(serializerCtxKey).(serializer); ok {
+		return s
+	}
+	return v1Serializer{}
+}
+
+// v1CompleteWithErrors is the name of the complete_with_errors status in the version 1
+// of the api.
+const v1CompleteWithErrors = "partial"
+
+// v1Serializer keeps the responses of the version 1 of the api unchanged.
+type v1Serializer struct{}
+
+func (v1Serializer) export(r *http.Request, payload models.ExportPayload) interface{} {
+	apiExport := DBExportToAPI(payload)
+	apiExport.Request = includedRequest(r, payload)
+	apiExport.Status = v1Status(apiExport.Status)
+	return apiExport
+}
+
+func (v1Serializer) list(r *http.Request, exports []*models.APIExport) interface{} {
+	for _, export := range exports {
+		export.Status = v1Status(export.Status)
+	}
+	return exports
+}
+
+// v1Status returns the status of an export as named by the version 1 of the api.
+func v1Status(status string) string {
+	if status == string(models.CompleteWithErrors) {
+		return v1CompleteWithErrors
+	}
+	return status
+}
+
+func (v1Serializer) schemas(b *openapi.Builder) (*openapi.Schema, *openapi.Schema) {
+	return b.SchemaOf(ExportPayload{}), b.SchemaOf(models.APIExport{})
+}
+
+// v2Serializer embeds the links of the exports and their sources.
+type v2Serializer struct {
+	cfg *config.ExportConfig
+}
+
+func (s v2Serializer) export(r *http.Request, payload models.ExportPayload) interface{} {
+	links := newLinkBuilder(s.cfg, r)
+	apiExport := DBExportToAPI(payload)
+	apiExport.Request = includedRequest(r, payload)
+
+	result := ExportPayloadV2{
+		ExportPayload: apiExport,
+		Sources:       make([]SourceV2, 0, len(apiExport.Sources)),
+		Links:         links.export(payload.ID, payload.Status),
+	}
+	for _, source := range apiExport.Sources {
+		sourceV2 := SourceV2{Source: source}
+		if source.DownloadHref != "" {
+			// the link replaces the relative href of the version 1
+			sourceV2.DownloadHref = ""
+			sourceV2.Links.Download = links.source(payload.ID, source.ID)
+		}
+		result.Sources = append(result.Sources, sourceV2)
+	}
+	return result
+}
+
+func (s v2Serializer) list(r *http.Request, exports []*models.APIExport) interface{} {
+	links := newLinkBuilder(s.cfg, r)
+
+	result := make([]APIExportV2, 0, len(exports))
+	for _, export := range exports {
+		result = append(result, APIExportV2{
+			APIExport: *export,
+			Links:     links.export(export.ID, models.PayloadStatus(export.Status)),
+		})
+	}
+	return result
+}
+
+func (v2Serializer) schemas(b *openapi.Builder) (*openapi.Schema, *openapi.Schema) {
+	return b.SchemaOf(ExportPayloadV2{}), b.SchemaOf(APIExportV2{})
+}
+
+// includedRequest returns the original request of the export when the client asked
+// for it with `include=request`. The exports created before the requests were kept
+// have none.
+func includedRequest(r *http.Request, payload models.ExportPayload) *ExportRequest {
+	if payload.OriginalRequest == nil {
+		return nil
+	}
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(include) != "request" {
+			continue
+		}
+		var request ExportRequest
+		if err := json.Unmarshal(payload.OriginalRequest, &request); err != nil {
+			return nil
+		}
+		return &request
+	}
+	return nil
+}
+
+// linkBuilder builds the absolute urls of the exports in the version 2 of the api.
+type linkBuilder struct {
+	exportsURL string
+}
+
+func newLinkBuilder(cfg *config.ExportConfig, r *http.Request) linkBuilder {
+	return linkBuilder{exportsURL: externalBaseURL(cfg, r) + PublicBasePathV2 + "/exports"}
+}
+
+func (l linkBuilder) export(exportID uuid.UUID, status models.PayloadStatus) ExportLinks {
+	links := ExportLinks{
+		Self:   fmt.Sprintf("%s/%s", l.exportsURL, exportID),
+		Status: fmt.Sprintf("%s/%s/status", l.exportsURL, exportID),
+	}
+	if status == models.Complete || status == models.CompleteWithErrors {
+		links.Download = fmt.Sprintf("%s/%s", l.exportsURL, exportID)
+	}
+	return links
+}
+
+func (l linkBuilder) source(exportID, sourceID uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/sources/%s", l.exportsURL, exportID, sourceID)
+}
+
+// externalBaseURL returns where the client reached the public api: the configured
+// EXTERNAL_BASE_URL, or the origin the gateway forwarded the request from.
+func externalBaseURL(cfg *config.ExportConfig, r *http.Request) string {
+	if cfg != nil && cfg.ExternalBaseURL != "" {
+		return cfg.ExternalBaseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := forwardedHeader(r, "X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwardedHost := forwardedHeader(r, "X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	prefix := strings.TrimSuffix(forwardedHeader(r, "X-Forwarded-Prefix"), "/")
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, prefix)
+}
+
+// forwardedHeader returns the value set by the gateway closest to the client when
+// the request went through several.
+func forwardedHeader(r *http.Request, name string) string {
+	return strings.TrimSpace(strings.Split(r.Header.Get(name), ",")[0])
+}
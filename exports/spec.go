@@ -0,0 +1,651 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"net/http"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/middleware"
+	"github.com/redhatinsights/export-service-go/openapi"
+)
+
+const (
+	// PublicBasePath is where the public api is routed
+	PublicBasePath = "/api/export/v1"
+	// PublicBasePathV2 is where the version 2 of the public api is routed
+	PublicBasePathV2 = "/api/export/v2"
+	// PrivateBasePath is where the private api is routed
+	PrivateBasePath = "/app/export/v1"
+
+	specVersion = "0.1.0"
+)
+
+const identityDescription = "Base64-encoded JSON identity header provided by 3Scale. Contains an account number of the user issuing the request. Format of the JSON:\n\n" +
+	"```\n" +
+	`{"identity": {"account_number": "account123", "org_id": "org123", "type": "User", "user": {"username": "user", "is_org_admin": true}, "internal": {"org_id": "org123"}}}` +
+	"\n```\n\n" +
+	"`ServiceAccount` identities, identified by the `client_id` of their `service_account`, and `System` identities, identified by the `cn` of their certificate, are accepted as well. Exports are only visible to the identity that requested them."
+
+// response documents a response with an optional json body.
+func response(description string, body *openapi.Schema) openapi.Response {
+	resp := openapi.Response{Description: description}
+	if body != nil {
+		resp.Content = openapi.JSON(body)
+	}
+	return resp
+}
+
+func queryParam(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// limitSchema is the schema of the limit of a page, which is bounded by the maximum of
+// the pagination.
+func limitSchema() *openapi.Schema {
+	schema := openapi.Integer(0)
+	maximum := float64(middleware.MaxLimit)
+	schema.Maximum = &maximum
+	return schema
+}
+
+// listParams are the query params filtering and paginating a list of exports.
+func listParams() []openapi.Parameter {
+	return []openapi.Parameter{
+		queryParam("name", "Only list the exports whose name contains this, ignoring the case", openapi.String()),
+		queryParam("application", "Only list the exports with a source of this application", openapi.String()),
+		queryParam("resource", "Only list the exports with a source of this resource", openapi.String()),
+		queryParam("status", "Only list the exports with this status", openapi.String("partial", "complete_with_errors", "pending", "running", "packaging", "complete", "failed", "cancelled")),
+		queryParam("created_at", "Only list the exports created on this date, in ISO 8601 or YYYY-MM-DD", openapi.String()),
+		queryParam("expires_at", "Only list the exports expiring on this date, in ISO 8601 or YYYY-MM-DD", openapi.String()),
+		queryParam("created_at_gte", "Only list the exports created on or after this date, in ISO 8601 or YYYY-MM-DD", openapi.String()),
+		queryParam("created_at_lte", "Only list the exports created on or before this date, in ISO 8601 or YYYY-MM-DD for the whole day", openapi.String()),
+		queryParam("expires_at_gte", "Only list the exports expiring on or after this date, in ISO 8601 or YYYY-MM-DD", openapi.String()),
+		queryParam("expires_at_lte", "Only list the exports expiring on or before this date, in ISO 8601 or YYYY-MM-DD for the whole day", openapi.String()),
+		queryParam("limit", "The number of exports in the page, the default page when 0", limitSchema()),
+		queryParam("offset", "The index of the first export of the page", openapi.Integer(0)),
+		queryParam("sort", "The field the exports are sorted by", openapi.String("name", "created", "created_at", "expires", "expires_at", "status")),
+		queryParam("dir", "The direction the exports are sorted in", openapi.String("asc", "desc")),
+	}
+}
+
+// page returns the schema of a page of the items.
+func page(b *openapi.Builder, items *openapi.Schema) *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{
+		"meta":  b.SchemaOf(middleware.Meta{}),
+		"links": b.SchemaOf(middleware.Links{}),
+		"data":  openapi.Array(items),
+	})
+}
+
+// PublicSpec documents the operations of the public api.
+func PublicSpec() *openapi.Builder {
+	return publicSpec(PublicBasePath, v1Serializer{})
+}
+
+// PublicSpecV2 documents the operations of the version 2 of the public api.
+func PublicSpecV2() *openapi.Builder {
+	return publicSpec(PublicBasePathV2, v2Serializer{})
+}
+
+func publicSpec(basePath string, s serializer) *openapi.Builder {
+	b := openapi.NewBuilder(
+		openapi.Info{Title: "consoledot Export Service - Public API", Version: specVersion},
+		openapi.Server{
+			URL:       "{server}" + basePath,
+			Variables: map[string]openapi.ServerVariable{"server": {Default: "http://localhost:8080"}},
+		},
+	)
+	b.SecuritySchemes["3ScaleIdentity"] = openapi.SecurityScheme{Type: "apiKey", In: "header", Name: "x-rh-identity", Description: identityDescription}
+	b.Security = []openapi.SecurityRequirement{{"3ScaleIdentity": {}}}
+
+	b.PathParam(openapi.Parameter{Name: "exportUUID", Description: "The ID of the export", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+	b.PathParam(openapi.Parameter{Name: "sourceUUID", Description: "The ID of the source", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+	b.PathParam(openapi.Parameter{Name: "scheduleUUID", Description: "The ID of the schedule", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+
+	errorBody := b.SchemaOf(Error{})
+	export, listItem := s.schemas(b)
+
+	b.Handle(http.MethodGet, "/openapi.json", openapi.Operation{
+		OperationID: "getOpenAPISpec",
+		Description: "Returns this document.",
+		Responses:   map[string]openapi.Response{"200": response("The OpenAPI document of the public api", &openapi.Schema{Type: "object"})},
+		Anonymous:   true,
+	})
+	b.Handle(http.MethodGet, "/ping", openapi.Operation{
+		OperationID: "ping",
+		Responses:   map[string]openapi.Response{"200": {Description: "Hello world"}},
+	})
+	b.Handle(http.MethodGet, "/limits", openapi.Operation{
+		OperationID: "getLimits",
+		Description: "Returns the limits of the requests, so that clients can validate them. A limit of 0 is disabled.",
+		Responses:   map[string]openapi.Response{"200": response("The limits of the requests", b.SchemaOf(Limits{}))},
+	})
+	b.Handle(http.MethodPost, "/exports", openapi.Operation{
+		OperationID: "createExport",
+		Description: "Schedules an export of the sources. The id, status and timestamps of the request are ignored. The archive is encrypted to the OpenPGP public key of `encryption`, when one is given.",
+		Parameters: []openapi.Parameter{
+			{Name: "Idempotency-Key", In: "header", Description: "A unique key of the request, at most 255 characters. The retries of the request with the same key within IDEMPOTENCY_KEY_TTL get the export of the first one, with the Idempotent-Replayed header, instead of creating another.", Schema: openapi.String()},
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(ExportPayload{}))},
+		Responses: map[string]openapi.Response{
+			"202": {
+				Description: "Export scheduled",
+				Content:     openapi.JSON(export),
+				Headers: map[string]openapi.Header{
+					"Idempotent-Replayed": {Description: "Set to true when the export was created by an earlier request with the same Idempotency-Key", Schema: openapi.String()},
+				},
+			},
+			"400": response("The request is invalid, it has more sources or larger filters than allowed by the limits, it is larger than allowed once normalized, a source requests a format its application does not allow, or its encryption key can not be used", errorBody),
+			"403": response("The user lacks the permission to create exports, or the organization reached its quota of exports that have not expired, or of exports pending or running at once. The body describes its usage.", b.SchemaOf(ExportQuotaError{})),
+			"409": response("An export is being created with the same Idempotency-Key, retry the request", errorBody),
+			"413": response("The request body is larger than allowed by the limits", errorBody),
+			"422": response("The Idempotency-Key was already used for another export request", errorBody),
+			"429": response("Insufficient storage, the exports of the organization use up its storage quota. Deleting exports frees space.", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports", openapi.Operation{
+		OperationID: "getExports",
+		Parameters:  listParams(),
+		Responses: map[string]openapi.Response{
+			"200": response("Matching exports", page(b, listItem)),
+			"400": response("The query params are invalid", errorBody),
+		},
+	})
+	b.Handle(http.MethodDelete, "/exports", openapi.Operation{
+		OperationID: "deleteExports",
+		Description: "Deletes the exports of the `ids`, or up to 1000 of the oldest exports matching the `filter`. They can be restored until their archives and source objects are purged after `DELETED_EXPORT_RETENTION`. The exports that had not finished are cancelled, and the exports being packaged are kept. The ids that can not be deleted carry their error rather than failing the request.",
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(BulkDelete{}))},
+		Responses: map[string]openapi.Response{
+			"200": response("The deleted exports", b.SchemaOf(BulkDeleteResult{})),
+			"400": response("The request is invalid, has more than 1000 ids, or an empty filter", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports/summary", openapi.Operation{
+		OperationID: "getExportsSummary",
+		Responses:   map[string]openapi.Response{"200": response("Summary of the exports of the organization", b.SchemaOf(Summary{}))},
+	})
+	b.Handle(http.MethodGet, "/exports/status", openapi.Operation{
+		OperationID: "getExportsStatus",
+		Description: "Returns the status of up to 100 exports at once, in the order of their ids. The exports that can not be returned, because they do not exist or belong to another user, carry their error in place of the export.",
+		Parameters: []openapi.Parameter{
+			{Name: "ids", In: "query", Required: true, Description: "The comma separated ids of the exports", Schema: openapi.String()},
+			{Name: "If-None-Match", In: "header", Description: "The ETag of a previous response, a 304 is returned while the status is unchanged", Schema: openapi.String()},
+		},
+		Responses: map[string]openapi.Response{
+			"200": {
+				Description: "The status of the exports",
+				Headers:     map[string]openapi.Header{"ETag": {Description: "The version of the response", Schema: openapi.String()}},
+				Content: openapi.JSON(openapi.Object(map[string]*openapi.Schema{
+					"data": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+						"id":     openapi.String(),
+						"export": export,
+						"error":  errorBody,
+					})),
+				})),
+			},
+			"304": {Description: "The status is unchanged since the ETag of If-None-Match"},
+			"400": response("No ids, or more than 100 ids, were given", errorBody),
+		},
+	})
+	rangeParams := []openapi.Parameter{
+		{Name: "Range", In: "header", Description: "A single range of bytes, e.g. `bytes=1048576-`, to resume a download. Several ranges get the whole download.", Schema: openapi.String()},
+		{Name: "If-Range", In: "header", Description: "The Last-Modified date of the partial response the download resumes, the whole download is sent if it changed since", Schema: openapi.String()},
+	}
+	partialContent := openapi.Response{
+		Description: "The requested range of the download",
+		Headers: map[string]openapi.Header{
+			"Content-Range": {Description: "The range sent and the size of the download", Schema: openapi.String()},
+			"Last-Modified": {Description: "The date to send in If-Range when resuming the download", Schema: openapi.String()},
+		},
+	}
+	b.Handle(http.MethodGet, "/exports/{exportUUID}", openapi.Operation{
+		OperationID: "downloadExport",
+		Parameters: append([]openapi.Parameter{
+			queryParam("allow_partial", "Download an archive of the completed sources while the others are not resolved yet. It is built for the download and can not be resumed.", openapi.Boolean()),
+		}, rangeParams...),
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Export data", Content: map[string]openapi.MediaType{
+				"application/zip":           {Schema: openapi.Binary()},
+				"application/gzip":          {Schema: openapi.Binary()},
+				"application/zstd":          {Schema: openapi.Binary()},
+				"application/pgp-encrypted": {Schema: openapi.Binary()},
+			}},
+			"206": partialContent,
+			"302": {Description: "STORAGE_PRESIGN_DOWNLOADS is set, the download is redirected to a presigned url of the archive"},
+			"307": {Description: "Too many downloads are in progress, the download is redirected to a presigned url of the archive"},
+			"400": response("The export is not ready for download, or none of its sources completed with allow_partial", errorBody),
+			"404": response("The export does not exist", errorBody),
+			"409": response("The export was cancelled, the sources that completed can be downloaded with allow_partial", errorBody),
+			"416": response("The range is outside of the archive", errorBody),
+			"503": response("Too many downloads are in progress, retry after the Retry-After header", errorBody),
+			"504": response("The export could not be retrieved from storage in time", errorBody),
+		},
+	})
+	b.Handle(http.MethodDelete, "/exports/{exportUUID}", openapi.Operation{
+		OperationID: "deleteExport",
+		Description: "Deletes the export, it can be restored until it is purged after `DELETED_EXPORT_RETENTION`. An export that has not finished is cancelled, the applications of its outstanding sources are told to stop working on them.",
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Export deleted"},
+			"404": response("The export does not exist", errorBody),
+			"409": response("The export is being packaged", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/exports/{exportUUID}/restore", openapi.Operation{
+		OperationID: "restoreExport",
+		Description: "Restores the deleted export until it is purged after `DELETED_EXPORT_RETENTION`. An export deleted before it finished stays cancelled.",
+		Responses: map[string]openapi.Response{
+			"200": response("The restored export", export),
+			"400": response("The export id is not a valid UUID", errorBody),
+			"404": response("The export does not exist or was purged", errorBody),
+			"409": response("The export is not deleted", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/exports/{exportUUID}/cancel", openapi.Operation{
+		OperationID: "cancelExport",
+		Description: "Cancels the export while it is pending or running. Its pending sources are cancelled and no longer accept uploads, and their applications are told to stop working on them. Unlike a delete the export is kept, with the sources that completed.",
+		Responses: map[string]openapi.Response{
+			"200": response("The cancelled export", export),
+			"400": response("The export id is not a valid UUID", errorBody),
+			"404": response("The export does not exist", errorBody),
+			"409": response("The export is being packaged or has finished", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports/{exportUUID}/status", openapi.Operation{
+		OperationID: "getExportStatus",
+		Parameters: []openapi.Parameter{
+			queryParam("include", "`request` includes the normalized request the export was created from", openapi.String("request")),
+		},
+		Responses: map[string]openapi.Response{
+			"200": response("Export status", export),
+			"404": response("The export does not exist", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports/{exportUUID}/sources/{sourceUUID}", openapi.Operation{
+		OperationID: "downloadExportSource",
+		Description: "Download the payload of a single completed source, even while the other sources of the export are still pending.",
+		Parameters:  rangeParams,
+		Responses: map[string]openapi.Response{
+			"200": {
+				Description: "Source data",
+				Headers: map[string]openapi.Header{
+					"Digest": {Description: "The sha-256 checksum of the payload, also the checksum of the source in the status", Schema: openapi.String()},
+				},
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: openapi.Binary()},
+					"text/csv":         {Schema: openapi.Binary()},
+				},
+			},
+			"206": partialContent,
+			"302": {Description: "STORAGE_PRESIGN_DOWNLOADS is set, the download is redirected to a presigned url of the source"},
+			"307": {Description: "Too many downloads are in progress, the download is redirected to a presigned url of the source"},
+			"404": response("The export or the source does not exist", errorBody),
+			"409": response("The source is not complete, or the archive of the export is encrypted", errorBody),
+			"410": response("The source objects were removed once the export was packaged, download the export archive instead", errorBody),
+			"416": response("The range is outside of the payload", errorBody),
+			"503": response("Too many downloads are in progress, retry after the Retry-After header", errorBody),
+			"504": response("The source could not be retrieved from storage in time", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports/{exportUUID}/sources/{sourceUUID}/status", openapi.Operation{
+		OperationID: "getExportSourceStatus",
+		Description: "Returns the status of a single source, with the error, the size of the payload and the timestamps of its delivery and upload.",
+		Responses: map[string]openapi.Response{
+			"200": response("Source status", b.SchemaOf(SourceStatus{})),
+			"400": response("The source id is not a valid UUID", errorBody),
+			"404": response("The export or the source does not exist", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/schedules", openapi.Operation{
+		OperationID: "createSchedule",
+		Description: "Creates an export of the template every day, or every week, at the hour in UTC. A run is skipped while the export of the previous run is not finished. The id and the timestamps of the request are ignored.",
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(Schedule{}))},
+		Responses: map[string]openapi.Response{
+			"201": response("Schedule created", b.SchemaOf(Schedule{})),
+			"400": response("The request is invalid, or its export has more sources or larger filters than allowed by the limits", errorBody),
+			"413": response("The request body is larger than allowed by the limits", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/schedules", openapi.Operation{
+		OperationID: "getSchedules",
+		Parameters: []openapi.Parameter{
+			queryParam("limit", "The number of schedules in the page", openapi.Integer(0)),
+			queryParam("offset", "The index of the first schedule of the page", openapi.Integer(0)),
+			queryParam("dir", "The direction the schedules are sorted in by their creation", openapi.String("asc", "desc")),
+		},
+		Responses: map[string]openapi.Response{
+			"200": response("The schedules of the user", page(b, b.SchemaOf(Schedule{}))),
+			"400": response("The query params are invalid", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/schedules/{scheduleUUID}", openapi.Operation{
+		OperationID: "getSchedule",
+		Responses: map[string]openapi.Response{
+			"200": response("Schedule", b.SchemaOf(Schedule{})),
+			"404": response("The schedule does not exist", errorBody),
+		},
+	})
+	b.Handle(http.MethodDelete, "/schedules/{scheduleUUID}", openapi.Operation{
+		OperationID: "deleteSchedule",
+		Description: "Deletes the schedule, the exports it created are kept.",
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Schedule deleted"},
+			"404": response("The schedule does not exist", errorBody),
+		},
+	})
+	return b
+}
+
+// PrivateSpec documents the operations of the private api.
+func PrivateSpec() *openapi.Builder {
+	b := openapi.NewBuilder(
+		openapi.Info{Title: "consoledot Export Service - Private API", Version: specVersion},
+		openapi.Server{
+			URL:         "{internal_server}" + PrivateBasePath,
+			Description: "Internal API used to upload export data",
+			Variables:   map[string]openapi.ServerVariable{"internal_server": {Default: "http://localhost:10010"}},
+		},
+	)
+	b.SecuritySchemes["psk"] = openapi.SecurityScheme{Type: "apiKey", In: "header", Name: "x-rh-exports-psk"}
+	b.Security = []openapi.SecurityRequirement{{"psk": {}}}
+
+	b.PathParam(openapi.Parameter{Name: "exportUUID", Description: "The ID of the export", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+	b.PathParam(openapi.Parameter{Name: "sourceUUID", Description: "The ID of the source", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+	b.PathParam(openapi.Parameter{Name: "resourceUUID", Description: "The ID of the resource that is being exported", Schema: &openapi.Schema{Type: "string", Format: "uuid"}})
+	b.PathParam(openapi.Parameter{Name: "application", Description: "The name of the application that is exporting data", Schema: openapi.String()})
+	b.PathParam(openapi.Parameter{Name: "orgID", Description: "The ID of the organization", Schema: openapi.String()})
+	b.PathParam(openapi.Parameter{Name: "uploadID", Description: "The ID of the multipart upload", Schema: openapi.String()})
+	b.PathParam(openapi.Parameter{Name: "partNumber", Description: "The number of the part, the parts are assembled in the order of their numbers", Schema: openapi.Integer(1)})
+
+	errorBody := b.SchemaOf(Error{})
+	adminExport := b.SchemaOf(AdminExportPayload{})
+	orgStorage := b.SchemaOf(OrgStorage{})
+	kafkaDebug := b.SchemaOf(ekafka.DebugInfo{})
+	audited := " Every call is audit logged with the id of the psk that made it."
+
+	b.Handle(http.MethodGet, "/ping", openapi.Operation{
+		OperationID: "ping",
+		Responses:   map[string]openapi.Response{"200": {Description: "Hello world"}},
+	})
+	b.Handle(http.MethodGet, "/debug/kafka", openapi.Operation{
+		OperationID: "debugKafka",
+		Description: "Reports the configured brokers and topic along with the result of a metadata request to the cluster. Credentials are never included.",
+		Responses: map[string]openapi.Response{
+			"200": response("The brokers responded to the metadata request", kafkaDebug),
+			"503": response("The brokers could not be reached", kafkaDebug),
+		},
+	})
+	redrive := b.SchemaOf(ekafka.RedriveResult{})
+	b.Handle(http.MethodPost, "/kafka/dlq/redrive", openapi.Operation{
+		OperationID: "redriveKafkaDLQ",
+		Description: "Sends the messages of the dead letter topic back to their original topic. The messages are committed once they are handed to the producer, the redrive stops early once the topic is drained.",
+		Parameters: []openapi.Parameter{
+			queryParam("max", "The number of messages to redrive, at most 1000", openapi.Integer(1)),
+		},
+		Responses: map[string]openapi.Response{
+			"200": response("The number of redriven messages", redrive),
+			"400": response("The max is not between 1 and 1000", redrive),
+			"503": response("The dead letter topic could not be read, along with the number of messages redriven before", redrive),
+		},
+	})
+	digestParam := openapi.Parameter{Name: "Digest", In: "header", Description: "The base64 encoded sha-256 checksum of the payload, e.g. `sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=`. The stored payload is verified against it.", Schema: openapi.String()}
+	b.Handle(http.MethodPost, "/{exportUUID}/{application}/{resourceUUID}/upload", openapi.Operation{
+		OperationID: "uploadExportSource",
+		Tags:        []string{"internal"},
+		Parameters: []openapi.Parameter{
+			{Name: "X-Record-Count", In: "header", Description: "The number of records of the payload, it may be sent as a trailer instead. The count is advisory, an invalid trailer is ignored.", Schema: openapi.Integer(0)},
+			digestParam,
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/json": {Schema: &openapi.Schema{Type: "object"}},
+			"text/csv":         {Schema: openapi.Binary()},
+		}},
+		Responses: map[string]openapi.Response{
+			"202": {Description: "The upload was accepted"},
+			"400": response("The X-Record-Count header is not a non-negative integer, the Digest header is invalid, or the checksum of the payload does not match it. The source is still pending after a mismatch.", errorBody),
+			"404": response("The export does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+			"413": response("The payload is larger than allowed for the application, or than what is left of the limit of the export, the source is failed", errorBody),
+			"415": response("The Content-Type of the upload does not match the requested format", errorBody),
+			"504": response("The upload to storage timed out. The source is still pending and the upload may be retried.", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/{exportUUID}/{application}/{resourceUUID}/upload", openapi.Operation{
+		OperationID: "getExportUpload",
+		Description: "Streams the payload an application uploaded for a source back to it. Only psks scoped to the application with PSK_APPLICATIONS may read its uploads.",
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"200": {
+				Description: "The uploaded payload",
+				Headers: map[string]openapi.Header{
+					"Digest": {Description: "The sha-256 checksum of the payload, computed when it was uploaded", Schema: openapi.String()},
+				},
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{Type: "object"}},
+					"text/csv":         {Schema: openapi.Binary()},
+				},
+			},
+			"403": response("The psk is not scoped to the application", errorBody),
+			"404": response("The source was not uploaded, or its upload has been removed", errorBody),
+			"504": response("Reading the upload from storage timed out", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/{exportUUID}/{application}/{resourceUUID}/upload/multipart", openapi.Operation{
+		OperationID: "startExportSourceMultipartUpload",
+		Description: "Starts an upload of the payload of a source in parts, for payloads too large to be uploaded reliably in one request. The Content-Type of the request is the one of the payload.",
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"201": response("The upload was started", b.SchemaOf(MultipartUpload{})),
+			"404": response("The export does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+			"415": response("The Content-Type of the upload does not match the requested format", errorBody),
+			"501": response("The storage does not support multipart uploads", errorBody),
+		},
+	})
+	b.Handle(http.MethodPut, "/{exportUUID}/{application}/{resourceUUID}/upload/multipart/{uploadID}/parts/{partNumber}", openapi.Operation{
+		OperationID: "uploadExportSourcePart",
+		Description: "Uploads a part of a multipart upload. Every part but the last must have at least min_part_bytes. A part may be uploaded again, the last upload of a part number replaces the previous ones.",
+		Tags:        []string{"internal"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/octet-stream": {Schema: openapi.Binary()},
+		}},
+		Responses: map[string]openapi.Response{
+			"200": response("The part was uploaded", b.SchemaOf(UploadedPart{})),
+			"400": response("The part number is out of range or the part is empty", errorBody),
+			"404": response("The export or the upload does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+			"413": response("The part is larger than max_part_bytes", errorBody),
+			"504": response("The upload to storage timed out, the part may be uploaded again", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/{exportUUID}/{application}/{resourceUUID}/upload/multipart/{uploadID}/complete", openapi.Operation{
+		OperationID: "completeExportSourceMultipartUpload",
+		Description: "Assembles the uploaded parts into the payload of the source, which is then processed like one uploaded in one request.",
+		Tags:        []string{"internal"},
+		Parameters: []openapi.Parameter{
+			{Name: "X-Record-Count", In: "header", Description: "The number of records of the payload. The count is advisory.", Schema: openapi.Integer(0)},
+			digestParam,
+		},
+		Responses: map[string]openapi.Response{
+			"202": {Description: "The upload was accepted"},
+			"400": response("The X-Record-Count header is not a non-negative integer, the Digest header is invalid or does not match the assembled payload, or the parts can not be assembled", errorBody),
+			"404": response("The export or the upload does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+			"413": response("The payload is larger than allowed for the application, or than what is left of the limit of the export, the source is failed", errorBody),
+			"504": response("Assembling the upload timed out. The source is still pending and the upload may be completed again.", errorBody),
+		},
+	})
+	b.Handle(http.MethodDelete, "/{exportUUID}/{application}/{resourceUUID}/upload/multipart/{uploadID}", openapi.Operation{
+		OperationID: "abortExportSourceMultipartUpload",
+		Description: "Discards a multipart upload and its parts, the source is still pending.",
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"204": {Description: "The upload was discarded"},
+			"404": response("The export or the upload does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+		},
+	})
+	b.Handle(http.MethodPost, "/{exportUUID}/{application}/{resourceUUID}/error", openapi.Operation{
+		OperationID: "reportExportSourceError",
+		Tags:        []string{"internal"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(SourceError{}))},
+		Responses: map[string]openapi.Response{
+			"202": {Description: "The error was recorded"},
+			"400": response("The error is invalid", errorBody),
+			"404": response("The export does not exist", errorBody),
+			"410": {Description: "The source was already processed, the export is packaged and no longer accepts uploads, or the export was cancelled (`export cancelled`)"},
+		},
+	})
+	b.Handle(http.MethodGet, "/exports", openapi.Operation{
+		OperationID: "adminListExports",
+		Description: "Lists the exports of every organization for support purposes." + audited,
+		Tags:        []string{"internal"},
+		Parameters: append([]openapi.Parameter{
+			queryParam("org_id", "Only list the exports of this organization", openapi.String()),
+			queryParam("request_id", "Only list the export created by the request with this X-Request-Id, also the requestid of its events", openapi.String()),
+		}, listParams()...),
+		Responses: map[string]openapi.Response{
+			"200": response("A page of exports", page(b, adminExport)),
+			"400": response("The query params are invalid", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/exports/{exportUUID}", openapi.Operation{
+		OperationID: "adminGetExport",
+		Description: "Returns an export of any organization, including internal details." + audited,
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"200": response("The export", adminExport),
+			"404": response("The export does not exist", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/exports/{exportUUID}/sources/{sourceUUID}/resolve", openapi.Operation{
+		OperationID: "adminResolveSource",
+		Description: "Forces a pending source into its final status, as if its application had reported it. The export is packaged if it was the last outstanding source." + audited,
+		Tags:        []string{"internal"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(ResolveSource{}))},
+		Responses: map[string]openapi.Response{
+			"202": response("The source was resolved", adminExport),
+			"400": response("The requested status is invalid", errorBody),
+			"404": response("The export or source does not exist", errorBody),
+			"409": response("The source is not pending, or it is resolved as complete without a stored object", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/exports/{exportUUID}/sources/{sourceUUID}/announce", openapi.Operation{
+		OperationID: "adminAnnounceSource",
+		Description: "Sends the request for the data of a pending source to its application again, as a new CloudEvent carrying the same data. The delivery of the message is reported in the announced_at and delivery_error fields of the source." + audited,
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"202": response("The source was sent to the producer", adminExport),
+			"404": response("The export or source does not exist", errorBody),
+			"409": response("The source is not pending, or the export was created before the identity of its requester was recorded", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/exports/{exportUUID}/announce", openapi.Operation{
+		OperationID: "adminAnnounceExport",
+		Description: "Sends the requests for the data of every pending source of a pending or running export to their applications again, to recover from messages lost by the consumers." + audited,
+		Tags:        []string{"internal"},
+		Responses: map[string]openapi.Response{
+			"202": response("The pending sources were sent to the producer", adminExport),
+			"404": response("The export does not exist", errorBody),
+			"409": response("The export is not pending nor running, has no pending source, or was created before the identity of its requester was recorded", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/orgs/{orgID}/storage", openapi.Operation{
+		OperationID: "adminGetOrgStorage",
+		Description: "Returns the storage used by the exports of an organization and its quota." + audited,
+		Tags:        []string{"internal"},
+		Responses:   map[string]openapi.Response{"200": response("The storage of the organization", orgStorage)},
+	})
+	b.Handle(http.MethodPut, "/orgs/{orgID}/storage", openapi.Operation{
+		OperationID: "adminSetOrgQuota",
+		Description: "Overrides the storage quota of an organization." + audited,
+		Tags:        []string{"internal"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(OrgQuota{}))},
+		Responses: map[string]openapi.Response{
+			"200": response("The storage of the organization", orgStorage),
+			"400": response("The quota is invalid", errorBody),
+		},
+	})
+	b.Handle(http.MethodPost, "/cleanup", openapi.Operation{
+		OperationID: "adminCleanup",
+		Description: "Deletes the expired exports, and the source objects of the packaged exports, now rather than at the next run of the expired export cleaner. A dry run reports what would be deleted, without deleting it, within the request. Cleanups are serialized by each replica." + audited,
+		Tags:        []string{"internal"},
+		Parameters:  []openapi.Parameter{queryParam("dry_run", "Only report what would be deleted", openapi.Boolean())},
+		Responses: map[string]openapi.Response{
+			"200": response("What the dry run would delete", b.SchemaOf(CleanupReport{})),
+			"202": response("The cleanup started", b.SchemaOf(CleanupStatus{})),
+			"400": response("dry_run is not a boolean", errorBody),
+			"409": response("A cleanup is already running", errorBody),
+		},
+	})
+	b.Handle(http.MethodGet, "/cleanup/status", openapi.Operation{
+		OperationID: "adminCleanupStatus",
+		Description: "Returns whether a cleanup runs, and the report of the last cleanup of the replica." + audited,
+		Tags:        []string{"internal"},
+		Responses:   map[string]openapi.Response{"200": response("The cleanups of the replica", b.SchemaOf(CleanupStatus{}))},
+	})
+	b.Handle(http.MethodGet, "/applications/{application}/policy", openapi.Operation{
+		OperationID: "getApplicationPolicy",
+		Description: "Returns the formats the sources of the application may be exported in, and the size their payloads may have. Larger uploads are rejected and fail their source.",
+		Tags:        []string{"internal"},
+		Responses:   map[string]openapi.Response{"200": response("The effective policy of the application", b.SchemaOf(ApplicationPolicy{}))},
+	})
+	b.Handle(http.MethodGet, "/psks", openapi.Operation{
+		OperationID: "listPSKs",
+		Description: "Lists the accepted psks by id, the last used first, along with their applications and expiry, so that a rotated psk can be removed once it is no longer used." + audited,
+		Tags:        []string{"internal"},
+		Responses:   map[string]openapi.Response{"200": response("The psks", openapi.Array(b.SchemaOf(PSK{})))},
+	})
+	b.Handle(http.MethodGet, "/audit", openapi.Operation{
+		OperationID: "listAuditEvents",
+		Description: "Lists the events of the audit trail, the latest first: the creations, downloads, deletions, cancellations, restorations and retries of the exports, with who took them and their outcome." + audited,
+		Tags:        []string{"internal"},
+		Parameters: []openapi.Parameter{
+			queryParam("export_id", "Only list the events of this export", openapi.String()),
+			queryParam("org_id", "Only list the events of this organization", openapi.String()),
+			queryParam("username", "Only list the events of this user", openapi.String()),
+			queryParam("client", "Only list the events of this psk id or service account", openapi.String()),
+			queryParam("action", "Only list the events of this action", openapi.String("create", "download", "delete", "cancel", "restore", "retry")),
+			queryParam("outcome", "Only list the events with this outcome", openapi.String("success", "denied", "failure")),
+			queryParam("since", "Only list the events that occurred at or after this RFC 3339 date-time", openapi.String()),
+			queryParam("until", "Only list the events that occurred before this RFC 3339 date-time", openapi.String()),
+			queryParam("limit", "The number of events in the page, the default page when 0", limitSchema()),
+			queryParam("offset", "The index of the first event of the page", openapi.Integer(0)),
+		},
+		Responses: map[string]openapi.Response{
+			"200": response("A page of audit events", page(b, b.SchemaOf(AuditEvent{}))),
+			"400": response("The query params are invalid", errorBody),
+		},
+	})
+	// the faults are only routed, and documented, with DEBUG
+	if config.Get().Debug {
+		fault := b.SchemaOf(InjectedFault{})
+		faultsNote := " Only available with DEBUG=true, the faults are kept in the memory of the replica that served the request." + audited
+		b.Handle(http.MethodGet, "/debug/faults", openapi.Operation{
+			OperationID: "debugListFaults",
+			Description: "Lists the injected faults that have not expired." + faultsNote,
+			Tags:        []string{"internal"},
+			Responses:   map[string]openapi.Response{"200": response("The injected faults", openapi.Array(fault))},
+		})
+		b.Handle(http.MethodPost, "/debug/faults", openapi.Operation{
+			OperationID: "debugInjectFault",
+			Description: "Injects a fault until its ttl is over: the announces of an application are delayed or dropped, the next upload of an application gets a 500, or the packaging of an export fails once." + faultsNote,
+			Tags:        []string{"internal"},
+			RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(b.SchemaOf(FaultRequest{}))},
+			Responses: map[string]openapi.Response{
+				"201": response("The injected fault", fault),
+				"400": response("The fault is invalid", errorBody),
+			},
+		})
+		b.Handle(http.MethodDelete, "/debug/faults", openapi.Operation{
+			OperationID: "debugClearFaults",
+			Description: "Removes every injected fault." + faultsNote,
+			Tags:        []string{"internal"},
+			Responses:   map[string]openapi.Response{"204": {Description: "The faults were removed"}},
+		})
+	}
+	return b
+}
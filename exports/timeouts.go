@@ -0,0 +1,83 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package exports
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+// SourceTimeouts fails the sources whose application did not answer in time, so that
+// their exports are packaged with the sources that did rather than hang forever.
+type SourceTimeouts struct {
+	Internal *Internal
+	Interval time.Duration
+}
+
+// Start times out the sources every interval until the context is done.
+func (t *SourceTimeouts) Start(ctx context.Context) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Run(ctx)
+		}
+	}
+}
+
+// Run fails the sources pending for longer than the timeout of their application,
+// and finishes their exports once they have no pending source left. The sources are
+// failed in the db, so that the replicas of the service can run it concurrently.
+func (t *SourceTimeouts) Run(ctx context.Context) {
+	i := t.Internal
+	cfg := i.Cfg
+	db := i.DB.WithContext(ctx)
+
+	timeouts := map[string]time.Duration{}
+	for application := range cfg.ApplicationPolicies {
+		timeouts[application] = cfg.ApplicationSourceTimeout(application)
+	}
+	sources, err := db.TimeOutSources(timeouts, cfg.SourceTimeout)
+	if err != nil {
+		i.Log.Errorw("error timing out the pending sources", "error", err)
+	}
+
+	// the sources are grouped by export, which is finished once
+	var exportUUIDs []uuid.UUID
+	timedOut := map[uuid.UUID][]models.Source{}
+	for _, source := range sources {
+		if _, ok := timedOut[source.ExportPayloadID]; !ok {
+			exportUUIDs = append(exportUUIDs, source.ExportPayloadID)
+		}
+		timedOut[source.ExportPayloadID] = append(timedOut[source.ExportPayloadID], source)
+	}
+
+	for _, exportUUID := range exportUUIDs {
+		logger := i.Log.With(export_logger.ExportIDField(exportUUID.String()))
+		payload, err := db.Get(exportUUID)
+		if err != nil {
+			logger.Errorw("error querying for the export of the timed out sources", "error", err)
+			continue
+		}
+		for _, source := range timedOut[exportUUID] {
+			logger.Infow("source timed out", "application", source.Application, "resource", source.Resource, "source_id", source.ID)
+			source := source
+			notify.PublishStatus(i.Events, notify.StatusSourceFailed, *payload, &source)
+		}
+		if err := i.packageResolved(db, payload); err != nil {
+			logger.Errorw("failed to finish the export of the timed out sources", "error", err)
+		}
+	}
+}
@@ -20,6 +20,10 @@ func initQuery(q url.Values) (result models.QueryParams, err error) {
 	result.Application = q.Get("application")
 	result.Resource = q.Get("resource")
 	result.Status = q.Get("status")
+	if result.Status == v1CompleteWithErrors {
+		// the name of the status in the version 1 of the api
+		result.Status = string(models.CompleteWithErrors)
+	}
 
 	created := q.Get("created_at")
 	expires := q.Get("expires_at")
@@ -40,9 +44,39 @@ func initQuery(q url.Values) (result models.QueryParams, err error) {
 		}
 	}
 
+	if result.CreatedGTE, result.CreatedLTE, err = parseDateRange(q, "created_at"); err != nil {
+		return models.QueryParams{}, err
+	}
+	if result.ExpiresGTE, result.ExpiresLTE, err = parseDateRange(q, "expires_at"); err != nil {
+		return models.QueryParams{}, err
+	}
+
 	return
 }
 
+// parseDateRange parses the `_gte` and `_lte` bounds of the date param, either of which
+// may be omitted. A date without a time includes the whole day in the lte bound.
+func parseDateRange(q url.Values, param string) (gte, lte time.Time, err error) {
+	if value := q.Get(param + "_gte"); value != "" {
+		if gte, err = parseDate(value); err != nil {
+			return gte, lte, fmt.Errorf("'%s' is not a valid date in ISO 8601", value)
+		}
+	}
+	if value := q.Get(param + "_lte"); value != "" {
+		if lte, err = parseDate(value); err != nil {
+			return gte, lte, fmt.Errorf("'%s' is not a valid date in ISO 8601", value)
+		}
+		if len(value) == formatDateLen {
+			// the last microsecond of the day, the precision of the timestamps
+			lte = lte.AddDate(0, 0, 1).Add(-time.Microsecond)
+		}
+	}
+	if !gte.IsZero() && !lte.IsZero() && gte.After(lte) {
+		return gte, lte, fmt.Errorf("invalid range: %s_gte is after %s_lte", param, param)
+	}
+	return gte, lte, nil
+}
+
 func parseDate(str string) (result time.Time, err error) {
 	format := formatDateTime
 
@@ -0,0 +1,206 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package faults injects controlled faults into the service, so that the
+// applications can test how their consumers and upload clients handle them. The
+// faults are only reachable with DEBUG=true, a nil Injector never injects any.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kind is the kind of a fault.
+type Kind string
+
+const (
+	// AnnounceDelay delays the announce messages of the sources of an application
+	AnnounceDelay Kind = "announce_delay"
+	// AnnounceDrop drops the announce messages of the sources of an application
+	AnnounceDrop Kind = "announce_drop"
+	// UploadError fails the next upload of an application with a 500
+	UploadError Kind = "upload_error"
+	// PackagingError fails the packaging of an export once
+	PackagingError Kind = "packaging_error"
+)
+
+// ErrInjected is the error of the injected faults.
+var ErrInjected = errors.New("injected fault")
+
+// ErrDisabled is returned when adding a fault to a nil Injector.
+var ErrDisabled = errors.New("fault injection is disabled")
+
+// maxTTL bounds how long a fault is kept, so that a forgotten fault does not break
+// the environment for good.
+const maxTTL = 24 * time.Hour
+
+// Fault is a fault injected into the service until it expires. The upload and
+// packaging errors are removed once they were injected.
+type Fault struct {
+	ID   uuid.UUID
+	Kind Kind
+	// Application is the application whose announces or uploads are faulted
+	Application string
+	// ExportID is the export whose packaging fails
+	ExportID *uuid.UUID
+	// Delay is how long the announces are delayed
+	Delay     time.Duration
+	ExpiresAt time.Time
+}
+
+// Validate checks that the fault names the target of its kind.
+func (f Fault) Validate() error {
+	switch f.Kind {
+	case AnnounceDelay:
+		if f.Delay <= 0 {
+			return fmt.Errorf("an %s fault requires a positive delay", f.Kind)
+		}
+		fallthrough
+	case AnnounceDrop, UploadError:
+		if f.Application == "" {
+			return fmt.Errorf("an %s fault requires an application", f.Kind)
+		}
+	case PackagingError:
+		if f.ExportID == nil {
+			return fmt.Errorf("a %s fault requires an export id", f.Kind)
+		}
+	default:
+		return fmt.Errorf("'%s' is not a fault kind, the kinds are %s, %s, %s and %s", f.Kind, AnnounceDelay, AnnounceDrop, UploadError, PackagingError)
+	}
+	return nil
+}
+
+// Injector keeps the faults in memory, every replica has its own. A nil Injector
+// never injects any fault.
+type Injector struct {
+	mu     sync.Mutex
+	faults map[uuid.UUID]Fault
+	now    func() time.Time
+}
+
+// NewInjector returns an Injector without faults.
+func NewInjector() *Injector {
+	return &Injector{faults: map[uuid.UUID]Fault{}, now: time.Now}
+}
+
+// Add injects the fault until its ttl is over.
+func (in *Injector) Add(fault Fault, ttl time.Duration) (Fault, error) {
+	if in == nil {
+		return Fault{}, ErrDisabled
+	}
+	if err := fault.Validate(); err != nil {
+		return Fault{}, err
+	}
+	if ttl <= 0 || ttl > maxTTL {
+		return Fault{}, fmt.Errorf("the ttl must be positive and at most %s", maxTTL)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	fault.ID = uuid.New()
+	fault.ExpiresAt = in.now().Add(ttl).UTC()
+	in.faults[fault.ID] = fault
+	return fault, nil
+}
+
+// List returns the faults that have not expired.
+func (in *Injector) List() []Fault {
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.expire()
+
+	faults := make([]Fault, 0, len(in.faults))
+	for _, fault := range in.faults {
+		faults = append(faults, fault)
+	}
+	return faults
+}
+
+// Clear removes every fault.
+func (in *Injector) Clear() {
+	if in == nil {
+		return
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.faults = map[uuid.UUID]Fault{}
+}
+
+// Announce returns whether the announce messages of the application are dropped,
+// or how long they are delayed.
+func (in *Injector) Announce(application string) (drop bool, delay time.Duration) {
+	if in == nil {
+		return false, 0
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.expire()
+
+	for _, fault := range in.faults {
+		if fault.Application != application {
+			continue
+		}
+		switch fault.Kind {
+		case AnnounceDrop:
+			return true, 0
+		case AnnounceDelay:
+			if fault.Delay > delay {
+				delay = fault.Delay
+			}
+		}
+	}
+	return false, delay
+}
+
+// TakeUploadError reports whether the upload of the application fails, the fault is
+// removed once it was injected.
+func (in *Injector) TakeUploadError(application string) bool {
+	return in.take(func(fault Fault) bool {
+		return fault.Kind == UploadError && fault.Application == application
+	})
+}
+
+// TakePackagingError reports whether the packaging of the export fails, the fault is
+// removed once it was injected.
+func (in *Injector) TakePackagingError(exportID uuid.UUID) bool {
+	return in.take(func(fault Fault) bool {
+		return fault.Kind == PackagingError && fault.ExportID != nil && *fault.ExportID == exportID
+	})
+}
+
+func (in *Injector) take(matches func(Fault) bool) bool {
+	if in == nil {
+		return false
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.expire()
+
+	for id, fault := range in.faults {
+		if matches(fault) {
+			delete(in.faults, id)
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes the expired faults, the lock must be held.
+func (in *Injector) expire() {
+	now := in.now()
+	for id, fault := range in.faults {
+		if !now.Before(fault.ExpiresAt) {
+			delete(in.faults, id)
+		}
+	}
+}
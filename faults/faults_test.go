@@ -0,0 +1,99 @@
+package faults_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/faults"
+)
+
+var _ = Describe("The fault injector", func() {
+	var injector *faults.Injector
+
+	BeforeEach(func() {
+		injector = faults.NewInjector()
+	})
+
+	It("never injects faults when it is nil", func() {
+		var disabled *faults.Injector
+		_, err := disabled.Add(faults.Fault{Kind: faults.UploadError, Application: "exampleApp"}, time.Minute)
+		Expect(err).To(MatchError(faults.ErrDisabled))
+
+		drop, delay := disabled.Announce("exampleApp")
+		Expect(drop).To(BeFalse())
+		Expect(delay).To(BeZero())
+		Expect(disabled.TakeUploadError("exampleApp")).To(BeFalse())
+		Expect(disabled.TakePackagingError(uuid.New())).To(BeFalse())
+		Expect(disabled.List()).To(BeEmpty())
+	})
+
+	It("rejects the faults without a target", func() {
+		_, err := injector.Add(faults.Fault{Kind: faults.AnnounceDrop}, time.Minute)
+		Expect(err).To(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: faults.AnnounceDelay, Application: "exampleApp"}, time.Minute)
+		Expect(err).To(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: faults.PackagingError}, time.Minute)
+		Expect(err).To(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: "explode", Application: "exampleApp"}, time.Minute)
+		Expect(err).To(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: faults.UploadError, Application: "exampleApp"}, 0)
+		Expect(err).To(HaveOccurred())
+		Expect(injector.List()).To(BeEmpty())
+	})
+
+	It("delays or drops the announces of an application", func() {
+		_, err := injector.Add(faults.Fault{Kind: faults.AnnounceDelay, Application: "exampleApp", Delay: time.Second}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		drop, delay := injector.Announce("exampleApp")
+		Expect(drop).To(BeFalse())
+		Expect(delay).To(Equal(time.Second))
+		// the announce faults last until they expire
+		_, delay = injector.Announce("exampleApp")
+		Expect(delay).To(Equal(time.Second))
+
+		drop, delay = injector.Announce("otherApp")
+		Expect(drop).To(BeFalse())
+		Expect(delay).To(BeZero())
+
+		_, err = injector.Add(faults.Fault{Kind: faults.AnnounceDrop, Application: "exampleApp"}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+		drop, _ = injector.Announce("exampleApp")
+		Expect(drop).To(BeTrue())
+	})
+
+	It("injects the upload and packaging errors once", func() {
+		exportID := uuid.New()
+		_, err := injector.Add(faults.Fault{Kind: faults.UploadError, Application: "exampleApp"}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = injector.Add(faults.Fault{Kind: faults.PackagingError, ExportID: &exportID}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(injector.List()).To(HaveLen(2))
+
+		Expect(injector.TakeUploadError("otherApp")).To(BeFalse())
+		Expect(injector.TakeUploadError("exampleApp")).To(BeTrue())
+		Expect(injector.TakeUploadError("exampleApp")).To(BeFalse())
+
+		Expect(injector.TakePackagingError(uuid.New())).To(BeFalse())
+		Expect(injector.TakePackagingError(exportID)).To(BeTrue())
+		Expect(injector.TakePackagingError(exportID)).To(BeFalse())
+		Expect(injector.List()).To(BeEmpty())
+	})
+
+	It("expires and clears the faults", func() {
+		_, err := injector.Add(faults.Fault{Kind: faults.AnnounceDrop, Application: "exampleApp"}, 10*time.Millisecond)
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(injector.List).Should(BeEmpty())
+		drop, _ := injector.Announce("exampleApp")
+		Expect(drop).To(BeFalse())
+
+		_, err = injector.Add(faults.Fault{Kind: faults.UploadError, Application: "exampleApp"}, time.Minute)
+		Expect(err).ShouldNot(HaveOccurred())
+		injector.Clear()
+		Expect(injector.List()).To(BeEmpty())
+		Expect(injector.TakeUploadError("exampleApp")).To(BeFalse())
+	})
+})
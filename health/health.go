@@ -0,0 +1,140 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The statuses of the dependencies and of the service
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Check reports whether a dependency of the service can be reached.
+type Check func(ctx context.Context) error
+
+// Readiness answers the readiness probe, the pod is ready while every one of its
+// dependencies answers its check within the timeout.
+type Readiness struct {
+	// Checks are the checks of the dependencies, keyed by their name
+	Checks  map[string]Check
+	Timeout time.Duration
+	Log     *zap.SugaredLogger
+}
+
+// DependencyStatus is the outcome of the check of a dependency.
+type DependencyStatus struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ReadinessResponse is the body of the responses of the readiness probe.
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// ServeHTTP runs the checks at once and responds with the status of every
+// dependency, with a 503 when any of them failed or did not answer in time.
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), rd.Timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[string]DependencyStatus, len(rd.Checks))
+	for name, check := range rd.Checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			status := run(ctx, check)
+			mu.Lock()
+			statuses[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	// a check that ignores its context must not hold the probe
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+
+	response := ReadinessResponse{Status: StatusOK, Dependencies: make(map[string]DependencyStatus, len(rd.Checks))}
+	mu.Lock()
+	for name := range rd.Checks {
+		status, ok := statuses[name]
+		if !ok {
+			status = DependencyStatus{Status: StatusUnavailable, Error: "the check timed out", DurationMs: rd.Timeout.Milliseconds()}
+		}
+		response.Dependencies[name] = status
+		if status.Status != StatusOK {
+			response.Status = StatusUnavailable
+		}
+	}
+	mu.Unlock()
+
+	status := http.StatusOK
+	if response.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+		if rd.Log != nil {
+			rd.Log.Warnw("the service is not ready", "dependencies", failed(response.Dependencies))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil && rd.Log != nil {
+		rd.Log.Errorw("failed to encode the readiness response", "error", err)
+	}
+}
+
+// run times the check of a dependency.
+func run(ctx context.Context, check Check) DependencyStatus {
+	start := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		status.Status = StatusUnavailable
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func failed(dependencies map[string]DependencyStatus) []string {
+	var names []string
+	for name, status := range dependencies {
+		if status.Status != StatusOK {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Database checks the connection to the database with a SELECT 1. It goes through
+// the pool rather than gorm, so that the probes are not traced as statements.
+func Database(sqlDB *sql.DB) Check {
+	return func(ctx context.Context) error {
+		var one int
+		return sqlDB.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+	}
+}
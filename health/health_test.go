@@ -0,0 +1,100 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/health"
+)
+
+func ok(ctx context.Context) error {
+	return nil
+}
+
+func probe(readiness *health.Readiness) (int, health.ReadinessResponse) {
+	rr := httptest.NewRecorder()
+	readiness.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var response health.ReadinessResponse
+	Expect(json.Unmarshal(rr.Body.Bytes(), &response)).To(Succeed())
+	Expect(rr.Header().Get("Content-Type")).To(Equal("application/json"))
+	return rr.Code, response
+}
+
+var _ = Describe("The readiness probe", func() {
+	It("is ready when every dependency answers", func() {
+		code, response := probe(&health.Readiness{
+			Checks:  map[string]health.Check{"database": ok, "kafka": ok, "storage": ok},
+			Timeout: time.Second,
+		})
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response.Status).To(Equal(health.StatusOK))
+		Expect(response.Dependencies).To(HaveLen(3))
+		for _, status := range response.Dependencies {
+			Expect(status.Status).To(Equal(health.StatusOK))
+			Expect(status.Error).To(BeEmpty())
+		}
+	})
+
+	It("is unavailable when a dependency fails", func() {
+		code, response := probe(&health.Readiness{
+			Checks: map[string]health.Check{
+				"database": ok,
+				"kafka":    func(ctx context.Context) error { return errors.New("all brokers are down") },
+			},
+			Timeout: time.Second,
+		})
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Status).To(Equal(health.StatusUnavailable))
+		Expect(response.Dependencies["database"].Status).To(Equal(health.StatusOK))
+		Expect(response.Dependencies["kafka"]).To(Equal(health.DependencyStatus{Status: health.StatusUnavailable, Error: "all brokers are down", DurationMs: response.Dependencies["kafka"].DurationMs}))
+	})
+
+	It("does not wait for a dependency past the timeout", func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		start := time.Now()
+		code, response := probe(&health.Readiness{
+			Checks: map[string]health.Check{
+				"storage": ok,
+				// the check ignores the deadline of its context
+				"database": func(ctx context.Context) error {
+					<-release
+					return nil
+				},
+			},
+			Timeout: 50 * time.Millisecond,
+		})
+
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Dependencies["storage"].Status).To(Equal(health.StatusOK))
+		Expect(response.Dependencies["database"].Status).To(Equal(health.StatusUnavailable))
+		Expect(response.Dependencies["database"].Error).To(Equal("the check timed out"))
+	})
+
+	It("passes the timeout to the checks", func() {
+		code, response := probe(&health.Readiness{
+			Checks: map[string]health.Check{
+				"kafka": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			Timeout: 10 * time.Millisecond,
+		})
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Dependencies["kafka"].Status).To(Equal(health.StatusUnavailable))
+	})
+})
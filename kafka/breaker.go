@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/tracing"
+)
+
+// ErrCircuitOpen is returned for the messages refused while the circuit breaker is
+// open, rather than waiting for a broker that is down.
+var ErrCircuitOpen = errors.New("the kafka circuit breaker is open")
+
+// The states of the circuit breaker, as reported by its gauge.
+const (
+	BreakerClosed   = 0
+	BreakerOpen     = 1
+	BreakerHalfOpen = 2
+)
+
+// CircuitBreaker opens once the deliveries failed Threshold times in a row, the new
+// messages then fail fast. Once the Cooldown passed the breaker is half open: the
+// messages are produced again, the first delivery closes the breaker and the first
+// failure opens it again. A nil breaker is always closed.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker returns the configured breaker, nil when it is disabled.
+func NewCircuitBreaker(cfg *config.ExportConfig) *CircuitBreaker {
+	if cfg.KafkaConfig.BreakerThreshold <= 0 {
+		return nil
+	}
+	breakerState.Set(BreakerClosed)
+	return &CircuitBreaker{Threshold: cfg.KafkaConfig.BreakerThreshold, Cooldown: cfg.KafkaConfig.BreakerCooldown}
+}
+
+// Allow returns ErrCircuitOpen while the breaker is open.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.Cooldown {
+			breakerRejected.Inc()
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	return nil
+}
+
+// Success records a delivery, it closes the breaker.
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != BreakerClosed {
+		log.Infow("closed the kafka circuit breaker, the messages are delivered again")
+		b.setState(BreakerClosed)
+	}
+}
+
+// Failure records a failed delivery, the breaker opens once the deliveries failed
+// Threshold times in a row or a half open breaker failed again.
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.Threshold) {
+		log.Errorw("opened the kafka circuit breaker, the new messages fail fast", "failures", b.failures, "cooldown", b.Cooldown)
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// State returns the state of the breaker.
+func (b *CircuitBreaker) State() int {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) setState(state int) {
+	b.state = state
+	breakerState.Set(float64(state))
+}
+
+// Send sends the message to the producer unless the breaker is open, waiting until
+// the context is done. The message carries the trace context of a producer span,
+// which ends once the message is delivered or given up on.
+func Send(ctx context.Context, breaker *CircuitBreaker, msgChan chan<- *kafka.Message, msg *kafka.Message) error {
+	topic := topicOf(msg)
+	ctx, span := tracing.Tracer().Start(ctx, topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingKafkaMessageKeyKey.String(string(msg.Key)),
+		),
+	)
+	InjectTraceContext(ctx, msg)
+
+	if err := breaker.Allow(); err != nil {
+		tracing.End(span, err)
+		return err
+	}
+	if msg.Opaque == nil {
+		msg.Opaque = &delivery{span: span}
+	}
+	select {
+	case msgChan <- msg:
+		return nil
+	case <-ctx.Done():
+		tracing.End(span, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// RetryBackoff returns how long a message is delayed before it is produced again
+// after it failed the attempts, doubling with every attempt up to the max backoff.
+func RetryBackoff(cfg *config.ExportConfig, attempts int) time.Duration {
+	backoff, max := cfg.KafkaConfig.RetryBackoff, cfg.KafkaConfig.RetryMaxBackoff
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if max > 0 && backoff > max {
+		return max
+	}
+	return backoff
+}
+
+var (
+	breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "export_service_kafka_circuit_breaker_state",
+		Help: "State of the kafka circuit breaker: 0 closed, 1 open, 2 half open",
+	})
+	breakerRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "export_service_kafka_circuit_breaker_rejected",
+		Help: "Number of messages that failed fast because the kafka circuit breaker was open",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(breakerRejected)
+}
@@ -0,0 +1,83 @@
+package kafka_test
+
+import (
+	"context"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+var _ = Describe("The kafka circuit breaker", func() {
+	It("opens once the deliveries failed the threshold in a row", func() {
+		breaker := &kafka.CircuitBreaker{Threshold: 3, Cooldown: time.Hour}
+
+		breaker.Failure()
+		breaker.Failure()
+		breaker.Success()
+		breaker.Failure()
+		breaker.Failure()
+		Expect(breaker.Allow()).To(Succeed())
+		Expect(breaker.State()).To(Equal(kafka.BreakerClosed))
+
+		breaker.Failure()
+		Expect(breaker.State()).To(Equal(kafka.BreakerOpen))
+		Expect(breaker.Allow()).To(MatchError(kafka.ErrCircuitOpen))
+	})
+
+	It("lets the messages through again once the cooldown passed", func() {
+		breaker := &kafka.CircuitBreaker{Threshold: 1, Cooldown: time.Millisecond}
+		breaker.Failure()
+		Expect(breaker.State()).To(Equal(kafka.BreakerOpen))
+
+		Eventually(breaker.Allow).Should(Succeed())
+		Expect(breaker.State()).To(Equal(kafka.BreakerHalfOpen))
+
+		// a half open breaker opens again with the first failure
+		breaker.Failure()
+		Expect(breaker.State()).To(Equal(kafka.BreakerOpen))
+
+		Eventually(breaker.Allow).Should(Succeed())
+		breaker.Success()
+		Expect(breaker.State()).To(Equal(kafka.BreakerClosed))
+	})
+
+	It("fails the messages fast while it is open", func() {
+		breaker := &kafka.CircuitBreaker{Threshold: 1, Cooldown: time.Hour}
+		breaker.Failure()
+
+		// nobody reads the channel, the send would wait for the context otherwise
+		msgChan := make(chan *confluent.Message)
+		Expect(kafka.Send(context.Background(), breaker, msgChan, &confluent.Message{})).To(MatchError(kafka.ErrCircuitOpen))
+	})
+
+	It("is always closed when it is disabled", func() {
+		var breaker *kafka.CircuitBreaker
+		breaker.Failure()
+		Expect(breaker.Allow()).To(Succeed())
+
+		msgChan := make(chan *confluent.Message, 1)
+		Expect(kafka.Send(context.Background(), breaker, msgChan, &confluent.Message{})).To(Succeed())
+		Expect(msgChan).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("The retries of the messages", func() {
+	DescribeTable("back off exponentially up to the max backoff",
+		func(attempts int, expected time.Duration) {
+			cfg := *config.Get()
+			cfg.KafkaConfig.RetryBackoff = 100 * time.Millisecond
+			cfg.KafkaConfig.RetryMaxBackoff = time.Second
+			Expect(kafka.RetryBackoff(&cfg, attempts)).To(Equal(expected))
+		},
+		Entry("after the first attempt", 1, 100*time.Millisecond),
+		Entry("after the second attempt", 2, 200*time.Millisecond),
+		Entry("after the fourth attempt", 4, 800*time.Millisecond),
+		Entry("once the max is reached", 5, time.Second),
+		Entry("long after the max is reached", 100, time.Second),
+	)
+})
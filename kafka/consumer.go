@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+var consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "export_service_kafka_consumer_lag",
+	Help: "Number of messages of the partition the consumer had yet to read when it read its last message",
+}, []string{"topic", "partition"})
+
+func init() {
+	prometheus.MustRegister(consumerLag)
+}
+
+// Consumer reads a topic and commits the messages it is done with, it is implemented
+// by *kafka.Consumer.
+type Consumer interface {
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	Close() error
+}
+
+// newConsumer returns a consumer of the topic in the group.
+func newConsumer(groupID, topic string) (Consumer, error) {
+	kcfg, err := ConsumerConfigMap(cfg, groupID)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := kafka.NewConsumer(kcfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		consumer.Close()
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// NewResponsesConsumer returns a consumer of the topic the applications report their
+// sources to, shared by the replicas of the service.
+func NewResponsesConsumer() (Consumer, error) {
+	return newConsumer(cfg.KafkaConfig.GroupID, cfg.KafkaConfig.ResponsesTopic)
+}
+
+// ConsumerConfigMap returns the configuration of a consumer in the group, which
+// shares the brokers and the credentials of the producer. The offsets are committed
+// by the service once it is done with a message.
+func ConsumerConfigMap(cfg *config.ExportConfig, groupID string) (*kafka.ConfigMap, error) {
+	kcfg := &kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(cfg.KafkaConfig.Brokers, ","),
+		"client.id":          cfg.Hostname,
+		"group.id":           groupID,
+		"enable.auto.commit": false,
+		"auto.offset.reset":  "earliest",
+	}
+	if err := setSecurity(kcfg, cfg); err != nil {
+		return nil, err
+	}
+	return kcfg, nil
+}
+
+// watermarks is implemented by *kafka.Consumer, which caches the offsets of the
+// partitions it fetches from.
+type watermarks interface {
+	GetWatermarkOffsets(topic string, partition int32) (low, high int64, err error)
+}
+
+// RecordLag records how many messages of its partition follow the message the
+// consumer read. The consumers that do not know the offsets of their partitions are
+// ignored.
+func RecordLag(consumer Consumer, msg *kafka.Message) {
+	w, ok := consumer.(watermarks)
+	if !ok || msg.TopicPartition.Topic == nil {
+		return
+	}
+	topic, partition := *msg.TopicPartition.Topic, msg.TopicPartition.Partition
+	_, high, err := w.GetWatermarkOffsets(topic, partition)
+	if err != nil || high < 0 {
+		return
+	}
+	lag := high - int64(msg.TopicPartition.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	consumerLag.With(prometheus.Labels{"topic": topic, "partition": strconv.Itoa(int(partition))}).Set(float64(lag))
+}
+
+// IsTimeout reports whether the error is the one of a read that got no message in
+// time.
+func IsTimeout(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut
+}
@@ -0,0 +1,64 @@
+package kafka_test
+
+import (
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+// watermarkConsumer knows the high watermark of its partitions, like the consumers of
+// librdkafka once they fetched from them.
+type watermarkConsumer struct {
+	fakeConsumer
+	high int64
+}
+
+func (c *watermarkConsumer) GetWatermarkOffsets(topic string, partition int32) (low, high int64, err error) {
+	return 0, c.high, nil
+}
+
+// consumerLag returns the lag recorded for the partition, or nil until one is recorded.
+func consumerLag(topic, partition string) *float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	Expect(err).To(BeNil())
+	for _, family := range families {
+		if family.GetName() != "export_service_kafka_consumer_lag" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["topic"] == topic && labels["partition"] == partition {
+				value := metric.GetGauge().GetValue()
+				return &value
+			}
+		}
+	}
+	return nil
+}
+
+var _ = Describe("The consumer lag", func() {
+	message := func(topic string, partition int32, offset int64) *confluent.Message {
+		return &confluent.Message{TopicPartition: confluent.TopicPartition{Topic: &topic, Partition: partition, Offset: confluent.Offset(offset)}}
+	}
+
+	It("counts the messages of the partition after the one read", func() {
+		consumer := &watermarkConsumer{high: 10}
+
+		kafka.RecordLag(consumer, message("platform.export.responses", 3, 6))
+		Expect(consumerLag("platform.export.responses", "3")).To(HaveValue(Equal(3.0)))
+
+		kafka.RecordLag(consumer, message("platform.export.responses", 3, 9))
+		Expect(consumerLag("platform.export.responses", "3")).To(HaveValue(Equal(0.0)))
+	})
+
+	It("is not recorded by the consumers that do not know their partitions", func() {
+		kafka.RecordLag(&fakeConsumer{}, message("platform.export.lagless", 0, 6))
+		Expect(consumerLag("platform.export.lagless", "0")).To(BeNil())
+	})
+})
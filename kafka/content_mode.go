@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// The content modes of the CloudEvents, as defined by their kafka protocol binding.
+const (
+	// ContentModeStructured sends the whole event as the value of the message
+	ContentModeStructured = "structured"
+	// ContentModeBinary sends the attributes of the event as ce_* headers and its
+	// data as the value of the message
+	ContentModeBinary = "binary"
+)
+
+const (
+	// ceHeaderPrefix prefixes the headers of the attributes in binary mode
+	ceHeaderPrefix = "ce_"
+	// contentTypeHeader is the content type of the data in binary mode
+	contentTypeHeader = "content-type"
+)
+
+// ErrNotAnEvent is returned for the messages whose value is not a CloudEvent.
+var ErrNotAnEvent = errors.New("the message is not a structured CloudEvent")
+
+// ToBinaryMode moves the attributes of the structured CloudEvent of the message to
+// ce_* headers, its value becomes the data of the event. The other headers are kept,
+// the messages already in binary mode are left untouched.
+func ToBinaryMode(msg *kafka.Message) error {
+	if isBinaryMode(msg) {
+		return nil
+	}
+
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Value, &event); err != nil || event == nil {
+		return fmt.Errorf("%w: %v", ErrNotAnEvent, err)
+	}
+
+	attributes := make([]string, 0, len(event))
+	for attribute := range event {
+		if attribute != "data" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	sort.Strings(attributes)
+
+	headers := make([]kafka.Header, 0, len(msg.Headers)+len(attributes)+1)
+	headers = append(headers, msg.Headers...)
+	for _, attribute := range attributes {
+		value := []byte(event[attribute])
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			value = []byte(s)
+		}
+		headers = append(headers, kafka.Header{Key: ceHeaderPrefix + attribute, Value: value})
+	}
+	headers = append(headers, kafka.Header{Key: contentTypeHeader, Value: []byte("application/json")})
+
+	msg.Headers = headers
+	msg.Value = event["data"]
+	return nil
+}
+
+// StructuredEvent returns the CloudEvent of the message in structured mode, the
+// events in binary mode are put back together from their headers and value.
+func StructuredEvent(msg *kafka.Message) ([]byte, error) {
+	if !isBinaryMode(msg) {
+		return msg.Value, nil
+	}
+
+	event := map[string]interface{}{}
+	for _, header := range msg.Headers {
+		if strings.HasPrefix(header.Key, ceHeaderPrefix) {
+			event[strings.TrimPrefix(header.Key, ceHeaderPrefix)] = string(header.Value)
+		}
+	}
+	if len(msg.Value) > 0 {
+		event["data"] = json.RawMessage(msg.Value)
+	}
+	return json.Marshal(event)
+}
+
+// isBinaryMode reports whether the message carries a CloudEvent in binary mode, which
+// always has a specversion.
+func isBinaryMode(msg *kafka.Message) bool {
+	for _, header := range msg.Headers {
+		if header.Key == ceHeaderPrefix+"specversion" {
+			return true
+		}
+	}
+	return false
+}
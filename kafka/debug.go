@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// DebugInfo describes the producer configuration and what the brokers report about
+// the cluster. It must never contain credentials.
+type DebugInfo struct {
+	Brokers          []string      `json:"brokers"`
+	Topic            string        `json:"topic"`
+	ClientID         string        `json:"client_id"`
+	SecurityProtocol string        `json:"security_protocol,omitempty"`
+	SASLMechanism    string        `json:"sasl_mechanism,omitempty"`
+	QueueDepth       int           `json:"queue_depth"`
+	Metadata         *MetadataInfo `json:"metadata,omitempty"`
+	Error            string        `json:"error,omitempty"`
+}
+
+// MetadataInfo is the result of a metadata request to the cluster.
+type MetadataInfo struct {
+	Brokers []BrokerInfo `json:"brokers"`
+	Topic   *TopicInfo   `json:"topic,omitempty"`
+	// ApplicationTopics are the dedicated topics of the applications, the missing
+	// ones carry an error
+	ApplicationTopics []TopicInfo `json:"application_topics,omitempty"`
+}
+
+type BrokerInfo struct {
+	ID   int32  `json:"id"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type TopicInfo struct {
+	Name       string `json:"name"`
+	Partitions int    `json:"partitions"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Debug returns the producer configuration along with the result of a metadata
+// request, so connectivity to the brokers can be verified.
+func (p *Producer) Debug(timeoutMs int) DebugInfo {
+	topic := cfg.KafkaConfig.ExportsTopic
+	info := DebugInfo{
+		Brokers:          cfg.KafkaConfig.Brokers,
+		Topic:            topic,
+		ClientID:         cfg.Hostname,
+		SecurityProtocol: cfg.KafkaConfig.SSLConfig.Protocol,
+		SASLMechanism:    cfg.KafkaConfig.SSLConfig.SASLMechanism,
+		QueueDepth:       p.Len(),
+	}
+
+	// request all topics so the broker never auto-creates the announce topic
+	metadata, err := p.GetMetadata(nil, true, timeoutMs)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	info.Metadata = &MetadataInfo{}
+	for _, broker := range metadata.Brokers {
+		info.Metadata.Brokers = append(info.Metadata.Brokers, BrokerInfo{ID: broker.ID, Host: broker.Host, Port: broker.Port})
+	}
+	if t, ok := metadata.Topics[topic]; ok {
+		info.Metadata.Topic = topicInfo(t)
+	} else {
+		info.Error = "topic not found in cluster metadata"
+	}
+
+	var applicationTopics []string
+	for _, name := range cfg.KafkaConfig.ApplicationTopics {
+		if name != "" && name != topic && !contains(applicationTopics, name) {
+			applicationTopics = append(applicationTopics, name)
+		}
+	}
+	sort.Strings(applicationTopics)
+	for _, name := range applicationTopics {
+		t, ok := metadata.Topics[name]
+		if !ok {
+			info.Metadata.ApplicationTopics = append(info.Metadata.ApplicationTopics, TopicInfo{Name: name, Error: "topic not found in cluster metadata"})
+			continue
+		}
+		info.Metadata.ApplicationTopics = append(info.Metadata.ApplicationTopics, *topicInfo(t))
+	}
+	return info
+}
+
+// HealthCheck reports whether the brokers answer a metadata request before the
+// deadline of the context. No topic is requested, so that none is auto-created.
+func (p *Producer) HealthCheck(ctx context.Context) error {
+	timeoutMs := metadataTimeoutMs
+	if deadline, ok := ctx.Deadline(); ok {
+		timeoutMs = int(time.Until(deadline).Milliseconds())
+	}
+	if timeoutMs <= 0 {
+		return context.DeadlineExceeded
+	}
+	metadata, err := p.GetMetadata(nil, false, timeoutMs)
+	if err != nil {
+		return err
+	}
+	if len(metadata.Brokers) == 0 {
+		return errors.New("no broker in cluster metadata")
+	}
+	return nil
+}
+
+func topicInfo(t kafka.TopicMetadata) *TopicInfo {
+	info := &TopicInfo{Name: t.Topic, Partitions: len(t.Partitions)}
+	if t.Error.Code() != 0 {
+		info.Error = t.Error.Error()
+	}
+	return info
+}
+
+// DebugHandler serves the producer debug information, responding with a 503 when the
+// brokers cannot be reached.
+func (p *Producer) DebugHandler(w http.ResponseWriter, r *http.Request) {
+	info := p.Debug(metadataTimeoutMs)
+	if info.Metadata == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(&info); err != nil {
+		log.Errorw("error while trying to encode", "error", err)
+	}
+}
@@ -0,0 +1,46 @@
+package kafka_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+var _ = Describe("The kafka debug endpoint", func() {
+	It("reports unreachable brokers without exposing credentials", func() {
+		cfg := config.Get()
+		cfg.KafkaConfig.Brokers = []string{"localhost:1"}
+		cfg.KafkaConfig.SSLConfig.Protocol = "SASL_PLAINTEXT"
+		cfg.KafkaConfig.SSLConfig.SASLMechanism = "PLAIN"
+		cfg.KafkaConfig.SSLConfig.Username = "export-user"
+		cfg.KafkaConfig.SSLConfig.Password = "super-secret-password"
+
+		producer, err := kafka.NewProducer()
+		Expect(err).To(BeNil())
+		defer producer.Close()
+
+		info := producer.Debug(100)
+		Expect(info.Brokers).To(Equal([]string{"localhost:1"}))
+		Expect(info.Metadata).To(BeNil())
+		Expect(info.Error).ShouldNot(BeEmpty())
+
+		req, err := http.NewRequest("GET", "/app/export/v1/debug/kafka", nil)
+		Expect(err).To(BeNil())
+		rr := httptest.NewRecorder()
+		producer.DebugHandler(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rr.Body.String()).ShouldNot(ContainSubstring("super-secret-password"))
+		Expect(rr.Body.String()).ShouldNot(ContainSubstring("export-user"))
+
+		var body kafka.DebugInfo
+		Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.SASLMechanism).To(Equal("PLAIN"))
+	})
+})
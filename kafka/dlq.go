@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// The headers describing why a message was sent to the dead letter topic, they are
+// removed again when the message is redriven.
+const (
+	DLQOriginalTopicHeader = "x-export-dlq-original-topic"
+	DLQErrorHeader         = "x-export-dlq-error"
+	DLQAttemptsHeader      = "x-export-dlq-attempts"
+	DLQFailedAtHeader      = "x-export-dlq-failed-at"
+)
+
+const (
+	defaultRedriveMessages = 100
+	maxRedriveMessages     = 1000
+	// dlqReadTimeout is how long a redrive waits for the next message, the first read
+	// includes joining the consumer group
+	dlqReadTimeout = 10 * time.Second
+)
+
+// NewDeadLetterMessage returns the message to send to the dead letter topic for a
+// message that could not be delivered. It keeps the key, value and headers of the
+// message along with the failure.
+func NewDeadLetterMessage(msg *kafka.Message, topic string, deliveryError error, attempts int, failedAt time.Time) *kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+4)
+	headers = append(headers, msg.Headers...)
+	errorText := ""
+	if deliveryError != nil {
+		errorText = deliveryError.Error()
+	}
+	headers = append(headers,
+		kafka.Header{Key: DLQOriginalTopicHeader, Value: []byte(topicOf(msg))},
+		kafka.Header{Key: DLQErrorHeader, Value: []byte(errorText)},
+		kafka.Header{Key: DLQAttemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: DLQFailedAtHeader, Value: []byte(failedAt.UTC().Format(time.RFC3339))},
+	)
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}
+}
+
+// RestoreDeadLetter returns the message to send again for a message of the dead letter
+// topic, to its original topic and without the headers of the failure.
+func RestoreDeadLetter(msg *kafka.Message) *kafka.Message {
+	topic := cfg.KafkaConfig.ExportsTopic
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for _, header := range msg.Headers {
+		if header.Key == DLQOriginalTopicHeader && len(header.Value) > 0 {
+			topic = string(header.Value)
+		}
+		if strings.HasPrefix(header.Key, "x-export-dlq-") {
+			continue
+		}
+		headers = append(headers, header)
+	}
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}
+}
+
+// NewDLQConsumer returns a consumer of the dead letter topic. Its offsets are only
+// committed once a message was handed to the producer again.
+func NewDLQConsumer() (Consumer, error) {
+	return newConsumer(cfg.KafkaConfig.GroupID+".dlq", cfg.KafkaConfig.DLQTopic)
+}
+
+// Redrive sends up to max messages of the dead letter topic back to their original
+// topic, stopping early once no message arrives within wait. It returns how many
+// messages were redriven, each of them is committed once it was handed to the
+// producer.
+func Redrive(ctx context.Context, consumer Consumer, msgChan chan<- *kafka.Message, max int, wait time.Duration) (int, error) {
+	redriven := 0
+	for redriven < max {
+		msg, err := consumer.ReadMessage(wait)
+		if err != nil {
+			if IsTimeout(err) {
+				return redriven, nil
+			}
+			return redriven, fmt.Errorf("failed to read the dead letter topic: %w", err)
+		}
+		RecordLag(consumer, msg)
+
+		select {
+		case msgChan <- RestoreDeadLetter(msg):
+		case <-ctx.Done():
+			return redriven, ctx.Err()
+		}
+		if _, err := consumer.CommitMessage(msg); err != nil {
+			// the message is redriven again by the next call
+			return redriven, fmt.Errorf("failed to commit the redriven message: %w", err)
+		}
+		redriven++
+	}
+	return redriven, nil
+}
+
+// RedriveResult is the result of a redrive of the dead letter topic.
+type RedriveResult struct {
+	Redriven int    `json:"redriven"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RedriveHandler sends the messages of the dead letter topic back to the producer, up
+// to the `max` query parameter. It responds with a 503 when the topic can not be read,
+// along with the number of messages redriven before the error.
+func RedriveHandler(newConsumer func() (Consumer, error), msgChan chan<- *kafka.Message) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		max := defaultRedriveMessages
+		if value := r.URL.Query().Get("max"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxRedriveMessages {
+				w.WriteHeader(http.StatusBadRequest)
+				writeRedriveResult(w, RedriveResult{Error: fmt.Sprintf("max must be between 1 and %d", maxRedriveMessages)})
+				return
+			}
+			max = parsed
+		}
+
+		consumer, err := newConsumer()
+		if err != nil {
+			log.Errorw("failed to create the dead letter consumer", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeRedriveResult(w, RedriveResult{Error: err.Error()})
+			return
+		}
+		defer func() {
+			if err := consumer.Close(); err != nil {
+				log.Errorw("failed to close the dead letter consumer", "error", err)
+			}
+		}()
+
+		result := RedriveResult{}
+		result.Redriven, err = Redrive(r.Context(), consumer, msgChan, max, dlqReadTimeout)
+		log.Infow("redrove the dead letter topic", "redriven", result.Redriven)
+		if err != nil {
+			log.Errorw("failed to redrive the dead letter topic", "error", err)
+			result.Error = err.Error()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeRedriveResult(w, result)
+	}
+}
+
+func writeRedriveResult(w http.ResponseWriter, result RedriveResult) {
+	if err := json.NewEncoder(w).Encode(&result); err != nil {
+		log.Errorw("error while trying to encode", "error", err)
+	}
+}
@@ -0,0 +1,142 @@
+package kafka_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+// fakeConsumer serves its messages, then times out like an idle topic.
+type fakeConsumer struct {
+	messages  []*confluent.Message
+	readErr   error
+	committed []*confluent.Message
+	closed    bool
+}
+
+func (c *fakeConsumer) ReadMessage(timeout time.Duration) (*confluent.Message, error) {
+	if len(c.messages) == 0 {
+		if c.readErr != nil {
+			return nil, c.readErr
+		}
+		return nil, confluent.NewError(confluent.ErrTimedOut, "timed out", false)
+	}
+	msg := c.messages[0]
+	c.messages = c.messages[1:]
+	return msg, nil
+}
+
+func (c *fakeConsumer) CommitMessage(msg *confluent.Message) ([]confluent.TopicPartition, error) {
+	c.committed = append(c.committed, msg)
+	return nil, nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func headerValue(msg *confluent.Message, key string) string {
+	for _, header := range msg.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func deadLetter(value string) *confluent.Message {
+	topic := "platform.export.requests"
+	msg := &confluent.Message{
+		TopicPartition: confluent.TopicPartition{Topic: &topic, Partition: 3},
+		Key:            []byte("export-id"),
+		Value:          []byte(value),
+		Headers:        []confluent.Header{{Key: "application", Value: []byte("exampleApp")}},
+	}
+	return kafka.NewDeadLetterMessage(msg, "platform.export.requests.dlq", errors.New("broker down"), 5, time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+}
+
+var _ = Describe("The dead letter topic", func() {
+	It("keeps the message along with its failure", func() {
+		msg := deadLetter(`{"id":"1"}`)
+
+		Expect(*msg.TopicPartition.Topic).To(Equal("platform.export.requests.dlq"))
+		Expect(msg.TopicPartition.Partition).To(Equal(confluent.PartitionAny))
+		Expect(msg.Key).To(Equal([]byte("export-id")))
+		Expect(msg.Value).To(Equal([]byte(`{"id":"1"}`)))
+		Expect(headerValue(msg, "application")).To(Equal("exampleApp"))
+		Expect(headerValue(msg, kafka.DLQOriginalTopicHeader)).To(Equal("platform.export.requests"))
+		Expect(headerValue(msg, kafka.DLQErrorHeader)).To(Equal("broker down"))
+		Expect(headerValue(msg, kafka.DLQAttemptsHeader)).To(Equal("5"))
+		Expect(headerValue(msg, kafka.DLQFailedAtHeader)).To(Equal("2022-06-01T12:00:00Z"))
+	})
+
+	It("redrives the messages to their original topic until the topic is drained", func() {
+		consumer := &fakeConsumer{messages: []*confluent.Message{deadLetter("1"), deadLetter("2")}}
+		msgChan := make(chan *confluent.Message, 2)
+
+		redriven, err := kafka.Redrive(context.Background(), consumer, msgChan, 10, time.Millisecond)
+		Expect(err).To(BeNil())
+		Expect(redriven).To(Equal(2))
+		Expect(consumer.committed).To(HaveLen(2))
+
+		msg := <-msgChan
+		Expect(*msg.TopicPartition.Topic).To(Equal("platform.export.requests"))
+		Expect(msg.Value).To(Equal([]byte("1")))
+		Expect(msg.Key).To(Equal([]byte("export-id")))
+		Expect(msg.Headers).To(Equal([]confluent.Header{{Key: "application", Value: []byte("exampleApp")}}))
+	})
+
+	It("redrives at most the requested messages", func() {
+		consumer := &fakeConsumer{messages: []*confluent.Message{deadLetter("1"), deadLetter("2")}}
+		msgChan := make(chan *confluent.Message, 2)
+
+		redriven, err := kafka.Redrive(context.Background(), consumer, msgChan, 1, time.Millisecond)
+		Expect(err).To(BeNil())
+		Expect(redriven).To(Equal(1))
+		Expect(consumer.messages).To(HaveLen(1))
+	})
+
+	It("reports the errors reading the topic with the messages redriven before", func() {
+		consumer := &fakeConsumer{
+			messages: []*confluent.Message{deadLetter("1")},
+			readErr:  confluent.NewError(confluent.ErrAllBrokersDown, "all brokers down", false),
+		}
+		msgChan := make(chan *confluent.Message, 1)
+
+		handler := kafka.RedriveHandler(func() (kafka.Consumer, error) { return consumer, nil }, msgChan)
+		req, err := http.NewRequest("POST", "/app/export/v1/kafka/dlq/redrive", nil)
+		Expect(err).To(BeNil())
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		var result kafka.RedriveResult
+		Expect(json.Unmarshal(rr.Body.Bytes(), &result)).To(Succeed())
+		Expect(result.Redriven).To(Equal(1))
+		Expect(result.Error).To(ContainSubstring("all brokers down"))
+		Expect(consumer.closed).To(BeTrue())
+	})
+
+	It("rejects an invalid max", func() {
+		handler := kafka.RedriveHandler(func() (kafka.Consumer, error) {
+			Fail("the consumer must not be created")
+			return nil, nil
+		}, nil)
+		req, err := http.NewRequest("POST", "/app/export/v1/kafka/dlq/redrive?max=1001", nil)
+		Expect(err).To(BeNil())
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -1,109 +1,493 @@
 package kafka
 
 import (
+	"context"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/redhatinsights/export-service-go/config"
 	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/reporting"
+	"github.com/redhatinsights/export-service-go/tracing"
 )
 
+const metadataTimeoutMs = 5000
+
 var (
 	cfg = config.Get()
 	log = logger.Get()
 
+	produceAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "export_service_kafka_produce_attempts",
+		Help: "Number of times a message was handed to the producer, including the retries",
+	}, []string{"topic"})
 	messagesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "export_service_kafka_produced",
 		Help: "Number of messages produced to kafka",
 	}, []string{"topic"})
 	messagePublishElapsed = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "export_service_publish_seconds",
-		Help: "Number of seconds spent writing kafka messages",
+		Help: "Number of seconds between producing a kafka message and receiving its delivery report",
 	}, []string{"topic"})
 	publishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "export_service_kafka_produce_failures",
 		Help: "Number of times a message was failed to be produced",
-	}, []string{"topic"})
+	}, []string{"topic", "broker"})
 	producerCount = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "export_service_kafka_producer_go_routine_count",
 		Help: "Number of go routines currently publishing to kafka",
 	})
+	producerQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "export_service_kafka_producer_queue_depth",
+		Help: "Number of messages waiting in the producer queue to be delivered",
+	})
+	retryQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "export_service_kafka_retry_queue_depth",
+		Help: "Number of failed messages waiting to be produced again",
+	})
+	deadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "export_service_kafka_dead_lettered",
+		Help: "Number of messages sent to the dead letter topic after failing to be delivered",
+	}, []string{"topic"})
+	invalidEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "export_service_kafka_invalid_events",
+		Help: "Number of messages that were not published because they do not match the schema of their event",
+	}, []string{"topic"})
 )
 
 func init() {
+	prometheus.MustRegister(produceAttempts)
 	prometheus.MustRegister(messagesPublished)
 	prometheus.MustRegister(messagePublishElapsed)
 	prometheus.MustRegister(publishFailures)
 	prometheus.MustRegister(producerCount)
+	prometheus.MustRegister(producerQueueDepth)
+	prometheus.MustRegister(retryQueueDepth)
+	prometheus.MustRegister(deadLettered)
+	prometheus.MustRegister(invalidEvents)
 }
 
-type Producer struct{ *kafka.Producer }
+// DeliveryHandler is called with the delivery report of every produced message. The
+// error is nil once the message has been delivered.
+type DeliveryHandler func(msg *kafka.Message, err error)
+
+type Producer struct {
+	*kafka.Producer
+	// OnDelivery is called for every delivery report, it may be nil. It must be set
+	// before the producer is started.
+	OnDelivery DeliveryHandler
+	// OnDeadLetter is called with the messages sent to the dead letter topic and the
+	// error of their last attempt, it may be nil. It must be set before the producer
+	// is started.
+	OnDeadLetter DeliveryHandler
+	// Validator validates the events before they are published, it may be nil
+	Validator *SchemaValidator
+	// Breaker is opened by the failed deliveries, it may be nil
+	Breaker *CircuitBreaker
+
+	leaders *leaderCache
+}
+
+// delivery is carried with a message through its attempts.
+type delivery struct {
+	// start is when the message was last produced, to measure the delivery latency
+	start    time.Time
+	attempts int
+	// span is the producer span of the message, it may be nil
+	span trace.Span
+}
+
+// end ends the producer span of the message once it is delivered or given up on.
+func (d *delivery) end(err error) {
+	if d == nil || d.span == nil {
+		return
+	}
+	d.span.SetAttributes(attribute.Int("messaging.kafka.attempts", d.attempts))
+	tracing.End(d.span, err)
+	d.span = nil
+}
 
 // StartProducer produces kafka messages on the kafka topic
 func (p *Producer) StartProducer(msgChan chan *kafka.Message) {
 	log.Infof("started kafka producer: %+v", p)
-	topic := cfg.KafkaConfig.ExportsTopic
+	p.leaders = newLeaderCache()
+	go p.refreshLeaders()
+	go p.handleDeliveryReports(msgChan)
+
 	for msg := range msgChan {
 		go func(msg *kafka.Message) {
 			producerCount.Inc()
 			defer producerCount.Dec()
-			start := time.Now()
 
+			d, ok := msg.Opaque.(*delivery)
+			if !ok {
+				d = &delivery{}
+				msg.Opaque = d
+			}
+			d.start = time.Now()
+			d.attempts++
+
+			topic := topicOf(msg)
+			if p.Validator != nil && topic != cfg.KafkaConfig.DLQTopic {
+				if err := p.Validator.Validate(msg); err != nil {
+					log.Errorw("refused to publish an event that does not match its schema", "topic", topic, "error", err)
+					p.reject(msg, err, d, msgChan)
+					return
+				}
+			}
+			// the dead letters keep the content mode of their original message
+			if cfg.KafkaConfig.ContentMode == ContentModeBinary && topic != cfg.KafkaConfig.DLQTopic {
+				if err := ToBinaryMode(msg); err != nil {
+					log.Errorw("refused to publish a message that is not an event in binary mode", "topic", topic, "error", err)
+					p.reject(msg, err, d, msgChan)
+					return
+				}
+			}
+			produceAttempts.With(prometheus.Labels{"topic": topic}).Inc()
 			if err := p.Produce(msg, nil); err != nil { // pass nil chan so that delivery reports go to the Events() channel
 				log.Errorw("failed to produce message", "error", err)
+				publishFailures.With(prometheus.Labels{"topic": topic, "broker": unknownBroker}).Inc()
+				p.retry(msg, err, d, msgChan)
 				return
 			}
+			producerQueueDepth.Set(float64(p.Len()))
+		}(msg)
+	}
+}
 
-			messagePublishElapsed.With(prometheus.Labels{"topic": topic}).Observe(time.Since(start).Seconds())
-
-			// Delivery report handler for produced messages
-			for e := range p.Events() {
-				switch ev := e.(type) {
-				case *kafka.Message:
-					if ev.TopicPartition.Error != nil {
-						log.Errorw("error publishing to kafka", "error", ev.TopicPartition.Error)
-						msgChan <- msg
-						publishFailures.With(prometheus.Labels{"topic": topic}).Inc()
-					} else {
-						log.Infof("delivered message to %v", ev.TopicPartition)
-						messagesPublished.With(prometheus.Labels{"topic": topic}).Inc()
-					}
-				}
+// reject never publishes the invalid event, it would fail every attempt.
+func (p *Producer) reject(msg *kafka.Message, err error, d *delivery, msgChan chan *kafka.Message) {
+	invalidEvents.With(prometheus.Labels{"topic": topicOf(msg)}).Inc()
+	if p.OnDelivery != nil {
+		p.OnDelivery(msg, err)
+	}
+	if cfg.KafkaConfig.MaxDeliveryAttempts > 0 {
+		p.deadLetter(msg, err, d.attempts, msgChan)
+		return
+	}
+	report(msg, err, d.attempts, d)
+	d.end(err)
+}
+
+// handleDeliveryReports records the delivery report of every produced message and
+// retries the messages that failed.
+func (p *Producer) handleDeliveryReports(msgChan chan *kafka.Message) {
+	// the events are closed with the producer, its metadata can not be requested anymore
+	defer p.leaders.close()
+	for e := range p.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			topic := topicOf(ev)
+			d, _ := ev.Opaque.(*delivery)
+			if d != nil {
+				messagePublishElapsed.With(prometheus.Labels{"topic": topic}).Observe(time.Since(d.start).Seconds())
 			}
-		}(msg)
+
+			// the dead letters are not the messages of the handlers
+			deadLetter := topic == cfg.KafkaConfig.DLQTopic
+			if p.OnDelivery != nil && !deadLetter {
+				p.OnDelivery(ev, ev.TopicPartition.Error)
+			}
+
+			if ev.TopicPartition.Error != nil {
+				log.Errorw("error publishing to kafka", "error", ev.TopicPartition.Error)
+				publishFailures.With(prometheus.Labels{"topic": topic, "broker": p.leaders.leader(ev.TopicPartition)}).Inc()
+				p.retry(ev, ev.TopicPartition.Error, d, msgChan)
+			} else {
+				p.Breaker.Success()
+				d.end(nil)
+				log.Debugw("delivered message",
+					"topic", topic,
+					"partition", ev.TopicPartition.Partition,
+					"offset", ev.TopicPartition.Offset,
+					"key", string(ev.Key),
+				)
+				messagesPublished.With(prometheus.Labels{"topic": topic}).Inc()
+			}
+			producerQueueDepth.Set(float64(p.Len()))
+		}
+	}
+}
+
+// retry sends the message that failed back to the producer once its backoff passed,
+// or to the dead letter topic once it failed MaxDeliveryAttempts times.
+func (p *Producer) retry(msg *kafka.Message, err error, d *delivery, msgChan chan *kafka.Message) {
+	p.Breaker.Failure()
+
+	attempts := 1
+	if d != nil {
+		attempts = d.attempts
+	}
+	maxAttempts := cfg.KafkaConfig.MaxDeliveryAttempts
+	if topicOf(msg) != cfg.KafkaConfig.DLQTopic && maxAttempts > 0 && attempts >= maxAttempts {
+		p.deadLetter(msg, err, attempts, msgChan)
+		return
+	}
+	msg.TopicPartition = kafka.TopicPartition{Topic: msg.TopicPartition.Topic, Partition: kafka.PartitionAny}
+	p.requeue(msg, msgChan, RetryBackoff(cfg, attempts))
+}
+
+// deadLetter sends the message that can not be delivered to the dead letter topic.
+func (p *Producer) deadLetter(msg *kafka.Message, err error, attempts int, msgChan chan *kafka.Message) {
+	topic := topicOf(msg)
+	log.Errorw("sending the undeliverable message to the dead letter topic", "topic", topic, "attempts", attempts, "key", string(msg.Key))
+	deadLettered.With(prometheus.Labels{"topic": topic}).Inc()
+	if p.OnDeadLetter != nil {
+		p.OnDeadLetter(msg, err)
+	}
+	d, _ := msg.Opaque.(*delivery)
+	report(msg, err, attempts, d)
+	d.end(err)
+	p.requeue(NewDeadLetterMessage(msg, cfg.KafkaConfig.DLQTopic, err, attempts, time.Now()), msgChan, 0)
+}
+
+// report sends the error of the message that is given up on to the error reporting,
+// in the trace of the message and with the export it concerns.
+func report(msg *kafka.Message, err error, attempts int, d *delivery) {
+	ctx := context.Background()
+	if d != nil && d.span != nil {
+		ctx = trace.ContextWithSpan(ctx, d.span)
+	}
+	tags := []string{"topic", topicOf(msg), "attempts", strconv.Itoa(attempts)}
+	reporting.Capture(ctx, err, append(tags, eventTags(msg)...)...)
+}
+
+// requeue sends the message back to the producer in the background, after the delay.
+func (p *Producer) requeue(msg *kafka.Message, msgChan chan *kafka.Message, delay time.Duration) {
+	retryQueueDepth.Inc()
+	go func() {
+		defer retryQueueDepth.Dec()
+		time.Sleep(delay)
+		msgChan <- msg
+	}()
+}
+
+const unknownBroker = "unknown"
+
+// leaderCache keeps the address of the broker leading each partition, so that the
+// failed deliveries are labelled without requesting the metadata of the cluster in
+// the loop of the delivery reports.
+type leaderCache struct {
+	mu      sync.RWMutex
+	leaders map[string]map[int32]string
+	// refresh takes the topics whose leaders are requested again in the background
+	refresh chan string
+}
+
+func newLeaderCache() *leaderCache {
+	return &leaderCache{
+		leaders: map[string]map[int32]string{},
+		refresh: make(chan string, 16),
 	}
 }
 
+// leader returns the last known leader of the partition, or "unknown" if it was not
+// requested yet. The failure may come from a new leader, so the leaders of the topic
+// are requested again in the background.
+func (c *leaderCache) leader(tp kafka.TopicPartition) string {
+	if c == nil || tp.Topic == nil {
+		return unknownBroker
+	}
+	select {
+	case c.refresh <- *tp.Topic:
+	default:
+		// a refresh is already pending
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if leader, ok := c.leaders[*tp.Topic][tp.Partition]; ok {
+		return leader
+	}
+	return unknownBroker
+}
+
+func (c *leaderCache) close() {
+	if c != nil {
+		close(c.refresh)
+	}
+}
+
+// refreshLeaders requests the leaders of the partitions of the topics that failed,
+// until the producer is closed.
+func (p *Producer) refreshLeaders() {
+	for topic := range p.leaders.refresh {
+		topic := topic
+		metadata, err := p.GetMetadata(&topic, false, metadataTimeoutMs)
+		if err != nil {
+			log.Debugw("failed to request the leaders of the partitions", "topic", topic, "error", err)
+			continue
+		}
+
+		brokers := map[int32]string{}
+		for _, broker := range metadata.Brokers {
+			brokers[broker.ID] = fmt.Sprintf("%s:%d", broker.Host, broker.Port)
+		}
+		leaders := map[int32]string{}
+		for _, partition := range metadata.Topics[topic].Partitions {
+			if leader, ok := brokers[partition.Leader]; ok {
+				leaders[partition.ID] = leader
+			}
+		}
+
+		p.leaders.mu.Lock()
+		p.leaders.leaders[topic] = leaders
+		p.leaders.mu.Unlock()
+	}
+}
+
+func topicOf(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic != nil {
+		return *msg.TopicPartition.Topic
+	}
+	return cfg.KafkaConfig.ExportsTopic
+}
+
 // NewProducer generates a new kafka producer
 func NewProducer() (*Producer, error) {
-	brokers := strings.Join(cfg.KafkaConfig.Brokers, ",")
+	kcfg, err := ProducerConfigMap(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Infow("kakfa configuration values",
 		"client.id", cfg.Hostname,
-		"bootstrap.servers", brokers,
+		"bootstrap.servers", strings.Join(cfg.KafkaConfig.Brokers, ","),
 		"topic", cfg.KafkaConfig.ExportsTopic,
 		"loglevel", cfg.LogLevel,
 		"debug", cfg.Debug,
+		"acks", effectiveSetting(kcfg, "acks"),
+		"compression.codec", effectiveSetting(kcfg, "compression.codec"),
+		"batch.size", effectiveSetting(kcfg, "batch.size"),
+		"linger.ms", effectiveSetting(kcfg, "linger.ms"),
+		"message.max.bytes", effectiveSetting(kcfg, "message.max.bytes"),
+		"message.key", cfg.KafkaConfig.MessageKey,
+		"content.mode", cfg.KafkaConfig.ContentMode,
 	)
+	if mode := cfg.KafkaConfig.ContentMode; mode != ContentModeStructured && mode != ContentModeBinary {
+		return nil, fmt.Errorf("invalid KAFKA_CONTENT_MODE %q, must be %s or %s", mode, ContentModeStructured, ContentModeBinary)
+	}
+
+	p, err := kafka.NewProducer(kcfg)
+	return &Producer{Producer: p, Breaker: NewCircuitBreaker(cfg)}, err
+}
+
+var (
+	validAcks              = []string{"0", "1", "all", "-1"}
+	validCompressionCodecs = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+)
+
+// ProducerConfigMap returns the configuration of the producer. The tuning settings
+// that are not set keep the defaults of the library, invalid ones are rejected.
+func ProducerConfigMap(cfg *config.ExportConfig) (*kafka.ConfigMap, error) {
 	kcfg := &kafka.ConfigMap{
-		"bootstrap.servers": brokers,
+		"bootstrap.servers": strings.Join(cfg.KafkaConfig.Brokers, ","),
 		"client.id":         cfg.Hostname,
 	}
-	if cfg.KafkaConfig.SSLConfig.SASLMechanism != "" {
-		ssl := cfg.KafkaConfig.SSLConfig
-		kcfg = &kafka.ConfigMap{
-			"bootstrap.servers": brokers,
-			"client.id":         cfg.Hostname,
-			"security.protocol": ssl.Protocol,
-			"sasl.mechanism":    ssl.SASLMechanism,
-			"ssl.ca.location":   ssl.CA,
-			"sasl.username":     ssl.Username,
-			"sasl.password":     ssl.Password,
+	if err := setSecurity(kcfg, cfg); err != nil {
+		return nil, err
+	}
+
+	tuning := cfg.KafkaConfig.ProducerConfig
+	if tuning.RequiredAcks != "" {
+		if !contains(validAcks, tuning.RequiredAcks) {
+			return nil, fmt.Errorf("invalid KAFKA_REQUIRED_ACKS %q, must be one of %s", tuning.RequiredAcks, strings.Join(validAcks, ", "))
 		}
+		(*kcfg)["acks"] = tuning.RequiredAcks
+	}
+	if tuning.CompressionCodec != "" {
+		if !contains(validCompressionCodecs, tuning.CompressionCodec) {
+			return nil, fmt.Errorf("invalid KAFKA_COMPRESSION_CODEC %q, must be one of %s", tuning.CompressionCodec, strings.Join(validCompressionCodecs, ", "))
+		}
+		(*kcfg)["compression.codec"] = tuning.CompressionCodec
+	}
+	if tuning.BatchSize < 0 {
+		return nil, fmt.Errorf("invalid KAFKA_BATCH_SIZE %d, must not be negative", tuning.BatchSize)
+	} else if tuning.BatchSize > 0 {
+		(*kcfg)["batch.size"] = tuning.BatchSize
+	}
+	if tuning.LingerMs > 900000 {
+		return nil, fmt.Errorf("invalid KAFKA_LINGER_MS %d, must be at most 900000", tuning.LingerMs)
+	} else if tuning.LingerMs >= 0 {
+		(*kcfg)["linger.ms"] = tuning.LingerMs
 	}
+	if tuning.MessageMaxBytes < 0 || (tuning.MessageMaxBytes > 0 && tuning.MessageMaxBytes < 1000) {
+		return nil, fmt.Errorf("invalid KAFKA_MESSAGE_MAX_BYTES %d, must be at least 1000", tuning.MessageMaxBytes)
+	} else if tuning.MessageMaxBytes > 0 {
+		(*kcfg)["message.max.bytes"] = tuning.MessageMaxBytes
+	}
+	return kcfg, nil
+}
 
-	p, err := kafka.NewProducer(kcfg)
-	return &Producer{p}, err
+var (
+	validSecurityProtocols = []string{"plaintext", "ssl", "sasl_plaintext", "sasl_ssl"}
+	validSASLMechanisms    = []string{"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"}
+)
+
+// setSecurity sets the protocol and the credentials of the brokers, if any. Without a
+// protocol the brokers are reached with sasl_ssl when a SASL mechanism is set, with
+// ssl when a CA is set, and in plaintext otherwise.
+func setSecurity(kcfg *kafka.ConfigMap, cfg *config.ExportConfig) error {
+	ssl := cfg.KafkaConfig.SSLConfig
+	protocol := strings.ToLower(ssl.Protocol)
+	switch {
+	case protocol != "":
+	case ssl.SASLMechanism != "":
+		protocol = "sasl_ssl"
+	case ssl.CA != "":
+		protocol = "ssl"
+	default:
+		return nil
+	}
+	if !contains(validSecurityProtocols, protocol) {
+		return fmt.Errorf("invalid KAFKA_SECURITY_PROTOCOL %q, must be one of %s", ssl.Protocol, strings.Join(validSecurityProtocols, ", "))
+	}
+	(*kcfg)["security.protocol"] = protocol
+	if ssl.CA != "" {
+		(*kcfg)["ssl.ca.location"] = ssl.CA
+	}
+
+	if !strings.HasPrefix(protocol, "sasl_") {
+		if ssl.SASLMechanism != "" {
+			return fmt.Errorf("KAFKA_SASL_MECHANISM requires a sasl KAFKA_SECURITY_PROTOCOL, got %q", protocol)
+		}
+		return nil
+	}
+	mechanism := strings.ToUpper(ssl.SASLMechanism)
+	if !contains(validSASLMechanisms, mechanism) {
+		return fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q, must be one of %s", ssl.SASLMechanism, strings.Join(validSASLMechanisms, ", "))
+	}
+	if ssl.Username == "" {
+		return fmt.Errorf("KAFKA_SASL_USERNAME is required by the %s protocol", protocol)
+	}
+	(*kcfg)["sasl.mechanism"] = mechanism
+	(*kcfg)["sasl.username"] = ssl.Username
+	(*kcfg)["sasl.password"] = ssl.Password
+	return nil
+}
+
+// effectiveSetting returns the value of the setting, or "default" when the library
+// default applies.
+func effectiveSetting(kcfg *kafka.ConfigMap, key string) interface{} {
+	if value, ok := (*kcfg)[key]; ok {
+		return value
+	}
+	return "default"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,241 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+// the JSON schemas of the events of the service, registered when the registry has no
+// schema for their subject yet
+//
+//go:embed schemas/*.json
+var embeddedSchemas embed.FS
+
+var (
+	// ErrSubjectNotFound is returned when the registry has no schema for a subject.
+	ErrSubjectNotFound = errors.New("subject not found in the schema registry")
+	// ErrInvalidEvent is returned for the events that do not match their schema.
+	ErrInvalidEvent = errors.New("the event does not match its schema")
+)
+
+// SchemaRegistry is a client of a Confluent compatible schema registry.
+type SchemaRegistry struct {
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// NewSchemaRegistry returns the client of the configured schema registry.
+func NewSchemaRegistry(cfg *config.ExportConfig) *SchemaRegistry {
+	registry := cfg.KafkaConfig.SchemaRegistry
+	return &SchemaRegistry{
+		URL:      strings.TrimSuffix(registry.URL, "/"),
+		Username: registry.Username,
+		Password: registry.Password,
+		Client:   &http.Client{Timeout: registry.Timeout},
+	}
+}
+
+// RegisteredSchema is a version of the schema of a subject.
+type RegisteredSchema struct {
+	Subject    string `json:"subject,omitempty"`
+	ID         int    `json:"id"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// registryError is the body of the errors of the registry.
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// errorCodeSubjectNotFound is the error code of the registry for unknown subjects
+const errorCodeSubjectNotFound = 40401
+
+// Latest returns the latest version of the schema of the subject.
+func (r *SchemaRegistry) Latest(ctx context.Context, subject string) (*RegisteredSchema, error) {
+	var schema RegisteredSchema
+	if err := r.do(ctx, http.MethodGet, fmt.Sprintf("/subjects/%s/versions/latest", url.PathEscape(subject)), nil, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Register registers the JSON schema under the subject and returns its id. The
+// registry returns the id of the existing version when the schema is registered
+// already.
+func (r *SchemaRegistry) Register(ctx context.Context, subject, schema string) (int, error) {
+	request := RegisteredSchema{SchemaType: "JSON", Schema: schema}
+	var registered RegisteredSchema
+	if err := r.do(ctx, http.MethodPost, fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject)), &request, &registered); err != nil {
+		return 0, err
+	}
+	return registered.ID, nil
+}
+
+func (r *SchemaRegistry) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.URL+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the schema registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var regErr registryError
+		_ = json.NewDecoder(resp.Body).Decode(&regErr)
+		if regErr.ErrorCode == errorCodeSubjectNotFound {
+			return ErrSubjectNotFound
+		}
+		return fmt.Errorf("the schema registry responded with %d: %s", resp.StatusCode, regErr.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SchemaSubject returns the subject of the schema of the events of the type on the
+// topic, the CloudEvent type is the name of the record.
+func SchemaSubject(topic, eventType string) string {
+	return topic + "-" + eventType
+}
+
+// eventSchemaFile is the embedded schema of a type of event and the topics of the
+// events.
+type eventSchemaFile struct {
+	topics []string
+	file   string
+}
+
+// eventSchemaFiles returns the embedded schema of each type of event of the service.
+// The requests and their cancellations are sent to the topics of the applications too.
+// The expiry warnings only have a schema while they are enabled.
+func eventSchemaFiles(cfg *config.ExportConfig) map[string]eventSchemaFile {
+	requestTopics := []string{cfg.KafkaConfig.ExportsTopic}
+	for _, topic := range cfg.KafkaConfig.ApplicationTopics {
+		if topic != "" && !contains(requestTopics, topic) {
+			requestTopics = append(requestTopics, topic)
+		}
+	}
+	files := map[string]eventSchemaFile{
+		cfg.KafkaConfig.EventType:       {requestTopics, "schemas/export-request.json"},
+		cfg.KafkaConfig.CancelEventType: {requestTopics, "schemas/export-cancel.json"},
+		cfg.KafkaConfig.StatusEventType: {[]string{cfg.KafkaConfig.StatusTopic}, "schemas/export-status.json"},
+	}
+	if warning := cfg.ExpiryWarningConfig; warning.Window > 0 {
+		files[warning.EventType] = eventSchemaFile{[]string{warning.Topic}, "schemas/export-expiring.json"}
+	}
+	return files
+}
+
+// eventSchema is the schema the events of a type are validated against.
+type eventSchema struct {
+	subject string
+	id      int
+	schema  *spec.Schema
+}
+
+// SchemaValidator validates the events against the schemas of their subjects in the
+// registry, so that the events drifting from the schemas are never published.
+type SchemaValidator struct {
+	schemas map[string]eventSchema
+}
+
+// NewSchemaValidator fetches the latest schema of every type of event on each of its
+// topics from the registry. The embedded schema of a type is registered when its
+// subject has none.
+func NewSchemaValidator(ctx context.Context, registry *SchemaRegistry, cfg *config.ExportConfig) (*SchemaValidator, error) {
+	v := &SchemaValidator{schemas: map[string]eventSchema{}}
+	for eventType, file := range eventSchemaFiles(cfg) {
+		for _, topic := range file.topics {
+			if err := v.load(ctx, registry, SchemaSubject(topic, eventType), file.file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// load fetches the latest schema of the subject, registering the embedded file when
+// the subject has none.
+func (v *SchemaValidator) load(ctx context.Context, registry *SchemaRegistry, subject, file string) error {
+	registered, err := registry.Latest(ctx, subject)
+	if errors.Is(err, ErrSubjectNotFound) {
+		embedded, readErr := embeddedSchemas.ReadFile(file)
+		if readErr != nil {
+			return readErr
+		}
+		registered = &RegisteredSchema{Subject: subject, Schema: string(embedded)}
+		registered.ID, err = registry.Register(ctx, subject, registered.Schema)
+		if err == nil {
+			log.Infow("registered the schema of the events", "subject", subject, "id", registered.ID)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the schema of %s: %w", subject, err)
+	}
+
+	var schema spec.Schema
+	if err := json.Unmarshal([]byte(registered.Schema), &schema); err != nil {
+		return fmt.Errorf("the schema of %s is not a JSON schema: %w", subject, err)
+	}
+	v.schemas[subject] = eventSchema{subject: subject, id: registered.ID, schema: &schema}
+	return nil
+}
+
+// Validate validates the CloudEvent of the message against the schema of its type on
+// its topic, in either content mode. The events without a schema are invalid.
+func (v *SchemaValidator) Validate(msg *kafka.Message) error {
+	value, err := StructuredEvent(msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	var event interface{}
+	if err := json.Unmarshal(value, &event); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	eventType := ""
+	if fields, ok := event.(map[string]interface{}); ok {
+		eventType, _ = fields["type"].(string)
+	}
+	schema, ok := v.schemas[SchemaSubject(topicOf(msg), eventType)]
+	if !ok {
+		return fmt.Errorf("%w: no schema for the event type '%s' on %s", ErrInvalidEvent, eventType, topicOf(msg))
+	}
+	if err := validate.AgainstSchema(schema.schema, event, strfmt.Default); err != nil {
+		return fmt.Errorf("%w: schema %d of %s: %v", ErrInvalidEvent, schema.id, schema.subject, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package kafka_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	cloudEventSchema "github.com/RedHatInsights/event-schemas-go/apps/exportservice/v1"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+// fakeRegistry keeps the schemas registered by subject, like a schema registry.
+type fakeRegistry struct {
+	mu      sync.Mutex
+	schemas map[string]string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subject := strings.TrimPrefix(r.URL.Path, "/subjects/")
+	subject = strings.TrimSuffix(strings.TrimSuffix(subject, "/latest"), "/versions")
+	switch r.Method {
+	case http.MethodGet:
+		schema, ok := f.schemas[subject]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			Expect(json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 40401, "message": "Subject not found"})).To(Succeed())
+			return
+		}
+		Expect(json.NewEncoder(w).Encode(kafka.RegisteredSchema{Subject: subject, ID: 7, Version: 1, Schema: schema})).To(Succeed())
+	case http.MethodPost:
+		var registered kafka.RegisteredSchema
+		Expect(json.NewDecoder(r.Body).Decode(&registered)).To(Succeed())
+		Expect(registered.SchemaType).To(Equal("JSON"))
+		f.schemas[subject] = registered.Schema
+		Expect(json.NewEncoder(w).Encode(map[string]int{"id": 1})).To(Succeed())
+	}
+}
+
+var _ = Describe("The schema validation of the events", func() {
+	cfg := config.Get()
+
+	var registry *fakeRegistry
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		registry = &fakeRegistry{schemas: map[string]string{}}
+		server = httptest.NewServer(registry)
+		DeferCleanup(server.Close)
+	})
+
+	request := func(format cloudEventSchema.Format) kafka.KafkaMessage {
+		return kafka.KafkaMessage{
+			ID:          uuid.New(),
+			Source:      cfg.KafkaConfig.EventSource,
+			Subject:     uuid.NewString(),
+			SpecVersion: cfg.KafkaConfig.EventSpecVersion,
+			Type:        cfg.KafkaConfig.EventType,
+			Time:        "2022-06-01T12:00:00Z",
+			OrgID:       "000001",
+			DataSchema:  cfg.KafkaConfig.EventDataSchema,
+			Data: cloudEventSchema.ExportRequestClass{
+				Application: "exampleApp",
+				Format:      format,
+				Resource:    "exampleResource",
+				UUID:        uuid.NewString(),
+				XRhIdentity: "eyJpZGVudGl0eSI6e319",
+			},
+		}
+	}
+
+	validator := func() *kafka.SchemaValidator {
+		v, err := kafka.NewSchemaValidator(context.Background(), &kafka.SchemaRegistry{URL: server.URL, Client: server.Client()}, cfg)
+		Expect(err).To(BeNil())
+		return v
+	}
+
+	It("registers the schemas of the events and validates the events against them", func() {
+		v := validator()
+		Expect(registry.schemas).To(HaveKey(kafka.SchemaSubject(cfg.KafkaConfig.ExportsTopic, cfg.KafkaConfig.EventType)))
+		Expect(registry.schemas).To(HaveKey(kafka.SchemaSubject(cfg.KafkaConfig.ExportsTopic, cfg.KafkaConfig.CancelEventType)))
+		Expect(registry.schemas).To(HaveKey(kafka.SchemaSubject(cfg.KafkaConfig.StatusTopic, cfg.KafkaConfig.StatusEventType)))
+
+		msg, err := request(cloudEventSchema.JSON).ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(Succeed())
+
+		cancel := kafka.KafkaCancelMessage{
+			ID:          uuid.New(),
+			Source:      cfg.KafkaConfig.EventSource,
+			Subject:     uuid.NewString(),
+			SpecVersion: cfg.KafkaConfig.EventSpecVersion,
+			Type:        cfg.KafkaConfig.CancelEventType,
+			Time:        "2022-06-01T12:00:00Z",
+			OrgID:       "000001",
+			Data:        kafka.ExportCancelClass{Application: "exampleApp", Resource: "exampleResource", UUID: uuid.NewString()},
+		}
+		msg, err = cancel.ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(Succeed())
+
+		status := kafka.KafkaStatusMessage{
+			ID:          uuid.New(),
+			Source:      cfg.KafkaConfig.EventSource,
+			Subject:     uuid.NewString(),
+			SpecVersion: cfg.KafkaConfig.EventSpecVersion,
+			Type:        cfg.KafkaConfig.StatusEventType,
+			Time:        "2022-06-01T12:00:00Z",
+			OrgID:       "000001",
+			Data: kafka.ExportStatusClass{
+				Status: "source-failed",
+				Name:   "systems",
+				Format: "json",
+				Source: &kafka.ExportStatusSource{Application: "exampleApp", Resource: "exampleResource", UUID: uuid.NewString(), Error: &kafka.ExportSourceError{Code: 1, Message: "failed"}},
+			},
+		}
+		msg, err = status.ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.StatusTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(Succeed())
+
+		status.Data.Status = "archived"
+		msg, err = status.ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.StatusTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(MatchError(kafka.ErrInvalidEvent))
+
+		// the events in binary mode are validated too
+		msg, err = request(cloudEventSchema.CSV).ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		Expect(kafka.ToBinaryMode(msg)).To(Succeed())
+		Expect(v.Validate(msg)).To(Succeed())
+
+		msg, err = request("xml").ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(MatchError(kafka.ErrInvalidEvent))
+
+		unknown := request(cloudEventSchema.CSV)
+		unknown.Type = "com.example.unknown"
+		msg, err = unknown.ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(MatchError(kafka.ErrInvalidEvent))
+	})
+
+	It("validates the events against the schemas of the registry", func() {
+		// the registry expects a field the service does not send
+		registry.schemas[kafka.SchemaSubject(cfg.KafkaConfig.ExportsTopic, cfg.KafkaConfig.EventType)] = `{"type": "object", "required": ["priority"]}`
+		v := validator()
+
+		msg, err := request(cloudEventSchema.JSON).ToMessage(kafka.KafkaHeader{}, cfg.KafkaConfig.ExportsTopic)
+		Expect(err).To(BeNil())
+		err = v.Validate(msg)
+		Expect(err).To(MatchError(kafka.ErrInvalidEvent))
+		Expect(err.Error()).To(ContainSubstring("priority"))
+	})
+
+	It("validates the requests on the topics of the applications", func() {
+		appCfg := *cfg
+		appCfg.KafkaConfig.ApplicationTopics = map[string]string{"exampleApp": "platform.export.requests.example"}
+		v, err := kafka.NewSchemaValidator(context.Background(), &kafka.SchemaRegistry{URL: server.URL, Client: server.Client()}, &appCfg)
+		Expect(err).To(BeNil())
+		Expect(registry.schemas).To(HaveKey(kafka.SchemaSubject("platform.export.requests.example", cfg.KafkaConfig.EventType)))
+		Expect(registry.schemas).To(HaveKey(kafka.SchemaSubject("platform.export.requests.example", cfg.KafkaConfig.CancelEventType)))
+
+		msg, err := request(cloudEventSchema.JSON).ToMessage(kafka.KafkaHeader{}, "platform.export.requests.example")
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(Succeed())
+
+		// the events of a topic without schemas are invalid
+		msg, err = request(cloudEventSchema.JSON).ToMessage(kafka.KafkaHeader{}, "platform.export.requests.other")
+		Expect(err).To(BeNil())
+		Expect(v.Validate(msg)).To(MatchError(kafka.ErrInvalidEvent))
+	})
+
+	It("fails when the registry can not be reached", func() {
+		server.Close()
+		_, err := kafka.NewSchemaValidator(context.Background(), &kafka.SchemaRegistry{URL: server.URL, Client: server.Client()}, cfg)
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,82 @@
+package kafka_test
+
+import (
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+var _ = Describe("The security of the connections to the brokers", func() {
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		cfg = *config.Get()
+		cfg.KafkaConfig.SSLConfig.Protocol = ""
+		cfg.KafkaConfig.SSLConfig.SASLMechanism = ""
+		cfg.KafkaConfig.SSLConfig.Username = ""
+		cfg.KafkaConfig.SSLConfig.Password = ""
+		cfg.KafkaConfig.SSLConfig.CA = ""
+	})
+
+	setting := func(kcfg *confluent.ConfigMap, key string) interface{} {
+		value, _ := kcfg.Get(key, nil)
+		return value
+	}
+
+	It("connects in plaintext without credentials", func() {
+		kcfg, err := kafka.ProducerConfigMap(&cfg)
+		Expect(err).To(BeNil())
+		Expect(setting(kcfg, "security.protocol")).To(BeNil())
+	})
+
+	It("uses sasl_ssl once a SASL mechanism is set", func() {
+		cfg.KafkaConfig.SSLConfig.SASLMechanism = "scram-sha-512"
+		cfg.KafkaConfig.SSLConfig.Username = "export"
+		cfg.KafkaConfig.SSLConfig.Password = "secret"
+		cfg.KafkaConfig.SSLConfig.CA = "/etc/kafka/ca.crt"
+
+		for _, groupID := range []string{"", "export"} {
+			var kcfg *confluent.ConfigMap
+			var err error
+			if groupID == "" {
+				kcfg, err = kafka.ProducerConfigMap(&cfg)
+			} else {
+				kcfg, err = kafka.ConsumerConfigMap(&cfg, groupID)
+			}
+			Expect(err).To(BeNil())
+			Expect(setting(kcfg, "security.protocol")).To(Equal("sasl_ssl"))
+			Expect(setting(kcfg, "sasl.mechanism")).To(Equal("SCRAM-SHA-512"))
+			Expect(setting(kcfg, "sasl.username")).To(Equal("export"))
+			Expect(setting(kcfg, "sasl.password")).To(Equal("secret"))
+			Expect(setting(kcfg, "ssl.ca.location")).To(Equal("/etc/kafka/ca.crt"))
+		}
+	})
+
+	It("uses ssl with only a CA", func() {
+		cfg.KafkaConfig.SSLConfig.CA = "/etc/kafka/ca.crt"
+
+		kcfg, err := kafka.ProducerConfigMap(&cfg)
+		Expect(err).To(BeNil())
+		Expect(setting(kcfg, "security.protocol")).To(Equal("ssl"))
+		Expect(setting(kcfg, "sasl.mechanism")).To(BeNil())
+	})
+
+	DescribeTable("rejects the invalid settings",
+		func(protocol, mechanism, username string) {
+			cfg.KafkaConfig.SSLConfig.Protocol = protocol
+			cfg.KafkaConfig.SSLConfig.SASLMechanism = mechanism
+			cfg.KafkaConfig.SSLConfig.Username = username
+
+			_, err := kafka.ProducerConfigMap(&cfg)
+			Expect(err).ShouldNot(BeNil())
+		},
+		Entry("an unknown protocol", "tls", "", ""),
+		Entry("an unknown mechanism", "sasl_ssl", "GSSAPI", "export"),
+		Entry("a sasl protocol without a mechanism", "sasl_plaintext", "", "export"),
+		Entry("a sasl protocol without a username", "sasl_ssl", "PLAIN", ""),
+		Entry("a mechanism without a sasl protocol", "ssl", "PLAIN", "export"),
+	)
+})
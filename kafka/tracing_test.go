@@ -0,0 +1,50 @@
+package kafka_test
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+)
+
+var _ = Describe("The trace context of the messages", func() {
+	BeforeEach(func() {
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	It("is carried in the traceparent header", func() {
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}))
+		msg := &kafka.Message{Headers: []kafka.Header{{Key: "application", Value: []byte("exampleApp")}}}
+
+		ekafka.InjectTraceContext(ctx, msg)
+		Expect(msg.Headers).To(ContainElement(kafka.Header{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")}))
+		Expect(msg.Headers).To(ContainElement(kafka.Header{Key: "application", Value: []byte("exampleApp")}))
+
+		extracted := trace.SpanContextFromContext(ekafka.ExtractTraceContext(context.Background(), msg))
+		Expect(extracted.TraceID()).To(Equal(traceID))
+		Expect(extracted.SpanID()).To(Equal(spanID))
+		Expect(extracted.IsRemote()).To(BeTrue())
+	})
+
+	It("replaces the trace context of a message that is produced again", func() {
+		msg := &kafka.Message{Headers: []kafka.Header{{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")}}}
+		traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+		spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+		ekafka.InjectTraceContext(ctx, msg)
+		Expect(msg.Headers).To(Equal([]kafka.Header{{Key: "traceparent", Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")}}))
+	})
+})
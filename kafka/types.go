@@ -28,6 +28,9 @@ func (kh KafkaHeader) ToHeader() []kafka.Header {
 	return result
 }
 
+// KafkaMessage is the CloudEvent requesting the data of a source from its application.
+// The requestid extension is the X-Request-Id of the request that created the export,
+// like in the other events of the export.
 type KafkaMessage struct {
 	ID          uuid.UUID                           `json:"id"`
 	Source      string                              `json:"source"`
@@ -36,10 +39,134 @@ type KafkaMessage struct {
 	Type        string                              `json:"type"`
 	Time        string                              `json:"time"`
 	OrgID       string                              `json:"redhatorgid"`
+	RequestID   string                              `json:"requestid,omitempty"`
 	DataSchema  string                              `json:"dataschema"`
 	Data        cloudEventSchema.ExportRequestClass `json:"data"`
 }
 
+// KafkaCancelMessage is the CloudEvent telling an application to stop working on a
+// source, as its export was deleted before it finished.
+type KafkaCancelMessage struct {
+	ID          uuid.UUID         `json:"id"`
+	Source      string            `json:"source"`
+	Subject     string            `json:"subject"`
+	SpecVersion string            `json:"specversion"`
+	Type        string            `json:"type"`
+	Time        string            `json:"time"`
+	OrgID       string            `json:"redhatorgid"`
+	RequestID   string            `json:"requestid,omitempty"`
+	Data        ExportCancelClass `json:"data"`
+}
+
+// ExportCancelClass identifies the cancelled source, its uuid is the one of the
+// request for its data.
+type ExportCancelClass struct {
+	Application string `json:"application"`
+	Resource    string `json:"resource"`
+	UUID        string `json:"uuid"`
+}
+
+// The statuses of the responses of the sources.
+const (
+	// ResponseComplete tells that the payload of the source is stored
+	ResponseComplete = "complete"
+	// ResponseError tells that the application failed to export the source
+	ResponseError = "error"
+)
+
+// KafkaResponseMessage is the CloudEvent an application sends to the responses topic
+// instead of calling the internal api, its subject is the id of the export.
+type KafkaResponseMessage struct {
+	ID          string              `json:"id"`
+	Source      string              `json:"source"`
+	Subject     string              `json:"subject"`
+	SpecVersion string              `json:"specversion"`
+	Type        string              `json:"type"`
+	Time        string              `json:"time"`
+	OrgID       string              `json:"redhatorgid"`
+	Data        ExportResponseClass `json:"data"`
+}
+
+// ExportResponseClass is the response of the application of a source. A complete
+// source has stored its payload at the key of its upload in the bucket of the service.
+type ExportResponseClass struct {
+	Application string `json:"application"`
+	Resource    string `json:"resource"`
+	// UUID is the id of the source, the one of the request
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	// Checksum is the hex encoded sha256 of the payload, it is verified when set
+	Checksum    string             `json:"checksum,omitempty"`
+	RecordCount *int64             `json:"record_count,omitempty"`
+	Error       *ExportSourceError `json:"error,omitempty"`
+}
+
+// ExportSourceError is the error of an application that failed to export a source.
+type ExportSourceError struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Retriable *bool  `json:"retriable,omitempty"`
+}
+
+// KafkaStatusMessage is the CloudEvent published on every transition of an export,
+// its subject is the id of the export.
+type KafkaStatusMessage struct {
+	ID          uuid.UUID         `json:"id"`
+	Source      string            `json:"source"`
+	Subject     string            `json:"subject"`
+	SpecVersion string            `json:"specversion"`
+	Type        string            `json:"type"`
+	Time        string            `json:"time"`
+	OrgID       string            `json:"redhatorgid"`
+	RequestID   string            `json:"requestid,omitempty"`
+	Data        ExportStatusClass `json:"data"`
+}
+
+// ExportStatusClass is the transition of the export, the transitions of a single
+// source carry the source.
+type ExportStatusClass struct {
+	// Status is the transition, one of the notify status events
+	Status  string              `json:"status"`
+	Name    string              `json:"name"`
+	Format  string              `json:"format"`
+	Expires string              `json:"expires_at,omitempty"`
+	Source  *ExportStatusSource `json:"source,omitempty"`
+}
+
+// ExportStatusSource is the source of a transition, a failed source carries its
+// error.
+type ExportStatusSource struct {
+	Application string             `json:"application"`
+	Resource    string             `json:"resource"`
+	UUID        string             `json:"uuid"`
+	Error       *ExportSourceError `json:"error,omitempty"`
+}
+
+// KafkaExpiringMessage is the CloudEvent warning the owner of an export that it is
+// about to be deleted, it is sent to the notifications service. Its subject is the id
+// of the export.
+type KafkaExpiringMessage struct {
+	ID          uuid.UUID           `json:"id"`
+	Source      string              `json:"source"`
+	Subject     string              `json:"subject"`
+	SpecVersion string              `json:"specversion"`
+	Type        string              `json:"type"`
+	Time        string              `json:"time"`
+	OrgID       string              `json:"redhatorgid"`
+	RequestID   string              `json:"requestid,omitempty"`
+	Data        ExportExpiringClass `json:"data"`
+}
+
+// ExportExpiringClass is the export about to be deleted and its owner.
+type ExportExpiringClass struct {
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Username string `json:"username"`
+	Expires  string `json:"expires_at"`
+	// DeletesAt is when the cleanup deletes the export
+	DeletesAt string `json:"deletes_at"`
+}
+
 func ParseFormat(s string) (result cloudEventSchema.Format, ok bool) {
 	switch s {
 	case "csv":
@@ -60,10 +187,74 @@ func JsonToMap(jsonData []byte) (map[string]interface{}, error) {
 	return resultMap, nil
 }
 
+// The keys of the messages, the messages sharing a key are delivered to the same
+// partition and consumed in order.
+const (
+	// KeyExport keys the messages with the id of their export
+	KeyExport = "export"
+	// KeyOrg keys the messages with the id of the organization of their export
+	KeyOrg = "org"
+	// KeyNone spreads the messages over the partitions
+	KeyNone = "none"
+)
+
+// Key returns the key of the message for the key setting, unknown settings key the
+// messages with their export.
+func (km KafkaMessage) Key(setting string) []byte {
+	return messageKey(setting, km.Subject, km.OrgID)
+}
+
+// Key returns the key of the message for the key setting, the cancellation of a
+// source shares the key of its request.
+func (km KafkaCancelMessage) Key(setting string) []byte {
+	return messageKey(setting, km.Subject, km.OrgID)
+}
+
+// Key returns the key of the message for the key setting, the events of an export
+// are consumed in order unless they are spread.
+func (km KafkaStatusMessage) Key(setting string) []byte {
+	return messageKey(setting, km.Subject, km.OrgID)
+}
+
+// Key returns the key of the message for the key setting, like the status events.
+func (km KafkaExpiringMessage) Key(setting string) []byte {
+	return messageKey(setting, km.Subject, km.OrgID)
+}
+
+func messageKey(setting, exportID, orgID string) []byte {
+	switch setting {
+	case KeyNone:
+		return nil
+	case KeyOrg:
+		return []byte(orgID)
+	default:
+		return []byte(exportID)
+	}
+}
+
 // ToMessage converts the KafkaMessage struct to a confluent kafka.Message
 // ready to be sent through the kafka producer
 func (km KafkaMessage) ToMessage(header KafkaHeader, topic string) (*kafka.Message, error) {
-	val, err := json.Marshal(km)
+	return toMessage(km, header, topic)
+}
+
+// ToMessage converts the KafkaCancelMessage struct to a confluent kafka.Message.
+func (km KafkaCancelMessage) ToMessage(header KafkaHeader, topic string) (*kafka.Message, error) {
+	return toMessage(km, header, topic)
+}
+
+// ToMessage converts the KafkaStatusMessage struct to a confluent kafka.Message.
+func (km KafkaStatusMessage) ToMessage(header KafkaHeader, topic string) (*kafka.Message, error) {
+	return toMessage(km, header, topic)
+}
+
+// ToMessage converts the KafkaExpiringMessage struct to a confluent kafka.Message.
+func (km KafkaExpiringMessage) ToMessage(header KafkaHeader, topic string) (*kafka.Message, error) {
+	return toMessage(km, header, topic)
+}
+
+func toMessage(event interface{}, header KafkaHeader, topic string) (*kafka.Message, error) {
+	val, err := json.Marshal(event)
 	if err != nil {
 		return nil, err
 	}
@@ -76,3 +267,57 @@ func (km KafkaMessage) ToMessage(header KafkaHeader, topic string) (*kafka.Messa
 		Value: []byte(val),
 	}, nil
 }
+
+// IsSourceRequest reports whether the message requests the data of a source, the
+// other events share the producer.
+func IsSourceRequest(msg *kafka.Message) bool {
+	value, err := StructuredEvent(msg)
+	if err != nil {
+		return false
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(value, &event); err != nil {
+		return false
+	}
+	return event.Type == cfg.KafkaConfig.EventType
+}
+
+// eventTags returns the subject, the org and the request of the CloudEvent of a
+// message, as the tags of the errors reported about it.
+func eventTags(msg *kafka.Message) []string {
+	value, err := StructuredEvent(msg)
+	if err != nil {
+		return nil
+	}
+	var event struct {
+		Subject   string `json:"subject"`
+		OrgID     string `json:"redhatorgid"`
+		RequestID string `json:"requestid"`
+	}
+	if err := json.Unmarshal(value, &event); err != nil {
+		return nil
+	}
+	var tags []string
+	for _, tag := range [][2]string{{"subject", event.Subject}, {"org_id", event.OrgID}, {"request_id", event.RequestID}} {
+		if tag[1] != "" {
+			tags = append(tags, tag[0], tag[1])
+		}
+	}
+	return tags
+}
+
+// MessageSourceID returns the id of the source announced by a message created with
+// ToMessage, in either content mode.
+func MessageSourceID(msg *kafka.Message) (uuid.UUID, error) {
+	value, err := StructuredEvent(msg)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	var km KafkaMessage
+	if err := json.Unmarshal(value, &km); err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(km.Data.UUID)
+}
@@ -0,0 +1,112 @@
+package kafka_test
+
+import (
+	cloudEventSchema "github.com/RedHatInsights/event-schemas-go/apps/exportservice/v1"
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/kafka"
+)
+
+var _ = Describe("Kafka messages", func() {
+	It("carry the id of the announced source", func() {
+		sourceID := uuid.New()
+		km := kafka.KafkaMessage{
+			ID:   uuid.New(),
+			Data: cloudEventSchema.ExportRequestClass{Application: "exampleApp", UUID: sourceID.String()},
+		}
+
+		msg, err := km.ToMessage(kafka.KafkaHeader{Application: "exampleApp"}, "platform.export.requests")
+		Expect(err).To(BeNil())
+
+		id, err := kafka.MessageSourceID(msg)
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal(sourceID))
+	})
+
+	DescribeTable("are keyed by the key setting",
+		func(setting string, expectedKey []byte) {
+			km := kafka.KafkaMessage{Subject: "f2a1c6e4-8a1d-4c0e-9b2a-3a5d2d6b1f00", OrgID: "12345"}
+			Expect(km.Key(setting)).To(Equal(expectedKey))
+		},
+		Entry("with their export", kafka.KeyExport, []byte("f2a1c6e4-8a1d-4c0e-9b2a-3a5d2d6b1f00")),
+		Entry("with their org", kafka.KeyOrg, []byte("12345")),
+		Entry("without a key", kafka.KeyNone, nil),
+		Entry("with their export when the setting is unknown", "", []byte("f2a1c6e4-8a1d-4c0e-9b2a-3a5d2d6b1f00")),
+	)
+
+	It("cancel a source with the key of its request", func() {
+		request := kafka.KafkaMessage{Subject: "f2a1c6e4-8a1d-4c0e-9b2a-3a5d2d6b1f00", OrgID: "12345"}
+		cancel := kafka.KafkaCancelMessage{
+			Subject: request.Subject,
+			OrgID:   request.OrgID,
+			Type:    "com.redhat.console.export-service.cancel",
+			Data:    kafka.ExportCancelClass{Application: "exampleApp", Resource: "systems", UUID: uuid.NewString()},
+		}
+		for _, setting := range []string{kafka.KeyExport, kafka.KeyOrg, kafka.KeyNone} {
+			Expect(cancel.Key(setting)).To(Equal(request.Key(setting)))
+		}
+
+		msg, err := cancel.ToMessage(kafka.KafkaHeader{Application: "exampleApp"}, "platform.export.requests")
+		Expect(err).To(BeNil())
+		Expect(string(msg.Value)).To(ContainSubstring(`"type":"com.redhat.console.export-service.cancel"`))
+		Expect(string(msg.Value)).To(ContainSubstring(`"resource":"systems"`))
+	})
+
+	It("reject messages without a source", func() {
+		_, err := kafka.MessageSourceID(&confluent.Message{Value: []byte("not json")})
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("carry the attributes of their event in headers in binary mode", func() {
+		sourceID := uuid.New()
+		km := kafka.KafkaMessage{
+			ID:          uuid.New(),
+			Source:      "urn:redhat:source:console:app:export-service",
+			Subject:     "f2a1c6e4-8a1d-4c0e-9b2a-3a5d2d6b1f00",
+			SpecVersion: "1.0",
+			Type:        "com.redhat.console.export-service.request",
+			Time:        "2022-06-01T12:00:00Z",
+			OrgID:       "12345",
+			RequestID:   "export-service/8hcF1dR9cX-000042",
+			Data:        cloudEventSchema.ExportRequestClass{Application: "exampleApp", Resource: "systems", UUID: sourceID.String()},
+		}
+		msg, err := km.ToMessage(kafka.KafkaHeader{Application: "exampleApp", IDheader: "eyJpZGVudGl0eSI6e319"}, "platform.export.requests")
+		Expect(err).To(BeNil())
+		structured := msg.Value
+
+		Expect(kafka.ToBinaryMode(msg)).To(Succeed())
+		headers := map[string]string{}
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		Expect(headers).To(HaveKeyWithValue("application", "exampleApp"))
+		Expect(headers).To(HaveKeyWithValue("x-rh-identity", "eyJpZGVudGl0eSI6e319"))
+		Expect(headers).To(HaveKeyWithValue("ce_id", km.ID.String()))
+		Expect(headers).To(HaveKeyWithValue("ce_type", "com.redhat.console.export-service.request"))
+		Expect(headers).To(HaveKeyWithValue("ce_redhatorgid", "12345"))
+		Expect(headers).To(HaveKeyWithValue("ce_requestid", "export-service/8hcF1dR9cX-000042"))
+		Expect(headers).To(HaveKeyWithValue("content-type", "application/json"))
+		Expect(headers).ToNot(HaveKey("ce_data"))
+		Expect(string(msg.Value)).To(ContainSubstring(`"resource":"systems"`))
+		Expect(string(msg.Value)).ToNot(ContainSubstring(`"specversion"`))
+
+		// the messages are read in either mode
+		event, err := kafka.StructuredEvent(msg)
+		Expect(err).To(BeNil())
+		Expect(event).To(MatchJSON(structured))
+		Expect(kafka.IsSourceRequest(msg)).To(BeTrue())
+		id, err := kafka.MessageSourceID(msg)
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal(sourceID))
+
+		// the messages already in binary mode are left untouched
+		length := len(msg.Headers)
+		Expect(kafka.ToBinaryMode(msg)).To(Succeed())
+		Expect(msg.Headers).To(HaveLen(length))
+
+		Expect(kafka.ToBinaryMode(&confluent.Message{Value: []byte("not json")})).To(MatchError(kafka.ErrNotAnEvent))
+	})
+})
@@ -67,6 +67,9 @@ func Get() *zap.SugaredLogger {
 			)
 		}
 
+		// the secrets are masked before they reach the console or cloudwatch
+		core = NewRedactingCore(core)
+
 		logger, err := loggerConfig.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core { return core }))
 		if err != nil {
 			tmpLogger.Info(err.Error())
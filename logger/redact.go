@@ -0,0 +1,95 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package logger
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+// secretFields are the keys of the fields whose values are never written, the keys
+// are compared in lower case with their dashes as underscores.
+var secretFields = map[string]bool{
+	"password":          true,
+	"secret":            true,
+	"secret_key":        true,
+	"secret_access_key": true,
+	"access_key":        true,
+	"access_key_id":     true,
+	"account_key":       true,
+	"psk":               true,
+	"psks":              true,
+	"x_rh_exports_psk":  true,
+	"signing_key":       true,
+	"token":             true,
+	"authorization":     true,
+	"dsn":               true,
+}
+
+// secretSuffixes mark the keys of secrets, e.g. `db_password` or `sasl_password`
+var secretSuffixes = []string{"_password", "_secret", "_secret_key", "_token"}
+
+func isSecret(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	if secretFields[key] {
+		return true
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactingCore masks the values of the secret fields before they reach the core it
+// wraps, whatever the logger they were given to.
+type redactingCore struct {
+	zapcore.Core
+}
+
+// NewRedactingCore wraps the core so that the values of the fields known to be
+// secrets are written as [REDACTED].
+func NewRedactingCore(core zapcore.Core) zapcore.Core {
+	return redactingCore{core}
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns the fields with the secrets masked, the fields of the caller
+// are left untouched.
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	var redacted []zapcore.Field
+	for i, field := range fields {
+		if !isSecret(field.Key) {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]zapcore.Field(nil), fields...)
+		}
+		redacted[i] = zap.String(field.Key, config.Redacted)
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
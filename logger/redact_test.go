@@ -0,0 +1,59 @@
+package logger_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
+)
+
+var _ = Describe("The redacting core", func() {
+	var logs *observer.ObservedLogs
+	var log *zap.SugaredLogger
+
+	BeforeEach(func() {
+		var core zapcore.Core
+		core, logs = observer.New(zapcore.DebugLevel)
+		log = zap.New(logger.NewRedactingCore(core)).Sugar()
+	})
+
+	It("masks the secret fields", func() {
+		log.Infow("connecting", "user", "exports", "password", "db-password", "X-Rh-Exports-Psk", "psk-1", "sasl_password", "sasl-password")
+
+		Expect(logs.All()).To(HaveLen(1))
+		Expect(logs.All()[0].ContextMap()).To(Equal(map[string]interface{}{
+			"user":             "exports",
+			"password":         config.Redacted,
+			"X-Rh-Exports-Psk": config.Redacted,
+			"sasl_password":    config.Redacted,
+		}))
+	})
+
+	It("masks the secret fields of the child loggers", func() {
+		log.With("token", "bearer-token").Desugar().With(zap.String("secret_key", "secret")).Info("uploading")
+
+		Expect(logs.All()[0].ContextMap()).To(Equal(map[string]interface{}{
+			"token":      config.Redacted,
+			"secret_key": config.Redacted,
+		}))
+	})
+
+	It("keeps the fields that only look like secrets", func() {
+		log.Infow("psk is not scoped to the application", "psk_id", "psk-id-1", "key", "export")
+
+		Expect(logs.All()[0].ContextMap()).To(Equal(map[string]interface{}{
+			"psk_id": "psk-id-1",
+			"key":    "export",
+		}))
+	})
+
+	It("respects the level of the core", func() {
+		core, observed := observer.New(zapcore.InfoLevel)
+		zap.New(logger.NewRedactingCore(core)).Debug("dropped", zap.String("password", "db-password"))
+		Expect(observed.All()).To(BeEmpty())
+	})
+})
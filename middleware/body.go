@@ -0,0 +1,73 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BodyTooLargeError is returned when reading a request body beyond its limit.
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("the request body is larger than the limit of %d bytes", e.Limit)
+}
+
+// MaxBodySize is a middleware that limits the size of the request bodies. Requests
+// announcing a larger body are rejected with a 413, reading past the limit of the
+// others fails with a BodyTooLargeError. A limit that is not positive leaves the
+// bodies unbounded.
+func MaxBodySize(limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				JSONError(w, (&BodyTooLargeError{Limit: limit}).Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = MaxBytesReader(r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxBytesReader limits the body to limit bytes, reading past the limit fails with a
+// BodyTooLargeError.
+func MaxBytesReader(body io.ReadCloser, limit int64) io.ReadCloser {
+	return &maxBytesReader{body: body, remaining: limit, limit: limit}
+}
+
+type maxBytesReader struct {
+	body      io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, &BodyTooLargeError{Limit: m.limit}
+	}
+	// read one byte more than allowed to tell a body of exactly the limit from a
+	// larger one
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.body.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n + int(m.remaining), &BodyTooLargeError{Limit: m.limit}
+	}
+	return n, err
+}
+
+func (m *maxBytesReader) Close() error {
+	return m.body.Close()
+}
@@ -0,0 +1,64 @@
+package middleware_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The body size middleware", func() {
+	var body []byte
+	var readErr error
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, readErr = io.ReadAll(r.Body)
+	})
+
+	BeforeEach(func() {
+		body, readErr = nil, nil
+	})
+
+	It("reads the bodies up to the limit", func() {
+		rr := httptest.NewRecorder()
+		middleware.MaxBodySize(5)(handler).ServeHTTP(rr, httptest.NewRequest("POST", "/", strings.NewReader("12345")))
+
+		Expect(readErr).To(BeNil())
+		Expect(string(body)).To(Equal("12345"))
+	})
+
+	It("rejects the requests announcing a larger body", func() {
+		rr := httptest.NewRecorder()
+		middleware.MaxBodySize(5)(handler).ServeHTTP(rr, httptest.NewRequest("POST", "/", strings.NewReader("123456")))
+
+		Expect(rr.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(rr.Body.String()).To(ContainSubstring("larger than the limit of 5 bytes"))
+		Expect(body).To(BeNil())
+	})
+
+	It("fails reading past the limit of the bodies of unknown length", func() {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("123456"))
+		req.ContentLength = -1
+		rr := httptest.NewRecorder()
+		middleware.MaxBodySize(5)(handler).ServeHTTP(rr, req)
+
+		var tooLarge *middleware.BodyTooLargeError
+		Expect(errors.As(readErr, &tooLarge)).To(BeTrue())
+		Expect(tooLarge.Limit).To(Equal(int64(5)))
+		Expect(string(body)).To(Equal("12345"))
+	})
+
+	It("leaves the bodies unbounded when the limit is not positive", func() {
+		rr := httptest.NewRecorder()
+		middleware.MaxBodySize(0)(handler).ServeHTTP(rr, httptest.NewRequest("POST", "/", strings.NewReader("123456")))
+
+		Expect(readErr).To(BeNil())
+		Expect(string(body)).To(Equal("123456"))
+	})
+})
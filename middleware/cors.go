@@ -0,0 +1,138 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+// exposedHeaders are the headers of the responses the browsers let the clients read,
+// on top of the few they always expose.
+var exposedHeaders = []string{
+	"Content-Disposition",
+	"Digest",
+	"ETag",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+}
+
+// CORS is a middleware letting browsers on the allowed origins call the api. It
+// answers the preflight requests of every route itself, before they reach the
+// routes and their authentication.
+type CORS struct {
+	// AllowedOrigins are the origins that may call the api, `*` allows any. CORS is
+	// disabled without allowed origins.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// NewCORS returns the configured CORS middleware.
+func NewCORS(cfg *config.ExportConfig) *CORS {
+	return &CORS{
+		AllowedOrigins:   cfg.CORSConfig.AllowedOrigins,
+		AllowedMethods:   cfg.CORSConfig.AllowedMethods,
+		AllowedHeaders:   cfg.CORSConfig.AllowedHeaders,
+		AllowCredentials: cfg.CORSConfig.AllowCredentials,
+		MaxAge:           cfg.CORSConfig.MaxAge,
+	}
+}
+
+// Handler sets the CORS headers of the responses to the allowed origins. The
+// preflight requests get a 204, or a 403 when their origin or method is not allowed.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// the responses differ between origins, caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+		if !c.originAllowed(origin) {
+			if preflight {
+				JSONError(w, "the origin is not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowedOrigin := origin
+		if c.anyOrigin() && !c.AllowCredentials {
+			allowedOrigin = "*"
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !c.methodAllowed(r.Header.Get("Access-Control-Request-Method")) {
+			JSONError(w, "the method is not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		if len(c.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c *CORS) anyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) originAllowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) methodAllowed(method string) bool {
+	// the simple methods are always allowed by the browsers
+	if method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+	for _, allowed := range c.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
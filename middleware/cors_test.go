@@ -0,0 +1,95 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The CORS middleware", func() {
+	var cors *middleware.CORS
+
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		router := chi.NewRouter()
+		router.Use(cors.Handler)
+		router.Get("/exports/{exportUUID}", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", `attachment; filename="export.tar.gz"`)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(method, "/exports/0b4c0a52-4e51-4b7e-8a4b-95b61d1f2c6f", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	BeforeEach(func() {
+		cors = &middleware.CORS{
+			AllowedOrigins: []string{"https://frontend.example.com"},
+			AllowedMethods: []string{"GET", "POST", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "X-Rh-Identity"},
+			MaxAge:         10 * time.Minute,
+		}
+	})
+
+	It("answers the preflight requests of the allowed origins", func() {
+		rr := serve(http.MethodOptions, "https://frontend.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+		Expect(rr.Code).To(Equal(http.StatusNoContent))
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://frontend.example.com"))
+		Expect(rr.Header().Get("Access-Control-Allow-Methods")).To(Equal("GET, POST, DELETE"))
+		Expect(rr.Header().Get("Access-Control-Allow-Headers")).To(Equal("Content-Type, X-Rh-Identity"))
+		Expect(rr.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+		Expect(rr.Header().Values("Vary")).To(ContainElement("Origin"))
+	})
+
+	It("rejects the preflight requests of other origins and methods", func() {
+		rr := serve(http.MethodOptions, "https://evil.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+
+		rr = serve(http.MethodOptions, "https://frontend.example.com", map[string]string{"Access-Control-Request-Method": "PUT"})
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("exposes the headers of the downloads to the allowed origins", func() {
+		rr := serve(http.MethodGet, "https://frontend.example.com", nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://frontend.example.com"))
+		Expect(rr.Header().Get("Access-Control-Expose-Headers")).To(ContainSubstring("Content-Disposition"))
+		Expect(rr.Header().Get("Access-Control-Expose-Headers")).To(ContainSubstring("ETag"))
+		Expect(rr.Header().Get("Access-Control-Expose-Headers")).To(ContainSubstring("Digest"))
+
+		rr = serve(http.MethodGet, "https://evil.example.com", nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("allows any origin without credentials", func() {
+		cors.AllowedOrigins = []string{"*"}
+		rr := serve(http.MethodGet, "https://frontend.example.com", nil)
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(Equal("*"))
+		Expect(rr.Header().Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+	})
+
+	It("is disabled without allowed origins", func() {
+		cors.AllowedOrigins = nil
+		rr := serve(http.MethodGet, "https://frontend.example.com", nil)
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+
+		rr = serve(http.MethodOptions, "https://frontend.example.com", map[string]string{"Access-Control-Request-Method": "GET"})
+		Expect(rr.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+var (
+	activeDownloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "export_service_active_downloads",
+		Help: "Number of downloads streamed by the service",
+	})
+	queuedDownloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "export_service_queued_downloads",
+		Help: "Number of downloads waiting for one of the streamed downloads to finish",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(activeDownloads, queuedDownloads)
+}
+
+// ErrDownloadsSaturated is returned when no download slot freed up in time.
+var ErrDownloadsSaturated = errors.New("too many downloads in progress")
+
+// DownloadLimiter limits the downloads streamed at once by the pod. A nil limiter is
+// unlimited.
+type DownloadLimiter struct {
+	slots chan struct{}
+	// QueueTimeout is how long Acquire waits for a slot
+	QueueTimeout time.Duration
+}
+
+// NewDownloadLimiter returns the configured download limiter, nil when the downloads
+// are unlimited.
+func NewDownloadLimiter(cfg *config.ExportConfig) *DownloadLimiter {
+	if cfg.DownloadConfig.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &DownloadLimiter{
+		slots:        make(chan struct{}, cfg.DownloadConfig.MaxConcurrent),
+		QueueTimeout: cfg.DownloadConfig.QueueTimeout,
+	}
+}
+
+// TryAcquire takes a slot without waiting. The returned release func must be called
+// once the download is over.
+func (dl *DownloadLimiter) TryAcquire() (func(), bool) {
+	if dl == nil {
+		return func() {}, true
+	}
+	select {
+	case dl.slots <- struct{}{}:
+		return dl.acquired(), true
+	default:
+		return nil, false
+	}
+}
+
+// Acquire waits up to the QueueTimeout for a slot, and returns
+// ErrDownloadsSaturated when none freed up. The returned release func must be called
+// once the download is over.
+func (dl *DownloadLimiter) Acquire(ctx context.Context) (func(), error) {
+	if release, ok := dl.TryAcquire(); ok {
+		return release, nil
+	}
+
+	queuedDownloads.Inc()
+	defer queuedDownloads.Dec()
+
+	timer := time.NewTimer(dl.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case dl.slots <- struct{}{}:
+		return dl.acquired(), nil
+	case <-timer.C:
+		return nil, ErrDownloadsSaturated
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (dl *DownloadLimiter) acquired() func() {
+	activeDownloads.Inc()
+	var released bool
+	return func() {
+		if released {
+			return
+		}
+		released = true
+		activeDownloads.Dec()
+		<-dl.slots
+	}
+}
@@ -0,0 +1,66 @@
+package middleware_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The download limiter", func() {
+	var limiter *middleware.DownloadLimiter
+
+	BeforeEach(func() {
+		cfg := &config.ExportConfig{}
+		cfg.DownloadConfig.MaxConcurrent = 1
+		cfg.DownloadConfig.QueueTimeout = 20 * time.Millisecond
+		limiter = middleware.NewDownloadLimiter(cfg)
+	})
+
+	It("is unlimited without a limit", func() {
+		limiter = middleware.NewDownloadLimiter(&config.ExportConfig{})
+		Expect(limiter).To(BeNil())
+
+		for i := 0; i < 3; i++ {
+			_, ok := limiter.TryAcquire()
+			Expect(ok).To(BeTrue())
+		}
+	})
+
+	It("refuses the downloads once the limit is reached", func() {
+		release, ok := limiter.TryAcquire()
+		Expect(ok).To(BeTrue())
+
+		_, ok = limiter.TryAcquire()
+		Expect(ok).To(BeFalse())
+		_, err := limiter.Acquire(context.Background())
+		Expect(err).To(MatchError(middleware.ErrDownloadsSaturated))
+
+		release()
+		// releasing twice does not free another slot
+		release()
+		release, ok = limiter.TryAcquire()
+		Expect(ok).To(BeTrue())
+		_, ok = limiter.TryAcquire()
+		Expect(ok).To(BeFalse())
+		release()
+	})
+
+	It("queues the downloads until a slot frees up", func() {
+		release, ok := limiter.TryAcquire()
+		Expect(ok).To(BeTrue())
+		limiter.QueueTimeout = time.Second
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}()
+		queued, err := limiter.Acquire(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		queued()
+	})
+})
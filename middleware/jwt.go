@@ -0,0 +1,357 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
+)
+
+// ErrInvalidToken is wrapped by the errors of the tokens that are rejected.
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwtLeeway tolerates the clock skew between the issuer and the service
+const jwtLeeway = time.Minute
+
+// keysetRetryInterval is how often at most the keyset is fetched again for a token
+// signed with an unknown key
+const keysetRetryInterval = time.Minute
+
+// jwtAlgorithms are the signature algorithms of the tokens, the symmetric ones are never
+// accepted.
+var jwtAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	jwt.RegisteredClaims
+	// AuthorizedParty is the client the token was issued to
+	AuthorizedParty string `json:"azp"`
+	ClientID        string `json:"client_id"`
+	// ClientIDCamel is the client id as named in the tokens of the service accounts of
+	// Red Hat SSO
+	ClientIDCamel string `json:"clientId"`
+}
+
+// Client returns the id of the client, or service account, the token was issued to.
+func (c Claims) Client() string {
+	for _, id := range []string{c.AuthorizedParty, c.ClientID, c.ClientIDCamel} {
+		if id != "" {
+			return id
+		}
+	}
+	return c.Subject
+}
+
+// KeySource returns the public key of the issuer with the key id.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWTVerifier verifies the signed JWTs of the service accounts: their signature with
+// the keys of the issuer, their issuer, their audience and their validity.
+type JWTVerifier struct {
+	Issuer string
+	// Audience must be one of the audiences of the tokens, no token is accepted when it
+	// is empty
+	Audience string
+	Keys     KeySource
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+}
+
+// Verify returns the claims of the compact serialized token once it is verified.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	if v.Audience == "" {
+		return nil, fmt.Errorf("%w: no audience is configured", ErrInvalidToken)
+	}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(jwtAlgorithms),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+		jwt.WithTimeFunc(v.Now),
+	)
+
+	var claims Claims
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.Keys.Key(ctx, kid)
+	})
+	if err != nil {
+		// the keyset could not be fetched, the token may be valid
+		if errors.Is(err, jwt.ErrTokenUnverifiable) && !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+// Keyset is the JSON Web Key Set of the issuer, fetched from its url. It is fetched
+// again every Refresh, and when a token is signed with a key it does not know, so
+// that the rotations of the keys of the issuer are followed.
+type Keyset struct {
+	URL     string
+	Refresh time.Duration
+	Client  *http.Client
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetchErr  error
+	// fetching is closed once the fetch in progress is done, it is nil when the keyset
+	// is not being fetched
+	fetching chan struct{}
+}
+
+// NewKeyset returns the keyset of the url.
+func NewKeyset(url string, refresh time.Duration) *Keyset {
+	return &Keyset{URL: url, Refresh: refresh, Client: &http.Client{Timeout: 10 * time.Second}, Now: time.Now}
+}
+
+// Key returns the key with the id, the only key of the set when the id is empty. The
+// keyset is fetched without holding the lock: while it is fetched the keys it already
+// knows keep being returned, only the keys it does not know wait for the fetch.
+func (k *Keyset) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	now := k.Now()
+	_, known := k.find(kid)
+	stale := k.keys == nil || now.Sub(k.fetchedAt) >= k.Refresh
+	if !known && now.Sub(k.fetchedAt) >= keysetRetryInterval {
+		stale = true
+	}
+	switch {
+	case stale && k.fetching == nil:
+		done := make(chan struct{})
+		k.fetching = done
+		k.mu.Unlock()
+		keys, err := k.fetch(ctx)
+		k.mu.Lock()
+		if err != nil {
+			// the keys fetched before keep verifying the tokens until the issuer is back
+			logger.Get().Errorw("failed to fetch the keyset", "url", k.URL, "error", err)
+		} else {
+			k.keys = keys
+		}
+		k.fetchErr = err
+		k.fetchedAt = now
+		k.fetching = nil
+		close(done)
+	case k.fetching != nil && !known:
+		done := k.fetching
+		k.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		k.mu.Lock()
+	}
+	defer k.mu.Unlock()
+
+	if k.keys == nil && k.fetchErr != nil {
+		return nil, k.fetchErr
+	}
+	key, ok := k.find(kid)
+	if !ok {
+		return nil, fmt.Errorf("%w: the key '%s' is not in the keyset of the issuer", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (k *Keyset) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (k *Keyset) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request the keyset: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the keyset responded with a %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to read the keyset: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// the keys of other types do not prevent the others from being used
+			logger.Get().Warnw("skipped a key of the keyset", "kid", jwk.KeyID, "error", err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", jwk.KeyType)
+	}
+}
+
+// InternalAuth authenticates the callers of the internal api with the signed JWTs of
+// their service accounts, sent as a bearer token, or with a psk. The clients of the
+// tokens are scoped to applications like the psks, the clients without applications
+// are forbidden. They are identified by their client id prefixed with `client:`
+// wherever the psks are identified by their id.
+type InternalAuth struct {
+	// JWT verifies the tokens, they are not accepted when it is nil
+	JWT *JWTVerifier
+	// PSKs accepts the psks
+	PSKs bool
+	// ClientApplications scopes the clients of the tokens to their applications
+	ClientApplications map[string][]string
+}
+
+// NewInternalAuth returns the authentication of the internal api of the config.
+func NewInternalAuth(cfg *config.ExportConfig) *InternalAuth {
+	auth := &InternalAuth{
+		PSKs:               cfg.InternalAuthConfig.PSKEnabled,
+		ClientApplications: cfg.InternalAuthConfig.JWTClientApplications,
+	}
+	if cfg.InternalAuthConfig.JWTEnabled {
+		auth.JWT = &JWTVerifier{
+			Issuer:   cfg.InternalAuthConfig.JWTIssuer,
+			Audience: cfg.InternalAuthConfig.JWTAudience,
+			Keys:     NewKeyset(cfg.InternalAuthConfig.JWTKeysetURL, cfg.InternalAuthConfig.JWTKeysetRefresh),
+			Now:      time.Now,
+		}
+	}
+	return auth
+}
+
+// Enforce is a middleware that requires a valid bearer token or x-rh-exports-psk
+// header, as enabled.
+func (a *InternalAuth) Enforce(next http.Handler) http.Handler {
+	enforcePSK := EnforcePSK(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, bearer := bearerToken(r)
+		if !bearer || a.JWT == nil {
+			if !a.PSKs {
+				JSONError(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+			enforcePSK.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.JWT.Verify(r.Context(), token)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				logger.Get().Errorw("failed to verify the bearer token", "error", err)
+				JSONError(w, "unable to verify the bearer token, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			logger.Get().Infow("rejected a bearer token", "error", err)
+			JSONError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		client := claims.Client()
+		applications, ok := a.ClientApplications[client]
+		if !ok || len(applications) == 0 {
+			logger.Get().Infow("rejected a bearer token of a client without applications", "client", client)
+			JSONError(w, fmt.Sprintf("the client '%s' is not allowed any application", client), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), pskIDKey, "client:"+client)
+		ctx = context.WithValue(ctx, pskApplicationKey, applications)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerToken returns the token of the Authorization header of the request.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,280 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	config "github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+func encodeSegment(v interface{}) string {
+	data, err := json.Marshal(v)
+	Expect(err).To(BeNil())
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signRS256 returns the token of the claims signed with the key.
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).To(BeNil())
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+var _ = Describe("Authenticating the internal api with JWTs", func() {
+	var now time.Time
+	var key *rsa.PrivateKey
+	var keys []map[string]string
+	var fetches int
+	var server *httptest.Server
+	var verifier *middleware.JWTVerifier
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://sso.example.com/auth/realms/redhat-external",
+			"aud": []string{"export-service", "other"},
+			"exp": now.Add(5 * time.Minute).Unix(),
+			"sub": "f5a6e8a2",
+			"azp": "exampleApp-service-account",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		keys = []map[string]string{rsaJWK("key-1", &key.PublicKey)}
+		fetches = 0
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			Expect(json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})).To(Succeed())
+		}))
+		DeferCleanup(server.Close)
+
+		keyset := middleware.NewKeyset(server.URL, time.Hour)
+		keyset.Now = func() time.Time { return now }
+		verifier = &middleware.JWTVerifier{
+			Issuer:   "https://sso.example.com/auth/realms/redhat-external",
+			Audience: "export-service",
+			Keys:     keyset,
+			Now:      func() time.Time { return now },
+		}
+	})
+
+	It("verifies the tokens signed by the issuer", func() {
+		verified, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(BeNil())
+		Expect(verified.Client()).To(Equal("exampleApp-service-account"))
+	})
+
+	DescribeTable("rejects the tokens that are not valid for the service",
+		func(overrides map[string]interface{}, message string) {
+			_, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(overrides)))
+			Expect(err).To(MatchError(middleware.ErrInvalidToken))
+			Expect(err.Error()).To(ContainSubstring(message))
+		},
+		Entry("of another issuer", map[string]interface{}{"iss": "https://attacker.example.com"}, "invalid issuer"),
+		Entry("of another audience", map[string]interface{}{"aud": "other"}, "invalid audience"),
+		Entry("that expired", map[string]interface{}{"exp": time.Date(2021, 12, 31, 23, 0, 0, 0, time.UTC).Unix()}, "expired"),
+		Entry("that are not valid yet", map[string]interface{}{"nbf": time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC).Unix()}, "not valid yet"),
+		Entry("that never expire", map[string]interface{}{"exp": nil}, "exp claim is required"),
+	)
+
+	It("rejects the tokens that were tampered with or are not signed", func() {
+		token := signRS256(key, "key-1", claims(nil))
+		parts := strings.Split(token, ".")
+
+		tampered := parts[0] + "." + encodeSegment(claims(map[string]interface{}{"azp": "otherApp-service-account"})) + "." + parts[2]
+		_, err := verifier.Verify(context.Background(), tampered)
+		Expect(err).To(MatchError(ContainSubstring("signature is invalid")))
+
+		unsigned := encodeSegment(map[string]string{"alg": "none"}) + "." + parts[1] + "."
+		_, err = verifier.Verify(context.Background(), unsigned)
+		Expect(err).To(MatchError(ContainSubstring("signing method none is invalid")))
+
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		_, err = verifier.Verify(context.Background(), signRS256(other, "key-1", claims(nil)))
+		Expect(err).To(MatchError(middleware.ErrInvalidToken))
+	})
+
+	It("verifies the tokens signed with ECDSA keys", func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		keys = []map[string]string{{
+			"kty": "EC",
+			"kid": "ec-1",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		}}
+
+		signed := encodeSegment(map[string]string{"alg": "ES256", "kid": "ec-1"}) + "." + encodeSegment(claims(nil))
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		Expect(err).To(BeNil())
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+		_, err = verifier.Verify(context.Background(), signed+"."+base64.RawURLEncoding.EncodeToString(signature))
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects every token when no audience is configured", func() {
+		verifier.Audience = ""
+		_, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(MatchError(middleware.ErrInvalidToken))
+	})
+
+	It("keeps verifying the tokens of the known keys while the keyset is fetched", func() {
+		_, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(BeNil())
+
+		// the issuer hangs on the next fetch
+		release := make(chan struct{})
+		blocked := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(blocked)
+			<-release
+			Expect(json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})).To(Succeed())
+		}))
+		DeferCleanup(slow.Close)
+		verifier.Keys.(*middleware.Keyset).URL = slow.URL
+
+		now = now.Add(time.Hour)
+		refreshed := make(chan error)
+		go func() {
+			defer GinkgoRecover()
+			_, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+			refreshed <- err
+		}()
+		Eventually(blocked).Should(BeClosed())
+
+		_, err = verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(BeNil())
+
+		close(release)
+		Eventually(refreshed).Should(Receive(BeNil()))
+	})
+
+	It("fetches the keyset again for the keys it does not know, at most once a minute", func() {
+		_, err := verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(BeNil())
+		Expect(fetches).To(Equal(1))
+
+		// the issuer rotated its key
+		rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		keys = append(keys, rsaJWK("key-2", &rotated.PublicKey))
+
+		_, err = verifier.Verify(context.Background(), signRS256(rotated, "key-2", claims(nil)))
+		Expect(err).To(MatchError(ContainSubstring("'key-2' is not in the keyset")))
+		Expect(fetches).To(Equal(1))
+
+		now = now.Add(time.Minute)
+		_, err = verifier.Verify(context.Background(), signRS256(rotated, "key-2", claims(nil)))
+		Expect(err).To(BeNil())
+		Expect(fetches).To(Equal(2))
+
+		_, err = verifier.Verify(context.Background(), signRS256(key, "key-1", claims(nil)))
+		Expect(err).To(BeNil())
+		Expect(fetches).To(Equal(2))
+	})
+
+	Describe("middleware", func() {
+		var auth *middleware.InternalAuth
+
+		request := func(header, value string) (*httptest.ResponseRecorder, string, []string) {
+			var client string
+			var applications []string
+			router := chi.NewRouter()
+			router.With(auth.Enforce).Get("/test", func(w http.ResponseWriter, r *http.Request) {
+				client = middleware.GetPSKID(r.Context())
+				applications, _ = middleware.GetPSKApplications(r.Context())
+			})
+			req := httptest.NewRequest("GET", "/test", nil)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr, client, applications
+		}
+
+		BeforeEach(func() {
+			middleware.Cfg = &config.ExportConfig{Psks: []string{"test-psk"}}
+			auth = &middleware.InternalAuth{
+				JWT:                verifier,
+				PSKs:               true,
+				ClientApplications: map[string][]string{"exampleApp-service-account": {"exampleApp"}},
+			}
+		})
+
+		It("scopes the clients of the tokens to their applications", func() {
+			rr, client, applications := request("Authorization", "Bearer "+signRS256(key, "key-1", claims(nil)))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(client).To(Equal("client:exampleApp-service-account"))
+			Expect(applications).To(Equal([]string{"exampleApp"}))
+
+			rr, _, _ = request("Authorization", "Bearer "+signRS256(key, "key-1", claims(map[string]interface{}{"aud": "other"})))
+			Expect(rr.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("forbids the clients that are not scoped to any application", func() {
+			rr, client, _ := request("Authorization", "Bearer "+signRS256(key, "key-1", claims(map[string]interface{}{"azp": "otherApp-service-account"})))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			Expect(client).To(BeEmpty())
+			Expect(rr.Body.String()).To(ContainSubstring("otherApp-service-account"))
+		})
+
+		It("accepts the psks unless they are disabled", func() {
+			rr, client, _ := request("X-Rh-Exports-Psk", "test-psk")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(client).To(Equal(middleware.PSKID("test-psk")))
+
+			auth.PSKs = false
+			rr, _, _ = request("X-Rh-Exports-Psk", "test-psk")
+			Expect(rr.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rr.Body.String()).To(ContainSubstring("missing bearer token"))
+		})
+
+		It("refuses the tokens while the keyset can not be fetched", func() {
+			server.Close()
+			rr, _, _ := request("Authorization", "Bearer "+signRS256(key, "key-1", claims(nil)))
+			Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
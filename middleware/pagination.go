@@ -20,6 +20,9 @@ const (
 	defaultOffset int           = 0
 	defaultSortBy string        = "created_at"
 	defaultDir    string        = "asc"
+	// MaxLimit is the largest page that may be requested, so that the organizations
+	// with many exports can not request all of them at once
+	MaxLimit int = 1000
 )
 
 // Pagination represents pagination parameters.
@@ -30,7 +33,8 @@ type Paginate struct {
 	Offset int
 	// Sort Direction - asc or desc
 	Dir string
-	// Sort by - name, created (created_at), or expires
+	// Sort by - the column of name, created (created_at), expires (expires_at) or
+	// status
 	SortBy string
 }
 
@@ -81,7 +85,7 @@ type Links struct {
 }
 
 func getFirstLink(url *url.URL, limit, offset int) string {
-	firstURL := url
+	firstURL := *url
 	q := firstURL.Query()
 	q.Set("offset", fmt.Sprintf("%d", 0))
 	q.Set("limit", fmt.Sprintf("%d", limit))
@@ -94,7 +98,7 @@ func getNextLink(url *url.URL, count int64, limit, offset int) *string {
 	if int64(offset+limit) >= count {
 		return nil
 	}
-	nextURL := url
+	nextURL := *url
 	q := nextURL.Query()
 	q.Set("offset", fmt.Sprintf("%d", limit+offset))
 	q.Set("limit", fmt.Sprintf("%d", limit))
@@ -109,7 +113,7 @@ func getPreviousLink(url *url.URL, count int64, limit, offset int) *string {
 	if offset <= 0 || int64(offset) >= count {
 		return nil
 	}
-	previousURL := url
+	previousURL := *url
 	q := previousURL.Query()
 
 	if offset-limit <= 0 {
@@ -127,15 +131,17 @@ func getPreviousLink(url *url.URL, count int64, limit, offset int) *string {
 }
 
 func getLastLink(url *url.URL, count int64, limit, offset int) string {
-	lastURL := url
+	lastURL := *url
 	q := lastURL.Query()
-	q.Set("offset", fmt.Sprintf("%d", count-int64(limit)))
+	last := count - int64(limit)
+	if last < 0 {
+		last = 0
+	}
+	q.Set("offset", fmt.Sprintf("%d", last))
 	q.Set("limit", fmt.Sprintf("%d", limit))
 
 	lastURL.RawQuery = q.Encode()
-	last := lastURL.String()
-
-	return last
+	return lastURL.String()
 }
 
 func GetLinks(url *url.URL, p Paginate, count int64, data interface{}) Links {
@@ -170,12 +176,15 @@ func PaginationCtx(next http.Handler) http.Handler {
 				return
 			}
 
-			if lim < 0 {
-				BadRequestError(w, fmt.Errorf("invalid limt: %d", lim))
+			if lim < 0 || lim > MaxLimit {
+				BadRequestError(w, fmt.Errorf("invalid limit: %d, the limit must be between 0 and %d", lim, MaxLimit))
 				return
 			}
 
-			pagination.Limit = lim
+			// a zero limit keeps the default page, it would not limit the page otherwise
+			if lim > 0 {
+				pagination.Limit = lim
+			}
 		}
 
 		offset := r.URL.Query().Get("offset")
@@ -197,12 +206,14 @@ func PaginationCtx(next http.Handler) http.Handler {
 		sort := r.URL.Query().Get("sort")
 		if sort != "" {
 			switch sort {
-			case "name", "expires":
+			case "name", "status":
 				pagination.SortBy = sort
-			case "created":
+			case "created", "created_at":
 				pagination.SortBy = "created_at"
+			case "expires", "expires_at":
+				pagination.SortBy = "expires"
 			default:
-				BadRequestError(w, fmt.Errorf("sort does not match 'name', 'created', or 'expires': %s", sort))
+				BadRequestError(w, fmt.Errorf("sort does not match 'name', 'created', 'expires' or 'status': %s", sort))
 				return
 			}
 		}
@@ -45,6 +45,26 @@ var _ = Describe("Handler", func() {
 				Last:     expectedLast,
 			}))
 		})
+
+		It("should not modify the url of the request", func() {
+			url := &url.URL{Path: "/test", RawQuery: "status=complete"}
+
+			links := middleware.GetLinks(url, middleware.Paginate{Limit: 10, Offset: 20}, 100, []int{})
+
+			Expect(url.String()).To(Equal("/test?status=complete"))
+			Expect(links.First).To(Equal("/test?limit=10&offset=0&status=complete"))
+		})
+
+		It("should not link to a negative offset when the page is larger than the count", func() {
+			url := &url.URL{Path: "/test"}
+
+			links := middleware.GetLinks(url, middleware.Paginate{Limit: 10, Offset: 3}, 5, []int{})
+
+			expectedPrevious := "/test?limit=10&offset=0"
+			Expect(links.Next).To(BeNil())
+			Expect(links.Previous).To(Equal(&expectedPrevious))
+			Expect(links.Last).To(Equal("/test?limit=10&offset=0"))
+		})
 	})
 
 	DescribeTable("Test PaginationCtx function",
@@ -62,6 +82,10 @@ var _ = Describe("Handler", func() {
 			if expectedStatus == http.StatusOK {
 				l, err := strconv.Atoi(limit)
 				Expect(err).To(BeNil())
+				// a zero limit falls back to the default
+				if l == 0 {
+					l = 100
+				}
 				o, err := strconv.Atoi(offset)
 				Expect(err).To(BeNil())
 
@@ -100,7 +124,31 @@ var _ = Describe("Handler", func() {
 		Entry("Use default values of 100 Limit and 0 Offset", true, "100", "0", http.StatusOK),
 		Entry("Use passed values", false, "10", "20", http.StatusOK),
 		Entry("Pass negative values", false, "-10", "-20", http.StatusBadRequest),
+		Entry("Pass a zero offset", false, "1", "0", http.StatusOK),
 		Entry("Pass zero values", false, "0", "0", http.StatusOK),
+		Entry("Pass the maximum limit", false, "1000", "0", http.StatusOK),
+		Entry("Pass a limit over the maximum", false, "1001", "0", http.StatusBadRequest),
 		Entry("Pass non-integer values", false, "a", "b", http.StatusBadRequest),
 	)
+
+	DescribeTable("Test the sort of PaginationCtx",
+		func(sort string, expectedSortBy string, expectedStatus int) {
+			var sortBy string
+			rr := httptest.NewRecorder()
+			handler := middleware.PaginationCtx(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				sortBy = middleware.GetPagination(r.Context()).SortBy
+			}))
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test?sort="+sort, nil))
+
+			Expect(rr.Code).To(Equal(expectedStatus))
+			Expect(sortBy).To(Equal(expectedSortBy))
+		},
+		Entry("by name", "name", "name", http.StatusOK),
+		Entry("by status", "status", "status", http.StatusOK),
+		Entry("by created", "created", "created_at", http.StatusOK),
+		Entry("by created_at", "created_at", "created_at", http.StatusOK),
+		Entry("by expires", "expires", "expires", http.StatusOK),
+		Entry("by expires_at", "expires_at", "expires", http.StatusOK),
+		Entry("by an unknown column", "size", "", http.StatusBadRequest),
+	)
 })
@@ -5,13 +5,29 @@ SPDX-License-Identifier: Apache-2.0
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
 )
 
 var Cfg = config.Get()
 
+// PSKUses records the uses of the psks, they are not recorded when it is nil.
+var PSKUses *PSKUseTracker
+
+type pskKey int
+
+const (
+	pskIDKey pskKey = iota
+	pskApplicationKey
+)
+
 // SliceContainsString returns true if the specified target is present in the given slice.
 // TODO: if this function is needed elsewhere, it should be moved to a separate package.
 func SliceContainsString(slice []string, target string) bool {
@@ -38,6 +54,91 @@ func EnforcePSK(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		id := PSKID(psk[0])
+		if expiry, ok := Cfg.PskExpiries[id]; ok && !time.Now().Before(expiry) {
+			logger.Get().Warnw("rejected an expired psk", "psk_id", id, "expired_at", expiry)
+			JSONError(w, "expired x-rh-exports-psk header", http.StatusUnauthorized)
+			return
+		}
+		if PSKUses != nil {
+			PSKUses.Seen(id)
+		}
+
+		ctx := context.WithValue(r.Context(), pskIDKey, id)
+		if applications, ok := Cfg.PskApplications[id]; ok {
+			ctx = context.WithValue(ctx, pskApplicationKey, applications)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// PSKID returns a short, non-reversible identifier of the psk that can be logged in
+// place of the psk itself.
+func PSKID(psk string) string {
+	sum := sha256.Sum256([]byte(psk))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// GetPSKID fetches the identifier of the psk that authenticated the request from the
+// context, or the `client:` prefixed client id of the bearer token that did.
+func GetPSKID(ctx context.Context) string {
+	id, _ := ctx.Value(pskIDKey).(string)
+	return id
+}
+
+// GetPSKApplications fetches the applications the psk that authenticated the request
+// is scoped to. The boolean is false for psks that are not scoped to applications.
+func GetPSKApplications(ctx context.Context) ([]string, bool) {
+	applications, ok := ctx.Value(pskApplicationKey).([]string)
+	return applications, ok
+}
+
+// PSKAllows reports whether the psk that authenticated the request may act for the
+// application: the psks that are not scoped act for every application.
+func PSKAllows(ctx context.Context, application string) bool {
+	applications, ok := GetPSKApplications(ctx)
+	return !ok || SliceContainsString(applications, application)
+}
+
+// PSKUseRecorder stores the last use of the psks.
+type PSKUseRecorder interface {
+	RecordPSKUse(pskID string, at time.Time) error
+}
+
+// PSKUseTracker records the uses of the psks, at most once per Interval for each psk,
+// so that the operators rotating a psk can tell when the previous one is no longer
+// used.
+type PSKUseTracker struct {
+	Recorder PSKUseRecorder
+	Interval time.Duration
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	mu       sync.Mutex
+	recorded map[string]time.Time
+}
+
+// NewPSKUseTracker returns a tracker recording the uses of each psk once a minute.
+func NewPSKUseTracker(recorder PSKUseRecorder) *PSKUseTracker {
+	return &PSKUseTracker{Recorder: recorder, Interval: time.Minute, Now: time.Now, recorded: map[string]time.Time{}}
+}
+
+// Seen records the use of the psk unless it was recorded within the Interval. A use
+// that could not be recorded is recorded with the next one.
+func (t *PSKUseTracker) Seen(pskID string) {
+	now := t.Now()
+	t.mu.Lock()
+	if last, ok := t.recorded[pskID]; ok && now.Sub(last) < t.Interval {
+		t.mu.Unlock()
+		return
+	}
+	t.recorded[pskID] = now
+	t.mu.Unlock()
+
+	if err := t.Recorder.RecordPSKUse(pskID, now); err != nil {
+		logger.Get().Errorw("failed to record the use of the psk", "psk_id", pskID, "error", err)
+		t.mu.Lock()
+		delete(t.recorded, pskID)
+		t.mu.Unlock()
+	}
+}
@@ -1,8 +1,10 @@
 package middleware_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
 	. "github.com/onsi/ginkgo/v2"
@@ -65,4 +67,127 @@ var _ = Describe("Handler", func() {
 		Entry("Test with invalid header", true, false, "invalid", http.StatusUnauthorized),
 		Entry("Test with valid header", true, false, validExportConfig.Psks[0], http.StatusOK),
 	)
+
+	It("stores the id of the psk in the request context", func() {
+		middleware.Cfg = validExportConfig
+
+		req, err := http.NewRequest("GET", "/test", nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("X-Rh-Exports-Psk", validExportConfig.Psks[0])
+
+		var pskID string
+		router := chi.NewRouter()
+		router.Route("/", func(sub chi.Router) {
+			sub.Use(middleware.EnforcePSK)
+			sub.Get("/test", func(rw http.ResponseWriter, r *http.Request) {
+				pskID = middleware.GetPSKID(r.Context())
+			})
+		})
+
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(pskID).To(Equal(middleware.PSKID(validExportConfig.Psks[0])))
+		Expect(pskID).ShouldNot(ContainSubstring(validExportConfig.Psks[0]))
+	})
+
+	DescribeTable("stores the applications the psk is scoped to in the request context",
+		func(psk string, expectedApplications []string, expectedScoped bool) {
+			middleware.Cfg = &config.ExportConfig{
+				Psks:            []string{"scoped-psk", "shared-psk"},
+				PskApplications: map[string][]string{middleware.PSKID("scoped-psk"): {"exampleApp", "otherApp"}},
+			}
+
+			req, err := http.NewRequest("GET", "/test", nil)
+			Expect(err).To(BeNil())
+			req.Header.Set("X-Rh-Exports-Psk", psk)
+
+			var applications []string
+			var scoped, allowsExampleApp, allowsThirdApp bool
+			router := chi.NewRouter()
+			router.With(middleware.EnforcePSK).Get("/test", func(rw http.ResponseWriter, r *http.Request) {
+				applications, scoped = middleware.GetPSKApplications(r.Context())
+				allowsExampleApp = middleware.PSKAllows(r.Context(), "exampleApp")
+				allowsThirdApp = middleware.PSKAllows(r.Context(), "thirdApp")
+			})
+
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			Expect(applications).To(Equal(expectedApplications))
+			Expect(scoped).To(Equal(expectedScoped))
+			Expect(allowsExampleApp).To(BeTrue())
+			Expect(allowsThirdApp).To(Equal(!expectedScoped))
+		},
+		Entry("for a scoped psk", "scoped-psk", []string{"exampleApp", "otherApp"}, true),
+		Entry("for a shared psk", "shared-psk", nil, false),
+	)
+
+	It("rejects the psks once they expired, while the rotated psk is accepted", func() {
+		middleware.Cfg = &config.ExportConfig{
+			Psks: []string{"old-psk", "new-psk"},
+			PskExpiries: map[string]time.Time{
+				middleware.PSKID("old-psk"): time.Now().Add(-time.Minute),
+				middleware.PSKID("new-psk"): time.Now().Add(time.Hour),
+			},
+		}
+		router := chi.NewRouter()
+		router.With(middleware.EnforcePSK).Get("/test", func(rw http.ResponseWriter, r *http.Request) {})
+
+		request := func(psk string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set("X-Rh-Exports-Psk", psk)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := request("old-psk")
+		Expect(rr.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rr.Body.String()).To(ContainSubstring("expired"))
+		Expect(request("new-psk").Code).To(Equal(http.StatusOK))
+	})
+})
+
+type mockPSKUseRecorder struct {
+	uses []time.Time
+	err  error
+}
+
+func (m *mockPSKUseRecorder) RecordPSKUse(pskID string, at time.Time) error {
+	m.uses = append(m.uses, at)
+	return m.err
+}
+
+var _ = Describe("The psk use tracker", func() {
+	It("records the uses of each psk once per interval", func() {
+		now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		recorder := &mockPSKUseRecorder{}
+		tracker := middleware.NewPSKUseTracker(recorder)
+		tracker.Now = func() time.Time { return now }
+
+		tracker.Seen("1a2b3c4d")
+		now = now.Add(30 * time.Second)
+		tracker.Seen("1a2b3c4d")
+		Expect(recorder.uses).To(HaveLen(1))
+
+		// a use that could not be recorded is recorded with the next one
+		recorder.err = errors.New("database is down")
+		now = now.Add(time.Minute)
+		tracker.Seen("1a2b3c4d")
+		recorder.err = nil
+		tracker.Seen("1a2b3c4d")
+		Expect(recorder.uses).To(HaveLen(3))
+		Expect(recorder.uses[2]).To(Equal(now))
+	})
+
+	It("records the psks accepted by the middleware", func() {
+		recorder := &mockPSKUseRecorder{}
+		middleware.PSKUses = middleware.NewPSKUseTracker(recorder)
+		defer func() { middleware.PSKUses = nil }()
+		middleware.Cfg = validExportConfig
+
+		router := chi.NewRouter()
+		router.With(middleware.EnforcePSK).Get("/test", func(rw http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Rh-Exports-Psk", "test-psk")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(recorder.uses).To(HaveLen(1))
+	})
 })
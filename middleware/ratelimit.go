@@ -0,0 +1,292 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
+)
+
+var throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_rate_limited_requests_total",
+	Help: "Number of requests rejected by the rate limiter, partitioned by org id and request class",
+}, []string{"org_id", "class"})
+
+func init() {
+	prometheus.MustRegister(throttledRequests)
+}
+
+const (
+	readRequests  = "read"
+	writeRequests = "write"
+	// CreateRequests are the creations of exports, limited on top of the writes
+	CreateRequests = "create"
+	// DownloadRequests are the downloads of the archives and of the sources, limited
+	// on top of the reads
+	DownloadRequests = "download"
+	// anonymousOrg labels the throttled requests without an identity, so that the
+	// metric is not partitioned by ip
+	anonymousOrg = "anonymous"
+)
+
+// Rate is the size of a token bucket and how fast it refills. A rate of 0 is
+// unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available again
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter keeps the token buckets of the rate limiter. The MemoryLimiter keeps them
+// in the pod, so every replica enforces the limits on its own. A limiter backed by a
+// shared store can enforce them across replicas.
+type Limiter interface {
+	// Take removes a token from the bucket of the key.
+	Take(ctx context.Context, key string, rate Rate) (Decision, error)
+}
+
+// RateLimiter is a middleware limiting the requests of each organization, account or
+// user, or of each client ip for requests without an identity. Reads and writes are
+// limited separately, and the creations and downloads of exports have their own limits
+// on top of them.
+type RateLimiter struct {
+	Limiter   Limiter
+	Reads     Rate
+	Writes    Rate
+	Creates   Rate
+	Downloads Rate
+	// Key is the identity the requests are counted against, the organization when
+	// empty
+	Key string
+	// TrustedProxies is the number of proxies whose X-Forwarded-For entries identify
+	// the client ip
+	TrustedProxies int
+}
+
+// NewRateLimiter returns a rate limiter enforcing the configured limits, in the pod or
+// in redis.
+func NewRateLimiter(cfg *config.ExportConfig) *RateLimiter {
+	rl := cfg.RateLimitConfig
+	var limiter Limiter = NewMemoryLimiter()
+	if rl.Backend == config.RateLimitRedis {
+		limiter = NewRedisLimiter(NewRedisClient(rl.Redis.Addr, rl.Redis.Password, rl.Redis.DB, rl.Redis.TLS, rl.Redis.Timeout))
+	}
+	return &RateLimiter{
+		Limiter:        limiter,
+		Reads:          Rate{PerSecond: rl.ReadsPerSecond, Burst: rl.ReadBurst},
+		Writes:         Rate{PerSecond: rl.WritesPerSecond, Burst: rl.WriteBurst},
+		Creates:        Rate{PerSecond: rl.CreatesPerSecond, Burst: rl.CreateBurst},
+		Downloads:      Rate{PerSecond: rl.DownloadsPerSecond, Burst: rl.DownloadBurst},
+		Key:            rl.Key,
+		TrustedProxies: cfg.TrustedProxies,
+	}
+}
+
+// Limit rejects the requests that exceed the limits with a 429. Every response
+// carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, rate := readRequests, rl.Reads
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			class, rate = writeRequests, rl.Writes
+		}
+		if rl.take(w, r, class, rate) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// LimitClass returns a middleware limiting the requests of a route to the rate of the
+// class, CreateRequests or DownloadRequests, on top of Limit. The headers of the
+// responses describe the bucket of the class. A nil rate limiter does not limit.
+func (rl *RateLimiter) LimitClass(class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rl == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rate := rl.Creates
+			if class == DownloadRequests {
+				rate = rl.Downloads
+			}
+			if rl.take(w, r, class, rate) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// take takes a token from the bucket of the class of the request, it answers the
+// request with a 429 and returns false when there is none.
+func (rl *RateLimiter) take(w http.ResponseWriter, r *http.Request, class string, rate Rate) bool {
+	if rate.PerSecond <= 0 {
+		return true
+	}
+
+	orgID, key := rl.requestKey(r)
+	decision, err := rl.Limiter.Take(r.Context(), class+":"+key, rate)
+	if err != nil {
+		// a broken limiter must not take the api down with it
+		logger.Get().Errorw("failed to rate limit request", "error", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(rate.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+
+	if !decision.Allowed {
+		throttledRequests.With(prometheus.Labels{"org_id": orgID, "class": class}).Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+		JSONError(w, "too many requests, retry later", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// requestKey returns the org id the request is counted against along with the key of
+// its bucket. Requests without an identity are counted against their client ip, and
+// those without an account against their organization.
+func (rl *RateLimiter) requestKey(r *http.Request) (string, string) {
+	user, ok := r.Context().Value(UserIdentityKey).(User)
+	if !ok || user.OrganizationID == "" {
+		return anonymousOrg, "ip:" + ClientIP(r, rl.TrustedProxies)
+	}
+	switch {
+	case rl.Key == config.RateLimitByUser:
+		return user.OrganizationID, "user:" + user.OrganizationID + ":" + user.Username
+	case rl.Key == config.RateLimitByAccount && user.AccountID != "":
+		return user.OrganizationID, "account:" + user.AccountID
+	}
+	return user.OrganizationID, "org:" + user.OrganizationID
+}
+
+// ClientIP returns the ip of the client. Each of the trusted proxies in front of the
+// service appends the address of its client to X-Forwarded-For, so the client is the
+// entry appended by the outermost of them; the entries before it are sent by the
+// client and may be spoofed. The address of the connection is used without trusted
+// proxies, or when the header is missing or malformed.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(entry))
+		}
+	}
+	if trustedProxies > 0 && len(forwarded) > 0 {
+		// fewer entries than proxies were all appended by the proxies
+		i := len(forwarded) - trustedProxies
+		if i < 0 {
+			i = 0
+		}
+		if ip := net.ParseIP(forwarded[i]); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryLimiter keeps the token buckets in memory.
+type MemoryLimiter struct {
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    Rate
+}
+
+// refill returns the tokens in the bucket at the given time.
+func (b *bucket) refill(now time.Time) float64 {
+	return math.Min(float64(b.rate.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.rate.PerSecond)
+}
+
+// sweepInterval is how often the buckets that have refilled are dropped, so that
+// the buckets of clients that went away do not pile up
+const sweepInterval = time.Minute
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{Now: time.Now, buckets: map[string]*bucket{}}
+}
+
+func (m *MemoryLimiter) Take(ctx context.Context, key string, rate Rate) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	burst := float64(rate.Burst)
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		m.buckets[key] = b
+	}
+	b.rate = rate
+	b.tokens = b.refill(now)
+	b.updated = now
+
+	decision := Decision{Allowed: b.tokens >= 1}
+	if decision.Allowed {
+		b.tokens--
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - b.tokens) / rate.PerSecond)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = secondsToDuration((burst - b.tokens) / rate.PerSecond)
+	return decision, nil
+}
+
+// sweep drops the buckets that are full again, a new bucket starts out full anyway.
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if b.refill(now) >= float64(b.rate.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
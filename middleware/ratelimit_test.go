@@ -0,0 +1,196 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The rate limiter", func() {
+	var now time.Time
+	var limiter *middleware.MemoryLimiter
+
+	BeforeEach(func() {
+		now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		limiter = middleware.NewMemoryLimiter()
+		limiter.Now = func() time.Time { return now }
+	})
+
+	It("refills the token bucket at the configured rate", func() {
+		rate := middleware.Rate{PerSecond: 1, Burst: 2}
+		ctx := context.Background()
+
+		decision, err := limiter.Take(ctx, "key", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Allowed).To(BeTrue())
+		Expect(decision.Remaining).To(Equal(1))
+
+		decision, _ = limiter.Take(ctx, "key", rate)
+		Expect(decision.Allowed).To(BeTrue())
+		Expect(decision.Remaining).To(Equal(0))
+		Expect(decision.Reset).To(Equal(2 * time.Second))
+
+		decision, _ = limiter.Take(ctx, "key", rate)
+		Expect(decision.Allowed).To(BeFalse())
+		Expect(decision.RetryAfter).To(Equal(time.Second))
+
+		now = now.Add(500 * time.Millisecond)
+		decision, _ = limiter.Take(ctx, "key", rate)
+		Expect(decision.Allowed).To(BeFalse())
+		Expect(decision.RetryAfter).To(Equal(500 * time.Millisecond))
+
+		// other keys have their own bucket
+		decision, _ = limiter.Take(ctx, "other-key", rate)
+		Expect(decision.Allowed).To(BeTrue())
+
+		now = now.Add(500 * time.Millisecond)
+		decision, _ = limiter.Take(ctx, "key", rate)
+		Expect(decision.Allowed).To(BeTrue())
+	})
+
+	Describe("middleware", func() {
+		var router *chi.Mux
+		var rl *middleware.RateLimiter
+
+		BeforeEach(func() {
+			rl = &middleware.RateLimiter{
+				Limiter:        limiter,
+				Reads:          middleware.Rate{PerSecond: 1, Burst: 2},
+				Writes:         middleware.Rate{PerSecond: 0.5, Burst: 1},
+				TrustedProxies: 1,
+			}
+
+			router = chi.NewRouter()
+			router.Use(rl.Limit)
+			router.Get("/exports", func(w http.ResponseWriter, r *http.Request) {})
+			router.Post("/exports", func(w http.ResponseWriter, r *http.Request) {})
+		})
+
+		requestAs := func(method, path string, user *middleware.User, ip string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			if user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIdentityKey, *user))
+			}
+			if ip != "" {
+				req.Header.Set("X-Forwarded-For", ip)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		request := func(method, orgID, ip string) *httptest.ResponseRecorder {
+			if orgID == "" {
+				return requestAs(method, "/exports", nil, ip)
+			}
+			return requestAs(method, "/exports", &middleware.User{OrganizationID: orgID, Username: "user", Type: middleware.UserIdentity}, ip)
+		}
+
+		It("limits reads and writes of each org separately", func() {
+			rr := request("POST", "org-a", "")
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("RateLimit-Limit")).To(Equal("1"))
+			Expect(rr.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+			Expect(rr.Header().Get("RateLimit-Reset")).To(Equal("2"))
+
+			rr = request("POST", "org-a", "")
+			Expect(rr.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(rr.Header().Get("Retry-After")).To(Equal("2"))
+			Expect(rr.Body.String()).To(ContainSubstring("too many requests"))
+
+			Expect(request("POST", "org-b", "").Code).To(Equal(http.StatusOK))
+			Expect(request("GET", "org-a", "").Code).To(Equal(http.StatusOK))
+
+			now = now.Add(2 * time.Second)
+			Expect(request("POST", "org-a", "").Code).To(Equal(http.StatusOK))
+		})
+
+		It("limits the creations and the downloads on top of the reads and writes", func() {
+			rl.Reads = middleware.Rate{PerSecond: 10, Burst: 10}
+			rl.Writes = middleware.Rate{PerSecond: 10, Burst: 10}
+			rl.Creates = middleware.Rate{PerSecond: 0.5, Burst: 1}
+			rl.Downloads = middleware.Rate{PerSecond: 1, Burst: 2}
+			router.With(rl.LimitClass(middleware.CreateRequests)).Post("/create", func(w http.ResponseWriter, r *http.Request) {})
+			router.With(rl.LimitClass(middleware.DownloadRequests)).Get("/download", func(w http.ResponseWriter, r *http.Request) {})
+			user := &middleware.User{OrganizationID: "org-a", Username: "user", Type: middleware.UserIdentity}
+
+			Expect(requestAs("POST", "/create", user, "").Code).To(Equal(http.StatusOK))
+			rr := requestAs("POST", "/create", user, "")
+			Expect(rr.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(rr.Header().Get("RateLimit-Limit")).To(Equal("1"))
+			Expect(rr.Header().Get("Retry-After")).To(Equal("2"))
+			// the other writes and reads are not limited by the creations and downloads
+			Expect(requestAs("POST", "/exports", user, "").Code).To(Equal(http.StatusOK))
+
+			Expect(requestAs("GET", "/download", user, "").Code).To(Equal(http.StatusOK))
+			Expect(requestAs("GET", "/download", user, "").Code).To(Equal(http.StatusOK))
+			Expect(requestAs("GET", "/download", user, "").Code).To(Equal(http.StatusTooManyRequests))
+			Expect(requestAs("GET", "/exports", user, "").Code).To(Equal(http.StatusOK))
+
+			// a nil rate limiter does not limit
+			var unlimited *middleware.RateLimiter
+			router.With(unlimited.LimitClass(middleware.CreateRequests)).Post("/unlimited", func(w http.ResponseWriter, r *http.Request) {})
+			Expect(requestAs("POST", "/unlimited", user, "").Code).To(Equal(http.StatusOK))
+		})
+
+		DescribeTable("counts the requests against the configured identity",
+			func(key string, sameBucket bool) {
+				rl.Key = key
+				Expect(requestAs("POST", "/exports", &middleware.User{AccountID: "1", OrganizationID: "org-a", Username: "alice"}, "").Code).To(Equal(http.StatusOK))
+				code := requestAs("POST", "/exports", &middleware.User{AccountID: "2", OrganizationID: "org-a", Username: "bob"}, "").Code
+				if sameBucket {
+					Expect(code).To(Equal(http.StatusTooManyRequests))
+				} else {
+					Expect(code).To(Equal(http.StatusOK))
+				}
+			},
+			Entry("the organization", "org", true),
+			Entry("the account", "account", false),
+			Entry("the user", "user", false),
+		)
+
+		It("limits requests without an identity by client ip", func() {
+			Expect(request("POST", "", "10.0.0.1").Code).To(Equal(http.StatusOK))
+			// the entries sent by the client do not get it another bucket
+			Expect(request("POST", "", "10.1.1.1, 10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+			Expect(request("POST", "", "10.0.0.2").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	DescribeTable("finds the ip of the client",
+		func(forwarded string, trustedProxies int, expected string) {
+			req := httptest.NewRequest("GET", "/exports", nil)
+			req.RemoteAddr = "10.128.0.5:43512"
+			if forwarded != "" {
+				req.Header.Set("X-Forwarded-For", forwarded)
+			}
+			Expect(middleware.ClientIP(req, trustedProxies)).To(Equal(expected))
+		},
+		Entry("appended by the proxy", "192.0.2.10", 1, "192.0.2.10"),
+		Entry("after the entries sent by the client", "198.51.100.7, 192.0.2.10", 1, "192.0.2.10"),
+		Entry("appended by the outermost of the proxies", "198.51.100.7, 192.0.2.10, 10.0.0.1", 2, "192.0.2.10"),
+		Entry("with fewer entries than proxies", "192.0.2.10", 2, "192.0.2.10"),
+		Entry("from the connection without a proxy", "192.0.2.10", 0, "10.128.0.5"),
+		Entry("from the connection without the header", "", 1, "10.128.0.5"),
+		Entry("from the connection when the entry is not an ip", "198.51.100.7, unknown", 1, "10.128.0.5"),
+	)
+
+	It("does not limit requests when the rate is 0", func() {
+		rl := &middleware.RateLimiter{Limiter: limiter}
+		handler := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		for i := 0; i < 10; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/exports", nil))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Header().Get("RateLimit-Limit")).To(BeEmpty())
+		}
+	})
+})
@@ -0,0 +1,232 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/logger"
+)
+
+var deniedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_rbac_denied_requests_total",
+	Help: "Number of requests rejected for a missing RBAC permission, partitioned by org id and permission",
+}, []string{"org_id", "permission"})
+
+func init() {
+	prometheus.MustRegister(deniedRequests)
+}
+
+// The permissions of the export-service application in RBAC.
+const (
+	PermissionRead   = "export-service:exports:read"
+	PermissionWrite  = "export-service:exports:write"
+	PermissionDelete = "export-service:exports:delete"
+)
+
+// rbacApplication is the application the permissions are requested for
+const rbacApplication = "export-service"
+
+// PermissionSource returns the permissions of the user of an x-rh-identity.
+type PermissionSource interface {
+	Permissions(ctx context.Context, identity string) ([]string, error)
+}
+
+// RBACClient fetches the permissions of the users from the access api of
+// insights-rbac.
+type RBACClient struct {
+	URL    string
+	Client *http.Client
+}
+
+type rbacAccess struct {
+	Data []struct {
+		Permission string `json:"permission"`
+	} `json:"data"`
+	Links struct {
+		Next *string `json:"next"`
+	} `json:"links"`
+}
+
+// Permissions returns the export-service permissions of the user, following the pages
+// of the access api.
+func (c *RBACClient) Permissions(ctx context.Context, identity string) ([]string, error) {
+	var permissions []string
+	next := fmt.Sprintf("/api/rbac/v1/access/?application=%s&limit=1000", rbacApplication)
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Rh-Identity", identity)
+
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to request the permissions: %w", err)
+		}
+		var access rbacAccess
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&access)
+		} else {
+			err = fmt.Errorf("rbac responded with a %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the permissions: %w", err)
+		}
+
+		for _, entry := range access.Data {
+			permissions = append(permissions, entry.Permission)
+		}
+		next = ""
+		if access.Links.Next != nil {
+			next = *access.Links.Next
+		}
+	}
+	return permissions, nil
+}
+
+// RBAC is a middleware requiring the users of the public api to have the
+// export-service permission of their request: read for GET requests, delete for
+// DELETE requests and write for the others. The permissions of each user are cached
+// for TTL. The systems authenticated with a certificate are not RBAC principals and
+// are not checked.
+type RBAC struct {
+	Enabled bool
+	Source  PermissionSource
+	TTL     time.Duration
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	mu        sync.Mutex
+	cache     map[string]cachedPermissions
+	lastSweep time.Time
+}
+
+type cachedPermissions struct {
+	permissions []string
+	expires     time.Time
+}
+
+// NewRBAC returns the middleware checking the permissions with the configured RBAC
+// service.
+func NewRBAC(cfg *config.ExportConfig) *RBAC {
+	return &RBAC{
+		Enabled: cfg.RBACConfig.Enabled,
+		Source:  &RBACClient{URL: cfg.RBACConfig.URL, Client: &http.Client{Timeout: cfg.RBACConfig.Timeout}},
+		TTL:     cfg.RBACConfig.CacheTTL,
+		Now:     time.Now,
+	}
+}
+
+// Enforce rejects the requests of the users without the permission of the request
+// with a 403, and the requests whose permissions could not be checked with a 503.
+func (rb *RBAC) Enforce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(UserIdentityKey).(User)
+		if !rb.Enabled || !ok || user.Type == SystemIdentity {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		permissions, err := rb.permissions(r.Context(), user, r.Header.Get("X-Rh-Identity"))
+		if err != nil {
+			logger.Get().Errorw("failed to check the permissions of the user", "error", err, "org_id", user.OrganizationID)
+			JSONError(w, "unable to check the permissions of the user, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		required := requiredPermission(r.Method)
+		if !hasPermission(permissions, required) {
+			deniedRequests.With(prometheus.Labels{"org_id": user.OrganizationID, "permission": required}).Inc()
+			JSONError(w, fmt.Sprintf("the '%s' permission is required", required), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// permissions returns the cached permissions of the user, fetching them once they
+// expired.
+func (rb *RBAC) permissions(ctx context.Context, user User, identity string) ([]string, error) {
+	key := user.OrganizationID + ":" + user.Username
+
+	rb.mu.Lock()
+	cached, ok := rb.cache[key]
+	rb.mu.Unlock()
+	if ok && rb.Now().Before(cached.expires) {
+		return cached.permissions, nil
+	}
+
+	permissions, err := rb.Source.Permissions(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	if rb.TTL <= 0 {
+		return permissions, nil
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	now := rb.Now()
+	if rb.cache == nil {
+		rb.cache = map[string]cachedPermissions{}
+	}
+	// drop the permissions of the users that went away
+	if now.Sub(rb.lastSweep) >= rb.TTL {
+		for k, c := range rb.cache {
+			if !now.Before(c.expires) {
+				delete(rb.cache, k)
+			}
+		}
+		rb.lastSweep = now
+	}
+	rb.cache[key] = cachedPermissions{permissions: permissions, expires: now.Add(rb.TTL)}
+	return permissions, nil
+}
+
+// requiredPermission returns the permission a request with the method requires.
+func requiredPermission(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return PermissionRead
+	case http.MethodDelete:
+		return PermissionDelete
+	default:
+		return PermissionWrite
+	}
+}
+
+// hasPermission reports whether one of the granted permissions, which may use a `*`
+// wildcard for any of their parts, matches the required one.
+func hasPermission(granted []string, required string) bool {
+	want := strings.Split(required, ":")
+	for _, permission := range granted {
+		parts := strings.Split(permission, ":")
+		if len(parts) != len(want) {
+			continue
+		}
+		matches := true
+		for i, part := range parts {
+			if part != "*" && part != want[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+type mockPermissions struct {
+	permissions map[string][]string
+	err         error
+	calls       int
+}
+
+func (m *mockPermissions) Permissions(ctx context.Context, identity string) ([]string, error) {
+	m.calls++
+	return m.permissions[identity], m.err
+}
+
+var _ = Describe("The RBAC middleware", func() {
+	var now time.Time
+	var source *mockPermissions
+	var rbac *middleware.RBAC
+	var router *chi.Mux
+
+	BeforeEach(func() {
+		now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		source = &mockPermissions{permissions: map[string][]string{
+			"reader": {"export-service:exports:read"},
+			"admin":  {"export-service:*:*"},
+		}}
+		rbac = &middleware.RBAC{Enabled: true, Source: source, TTL: time.Minute, Now: func() time.Time { return now }}
+
+		router = chi.NewRouter()
+		router.Use(rbac.Enforce)
+		router.Get("/exports", func(w http.ResponseWriter, r *http.Request) {})
+		router.Post("/exports", func(w http.ResponseWriter, r *http.Request) {})
+		router.Delete("/exports", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	request := func(method, username, userType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/exports", nil)
+		req.Header.Set("X-Rh-Identity", username)
+		user := middleware.User{OrganizationID: "org", Username: username, Type: userType}
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIdentityKey, user))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	It("requires the permission of the method of the request", func() {
+		Expect(request("GET", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+
+		rr := request("POST", "reader", middleware.UserIdentity)
+		Expect(rr.Code).To(Equal(http.StatusForbidden))
+		Expect(rr.Body.String()).To(ContainSubstring("export-service:exports:write"))
+		Expect(request("DELETE", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusForbidden))
+
+		Expect(request("POST", "admin", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(request("DELETE", "admin", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "nobody", middleware.ServiceAccountIdentity).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("caches the permissions of each user", func() {
+		Expect(request("GET", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(source.calls).To(Equal(1))
+
+		Expect(request("GET", "admin", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(source.calls).To(Equal(2))
+
+		now = now.Add(time.Minute)
+		Expect(request("GET", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(source.calls).To(Equal(3))
+	})
+
+	It("refuses the requests while the permissions can not be checked", func() {
+		source.err = errors.New("rbac is down")
+		Expect(request("GET", "reader", middleware.UserIdentity).Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("does not check the systems or a disabled middleware", func() {
+		Expect(request("POST", "system", middleware.SystemIdentity).Code).To(Equal(http.StatusOK))
+
+		rbac.Enabled = false
+		Expect(request("POST", "nobody", middleware.UserIdentity).Code).To(Equal(http.StatusOK))
+		Expect(source.calls).To(Equal(0))
+	})
+})
+
+var _ = Describe("The RBAC client", func() {
+	It("follows the pages of the access api", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/rbac/v1/access/"))
+			Expect(r.URL.Query().Get("application")).To(Equal("export-service"))
+			Expect(r.Header.Get("X-Rh-Identity")).To(Equal("identity"))
+			if r.URL.Query().Get("offset") == "" {
+				fmt.Fprint(w, `{"data": [{"permission": "export-service:exports:read", "resourceDefinitions": []}], "links": {"next": "/api/rbac/v1/access/?application=export-service&limit=1&offset=1"}}`)
+				return
+			}
+			fmt.Fprint(w, `{"data": [{"permission": "export-service:exports:write", "resourceDefinitions": []}], "links": {"next": null}}`)
+		}))
+		defer server.Close()
+
+		client := &middleware.RBACClient{URL: server.URL, Client: server.Client()}
+		permissions, err := client.Permissions(context.Background(), "identity")
+		Expect(err).To(BeNil())
+		Expect(permissions).To(Equal([]string{"export-service:exports:read", "export-service:exports:write"}))
+	})
+
+	It("fails when rbac does not answer with the permissions", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		client := &middleware.RBACClient{URL: server.URL, Client: server.Client()}
+		_, err := client.Permissions(context.Background(), "identity")
+		Expect(err).To(MatchError(ContainSubstring("500")))
+	})
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient returns a client of the redis at the address. The connections are
+// pooled by go-redis, which checks the idle ones before reusing them and backs off
+// from dialing while redis can not be reached. Every dial and command is bounded by
+// the timeout, and the commands are not retried, so that the limiter fails open
+// within the timeout.
+func NewRedisClient(addr, password string, db int, useTLS bool, timeout time.Duration) *redis.Client {
+	opts := &redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		MaxRetries:   -1,
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host}
+	}
+	return redis.NewClient(opts)
+}
+
+// tokenBucketScript takes a token from the bucket of KEYS[1], refilled at ARGV[1]
+// tokens per second up to ARGV[2] tokens, at the time ARGV[3] in milliseconds. It
+// returns whether the token was taken and the tokens left. The bucket expires once it
+// is full again, a new bucket starts out full anyway.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(math.max(now, updated)))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter keeps the token buckets in redis, so that the limits are enforced
+// across the replicas. The buckets are updated by a script, atomically.
+type RedisLimiter struct {
+	Client redis.Scripter
+	// Prefix is prepended to the keys of the buckets
+	Prefix string
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+}
+
+func NewRedisLimiter(client redis.Scripter) *RedisLimiter {
+	return &RedisLimiter{Client: client, Prefix: "export-service:ratelimit:", Now: time.Now}
+}
+
+func (l *RedisLimiter) Take(ctx context.Context, key string, rate Rate) (Decision, error) {
+	// the script is evaluated by its sha, and loaded by redis the first time
+	result, err := tokenBucketScript.Run(ctx, l.Client, []string{l.Prefix + key},
+		strconv.FormatFloat(rate.PerSecond, 'f', -1, 64),
+		rate.Burst,
+		l.Now().UnixNano()/int64(time.Millisecond),
+	).Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 2 {
+		return Decision{}, fmt.Errorf("unexpected reply of the token bucket script: %v", result)
+	}
+	allowed, _ := result[0].(int64)
+	tokensReply, _ := result[1].(string)
+	tokens, err := strconv.ParseFloat(tokensReply, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("unexpected tokens in the reply of the token bucket script: %w", err)
+	}
+
+	decision := Decision{Allowed: allowed == 1, Remaining: int(tokens)}
+	if !decision.Allowed {
+		decision.RetryAfter = secondsToDuration((1 - tokens) / rate.PerSecond)
+	}
+	decision.Reset = secondsToDuration((float64(rate.Burst) - tokens) / rate.PerSecond)
+	return decision, nil
+}
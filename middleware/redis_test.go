@@ -0,0 +1,77 @@
+package middleware_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The redis rate limiter", func() {
+	var server *miniredis.Miniredis
+	var now time.Time
+	var limiter *middleware.RedisLimiter
+
+	BeforeEach(func() {
+		server = miniredis.NewMiniRedis()
+		server.RequireAuth("redis-password")
+		Expect(server.Start()).To(Succeed())
+		DeferCleanup(server.Close)
+
+		now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		client := middleware.NewRedisClient(server.Addr(), "redis-password", 0, false, time.Second)
+		DeferCleanup(client.Close)
+		limiter = middleware.NewRedisLimiter(client)
+		limiter.Now = func() time.Time { return now }
+	})
+
+	It("takes the tokens of the bucket with the script", func() {
+		rate := middleware.Rate{PerSecond: 2, Burst: 2}
+
+		decision, err := limiter.Take(context.Background(), "write:org:12345", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Allowed).To(BeTrue())
+		Expect(decision.Remaining).To(Equal(1))
+		Expect(decision.Reset).To(Equal(500 * time.Millisecond))
+
+		_, err = limiter.Take(context.Background(), "write:org:12345", rate)
+		Expect(err).To(BeNil())
+		decision, err = limiter.Take(context.Background(), "write:org:12345", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Allowed).To(BeFalse())
+		Expect(decision.RetryAfter).To(Equal(500 * time.Millisecond))
+		Expect(server.Keys()).To(Equal([]string{"export-service:ratelimit:write:org:12345"}))
+
+		// the bucket is refilled with time
+		now = now.Add(250 * time.Millisecond)
+		decision, err = limiter.Take(context.Background(), "write:org:12345", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Allowed).To(BeFalse())
+		Expect(decision.RetryAfter).To(Equal(250 * time.Millisecond))
+
+		now = now.Add(250 * time.Millisecond)
+		decision, err = limiter.Take(context.Background(), "write:org:12345", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Allowed).To(BeTrue())
+
+		// the buckets of the other keys are full
+		decision, err = limiter.Take(context.Background(), "write:org:67890", rate)
+		Expect(err).To(BeNil())
+		Expect(decision.Remaining).To(Equal(1))
+	})
+
+	It("fails when redis can not be used", func() {
+		wrong := middleware.NewRedisClient(server.Addr(), "wrong", 0, false, time.Second)
+		DeferCleanup(wrong.Close)
+		_, err := middleware.NewRedisLimiter(wrong).Take(context.Background(), "write:org:12345", middleware.Rate{PerSecond: 1, Burst: 1})
+		Expect(err).To(MatchError(ContainSubstring("WRONGPASS")))
+
+		server.Close()
+		_, err = limiter.Take(context.Background(), "write:org:12345", middleware.Rate{PerSecond: 1, Burst: 1})
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,36 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package middleware
+
+import (
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"github.com/redhatinsights/export-service-go/reporting"
+)
+
+// ReportPanics reports the panics of the handlers with their request, then panics
+// again so that the recoverer answers them. It must be used after the recoverer.
+func ReportPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			// an aborted handler is how a response is cut short, not a failure
+			if rvr != http.ErrAbortHandler {
+				tags := []string{"method", r.Method, "path", r.URL.Path}
+				if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+					tags = append(tags, "route", rctx.RoutePattern())
+				}
+				reporting.CapturePanic(r.Context(), rvr, tags...)
+			}
+			panic(rvr)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
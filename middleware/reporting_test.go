@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	chi "github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (r *eventRecorder) Configure(options sentry.ClientOptions) {}
+
+func (r *eventRecorder) SendEvent(event *sentry.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) Flush(timeout time.Duration) bool {
+	return true
+}
+
+var _ = Describe("The panic reporting middleware", func() {
+	var recorder *eventRecorder
+	var router chi.Router
+
+	BeforeEach(func() {
+		recorder = &eventRecorder{}
+		Expect(sentry.Init(sentry.ClientOptions{Transport: recorder})).To(Succeed())
+		DeferCleanup(func() {
+			sentry.CurrentHub().BindClient(nil)
+		})
+
+		router = chi.NewRouter()
+		router.Use(request_id.RequestID, chimiddleware.Recoverer, middleware.ReportPanics)
+		router.Get("/exports/{id}", func(w http.ResponseWriter, r *http.Request) {
+			panic("the handler panicked")
+		})
+		router.Get("/aborted", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		router.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	It("reports the panics with their request before they are recovered", func() {
+		req := httptest.NewRequest(http.MethodGet, "/exports/1234", nil)
+		req.Header.Set("X-Request-Id", "request-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.events).To(HaveLen(1))
+		Expect(recorder.events[0].Exception[0].Value).To(Equal("panic: the handler panicked"))
+		Expect(recorder.events[0].Tags).To(Equal(map[string]string{
+			"request_id": "request-1",
+			"method":     http.MethodGet,
+			"path":       "/exports/1234",
+			"route":      "/exports/{id}",
+		}))
+	})
+
+	It("does not report the aborted handlers", func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/aborted", nil))
+		Expect(recorder.events).To(BeEmpty())
+	})
+
+	It("does not report the requests that did not panic", func() {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(recorder.events).To(BeEmpty())
+	})
+})
@@ -0,0 +1,43 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The timeout middleware", func() {
+	var deadline time.Time
+	var hasDeadline bool
+	var ctxErr error
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+		<-time.After(20 * time.Millisecond)
+		ctxErr = r.Context().Err()
+	})
+
+	It("cancels the context of the request once the timeout elapses", func() {
+		start := time.Now()
+		rr := httptest.NewRecorder()
+		middleware.Timeout(10*time.Millisecond)(handler).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		Expect(hasDeadline).To(BeTrue())
+		Expect(deadline).To(BeTemporally("~", start.Add(10*time.Millisecond), 5*time.Millisecond))
+		Expect(ctxErr).To(Equal(context.DeadlineExceeded))
+	})
+
+	It("leaves the request without a deadline when the timeout is not positive", func() {
+		rr := httptest.NewRecorder()
+		middleware.Timeout(0)(handler).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		Expect(hasDeadline).To(BeFalse())
+		Expect(ctxErr).To(BeNil())
+	})
+})
@@ -0,0 +1,76 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	chi "github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/redhatinsights/export-service-go/middleware"
+)
+
+var _ = Describe("The tracing middleware", func() {
+	var recorder *tracetest.SpanRecorder
+	var previous trace.TracerProvider
+	var router chi.Router
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+
+		router = chi.NewRouter()
+		router.Use(middleware.Trace)
+		router.Get("/exports/{exportUUID}", func(w http.ResponseWriter, r *http.Request) {
+			Expect(trace.SpanContextFromContext(r.Context()).IsValid()).To(BeTrue())
+			w.WriteHeader(http.StatusOK)
+		})
+		router.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	It("names the span of a request after its route", func() {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/exports/0b4fb3d5-1c3a-4e4f-8a6e-3c5f2f2f6a1e", nil))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name()).To(Equal("GET /exports/{exportUUID}"))
+		Expect(spans[0].SpanKind()).To(Equal(trace.SpanKindServer))
+		Expect(spans[0].Status().Code).To(Equal(codes.Unset))
+		Expect(spans[0].Parent().IsValid()).To(BeFalse())
+	})
+
+	It("continues the trace of the caller", func() {
+		req := httptest.NewRequest("GET", "/exports/0b4fb3d5-1c3a-4e4f-8a6e-3c5f2f2f6a1e", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+	})
+
+	It("marks the spans of the server errors as failed", func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+	})
+})
@@ -6,6 +6,9 @@ package middleware
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -18,27 +21,104 @@ const (
 	UserIdentityKey userIdentityKey = iota
 )
 
+const (
+	UserIdentity           = "User"
+	ServiceAccountIdentity = "ServiceAccount"
+	SystemIdentity         = "System"
+)
+
 type User struct {
 	AccountID      string
 	OrganizationID string
-	Username       string
+	// Username is the stable requester of the identity: the username of a user, the
+	// client id of a service account or the common name of a system certificate
+	Username string
+	// Type is the type of the x-rh-identity
+	Type string
+}
+
+// serviceAccountIdentity is the part of the x-rh-identity describing a service
+// account, which the identity middleware does not parse.
+type serviceAccountIdentity struct {
+	Identity struct {
+		ServiceAccount struct {
+			ClientID string `json:"client_id"`
+			Username string `json:"username"`
+		} `json:"service_account"`
+	} `json:"identity"`
+}
+
+// EnforceIdentity extracts the x-rh-identity header and places the contents into the
+// request context, like the identity middleware of platform-go-middlewares. Its
+// checks require an account number, which the identities of service accounts do not
+// have, so they are replaced by checkIdentity. Invalid identities are rejected.
+func EnforceIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawHeaders := r.Header["X-Rh-Identity"]
+		if len(rawHeaders) != 1 {
+			BadRequestError(w, "missing x-rh-identity header")
+			return
+		}
+		decoded, err := base64.StdEncoding.DecodeString(rawHeaders[0])
+		if err != nil {
+			BadRequestError(w, "unable to b64 decode x-rh-identity header")
+			return
+		}
+		var id identity.XRHID
+		if err := json.Unmarshal(decoded, &id); err != nil {
+			BadRequestError(w, "x-rh-identity header does not contain valid JSON")
+			return
+		}
+		if id.Identity.OrgID == "" {
+			id.Identity.OrgID = id.Identity.Internal.OrgID
+		}
+		if err := checkIdentity(id); err != nil {
+			BadRequestError(w, err.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identity.Key, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkIdentity applies the checks of the identity middleware, except that service
+// accounts need no account number.
+func checkIdentity(id identity.XRHID) error {
+	switch {
+	case id.Identity.Type == "Associate" && id.Identity.AccountNumber == "":
+		return nil
+	case id.Identity.Type == ServiceAccountIdentity:
+	case id.Identity.AccountNumber == "" || id.Identity.AccountNumber == "-1":
+		return errors.New("x-rh-identity header has an invalid or missing account number")
+	}
+	if id.Identity.OrgID == "" || id.Identity.Internal.OrgID == "" {
+		return errors.New("x-rh-identity header has an invalid or missing org_id")
+	}
+	if id.Identity.Type == "" {
+		return errors.New("x-rh-identity header is missing type")
+	}
+	return nil
 }
 
 // EnforeUserIdentity is a middleware that checks for a valid x-rh-identity
-// header and adds the id to the request context.
+// header and adds the id to the request context. Users, service accounts and
+// systems authenticated with a certificate are accepted.
 func EnforceUserIdentity(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := identity.Get(r.Context())
 
-		if id.Identity.Type != "User" {
-			BadRequestError(w, fmt.Sprintf("'%s' is not a valid user type", id.Identity.Type))
+		requester, err := getRequester(r, id)
+		if err != nil {
+			BadRequestError(w, err.Error())
 			return
 		}
 
 		user := User{
 			AccountID:      id.Identity.AccountNumber,
 			OrganizationID: id.Identity.OrgID,
-			Username:       id.Identity.User.Username,
+			Username:       requester,
+			Type:           id.Identity.Type,
 		}
 
 		ctx := context.WithValue(r.Context(), UserIdentityKey, user)
@@ -46,6 +126,37 @@ func EnforceUserIdentity(next http.Handler) http.Handler {
 	})
 }
 
+// getRequester returns the value identifying who made the request for the type of
+// the identity. Identities without one are rejected, so that their exports are
+// never stored without an owner.
+func getRequester(r *http.Request, id identity.XRHID) (string, error) {
+	var requester string
+
+	switch id.Identity.Type {
+	case UserIdentity:
+		requester = id.Identity.User.Username
+	case ServiceAccountIdentity:
+		var sa serviceAccountIdentity
+		decoded, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Rh-Identity"))
+		if err != nil {
+			return "", fmt.Errorf("unable to decode the service account identity: %w", err)
+		}
+		if err := json.Unmarshal(decoded, &sa); err != nil {
+			return "", fmt.Errorf("unable to parse the service account identity: %w", err)
+		}
+		requester = sa.Identity.ServiceAccount.ClientID
+	case SystemIdentity:
+		requester, _ = id.Identity.System["cn"].(string)
+	default:
+		return "", fmt.Errorf("'%s' is not a valid user type", id.Identity.Type)
+	}
+
+	if requester == "" {
+		return "", fmt.Errorf("the '%s' identity does not name its requester", id.Identity.Type)
+	}
+	return requester, nil
+}
+
 // GetUserIdentity is a helper function that return the x-rh-identity
 // stored in the request context.
 func GetUserIdentity(ctx context.Context) User {
@@ -10,7 +10,6 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/redhatinsights/export-service-go/middleware"
-	"github.com/redhatinsights/platform-go-middlewares/identity"
 )
 
 var _ = Describe("Handler", func() {
@@ -40,7 +39,7 @@ var _ = Describe("Handler", func() {
 
 			router := chi.NewRouter()
 			router.Route("/", func(sub chi.Router) {
-				sub.Use(identity.EnforceIdentity)
+				sub.Use(middleware.EnforceIdentity)
 				sub.Use(middleware.EnforceUserIdentity)
 				sub.Get("/test", applicationHandler)
 			})
@@ -62,9 +61,67 @@ var _ = Describe("Handler", func() {
 			`{ "identity": {"account_number": "540155", "auth_type": "jwt-auth", "org_id": "1979710", "internal": {"org_id": "1979710"}, "type": "User", "user": {"username": "username", "email": "boring@boring.mail.com", "first_name": "Jake", "last_name": "Logan", "is_active": true, "is_org_admin": false, "is_internal": true, "locale": "North America", "user_id": "1010101"} } }`,
 			http.StatusOK,
 		),
+		Entry("Test without account number", "", "1979710", "username",
+			`{ "identity": {"auth_type": "jwt-auth", "org_id": "1979710", "internal": {"org_id": "1979710"}, "type": "User", "user": {"username": "username"} } }`,
+			http.StatusBadRequest,
+		),
 		Entry("Test without org_id", "540155", "", "username",
 			`{ "identity": {"account_number": "540155", "auth_type": "jwt-auth", "internal": {}, "type": "User", "user": {"username": "username", "email": "boring@boring.mail.com", "first_name": "Jake", "last_name": "Logan", "is_active": true, "is_org_admin": false, "is_internal": true, "locale": "North America", "user_id": "1010101"} } }`,
 			http.StatusBadRequest,
 		),
 	)
 })
+
+var _ = Describe("Identity types", func() {
+	DescribeTable("derive the requester of the identity",
+		func(testIdentity string, expected *middleware.User) {
+			req, err := http.NewRequest("GET", "/test", nil)
+			Expect(err).To(BeNil())
+			req.Header.Add("X-Rh-Identity", base64.StdEncoding.EncodeToString([]byte(testIdentity)))
+
+			var user *middleware.User
+			applicationHandler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				u := middleware.GetUserIdentity(r.Context())
+				user = &u
+			})
+
+			router := chi.NewRouter()
+			router.With(middleware.EnforceIdentity, middleware.EnforceUserIdentity).Get("/test", applicationHandler)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if expected == nil {
+				Expect(rr.Code).To(Equal(http.StatusBadRequest))
+				Expect(user).To(BeNil())
+				return
+			}
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(user).To(Equal(expected))
+		},
+		Entry("for a user",
+			`{"identity": {"account_number": "540155", "auth_type": "jwt-auth", "org_id": "1979710", "internal": {"org_id": "1979710"}, "type": "User", "user": {"username": "username", "email": "boring@boring.mail.com", "is_org_admin": false, "user_id": "1010101"}}}`,
+			&middleware.User{AccountID: "540155", OrganizationID: "1979710", Username: "username", Type: "User"},
+		),
+		Entry("for a service account",
+			`{"identity": {"org_id": "1979710", "auth_type": "jwt-auth", "internal": {"org_id": "1979710", "auth_time": 0}, "type": "ServiceAccount", "service_account": {"client_id": "b69eaf9e-e6a6-4f9e-805e-02987daddfbd", "username": "service-account-b69eaf9e-e6a6-4f9e-805e-02987daddfbd"}}}`,
+			&middleware.User{OrganizationID: "1979710", Username: "b69eaf9e-e6a6-4f9e-805e-02987daddfbd", Type: "ServiceAccount"},
+		),
+		Entry("for a system authenticated with a certificate",
+			`{"identity": {"account_number": "540155", "org_id": "1979710", "auth_type": "cert-auth", "internal": {"org_id": "1979710"}, "type": "System", "system": {"cn": "1b36b20f-7fa0-4454-a6d2-008294e06378", "cert_type": "system"}}}`,
+			&middleware.User{AccountID: "540155", OrganizationID: "1979710", Username: "1b36b20f-7fa0-4454-a6d2-008294e06378", Type: "System"},
+		),
+		Entry("rejects a user without a username",
+			`{"identity": {"account_number": "540155", "org_id": "1979710", "internal": {"org_id": "1979710"}, "type": "User", "user": {}}}`,
+			nil,
+		),
+		Entry("rejects a service account without a client id",
+			`{"identity": {"org_id": "1979710", "internal": {"org_id": "1979710"}, "type": "ServiceAccount", "service_account": {}}}`,
+			nil,
+		),
+		Entry("rejects a system without a common name",
+			`{"identity": {"org_id": "1979710", "auth_type": "cert-auth", "internal": {"org_id": "1979710"}, "type": "System", "system": {"cert_type": "system"}}}`,
+			nil,
+		),
+	)
+})
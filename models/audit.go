@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The actions recorded in the audit trail.
+const (
+	AuditCreate   = "create"
+	AuditDownload = "download"
+	AuditDelete   = "delete"
+	AuditCancel   = "cancel"
+	AuditRestore  = "restore"
+	// AuditRetry is the announcement of the pending sources of an export again
+	AuditRetry = "retry"
+)
+
+// The outcomes of the audited actions.
+const (
+	AuditSuccess = "success"
+	// AuditDenied is an action rejected for the credentials of the requester
+	AuditDenied  = "denied"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an action taken on an export, as recorded in the append only audit
+// trail. The events are never updated or deleted, the database rejects it.
+type AuditEvent struct {
+	ID         int64 `gorm:"primarykey"`
+	OccurredAt time.Time
+	Action     string
+	Outcome    string
+	// StatusCode is the status of the response to the request of the action
+	StatusCode int
+	// ExportID is nil for the actions that failed before their export was known
+	ExportID *uuid.UUID `gorm:"type:uuid"`
+	// SourceID is the source the action was taken on, nil for the whole export
+	SourceID *uuid.UUID `gorm:"type:uuid"`
+	User
+	// Client is the psk id or service account of the requests to the internal api
+	Client    string
+	SourceIP  string
+	RequestID string
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// AuditFilter selects the audit events, its empty fields match every event.
+type AuditFilter struct {
+	OrganizationID string
+	Username       string
+	Client         string
+	ExportID       *uuid.UUID
+	Action         string
+	Outcome        string
+	Since          *time.Time
+	Until          *time.Time
+}
+
+// RecordAuditEvents appends the events to the audit trail.
+func (edb *ExportDB) RecordAuditEvents(events []AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return edb.DB.Create(&events).Error
+}
+
+// ListAuditEvents returns a page of the events matching the filter, the latest first,
+// along with the number of matching events.
+func (edb *ExportDB) ListAuditEvents(filter AuditFilter, offset, limit int) (result []AuditEvent, count int64, err error) {
+	db := edb.DB.Model(&AuditEvent{})
+	for column, value := range map[string]string{
+		"organization_id": filter.OrganizationID,
+		"username":        filter.Username,
+		"client":          filter.Client,
+		"action":          filter.Action,
+		"outcome":         filter.Outcome,
+	} {
+		if value != "" {
+			db = db.Where(column+" = ?", value)
+		}
+	}
+	if filter.ExportID != nil {
+		db = db.Where("export_id = ?", *filter.ExportID)
+	}
+	if filter.Since != nil {
+		db = db.Where("occurred_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		db = db.Where("occurred_at < ?", *filter.Until)
+	}
+
+	if err = db.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	result = []AuditEvent{}
+	err = db.Order("occurred_at DESC, id DESC").Limit(limit).Offset(offset).Find(&result).Error
+	return result, count, err
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CancelledExport remembers an export that was deleted before it finished, so that
+// the applications still working on its sources are told that it was cancelled
+// rather than that it does not exist.
+type CancelledExport struct {
+	ID             uuid.UUID `gorm:"type:uuid;primarykey"`
+	OrganizationID string
+	CancelledAt    time.Time
+	// Expires is when the export is forgotten
+	Expires time.Time
+}
+
+// cancelExports records the deleted exports that had not finished.
+func (edb *ExportDB) cancelExports(deleted []ExportPayload) error {
+	if edb.Cfg == nil || edb.Cfg.CancelledExportRetention <= 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, export := range deleted {
+		if export.Status != Pending && export.Status != Running {
+			continue
+		}
+		cancelled := CancelledExport{
+			ID:             export.ID,
+			OrganizationID: export.OrganizationID,
+			CancelledAt:    now,
+			Expires:        now.Add(edb.Cfg.CancelledExportRetention),
+		}
+		if err := edb.DB.Create(&cancelled).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel stops the export of the user while it is pending or running and cancels its
+// pending sources, which are returned. Unlike Delete the export is kept, with the
+// sources that completed. ErrStatusConflict is returned once the export is being
+// packaged or has finished.
+func (edb *ExportDB) Cancel(exportUUID uuid.UUID, user User) (*ExportPayload, []Source, error) {
+	export, err := edb.GetWithUser(exportUUID, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	values := ExportPayload{Status: Cancelled, CompletedAt: &now}
+	if err := edb.UpdateStatus(export, []PayloadStatus{Pending, Running}, values); err != nil {
+		return nil, nil, err
+	}
+
+	// the uploads racing the cancellation either resolved their source first or find
+	// it cancelled
+	var cancelled []Source
+	err = edb.DB.Model(&cancelled).
+		Clauses(clause.Returning{}).
+		Where("export_payload_id = ? AND status = ?", exportUUID, RPending).
+		Updates(map[string]interface{}{"status": RCancelled, "resolved_at": now}).
+		Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	export, err = edb.GetWithUser(exportUUID, user)
+	return export, cancelled, err
+}
+
+// GetCancelledExport returns the cancelled export, ErrRecordNotFound is returned once
+// it has been forgotten.
+func (edb *ExportDB) GetCancelledExport(exportUUID uuid.UUID) (*CancelledExport, error) {
+	var cancelled CancelledExport
+	err := edb.DB.Where("id = ? AND expires > now()", exportUUID).Take(&cancelled).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+	return &cancelled, err
+}
+
+// DeleteExpiredCancelledExports forgets the cancelled exports past their retention.
+func (edb *ExportDB) DeleteExpiredCancelledExports() (int64, error) {
+	result := edb.DB.Where("expires <= now()").Delete(&CancelledExport{})
+	return result.RowsAffected, result.Error
+}
@@ -1,8 +1,10 @@
 package models
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redhatinsights/export-service-go/config"
@@ -11,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
 )
 
 // APIExport represents select fields of the ExportPayload which are returned to the user
@@ -22,6 +25,7 @@ type APIExport struct {
 	Expires     *time.Time `json:"expires_at,omitempty"`
 	Format      string     `json:"format"`
 	Status      string     `json:"status"`
+	RecordCount *int64     `json:"record_count,omitempty" description:"The number of records reported by the applications of the sources, advisory"`
 }
 
 type ExportDB struct {
@@ -29,32 +33,267 @@ type ExportDB struct {
 	Cfg *config.ExportConfig
 }
 
+// ReplicaResolver is the name the read-only replica of the database is registered
+// under by db.UseReplica. Only the statements that use it are read from the replica,
+// the others and the transactions stay on the primary.
+const ReplicaResolver = "replica"
+
+// fromReplica reads the statement from the replica of the database, or from the
+// primary when no replica is registered. The replica lags behind the primary, it only
+// serves the reads that can be slightly stale.
+func fromReplica(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Use(ReplicaResolver), dbresolver.Read)
+}
+
 type DBInterface interface {
 	APIList(user User, params *QueryParams, offset, limit int, sort, dir string) (result []*APIExport, count int64, err error)
+	AdminList(orgID string, params *QueryParams, offset, limit int, sort, dir string) (result []*ExportPayload, count int64, err error)
 
 	Create(payload *ExportPayload) (result *ExportPayload, err error)
-	Delete(exportUUID uuid.UUID, user User) error
+	GetByIdempotencyKey(key string, user User) (*ExportPayload, error)
+	ReleaseIdempotencyKeys(key string, user User, before time.Time) error
+	Delete(exportUUID uuid.UUID, user User) (*ExportPayload, error)
+	Restore(exportUUID uuid.UUID, user User) (*ExportPayload, error)
+	Cancel(exportUUID uuid.UUID, user User) (*ExportPayload, []Source, error)
+	ClaimOutbox(retryBefore time.Time, limit int) ([]OutboxMessage, error)
+	DiscardOutboxMessage(id int64) error
+	DeleteMany(user User, exportUUIDs []uuid.UUID, params *QueryParams, limit int) ([]ExportPayload, error)
 	Get(exportUUID uuid.UUID) (result *ExportPayload, err error)
 	GetWithUser(exportUUID uuid.UUID, user User) (result *ExportPayload, err error)
+	GetManyWithOrg(exportUUIDs []uuid.UUID, orgID string) (result []*ExportPayload, err error)
+	// GetWithUserFromReplica and GetManyWithOrgFromReplica read the polled statuses
+	// from the replica of the database, the exports that did not reach it yet are
+	// read from the primary.
+	GetWithUserFromReplica(exportUUID uuid.UUID, user User) (result *ExportPayload, err error)
+	GetManyWithOrgFromReplica(exportUUIDs []uuid.UUID, orgID string) (result []*ExportPayload, err error)
 	List(user User) (result []*ExportPayload, err error)
+	ListWithSourceObjects() (result []*ExportPayload, err error)
 	Raw(sql string, values ...interface{}) *gorm.DB
 	Updates(m *ExportPayload, values interface{}) error
-	DeleteExpiredExports() error
+	UpdateStatus(m *ExportPayload, from []PayloadStatus, values ExportPayload) error
+	ListExpiredExports() (result []*ExportPayload, err error)
+	DeleteExpiredExports() ([]ExportPayload, error)
+	ListPurgeableExports() (result []*ExportPayload, err error)
+	PurgeDeletedExports() ([]ExportPayload, error)
+	ExportS3Keys(exportUUIDs []uuid.UUID) (map[uuid.UUID]string, error)
+	TimeOutSources(timeouts map[string]time.Duration, defaultTimeout time.Duration) ([]Source, error)
+	ClaimExpiringExports(window time.Duration, limit int) ([]ExportPayload, error)
+	ReleaseExpiryWarning(exportUUID uuid.UUID) error
+
+	// TryLock takes the advisory lock of the key without waiting, ErrLocked is returned
+	// while another session holds it.
+	TryLock(key int64) (unlock func(), err error)
+
+	CreatePartitions(months int) error
+	ListExpiredPartitions() ([]Partition, error)
+	DropPartition(p Partition) ([]ExportPayload, error)
+
+	GetCancelledExport(exportUUID uuid.UUID) (*CancelledExport, error)
+	DeleteExpiredCancelledExports() (int64, error)
+
+	GetOrgStorage(orgID string) (OrgStorage, error)
+	SetOrgQuota(orgID string, quota *int64) (OrgStorage, error)
+	CountOrgExports(orgID string) (OrgExports, error)
+
+	RecordDownload(d Download) error
+	ListDownloads(exportUUID uuid.UUID) ([]Download, error)
+
+	RecordPSKUse(pskID string, at time.Time) error
+	ListPSKUses() (map[string]time.Time, error)
+
+	RecordAuditEvents(events []AuditEvent) error
+	ListAuditEvents(filter AuditFilter, offset, limit int) (result []AuditEvent, count int64, err error)
+
+	CreateSchedule(schedule *Schedule) (*Schedule, error)
+	GetSchedule(scheduleUUID uuid.UUID, user User) (*Schedule, error)
+	ListSchedules(user User, offset, limit int, dir string) (result []*Schedule, count int64, err error)
+	DeleteSchedule(scheduleUUID uuid.UUID, user User) error
+	DueSchedules(t time.Time) ([]*Schedule, error)
+	ClaimScheduleRun(schedule *Schedule, t, next time.Time) (bool, error)
+	SetScheduleExport(scheduleUUID, exportUUID uuid.UUID) error
+
+	// WithContext returns a copy whose queries are bound to the context, they are
+	// aborted once it is done.
+	WithContext(ctx context.Context) DBInterface
 }
 
 var ErrRecordNotFound = errors.New("record not found")
 
+// ErrDuplicateIdempotencyKey is returned when an export of the user was created with
+// the same Idempotency-Key concurrently.
+var ErrDuplicateIdempotencyKey = errors.New("an export was already created with the idempotency key")
+
+// ErrStatusConflict is returned when the status of an export changed, or the export
+// was deleted, before it could be updated.
+var ErrStatusConflict = errors.New("the status of the export changed concurrently")
+
+func (edb *ExportDB) WithContext(ctx context.Context) DBInterface {
+	return &ExportDB{DB: edb.DB.WithContext(ctx), Cfg: edb.Cfg}
+}
+
+// Create creates the export along with the outbox messages requesting the data of its
+// sources, in a single transaction.
 func (edb *ExportDB) Create(payload *ExportPayload) (*ExportPayload, error) {
-	result := edb.DB.Create(&payload)
-	return payload, result.Error
+	err := edb.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payload).Error; err != nil {
+			return err
+		}
+		if payload.IdempotencyKey != nil {
+			key := IdempotencyKey{
+				IdempotencyKey:  *payload.IdempotencyKey,
+				User:            payload.User,
+				ExportPayloadID: payload.ID,
+				ExportCreatedAt: payload.CreatedAt,
+			}
+			if err := tx.Create(&key).Error; err != nil {
+				return err
+			}
+		}
+		messages := newOutboxMessages(payload)
+		if len(messages) == 0 {
+			return nil
+		}
+		return tx.Create(&messages).Error
+	})
+	if payload.IdempotencyKey != nil && isUniqueViolation(err) {
+		return payload, ErrDuplicateIdempotencyKey
+	}
+	if err == nil {
+		createdExports.WithLabelValues(string(payload.Format)).Inc()
+	}
+	return payload, err
 }
 
-func (edb *ExportDB) Delete(exportUUID uuid.UUID, user User) error {
-	result := edb.DB.Where(&ExportPayload{ID: exportUUID, User: user}).Delete(&ExportPayload{})
-	if result.RowsAffected == 0 {
-		return ErrRecordNotFound
+// isUniqueViolation reports whether the error is the violation of a unique index.
+func isUniqueViolation(err error) bool {
+	var sqlErr interface{ SQLState() string }
+	return errors.As(err, &sqlErr) && sqlErr.SQLState() == "23505"
+}
+
+// GetByIdempotencyKey returns the export of the user created with the Idempotency-Key,
+// along with its sources.
+func (edb *ExportDB) GetByIdempotencyKey(key string, user User) (result *ExportPayload, err error) {
+	err = ownedBy(edb.DB.Model(&ExportPayload{}).Where("export_payloads.idempotency_key = ?", key), user).
+		Preload("Sources").
+		Take(&result).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, ErrRecordNotFound
+	}
+	return
+}
+
+// ReleaseIdempotencyKeys frees the Idempotency-Key of the exports of the user created
+// before the given time, so that the key creates a new export.
+func (edb *ExportDB) ReleaseIdempotencyKeys(key string, user User, before time.Time) error {
+	return edb.DB.Transaction(func(tx *gorm.DB) error {
+		err := tableOwnedBy(tx.Where("idempotency_key = ? AND export_created_at < ?", key, before), "export_idempotency_keys", user).
+			Delete(&IdempotencyKey{}).
+			Error
+		if err != nil {
+			return err
+		}
+		return ownedBy(tx.Model(&ExportPayload{}).Where("export_payloads.idempotency_key = ? AND export_payloads.created_at < ?", key, before), user).
+			Update("idempotency_key", nil).
+			Error
+	})
+}
+
+// Delete deletes the export of the user and returns it, along with its sources. Exports
+// that are being packaged can not be deleted, ErrStatusConflict is returned for them.
+// The export is only marked as deleted, so that it can be restored until it is purged.
+// The exports deleted before they finished are cancelled and recorded as such.
+func (edb *ExportDB) Delete(exportUUID uuid.UUID, user User) (*ExportPayload, error) {
+	export, err := edb.GetWithUser(exportUUID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []ExportPayload
+	err = edb.DB.Transaction(func(tx *gorm.DB) error {
+		result := ownedBy(tx.Where(&ExportPayload{ID: exportUUID}), user).
+			Where("status IS DISTINCT FROM ?", Packaging).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "organization_id"}, {Name: "stored_bytes"}, {Name: "status"}}}).
+			Delete(&deleted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := ownedBy(tx.Model(&ExportPayload{}).Where(&ExportPayload{ID: exportUUID}), user).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrStatusConflict
+			}
+			return ErrRecordNotFound
+		}
+		return (&ExportDB{DB: tx, Cfg: edb.Cfg}).softDeleted(deleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the status may have changed since the export was read
+	export.Status = deleted[0].Status
+	return export, nil
+}
+
+// DeleteMany deletes, in a single transaction, up to limit exports of the user among
+// exportUUIDs, or the oldest matching params when there are no ids, like Delete does.
+// The exports being packaged are kept. The deleted exports that had not finished are
+// returned with their pending sources, so that the applications can be told to stop
+// working on them.
+func (edb *ExportDB) DeleteMany(user User, exportUUIDs []uuid.UUID, params *QueryParams, limit int) ([]ExportPayload, error) {
+	var deleted []ExportPayload
+	err := edb.DB.Transaction(func(tx *gorm.DB) error {
+		matching := ownedBy(tx.Model(&ExportPayload{}), user).
+			Where("export_payloads.status IS DISTINCT FROM ?", Packaging)
+		if len(exportUUIDs) > 0 {
+			matching = matching.Where("export_payloads.id IN ?", exportUUIDs)
+		} else {
+			matching = filterQuery(matching, params)
+		}
+
+		var ids []uuid.UUID
+		err := matching.
+			Group("export_payloads.id").
+			Order("export_payloads.created_at").
+			Limit(limit).
+			Pluck("export_payloads.id", &ids).
+			Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		var pending []Source
+		if err := tx.Where("export_payload_id IN ? AND status = ?", ids, RPending).Find(&pending).Error; err != nil {
+			return err
+		}
+
+		err = tx.Where("id IN ? AND status IS DISTINCT FROM ?", ids, Packaging).
+			Clauses(clause.Returning{}).
+			Delete(&deleted).
+			Error
+		if err != nil {
+			return err
+		}
+		for i, export := range deleted {
+			if export.Status != Pending && export.Status != Running {
+				continue
+			}
+			for _, source := range pending {
+				if source.ExportPayloadID == export.ID {
+					deleted[i].Sources = append(deleted[i].Sources, source)
+				}
+			}
+		}
+
+		return (&ExportDB{DB: tx, Cfg: edb.Cfg}).softDeleted(deleted)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return result.Error
+	return deleted, nil
 }
 
 func (edb *ExportDB) Get(exportUUID uuid.UUID) (result *ExportPayload, err error) {
@@ -70,8 +309,20 @@ func (edb *ExportDB) Get(exportUUID uuid.UUID) (result *ExportPayload, err error
 }
 
 func (edb *ExportDB) GetWithUser(exportUUID uuid.UUID, user User) (result *ExportPayload, err error) {
-	err = (edb.DB.Model(&ExportPayload{}).
-		Where(&ExportPayload{ID: exportUUID, User: user}).
+	return getWithUser(edb.DB, exportUUID, user)
+}
+
+func (edb *ExportDB) GetWithUserFromReplica(exportUUID uuid.UUID, user User) (*ExportPayload, error) {
+	result, err := getWithUser(fromReplica(edb.DB), exportUUID, user)
+	if err == ErrRecordNotFound {
+		// e.g. an export polled right after its creation
+		return getWithUser(edb.DB, exportUUID, user)
+	}
+	return result, err
+}
+
+func getWithUser(db *gorm.DB, exportUUID uuid.UUID, user User) (result *ExportPayload, err error) {
+	err = (ownedBy(db.Model(&ExportPayload{}).Where(&ExportPayload{ID: exportUUID}), user).
 		Preload("Sources").
 		Take(&result)).
 		Error
@@ -81,12 +332,93 @@ func (edb *ExportDB) GetWithUser(exportUUID uuid.UUID, user User) (result *Expor
 	return
 }
 
+// GetManyWithOrg returns the exports of the organization among the given ones, along
+// with their sources, in a single query. The exports of other organizations are left
+// out.
+func (edb *ExportDB) GetManyWithOrg(exportUUIDs []uuid.UUID, orgID string) (result []*ExportPayload, err error) {
+	return getManyWithOrg(edb.DB, exportUUIDs, orgID)
+}
+
+func (edb *ExportDB) GetManyWithOrgFromReplica(exportUUIDs []uuid.UUID, orgID string) ([]*ExportPayload, error) {
+	result, err := getManyWithOrg(fromReplica(edb.DB), exportUUIDs, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[uuid.UUID]bool{}
+	for _, export := range result {
+		found[export.ID] = true
+	}
+	var missing []uuid.UUID
+	for _, exportUUID := range exportUUIDs {
+		if !found[exportUUID] {
+			missing = append(missing, exportUUID)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	fromPrimary, err := getManyWithOrg(edb.DB, missing, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return append(result, fromPrimary...), nil
+}
+
+func getManyWithOrg(db *gorm.DB, exportUUIDs []uuid.UUID, orgID string) (result []*ExportPayload, err error) {
+	err = db.Model(&ExportPayload{}).
+		Where("id IN ? AND organization_id = ?", exportUUIDs, orgID).
+		Preload("Sources").
+		Find(&result).
+		Error
+	return
+}
+
+// APIList lists the exports from the replica of the database, they are polled by the
+// dashboards.
 func (edb *ExportDB) APIList(user User, params *QueryParams, offset, limit int, sort, dir string) (result []*APIExport, count int64, err error) {
-	db := edb.DB.Model(&ExportPayload{}).Where(&ExportPayload{User: user})
+	db := ownedBy(fromReplica(edb.DB).Model(&ExportPayload{}), user)
+
+	db = filterQuery(db, params)
 
-	// filter by name, export status, created, expires, application, resource
+	// count total records
+	db.Count(&count)
+
+	// order by sort and dir params, the column is qualified since the sources share
+	// the status, and the id keeps the order of equal values stable across pages
+	db = db.Order(fmt.Sprintf("export_payloads.%s %s, export_payloads.id %s", sort, dir, dir)).Limit(limit).Offset(offset)
+
+	err = db.Find(&result).Error
+
+	return
+}
+
+// ownedBy restricts the query to the exports of the user. Unlike struct conditions,
+// which skip zero values, empty fields have to match as well, so that an identity
+// without a username or account can not see the exports of others.
+func ownedBy(db *gorm.DB, user User) *gorm.DB {
+	return tableOwnedBy(db, "export_payloads", user)
+}
+
+// tableOwnedBy restricts the query to the rows of the table owned by the user.
+func tableOwnedBy(db *gorm.DB, table string, user User) *gorm.DB {
+	return db.Where(
+		fmt.Sprintf("%[1]s.account_id = ? AND %[1]s.organization_id = ? AND %[1]s.username = ? AND %[1]s.identity_type = ?", table),
+		user.AccountID, user.OrganizationID, user.Username, user.IdentityType,
+	)
+}
+
+// filterQuery applies the name, export status, created, expires, application and
+// resource filters of the query params, and the ranges of the created and expires
+// dates.
+func filterQuery(db *gorm.DB, params *QueryParams) *gorm.DB {
 	if params.Name != "" {
-		db = db.Where("export_payloads.name = ?", params.Name)
+		// a case-insensitive match of any part of the name
+		db = db.Where("export_payloads.name ILIKE ?", "%"+escapeLike(params.Name)+"%")
+	}
+
+	if params.RequestID != "" {
+		db = db.Where("export_payloads.request_id = ?", params.RequestID)
 	}
 
 	if params.Status != "" {
@@ -101,6 +433,19 @@ func (edb *ExportDB) APIList(user User, params *QueryParams, offset, limit int,
 		db = db.Where("export_payloads.expires BETWEEN ? AND ?", params.Expires, params.Expires.AddDate(0, 0, 1))
 	}
 
+	if !params.CreatedGTE.IsZero() {
+		db = db.Where("export_payloads.created_at >= ?", params.CreatedGTE)
+	}
+	if !params.CreatedLTE.IsZero() {
+		db = db.Where("export_payloads.created_at <= ?", params.CreatedLTE)
+	}
+	if !params.ExpiresGTE.IsZero() {
+		db = db.Where("export_payloads.expires >= ?", params.ExpiresGTE)
+	}
+	if !params.ExpiresLTE.IsZero() {
+		db = db.Where("export_payloads.expires <= ?", params.ExpiresLTE)
+	}
+
 	// filtering by application and resource, joining the export_payloads table to the sources table
 	if params.Application != "" || params.Resource != "" {
 		db = db.Joins("JOIN sources ON sources.export_payload_id = export_payloads.id")
@@ -114,20 +459,51 @@ func (edb *ExportDB) APIList(user User, params *QueryParams, offset, limit int,
 		}
 	}
 
-	// count total records
+	return db
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, with the default backslash
+// escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike returns the value as a LIKE pattern matching it literally.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// AdminList returns the exports of every organization, or of a single organization
+// when orgID is given, including their sources. It is only meant for the private API.
+func (edb *ExportDB) AdminList(orgID string, params *QueryParams, offset, limit int, sort, dir string) (result []*ExportPayload, count int64, err error) {
+	db := edb.DB.Model(&ExportPayload{})
+	if orgID != "" {
+		db = db.Where("export_payloads.organization_id = ?", orgID)
+	}
+
+	db = filterQuery(db, params)
+
 	db.Count(&count)
 
-	// order by sort and dir params
-	db = db.Order(fmt.Sprintf("%s %s", sort, dir)).Limit(limit).Offset(offset)
+	db = db.Order(fmt.Sprintf("export_payloads.%s %s, export_payloads.id %s", sort, dir, dir)).Limit(limit).Offset(offset)
 
-	err = db.Find(&result).Error
+	// only select the export columns, the sources may have been joined for filtering
+	err = db.Select("export_payloads.*").Preload("Sources").Find(&result).Error
 
 	return
 }
 
 func (edb *ExportDB) List(user User) (result []*ExportPayload, err error) {
+	err = (ownedBy(edb.DB.Model(&ExportPayload{}), user).
+		Find(&result).Error)
+	return
+}
+
+// ListWithSourceObjects returns the packaged exports whose raw per-source objects
+// have not been removed from the bucket yet.
+func (edb *ExportDB) ListWithSourceObjects() (result []*ExportPayload, err error) {
 	err = (edb.DB.Model(&ExportPayload{}).
-		Where(&ExportPayload{User: user}).
+		Where("status IN ?", []PayloadStatus{Complete, CompleteWithErrors}).
+		Where("source_objects_deleted = ?", false).
+		Preload("Sources").
 		Find(&result).Error)
 	return
 }
@@ -136,30 +512,79 @@ func (edb *ExportDB) Updates(m *ExportPayload, values interface{}) error {
 	return edb.DB.Model(m).Updates(values).Error
 }
 
+// UpdateStatus updates the export only while its status is one of from.
+func (edb *ExportDB) UpdateStatus(m *ExportPayload, from []PayloadStatus, values ExportPayload) error {
+	result := edb.DB.Model(m).Where("status IN ?", from).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusConflict
+	}
+	return nil
+}
+
 func (edb *ExportDB) Raw(sql string, values ...interface{}) *gorm.DB {
 	return edb.DB.Raw(sql, values...)
 }
 
-func (edb *ExportDB) DeleteExpiredExports() error {
+// deletionTime is the time the cleanup deletes an export at, the expiry days after it
+// expired.
+func (edb *ExportDB) deletionTime() string {
+	return fmt.Sprintf("expires + interval '%d days'", edb.Cfg.ExportExpiryDays)
+}
+
+// expiredExportsClause matches the exports that expired longer than the expiry days
+// ago.
+func (edb *ExportDB) expiredExportsClause() string {
+	return "now() > " + edb.deletionTime()
+}
+
+// ListExpiredExports returns the exports DeleteExpiredExports would delete.
+func (edb *ExportDB) ListExpiredExports() (result []*ExportPayload, err error) {
+	err = edb.DB.Model(&ExportPayload{}).Where(edb.expiredExportsClause()).Order("expires").Find(&result).Error
+	return
+}
+
+// expiredExportColumns are the columns of the expired exports returned by the
+// cleanups
+var expiredExportColumns = []string{"id", "account_id", "organization_id", "username", "identity_type", "stored_bytes", "expires"}
+
+// DeleteExpiredExports deletes the expired exports and returns them. The deleted exports
+// are left to PurgeDeletedExports.
+func (edb *ExportDB) DeleteExpiredExports() ([]ExportPayload, error) {
 	log := logger.Get()
 
-	columnsToReturn := []clause.Column{{Name: "id"}, {Name: "account_id"}, {Name: "organization_id"}, {Name: "username"}}
-	expiredExportsClause := fmt.Sprintf("now() > expires + interval '%d days'", edb.Cfg.ExportExpiryDays)
+	columnsToReturn := make([]clause.Column, 0, len(expiredExportColumns))
+	for _, column := range expiredExportColumns {
+		columnsToReturn = append(columnsToReturn, clause.Column{Name: column})
+	}
 
 	var deletedExports []ExportPayload
-	err := edb.DB.Clauses(clause.Returning{Columns: columnsToReturn}).Where(expiredExportsClause).Delete(&deletedExports).Error
+	err := edb.DB.Unscoped().Clauses(clause.Returning{Columns: columnsToReturn}).
+		Where(edb.expiredExportsClause()).
+		Where("deleted_at IS NULL").
+		Delete(&deletedExports).
+		Error
 	if err != nil {
 		log.Error("Unable to remove expired exports from the database", "error", err)
-		return err
+		return nil, err
 	}
+	expiredExports.Add(float64(len(deletedExports)))
 
 	for _, export := range deletedExports {
 		log.Debugw("Deleted expired export",
 			"id", export.ID,
 			"org_id", export.OrganizationID,
 			"account", export.AccountID,
-			"username", export.Username)
+			"username", export.Username,
+			"identity_type", export.IdentityType)
 	}
 
-	return nil
+	if err := releaseOrgStorage(edb.DB, deletedExports); err != nil {
+		log.Error("Unable to release the storage of expired exports", "error", err)
+		return deletedExports, err
+	}
+
+	return deletedExports, nil
 }
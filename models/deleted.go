@@ -0,0 +1,135 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// softDeleted completes the deletion of the exports marked as deleted. Their storage
+// is released, their outbox messages discarded and their Idempotency-Key freed at
+// once. The exports that had not finished are cancelled, so that a restored export
+// does not wait for sources its applications were told to drop.
+func (edb *ExportDB) softDeleted(deleted []ExportPayload) error {
+	var ids, unfinished []uuid.UUID
+	for _, export := range deleted {
+		ids = append(ids, export.ID)
+		if export.Status == Pending || export.Status == Running {
+			unfinished = append(unfinished, export.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if len(unfinished) > 0 {
+		now := time.Now()
+		err := edb.DB.Unscoped().Model(&ExportPayload{}).
+			Where("id IN ?", unfinished).
+			Updates(map[string]interface{}{"status": Cancelled, "completed_at": now}).
+			Error
+		if err != nil {
+			return err
+		}
+		err = edb.DB.Model(&Source{}).
+			Where("export_payload_id IN ? AND status = ?", unfinished, RPending).
+			Updates(map[string]interface{}{"status": RCancelled, "resolved_at": now}).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// the sources of the deleted exports are no longer requested
+	err := edb.DB.Model(&OutboxMessage{}).
+		Where("export_payload_id IN ? AND published_at IS NULL", ids).
+		Update("published_at", time.Now()).
+		Error
+	if err != nil {
+		return err
+	}
+
+	if err := edb.DB.Where("export_payload_id IN ?", ids).Delete(&IdempotencyKey{}).Error; err != nil {
+		return err
+	}
+	err = edb.DB.Unscoped().Model(&ExportPayload{}).
+		Where("id IN ? AND idempotency_key IS NOT NULL", ids).
+		Update("idempotency_key", nil).
+		Error
+	if err != nil {
+		return err
+	}
+
+	if err := releaseOrgStorage(edb.DB, deleted); err != nil {
+		return err
+	}
+	return edb.cancelExports(deleted)
+}
+
+// deletedBefore returns the time before which the deleted exports are purged.
+func (edb *ExportDB) deletedBefore() time.Time {
+	var retention time.Duration
+	if edb.Cfg != nil {
+		retention = edb.Cfg.DeletedExportRetention
+	}
+	return time.Now().Add(-retention)
+}
+
+// Restore restores the deleted export of the user and returns it, along with its
+// sources. ErrRecordNotFound is returned once the export was purged, ErrStatusConflict
+// when it was not deleted. The exports deleted before they finished stay cancelled.
+func (edb *ExportDB) Restore(exportUUID uuid.UUID, user User) (*ExportPayload, error) {
+	err := edb.DB.Transaction(func(tx *gorm.DB) error {
+		var restored []ExportPayload
+		result := ownedBy(tx.Unscoped().Model(&restored).Where("export_payloads.id = ? AND export_payloads.deleted_at > ?", exportUUID, edb.deletedBefore()), user).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "organization_id"}, {Name: "stored_bytes"}}}).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := ownedBy(tx.Model(&ExportPayload{}).Where(&ExportPayload{ID: exportUUID}), user).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrStatusConflict
+			}
+			return ErrRecordNotFound
+		}
+		return reclaimOrgStorage(tx, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return edb.GetWithUser(exportUUID, user)
+}
+
+// ListPurgeableExports returns the exports PurgeDeletedExports would purge.
+func (edb *ExportDB) ListPurgeableExports() (result []*ExportPayload, err error) {
+	err = edb.DB.Unscoped().Model(&ExportPayload{}).
+		Where("deleted_at <= ?", edb.deletedBefore()).
+		Order("deleted_at").
+		Find(&result).
+		Error
+	return
+}
+
+// PurgeDeletedExports deletes for good the exports deleted longer than the
+// DeletedExportRetention ago and returns them, so that their objects can be removed.
+// Their storage was released when they were deleted.
+func (edb *ExportDB) PurgeDeletedExports() ([]ExportPayload, error) {
+	var purged []ExportPayload
+	err := edb.DB.Unscoped().
+		Clauses(clause.Returning{}).
+		Where("deleted_at <= ?", edb.deletedBefore()).
+		Delete(&purged).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	purgedExports.Add(float64(len(purged)))
+	return purged, nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Download is a retrieval of the archive of an export, or of the payload of one of
+// its sources, by a user.
+type Download struct {
+	ID              int64     `gorm:"primarykey"`
+	ExportPayloadID uuid.UUID `gorm:"type:uuid"`
+	// ExportCreatedAt is the creation of the export, part of its key
+	ExportCreatedAt time.Time
+	// SourceID is the source whose payload was downloaded, nil for the archive
+	SourceID     *uuid.UUID `gorm:"type:uuid"`
+	DownloadedAt time.Time
+	User
+	// Bytes is the number of bytes sent to the user, 0 for a presigned redirect
+	Bytes int64
+	// Presigned is set when the user was redirected to a presigned url. The
+	// retrieval from the bucket itself can not be seen, so the redirect is counted.
+	Presigned bool
+}
+
+func (Download) TableName() string {
+	return "export_downloads"
+}
+
+// the download is recorded and counted in a single statement so that the count can
+// not drift from the history
+const recordDownloadSQL = `WITH download AS (
+	INSERT INTO export_downloads (export_payload_id, export_created_at, source_id, downloaded_at, account_id, organization_id, username, identity_type, bytes, presigned)
+	VALUES (@export_id, @created_at, @source_id, @downloaded_at, @account_id, @org_id, @username, @identity_type, @bytes, @presigned)
+	RETURNING export_payload_id, export_created_at, downloaded_at
+)
+UPDATE export_payloads
+SET download_count = download_count + 1, last_downloaded_at = GREATEST(last_downloaded_at, download.downloaded_at)
+FROM download WHERE export_payloads.id = download.export_payload_id AND export_payloads.created_at = download.export_created_at`
+
+// RecordDownload adds the download to the history of its export.
+func (edb *ExportDB) RecordDownload(d Download) error {
+	return edb.DB.Exec(recordDownloadSQL, map[string]interface{}{
+		"export_id":     d.ExportPayloadID,
+		"created_at":    d.ExportCreatedAt,
+		"source_id":     d.SourceID,
+		"downloaded_at": d.DownloadedAt,
+		"account_id":    d.AccountID,
+		"org_id":        d.OrganizationID,
+		"username":      d.Username,
+		"identity_type": d.IdentityType,
+		"bytes":         d.Bytes,
+		"presigned":     d.Presigned,
+	}).Error
+}
+
+// ListDownloads returns the downloads of the export, the latest first.
+func (edb *ExportDB) ListDownloads(exportUUID uuid.UUID) ([]Download, error) {
+	downloads := []Download{}
+	err := edb.DB.Where("export_payload_id = ?", exportUUID).Order("downloaded_at DESC, id DESC").Find(&downloads).Error
+	return downloads, err
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// claimExpiringSQL claims the finished exports deleted before the given time that were
+// not warned about yet, %[1]s is the time an export is deleted at
+const claimExpiringSQL = `UPDATE export_payloads SET expiry_warned_at = now()
+WHERE id IN (
+	SELECT id FROM export_payloads
+	WHERE expiry_warned_at IS NULL AND deleted_at IS NULL AND status IN ?
+	AND %[1]s > now() AND %[1]s <= ?
+	ORDER BY expires
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// DeletesAt returns when the cleanup deletes the export, expiryDays after it expires.
+// It is nil for the exports that do not expire.
+func (ep *ExportPayload) DeletesAt(expiryDays int) *time.Time {
+	if ep.Expires == nil {
+		return nil
+	}
+	deletesAt := ep.Expires.AddDate(0, 0, expiryDays)
+	return &deletesAt
+}
+
+// ClaimExpiringExports claims up to limit of the complete and partial exports deleted
+// within the window that were not warned about yet, so that their owners are warned
+// once. The claims of the warnings that could not be sent are released with
+// ReleaseExpiryWarning.
+func (edb *ExportDB) ClaimExpiringExports(window time.Duration, limit int) ([]ExportPayload, error) {
+	var exports []ExportPayload
+	sql := fmt.Sprintf(claimExpiringSQL, edb.deletionTime())
+	err := edb.DB.Raw(sql, []PayloadStatus{Complete, CompleteWithErrors}, time.Now().Add(window), limit).Scan(&exports).Error
+	return exports, err
+}
+
+// ReleaseExpiryWarning releases the claim of the warning of the export, it is claimed
+// again by the next run.
+func (edb *ExportDB) ReleaseExpiryWarning(exportUUID uuid.UUID) error {
+	return edb.DB.Model(&ExportPayload{}).Where("id = ?", exportUUID).Update("expiry_warned_at", nil).Error
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// CleanupLock is the key of the advisory lock held by the replica or the job cleaning
+// up, "export" in ascii. The keys are shared by every user of the database.
+const CleanupLock int64 = 0x6578706f7274
+
+// ErrLocked is returned when the lock is held by another session.
+var ErrLocked = errors.New("the lock is held by another session")
+
+// TryLock takes the advisory lock of the key on a connection of its own, without
+// waiting, and returns the function releasing it. ErrLocked is returned while another
+// session holds it. The lock is released by postgres when the connection is lost, so
+// that a crashed holder does not keep it.
+func (edb *ExportDB) TryLock(key int64) (func(), error) {
+	sqlDB, err := edb.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	// the lock belongs to the session, it is taken and released on the same connection
+	conn, err := sqlDB.Conn(edb.DB.Statement.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(edb.DB.Statement.Context, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to take the advisory lock %d: %w", key, err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrLocked
+	}
+
+	return func() {
+		// the lock is released even once the context of the holder is done
+		var unlocked bool
+		err := conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", key).Scan(&unlocked)
+		if err != nil || !unlocked {
+			// the connection is discarded rather than returned to the pool holding the
+			// lock
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
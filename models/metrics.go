@@ -0,0 +1,81 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package models
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics of the sources are only labelled with their application, the
+// resources are unbounded.
+
+var sourceResolutionSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "export_service_source_resolution_seconds",
+	Help: "Time between the creation of an export and one of its sources reaching a terminal status, by application and status.",
+	// from a second to three days
+	Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+}, []string{"application", "status"})
+
+var resolvedSources = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_resolved_sources_total",
+	Help: "The total number of sources that reached a terminal status, by application and status.",
+}, []string{"application", "status"})
+
+var createdExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_exports_created_total",
+	Help: "The total number of exports created, by format.",
+}, []string{"format"})
+
+var finishedExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_exports_finished_total",
+	Help: "The total number of exports that reached a final status, by status: complete, partial or failed.",
+}, []string{"status"})
+
+var exportDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "export_service_export_duration_seconds",
+	Help: "Time between the creation of an export and its final status, by status.",
+	// from a second to three days, like the sources
+	Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+}, []string{"status"})
+
+var expiredExports = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "export_service_exports_expired_total",
+	Help: "The total number of expired exports deleted by the cleanups of this process.",
+})
+
+var droppedPartitions = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "export_service_partitions_dropped_total",
+	Help: "The total number of monthly partitions of expired exports dropped by the cleanups of this process.",
+})
+
+var purgedExports = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "export_service_purged_exports_total",
+	Help: "The total number of deleted exports purged past their retention by the cleanups of this process.",
+})
+
+// observeSourceResolution records a pending source reaching a terminal status.
+func observeSourceResolution(application string, status ResourceStatus, createdAt time.Time) {
+	sourceResolutionSeconds.WithLabelValues(application, string(status)).Observe(time.Since(createdAt).Seconds())
+	resolvedSources.WithLabelValues(application, string(status)).Inc()
+}
+
+// observeExportFinished records an export reaching a final status.
+func observeExportFinished(status PayloadStatus, createdAt time.Time) {
+	exportDurationSeconds.WithLabelValues(string(status)).Observe(time.Since(createdAt).Seconds())
+	finishedExports.WithLabelValues(string(status)).Inc()
+}
+
+func init() {
+	prometheus.MustRegister(sourceResolutionSeconds)
+	prometheus.MustRegister(resolvedSources)
+	prometheus.MustRegister(createdExports)
+	prometheus.MustRegister(finishedExports)
+	prometheus.MustRegister(exportDurationSeconds)
+	prometheus.MustRegister(expiredExports)
+	prometheus.MustRegister(droppedPartitions)
+	prometheus.MustRegister(purgedExports)
+}
@@ -22,14 +22,100 @@ const (
 	JSON PayloadFormat = "json"
 )
 
+// PayloadFormats are the formats the service is able to export.
+var PayloadFormats = []PayloadFormat{CSV, JSON}
+
+// IsValid reports whether the format is one that the service is able to export.
+func (pf PayloadFormat) IsValid() bool {
+	switch pf {
+	case CSV, JSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// ContentType returns the MIME type used when storing a payload of this format.
+func (pf PayloadFormat) ContentType() string {
+	switch pf {
+	case CSV:
+		return "text/csv"
+	case JSON:
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// FormatFromContentType returns the format described by the given media type. The
+// boolean is false when the media type does not correspond to any known format.
+func FormatFromContentType(mediaType string) (PayloadFormat, bool) {
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return CSV, true
+	case "application/json":
+		return JSON, true
+	default:
+		return "", false
+	}
+}
+
+// ArchiveFormat is the format of the archive an export is packaged into.
+type ArchiveFormat string
+
+const (
+	Zip    ArchiveFormat = "zip"
+	TarGz  ArchiveFormat = "tar.gz"
+	TarZst ArchiveFormat = "tar.zst"
+)
+
+// ArchiveFormats are the formats the service is able to package exports into.
+var ArchiveFormats = []ArchiveFormat{Zip, TarGz, TarZst}
+
+// IsValid reports whether the service is able to package exports into the format.
+func (af ArchiveFormat) IsValid() bool {
+	switch af {
+	case Zip, TarGz, TarZst:
+		return true
+	default:
+		return false
+	}
+}
+
+// ContentType returns the MIME type of an archive of this format.
+func (af ArchiveFormat) ContentType() string {
+	switch af {
+	case Zip:
+		return "application/zip"
+	case TarZst:
+		return "application/zstd"
+	default:
+		return "application/gzip"
+	}
+}
+
 type PayloadStatus string
 
 const (
-	Partial  PayloadStatus = "partial"
-	Pending  PayloadStatus = "pending"
-	Running  PayloadStatus = "running"
-	Complete PayloadStatus = "complete"
-	Failed   PayloadStatus = "failed"
+	Pending   PayloadStatus = "pending"
+	Running   PayloadStatus = "running"
+	Packaging PayloadStatus = "packaging"
+	Complete  PayloadStatus = "complete"
+	Failed    PayloadStatus = "failed"
+	// Cancelled exports were stopped by their user before they finished, they are
+	// never packaged
+	Cancelled PayloadStatus = "cancelled"
+	// CompleteWithErrors exports were packaged with the sources that succeeded while
+	// others failed, it was `partial` before
+	CompleteWithErrors PayloadStatus = "complete_with_errors"
+)
+
+type NotificationStatus string
+
+const (
+	NPending   NotificationStatus = "pending"
+	NDelivered NotificationStatus = "delivered"
+	NFailed    NotificationStatus = "failed"
 )
 
 type ResourceStatus string
@@ -38,16 +124,28 @@ const (
 	RPending ResourceStatus = "pending"
 	RSuccess ResourceStatus = "success"
 	RFailed  ResourceStatus = "failed"
+	// RCancelled sources were pending when their export was cancelled
+	RCancelled ResourceStatus = "cancelled"
 )
 
 // QueryParams for the /export/v1/exports endpoint
 type QueryParams struct {
+	// Name matches any part of the name of the exports, ignoring the case
 	Name        string
 	Created     time.Time
 	Expires     time.Time
 	Status      string
 	Application string
 	Resource    string
+	// the bounds of the created and expires ranges are inclusive, and are ignored
+	// when zero
+	CreatedGTE time.Time
+	CreatedLTE time.Time
+	ExpiresGTE time.Time
+	ExpiresLTE time.Time
+	// RequestID is the X-Request-Id of the request that created the export, only
+	// support filters on it
+	RequestID string
 }
 
 type ExportPayload struct {
@@ -62,7 +160,73 @@ type ExportPayload struct {
 	Status      PayloadStatus `gorm:"type:string"`
 	Sources     []Source      `gorm:"foreignKey:ExportPayloadID"`
 	S3Key       string
+	// SourceObjectsDeleted is set once the raw per-source objects have been removed
+	// from the bucket after packaging
+	SourceObjectsDeleted bool
+	// StoredBytes is the size of the objects of the export that are counted against
+	// the storage quota of its organization
+	StoredBytes int64
+	// Identity is the x-rh-identity header of the request, it is passed on to the
+	// source applications with every announce of a source
+	Identity string
+	// DownloadCount is the number of downloads of the export and its sources,
+	// LastDownloadedAt the time of the latest one
+	DownloadCount    int64
+	LastDownloadedAt *time.Time
+	// EncryptionPublicKey is the armored OpenPGP key the archive is encrypted to,
+	// EncryptionFingerprint its fingerprint. Both are empty for plain archives.
+	EncryptionPublicKey   string
+	EncryptionFingerprint string
+	// OriginalRequest is the normalized request the export was created from, so that
+	// it can be reproduced even if its sources change. It is null for the exports
+	// created before it was kept.
+	OriginalRequest datatypes.JSON
+	// RecordCount is the sum of the record counts reported for the sources, nil
+	// until one is reported
+	RecordCount *int64
+	// ArchiveFormat is the format of the archive, the exports created before it could
+	// be chosen are tar.gz
+	ArchiveFormat ArchiveFormat `gorm:"type:string"`
+	// IdempotencyKey is the Idempotency-Key the export was created with, nil once it
+	// expired or when there was none
+	IdempotencyKey *string
+	// IdempotencyFingerprint identifies the request of the IdempotencyKey, so that the
+	// key can not be reused for another request
+	IdempotencyFingerprint string
+	// DeletedAt is when the export was deleted, it can be restored until it is purged
+	// after the DeletedExportRetention. The deleted exports are left out of the queries
+	// unless they are Unscoped.
+	DeletedAt gorm.DeletedAt
+	// ExpiryWarnedAt is when the owner of the export was warned that it is about to be
+	// deleted, nil until then
+	ExpiryWarnedAt *time.Time
 	User
+	Notification
+}
+
+// IsOwnedBy reports whether the export was requested by the user.
+func (ep *ExportPayload) IsOwnedBy(user User) bool {
+	return ep.User == user
+}
+
+// IdempotencyKey reserves the Idempotency-Key of a user for an export. The unique
+// indexes of the partitioned exports can not span their partitions, the keys are
+// unique in their own table instead.
+type IdempotencyKey struct {
+	IdempotencyKey string
+	User
+	ExportPayloadID uuid.UUID `gorm:"type:uuid"`
+	ExportCreatedAt time.Time
+}
+
+func (IdempotencyKey) TableName() string {
+	return "export_idempotency_keys"
+}
+
+// IsEncrypted reports whether the archive of the export is encrypted to a key of
+// the requester.
+func (ep *ExportPayload) IsEncrypted() bool {
+	return ep.EncryptionPublicKey != ""
 }
 
 type Source struct {
@@ -71,25 +235,92 @@ type Source struct {
 	Application     string
 	Status          ResourceStatus
 	Resource        string
+	Format          PayloadFormat  `gorm:"type:string"`
 	Filters         datatypes.JSON `gorm:"type:json"`
+	// Checksum is the hex encoded sha256 of the uploaded payload, Size its length
+	Checksum string
+	Size     int64
+	// RecordCount is the number of records of the payload, as reported by the
+	// application. It is advisory and nil when the application did not report it.
+	RecordCount *int64
+	// AnnouncedAt is when kafka last confirmed the delivery of the request for the
+	// source's data, DeliveryError is the last error reported for it
+	AnnouncedAt   *time.Time
+	DeliveryError string
+	// ResolvedAt is when the application reported the final status of the source
+	ResolvedAt *time.Time
+	// CreatedAt is the creation of the export of the source, the sources are
+	// partitioned along with their export by it
+	CreatedAt time.Time `gorm:"primarykey"`
 	*SourceError
 }
 
 type SourceError struct {
 	Message string
 	Code    int
+	// Retriable tells the user that the source may succeed in a new export, e.g. once
+	// a transient outage of its application is over
+	Retriable *bool
+}
+
+// SourceFailure describes a source that failed, in the status of its export and in
+// the failures.json of its archive.
+type SourceFailure struct {
+	ID          uuid.UUID `json:"id"`
+	Application string    `json:"application"`
+	Resource    string    `json:"resource"`
+	Code        int       `json:"error" description:"The error code of the source"`
+	Message     string    `json:"message"`
+	Retriable   bool      `json:"retriable"`
 }
 
+// SourceFailures returns the failures of the sources that failed.
+func SourceFailures(sources []Source) []SourceFailure {
+	var failures []SourceFailure
+	for _, source := range sources {
+		if source.Status != RFailed {
+			continue
+		}
+		failure := SourceFailure{ID: source.ID, Application: source.Application, Resource: source.Resource}
+		if source.SourceError != nil {
+			failure.Code, failure.Message = source.Code, source.Message
+			failure.Retriable = source.Retriable != nil && *source.Retriable
+		}
+		failures = append(failures, failure)
+	}
+	return failures
+}
+
+// User is the identity that requested an export.
 type User struct {
 	AccountID      string
 	OrganizationID string
-	Username       string
+	// Username is the requester of the export: the username of a user, the client id
+	// of a service account or the common name of a system certificate
+	Username string
+	// IdentityType is the type of the x-rh-identity, e.g. `User` or `ServiceAccount`
+	IdentityType string
+}
+
+// Notification holds the webhook requested for an export and the outcome of
+// delivering it once the export finished.
+type Notification struct {
+	NotificationURL      string
+	NotificationStatus   NotificationStatus `gorm:"type:string"`
+	NotificationAttempts int
+	NotificationError    string
+	NotifiedAt           *time.Time
 }
 
 func (ep *ExportPayload) BeforeCreate(tx *gorm.DB) (err error) {
 	exportConfig := config.Get()
 
 	ep.ID = uuid.New()
+	if ep.CreatedAt.IsZero() {
+		// the precision of the database, so that the rows referencing the export
+		// match its key
+		ep.CreatedAt = time.Now().Truncate(time.Microsecond)
+	}
 	if ep.Expires == nil {
 		expirationTime := time.Now().AddDate(0, 0, exportConfig.ExportExpiryDays)
 		ep.Expires = &expirationTime
@@ -97,6 +328,7 @@ func (ep *ExportPayload) BeforeCreate(tx *gorm.DB) (err error) {
 	for i := range ep.Sources {
 		ep.Sources[i].ID = uuid.New()
 		ep.Sources[i].ExportPayloadID = ep.ID
+		ep.Sources[i].CreatedAt = ep.CreatedAt
 	}
 	return nil
 }
@@ -124,22 +356,26 @@ func (es *Source) GetFilters() (map[string]string, error) {
 	return filters, err
 }
 
+// The status transitions are compare-and-swaps on the status of the export, they
+// return ErrStatusConflict when another replica changed the status first or the
+// export was deleted.
+
 func (ep *ExportPayload) SetStatusComplete(db DBInterface, t *time.Time, s3key string) error {
 	values := ExportPayload{
 		Status:      Complete,
 		CompletedAt: t,
 		S3Key:       s3key,
 	}
-	return db.Updates(ep, values)
+	return ep.finish(db, []PayloadStatus{Packaging}, values)
 }
 
-func (ep *ExportPayload) SetStatusPartial(db DBInterface, t *time.Time, s3key string) error {
+func (ep *ExportPayload) SetStatusCompleteWithErrors(db DBInterface, t *time.Time, s3key string) error {
 	values := ExportPayload{
-		Status:      Partial,
+		Status:      CompleteWithErrors,
 		CompletedAt: t,
 		S3Key:       s3key,
 	}
-	return db.Updates(ep, values)
+	return ep.finish(db, []PayloadStatus{Packaging}, values)
 }
 
 func (ep *ExportPayload) SetStatusFailed(db DBInterface) error {
@@ -148,28 +384,152 @@ func (ep *ExportPayload) SetStatusFailed(db DBInterface) error {
 		Status:      Failed,
 		CompletedAt: &t,
 	}
-	return db.Updates(ep, values)
+	return ep.finish(db, []PayloadStatus{Pending, Running, Packaging}, values)
 }
 
+// finish moves the export to its final status, only the replica whose transition
+// succeeded records it in the metrics.
+func (ep *ExportPayload) finish(db DBInterface, from []PayloadStatus, values ExportPayload) error {
+	createdAt := ep.CreatedAt
+	if err := db.UpdateStatus(ep, from, values); err != nil {
+		return err
+	}
+	observeExportFinished(values.Status, createdAt)
+	return nil
+}
+
+// SetStatusRunning fails once the export is being packaged, so that it no longer
+// accepts uploads.
 func (ep *ExportPayload) SetStatusRunning(db DBInterface) error {
 	values := ExportPayload{Status: Running}
+	return db.UpdateStatus(ep, []PayloadStatus{Pending, Running}, values)
+}
+
+// SetStatusPackaging claims the packaging of the export. Only one of the replicas
+// processing the last source of the export succeeds.
+func (ep *ExportPayload) SetStatusPackaging(db DBInterface) error {
+	values := ExportPayload{Status: Packaging}
+	return db.UpdateStatus(ep, []PayloadStatus{Running}, values)
+}
+
+// SetSourceObjectsDeleted records that the raw per-source objects of the export have
+// been removed from the bucket.
+func (ep *ExportPayload) SetSourceObjectsDeleted(db DBInterface) error {
+	err := db.Raw(setSourceObjectsDeletedSQL, map[string]interface{}{"bytes": ep.sourceBytes(), "id": ep.ID}).Scan(&OrgStorage{}).Error
+	if err != nil {
+		return err
+	}
+	ep.SourceObjectsDeleted = true
+	return nil
+}
+
+// the bytes of the source objects are only released the first time, so that a
+// retried deletion does not release them twice
+const setSourceObjectsDeletedSQL = `WITH export AS (
+	UPDATE export_payloads
+	SET source_objects_deleted = true, stored_bytes = GREATEST(stored_bytes - @bytes, 0), updated_at = now()
+	WHERE id = @id AND NOT source_objects_deleted
+	RETURNING organization_id
+)
+UPDATE org_storage SET stored_bytes = GREATEST(org_storage.stored_bytes - @bytes, 0), updated_at = now()
+FROM export WHERE org_storage.organization_id = export.organization_id`
+
+// SetNotificationResult records the outcome of the latest attempt to deliver the
+// webhook notification for the export.
+func (ep *ExportPayload) SetNotificationResult(db DBInterface, status NotificationStatus, attempts int, lastError string, t *time.Time) error {
+	values := map[string]interface{}{
+		"notification_status":   status,
+		"notification_attempts": attempts,
+		"notification_error":    lastError,
+		"notified_at":           t,
+	}
 	return db.Updates(ep, values)
 }
 
 func (ep *ExportPayload) SetSourceStatus(db DBInterface, uid uuid.UUID, status ResourceStatus, sourceError *SourceError) error {
-	_, _, err := ep.GetSource(uid)
+	_, source, err := ep.GetSource(uid)
 	if err != nil {
 		return fmt.Errorf("failed to get sources: %w", err)
 	}
 
+	var resolvedAt *time.Time
+	if status != RPending {
+		now := time.Now()
+		resolvedAt = &now
+	}
+
 	var sql *gorm.DB
 	if sourceError == nil {
-		sql = db.Raw("UPDATE sources SET status = ? WHERE id = ?", status, uid)
+		sql = db.Raw("UPDATE sources SET status = ?, resolved_at = ? WHERE id = ?", status, resolvedAt, uid)
 	} else {
 		// the `code` and `message` are user inputs, so they are parameterized to prevent sql injection
-		sql = db.Raw("UPDATE sources SET status = ?, code = ?, message = ? WHERE id = ?", status, sourceError.Code, sourceError.Message, uid)
+		sql = db.Raw("UPDATE sources SET status = ?, resolved_at = ?, code = ?, message = ? WHERE id = ?", status, resolvedAt, sourceError.Code, sourceError.Message, uid)
+	}
+	application, createdAt := source.Application, ep.CreatedAt
+	if err := sql.Scan(&ep).Error; err != nil {
+		return err
+	}
+	if source.Status == RPending && status != RPending {
+		observeSourceResolution(application, status, createdAt)
+	}
+	return nil
+}
+
+// SetSourceUpload records the checksum and size of the payload uploaded for the source.
+func (ep *ExportPayload) SetSourceUpload(db DBInterface, uid uuid.UUID, checksum string, size int64) error {
+	return db.Raw("UPDATE sources SET checksum = ?, size = ? WHERE id = ?", checksum, size, uid).Scan(&ep).Error
+}
+
+// the count of the source and the rollup of the export are updated in a single
+// statement so that they can not drift apart, a source is only counted once
+const setSourceRecordCountSQL = `WITH source AS (
+	UPDATE sources SET record_count = @count
+	WHERE id = @source AND export_payload_id = @id AND record_count IS NULL
+	RETURNING export_payload_id
+)
+UPDATE export_payloads SET record_count = COALESCE(export_payloads.record_count, 0) + @count
+FROM source WHERE export_payloads.id = source.export_payload_id`
+
+// SetSourceRecordCount records the number of records reported for the payload of the
+// source, and adds it to the record count of the export.
+func (ep *ExportPayload) SetSourceRecordCount(db DBInterface, uid uuid.UUID, count int64) error {
+	return db.Raw(setSourceRecordCountSQL, map[string]interface{}{"count": count, "source": uid, "id": ep.ID}).Scan(&ExportPayload{}).Error
+}
+
+// RecordCountComplete reports whether the record count of the export covers all of
+// its sources that did not fail, rather than only the ones whose applications
+// reported it so far.
+func (ep *ExportPayload) RecordCountComplete() bool {
+	for _, source := range ep.Sources {
+		if source.Status != RFailed && source.RecordCount == nil {
+			return false
+		}
+	}
+	return ep.RecordCount != nil
+}
+
+// SetSourceDelivery records the delivery report of the message announcing the source
+// to its application. A successful delivery clears the previous error, and publishes
+// the outbox messages of the source.
+func SetSourceDelivery(db DBInterface, uid uuid.UUID, deliveryError error) error {
+	if deliveryError != nil {
+		return db.Raw("UPDATE sources SET delivery_error = ? WHERE id = ?", deliveryError.Error(), uid).Scan(&Source{}).Error
+	}
+	now := time.Now()
+	if err := db.Raw("UPDATE sources SET announced_at = ?, delivery_error = NULL WHERE id = ?", now, uid).Scan(&Source{}).Error; err != nil {
+		return err
+	}
+	return db.Raw("UPDATE outbox SET published_at = ? WHERE source_id = ? AND published_at IS NULL", now, uid).Scan(&OutboxMessage{}).Error
+}
+
+// SetSourceDeadLettered records that the request for the data of the source was sent
+// to the dead letter topic. The outbox stops sending it, it is only sent again when the
+// dead letter topic is redriven.
+func SetSourceDeadLettered(db DBInterface, uid uuid.UUID, deliveryError error) error {
+	if err := db.Raw("UPDATE sources SET delivery_error = ? WHERE id = ?", deliveryError.Error(), uid).Scan(&Source{}).Error; err != nil {
+		return err
 	}
-	return sql.Scan(&ep).Error
+	return db.Raw("UPDATE outbox SET published_at = ? WHERE source_id = ? AND published_at IS NULL", time.Now(), uid).Scan(&OutboxMessage{}).Error
 }
 
 const (
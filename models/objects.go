@@ -0,0 +1,30 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// ExportS3Keys returns the key of the archive of each of the exports that exist, by
+// id, the exports without an archive map to an empty key. The deleted exports that can
+// still be restored exist, they keep their objects until they are purged.
+func (edb *ExportDB) ExportS3Keys(exportUUIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	var rows []struct {
+		ID    uuid.UUID
+		S3Key string
+	}
+	if len(exportUUIDs) > 0 {
+		err := edb.DB.Unscoped().Model(&ExportPayload{}).
+			Select("id", "s3_key").
+			Where("id IN ?", exportUUIDs).
+			Scan(&rows).
+			Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	keys := make(map[uuid.UUID]string, len(rows))
+	for _, row := range rows {
+		keys[row.ID] = row.S3Key
+	}
+	return keys, nil
+}
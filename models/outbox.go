@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxMessage is a request for the data of a source that is yet to be delivered to
+// its application. The messages are written in the transaction creating the export,
+// so that an export is announced even if the service stops right after creating it.
+type OutboxMessage struct {
+	ID              int64     `gorm:"primarykey"`
+	ExportPayloadID uuid.UUID `gorm:"type:uuid"`
+	// ExportCreatedAt is the creation of the export, part of its key
+	ExportCreatedAt time.Time
+	SourceID        uuid.UUID `gorm:"type:uuid"`
+	CreatedAt       time.Time
+	// SentAt is when the message was last handed to the producer, Attempts how many
+	// times it was
+	SentAt   *time.Time
+	Attempts int
+	// PublishedAt is when kafka reported the delivery of the message, or when it was
+	// discarded because its source was no longer pending
+	PublishedAt *time.Time
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// newOutboxMessages returns the messages requesting the data of the sources of the
+// export.
+func newOutboxMessages(payload *ExportPayload) []OutboxMessage {
+	messages := make([]OutboxMessage, 0, len(payload.Sources))
+	for _, source := range payload.Sources {
+		messages = append(messages, OutboxMessage{ExportPayloadID: payload.ID, ExportCreatedAt: payload.CreatedAt, SourceID: source.ID})
+	}
+	return messages
+}
+
+// the messages are claimed by a single replica at a time, a claimed message is sent
+// again once it has gone without a delivery report since the given time
+const claimOutboxSQL = `UPDATE outbox SET sent_at = now(), attempts = attempts + 1
+WHERE id IN (
+	SELECT id FROM outbox
+	WHERE published_at IS NULL AND (sent_at IS NULL OR sent_at < ?)
+	ORDER BY id
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// ClaimOutbox claims up to limit messages to send, those never sent and those sent
+// before retryBefore without being delivered.
+func (edb *ExportDB) ClaimOutbox(retryBefore time.Time, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := edb.DB.Raw(claimOutboxSQL, retryBefore, limit).Scan(&messages).Error
+	return messages, err
+}
+
+// DiscardOutboxMessage stops sending the message, e.g. because its source is no longer
+// pending.
+func (edb *ExportDB) DiscardOutboxMessage(id int64) error {
+	return edb.DB.Model(&OutboxMessage{}).Where("id = ?", id).Update("published_at", time.Now()).Error
+}
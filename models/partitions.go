@@ -0,0 +1,142 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The exports and their sources are partitioned by the month the export was created
+// in, in UTC. The partitions are named after their month, e.g. export_payloads_y2022m05
+// and sources_y2022m05, the rows outside of them are in the default partitions.
+const partitionLayout = "y2006m01"
+
+// PartitionMonthsAhead is the number of months whose partitions are created ahead,
+// so that the exports never fall in the default partition
+const PartitionMonthsAhead = 3
+
+// the tables referencing the exports, their rows are deleted along with the exports
+var exportReferences = []string{"export_downloads", "outbox", "export_idempotency_keys"}
+
+// Partition is a month of exports and of their sources.
+type Partition struct {
+	// Month is the start of the month, in UTC
+	Month time.Time
+}
+
+// PartitionOf returns the partition of the exports created at the time.
+func PartitionOf(t time.Time) Partition {
+	t = t.UTC()
+	return Partition{Month: time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (p Partition) String() string {
+	return p.Month.Format(partitionLayout)
+}
+
+// End is the start of the next month, the exports of the partition were created
+// before it.
+func (p Partition) End() time.Time {
+	return p.Month.AddDate(0, 1, 0)
+}
+
+// Table returns the name of the partition of the table, export_payloads or sources.
+func (p Partition) Table(parent string) string {
+	return parent + "_" + p.String()
+}
+
+// ParsePartition returns the partition of the table named after it, the default
+// partition is not one.
+func ParsePartition(parent, table string) (Partition, bool) {
+	if !strings.HasPrefix(table, parent+"_") {
+		return Partition{}, false
+	}
+	month, err := time.Parse(partitionLayout, strings.TrimPrefix(table, parent+"_"))
+	if err != nil {
+		return Partition{}, false
+	}
+	return Partition{Month: month}, true
+}
+
+// CreatePartitions creates the partitions of the current month and of the months
+// ahead, the partitions that exist are left untouched.
+func (edb *ExportDB) CreatePartitions(months int) error {
+	return edb.DB.Exec("SELECT create_export_partitions(now(), now() + make_interval(months => ?))", months).Error
+}
+
+const listPartitionsSQL = `SELECT child.relname FROM pg_inherits
+JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+WHERE parent.relname = 'export_payloads'`
+
+// ListExpiredPartitions returns the partitions of the past months whose exports all
+// expired, DropPartition drops them. The oldest partition comes first.
+func (edb *ExportDB) ListExpiredPartitions() ([]Partition, error) {
+	var tables []string
+	if err := edb.DB.Raw(listPartitionsSQL).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var expired []Partition
+	for _, table := range tables {
+		p, ok := ParsePartition("export_payloads", table)
+		if !ok || p.End().After(now) {
+			continue
+		}
+		var live bool
+		err := edb.DB.Raw(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE expires IS NULL OR NOT (%s))", p.Table("export_payloads"), edb.expiredExportsClause())).
+			Scan(&live).
+			Error
+		if err != nil {
+			return nil, err
+		}
+		if !live {
+			expired = append(expired, p)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Month.Before(expired[j].Month) })
+	return expired, nil
+}
+
+// DropPartition drops the partition of the exports and of their sources, and returns
+// its exports like DeleteExpiredExports. The rows referencing the exports are deleted
+// first, the partition can not be detached while they exist.
+func (edb *ExportDB) DropPartition(p Partition) ([]ExportPayload, error) {
+	var dropped []ExportPayload
+	err := edb.DB.Transaction(func(tx *gorm.DB) error {
+		// the deleted exports already released their storage, they are dropped silently
+		if err := tx.Table(p.Table("export_payloads")).Select(expiredExportColumns).Find(&dropped).Error; err != nil {
+			return err
+		}
+		for _, table := range exportReferences {
+			err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE export_created_at >= ? AND export_created_at < ?", table), p.Month, p.End()).Error
+			if err != nil {
+				return err
+			}
+		}
+		// the sources reference the exports, they go first
+		for _, parent := range []string{"sources", "export_payloads"} {
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", parent, p.Table(parent))).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf("DROP TABLE %s", p.Table(parent))).Error; err != nil {
+				return err
+			}
+		}
+		return releaseOrgStorage(tx, dropped)
+	})
+	if err != nil {
+		return nil, err
+	}
+	expiredExports.Add(float64(len(dropped)))
+	droppedPartitions.Inc()
+	return dropped, nil
+}
@@ -0,0 +1,36 @@
+package models_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+var _ = Describe("Partitions", func() {
+	It("partitions the exports by the month of their creation in UTC", func() {
+		// the first of May in UTC, still April in New York
+		created := time.Date(2022, time.April, 30, 22, 30, 0, 0, time.FixedZone("EDT", -4*60*60))
+		p := models.PartitionOf(created)
+		Expect(p.Month).To(Equal(time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(p.End()).To(Equal(time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(p.String()).To(Equal("y2022m05"))
+		Expect(p.Table("sources")).To(Equal("sources_y2022m05"))
+	})
+
+	DescribeTable("parses the names of the partitions",
+		func(table string, expected string, ok bool) {
+			p, found := models.ParsePartition("export_payloads", table)
+			Expect(found).To(Equal(ok))
+			if ok {
+				Expect(p.String()).To(Equal(expected))
+				Expect(p.Table("export_payloads")).To(Equal(table))
+			}
+		},
+		Entry("a month", "export_payloads_y2022m05", "y2022m05", true),
+		Entry("the default partition", "export_payloads_default", "", false),
+		Entry("a partition of another table", "sources_y2022m05", "", false),
+	)
+})
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// PSKUse is the last use of a psk, identified by its id, to authenticate a request to
+// the internal api.
+type PSKUse struct {
+	PSKID      string `gorm:"column:psk_id;primarykey"`
+	LastUsedAt time.Time
+}
+
+func (PSKUse) TableName() string {
+	return "psk_uses"
+}
+
+// the uses recorded out of order by the replicas never move the last use back
+const recordPSKUseSQL = `INSERT INTO psk_uses (psk_id, last_used_at) VALUES (?, ?)
+ON CONFLICT (psk_id) DO UPDATE SET last_used_at = GREATEST(psk_uses.last_used_at, excluded.last_used_at)`
+
+// RecordPSKUse records that the psk was used at the given time.
+func (edb *ExportDB) RecordPSKUse(pskID string, at time.Time) error {
+	return edb.DB.Exec(recordPSKUseSQL, pskID, at).Error
+}
+
+// ListPSKUses returns the last use of every psk that was used, by psk id.
+func (edb *ExportDB) ListPSKUses() (map[string]time.Time, error) {
+	var uses []PSKUse
+	if err := edb.DB.Find(&uses).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]time.Time, len(uses))
+	for _, use := range uses {
+		result[use.PSKID] = use.LastUsedAt
+	}
+	return result, nil
+}
@@ -50,7 +50,7 @@ var _ = Describe("Purge expired exports", func() {
 				Cfg: exportConfig,
 			}
 
-			err = exportDB.DeleteExpiredExports()
+			_, err = exportDB.DeleteExpiredExports()
 			Expect(err).NotTo(HaveOccurred())
 
 			// Attempt to delete the record that we inserted before using the id
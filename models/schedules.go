@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type ScheduleFrequency string
+
+const (
+	Daily  ScheduleFrequency = "daily"
+	Weekly ScheduleFrequency = "weekly"
+)
+
+// IsValid reports whether the frequency is one the scheduler supports.
+func (f ScheduleFrequency) IsValid() bool {
+	return f == Daily || f == Weekly
+}
+
+// Schedule creates an export from its template at every run, on behalf of the user
+// that created it.
+type Schedule struct {
+	ID        uuid.UUID         `gorm:"type:uuid;primarykey"`
+	CreatedAt time.Time         `gorm:"autoCreateTime"`
+	UpdatedAt time.Time         `gorm:"autoUpdateTime"`
+	Frequency ScheduleFrequency `gorm:"type:string"`
+	// Hour is the hour of the day, in UTC, of the runs. Weekday is the day of the
+	// weekly runs.
+	Hour    int
+	Weekday time.Weekday
+	Enabled bool
+	// Template is the body of the request creating the exports of the schedule
+	Template datatypes.JSON `gorm:"type:json"`
+	// Identity is the x-rh-identity header of the request creating the schedule, the
+	// exports are requested with it
+	Identity string
+	User
+	LastRun *time.Time
+	NextRun time.Time
+	// LastExportID is the export created by the latest run
+	LastExportID *uuid.UUID `gorm:"type:uuid"`
+}
+
+func (s *Schedule) BeforeCreate(tx *gorm.DB) (err error) {
+	s.ID = uuid.New()
+	return nil
+}
+
+// NextRunAfter returns the first run of the schedule after t.
+func (s *Schedule) NextRunAfter(t time.Time) time.Time {
+	t = t.UTC()
+	next := time.Date(t.Year(), t.Month(), t.Day(), s.Hour, 0, 0, 0, time.UTC)
+	days := 1
+	if s.Frequency == Weekly {
+		next = next.AddDate(0, 0, (int(s.Weekday)-int(next.Weekday())+7)%7)
+		days = 7
+	}
+	for !next.After(t) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next
+}
+
+func (edb *ExportDB) CreateSchedule(schedule *Schedule) (*Schedule, error) {
+	return schedule, edb.DB.Create(schedule).Error
+}
+
+func (edb *ExportDB) GetSchedule(scheduleUUID uuid.UUID, user User) (result *Schedule, err error) {
+	err = tableOwnedBy(edb.DB.Where("id = ?", scheduleUUID), "schedules", user).Take(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, ErrRecordNotFound
+	}
+	return
+}
+
+// ListSchedules returns a page of the schedules of the user, sorted by their creation.
+func (edb *ExportDB) ListSchedules(user User, offset, limit int, dir string) (result []*Schedule, count int64, err error) {
+	if dir != "asc" {
+		dir = "desc"
+	}
+	query := tableOwnedBy(edb.DB.Model(&Schedule{}), "schedules", user)
+	if err = query.Count(&count).Error; err != nil {
+		return
+	}
+	err = query.Order("created_at " + dir).Offset(offset).Limit(limit).Find(&result).Error
+	return
+}
+
+func (edb *ExportDB) DeleteSchedule(scheduleUUID uuid.UUID, user User) error {
+	result := tableOwnedBy(edb.DB.Where("id = ?", scheduleUUID), "schedules", user).Delete(&Schedule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DueSchedules returns the enabled schedules whose next run is due at t.
+func (edb *ExportDB) DueSchedules(t time.Time) (result []*Schedule, err error) {
+	err = edb.DB.Where("enabled AND next_run <= ?", t).Order("next_run").Find(&result).Error
+	return
+}
+
+// ClaimScheduleRun moves the next run of the schedule from its due run to next. The
+// run is claimed by a single caller, the others get false, so that the replicas
+// running the scheduler do not run it twice.
+func (edb *ExportDB) ClaimScheduleRun(schedule *Schedule, t, next time.Time) (bool, error) {
+	result := edb.DB.Model(&Schedule{}).
+		Where("id = ? AND enabled AND next_run = ?", schedule.ID, schedule.NextRun).
+		Updates(map[string]interface{}{"last_run": t, "next_run": next})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// SetScheduleExport records the export created by the latest run of the schedule.
+func (edb *ExportDB) SetScheduleExport(scheduleUUID, exportUUID uuid.UUID) error {
+	return edb.DB.Model(&Schedule{}).Where("id = ?", scheduleUUID).Update("last_export_id", exportUUID).Error
+}
@@ -0,0 +1,30 @@
+package models_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+var _ = Describe("Schedules", func() {
+	// 2023-03-15 is a wednesday
+	at := func(day, hour, min int) time.Time { return time.Date(2023, time.March, day, hour, min, 0, 0, time.UTC) }
+
+	DescribeTable("run next at their hour, in UTC",
+		func(frequency models.ScheduleFrequency, hour int, weekday time.Weekday, now, expected time.Time) {
+			schedule := models.Schedule{Frequency: frequency, Hour: hour, Weekday: weekday}
+			Expect(schedule.NextRunAfter(now)).To(Equal(expected))
+		},
+		Entry("daily, later today", models.Daily, 18, time.Sunday, at(15, 10, 30), at(15, 18, 0)),
+		Entry("daily, tomorrow once the hour passed", models.Daily, 6, time.Sunday, at(15, 10, 30), at(16, 6, 0)),
+		Entry("daily, tomorrow when run at the hour", models.Daily, 10, time.Sunday, at(15, 10, 0), at(16, 10, 0)),
+		Entry("weekly, later this week", models.Weekly, 6, time.Friday, at(15, 10, 30), at(17, 6, 0)),
+		Entry("weekly, later today", models.Weekly, 18, time.Wednesday, at(15, 10, 30), at(15, 18, 0)),
+		Entry("weekly, next week once the hour passed", models.Weekly, 6, time.Wednesday, at(15, 10, 30), at(22, 6, 0)),
+		Entry("weekly, next week for an earlier day", models.Weekly, 6, time.Monday, at(15, 10, 30), at(20, 6, 0)),
+		Entry("from another time zone", models.Daily, 6, time.Sunday, at(15, 10, 30).In(time.FixedZone("UTC-8", -8*3600)), at(16, 6, 0)),
+	)
+})
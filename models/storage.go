@@ -0,0 +1,172 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrgStorage is the number of bytes the exports of an organization keep in the
+// bucket. It is maintained as objects are uploaded, packaged and cleaned up.
+type OrgStorage struct {
+	OrganizationID string `gorm:"primarykey"`
+	StoredBytes    int64
+	// QuotaBytes overrides the configured quota of the organization when set, 0 is
+	// unlimited
+	QuotaBytes *int64
+	UpdatedAt  time.Time
+}
+
+func (OrgStorage) TableName() string {
+	return "org_storage"
+}
+
+// Quota returns the quota of the organization, which is the configured default
+// unless it has been overridden. 0 is unlimited.
+func (s OrgStorage) Quota(defaultQuota int64) int64 {
+	if s.QuotaBytes != nil {
+		return *s.QuotaBytes
+	}
+	return defaultQuota
+}
+
+// OverQuota reports whether the organization has used up its quota.
+func (s OrgStorage) OverQuota(defaultQuota int64) bool {
+	quota := s.Quota(defaultQuota)
+	return quota > 0 && s.StoredBytes >= quota
+}
+
+// the stored bytes of the export and of its organization are updated in a single
+// statement so that they can not drift apart
+const addStoredBytesSQL = `WITH export AS (
+	UPDATE export_payloads SET stored_bytes = GREATEST(stored_bytes + @delta, 0)
+	WHERE id = @id
+	RETURNING organization_id
+)
+INSERT INTO org_storage (organization_id, stored_bytes, updated_at)
+SELECT organization_id, GREATEST(@delta, 0), now() FROM export
+ON CONFLICT (organization_id) DO UPDATE
+SET stored_bytes = GREATEST(org_storage.stored_bytes + @delta, 0), updated_at = now()`
+
+// AddStoredBytes records that delta bytes of the export were written to, or removed
+// from when negative, the bucket.
+func (ep *ExportPayload) AddStoredBytes(db DBInterface, delta int64) error {
+	return db.Raw(addStoredBytesSQL, map[string]interface{}{"delta": delta, "id": ep.ID}).Scan(&OrgStorage{}).Error
+}
+
+// UploadedBytes returns the number of bytes uploaded for the sources of the export
+// other than the source.
+func (ep *ExportPayload) UploadedBytes(except uuid.UUID) int64 {
+	var total int64
+	for _, source := range ep.Sources {
+		if source.ID != except {
+			total += source.Size
+		}
+	}
+	return total
+}
+
+// sourceBytes returns the number of bytes uploaded for the sources of the export.
+func (ep *ExportPayload) sourceBytes() int64 {
+	return ep.UploadedBytes(uuid.Nil)
+}
+
+// releaseOrgStorage removes the bytes of deleted exports from the usage of their
+// organizations. The objects of the exports are left to the bucket lifecycle policy.
+func releaseOrgStorage(db *gorm.DB, deleted []ExportPayload) error {
+	for _, export := range deleted {
+		if export.StoredBytes == 0 {
+			continue
+		}
+		err := db.Exec("UPDATE org_storage SET stored_bytes = GREATEST(stored_bytes - ?, 0), updated_at = now() WHERE organization_id = ?",
+			export.StoredBytes, export.OrganizationID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reclaimOrgStorage adds the bytes of restored exports back to the usage of their
+// organizations.
+func reclaimOrgStorage(db *gorm.DB, restored []ExportPayload) error {
+	for _, export := range restored {
+		if export.StoredBytes == 0 {
+			continue
+		}
+		err := db.Exec(`INSERT INTO org_storage (organization_id, stored_bytes, updated_at) VALUES (?, ?, now())
+ON CONFLICT (organization_id) DO UPDATE
+SET stored_bytes = org_storage.stored_bytes + EXCLUDED.stored_bytes, updated_at = now()`,
+			export.OrganizationID, export.StoredBytes).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrgStorage returns the storage used by the organization. Organizations that
+// never stored anything have an empty usage.
+func (edb *ExportDB) GetOrgStorage(orgID string) (OrgStorage, error) {
+	var usage OrgStorage
+	err := edb.DB.Where("organization_id = ?", orgID).Take(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return OrgStorage{OrganizationID: orgID}, nil
+	}
+	return usage, err
+}
+
+// SetOrgQuota overrides the quota of the organization, a nil quota restores the
+// configured default.
+func (edb *ExportDB) SetOrgQuota(orgID string, quota *int64) (OrgStorage, error) {
+	usage := OrgStorage{OrganizationID: orgID, QuotaBytes: quota}
+	err := edb.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "organization_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"quota_bytes": quota, "updated_at": time.Now()}),
+	}).Create(&usage).Error
+	if err != nil {
+		return usage, err
+	}
+	return edb.GetOrgStorage(orgID)
+}
+
+// the quotas on the number of exports of an organization
+const (
+	ExportsQuota         = "exports"
+	InFlightExportsQuota = "in_flight_exports"
+)
+
+// OrgExports is the number of exports of an organization counted against its quotas.
+type OrgExports struct {
+	// Exports are the exports that have not expired
+	Exports int64
+	// InFlight are the exports that are still being collected or packaged
+	InFlight int64
+}
+
+// Exceeded returns the quota another export of the organization would exceed, it is
+// empty when the export is allowed. A maximum of 0 is unlimited.
+func (c OrgExports) Exceeded(maxExports, maxInFlight int64) string {
+	switch {
+	case maxExports > 0 && c.Exports >= maxExports:
+		return ExportsQuota
+	case maxInFlight > 0 && c.InFlight >= maxInFlight:
+		return InFlightExportsQuota
+	}
+	return ""
+}
+
+// CountOrgExports counts the exports of the organization that have not expired, and
+// those of them that are pending, running or packaging. The deleted exports are not
+// counted.
+func (edb *ExportDB) CountOrgExports(orgID string) (OrgExports, error) {
+	var counts OrgExports
+	err := edb.DB.Model(&ExportPayload{}).
+		Select("count(*) AS exports, count(*) FILTER (WHERE status IN ?) AS in_flight", []PayloadStatus{Pending, Running, Packaging}).
+		Where("organization_id = ? AND (expires IS NULL OR expires > now())", orgID).
+		Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,39 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+var _ = Describe("Org storage", func() {
+	quota := func(q int64) *int64 { return &q }
+
+	DescribeTable("is over quota once the stored bytes reach the quota",
+		func(storedBytes int64, override *int64, defaultQuota int64, expectedQuota int64, expectedOver bool) {
+			usage := models.OrgStorage{StoredBytes: storedBytes, QuotaBytes: override}
+			Expect(usage.Quota(defaultQuota)).To(Equal(expectedQuota))
+			Expect(usage.OverQuota(defaultQuota)).To(Equal(expectedOver))
+		},
+		Entry("unlimited by default", int64(500), nil, int64(0), int64(0), false),
+		Entry("below the default quota", int64(99), nil, int64(100), int64(100), false),
+		Entry("at the default quota", int64(100), nil, int64(100), int64(100), true),
+		Entry("with a larger override", int64(100), quota(1000), int64(100), int64(1000), false),
+		Entry("with an unlimited override", int64(100), quota(0), int64(100), int64(0), false),
+		Entry("with a smaller override", int64(100), quota(10), int64(1000), int64(10), true),
+	)
+})
+
+var _ = Describe("Org exports", func() {
+	DescribeTable("exceed a quota once another export would go over it",
+		func(counts models.OrgExports, maxExports, maxInFlight int64, expected string) {
+			Expect(counts.Exceeded(maxExports, maxInFlight)).To(Equal(expected))
+		},
+		Entry("unlimited by default", models.OrgExports{Exports: 500, InFlight: 500}, int64(0), int64(0), ""),
+		Entry("below the quotas", models.OrgExports{Exports: 9, InFlight: 2}, int64(10), int64(3), ""),
+		Entry("at the quota of exports", models.OrgExports{Exports: 10, InFlight: 2}, int64(10), int64(3), models.ExportsQuota),
+		Entry("at the quota of in flight exports", models.OrgExports{Exports: 9, InFlight: 3}, int64(10), int64(3), models.InFlightExportsQuota),
+		Entry("at both quotas", models.OrgExports{Exports: 10, InFlight: 3}, int64(10), int64(3), models.ExportsQuota),
+	)
+})
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SourceTimedOut is the error of the sources whose application did not answer in time.
+var SourceTimedOut = SourceError{Code: http.StatusGatewayTimeout, Message: "timed_out"}
+
+// TimeOutSources fails with SourceTimedOut the sources pending for longer than the
+// timeout of their application, or the default timeout for the applications without
+// one, and returns them. A timeout of 0 never fails the sources. The sources are
+// failed only once, whichever replica gets to them first.
+func (edb *ExportDB) TimeOutSources(timeouts map[string]time.Duration, defaultTimeout time.Duration) ([]Source, error) {
+	var timedOut []Source
+	timeOut := func(before time.Time, query interface{}, args ...interface{}) error {
+		var sources []Source
+		err := edb.DB.Model(&sources).
+			Clauses(clause.Returning{}).
+			Where("status = ? AND created_at < ?", RPending, before).
+			Where(query, args...).
+			Updates(map[string]interface{}{
+				"status":      RFailed,
+				"resolved_at": time.Now(),
+				"code":        SourceTimedOut.Code,
+				"message":     SourceTimedOut.Message,
+			}).
+			Error
+		timedOut = append(timedOut, sources...)
+		return err
+	}
+
+	now := time.Now()
+	applications := []string{}
+	for application, timeout := range timeouts {
+		if timeout <= 0 {
+			continue
+		}
+		applications = append(applications, application)
+		if err := timeOut(now.Add(-timeout), "application = ?", application); err != nil {
+			return timedOut, err
+		}
+	}
+	if defaultTimeout > 0 {
+		if len(applications) == 0 {
+			// NOT IN an empty list matches nothing
+			applications = append(applications, "")
+		}
+		if err := timeOut(now.Add(-defaultTimeout), "application NOT IN ?", applications); err != nil {
+			return timedOut, err
+		}
+	}
+
+	for _, source := range timedOut {
+		observeSourceResolution(source.Application, RFailed, source.CreatedAt)
+	}
+	return timedOut, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// expiryWarningBatch is the number of exports claimed at once by the ExpiryWarner.
+const expiryWarningBatch = 100
+
+// ExpiryWarner warns the owners of the exports deleted within the expiry warning
+// window through the notifications service. Each export is warned about once.
+type ExpiryWarner struct {
+	DB   models.DBInterface
+	Cfg  *config.ExportConfig
+	Chan chan<- *kafka.Message
+	// Breaker drops the warnings while it is open, it may be nil
+	Breaker *ekafka.CircuitBreaker
+	Log     *zap.SugaredLogger
+}
+
+// Run sends the warnings of the exports about to be deleted and returns how many were
+// sent. The exports whose warning could not be sent are warned about by the next run.
+func (w *ExpiryWarner) Run(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		exports, err := w.DB.ClaimExpiringExports(w.Cfg.ExpiryWarningConfig.Window, expiryWarningBatch)
+		if err != nil {
+			return sent, fmt.Errorf("failed to claim the expiring exports: %w", err)
+		}
+		for i, export := range exports {
+			if err := w.warn(ctx, export); err != nil {
+				w.release(exports[i:])
+				return sent, err
+			}
+			sent++
+		}
+		if len(exports) < expiryWarningBatch {
+			return sent, nil
+		}
+	}
+}
+
+func (w *ExpiryWarner) warn(ctx context.Context, export models.ExportPayload) error {
+	logger := w.Log.With(export_logger.ExportIDField(export.ID.String()), export_logger.RequestIDField(export.RequestID))
+
+	msg, err := NewExpiringMessage(w.Cfg, export)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.Cfg.KafkaConfig.ProduceTimeout)
+	defer cancel()
+	if err := ekafka.Send(ctx, w.Breaker, w.Chan, msg); err != nil {
+		return fmt.Errorf("failed to send the expiry warning of export %s: %w", export.ID, err)
+	}
+	logger.Debugw("sent the expiry warning to the producer")
+	return nil
+}
+
+// release releases the claims of the exports that were not warned about.
+func (w *ExpiryWarner) release(exports []models.ExportPayload) {
+	for _, export := range exports {
+		if err := w.DB.ReleaseExpiryWarning(export.ID); err != nil {
+			w.Log.Errorw("failed to release the expiry warning", export_logger.ExportIDField(export.ID.String()), "error", err)
+		}
+	}
+}
+
+// NewExpiringMessage creates the CloudEvent warning the owner of the export that it is
+// about to be deleted.
+func NewExpiringMessage(cfg *config.ExportConfig, export models.ExportPayload) (*kafka.Message, error) {
+	warning := cfg.ExpiryWarningConfig
+
+	event := ekafka.KafkaExpiringMessage{
+		ID:          uuid.New(),
+		Source:      cfg.KafkaConfig.EventSource,
+		Subject:     export.ID.String(),
+		SpecVersion: cfg.KafkaConfig.EventSpecVersion,
+		Type:        warning.EventType,
+		Time:        time.Now().UTC().Format(time.RFC3339),
+		OrgID:       export.OrganizationID,
+		RequestID:   export.RequestID,
+		Data: ekafka.ExportExpiringClass{
+			Name:     export.Name,
+			Format:   string(export.Format),
+			Username: export.Username,
+		},
+	}
+	if export.Expires != nil {
+		event.Data.Expires = export.Expires.UTC().Format(time.RFC3339)
+		event.Data.DeletesAt = export.DeletesAt(cfg.ExportExpiryDays).UTC().Format(time.RFC3339)
+	}
+
+	msg, err := event.ToMessage(ekafka.KafkaHeader{}, warning.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the expiry warning: %w", err)
+	}
+	// the warnings are not addressed to an application, nor do they carry the identity
+	msg.Headers = nil
+	msg.Key = event.Key(cfg.KafkaConfig.MessageKey)
+	return msg, nil
+}
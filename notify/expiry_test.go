@@ -0,0 +1,51 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+var _ = Describe("The expiry warnings", func() {
+	cfg := config.Get()
+
+	It("warn the owner of the export of its deletion on the notifications topic", func() {
+		expires := time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+		export := models.ExportPayload{
+			ID:      uuid.New(),
+			Name:    "systems",
+			Format:  models.CSV,
+			Expires: &expires,
+		}
+		export.OrganizationID = "000001"
+		export.Username = "user"
+
+		msg, err := notify.NewExpiringMessage(cfg, export)
+		Expect(err).To(BeNil())
+
+		Expect(*msg.TopicPartition.Topic).To(Equal(cfg.ExpiryWarningConfig.Topic))
+		Expect(msg.Key).To(Equal([]byte(export.ID.String())))
+		Expect(msg.Headers).To(BeEmpty())
+
+		var warning ekafka.KafkaExpiringMessage
+		Expect(json.Unmarshal(msg.Value, &warning)).To(Succeed())
+		Expect(warning.Type).To(Equal(cfg.ExpiryWarningConfig.EventType))
+		Expect(warning.Subject).To(Equal(export.ID.String()))
+		Expect(warning.OrgID).To(Equal("000001"))
+		Expect(warning.Data).To(Equal(ekafka.ExportExpiringClass{
+			Name:      "systems",
+			Format:    "csv",
+			Username:  "user",
+			Expires:   "2022-06-08T12:00:00Z",
+			DeletesAt: expires.AddDate(0, 0, cfg.ExportExpiryDays).Format(time.RFC3339),
+		}))
+	})
+})
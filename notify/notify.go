@@ -0,0 +1,201 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the notification body.
+const SignatureHeader = "X-Rh-Exports-Signature"
+
+// Notifier is informed when an export reaches a terminal state.
+type Notifier interface {
+	Notify(db models.DBInterface, exportUUID uuid.UUID)
+}
+
+// WebhookPayload is the body POSTed to the notification url of an export.
+type WebhookPayload struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	Expires     *time.Time `json:"expires_at,omitempty"`
+}
+
+// WebhookNotifier delivers signed webhook notifications for finished exports.
+type WebhookNotifier struct {
+	Cfg    config.ExportConfig
+	Client *http.Client
+	Log    *zap.SugaredLogger
+}
+
+// ValidateURL checks that the notification url uses https and that its host is one of
+// the allowed domains or a subdomain of one.
+func ValidateURL(raw string, allowedDomains []string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid notification_url: %w", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("notification_url must use https")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("notification_url host '%s' is not allowed", host)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body using the given key.
+func Sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingKey returns the key used to sign notifications for the given organization.
+func (n *WebhookNotifier) signingKey(orgID string) string {
+	if key, ok := n.Cfg.NotificationConfig.OrgSigningKeys[orgID]; ok {
+		return key
+	}
+	return n.Cfg.NotificationConfig.SigningKey
+}
+
+// Notify delivers the webhook for the export in a go-routine. Failures are recorded on
+// the export but never change its status.
+func (n *WebhookNotifier) Notify(db models.DBInterface, exportUUID uuid.UUID) {
+	go n.notify(db, exportUUID)
+}
+
+func (n *WebhookNotifier) notify(db models.DBInterface, exportUUID uuid.UUID) {
+	logger := n.Log.With(export_logger.ExportIDField(exportUUID.String()))
+
+	payload, err := db.Get(exportUUID)
+	if err != nil {
+		logger.Errorw("failed to get export for notification", "error", err)
+		return
+	}
+	if payload.NotificationURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(n.buildPayload(payload))
+	if err != nil {
+		logger.Errorw("failed to marshal notification payload", "error", err)
+		return
+	}
+
+	attempts, err := n.Deliver(context.Background(), payload.NotificationURL, n.signingKey(payload.OrganizationID), body)
+	t := time.Now()
+	if err != nil {
+		logger.Errorw("failed to deliver notification", "attempts", attempts, "error", err)
+		if err := payload.SetNotificationResult(db, models.NFailed, attempts, err.Error(), nil); err != nil {
+			logger.Errorw("failed to record notification result", "error", err)
+		}
+		return
+	}
+
+	logger.Infow("delivered notification", "attempts", attempts)
+	if err := payload.SetNotificationResult(db, models.NDelivered, attempts, "", &t); err != nil {
+		logger.Errorw("failed to record notification result", "error", err)
+	}
+}
+
+func (n *WebhookNotifier) buildPayload(payload *models.ExportPayload) WebhookPayload {
+	result := WebhookPayload{
+		ID:      payload.ID.String(),
+		Status:  string(payload.Status),
+		Expires: payload.Expires,
+	}
+	if payload.Status == models.Complete || payload.Status == models.CompleteWithErrors {
+		result.DownloadURL = fmt.Sprintf("%s/api/export/v1/exports/%s", strings.TrimSuffix(n.Cfg.NotificationConfig.DownloadBaseURL, "/"), payload.ID)
+	}
+	return result
+}
+
+// Deliver POSTs the signed body to the url, retrying with exponential backoff until
+// a 2xx response is received or the attempts are exhausted. It returns the number of
+// attempts made. The redirects are not followed, their target was never checked
+// against the allowed domains, so a 3xx response is a failed attempt.
+func (n *WebhookNotifier) Deliver(ctx context.Context, url, key string, body []byte) (int, error) {
+	ncfg := n.Cfg.NotificationConfig
+	client := &http.Client{Timeout: ncfg.Timeout}
+	if n.Client != nil {
+		// the client is copied so that the one of the caller keeps its redirects
+		*client = *n.Client
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	backoff := ncfg.InitialBackoff
+	var lastErr error
+	attempt := 0
+	for attempt < ncfg.MaxAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return attempt, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		attempt++
+
+		lastErr = n.post(ctx, client, url, key, body)
+		if lastErr == nil {
+			return attempt, nil
+		}
+		n.Log.Debugw("notification attempt failed", "attempt", attempt, "error", lastErr)
+	}
+	return attempt, lastErr
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, client *http.Client, url, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(key, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return fmt.Errorf("unexpected redirect to '%s', the redirects are not followed: %d", resp.Header.Get("Location"), resp.StatusCode)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+	}
+	return nil
+}
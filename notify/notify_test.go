@@ -0,0 +1,104 @@
+package notify_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+var _ = Describe("Validate the notification url", func() {
+	allowed := []string{"example.com", "", "hooks.redhat.com"}
+
+	DescribeTable("against the allowed domains",
+		func(url string, valid bool) {
+			err := notify.ValidateURL(url, allowed)
+			if valid {
+				Expect(err).To(BeNil())
+			} else {
+				Expect(err).ShouldNot(BeNil())
+			}
+		},
+		Entry("an allowed domain", "https://example.com/hook", true),
+		Entry("a subdomain of an allowed domain", "https://api.example.com/hook", true),
+		Entry("an allowed domain with a port", "https://hooks.redhat.com:8443/hook", true),
+		Entry("plain http", "http://example.com/hook", false),
+		Entry("a domain that is not allowed", "https://example.org/hook", false),
+		Entry("a domain sharing a suffix", "https://badexample.com/hook", false),
+		Entry("an empty host", "https:///hook", false),
+	)
+})
+
+var _ = Describe("Deliver a notification", func() {
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		cfg = *config.Get()
+		cfg.NotificationConfig.MaxAttempts = 3
+		cfg.NotificationConfig.InitialBackoff = time.Millisecond
+	})
+
+	It("should sign the body and retry until the webhook succeeds", func() {
+		body := []byte(`{"id":"1234","status":"complete"}`)
+		calls := 0
+
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			received, err := io.ReadAll(r.Body)
+			Expect(err).To(BeNil())
+			Expect(received).To(Equal(body))
+			Expect(r.Header.Get(notify.SignatureHeader)).To(Equal("sha256=" + notify.Sign("secret", body)))
+
+			if calls < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		notifier := notify.WebhookNotifier{Cfg: cfg, Client: server.Client(), Log: zap.NewNop().Sugar()}
+		attempts, err := notifier.Deliver(context.Background(), server.URL, "secret", body)
+		Expect(err).To(BeNil())
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("should give up after the maximum attempts", func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		notifier := notify.WebhookNotifier{Cfg: cfg, Client: server.Client(), Log: zap.NewNop().Sugar()}
+		attempts, err := notifier.Deliver(context.Background(), server.URL, "secret", []byte("{}"))
+		Expect(err).ShouldNot(BeNil())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should not follow the redirects", func() {
+		redirected := 0
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/internal" {
+				redirected++
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+		}))
+		defer server.Close()
+
+		notifier := notify.WebhookNotifier{Cfg: cfg, Client: server.Client(), Log: zap.NewNop().Sugar()}
+		attempts, err := notifier.Deliver(context.Background(), server.URL+"/hook", "secret", []byte("{}"))
+		Expect(err).To(MatchError(ContainSubstring("redirects are not followed: 307")))
+		Expect(attempts).To(Equal(3))
+		Expect(redirected).To(BeZero())
+	})
+})
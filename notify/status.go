@@ -0,0 +1,121 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	export_logger "github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// The transitions of the lifecycle of an export, published as status events.
+const (
+	StatusCreated         = "created"
+	StatusSourceCompleted = "source-completed"
+	StatusSourceFailed    = "source-failed"
+	// StatusComplete is published for the complete and the partial exports
+	StatusComplete  = "complete"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+	StatusDeleted   = "deleted"
+	StatusRestored  = "restored"
+)
+
+// StatusPublisher is informed of every transition of an export, the transitions of a
+// single source carry the source. The publication is best effort, the failures are
+// logged rather than failing the transition.
+type StatusPublisher interface {
+	Publish(status string, payload models.ExportPayload, source *models.Source)
+}
+
+// PublishStatus publishes the transition with the publisher, which may be nil.
+func PublishStatus(publisher StatusPublisher, status string, payload models.ExportPayload, source *models.Source) {
+	if publisher == nil {
+		return
+	}
+	publisher.Publish(status, payload, source)
+}
+
+// KafkaStatusPublisher sends the transitions to the producer as CloudEvents on the
+// status topic, keyed like the requests for the data of the sources.
+type KafkaStatusPublisher struct {
+	Cfg  *config.ExportConfig
+	Chan chan<- *kafka.Message
+	// Breaker drops the events while it is open, it may be nil
+	Breaker *ekafka.CircuitBreaker
+	Log     *zap.SugaredLogger
+}
+
+// Publish sends the event to the producer, waiting at most the produce timeout.
+func (p *KafkaStatusPublisher) Publish(status string, payload models.ExportPayload, source *models.Source) {
+	logger := p.Log.With(export_logger.ExportIDField(payload.ID.String()), export_logger.RequestIDField(payload.RequestID), "status", status)
+
+	msg, err := NewStatusMessage(p.Cfg, status, payload, source)
+	if err != nil {
+		logger.Errorw("failed to create the status event", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Cfg.KafkaConfig.ProduceTimeout)
+	defer cancel()
+	if err := ekafka.Send(ctx, p.Breaker, p.Chan, msg); err != nil {
+		logger.Errorw("failed to send the status event to the producer", "error", err)
+		return
+	}
+	logger.Debugw("sent the status event to the producer")
+}
+
+// NewStatusMessage creates the CloudEvent of the transition of the export.
+func NewStatusMessage(cfg *config.ExportConfig, status string, payload models.ExportPayload, source *models.Source) (*kafka.Message, error) {
+	kafkaConfig := cfg.KafkaConfig
+
+	event := ekafka.KafkaStatusMessage{
+		ID:          uuid.New(),
+		Source:      kafkaConfig.EventSource,
+		Subject:     payload.ID.String(),
+		SpecVersion: kafkaConfig.EventSpecVersion,
+		Type:        kafkaConfig.StatusEventType,
+		Time:        time.Now().UTC().Format(time.RFC3339),
+		OrgID:       payload.OrganizationID,
+		RequestID:   payload.RequestID,
+		Data: ekafka.ExportStatusClass{
+			Status: status,
+			Name:   payload.Name,
+			Format: string(payload.Format),
+		},
+	}
+	if payload.Expires != nil {
+		event.Data.Expires = payload.Expires.UTC().Format(time.RFC3339)
+	}
+	if source != nil {
+		event.Data.Source = &ekafka.ExportStatusSource{
+			Application: source.Application,
+			Resource:    source.Resource,
+			UUID:        source.ID.String(),
+		}
+		if source.SourceError != nil {
+			event.Data.Source.Error = &ekafka.ExportSourceError{Code: source.Code, Message: source.Message, Retriable: source.Retriable}
+		}
+	}
+
+	msg, err := event.ToMessage(ekafka.KafkaHeader{}, kafkaConfig.StatusTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the status event: %w", err)
+	}
+	// the events are not addressed to an application, nor do they carry the identity
+	msg.Headers = nil
+	msg.Key = event.Key(kafkaConfig.MessageKey)
+	return msg, nil
+}
@@ -0,0 +1,92 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+)
+
+var _ = Describe("The status events", func() {
+	cfg := config.Get()
+
+	expires := time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+	payload := models.ExportPayload{
+		ID:      uuid.New(),
+		Name:    "systems",
+		Format:  models.JSON,
+		Expires: &expires,
+	}
+	payload.OrganizationID = "000001"
+	payload.RequestID = "export-service/8hcF1dR9cX-000042"
+
+	event := func(msg *kafka.Message) ekafka.KafkaStatusMessage {
+		var status ekafka.KafkaStatusMessage
+		Expect(json.Unmarshal(msg.Value, &status)).To(Succeed())
+		return status
+	}
+
+	It("carry the transition of the export to the status topic", func() {
+		msg, err := notify.NewStatusMessage(cfg, notify.StatusComplete, payload, nil)
+		Expect(err).To(BeNil())
+
+		Expect(*msg.TopicPartition.Topic).To(Equal(cfg.KafkaConfig.StatusTopic))
+		Expect(msg.Key).To(Equal([]byte(payload.ID.String())))
+		Expect(msg.Headers).To(BeEmpty())
+		Expect(ekafka.IsSourceRequest(msg)).To(BeFalse())
+
+		status := event(msg)
+		Expect(status.Type).To(Equal(cfg.KafkaConfig.StatusEventType))
+		Expect(status.Subject).To(Equal(payload.ID.String()))
+		Expect(status.OrgID).To(Equal("000001"))
+		Expect(status.RequestID).To(Equal("export-service/8hcF1dR9cX-000042"))
+		Expect(status.Data).To(Equal(ekafka.ExportStatusClass{
+			Status:  notify.StatusComplete,
+			Name:    "systems",
+			Format:  "json",
+			Expires: "2022-06-08T12:00:00Z",
+		}))
+	})
+
+	It("carry the source of the transitions of a source", func() {
+		source := models.Source{
+			ID:          uuid.New(),
+			Application: "exampleApp",
+			Resource:    "exampleResource",
+			Status:      models.RFailed,
+			SourceError: &models.SourceError{Code: 400, Message: "unknown resource"},
+		}
+		msg, err := notify.NewStatusMessage(cfg, notify.StatusSourceFailed, payload, &source)
+		Expect(err).To(BeNil())
+
+		status := event(msg)
+		Expect(status.Data.Status).To(Equal(notify.StatusSourceFailed))
+		Expect(status.Data.Source).To(Equal(&ekafka.ExportStatusSource{
+			Application: "exampleApp",
+			Resource:    "exampleResource",
+			UUID:        source.ID.String(),
+			Error:       &ekafka.ExportSourceError{Code: 400, Message: "unknown resource"},
+		}))
+	})
+
+	It("are sent to the producer", func() {
+		msgChan := make(chan *kafka.Message, 1)
+		publisher := &notify.KafkaStatusPublisher{Cfg: cfg, Chan: msgChan, Log: logger.Get()}
+
+		notify.PublishStatus(publisher, notify.StatusCreated, payload, nil)
+		Expect(event(<-msgChan).Data.Status).To(Equal(notify.StatusCreated))
+
+		// nobody publishes without a publisher
+		notify.PublishStatus(nil, notify.StatusDeleted, payload, nil)
+		Expect(msgChan).To(BeEmpty())
+	})
+})
@@ -0,0 +1,212 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	chi "github.com/go-chi/chi/v5"
+)
+
+// Builder generates the OpenAPI document of a router. Operations are documented
+// with the chi pattern of their route, and the document is built by walking the
+// router, so that every route ends up in the document.
+type Builder struct {
+	Info    Info
+	Servers []Server
+	// Security is required by every operation that is not anonymous
+	Security        []SecurityRequirement
+	SecuritySchemes map[string]SecurityScheme
+
+	operations map[route]Operation
+	pathParams map[string]Parameter
+	schemas    map[string]*Schema
+	types      map[string]reflect.Type
+}
+
+type route struct {
+	method string
+	path   string
+}
+
+func NewBuilder(info Info, servers ...Server) *Builder {
+	return &Builder{
+		Info:            info,
+		Servers:         servers,
+		SecuritySchemes: map[string]SecurityScheme{},
+		operations:      map[route]Operation{},
+		pathParams:      map[string]Parameter{},
+		schemas:         map[string]*Schema{},
+		types:           map[string]reflect.Type{},
+	}
+}
+
+// Handle documents the operation of the method on the path. The path is the chi
+// pattern of the route relative to the base path of the api.
+func (b *Builder) Handle(method, path string, op Operation) {
+	b.operations[route{method: strings.ToUpper(method), path: normalizePath(path)}] = op
+}
+
+// PathParam documents the path parameter of every path it appears in. Path
+// parameters that are not documented are required strings.
+func (b *Builder) PathParam(p Parameter) {
+	p.In = "path"
+	p.Required = true
+	b.pathParams[p.Name] = p
+}
+
+// Build walks the routes registered under the base path and returns their
+// document. Routes that are not documented, and documented operations without a
+// route, are reported in the error. The document includes the undocumented routes
+// nonetheless.
+func (b *Builder) Build(routes chi.Routes, basePath string) (*Document, error) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    b.Info,
+		Servers: b.Servers,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas:         b.schemas,
+			SecuritySchemes: b.SecuritySchemes,
+		},
+	}
+
+	var problems []string
+	routed := map[route]bool{}
+	err := chi.Walk(routes, func(method, pattern string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path, ok := relativePath(normalizePath(pattern), normalizePath(basePath))
+		if !ok {
+			return nil
+		}
+		r := route{method: method, path: path}
+		routed[r] = true
+
+		op, ok := b.operations[r]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %s is not documented", r.method, r.path))
+			op = Operation{Responses: map[string]Response{"default": {Description: "Undocumented"}}}
+		}
+		b.addOperation(doc, r, op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for r := range b.operations {
+		if !routed[r] {
+			problems = append(problems, fmt.Sprintf("%s %s is documented but not routed", r.method, r.path))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return doc, fmt.Errorf("the OpenAPI document does not match the routes: %s", strings.Join(problems, "; "))
+	}
+	return doc, nil
+}
+
+var pathParamRegex = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+func (b *Builder) addOperation(doc *Document, r route, op Operation) {
+	var params []Parameter
+	for _, match := range pathParamRegex.FindAllStringSubmatch(r.path, -1) {
+		if !hasParameter(op.Parameters, match[1]) {
+			p, ok := b.pathParams[match[1]]
+			if !ok {
+				p = Parameter{Name: match[1], In: "path", Required: true, Schema: String()}
+			}
+			params = append(params, p)
+		}
+	}
+	op.Parameters = append(params, op.Parameters...)
+	if op.Security == nil && !op.Anonymous {
+		op.Security = b.Security
+	}
+
+	// chi patterns may constrain their params with a regexp, OpenAPI paths can not
+	path := pathParamRegex.ReplaceAllString(r.path, "{$1}")
+	if doc.Paths[path] == nil {
+		doc.Paths[path] = PathItem{}
+	}
+	doc.Paths[path][strings.ToLower(r.method)] = &op
+}
+
+func hasParameter(params []Parameter, name string) bool {
+	for _, p := range params {
+		if p.In == "path" && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePath drops the empty segments, the trailing slash and the wildcards of
+// mount points chi leaves in the patterns of sub routers.
+func normalizePath(path string) string {
+	var segments []string
+	parts := strings.Split(path, "/")
+	for i, s := range parts {
+		if s != "" && (s != "*" || i == len(parts)-1) {
+			segments = append(segments, s)
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func relativePath(path, basePath string) (string, bool) {
+	if basePath == "/" {
+		return path, true
+	}
+	if path == basePath {
+		return "/", true
+	}
+	if !strings.HasPrefix(path, basePath+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(path, basePath), true
+}
+
+// Handler serves a document as json.
+type Handler struct {
+	mu   sync.RWMutex
+	body []byte
+}
+
+// Build builds the document of the routes and serves it. The document is served
+// even when it does not match the routes, the error reports the mismatch.
+func (h *Handler) Build(b *Builder, routes chi.Routes, basePath string) error {
+	doc, buildErr := b.Build(routes, basePath)
+	if doc == nil {
+		return buildErr
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.body = body
+	return buildErr
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.body == nil {
+		http.Error(w, "the OpenAPI document has not been built", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(h.body)
+}
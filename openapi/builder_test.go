@@ -0,0 +1,201 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/exports"
+	ekafka "github.com/redhatinsights/export-service-go/kafka"
+	"github.com/redhatinsights/export-service-go/openapi"
+)
+
+type inner struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+type outer struct {
+	inner
+	Name      string     `json:"name" description:"shadows the inner name"`
+	Status    string     `json:"status" enum:"a,b"`
+	CreatedAt *time.Time `json:"created_at"`
+	Children  []inner    `json:"children,omitempty"`
+	Ignored   string     `json:"-"`
+}
+
+func noop(w http.ResponseWriter, r *http.Request) {}
+
+// methods returns the methods of the routes under the base path, keyed by their
+// path in the spec.
+func methods(routes chi.Routes, basePath string) map[string][]string {
+	result := map[string][]string{}
+	Expect(chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.ReplaceAll(strings.ReplaceAll(route, "/*/", "/"), "//", "/")
+		if !strings.HasPrefix(route, basePath) {
+			return nil
+		}
+		path := strings.TrimSuffix(strings.TrimPrefix(route, basePath), "/")
+		result[path] = append(result[path], strings.ToLower(method))
+		return nil
+	})).To(Succeed())
+	return result
+}
+
+var _ = Describe("The OpenAPI builder", func() {
+	It("derives the schemas from the json encoding of structs", func() {
+		b := openapi.NewBuilder(openapi.Info{Title: "test", Version: "1"})
+		Expect(b.SchemaOf(outer{})).To(Equal(&openapi.Schema{Ref: "#/components/schemas/outer"}))
+
+		doc, err := b.Build(chi.NewRouter(), "/")
+		Expect(err).To(BeNil())
+
+		schema := doc.Components.Schemas["outer"]
+		Expect(schema.Properties).To(HaveLen(5))
+		Expect(schema.Properties["id"]).To(Equal(&openapi.Schema{Type: "string", Format: "uuid"}))
+		Expect(schema.Properties["name"].Description).To(Equal("shadows the inner name"))
+		Expect(schema.Properties["status"].Enum).To(Equal([]string{"a", "b"}))
+		Expect(schema.Properties["created_at"]).To(Equal(&openapi.Schema{Type: "string", Format: "date-time", Nullable: true}))
+		Expect(schema.Properties["children"]).To(Equal(openapi.Array(&openapi.Schema{Ref: "#/components/schemas/inner"})))
+		Expect(doc.Components.Schemas).To(HaveKey("inner"))
+	})
+
+	It("reports the routes that are not documented and the operations that are not routed", func() {
+		router := chi.NewRouter()
+		router.Route("/api", func(r chi.Router) {
+			r.Get("/things/{thingID}", noop)
+			r.Delete("/things/{thingID}", noop)
+		})
+		router.Get("/elsewhere", noop)
+
+		b := openapi.NewBuilder(openapi.Info{Title: "test", Version: "1"})
+		b.Security = []openapi.SecurityRequirement{{"key": {}}}
+		b.Handle(http.MethodGet, "/things/{thingID}/", openapi.Operation{OperationID: "getThing"})
+		b.Handle(http.MethodPost, "/things", openapi.Operation{OperationID: "createThing"})
+
+		doc, err := b.Build(router, "/api")
+		Expect(err).To(MatchError(ContainSubstring("DELETE /things/{thingID} is not documented")))
+		Expect(err).To(MatchError(ContainSubstring("POST /things is documented but not routed")))
+
+		Expect(doc.Paths).To(HaveLen(1))
+		op := doc.Paths["/things/{thingID}"]["get"]
+		Expect(op.OperationID).To(Equal("getThing"))
+		Expect(op.Security).To(Equal(b.Security))
+		Expect(op.Parameters).To(ConsistOf(openapi.Parameter{Name: "thingID", In: "path", Required: true, Schema: openapi.String()}))
+		Expect(doc.Paths["/things/{thingID}"]).To(HaveKey("delete"))
+	})
+
+	It("serves the document", func() {
+		router := chi.NewRouter()
+		router.Get("/ping", noop)
+		b := openapi.NewBuilder(openapi.Info{Title: "test", Version: "1"})
+		b.Handle(http.MethodGet, "/ping", openapi.Operation{OperationID: "ping"})
+
+		handler := &openapi.Handler{}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+		Expect(rr.Code).To(Equal(http.StatusServiceUnavailable))
+
+		Expect(handler.Build(b, router, "/")).To(Succeed())
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var doc openapi.Document
+		Expect(json.Unmarshal(rr.Body.Bytes(), &doc)).To(Succeed())
+		Expect(doc.OpenAPI).To(Equal(openapi.Version))
+		Expect(doc.Paths["/ping"]).To(HaveKey("get"))
+	})
+
+	Describe("the specs of the export service", func() {
+		DescribeTable("document every public route with its methods",
+			func(basePath string, exportRouter func(*exports.Export) func(chi.Router), spec func() *openapi.Builder) {
+				external := &exports.Export{Cfg: config.Get()}
+				router := chi.NewRouter()
+				router.Get(basePath+"/openapi.json", noop)
+				router.Route(basePath, func(r chi.Router) {
+					r.Get("/ping", noop)
+					r.Get("/limits", external.GetLimits)
+					r.Route("/exports", exportRouter(external))
+					r.Route("/schedules", external.ScheduleRouter)
+				})
+
+				doc, err := spec().Build(router, basePath)
+				Expect(err).To(BeNil())
+				routes := methods(router, basePath)
+				Expect(routes).To(HaveKey("/exports/{exportUUID}/sources/{sourceUUID}"))
+				Expect(routes).To(HaveKey("/schedules/{scheduleUUID}"))
+				for path, pathMethods := range routes {
+					Expect(doc.Paths).To(HaveKey(path))
+					for _, method := range pathMethods {
+						Expect(doc.Paths[path]).To(HaveKey(method), path)
+					}
+				}
+			},
+			Entry("v1", exports.PublicBasePath, func(e *exports.Export) func(chi.Router) { return e.ExportRouter }, exports.PublicSpec),
+			Entry("v2", exports.PublicBasePathV2, func(e *exports.Export) func(chi.Router) { return e.ExportRouterV2 }, exports.PublicSpecV2),
+		)
+
+		It("document every private route with its methods", func() {
+			internal := exports.Internal{Cfg: config.Get()}
+			producer := &ekafka.Producer{}
+			router := chi.NewRouter()
+			router.Route(exports.PrivateBasePath, func(r chi.Router) {
+				r.Get("/ping", noop)
+				r.Get("/debug/kafka", producer.DebugHandler)
+				r.Post("/kafka/dlq/redrive", ekafka.RedriveHandler(ekafka.NewDLQConsumer, nil))
+				r.Route("/", internal.InternalRouter)
+			})
+
+			doc, err := exports.PrivateSpec().Build(router, exports.PrivateBasePath)
+			Expect(err).To(BeNil())
+			routes := methods(router, exports.PrivateBasePath)
+			Expect(routes).To(HaveKey("/orgs/{orgID}/storage"))
+			for path, pathMethods := range routes {
+				Expect(doc.Paths).To(HaveKey(path))
+				for _, method := range pathMethods {
+					Expect(doc.Paths[path]).To(HaveKey(method), path)
+				}
+			}
+		})
+
+		It("route and document the faults only with DEBUG", func() {
+			cfg := config.Get()
+			debug := cfg.Debug
+			DeferCleanup(func() { cfg.Debug = debug })
+
+			build := func() (map[string][]string, *openapi.Document) {
+				internal := exports.Internal{Cfg: cfg}
+				producer := &ekafka.Producer{}
+				router := chi.NewRouter()
+				router.Route(exports.PrivateBasePath, func(r chi.Router) {
+					r.Get("/ping", noop)
+					r.Get("/debug/kafka", producer.DebugHandler)
+					r.Post("/kafka/dlq/redrive", ekafka.RedriveHandler(ekafka.NewDLQConsumer, nil))
+					r.Route("/", internal.InternalRouter)
+				})
+				doc, err := exports.PrivateSpec().Build(router, exports.PrivateBasePath)
+				Expect(err).To(BeNil())
+				return methods(router, exports.PrivateBasePath), doc
+			}
+
+			cfg.Debug = false
+			routes, doc := build()
+			Expect(routes).ToNot(HaveKey("/debug/faults"))
+			Expect(doc.Paths).ToNot(HaveKey("/debug/faults"))
+
+			cfg.Debug = true
+			routes, doc = build()
+			Expect(routes["/debug/faults"]).To(ConsistOf("get", "post", "delete"))
+			Expect(doc.Paths["/debug/faults"]).To(HaveLen(3))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	uuidType          = reflect.TypeOf(uuid.UUID{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// SchemaOf returns the schema of the json encoding of the value. Named structs are
+// added to the component schemas of the document and referenced.
+//
+// The properties of a struct follow its json tags, including the fields of embedded
+// structs. Fields may be annotated with a `description` tag and with an `enum` tag
+// listing the comma separated values of the field. Pointers without omitempty are
+// nullable.
+func (b *Builder) SchemaOf(v interface{}) *Schema {
+	return b.schemaOf(reflect.TypeOf(v))
+}
+
+func (b *Builder) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	}
+	if t.Kind() == reflect.Ptr {
+		return b.schemaOf(t.Elem())
+	}
+	if implements(t, jsonMarshalerType) {
+		// the type encodes itself, it can be any json value
+		return &Schema{}
+	}
+	if implements(t, textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices with base64
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return Array(b.schemaOf(t.Elem()))
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		return b.component(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// component adds the schema of the named struct to the components and returns a
+// reference to it. Structs named alike in different packages are told apart by
+// the name of their package.
+func (b *Builder) component(t reflect.Type) *Schema {
+	name := t.Name()
+	if other, ok := b.types[name]; ok && other != t {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	if _, ok := b.types[name]; !ok {
+		b.types[name] = t
+		// the schema is added once complete, a struct referencing itself only
+		// needs the name
+		b.schemas[name] = b.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (b *Builder) structSchema(t reflect.Type) *Schema {
+	s := Object(map[string]*Schema{})
+	b.addFields(s, t, 0, map[string]int{})
+	return s
+}
+
+// addFields adds the properties of the fields of the struct to the schema. As with
+// encoding/json, the fields of embedded structs are shadowed by shallower fields
+// with the same name.
+func (b *Builder) addFields(s *Schema, t reflect.Type, depth int, depths map[string]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.addFields(s, ft, depth+1, depths)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if d, ok := depths[name]; ok && d <= depth {
+			continue
+		}
+		depths[name] = depth
+
+		nullable := f.Type.Kind() == reflect.Ptr && !strings.Contains(opts, "omitempty")
+		s.Properties[name] = annotate(b.schemaOf(f.Type), f, nullable)
+	}
+}
+
+func annotate(s *Schema, f reflect.StructField, nullable bool) *Schema {
+	description := f.Tag.Get("description")
+	if s.Ref != "" && (description != "" || nullable) {
+		// a reference can not have siblings
+		s = &Schema{AllOf: []*Schema{s}}
+	}
+	s.Description = description
+	s.Nullable = nullable
+	if enum := f.Tag.Get("enum"); enum != "" {
+		s.Enum = strings.Split(enum, ",")
+	}
+	return s
+}
+
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
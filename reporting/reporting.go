@@ -0,0 +1,98 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package reporting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+// flushTimeout bounds sending the events that were not sent yet on shutdown
+const flushTimeout = 5 * time.Second
+
+// Init reports the errors to the configured Sentry, or Glitchtip, project. The
+// returned func sends the events that were not sent yet, it is called on shutdown
+// and reports whether they were all sent.
+func Init(cfg *config.ExportConfig, log *zap.SugaredLogger) func() bool {
+	scfg := cfg.SentryConfig
+	if scfg.DSN == "" {
+		log.Info("error reporting is disabled, SENTRY_DSN is not set")
+		return func() bool { return true }
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         scfg.DSN,
+		Environment: scfg.Environment,
+		ServerName:  cfg.Hostname,
+		SampleRate:  scfg.SampleRate,
+	})
+	if err != nil {
+		// the service runs without the reports rather than not at all
+		log.Errorw("failed to initialize the error reporting", "error", err)
+		return func() bool { return true }
+	}
+
+	log.Infow("reporting the errors", "environment", scfg.Environment, "sample_rate", scfg.SampleRate)
+	return func() bool { return sentry.Flush(flushTimeout) }
+}
+
+// Capture reports the error with the request and the trace of the context, and the
+// tags, given as key and value pairs like the fields of the logger, e.g. the id of
+// the export it concerns. It does nothing when the reporting is disabled.
+func Capture(ctx context.Context, err error, tags ...string) {
+	if err == nil {
+		return
+	}
+	hub := newHub(ctx, tags)
+	if hub == nil {
+		return
+	}
+	hub.CaptureException(err)
+}
+
+// CapturePanic reports the value recovered from a panic, see Capture.
+func CapturePanic(ctx context.Context, recovered interface{}, tags ...string) {
+	hub := newHub(ctx, tags)
+	if hub == nil {
+		return
+	}
+	if err, ok := recovered.(error); ok {
+		hub.CaptureException(fmt.Errorf("panic: %w", err))
+		return
+	}
+	hub.CaptureException(errors.New(fmt.Sprint("panic: ", recovered)))
+}
+
+// newHub returns a hub scoped to the event, the hub of the process is shared by the
+// goroutines and can not hold the tags of one of them.
+func newHub(ctx context.Context, tags []string) *sentry.Hub {
+	if sentry.CurrentHub().Client() == nil {
+		return nil
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		if id := request_id.GetReqID(ctx); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+			scope.SetTag("trace_id", span.TraceID().String())
+		}
+		for i := 0; i+1 < len(tags); i += 2 {
+			if tags[i+1] != "" {
+				scope.SetTag(tags[i], tags[i+1])
+			}
+		}
+	})
+	return hub
+}
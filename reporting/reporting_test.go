@@ -0,0 +1,93 @@
+package reporting_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redhatinsights/platform-go-middlewares/request_id"
+
+	"github.com/redhatinsights/export-service-go/reporting"
+)
+
+// eventRecorder is the transport of the tests, it keeps the events instead of
+// sending them.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (r *eventRecorder) Configure(options sentry.ClientOptions) {}
+
+func (r *eventRecorder) SendEvent(event *sentry.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) Flush(timeout time.Duration) bool {
+	return true
+}
+
+func (r *eventRecorder) Events() []*sentry.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*sentry.Event(nil), r.events...)
+}
+
+var _ = Describe("The error reporting", func() {
+	var recorder *eventRecorder
+
+	BeforeEach(func() {
+		recorder = &eventRecorder{}
+		Expect(sentry.Init(sentry.ClientOptions{Transport: recorder})).To(Succeed())
+		DeferCleanup(func() {
+			sentry.CurrentHub().BindClient(nil)
+		})
+	})
+
+	It("reports the errors with their request and tags", func() {
+		ctx := context.WithValue(context.Background(), request_id.RequestIDKey, "request-1")
+		reporting.Capture(ctx, errors.New("the bucket is gone"), "export_id", "export-1", "org_id", "", "dangling")
+
+		events := recorder.Events()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Exception).To(HaveLen(1))
+		Expect(events[0].Exception[0].Value).To(Equal("the bucket is gone"))
+		Expect(events[0].Tags).To(Equal(map[string]string{"request_id": "request-1", "export_id": "export-1"}))
+	})
+
+	It("does not share the tags of the reports", func() {
+		reporting.Capture(context.Background(), errors.New("first"), "export_id", "export-1")
+		reporting.Capture(context.Background(), errors.New("second"))
+
+		events := recorder.Events()
+		Expect(events).To(HaveLen(2))
+		Expect(events[1].Tags).NotTo(HaveKey("export_id"))
+	})
+
+	It("does not report the lack of an error", func() {
+		reporting.Capture(context.Background(), nil)
+		Expect(recorder.Events()).To(BeEmpty())
+	})
+
+	It("reports the panics", func() {
+		reporting.CapturePanic(context.Background(), "index out of range")
+		reporting.CapturePanic(context.Background(), errors.New("nil map"))
+
+		events := recorder.Events()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Exception[0].Value).To(Equal("panic: index out of range"))
+		Expect(events[1].Exception[len(events[1].Exception)-1].Value).To(Equal("panic: nil map"))
+	})
+
+	It("does nothing when it is disabled", func() {
+		sentry.CurrentHub().BindClient(nil)
+		reporting.Capture(context.Background(), errors.New("unreported"))
+		Expect(recorder.Events()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,97 @@
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/DataDog/zstd"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// archiveWriter writes the files of an archive one after the other.
+type archiveWriter interface {
+	// Create adds a file to the archive, its body is written to the returned writer
+	// before the next file is created.
+	Create(name string, size int64, modTime time.Time) (io.Writer, error)
+	// Close writes the end of the archive, it does not close the underlying writer.
+	Close() error
+}
+
+// newArchiveWriter returns a writer of archives of the format, the exports without a
+// format are packaged like before the format could be chosen.
+func newArchiveWriter(w io.Writer, format models.ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case models.Zip:
+		return zipArchive{zip.NewWriter(w)}, nil
+	case models.TarGz, "":
+		gzipWriter := gzip.NewWriter(w)
+		return &tarArchive{Writer: tar.NewWriter(gzipWriter), compressor: gzipWriter}, nil
+	case models.TarZst:
+		zstdWriter := zstd.NewWriter(w)
+		return &tarArchive{Writer: tar.NewWriter(zstdWriter), compressor: zstdWriter}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format: %s", format)
+	}
+}
+
+// tarArchive is a tarball compressed by the compressor.
+type tarArchive struct {
+	*tar.Writer
+	compressor io.Closer
+}
+
+func (a *tarArchive) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := a.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return a.Writer, nil
+}
+
+func (a *tarArchive) Close() error {
+	// produce tar
+	if err := a.Writer.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	// produce the compressed tarball
+	if err := a.compressor.Close(); err != nil {
+		return fmt.Errorf("failed to close the compressor: %w", err)
+	}
+	return nil
+}
+
+// zipArchive is a zip file, whose files are deflated one by one.
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (a zipArchive) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(0600)
+	fw, err := a.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return fw, nil
+}
+
+func (a zipArchive) Close() error {
+	if err := a.Writer.Close(); err != nil {
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	return nil
+}
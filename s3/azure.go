@@ -0,0 +1,261 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"go.uber.org/zap"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+// azureBlockSize is the size of the blocks of an upload.
+const azureBlockSize = 8 * 1024 * 1024 // 8 MiB
+
+func isAzureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// AzureStorage keeps the objects in a container of Azure Blob Storage, with the azblob
+// client of the Azure SDK authenticated with the shared key of the storage account.
+type AzureStorage struct {
+	Client *container.Client
+	Cfg    econfig.ExportConfig
+	// Stats records the puts, gets, stats and deletes, they are not recorded when
+	// it is nil
+	Stats Stats
+	// BlockSize is the size of the blocks of an upload, azureBlockSize when 0. The SDK
+	// uploads blocks of at least 1 MiB.
+	BlockSize int64
+
+	credential *container.SharedKeyCredential
+}
+
+// NewAzureStorage returns the storage for the container configured by the
+// AZURE_STORAGE_* variables, recording its operations to the stats.
+func NewAzureStorage(cfg econfig.ExportConfig, log *zap.SugaredLogger, stats Stats) (*AzureStorage, error) {
+	acfg := cfg.StorageConfig.Azure
+	if acfg.Account == "" || acfg.AccountKey == "" || acfg.Container == "" {
+		return nil, fmt.Errorf("the %s provider requires AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY and AZURE_STORAGE_CONTAINER", ProviderAzure)
+	}
+	if cfg.StorageConfig.SSEType != "" {
+		return nil, fmt.Errorf("the %s provider does not support STORAGE_SSE_TYPE, azure encrypts the objects at rest", ProviderAzure)
+	}
+	credential, err := container.NewSharedKeyCredential(acfg.Account, acfg.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("AZURE_STORAGE_KEY is not base64 encoded: %w", err)
+	}
+
+	endpoint := acfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", acfg.Account)
+	}
+	containerURL := strings.TrimSuffix(endpoint, "/") + "/" + acfg.Container
+
+	// the failed requests are retried like those of the S3 providers, the SDK retries
+	// 3 times when MaxRetries is 0
+	retries := int32(cfg.StorageConfig.MaxRetries)
+	if retries == 0 {
+		retries = -1
+	}
+	client, err := container.NewClientWithSharedKeyCredential(containerURL, credential, &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: newHTTPClient(cfg),
+			Retry: policy.RetryOptions{
+				MaxRetries:    retries,
+				RetryDelay:    100 * time.Millisecond,
+				MaxRetryDelay: cfg.StorageConfig.RetryMaxBackoff,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the azure blob storage client: %w", err)
+	}
+
+	log.Infow("azure blob storage configured", "endpoint", endpoint, "container", acfg.Container)
+	return &AzureStorage{
+		Client:     client,
+		Cfg:        cfg,
+		Stats:      stats,
+		credential: credential,
+	}, nil
+}
+
+// Put uploads the body in blocks, so that it does not have to be held in memory, and
+// commits them once all were uploaded; a body smaller than a block is uploaded at
+// once. The tags are kept as the blob index tags.
+func (a *AzureStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, tags ObjectTags) (err error) {
+	ctx, cancel := withOperationTimeout(ctx, a.Cfg)
+	defer cancel()
+
+	done := startOperation(a.Stats, OpPut)
+	counter := &countingReader{Reader: body}
+	defer func() {
+		done(err)
+		orNoStats(a.Stats).BytesTransferred(Uploaded, outcome(err), counter.n)
+	}()
+
+	blockSize := a.BlockSize
+	if blockSize <= 0 {
+		blockSize = azureBlockSize
+	}
+	_, err = a.Client.NewBlockBlobClient(key).UploadStream(ctx, counter, &blockblob.UploadStreamOptions{
+		BlockSize:   blockSize,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+		Tags:        tags.Metadata(),
+	})
+	return operationError("upload", err)
+}
+
+func (a *AzureStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return a.get(ctx, key, blob.HTTPRange{})
+}
+
+func (a *AzureStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return a.get(ctx, key, blob.HTTPRange{Offset: offset, Count: length})
+}
+
+func (a *AzureStorage) get(ctx context.Context, key string, byteRange blob.HTTPRange) (io.ReadCloser, error) {
+	// the deadline also covers reading the body, so it is only released once the
+	// body is closed
+	ctx, cancel := withOperationTimeout(ctx, a.Cfg)
+	done := startOperation(a.Stats, OpGet)
+	resp, err := a.Client.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{Range: byteRange})
+	if err != nil {
+		cancel()
+		if isAzureNotFound(err) {
+			err = ErrObjectNotFound
+		} else {
+			err = operationError("get", err)
+		}
+		done(err)
+		return nil, err
+	}
+	done(nil)
+	body := &downloadCounter{ReadCloser: resp.Body, stats: orNoStats(a.Stats)}
+	return &cancelOnClose{ReadCloser: body, cancel: cancel}, nil
+}
+
+func (a *AzureStorage) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	ctx, cancel := withOperationTimeout(ctx, a.Cfg)
+	defer cancel()
+
+	done := startOperation(a.Stats, OpStat)
+	defer func() { done(err) }()
+
+	props, err := a.Client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		if isAzureNotFound(err) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, operationError("stat", err)
+	}
+
+	info = ObjectInfo{Key: key}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.ContentType != nil {
+		info.ContentType = *props.ContentType
+	}
+	if props.LastModified != nil {
+		info.LastModified = *props.LastModified
+	}
+	return info, nil
+}
+
+func (a *AzureStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	pager := a.Client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, operationError("list", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			info := ObjectInfo{Key: *item.Name}
+			if props := item.Properties; props != nil {
+				if props.ContentLength != nil {
+					info.Size = *props.ContentLength
+				}
+				if props.ContentType != nil {
+					info.ContentType = *props.ContentType
+				}
+				if props.LastModified != nil {
+					info.LastModified = *props.LastModified
+				}
+			}
+			objects = append(objects, info)
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the blobs one at a time, the Blob REST api has no deletes by prefix
+// outside of batch requests.
+func (a *AzureStorage) Delete(ctx context.Context, prefix string) (n int, err error) {
+	done := startOperation(a.Stats, OpDelete)
+	var bytes int64
+	defer func() {
+		done(err)
+		// the objects removed before a failure are counted as well
+		orNoStats(a.Stats).BytesTransferred(Deleted, outcome(err), bytes)
+	}()
+
+	objects, err := a.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		_, err := a.Client.NewBlobClient(object.Key).Delete(ctx, nil)
+		if err != nil && !isAzureNotFound(err) {
+			return n, operationError("delete", fmt.Errorf("failed to delete object '%s': %w", object.Key, err))
+		}
+		n++
+		bytes += object.Size
+	}
+	return n, nil
+}
+
+// Presign returns a url with a service SAS that allows reading the blob until it
+// expires.
+func (a *AzureStorage) Presign(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	values := sas.BlobSignatureValues{
+		ExpiryTime:    time.Now().UTC().Add(expires),
+		Permissions:   (&sas.BlobPermissions{Read: true}).String(),
+		ContainerName: a.Cfg.StorageConfig.Azure.Container,
+		BlobName:      key,
+	}
+	if filename != "" {
+		values.ContentDisposition = attachment(filename)
+	}
+	query, err := values.SignWithSharedKey(a.credential)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the url of the blob: %w", err)
+	}
+	return a.Client.NewBlobClient(key).URL() + "?" + query.Encode(), nil
+}
+
+// HealthCheck reads the properties of the container.
+func (a *AzureStorage) HealthCheck(ctx context.Context) error {
+	ctx, cancel := withOperationTimeout(ctx, a.Cfg)
+	defer cancel()
+
+	_, err := a.Client.GetProperties(ctx, nil)
+	return operationError("health_check", err)
+}
@@ -0,0 +1,109 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+// S3BucketAPI defines the interface for the functions used to bootstrap the bucket.
+// We use this interface to test the bootstrap using a mocked service.
+type S3BucketAPI interface {
+	HeadBucket(ctx context.Context,
+		params *s3.HeadBucketInput,
+		optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	CreateBucket(ctx context.Context,
+		params *s3.CreateBucketInput,
+		optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context,
+		params *s3.PutBucketLifecycleConfigurationInput,
+		optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+}
+
+// LifecycleRules returns the lifecycle rules of the exports bucket. They only back up
+// the cleanups, so an archive is kept for the longest expiry an export may request and
+// the cleanup delay after it, and the raw per-source objects are kept for the grace
+// days after that. The objects are never expired when the expiry of the exports is not
+// bounded. Incomplete multipart uploads are aborted after a day.
+func LifecycleRules(cfg econfig.ExportConfig) []types.LifecycleRule {
+	rules := []types.LifecycleRule{
+		{
+			ID:     aws.String("abort-incomplete-multipart-uploads"),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilterMemberPrefix{Value: ""},
+			AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: 1,
+			},
+		},
+	}
+	maxExpiryDays := cfg.LimitsConfig.MaxExpiryDays
+	if maxExpiryDays <= 0 {
+		return rules
+	}
+	if maxExpiryDays < cfg.ExportExpiryDays {
+		maxExpiryDays = cfg.ExportExpiryDays
+	}
+	archiveDays := int32(maxExpiryDays + cfg.ExportExpiryDays)
+	sourceDays := archiveDays + int32(cfg.StorageConfig.SourceObjectGraceDays)
+
+	return append(rules,
+		types.LifecycleRule{
+			ID:     aws.String("expire-archives"),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilterMemberTag{
+				Value: types.Tag{Key: aws.String(ObjectTypeTag), Value: aws.String(ArchiveObject)},
+			},
+			Expiration: &types.LifecycleExpiration{Days: archiveDays},
+		},
+		types.LifecycleRule{
+			ID:     aws.String("expire-source-objects"),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilterMemberTag{
+				Value: types.Tag{Key: aws.String(ObjectTypeTag), Value: aws.String(SourceObject)},
+			},
+			Expiration: &types.LifecycleExpiration{Days: sourceDays},
+		},
+	)
+}
+
+// BootstrapBucket creates the exports bucket if it does not exist and applies its
+// lifecycle configuration. The returned error is meant to be logged; the service
+// keeps running without a bootstrapped bucket.
+func BootstrapBucket(ctx context.Context, api S3BucketAPI, cfg econfig.ExportConfig, log *zap.SugaredLogger) error {
+	bucket := cfg.StorageConfig.Bucket
+	if cfg.StorageConfig.Provider == ProviderGCS {
+		// gcs does not accept the S3 lifecycle configuration
+		return fmt.Errorf("bootstrapping is not supported by the %s provider, create bucket '%s' and its lifecycle rules in gcs", ProviderGCS, bucket)
+	}
+
+	_, err := api.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &bucket})
+	if err != nil {
+		var notFound *types.NotFound
+		if !errors.As(err, &notFound) {
+			return fmt.Errorf("failed to check bucket '%s': %w", bucket, err)
+		}
+
+		log.Infow("creating bucket", "bucket", bucket)
+		if _, err := api.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &bucket}); err != nil {
+			return fmt.Errorf("failed to create bucket '%s': %w", bucket, err)
+		}
+	}
+
+	input := &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &bucket,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: LifecycleRules(cfg)},
+	}
+	if _, err := api.PutBucketLifecycleConfiguration(ctx, input); err != nil {
+		return fmt.Errorf("failed to apply lifecycle configuration to bucket '%s': %w", bucket, err)
+	}
+
+	log.Infow("bucket bootstrapped", "bucket", bucket)
+	return nil
+}
@@ -0,0 +1,93 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+type mockBucketAPI struct {
+	headErr      error
+	lifecycleErr error
+	created      bool
+	lifecycle    *types.BucketLifecycleConfiguration
+}
+
+func (m *mockBucketAPI) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, m.headErr
+}
+
+func (m *mockBucketAPI) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	m.created = true
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (m *mockBucketAPI) PutBucketLifecycleConfiguration(ctx context.Context, params *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	if m.lifecycleErr != nil {
+		return nil, m.lifecycleErr
+	}
+	m.lifecycle = params.LifecycleConfiguration
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+var _ = Describe("Bootstrap the bucket", func() {
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		cfg = *config.Get()
+		cfg.ExportExpiryDays = 7
+		cfg.LimitsConfig.MaxExpiryDays = 90
+		cfg.StorageConfig.SourceObjectGraceDays = 2
+	})
+
+	It("creates a missing bucket and applies the lifecycle rules", func() {
+		api := &mockBucketAPI{headErr: &types.NotFound{}}
+
+		Expect(es3.BootstrapBucket(context.Background(), api, cfg, zap.NewNop().Sugar())).To(Succeed())
+		Expect(api.created).To(BeTrue())
+		Expect(api.lifecycle).ShouldNot(BeNil())
+
+		rules := map[string]types.LifecycleRule{}
+		for _, rule := range api.lifecycle.Rules {
+			rules[*rule.ID] = rule
+		}
+		// the archives outlive the longest expiry an export may request
+		Expect(rules["expire-archives"].Expiration.Days).To(Equal(int32(97)))
+		Expect(rules["expire-archives"].Filter).To(Equal(&types.LifecycleRuleFilterMemberTag{
+			Value: types.Tag{Key: &[]string{es3.ObjectTypeTag}[0], Value: &[]string{es3.ArchiveObject}[0]},
+		}))
+		Expect(rules["expire-source-objects"].Expiration.Days).To(Equal(int32(99)))
+		Expect(rules["abort-incomplete-multipart-uploads"].AbortIncompleteMultipartUpload.DaysAfterInitiation).To(Equal(int32(1)))
+	})
+
+	It("does not expire the objects when the expiry of the exports is not bounded", func() {
+		cfg.LimitsConfig.MaxExpiryDays = 0
+
+		rules := es3.LifecycleRules(cfg)
+		Expect(rules).To(HaveLen(1))
+		Expect(*rules[0].ID).To(Equal("abort-incomplete-multipart-uploads"))
+	})
+
+	It("keeps an existing bucket", func() {
+		api := &mockBucketAPI{}
+
+		Expect(es3.BootstrapBucket(context.Background(), api, cfg, zap.NewNop().Sugar())).To(Succeed())
+		Expect(api.created).To(BeFalse())
+		Expect(api.lifecycle.Rules).To(HaveLen(3))
+	})
+
+	It("reports missing permissions", func() {
+		api := &mockBucketAPI{lifecycleErr: errors.New("AccessDenied")}
+
+		err := es3.BootstrapBucket(context.Background(), api, cfg, zap.NewNop().Sugar())
+		Expect(err).Should(MatchError(ContainSubstring("failed to apply lifecycle configuration")))
+	})
+})
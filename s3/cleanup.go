@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// S3DeleteObjectsAPI defines the interface for the functions used to remove the
+// objects under a prefix. We use this interface to test the function using a mocked
+// service.
+type S3DeleteObjectsAPI interface {
+	S3ListObjectsAPI
+	DeleteObjects(ctx context.Context,
+		params *s3.DeleteObjectsInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// SourceObjectsPrefix returns the prefix under which the raw per-source objects of
+// an export are stored.
+func SourceObjectsPrefix(m *models.ExportPayload) string {
+	return fmt.Sprintf("%s/%s/", m.OrganizationID, m.ID)
+}
+
+// SourceObjectKey returns the key of the raw object uploaded for the source.
+func SourceObjectKey(m *models.ExportPayload, source models.Source) string {
+	return fmt.Sprintf("%s%s.%s", SourceObjectsPrefix(m), source.ID, source.Format)
+}
+
+// DeleteObjects removes every object under the prefix and returns how many objects
+// were removed.
+func DeleteObjects(ctx context.Context, api S3DeleteObjectsAPI, bucket, prefix string) (int, error) {
+	deleted, _, err := deleteObjects(ctx, api, bucket, prefix, batchRemover(api, bucket))
+	return deleted, err
+}
+
+// objectRemover removes a page of listed objects.
+type objectRemover func(ctx context.Context, objects []types.ObjectIdentifier) error
+
+// batchRemover removes the objects with a single DeleteObjects request.
+func batchRemover(api S3DeleteObjectsAPI, bucket string) objectRemover {
+	return func(ctx context.Context, objects []types.ObjectIdentifier) error {
+		out, err := api.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &types.Delete{Objects: objects, Quiet: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %d objects, first error: %s", len(out.Errors), stringValue(out.Errors[0].Message))
+		}
+		return nil
+	}
+}
+
+// deleteObjects removes the objects under the prefix, returning how many objects and
+// bytes were removed.
+func deleteObjects(ctx context.Context, api S3ListObjectsAPI, bucket, prefix string, remove objectRemover) (int, int64, error) {
+	deleted := 0
+	var deletedBytes int64
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}
+	for {
+		resp, err := GetObjects(ctx, api, input)
+		if err != nil {
+			return deleted, deletedBytes, fmt.Errorf("failed to list bucket objects: %w", err)
+		}
+
+		if len(resp.Contents) > 0 {
+			var objects []types.ObjectIdentifier
+			var bytes int64
+			for _, obj := range resp.Contents {
+				objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+				bytes += obj.Size
+			}
+
+			if err := remove(ctx, objects); err != nil {
+				return deleted, deletedBytes, err
+			}
+			deleted += len(objects)
+			deletedBytes += bytes
+		}
+
+		if !resp.IsTruncated {
+			return deleted, deletedBytes, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+// DeleteSourceObjects removes the raw per-source objects of a packaged export from
+// the bucket and records that they are gone. Retrying an export requests the data
+// from the source applications again, so nothing depends on these objects once the
+// archive exists. It returns how many objects were removed.
+func (c *Compressor) DeleteSourceObjects(ctx context.Context, db models.DBInterface, m *models.ExportPayload) (int, error) {
+	n, err := c.Storage.Delete(ctx, SourceObjectsPrefix(m))
+	if err != nil {
+		return n, err
+	}
+	c.Log.Infow("deleted source objects", "export_id", m.ID, "count", n)
+
+	return n, m.SetSourceObjectsDeleted(db)
+}
+
+// DeleteExportObjects removes the archive and the raw per-source objects of an export
+// that was deleted from the database. It returns how many objects were removed.
+func (c *Compressor) DeleteExportObjects(ctx context.Context, m *models.ExportPayload) (int, error) {
+	n, err := c.Storage.Delete(ctx, SourceObjectsPrefix(m))
+	if err != nil {
+		return n, err
+	}
+	if m.S3Key != "" {
+		removed, err := c.Storage.Delete(ctx, m.S3Key)
+		n += removed
+		if err != nil {
+			return n, err
+		}
+	}
+	c.Log.Infow("deleted export objects", "export_id", m.ID, "count", n)
+	return n, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
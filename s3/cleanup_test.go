@@ -0,0 +1,70 @@
+package s3_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+// mockObjectsAPI serves the objects in pages of a single object.
+type mockObjectsAPI struct {
+	objects []string
+	deleted []string
+}
+
+func (m *mockObjectsAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	var matching []string
+	for _, key := range m.objects {
+		if strings.HasPrefix(key, *params.Prefix) {
+			matching = append(matching, key)
+		}
+	}
+
+	start := 0
+	if params.ContinuationToken != nil {
+		for i, key := range matching {
+			if key == *params.ContinuationToken {
+				start = i
+			}
+		}
+	}
+	if start >= len(matching) {
+		return &s3.ListObjectsV2Output{}, nil
+	}
+
+	out := &s3.ListObjectsV2Output{Contents: []types.Object{{Key: aws.String(matching[start])}}}
+	if start+1 < len(matching) {
+		out.IsTruncated = true
+		out.NextContinuationToken = aws.String(matching[start+1])
+	}
+	return out, nil
+}
+
+func (m *mockObjectsAPI) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	for _, obj := range params.Delete.Objects {
+		m.deleted = append(m.deleted, *obj.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+var _ = Describe("Delete the source objects", func() {
+	It("removes every object under the prefix and keeps the archive", func() {
+		api := &mockObjectsAPI{objects: []string{
+			"10000001/export-id/source-1.json",
+			"10000001/export-id/source-2.csv",
+			"10000001/2023-01-01T00:00:00Z-export-id.tar.gz",
+		}}
+
+		n, err := es3.DeleteObjects(context.Background(), api, "exports-bucket", "10000001/export-id/")
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		Expect(api.deleted).To(ConsistOf("10000001/export-id/source-1.json", "10000001/export-id/source-2.csv"))
+	})
+})
@@ -1,35 +1,45 @@
 package s3
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	econfig "github.com/redhatinsights/export-service-go/config"
 	"go.uber.org/zap"
 
+	"github.com/redhatinsights/export-service-go/encryption"
+	"github.com/redhatinsights/export-service-go/faults"
 	export_logger "github.com/redhatinsights/export-service-go/logger"
 	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/notify"
+	"github.com/redhatinsights/export-service-go/reporting"
 )
 
 const formatDateTime = "2006-01-02T15:04:05Z" // ISO 8601
 
 type Compressor struct {
-	Bucket string
-	Log    *zap.SugaredLogger
-	Client s3.Client
-	Cfg    econfig.ExportConfig
+	Log *zap.SugaredLogger
+	// Storage holds the source payloads and the export archives
+	Storage Storage
+	Cfg     econfig.ExportConfig
+	// Notifier is informed once an export reaches a terminal state, it may be nil
+	Notifier notify.Notifier
+	// Events publishes the transitions of the exports and of the failed uploads, it
+	// may be nil
+	Events notify.StatusPublisher
+	// Faults fails the packaging of exports on demand when debugging, it may be nil
+	Faults *faults.Injector
 }
 
 // S3ListObjectsAPI defines the interface for the ListObjectsV2 function.
@@ -43,98 +53,92 @@ type S3ListObjectsAPI interface {
 
 type StorageHandler interface {
 	Compress(ctx context.Context, m *models.ExportPayload) (time.Time, string, string, error)
-	Download(ctx context.Context, w io.WriterAt, bucket, key *string) (n int64, err error)
-	Upload(ctx context.Context, body io.Reader, bucket, key *string) (*manager.UploadOutput, error)
-	CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error
+	CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, checksum string, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error
 	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+	PresignObject(ctx context.Context, key string, expires time.Duration, filename string) (string, error)
+	// PartialArchive returns the archive of the completed sources of an export that
+	// is not packaged yet
+	PartialArchive(ctx context.Context, m *models.ExportPayload) (io.WriterTo, error)
+	// DeleteExportObjects removes the archive and the source objects of a deleted
+	// export
+	DeleteExportObjects(ctx context.Context, m *models.ExportPayload) (int, error)
+	// the multipart uploads of the source payloads
+	CreateMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload) (string, error)
+	UploadObjectPart(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string, partNumber int, body io.ReadSeeker) error
+	CompleteMultipartObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID, checksum string, maxBytes int64) error
+	AbortMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string) error
+	// CompleteStoredObject records the payload stored by the application itself
+	CompleteStoredObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, checksum string, maxBytes int64) error
 	ProcessSources(db models.DBInterface, uid uuid.UUID)
 }
 
+// ErrNoCompletedSources is returned for the partial archive of an export none of whose
+// sources has completed.
+var ErrNoCompletedSources = errors.New("no source of the export has completed")
+
 func GetObjects(c context.Context, api S3ListObjectsAPI, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
 	return api.ListObjectsV2(c, input)
 }
 
-func (c *Compressor) zipExport(ctx context.Context, prefix, filename, s3key string, meta ExportMeta, sources []models.Source) error {
-	input := &s3.ListObjectsV2Input{
-		Bucket: &c.Bucket,
-		Prefix: &prefix,
-	}
+// ArchiveFile is a single source payload that is added to an export archive.
+type ArchiveFile struct {
+	// Name is the base name of the file within the archive, e.g. `<source-uuid>.csv`
+	Name string
+	Size int64
+	Body io.Reader
+	// Open returns the body when Body is nil, it is only called once the file is
+	// written so that a single source is downloaded at a time
+	Open func() (io.ReadCloser, error)
+}
 
+// BuildArchive writes an archive of the format containing the given files to w, along
+// with the meta.json and README.md files describing them.
+func BuildArchive(w io.Writer, format models.ArchiveFormat, files []ArchiveFile, meta ExportMeta, sources []models.Source) error {
 	var fileMeta []ExportFileMeta
 
-	var buf bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buf)
-	tarWriter := tar.NewWriter(gzipWriter)
-
-	s3client := NewS3Client(c.Cfg, c.Log)
-
-	resp, err := GetObjects(ctx, s3client, input)
+	archive, err := newArchiveWriter(w, format)
 	if err != nil {
-		return fmt.Errorf("failed to list bucket objects: %w", err)
+		return err
 	}
 
-	for _, obj := range resp.Contents {
-
-		c.Log.Infof("downloading s3://%s/%s...", c.Bucket, *obj.Key)
-		basename := filepath.Base(*obj.Key)
-
+	for _, file := range files {
 		// save id from the basename without the extension
-		id := strings.Split(basename, ".")[0]
-
-		f, err := os.CreateTemp("", basename)
-		if err != nil {
-			return fmt.Errorf("failed to create temp file: %w", err)
-		}
-		if _, err := c.Download(ctx, f, &c.Bucket, obj.Key); err != nil {
-			return fmt.Errorf("failed to download to file: %w", err)
-		}
-		fi, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("failed to get file info: %w", err)
-		}
-
-		tempFileMeta, err := findFileMeta(id, basename, sources)
+		id := strings.Split(file.Name, ".")[0]
 
+		tempFileMeta, err := findFileMeta(id, file.Name, sources)
 		if err != nil {
 			return fmt.Errorf("failed to parse file meta: %w", err)
 		}
-
-		fileMeta = append(fileMeta, *tempFileMeta)
-
-		header, err := tar.FileInfoHeader(fi, basename)
-		if err != nil {
-			return fmt.Errorf("failed to create file header: %w", err)
+		if tempFileMeta != nil {
+			fileMeta = append(fileMeta, *tempFileMeta)
 		}
-		header.Name = basename
 
-		if err = tarWriter.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write header: %w", err)
+		fw, err := archive.Create(file.Name, file.Size, time.Now())
+		if err != nil {
+			return err
 		}
-		if _, err := io.Copy(tarWriter, f); err != nil {
-			return fmt.Errorf("failed to copy data into tar file: %w", err)
+		if err := copyArchiveFile(fw, file); err != nil {
+			return err
 		}
-		c.Log.Infof("added file %s to payload", basename)
 	}
 
 	// add the file metadata to the ExportMeta struct
 	meta.FileMeta = fileMeta
+	meta.Failures = models.SourceFailures(sources)
 
 	metaJSON, err := BuildMeta(&meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal meta struct: %w", err)
 	}
 
-	// add the json file to the tar
-	metaHeader := &tar.Header{
-		Name: "meta.json",
-		Mode: 0600,
-		Size: int64(len(metaJSON)),
-	}
-
-	if err := tarWriter.WriteHeader(metaHeader); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	// add the json file to the archive
+	fw, err := archive.Create("meta.json", int64(len(metaJSON)), time.Time{})
+	if err != nil {
+		return err
 	}
-	if _, err := tarWriter.Write(metaJSON); err != nil {
+	if _, err := fw.Write(metaJSON); err != nil {
 		return fmt.Errorf("failed to write meta.json: %w", err)
 	}
 
@@ -144,50 +148,147 @@ func (c *Compressor) zipExport(ctx context.Context, prefix, filename, s3key stri
 		return fmt.Errorf("failed to build README.md: %w", err)
 	}
 
-	// add the README.md file to the tar
-	readmeHeader := &tar.Header{
-		Name: "README.md",
-		Mode: 0600,
-		Size: int64(len(readme)),
-	}
-
-	if err := tarWriter.WriteHeader(readmeHeader); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	// add the README.md file to the archive
+	fw, err = archive.Create("README.md", int64(len(readme)), time.Time{})
+	if err != nil {
+		return err
 	}
-	if _, err := tarWriter.Write([]byte(readme)); err != nil {
+	if _, err := fw.Write([]byte(readme)); err != nil {
 		return fmt.Errorf("failed to write README.md: %w", err)
 	}
 
-	// produce tar
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+	// the archives of the exports complete with errors describe the sources that failed
+	if len(meta.Failures) > 0 {
+		failuresJSON, err := json.Marshal(meta.Failures)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the failures: %w", err)
+		}
+		fw, err = archive.Create("failures.json", int64(len(failuresJSON)), time.Time{})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(failuresJSON); err != nil {
+			return fmt.Errorf("failed to write failures.json: %w", err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// copyArchiveFile copies the body of the file into the archive, opening it first when
+// it is streamed from the storage.
+func copyArchiveFile(w io.Writer, file ArchiveFile) error {
+	body := file.Body
+	if body == nil {
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", file.Name, err)
+		}
+		defer rc.Close()
+		body = storageReader{rc}
 	}
-	// produce gzip
-	if err := gzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
+	if _, err := io.Copy(w, body); err != nil {
+		return fmt.Errorf("failed to copy data into the archive: %w", err)
 	}
+	return nil
+}
+
+// storageReader records the failed reads of a download like the other failed
+// storage operations.
+type storageReader struct {
+	io.Reader
+}
+
+func (r storageReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = operationError("download", err)
+	}
+	return n, err
+}
 
-	f, err := os.CreateTemp("", filename)
+// zipExport streams the source objects under the prefix into an archive, which is
+// encrypted to the key when one is given, while the archive is uploaded to s3key.
+// Only the chunk being copied and the parts of the upload are held in memory, so the
+// size of an export is not bounded by the memory of the pod.
+func (c *Compressor) zipExport(ctx context.Context, prefix, s3key string, format models.ArchiveFormat, meta ExportMeta, sources []models.Source, tags ObjectTags, key *encryption.PublicKey) error {
+	files, err := c.archiveFiles(ctx, prefix)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return err
 	}
 
-	if _, err := io.Copy(f, &buf); err != nil {
-		return fmt.Errorf("failed to copy buffer into file: %w", err)
+	contentType := format.ContentType()
+	if key != nil {
+		// the checksum and the size of the archive are the ones of the encrypted file
+		contentType = encryption.ContentType
 	}
 
-	c.Log.Infof("saving temp file %s", filename)
-	c.Log.Infof("shipping %s to s3", filename)
+	// the archive is written to the pipe while the upload reads from it, a failed
+	// archive fails the upload so that no partial archive is stored
+	pr, pw := io.Pipe()
+	archived := make(chan error, 1)
+	go func() {
+		err := writeArchive(pw, format, files, meta, sources, key)
+		pw.CloseWithError(err)
+		archived <- err
+	}()
+
+	c.Log.Infof("shipping %s to storage", s3key)
+	uploadErr := c.Storage.Put(ctx, s3key, pr, contentType, tags)
+	// stop the archive when the upload failed before reading all of it
+	pr.CloseWithError(uploadErr)
+	archiveErr := <-archived
 
-	// seek to the beginning of the file so that we can reuse the file handler for upload
-	if _, err := f.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to seek to beginning of file: %w", err)
+	if archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) && !errors.Is(archiveErr, uploadErr) {
+		return archiveErr
+	}
+	if uploadErr != nil {
+		return fmt.Errorf("failed to upload archive `%s` to storage: %w", s3key, uploadErr)
 	}
+	return nil
+}
 
-	if _, err := c.Upload(ctx, f, &c.Cfg.StorageConfig.Bucket, &s3key); err != nil {
-		return fmt.Errorf("failed to upload tarfile `%s` to s3: %w", s3key, err)
+// archiveFiles returns the source objects under the prefix as the files of an archive,
+// which are downloaded once they are written.
+func (c *Compressor) archiveFiles(ctx context.Context, prefix string) ([]ArchiveFile, error) {
+	objects, err := c.Storage.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bucket objects: %w", err)
+	}
+
+	var files []ArchiveFile
+	for _, obj := range objects {
+		obj := obj
+		basename := filepath.Base(obj.Key)
+		files = append(files, ArchiveFile{
+			Name: basename,
+			Size: obj.Size,
+			Open: func() (io.ReadCloser, error) {
+				c.Log.Infof("adding %s to the archive", obj.Key)
+				return c.Storage.Get(ctx, obj.Key)
+			},
+		})
+	}
+	return files, nil
+}
+
+// writeArchive writes the archive of the files to w, encrypted to the key when one is
+// given.
+func writeArchive(w io.Writer, format models.ArchiveFormat, files []ArchiveFile, meta ExportMeta, sources []models.Source, key *encryption.PublicKey) error {
+	if key == nil {
+		return BuildArchive(w, format, files, meta, sources)
 	}
 
+	encrypted, err := key.Encrypt(w)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt the archive: %w", err)
+	}
+	if err := BuildArchive(encrypted, format, files, meta, sources); err != nil {
+		return err
+	}
+	if err := encrypted.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt the archive: %w", err)
+	}
 	return nil
 }
 
@@ -195,8 +296,24 @@ func (c *Compressor) Compress(ctx context.Context, m *models.ExportPayload) (tim
 	t := time.Now()
 
 	c.Log.Infof("starting payload compression for %s", m.ID)
-	prefix := fmt.Sprintf("%s/%s/", m.OrganizationID, m.ID)
-	filename := fmt.Sprintf("%s-%s.tar.gz", t.UTC().Format(formatDateTime), m.ID.String())
+	if c.Faults.TakePackagingError(m.ID) {
+		return t, "", "", fmt.Errorf("failed to package %s: %w", m.ID, faults.ErrInjected)
+	}
+	prefix := SourceObjectsPrefix(m)
+	format := m.ArchiveFormat
+	if format == "" {
+		format = models.TarGz
+	}
+	filename := fmt.Sprintf("%s-%s.%s", t.UTC().Format(formatDateTime), m.ID.String(), format)
+
+	var key *encryption.PublicKey
+	if m.IsEncrypted() {
+		var err error
+		if key, err = encryption.ParsePublicKey(m.EncryptionPublicKey); err != nil {
+			return t, filename, "", fmt.Errorf("failed to parse the encryption key: %w", err)
+		}
+		filename += encryption.Extension
+	}
 	s3key := fmt.Sprintf("%s/%s", m.OrganizationID, filename)
 
 	sources, err := m.GetSources()
@@ -204,105 +321,229 @@ func (c *Compressor) Compress(ctx context.Context, m *models.ExportPayload) (tim
 		return t, filename, s3key, fmt.Errorf("failed to get sources: %w", err)
 	}
 
-	meta := ExportMeta{
+	meta := exportMeta(m)
+
+	tags := ObjectTags{OrgID: m.OrganizationID, ExportID: m.ID.String(), Type: ArchiveObject}
+
+	err = c.zipExport(ctx, prefix, s3key, format, meta, sources, tags, key)
+	return t, filename, s3key, err
+}
+
+// exportMeta returns the description of the export in its archive.
+func exportMeta(m *models.ExportPayload) ExportMeta {
+	return ExportMeta{
+		ExportName:  m.Name,
 		ExportBy:    m.User.Username,
 		ExportDate:  m.CreatedAt.UTC().Format(formatDateTime),
 		ExportOrgID: m.User.OrganizationID,
 		HelpString:  helpString,
 	}
-
-	err = c.zipExport(ctx, prefix, filename, s3key, meta, sources)
-	return t, filename, s3key, err
 }
 
-func (c *Compressor) Download(ctx context.Context, w io.WriterAt, bucket, key *string) (n int64, err error) {
-	s3client := NewS3Client(c.Cfg, c.Log)
-
-	downloader := manager.NewDownloader(s3client, func(d *manager.Downloader) {
-		d.PartSize = 100 * 1024 * 1024 // 100 MiB
-	})
-
-	input := &s3.GetObjectInput{Bucket: bucket, Key: key}
+// partialArchive is the archive of the completed sources of an export, which is
+// streamed to the download rather than stored.
+type partialArchive struct {
+	format  models.ArchiveFormat
+	files   []ArchiveFile
+	meta    ExportMeta
+	sources []models.Source
+	key     *encryption.PublicKey
+}
 
-	return downloader.Download(ctx, w, input)
+func (a *partialArchive) WriteTo(w io.Writer) (int64, error) {
+	var counter byteCounter
+	err := writeArchive(io.MultiWriter(w, &counter), a.format, a.files, a.meta, a.sources, a.key)
+	return int64(counter), err
 }
 
-func (c *Compressor) Upload(ctx context.Context, body io.Reader, bucket, key *string) (*manager.UploadOutput, error) {
-	s3client := NewS3Client(c.Cfg, c.Log)
+// PartialArchive returns the archive of the sources of the export that completed, in
+// the format of the export and encrypted like its archive would be. The sources are
+// only downloaded while the archive is written. ErrNoCompletedSources is returned when
+// no source has completed yet.
+func (c *Compressor) PartialArchive(ctx context.Context, m *models.ExportPayload) (io.WriterTo, error) {
+	archive := &partialArchive{format: m.ArchiveFormat, meta: exportMeta(m)}
+	if m.IsEncrypted() {
+		key, err := encryption.ParsePublicKey(m.EncryptionPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the encryption key: %w", err)
+		}
+		archive.key = key
+	}
 
-	uploader := manager.NewUploader(s3client, func(u *manager.Uploader) {
-		u.PartSize = 100 * 1024 * 1024 // 100 MiB
-	})
+	completed := map[string]bool{}
+	for _, source := range m.Sources {
+		if source.Status == models.RSuccess {
+			archive.sources = append(archive.sources, source)
+			completed[filepath.Base(SourceObjectKey(m, source))] = true
+		}
+	}
+	if len(archive.sources) == 0 {
+		return nil, ErrNoCompletedSources
+	}
 
-	input := &s3.PutObjectInput{
-		Bucket: bucket,
-		Key:    key,
-		Body:   body,
+	files, err := c.archiveFiles(ctx, SourceObjectsPrefix(m))
+	if err != nil {
+		return nil, err
 	}
-	return uploader.Upload(ctx, input)
+	for _, file := range files {
+		if completed[file.Name] {
+			archive.files = append(archive.files, file)
+		}
+	}
+	return archive, nil
 }
 
-func getUploadSize(ctx context.Context, s3client *s3.Client, bucket, key *string) (int64, error) {
-	headObj := &s3.HeadObjectInput{
-		Bucket: bucket,
-		Key:    key,
-	}
-	headObjOutput, err := s3client.HeadObject(ctx, headObj)
+// CreateObject uploads the payload of the source. When the checksum, the hex encoded
+// sha256 the application sent with the payload, is set, the upload is verified
+// against it and a ChecksumMismatchError is returned if it differs.
+func (c *Compressor) CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, checksum string, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error {
+	_, source, err := payload.GetSource(resourceUUID)
 	if err != nil {
-		return 0, err
+		c.Log.Errorw("failed to get source", "error", err)
+		return err
 	}
-	return headObjOutput.ContentLength, nil
-}
 
-func (c *Compressor) CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error {
-	filename := fmt.Sprintf("%s/%s/%s.%s", payload.OrganizationID, payload.ID, resourceUUID, payload.Format)
+	filename := SourceObjectKey(payload, *source)
 
 	if err := payload.SetStatusRunning(db); err != nil {
 		c.Log.Errorw("failed to set running status", "error", err)
 		return err
 	}
 
-	_, uploadErr := c.Upload(ctx, body, &c.Bucket, &filename)
+	tags := ObjectTags{OrgID: payload.OrganizationID, ExportID: payload.ID.String(), Type: SourceObject}
+
+	// the checksum is computed while uploading, so that applications can verify the
+	// payload when they fetch it back
+	hash := sha256.New()
+	var size byteCounter
+	uploadErr := c.Storage.Put(ctx, filename, io.TeeReader(body, io.MultiWriter(hash, &size)), source.Format.ContentType(), tags)
 	totalUploads.Inc()
 	if uploadErr != nil {
 		failUploads.Inc()
 		c.Log.Errorf("error during upload: %v", uploadErr)
+		if ctx.Err() == nil {
+			reporting.Capture(ctx, uploadErr, append(payloadTags(payload), "application", application, "resource", resourceUUID.String())...)
+		}
+		if IsTimeout(uploadErr) || ctx.Err() != nil {
+			// leave the source pending so that the application can retry the upload
+			return uploadErr
+		}
 		statusError := models.SourceError{Message: uploadErr.Error(), Code: 1} // TODO: determine a better approach to assigning an internal status code
 		if err := payload.SetSourceStatus(db, resourceUUID, models.RFailed, &statusError); err != nil {
 			c.Log.Errorw("failed to set source status after failed upload", "error", err)
 			return uploadErr
 		}
+		failed := *source
+		failed.Status, failed.SourceError = models.RFailed, &statusError
+		notify.PublishStatus(c.Events, notify.StatusSourceFailed, *payload, &failed)
 		return uploadErr
 	}
 
-	uploadSize, err := getUploadSize(ctx, &c.Client, &c.Bucket, &filename)
-	if err != nil {
-		c.Log.Errorw("failed to get metric for upload size", "error", err)
-	} else {
-		uploadSizes.With(prometheus.Labels{"account": payload.AccountID, "org_id": payload.OrganizationID, "app": application}).Observe(float64(uploadSize))
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if err := c.verifyChecksum(ctx, filename, checksum, actual); err != nil {
+		return err
+	}
+	return c.recordUpload(ctx, db, payload, resourceUUID, application, filename, actual, int64(size))
+}
+
+// verifyChecksum deletes the upload when its checksum is not the expected one. The
+// source is left pending, so that the application can upload the payload again.
+func (c *Compressor) verifyChecksum(ctx context.Context, key, expected, actual string) error {
+	if expected == "" || expected == actual {
+		return nil
+	}
+	failUploads.Inc()
+	if _, err := c.Storage.Delete(ctx, key); err != nil {
+		c.Log.Errorw("failed to delete the upload with a mismatched checksum", "error", err)
 	}
+	return &ChecksumMismatchError{Expected: expected, Actual: actual}
+}
+
+// recordUpload keeps the checksum and size of the stored upload of the source.
+func (c *Compressor) recordUpload(ctx context.Context, db models.DBInterface, payload *models.ExportPayload, resourceUUID uuid.UUID, application, key, checksum string, size int64) error {
+	// the export may have been packaged or deleted during the upload, the upload is
+	// dropped rather than left behind
+	if err := payload.SetStatusRunning(db); err != nil {
+		c.Log.Errorw("the export no longer accepts uploads", "error", err)
+		if _, deleteErr := c.Storage.Delete(ctx, key); deleteErr != nil {
+			c.Log.Errorw("failed to delete the dropped upload", "error", deleteErr)
+		}
+		return err
+	}
+
+	if err := payload.SetSourceUpload(db, resourceUUID, checksum, size); err != nil {
+		c.Log.Errorw("failed to set source checksum", "error", err)
+	}
+	if err := payload.AddStoredBytes(db, size); err != nil {
+		c.Log.Errorw("failed to add the upload to the org storage", "error", err)
+	}
+
+	uploadSizes.With(prometheus.Labels{"account": payload.AccountID, "org_id": payload.OrganizationID, "app": application}).Observe(float64(size))
 
 	return nil
 }
 
+// byteCounter counts the bytes written to it.
+type byteCounter int64
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	*b += byteCounter(len(p))
+	return len(p), nil
+}
+
+// GetObject returns the body of the object. Reading the body fails once the context
+// is done, so that a download stops as soon as its request is cancelled.
 func (c *Compressor) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
-	input := &s3.GetObjectInput{Bucket: &c.Bucket, Key: &key}
-	//return GetObject(ctx, &c.Client, input)
-	s3Object, err := GetObject(ctx, &c.Client, input)
+	body, err := c.Storage.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-	return s3Object.Body, err
+	return &contextReader{ReadCloser: body, ctx: ctx}, nil
+}
+
+// GetObjectRange streams length bytes of the object from the offset, like GetObject.
+func (c *Compressor) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := c.Storage.GetRange(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &contextReader{ReadCloser: body, ctx: ctx}, nil
+}
+
+// contextReader fails the reads of the body once its context is done.
+type contextReader struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func (c *Compressor) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	return c.Storage.Stat(ctx, key)
+}
+
+// PresignObject returns a url that allows downloading the object, as an attachment
+// named filename, until it expires.
+func (c *Compressor) PresignObject(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	return c.Storage.Presign(ctx, key, expires, filename)
 }
 
 func (c *Compressor) compressPayload(db models.DBInterface, payload *models.ExportPayload) {
 	t, filename, s3key, err := c.Compress(context.TODO(), payload)
 	if err != nil {
 		c.Log.Errorw("failed to compress payload", "error", err)
+		reporting.Capture(context.TODO(), err, payloadTags(payload)...)
 		if err := payload.SetStatusFailed(db); err != nil {
 			c.Log.Errorw("failed to set status failed", "error", err)
 			return
 		}
+		c.notify(db, payload, notify.StatusFailed)
+		return
 	}
 
 	c.Log.Infof("done uploading %s", filename)
@@ -316,13 +557,59 @@ func (c *Compressor) compressPayload(db models.DBInterface, payload *models.Expo
 	case models.StatusComplete:
 		err = payload.SetStatusComplete(db, &t, s3key)
 	case models.StatusPartial:
-		err = payload.SetStatusPartial(db, &t, s3key)
+		err = payload.SetStatusCompleteWithErrors(db, &t, s3key)
 	}
 
+	if errors.Is(err, models.ErrStatusConflict) {
+		// the export expired and was deleted while it was packaged
+		c.Log.Warnw("the packaged export no longer exists", "error", err)
+		if _, err := c.Storage.Delete(context.TODO(), s3key); err != nil {
+			c.Log.Errorw("failed to delete the archive of the deleted export", "error", err)
+		}
+		return
+	}
 	if err != nil {
 		c.Log.Errorw("failed updating model status", "error", err)
 		return
 	}
+
+	if info, err := c.Storage.Stat(context.TODO(), s3key); err != nil {
+		c.Log.Errorw("failed to get the archive size", "error", err)
+	} else {
+		format := payload.ArchiveFormat
+		if format == "" {
+			format = models.TarGz
+		}
+		archiveBytes.WithLabelValues(string(format)).Observe(float64(info.Size))
+		if err := payload.AddStoredBytes(db, info.Size); err != nil {
+			c.Log.Errorw("failed to add the archive to the org storage", "error", err)
+		}
+	}
+
+	// the source objects of encrypted exports are never kept, they are not encrypted
+	if !c.Cfg.StorageConfig.KeepSourceObjects || payload.IsEncrypted() {
+		if _, err := c.DeleteSourceObjects(context.TODO(), db, payload); err != nil {
+			// the expired export cleaner retries the deletion
+			c.Log.Errorw("failed to delete source objects", "error", err)
+		}
+	}
+
+	c.notify(db, payload, notify.StatusComplete)
+}
+
+// payloadTags are the tags of the errors reported about an export.
+func payloadTags(payload *models.ExportPayload) []string {
+	return []string{"export_id", payload.ID.String(), "org_id", payload.OrganizationID, "request_id", payload.RequestID}
+}
+
+// notify publishes the final status of the export and informs the configured notifier
+// that the export has finished.
+func (c *Compressor) notify(db models.DBInterface, payload *models.ExportPayload, status string) {
+	notify.PublishStatus(c.Events, status, *payload, nil)
+	if c.Notifier == nil || payload.NotificationURL == "" {
+		return
+	}
+	c.Notifier.Notify(db, payload.ID)
 }
 
 func (c *Compressor) ProcessSources(db models.DBInterface, uid uuid.UUID) {
@@ -341,17 +628,31 @@ func (c *Compressor) ProcessSources(db models.DBInterface, uid uuid.UUID) {
 	}
 	switch ready {
 	case models.StatusComplete, models.StatusPartial:
-		if payload.Status == models.Running {
-			logger.Infow("ready for zipping", "export-uuid", payload.ID)
-			go c.compressPayload(db, payload) // start a go-routine to not block
+		// every replica that processed the last source gets here, only the one
+		// claiming the packaging builds the archive
+		if err := payload.SetStatusPackaging(db); err != nil {
+			if errors.Is(err, models.ErrStatusConflict) {
+				logger.Debugw("the export is already packaged", "status", payload.Status)
+				return
+			}
+			logger.Errorw("failed updating model status to packaging", "error", err)
+			return
 		}
+		logger.Infow("ready for zipping", "export-uuid", payload.ID)
+		go c.compressPayload(db, payload) // start a go-routine to not block
 	case models.StatusPending:
 		return
 	case models.StatusFailed:
 		logger.Infof("all sources for payload %s reported as failure", payload.ID)
 		if err := payload.SetStatusFailed(db); err != nil {
+			if errors.Is(err, models.ErrStatusConflict) {
+				logger.Debugw("the export already failed", "status", payload.Status)
+				return
+			}
 			logger.Errorw("failed updating model status after sources failed", "error", err)
+			return
 		}
+		c.notify(db, payload, notify.StatusFailed)
 	}
 }
 
@@ -363,25 +664,66 @@ func (mc *MockStorageHandler) Compress(ctx context.Context, m *models.ExportPayl
 	return time.Now(), "filename", "s3key", nil
 }
 
-func (mc *MockStorageHandler) Download(ctx context.Context, w io.WriterAt, bucket, key *string) (n int64, err error) {
-	fmt.Println("Ran mockStorageHandler.Download")
+func (mc *MockStorageHandler) CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, checksum string, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error {
+	fmt.Println("Ran mockStorageHandler.CreateObject")
+	return nil
+}
+
+func (mc *MockStorageHandler) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	fmt.Println("Ran mockStorageHandler.GetObject")
+
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (mc *MockStorageHandler) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	fmt.Println("Ran mockStorageHandler.GetObjectRange")
+
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (mc *MockStorageHandler) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	fmt.Println("Ran mockStorageHandler.StatObject")
+	return ObjectInfo{Key: key}, nil
+}
+
+func (mc *MockStorageHandler) PresignObject(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	fmt.Println("Ran mockStorageHandler.PresignObject")
+	return "https://example.com/" + key, nil
+}
+
+func (mc *MockStorageHandler) PartialArchive(ctx context.Context, m *models.ExportPayload) (io.WriterTo, error) {
+	fmt.Println("Ran mockStorageHandler.PartialArchive")
+	return nil, ErrNoCompletedSources
+}
+
+func (mc *MockStorageHandler) DeleteExportObjects(ctx context.Context, m *models.ExportPayload) (int, error) {
+	fmt.Println("Ran mockStorageHandler.DeleteExportObjects")
 	return 0, nil
 }
 
-func (mc *MockStorageHandler) Upload(ctx context.Context, body io.Reader, bucket, key *string) (*manager.UploadOutput, error) {
-	fmt.Println("Ran mockStorageHandler.Upload")
-	return nil, nil
+func (mc *MockStorageHandler) CreateMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload) (string, error) {
+	fmt.Println("Ran mockStorageHandler.CreateMultipartObject")
+	return "upload-id", nil
 }
 
-func (mc *MockStorageHandler) CreateObject(ctx context.Context, db models.DBInterface, body io.Reader, application string, resourceUUID uuid.UUID, payload *models.ExportPayload) error {
-	fmt.Println("Ran mockStorageHandler.CreateObject")
+func (mc *MockStorageHandler) UploadObjectPart(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string, partNumber int, body io.ReadSeeker) error {
+	fmt.Println("Ran mockStorageHandler.UploadObjectPart")
 	return nil
 }
 
-func (mc *MockStorageHandler) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
-	fmt.Println("Ran mockStorageHandler.GetObject")
+func (mc *MockStorageHandler) CompleteMultipartObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID, checksum string, maxBytes int64) error {
+	fmt.Println("Ran mockStorageHandler.CompleteMultipartObject")
+	return nil
+}
+
+func (mc *MockStorageHandler) AbortMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string) error {
+	fmt.Println("Ran mockStorageHandler.AbortMultipartObject")
+	return nil
+}
 
-	return nil, nil
+func (mc *MockStorageHandler) CompleteStoredObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, checksum string, maxBytes int64) error {
+	fmt.Println("Ran mockStorageHandler.CompleteStoredObject")
+	return nil
 }
 
 func (mc *MockStorageHandler) ProcessSources(db models.DBInterface, uid uuid.UUID) {
@@ -398,7 +740,16 @@ func (mc *MockStorageHandler) ProcessSources(db models.DBInterface, uid uuid.UUI
 	}
 	switch ready {
 	case models.StatusComplete, models.StatusPartial:
-		if err := payload.SetStatusComplete(db, nil, ""); err != nil {
+		if err := payload.SetStatusPackaging(db); err != nil {
+			fmt.Printf("failed updating model status: %v", err)
+			return
+		}
+		if ready == models.StatusPartial {
+			err = payload.SetStatusCompleteWithErrors(db, nil, "")
+		} else {
+			err = payload.SetStatusComplete(db, nil, "")
+		}
+		if err != nil {
 			fmt.Printf("failed updating model status: %v", err)
 			return
 		}
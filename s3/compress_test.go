@@ -0,0 +1,395 @@
+package s3_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/DataDog/zstd"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/models"
+	"github.com/redhatinsights/export-service-go/s3"
+)
+
+// readArchive returns the contents of every file in a gzipped tarball keyed by file name.
+func readArchive(archive []byte) map[string]string {
+	return readArchiveFormat(archive, models.TarGz)
+}
+
+// readArchiveFormat returns the contents of every file in an archive of the format
+// keyed by file name.
+func readArchiveFormat(archive []byte, format models.ArchiveFormat) map[string]string {
+	contents := map[string]string{}
+	if format == models.Zip {
+		zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		Expect(err).To(BeNil())
+		for _, file := range zipReader.File {
+			rc, err := file.Open()
+			Expect(err).To(BeNil())
+			body, err := io.ReadAll(rc)
+			Expect(err).To(BeNil())
+			Expect(rc.Close()).To(Succeed())
+			contents[file.Name] = string(body)
+		}
+		return contents
+	}
+
+	var decompressed io.Reader
+	if format == models.TarZst {
+		decompressed = zstd.NewReader(bytes.NewReader(archive))
+	} else {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+		Expect(err).To(BeNil())
+		decompressed = gzipReader
+	}
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		Expect(err).To(BeNil())
+
+		body, err := io.ReadAll(tarReader)
+		Expect(err).To(BeNil())
+		contents[header.Name] = string(body)
+	}
+	return contents
+}
+
+var _ = Describe("Build the export archive", func() {
+	It("should package sources of different formats with matching extensions", func() {
+		csvSource := models.Source{
+			ID:          uuid.New(),
+			Application: "exampleApp",
+			Resource:    "systems",
+			Format:      models.CSV,
+			Filters:     []byte(`{}`),
+		}
+		jsonSource := models.Source{
+			ID:          uuid.New(),
+			Application: "exampleApp",
+			Resource:    "policies",
+			Format:      models.JSON,
+			Filters:     []byte(`{}`),
+		}
+
+		csvData := "id,name\n1,system\n"
+		jsonData := `[{"id": 1, "name": "policy"}]`
+		csvName := csvSource.ID.String() + "." + string(csvSource.Format)
+		jsonName := jsonSource.ID.String() + "." + string(jsonSource.Format)
+
+		files := []s3.ArchiveFile{
+			{Name: csvName, Size: int64(len(csvData)), Body: strings.NewReader(csvData)},
+			{Name: jsonName, Size: int64(len(jsonData)), Body: strings.NewReader(jsonData)},
+		}
+
+		var buf bytes.Buffer
+		err := s3.BuildArchive(&buf, models.TarGz, files, s3.ExportMeta{ExportBy: "user"}, []models.Source{csvSource, jsonSource})
+		Expect(err).To(BeNil())
+
+		contents := readArchive(buf.Bytes())
+		Expect(contents).To(HaveLen(4))
+		Expect(contents).To(HaveKeyWithValue(csvName, csvData))
+		Expect(contents).To(HaveKeyWithValue(jsonName, jsonData))
+		Expect(contents).To(HaveKey("meta.json"))
+		Expect(contents["README.md"]).To(ContainSubstring("### " + csvName))
+		Expect(contents["README.md"]).To(ContainSubstring("### " + jsonName))
+	})
+
+	It("describes the sources that failed in failures.json", func() {
+		source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Filters: []byte(`{}`), Status: models.RSuccess}
+		retriable := true
+		failed := models.Source{
+			ID:          uuid.New(),
+			Application: "otherApp",
+			Resource:    "policies",
+			Status:      models.RFailed,
+			SourceError: &models.SourceError{Code: 504, Message: "timed_out", Retriable: &retriable},
+		}
+		name := source.ID.String() + ".json"
+		data := `[{"id": 1}]`
+
+		var buf bytes.Buffer
+		files := []s3.ArchiveFile{{Name: name, Size: int64(len(data)), Body: strings.NewReader(data)}}
+		Expect(s3.BuildArchive(&buf, models.TarGz, files, s3.ExportMeta{ExportBy: "user"}, []models.Source{source, failed})).To(Succeed())
+
+		contents := readArchive(buf.Bytes())
+		Expect(contents).To(HaveLen(4))
+		Expect(contents["failures.json"]).To(MatchJSON(`[{"id": "` + failed.ID.String() + `", "application": "otherApp", "resource": "policies", "error": 504, "message": "timed_out", "retriable": true}]`))
+		Expect(contents["meta.json"]).NotTo(ContainSubstring("otherApp"))
+		Expect(contents["README.md"]).To(ContainSubstring("## Failed Sources"))
+		Expect(contents["README.md"]).To(ContainSubstring("- **otherApp** policies: timed_out (504, may succeed in a new export)"))
+	})
+
+	DescribeTable("packages the archives in every format",
+		func(format models.ArchiveFormat) {
+			source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Filters: []byte(`{}`)}
+			name := source.ID.String() + ".json"
+			data := `[{"id": 1}]`
+
+			var buf bytes.Buffer
+			files := []s3.ArchiveFile{{Name: name, Size: int64(len(data)), Body: strings.NewReader(data)}}
+			Expect(s3.BuildArchive(&buf, format, files, s3.ExportMeta{ExportBy: "user"}, []models.Source{source})).To(Succeed())
+
+			contents := readArchiveFormat(buf.Bytes(), format)
+			Expect(contents).To(HaveLen(3))
+			Expect(contents).To(HaveKeyWithValue(name, data))
+			Expect(contents).To(HaveKey("meta.json"))
+			Expect(contents).To(HaveKey("README.md"))
+		},
+		Entry("zip", models.Zip),
+		Entry("tar.gz", models.TarGz),
+		Entry("tar.zst", models.TarZst),
+	)
+})
+
+var _ = Describe("Compress an export", func() {
+	It("packages the source objects of the export into an archive in the storage", func() {
+		source := models.Source{
+			ID:          uuid.New(),
+			Application: "exampleApp",
+			Resource:    "systems",
+			Format:      models.JSON,
+			Filters:     []byte(`{}`),
+		}
+		export := &models.ExportPayload{
+			ID:      uuid.New(),
+			Sources: []models.Source{source},
+			User:    models.User{OrganizationID: "10000001", Username: "user"},
+		}
+
+		storage := s3.NewMemoryStorage()
+		sourceKey := s3.SourceObjectsPrefix(export) + source.ID.String() + ".json"
+		Expect(storage.Put(context.Background(), sourceKey, strings.NewReader(`[]`), "application/json", s3.ObjectTags{})).To(Succeed())
+		// objects of other exports are left out of the archive
+		Expect(storage.Put(context.Background(), "10000001/other-export/source.json", strings.NewReader(`{}`), "application/json", s3.ObjectTags{})).To(Succeed())
+
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+		_, _, key, err := c.Compress(context.Background(), export)
+		Expect(err).To(BeNil())
+
+		body, err := storage.Get(context.Background(), key)
+		Expect(err).To(BeNil())
+		archive, err := io.ReadAll(body)
+		Expect(err).To(BeNil())
+
+		contents := readArchive(archive)
+		Expect(contents).To(HaveLen(3))
+		Expect(contents).To(HaveKeyWithValue(source.ID.String()+".json", `[]`))
+
+		tags, err := storage.Tags(key)
+		Expect(err).To(BeNil())
+		Expect(tags).To(Equal(s3.ObjectTags{OrgID: "10000001", ExportID: export.ID.String(), Type: s3.ArchiveObject}))
+	})
+
+	It("packages the archive in the format of the export", func() {
+		source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Filters: []byte(`{}`)}
+		export := &models.ExportPayload{
+			ID:            uuid.New(),
+			Sources:       []models.Source{source},
+			User:          models.User{OrganizationID: "10000001", Username: "user"},
+			ArchiveFormat: models.Zip,
+		}
+
+		storage := s3.NewMemoryStorage()
+		sourceKey := s3.SourceObjectsPrefix(export) + source.ID.String() + ".json"
+		Expect(storage.Put(context.Background(), sourceKey, strings.NewReader(`[]`), "application/json", s3.ObjectTags{})).To(Succeed())
+
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+		_, filename, key, err := c.Compress(context.Background(), export)
+		Expect(err).To(BeNil())
+		Expect(filename).To(HaveSuffix(".zip"))
+
+		info, err := storage.Stat(context.Background(), key)
+		Expect(err).To(BeNil())
+		Expect(info.ContentType).To(Equal("application/zip"))
+
+		body, err := storage.Get(context.Background(), key)
+		Expect(err).To(BeNil())
+		archive, err := io.ReadAll(body)
+		Expect(err).To(BeNil())
+		Expect(readArchiveFormat(archive, models.Zip)).To(HaveKeyWithValue(source.ID.String()+".json", `[]`))
+	})
+
+	It("streams a partial archive of the completed sources", func() {
+		completed := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Status: models.RSuccess, Filters: []byte(`{}`)}
+		pending := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "policies", Format: models.JSON, Status: models.RPending, Filters: []byte(`{}`)}
+		export := &models.ExportPayload{
+			ID:            uuid.New(),
+			Sources:       []models.Source{completed, pending},
+			User:          models.User{OrganizationID: "10000001", Username: "user"},
+			ArchiveFormat: models.TarZst,
+		}
+
+		storage := s3.NewMemoryStorage()
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+
+		_, err := c.PartialArchive(context.Background(), &models.ExportPayload{ID: uuid.New(), Sources: []models.Source{pending}})
+		Expect(err).To(MatchError(s3.ErrNoCompletedSources))
+
+		// the upload of a source that is still pending is left out as well
+		for _, source := range export.Sources {
+			Expect(storage.Put(context.Background(), s3.SourceObjectKey(export, source), strings.NewReader(`[]`), "application/json", s3.ObjectTags{})).To(Succeed())
+		}
+
+		archive, err := c.PartialArchive(context.Background(), export)
+		Expect(err).To(BeNil())
+		var buf bytes.Buffer
+		n, err := archive.WriteTo(&buf)
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(int64(buf.Len())))
+
+		contents := readArchiveFormat(buf.Bytes(), models.TarZst)
+		Expect(contents).To(HaveLen(3))
+		Expect(contents).To(HaveKeyWithValue(completed.ID.String()+".json", `[]`))
+		Expect(contents["README.md"]).ToNot(ContainSubstring(pending.ID.String()))
+	})
+
+	It("encrypts the archive to the key of the export", func() {
+		entity, err := openpgp.NewEntity("export", "", "export@example.com", nil)
+		Expect(err).To(BeNil())
+		var armored bytes.Buffer
+		w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+		Expect(err).To(BeNil())
+		Expect(entity.Serialize(w)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		source := models.Source{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Filters: []byte(`{}`)}
+		export := &models.ExportPayload{
+			ID:                  uuid.New(),
+			Sources:             []models.Source{source},
+			User:                models.User{OrganizationID: "10000001", Username: "user"},
+			EncryptionPublicKey: armored.String(),
+		}
+
+		storage := s3.NewMemoryStorage()
+		sourceKey := s3.SourceObjectsPrefix(export) + source.ID.String() + ".json"
+		Expect(storage.Put(context.Background(), sourceKey, strings.NewReader(`[]`), "application/json", s3.ObjectTags{})).To(Succeed())
+
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+		_, filename, key, err := c.Compress(context.Background(), export)
+		Expect(err).To(BeNil())
+		Expect(filename).To(HaveSuffix(".tar.gz.gpg"))
+
+		info, err := storage.Stat(context.Background(), key)
+		Expect(err).To(BeNil())
+		Expect(info.ContentType).To(Equal("application/pgp-encrypted"))
+
+		body, err := storage.Get(context.Background(), key)
+		Expect(err).To(BeNil())
+		md, err := openpgp.ReadMessage(body, openpgp.EntityList{entity}, nil, nil)
+		Expect(err).To(BeNil())
+		archive, err := io.ReadAll(md.UnverifiedBody)
+		Expect(err).To(BeNil())
+		Expect(readArchive(archive)).To(HaveKeyWithValue(source.ID.String()+".json", `[]`))
+	})
+
+	It("streams the sources one at a time and stores no archive when one fails", func() {
+		sources := []models.Source{
+			{ID: uuid.New(), Application: "exampleApp", Resource: "systems", Format: models.JSON, Filters: []byte(`{}`)},
+			{ID: uuid.New(), Application: "exampleApp", Resource: "policies", Format: models.JSON, Filters: []byte(`{}`)},
+		}
+		export := &models.ExportPayload{
+			ID:      uuid.New(),
+			Sources: sources,
+			User:    models.User{OrganizationID: "10000001", Username: "user"},
+		}
+
+		storage := &streamingStorage{MemoryStorage: s3.NewMemoryStorage()}
+		for _, source := range sources {
+			key := s3.SourceObjectsPrefix(export) + source.ID.String() + ".json"
+			Expect(storage.Put(context.Background(), key, strings.NewReader(strings.Repeat("[]", 64*1024)), "application/json", s3.ObjectTags{})).To(Succeed())
+		}
+
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+		_, _, key, err := c.Compress(context.Background(), export)
+		Expect(err).To(BeNil())
+		Expect(storage.maxOpen).To(Equal(1))
+		_, err = storage.Delete(context.Background(), key)
+		Expect(err).To(BeNil())
+
+		storage.failReads = true
+		_, _, key, err = c.Compress(context.Background(), export)
+		Expect(err).To(MatchError(ContainSubstring("read failed")))
+		objects, err := storage.List(context.Background(), key)
+		Expect(err).To(BeNil())
+		Expect(objects).To(BeEmpty())
+	})
+
+	It("reports missing objects", func() {
+		storage := s3.NewMemoryStorage()
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+
+		_, err := c.GetObject(context.Background(), "10000001/missing.tar.gz")
+		Expect(errors.Is(err, s3.ErrObjectNotFound)).To(BeTrue())
+	})
+	It("aborts the download once its context is cancelled", func() {
+		storage := s3.NewMemoryStorage()
+		Expect(storage.Put(context.Background(), "10000001/export.tar.gz", strings.NewReader("archive"), "application/gzip", s3.ObjectTags{})).To(Succeed())
+		c := &s3.Compressor{Log: zap.NewNop().Sugar(), Storage: storage, Cfg: *config.Get()}
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.GetObject(cancelled, "10000001/export.tar.gz")
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		body, err := c.GetObject(ctx, "10000001/export.tar.gz")
+		Expect(err).To(BeNil())
+		cancel()
+		_, err = io.ReadAll(body)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		Expect(body.Close()).To(Succeed())
+	})
+})
+
+// streamingStorage records how many downloads are open at once, and fails their reads
+// on demand.
+type streamingStorage struct {
+	*s3.MemoryStorage
+	open, maxOpen int
+	failReads     bool
+}
+
+func (s *streamingStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.MemoryStorage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	if s.open > s.maxOpen {
+		s.maxOpen = s.open
+	}
+	return &streamedBody{ReadCloser: body, storage: s}, nil
+}
+
+type streamedBody struct {
+	io.ReadCloser
+	storage *streamingStorage
+}
+
+func (b *streamedBody) Read(p []byte) (int, error) {
+	if b.storage.failReads {
+		return 0, errors.New("read failed")
+	}
+	return b.ReadCloser.Read(p)
+}
+
+func (b *streamedBody) Close() error {
+	b.storage.open--
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TimeoutError is returned when a storage operation did not finish in time, either
+// because its deadline passed or because the storage endpoint stopped responding.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("storage %s timed out: %v", e.Op, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumMismatchError is returned when the sha256 checksum of an upload is not the
+// one the application sent with it. The upload is deleted.
+type ChecksumMismatchError struct {
+	// Expected and Actual are hex encoded
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("the sha-256 checksum of the payload is %s, not %s", e.Actual, e.Expected)
+}
+
+// IsTimeout reports whether the error is a TimeoutError.
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// operationError wraps timeouts of the storage operation in a TimeoutError and counts
+// the failure.
+func operationError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		storageErrors.WithLabelValues(op, "timeout").Inc()
+		return &TimeoutError{Op: op, Err: err}
+	}
+	storageErrors.WithLabelValues(op, "error").Inc()
+	return err
+}
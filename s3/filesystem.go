@@ -0,0 +1,427 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+const (
+	// filesystemMetaDir holds the content type and tags of the objects, next to the
+	// objects themselves
+	filesystemMetaDir = ".meta"
+	// filesystemTmpDir holds the objects being written, they are moved in place once
+	// complete so that a reader never sees a partial object
+	filesystemTmpDir = ".tmp"
+)
+
+// filesystemMeta is what the filesystem does not keep about an object.
+type filesystemMeta struct {
+	ContentType string     `json:"content_type"`
+	Tags        ObjectTags `json:"tags"`
+}
+
+// FilesystemStorage keeps the objects as files below a root directory, the keys are
+// their paths. It is meant for running the service locally without an object store,
+// the replicas of a deployment would each have their own objects.
+type FilesystemStorage struct {
+	Root string
+	// Stats records the puts, gets, stats and deletes, they are not recorded when
+	// it is nil
+	Stats Stats
+}
+
+// NewFilesystemStorage returns the storage below STORAGE_FILESYSTEM_ROOT, creating the
+// directory when it does not exist.
+func NewFilesystemStorage(cfg econfig.ExportConfig, log *zap.SugaredLogger, stats Stats) (*FilesystemStorage, error) {
+	root := cfg.StorageConfig.FilesystemRoot
+	if root == "" {
+		return nil, fmt.Errorf("the %s provider requires STORAGE_FILESYSTEM_ROOT", ProviderFilesystem)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_FILESYSTEM_ROOT: %w", err)
+	}
+	for _, dir := range []string{root, filepath.Join(root, filesystemMetaDir), filepath.Join(root, filesystemTmpDir)} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create the storage directory: %w", err)
+		}
+	}
+
+	log.Infow("filesystem storage configured", "root", root)
+	return &FilesystemStorage{Root: root, Stats: stats}, nil
+}
+
+func (f *FilesystemStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, tags ObjectTags) (err error) {
+	done := startOperation(f.Stats, OpPut)
+	counter := &countingReader{Reader: &contextReader{ReadCloser: io.NopCloser(body), ctx: ctx}}
+	defer func() {
+		done(err)
+		orNoStats(f.Stats).BytesTransferred(Uploaded, outcome(err), counter.n)
+	}()
+
+	objectPath, metaPath, err := f.paths(key)
+	if err != nil {
+		return err
+	}
+
+	if err := f.writeFile(objectPath, counter); err != nil {
+		return fmt.Errorf("failed to write object '%s': %w", key, err)
+	}
+	meta, err := json.Marshal(filesystemMeta{ContentType: contentType, Tags: tags})
+	if err != nil {
+		return err
+	}
+	if err := f.writeFile(metaPath, strings.NewReader(string(meta))); err != nil {
+		return fmt.Errorf("failed to write the metadata of object '%s': %w", key, err)
+	}
+	return nil
+}
+
+func (f *FilesystemStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return f.get(ctx, key, 0, -1)
+}
+
+func (f *FilesystemStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return f.get(ctx, key, offset, length)
+}
+
+// get opens the object at the offset, a negative length reads it to its end.
+func (f *FilesystemStorage) get(ctx context.Context, key string, offset, length int64) (body io.ReadCloser, err error) {
+	done := startOperation(f.Stats, OpGet)
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	objectPath, _, err := f.paths(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+	if fileInfo, err := file.Stat(); err != nil || fileInfo.IsDir() {
+		file.Close()
+		return nil, ErrObjectNotFound
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	var reader io.ReadCloser = file
+	if length >= 0 {
+		reader = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(file, length), file}
+	}
+	return &downloadCounter{ReadCloser: reader, stats: orNoStats(f.Stats)}, nil
+}
+
+func (f *FilesystemStorage) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	done := startOperation(f.Stats, OpStat)
+	defer func() { done(err) }()
+
+	objectPath, metaPath, err := f.paths(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return f.stat(key, objectPath, metaPath)
+}
+
+func (f *FilesystemStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if err := validateKey(prefix, true); err != nil {
+		return nil, err
+	}
+
+	// only the directory holding the prefix has to be walked
+	start := f.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(f.Root, filepath.FromSlash(prefix[:i]))
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(f.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if key == filesystemMetaDir || key == filesystemTmpDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		_, metaPath, err := f.paths(key)
+		if err != nil {
+			return err
+		}
+		info, err := f.stat(key, p, metaPath)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// keep the order of S3, which lists keys in ascending order
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (f *FilesystemStorage) Delete(ctx context.Context, prefix string) (n int, err error) {
+	done := startOperation(f.Stats, OpDelete)
+	var bytes int64
+	defer func() {
+		done(err)
+		// the objects removed before a failure are counted as well
+		orNoStats(f.Stats).BytesTransferred(Deleted, outcome(err), bytes)
+	}()
+
+	objects, err := f.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		objectPath, metaPath, err := f.paths(object.Key)
+		if err != nil {
+			return n, err
+		}
+		if err := os.Remove(objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("failed to delete object '%s': %w", object.Key, err)
+		}
+		if err := os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("failed to delete the metadata of object '%s': %w", object.Key, err)
+		}
+		f.removeEmptyDirs(filepath.Dir(objectPath))
+		f.removeEmptyDirs(filepath.Dir(metaPath))
+		n++
+		bytes += object.Size
+	}
+	return n, nil
+}
+
+// Presign is not supported, there is no server in front of the files.
+func (f *FilesystemStorage) Presign(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// HealthCheck reports whether the root directory exists.
+func (f *FilesystemStorage) HealthCheck(ctx context.Context) error {
+	info, err := os.Stat(f.Root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("the storage root '%s' is not a directory", f.Root)
+	}
+	return nil
+}
+
+// filesystemUpload is kept with the parts of a multipart upload until it is completed.
+type filesystemUpload struct {
+	Key string `json:"key"`
+	filesystemMeta
+}
+
+func (f *FilesystemStorage) CreateMultipartUpload(ctx context.Context, key, contentType string, tags ObjectTags) (string, error) {
+	if _, _, err := f.paths(key); err != nil {
+		return "", err
+	}
+	uploadID := uuid.NewString()
+	dir := f.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create the upload directory: %w", err)
+	}
+	upload, err := json.Marshal(filesystemUpload{Key: key, filesystemMeta: filesystemMeta{ContentType: contentType, Tags: tags}})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), upload, 0o600); err != nil {
+		return "", fmt.Errorf("failed to create the upload: %w", err)
+	}
+	return uploadID, nil
+}
+
+func (f *FilesystemStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker) error {
+	if _, err := f.upload(key, uploadID); err != nil {
+		return err
+	}
+	return f.writeFile(filepath.Join(f.uploadDir(uploadID), fmt.Sprintf("part-%05d", partNumber)), &contextReader{ReadCloser: io.NopCloser(body), ctx: ctx})
+}
+
+func (f *FilesystemStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string) error {
+	upload, err := f.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	// the part names sort in the order of their numbers
+	parts, err := filepath.Glob(filepath.Join(f.uploadDir(uploadID), "part-*"))
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: no parts were uploaded", ErrInvalidParts)
+	}
+	sort.Strings(parts)
+
+	var readers []io.Reader
+	for _, part := range parts {
+		file, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+	if err := f.Put(ctx, key, io.MultiReader(readers...), upload.ContentType, upload.Tags); err != nil {
+		return err
+	}
+	return os.RemoveAll(f.uploadDir(uploadID))
+}
+
+func (f *FilesystemStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if _, err := f.upload(key, uploadID); err != nil {
+		return err
+	}
+	return os.RemoveAll(f.uploadDir(uploadID))
+}
+
+// upload returns the multipart upload of the key.
+func (f *FilesystemStorage) upload(key, uploadID string) (filesystemUpload, error) {
+	// the id is a path segment, it must not be able to leave the upload directory
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return filesystemUpload{}, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(f.uploadDir(uploadID), "upload.json"))
+	if err != nil {
+		return filesystemUpload{}, ErrUploadNotFound
+	}
+	var upload filesystemUpload
+	if err := json.Unmarshal(data, &upload); err != nil || upload.Key != key {
+		return filesystemUpload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (f *FilesystemStorage) uploadDir(uploadID string) string {
+	return filepath.Join(f.Root, filesystemTmpDir, "multipart", uploadID)
+}
+
+// paths returns the path of the object and of its metadata.
+func (f *FilesystemStorage) paths(key string) (string, string, error) {
+	if err := validateKey(key, false); err != nil {
+		return "", "", err
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(key)),
+		filepath.Join(f.Root, filesystemMetaDir, filepath.FromSlash(key)+".json"),
+		nil
+}
+
+func (f *FilesystemStorage) stat(key, objectPath, metaPath string) (ObjectInfo, error) {
+	fileInfo, err := os.Stat(objectPath)
+	if err != nil {
+		return ObjectInfo{}, notFoundError(err)
+	}
+	if fileInfo.IsDir() {
+		// a directory is only a prefix of the keys of other objects
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	info := ObjectInfo{Key: key, Size: fileInfo.Size(), LastModified: fileInfo.ModTime()}
+
+	// the content type is lost when the metadata was removed by hand, the object is
+	// still served
+	var meta filesystemMeta
+	if data, err := os.ReadFile(metaPath); err == nil && json.Unmarshal(data, &meta) == nil {
+		info.ContentType = meta.ContentType
+	}
+	return info, nil
+}
+
+// writeFile writes the file in the tmp directory and moves it in place once it is
+// complete.
+func (f *FilesystemStorage) writeFile(dst string, body io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(f.Root, filesystemTmpDir), "object-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// removeEmptyDirs removes the directory and its parents up to the root, for as long as
+// they are empty.
+func (f *FilesystemStorage) removeEmptyDirs(dir string) {
+	for dir != f.Root && dir != filepath.Join(f.Root, filesystemMetaDir) && strings.HasPrefix(dir, f.Root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// validateKey rejects the keys that would escape the root directory or clash with the
+// metadata, a prefix may be empty or end with a slash.
+func validateKey(key string, prefix bool) error {
+	if key == "" && prefix {
+		return nil
+	}
+	cleaned := key
+	if prefix {
+		cleaned = strings.TrimSuffix(key, "/")
+	}
+	if cleaned == "" || path.IsAbs(cleaned) || path.Clean(cleaned) != cleaned || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("invalid object key '%s'", key)
+	}
+	if first := strings.SplitN(cleaned, "/", 2)[0]; first == filesystemMetaDir || first == filesystemTmpDir {
+		return fmt.Errorf("invalid object key '%s'", key)
+	}
+	return nil
+}
+
+func notFoundError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
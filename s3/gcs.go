@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+// gcsEndpoint is the S3 compatible XML api of Google Cloud Storage.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsSigningRegion is the region gcs expects in the signatures of the HMAC keys.
+const gcsSigningRegion = "auto"
+
+// S3DeleteObjectAPI defines the interface for removing a single object, for the
+// providers without multi-object deletes.
+type S3DeleteObjectAPI interface {
+	DeleteObject(ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// NewGCSClient returns a client for the XML api of Google Cloud Storage, which is
+// compatible with S3. It authenticates with the HMAC keys of a service account, set
+// as the access and secret keys, it does not use the application default credentials
+// so service account keys and workload identity are not supported. The endpoint
+// defaults to the public gcs endpoint.
+func NewGCSClient(cfg econfig.ExportConfig, log *zap.SugaredLogger) *s3.Client {
+	scfg := cfg.StorageConfig
+	endpoint := scfg.Endpoint
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{
+			URL:               endpoint,
+			SigningRegion:     gcsSigningRegion,
+			HostnameImmutable: true,
+		}, nil
+	})
+
+	gcscfg := aws.Config{
+		Region: gcsSigningRegion,
+		Credentials: aws.CredentialsProviderFunc(func(c context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     scfg.AccessKey,
+				SecretAccessKey: scfg.SecretKey,
+			}, nil
+		}),
+		EndpointResolverWithOptions: resolver,
+		HTTPClient:                  newHTTPClient(cfg),
+		Retryer:                     newRetryer(cfg),
+		APIOptions:                  apiOptions,
+	}
+
+	log.Infow("gcs client configured", "endpoint", endpoint)
+	return s3.NewFromConfig(gcscfg)
+}
+
+// singleRemover removes the objects one at a time, the XML api of gcs has no
+// multi-object deletes.
+func singleRemover(api S3DeleteObjectAPI, bucket string) objectRemover {
+	return func(ctx context.Context, objects []types.ObjectIdentifier) error {
+		for _, object := range objects {
+			if _, err := api.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: object.Key}); err != nil {
+				return fmt.Errorf("failed to delete object '%s': %w", stringValue(object.Key), err)
+			}
+		}
+		return nil
+	}
+}
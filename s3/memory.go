@@ -0,0 +1,232 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage keeps the objects in memory. It is meant for tests that should not
+// depend on a running object store.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+// memoryUpload is a multipart upload that was not completed yet.
+type memoryUpload struct {
+	key         string
+	contentType string
+	tags        ObjectTags
+	parts       map[int][]byte
+}
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	tags         ObjectTags
+	lastModified time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]memoryObject{}, uploads: map[string]*memoryUpload{}}
+}
+
+func (m *MemoryStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, tags ObjectTags) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: data, contentType: contentType, tags: tags, lastModified: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (m *MemoryStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	if offset < 0 || offset > int64(len(obj.data)) {
+		return nil, fmt.Errorf("range %d-%d is outside of the object", offset, offset+length-1)
+	}
+	end := offset + length
+	if end > int64(len(obj.data)) {
+		end = int64(len(obj.data))
+	}
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), nil
+}
+
+func (m *MemoryStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	return obj.info(key), nil
+}
+
+func (m *MemoryStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var objects []ObjectInfo
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	// keep the order of S3, which lists keys in ascending order
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.objects, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStorage) Presign(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	if _, err := m.Stat(ctx, key); err != nil {
+		return "", err
+	}
+	query := url.Values{"expires": {strconv.FormatInt(time.Now().Add(expires).Unix(), 10)}}
+	if filename != "" {
+		query.Set("filename", filename)
+	}
+	return fmt.Sprintf("memory://%s?%s", key, query.Encode()), nil
+}
+
+func (m *MemoryStorage) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// Tags returns the tags the object was stored with.
+func (m *MemoryStorage) Tags(key string) (ObjectTags, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectTags{}, ErrObjectNotFound
+	}
+	return obj.tags, nil
+}
+
+func (m *MemoryStorage) CreateMultipartUpload(ctx context.Context, key, contentType string, tags ObjectTags) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uploadID := uuid.NewString()
+	m.uploads[uploadID] = &memoryUpload{key: key, contentType: contentType, tags: tags, parts: map[int][]byte{}}
+	return uploadID, nil
+}
+
+func (m *MemoryStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return ErrUploadNotFound
+	}
+	upload.parts[partNumber] = data
+	return nil
+}
+
+func (m *MemoryStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return ErrUploadNotFound
+	}
+	if len(upload.parts) == 0 {
+		return fmt.Errorf("%w: no parts were uploaded", ErrInvalidParts)
+	}
+
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	var data []byte
+	for _, number := range numbers {
+		data = append(data, upload.parts[number]...)
+	}
+
+	m.objects[key] = memoryObject{data: data, contentType: upload.contentType, tags: upload.tags, lastModified: time.Now()}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (m *MemoryStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if upload, ok := m.uploads[uploadID]; !ok || upload.key != key {
+		return ErrUploadNotFound
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		LastModified: o.lastModified,
+	}
+}
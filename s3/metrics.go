@@ -19,10 +19,46 @@ var uploadSizes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Help: "Size of payloads posted",
 }, []string{"account", "org_id", "app"})
 
+var storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_storage_errors",
+	Help: "The total number of failed storage operations, by whether they timed out.",
+}, []string{"operation", "reason"})
+
 func init() {
 	prometheus.MustRegister(totalUploads)
 	prometheus.MustRegister(failUploads)
 	prometheus.MustRegister(uploadSizes)
+	prometheus.MustRegister(storageErrors)
 	// Set an initial value of 0 for the histogram so that it shows up in the metrics
 	uploadSizes.With(prometheus.Labels{"account": "testAccount", "org_id": "testOrg", "app": "testApp"})
 }
+
+var storageBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "export_service_storage_bytes_total",
+	Help: "The bytes uploaded to, downloaded from and deleted from the bucket, by the outcome of their operation.",
+}, []string{"direction", "outcome"})
+
+var storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "export_service_storage_operation_duration_seconds",
+	Help:    "The duration of the storage operations, by operation and outcome. Downloads are timed until the object is opened.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+}, []string{"operation", "outcome"})
+
+var storageInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "export_service_storage_operations_in_flight",
+	Help: "The storage operations in progress, by operation.",
+}, []string{"operation"})
+
+var archiveBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "export_service_archive_bytes",
+	Help: "The size of the archives of the packaged exports, by archive format.",
+	// from a kilobyte to 16 gigabytes
+	Buckets: prometheus.ExponentialBuckets(1024, 4, 13),
+}, []string{"format"})
+
+func init() {
+	prometheus.MustRegister(storageBytes)
+	prometheus.MustRegister(storageDuration)
+	prometheus.MustRegister(storageInFlight)
+	prometheus.MustRegister(archiveBytes)
+}
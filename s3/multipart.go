@@ -0,0 +1,307 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+
+	"github.com/redhatinsights/export-service-go/models"
+)
+
+// The part numbers of a multipart upload, like those of S3.
+const (
+	MinPartNumber = 1
+	MaxPartNumber = 10000
+)
+
+// MinPartBytes is the size every part but the last must have, S3 refuses to assemble
+// smaller parts.
+const MinPartBytes = 5 * 1024 * 1024 // 5 MiB
+
+var (
+	// ErrMultipartNotSupported is returned by the storages without multipart uploads.
+	ErrMultipartNotSupported = errors.New("the storage does not support multipart uploads")
+	// ErrUploadNotFound is returned when the multipart upload does not exist, e.g.
+	// because it was completed or aborted.
+	ErrUploadNotFound = errors.New("multipart upload not found")
+	// ErrInvalidParts is returned when the uploaded parts can not be assembled.
+	ErrInvalidParts = errors.New("the parts of the upload can not be assembled")
+	// ErrUploadTooLarge is returned when the assembled upload is larger than allowed
+	// for its source, the upload is deleted.
+	ErrUploadTooLarge = errors.New("the upload is larger than allowed")
+)
+
+// MultipartStorage is implemented by the storages that accept an object in parts, so
+// that a large payload can be uploaded, and retried, one part at a time.
+type MultipartStorage interface {
+	// CreateMultipartUpload starts an upload to the key and returns its id.
+	CreateMultipartUpload(ctx context.Context, key, contentType string, tags ObjectTags) (string, error)
+	// UploadPart stores a part of the upload, uploading a part again replaces it.
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker) error
+	// CompleteMultipartUpload assembles the uploaded parts in the order of their
+	// numbers into the object.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string) error
+	// AbortMultipartUpload discards the upload and its parts.
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+func (s *S3Storage) CreateMultipartUpload(ctx context.Context, key, contentType string, tags ObjectTags) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      &s.Bucket,
+		Key:         &key,
+		ContentType: &contentType,
+	}
+	if s.Cfg.StorageConfig.Provider == ProviderGCS {
+		// gcs has no object tags, the tags are kept as metadata instead
+		input.Metadata = tags.Metadata()
+	} else if encodedTags := tags.Encode(); encodedTags != "" {
+		input.Tagging = &encodedTags
+	}
+	sse, kmsKeyID, err := serverSideEncryption(s.Cfg)
+	if err != nil {
+		return "", err
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = sse, kmsKeyID
+
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	out, err := s.Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", operationError("create_multipart_upload", encryptionError(s.Cfg, err))
+	}
+	return stringValue(out.UploadId), nil
+}
+
+// UploadPart uploads the part with the retries of the client, the body is read again
+// for every attempt.
+func (s *S3Storage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker) (err error) {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	done := s.observe(OpPut)
+	defer func() { done(err) }()
+
+	_, err = s.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &s.Bucket,
+		Key:           &key,
+		UploadId:      &uploadID,
+		PartNumber:    int32(partNumber),
+		Body:          body,
+		ContentLength: size,
+	})
+	if err != nil {
+		return multipartError("upload_part", err)
+	}
+	s.stats().BytesTransferred(Uploaded, OutcomeSuccess, size)
+	return nil
+}
+
+// CompleteMultipartUpload assembles the parts listed by the storage, so that the
+// etags of the parts need not be kept by the service.
+func (s *S3Storage) CompleteMultipartUpload(ctx context.Context, key, uploadID string) error {
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	var parts []types.CompletedPart
+	paginator := s3.NewListPartsPaginator(s.Client, &s3.ListPartsInput{Bucket: &s.Bucket, Key: &key, UploadId: &uploadID})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return multipartError("list_parts", err)
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: no parts were uploaded", ErrInvalidParts)
+	}
+
+	_, err := s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.Bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return multipartError("complete_multipart_upload", err)
+}
+
+func (s *S3Storage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	_, err := s.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: &s.Bucket, Key: &key, UploadId: &uploadID})
+	return multipartError("abort_multipart_upload", err)
+}
+
+// multipartError maps the errors of the multipart operations to those of the
+// storage.
+func multipartError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var noSuchUpload *types.NoSuchUpload
+	if errors.As(err, &noSuchUpload) {
+		return ErrUploadNotFound
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchUpload":
+			return ErrUploadNotFound
+		case "EntityTooSmall", "InvalidPart", "InvalidPartOrder":
+			return fmt.Errorf("%w: %s", ErrInvalidParts, apiErr.ErrorMessage())
+		}
+	}
+	return operationError(op, err)
+}
+
+// multipartStorage returns the storage as a MultipartStorage.
+func (c *Compressor) multipartStorage() (MultipartStorage, error) {
+	storage, ok := c.Storage.(MultipartStorage)
+	if !ok {
+		return nil, ErrMultipartNotSupported
+	}
+	return storage, nil
+}
+
+// CreateMultipartObject starts a multipart upload of the payload of the source.
+func (c *Compressor) CreateMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload) (string, error) {
+	storage, err := c.multipartStorage()
+	if err != nil {
+		return "", err
+	}
+	_, source, err := payload.GetSource(resourceUUID)
+	if err != nil {
+		return "", err
+	}
+	tags := ObjectTags{OrgID: payload.OrganizationID, ExportID: payload.ID.String(), Type: SourceObject}
+	return storage.CreateMultipartUpload(ctx, SourceObjectKey(payload, *source), source.Format.ContentType(), tags)
+}
+
+// UploadObjectPart stores a part of the multipart upload of the source.
+func (c *Compressor) UploadObjectPart(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string, partNumber int, body io.ReadSeeker) error {
+	storage, err := c.multipartStorage()
+	if err != nil {
+		return err
+	}
+	_, source, err := payload.GetSource(resourceUUID)
+	if err != nil {
+		return err
+	}
+	return storage.UploadPart(ctx, SourceObjectKey(payload, *source), uploadID, partNumber, body)
+}
+
+// CompleteMultipartObject assembles the multipart upload of the source and records it
+// like an upload in one request. The checksum is computed from the assembled object,
+// since the parts may have been uploaded in any order, and verified against the
+// expected one like by CreateObject. An upload larger than maxBytes, when it is
+// positive, is deleted again and ErrUploadTooLarge is returned. The source is left
+// pending when the upload fails, so that its parts can be uploaded again.
+func (c *Compressor) CompleteMultipartObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID, expectedChecksum string, maxBytes int64) error {
+	storage, err := c.multipartStorage()
+	if err != nil {
+		return err
+	}
+	_, source, err := payload.GetSource(resourceUUID)
+	if err != nil {
+		return err
+	}
+	key := SourceObjectKey(payload, *source)
+
+	if err := payload.SetStatusRunning(db); err != nil {
+		return err
+	}
+
+	totalUploads.Inc()
+	if err := storage.CompleteMultipartUpload(ctx, key, uploadID); err != nil {
+		failUploads.Inc()
+		return err
+	}
+	return c.recordStoredObject(ctx, db, application, resourceUUID, payload, key, expectedChecksum, maxBytes)
+}
+
+// CompleteStoredObject records the payload the application of the source stored
+// itself at the SourceObjectKey of the source, like an upload in one request. It is
+// verified like by CompleteMultipartObject, ErrObjectNotFound is returned when the
+// payload was not stored.
+func (c *Compressor) CompleteStoredObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, expectedChecksum string, maxBytes int64) error {
+	_, source, err := payload.GetSource(resourceUUID)
+	if err != nil {
+		return err
+	}
+	key := SourceObjectKey(payload, *source)
+
+	if err := payload.SetStatusRunning(db); err != nil {
+		return err
+	}
+
+	totalUploads.Inc()
+	return c.recordStoredObject(ctx, db, application, resourceUUID, payload, key, expectedChecksum, maxBytes)
+}
+
+// recordStoredObject verifies the object stored for the source and records it.
+func (c *Compressor) recordStoredObject(ctx context.Context, db models.DBInterface, application string, resourceUUID uuid.UUID, payload *models.ExportPayload, key, expectedChecksum string, maxBytes int64) error {
+	checksum, size, err := c.checksum(ctx, key)
+	if err != nil {
+		failUploads.Inc()
+		return fmt.Errorf("failed to read back the upload: %w", err)
+	}
+	if maxBytes > 0 && size > maxBytes {
+		if _, err := c.Storage.Delete(ctx, key); err != nil {
+			c.Log.Errorw("failed to delete the upload larger than allowed", "error", err)
+		}
+		return ErrUploadTooLarge
+	}
+	if err := c.verifyChecksum(ctx, key, expectedChecksum, checksum); err != nil {
+		return err
+	}
+
+	return c.recordUpload(ctx, db, payload, resourceUUID, application, key, checksum, size)
+}
+
+// AbortMultipartObject discards the multipart upload of the source.
+func (c *Compressor) AbortMultipartObject(ctx context.Context, resourceUUID uuid.UUID, payload *models.ExportPayload, uploadID string) error {
+	storage, err := c.multipartStorage()
+	if err != nil {
+		return err
+	}
+	_, source, err := payload.GetSource(resourceUUID)
+	if err != nil {
+		return err
+	}
+	return storage.AbortMultipartUpload(ctx, SourceObjectKey(payload, *source), uploadID)
+}
+
+// checksum returns the sha256 checksum and the size of the object.
+func (c *Compressor) checksum(ctx context.Context, key string) (string, int64, error) {
+	body, err := c.Storage.Get(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, storageReader{body})
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,151 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// orphanBatch is the number of exports looked up at once by the OrphanCollector.
+const orphanBatch = 1000
+
+// archiveIDPattern matches the id of the export in the name of its archive, e.g.
+// 2022-06-08T12:00:00Z-<id>.tar.gz
+var archiveIDPattern = regexp.MustCompile(`-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\.`)
+
+// ExportKeys looks up the exports owning the objects of the bucket, it is implemented
+// by models.ExportDB.
+type ExportKeys interface {
+	// ExportS3Keys returns the key of the archive of each of the exports that exist
+	ExportS3Keys(exportUUIDs []uuid.UUID) (map[uuid.UUID]string, error)
+}
+
+// OrphanCollector deletes the objects of the bucket left behind by the crashed
+// uploads and the failed deletions: the source objects of the exports that no longer
+// exist, and the archives that are not the archive of their export. The objects are
+// only deleted once they are older than MinAge, so that the uploads in flight are not
+// mistaken for orphans. The objects whose keys are not those of the service are kept.
+type OrphanCollector struct {
+	DB      ExportKeys
+	Storage Storage
+	MinAge  time.Duration
+	Log     *zap.SugaredLogger
+	// Now returns the current time, it defaults to time.Now
+	Now func() time.Time
+}
+
+// OrphanReport describes a run of the OrphanCollector.
+type OrphanReport struct {
+	DryRun bool
+	// Listed is the number of objects of the bucket
+	Listed int
+	// Orphans are the keys of the orphaned objects, deleted unless the run is dry
+	Orphans []string
+	Bytes   int64
+	Deleted int
+}
+
+// objectOwner returns the export owning the object and whether the object is its
+// archive, ok is false for the keys that are not those of the service.
+func objectOwner(key string) (id uuid.UUID, archive bool, ok bool) {
+	parts := strings.Split(key, "/")
+	var err error
+	switch len(parts) {
+	case 2:
+		// <org>/<date>-<export>.<format>
+		match := archiveIDPattern.FindStringSubmatch(parts[1])
+		if match == nil {
+			return uuid.Nil, false, false
+		}
+		id, err = uuid.Parse(match[1])
+		archive = true
+	case 3:
+		// <org>/<export>/<source>.<format>
+		id, err = uuid.Parse(parts[1])
+	default:
+		return uuid.Nil, false, false
+	}
+	return id, archive, err == nil
+}
+
+// Run lists the bucket and deletes its orphaned objects, a dry run only reports them.
+func (o *OrphanCollector) Run(ctx context.Context, dryRun bool) (*OrphanReport, error) {
+	now := time.Now
+	if o.Now != nil {
+		now = o.Now
+	}
+	olderThan := now().Add(-o.MinAge)
+
+	objects, err := o.Storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the bucket: %w", err)
+	}
+	report := &OrphanReport{DryRun: dryRun, Listed: len(objects), Orphans: []string{}}
+
+	for start := 0; start < len(objects); start += orphanBatch {
+		end := start + orphanBatch
+		if end > len(objects) {
+			end = len(objects)
+		}
+		if err := o.collect(ctx, objects[start:end], olderThan, report); err != nil {
+			return report, err
+		}
+	}
+
+	o.Log.Infow("orphaned objects collected",
+		"dry_run", dryRun,
+		"listed", report.Listed,
+		"orphans", len(report.Orphans),
+		"bytes", report.Bytes,
+		"deleted", report.Deleted,
+	)
+	return report, nil
+}
+
+func (o *OrphanCollector) collect(ctx context.Context, objects []ObjectInfo, olderThan time.Time, report *OrphanReport) error {
+	var candidates []ObjectInfo
+	var ids []uuid.UUID
+	for _, object := range objects {
+		if object.LastModified.After(olderThan) {
+			continue
+		}
+		if id, _, ok := objectOwner(object.Key); ok {
+			candidates = append(candidates, object)
+			ids = append(ids, id)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	keys, err := o.DB.ExportS3Keys(ids)
+	if err != nil {
+		return fmt.Errorf("failed to look up the exports of the objects: %w", err)
+	}
+	for _, object := range candidates {
+		id, archive, _ := objectOwner(object.Key)
+		s3Key, exists := keys[id]
+		// an export packaged again keeps its source objects, but not its former archive
+		if exists && (!archive || s3Key == object.Key) {
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, object.Key)
+		report.Bytes += object.Size
+		if report.DryRun {
+			o.Log.Infow("orphaned object", "key", object.Key, "size", object.Size)
+			continue
+		}
+		n, err := o.Storage.Delete(ctx, object.Key)
+		report.Deleted += n
+		if err != nil {
+			return fmt.Errorf("failed to delete the orphaned object %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package s3_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/logger"
+	es3 "github.com/redhatinsights/export-service-go/s3"
+)
+
+// mockExportKeys holds the archive of each export that exists.
+type mockExportKeys map[uuid.UUID]string
+
+func (m mockExportKeys) ExportS3Keys(exportUUIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	keys := map[uuid.UUID]string{}
+	for _, id := range exportUUIDs {
+		if key, ok := m[id]; ok {
+			keys[id] = key
+		}
+	}
+	return keys, nil
+}
+
+var _ = Describe("Collect the orphaned objects", func() {
+	var storage *es3.MemoryStorage
+	var collector *es3.OrphanCollector
+
+	exported, packagedAgain, deleted := uuid.New(), uuid.New(), uuid.New()
+	archive := func(id uuid.UUID) string {
+		return "000001/2022-06-08T12:00:00Z-" + id.String() + ".tar.gz"
+	}
+	source := func(id uuid.UUID) string {
+		return "000001/" + id.String() + "/" + uuid.NewString() + ".json"
+	}
+
+	BeforeEach(func() {
+		storage = es3.NewMemoryStorage()
+		collector = &es3.OrphanCollector{
+			DB: mockExportKeys{
+				exported:      archive(exported),
+				packagedAgain: "000001/2022-06-09T12:00:00Z-" + packagedAgain.String() + ".tar.gz",
+			},
+			Storage: storage,
+			MinAge:  24 * time.Hour,
+			Log:     logger.Get(),
+			// the objects were put two days ago
+			Now: func() time.Time { return time.Now().Add(48 * time.Hour) },
+		}
+	})
+
+	put := func(keys ...string) {
+		for _, key := range keys {
+			Expect(storage.Put(context.Background(), key, strings.NewReader("data"), "application/json", es3.ObjectTags{})).To(Succeed())
+		}
+	}
+	keys := func() []string {
+		objects, err := storage.List(context.Background(), "")
+		Expect(err).To(BeNil())
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	It("deletes the objects of the exports that no longer exist and the former archives", func() {
+		kept := []string{archive(exported), source(exported), source(packagedAgain), "000001/README"}
+		orphans := []string{archive(deleted), source(deleted), archive(packagedAgain)}
+		put(append(kept, orphans...)...)
+
+		report, err := collector.Run(context.Background(), true)
+		Expect(err).To(BeNil())
+		Expect(report.Listed).To(Equal(7))
+		Expect(report.Orphans).To(ConsistOf(orphans))
+		Expect(report.Bytes).To(BeEquivalentTo(12))
+		Expect(keys()).To(HaveLen(7))
+
+		report, err = collector.Run(context.Background(), false)
+		Expect(err).To(BeNil())
+		Expect(report.Deleted).To(Equal(3))
+		Expect(keys()).To(ConsistOf(kept))
+	})
+
+	It("keeps the objects younger than the minimum age", func() {
+		put(archive(deleted), source(deleted))
+		collector.Now = time.Now
+
+		report, err := collector.Run(context.Background(), false)
+		Expect(err).To(BeNil())
+		Expect(report.Orphans).To(BeEmpty())
+		Expect(keys()).To(HaveLen(2))
+	})
+})
@@ -2,9 +2,21 @@ package s3
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"go.uber.org/zap"
 
 	econfig "github.com/redhatinsights/export-service-go/config"
@@ -12,6 +24,13 @@ import (
 
 const defaultRegion = "us-east-1"
 
+const (
+	// SSES3 encrypts objects with keys managed by S3
+	SSES3 = "SSE-S3"
+	// SSEKMS encrypts objects with the KMS key configured in KMSKeyID
+	SSEKMS = "SSE-KMS"
+)
+
 func NewS3Client(cfg econfig.ExportConfig, log *zap.SugaredLogger) *s3.Client {
 	scfg := cfg.StorageConfig
 
@@ -23,19 +42,184 @@ func NewS3Client(cfg econfig.ExportConfig, log *zap.SugaredLogger) *s3.Client {
 		}, nil
 	})
 
-	creds := aws.CredentialsProviderFunc(func(c context.Context) (aws.Credentials, error) {
+	var creds aws.CredentialsProvider = aws.CredentialsProviderFunc(func(c context.Context) (aws.Credentials, error) {
 		return aws.Credentials{
 			AccessKeyID:     scfg.AccessKey,
 			SecretAccessKey: scfg.SecretKey,
 		}, nil
 	})
+	if scfg.AmbientCredentials {
+		awscfg, err := awsconfig.LoadDefaultConfig(context.Background())
+		if err != nil {
+			log.Errorw("failed to load the ambient aws credentials", "error", err)
+		} else {
+			creds = awscfg.Credentials
+			log.Infof("s3 client uses the ambient credentials")
+		}
+	}
 
 	s3cfg := aws.Config{
 		Region:                      defaultRegion,
 		Credentials:                 creds,
 		EndpointResolverWithOptions: resolver,
+		HTTPClient:                  newHTTPClient(cfg),
+		Retryer:                     newRetryer(cfg),
+		APIOptions:                  apiOptions,
 	}
 
 	log.Infof("s3 client configured")
 	return s3.NewFromConfig(s3cfg)
 }
+
+// NewAWSClient returns a client for AWS S3. The credentials are resolved by the default
+// AWS credential chain, so the service can run with an IRSA role instead of static keys.
+func NewAWSClient(ctx context.Context, cfg econfig.ExportConfig, log *zap.SugaredLogger) (*s3.Client, error) {
+	awscfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(cfg.StorageConfig.Region),
+		awsconfig.WithHTTPClient(newHTTPClient(cfg)),
+		awsconfig.WithRetryer(newRetryer(cfg)),
+		awsconfig.WithAPIOptions(apiOptions),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws configuration: %w", err)
+	}
+
+	log.Infow("aws s3 client configured", "region", awscfg.Region)
+	return s3.NewFromConfig(awscfg), nil
+}
+
+// newRetryer returns the retryer used by the storage clients.
+func newRetryer(cfg econfig.ExportConfig) func() aws.Retryer {
+	scfg := cfg.StorageConfig
+	return func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = scfg.MaxRetries + 1
+			if scfg.RetryMaxBackoff > 0 {
+				o.MaxBackoff = scfg.RetryMaxBackoff
+			}
+		})
+	}
+}
+
+const (
+	// ObjectTypeTag is the tag used by the lifecycle configuration to tell the kinds of
+	// objects in the bucket apart
+	ObjectTypeTag = "object_type"
+	// ArchiveObject is the packaged export downloaded by the user
+	ArchiveObject = "archive"
+	// SourceObject is the raw payload uploaded by a source application
+	SourceObject = "source"
+)
+
+// newHTTPClient returns the http client used by the storage client. The timeouts keep
+// a slow storage node from holding on to a request indefinitely.
+func newHTTPClient(cfg econfig.ExportConfig) *http.Client {
+	scfg := cfg.StorageConfig
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   scfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = scfg.ConnectTimeout
+	transport.ResponseHeaderTimeout = scfg.RequestTimeout
+
+	transports.Lock()
+	transports.list = append(transports.list, transport)
+	transports.Unlock()
+	return &http.Client{Transport: transport}
+}
+
+// transports are the transports of the storage clients
+var transports struct {
+	sync.Mutex
+	list []*http.Transport
+}
+
+// CloseIdleConnections closes the connections the storage clients keep alive, once
+// the service stopped using them on shutdown.
+func CloseIdleConnections() {
+	transports.Lock()
+	defer transports.Unlock()
+	for _, transport := range transports.list {
+		transport.CloseIdleConnections()
+	}
+}
+
+// ObjectTags are attached to every uploaded object so that storage costs can be
+// attributed to the organization.
+type ObjectTags struct {
+	OrgID    string
+	ExportID string
+	// Type is one of ArchiveObject or SourceObject
+	Type string
+}
+
+// Encode returns the tags in the url query format expected by S3.
+func (t ObjectTags) Encode() string {
+	values := url.Values{}
+	if t.Type != "" {
+		values.Set(ObjectTypeTag, t.Type)
+	}
+	if t.OrgID != "" {
+		values.Set("org_id", t.OrgID)
+	}
+	if t.ExportID != "" {
+		values.Set("export_id", t.ExportID)
+	}
+	return values.Encode()
+}
+
+// Metadata returns the tags as the metadata of an object, for the providers without
+// object tags.
+func (t ObjectTags) Metadata() map[string]string {
+	metadata := map[string]string{}
+	values, _ := url.ParseQuery(t.Encode())
+	for key := range values {
+		metadata[key] = values.Get(key)
+	}
+	return metadata
+}
+
+// applyServerSideEncryption sets the configured server-side encryption on the input.
+func applyServerSideEncryption(cfg econfig.ExportConfig, input *s3.PutObjectInput) error {
+	sse, kmsKeyID, err := serverSideEncryption(cfg)
+	if err != nil {
+		return err
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = sse, kmsKeyID
+	return nil
+}
+
+// serverSideEncryption returns the configured server-side encryption and its kms key.
+func serverSideEncryption(cfg econfig.ExportConfig) (types.ServerSideEncryption, *string, error) {
+	scfg := cfg.StorageConfig
+	switch scfg.SSEType {
+	case "":
+		return "", nil, nil
+	case SSES3:
+		return types.ServerSideEncryptionAes256, nil, nil
+	case SSEKMS:
+		if scfg.KMSKeyID == "" {
+			return "", nil, fmt.Errorf("server-side encryption %s requires a kms key id", SSEKMS)
+		}
+		return types.ServerSideEncryptionAwsKms, aws.String(scfg.KMSKeyID), nil
+	default:
+		return "", nil, fmt.Errorf("unknown server-side encryption type: %s", scfg.SSEType)
+	}
+}
+
+// encryptionError replaces errors caused by the kms key with one that names the key,
+// so that the failure can be understood from the source error.
+func encryptionError(cfg econfig.ExportConfig, err error) error {
+	if cfg.StorageConfig.SSEType != SSEKMS {
+		return err
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if strings.HasPrefix(apiErr.ErrorCode(), "KMS.") || strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "kms") {
+		return fmt.Errorf("server-side encryption with kms key '%s' failed: %s", cfg.StorageConfig.KMSKeyID, apiErr.ErrorMessage())
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+// S3Storage keeps the objects in an S3 compatible bucket.
+type S3Storage struct {
+	Client *s3.Client
+	Bucket string
+	Cfg    econfig.ExportConfig
+	// Stats records the puts, gets, stats and deletes, they are not recorded when
+	// it is nil
+	Stats Stats
+}
+
+// Put writes the body to the bucket, encrypted with the configured server-side
+// encryption and tagged with the given tags. The uploader passes these options on to
+// every part of a multipart upload.
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, contentType string, tags ObjectTags) error {
+	uploader := manager.NewUploader(s.Client, func(u *manager.Uploader) {
+		u.PartSize = 100 * 1024 * 1024 // 100 MiB
+	})
+
+	counter := &countingReader{Reader: body}
+	input := &s3.PutObjectInput{
+		Bucket:      &s.Bucket,
+		Key:         &key,
+		Body:        counter,
+		ContentType: &contentType,
+	}
+	if s.Cfg.StorageConfig.Provider == ProviderGCS {
+		// gcs has no object tags, the tags are kept as metadata instead
+		input.Metadata = tags.Metadata()
+	} else if encodedTags := tags.Encode(); encodedTags != "" {
+		input.Tagging = &encodedTags
+	}
+	if err := applyServerSideEncryption(s.Cfg, input); err != nil {
+		return err
+	}
+
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	done := s.observe(OpPut)
+	_, err := uploader.Upload(ctx, input)
+	err = operationError("upload", encryptionError(s.Cfg, err))
+	done(err)
+	s.stats().BytesTransferred(Uploaded, outcome(err), counter.n)
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.get(ctx, &s3.GetObjectInput{Bucket: &s.Bucket, Key: &key})
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	return s.get(ctx, &s3.GetObjectInput{Bucket: &s.Bucket, Key: &key, Range: &byteRange})
+}
+
+func (s *S3Storage) get(ctx context.Context, input *s3.GetObjectInput) (io.ReadCloser, error) {
+	// the deadline also covers reading the body, so it is only released once the
+	// body is closed
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	done := s.observe(OpGet)
+	s3Object, err := GetObject(ctx, s.Client, input)
+	if err != nil {
+		cancel()
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			err = ErrObjectNotFound
+		} else {
+			err = operationError("get", err)
+		}
+		done(err)
+		return nil, err
+	}
+	done(nil)
+	body := &downloadCounter{ReadCloser: s3Object.Body, stats: s.stats()}
+	return &cancelOnClose{ReadCloser: body, cancel: cancel}, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	done := s.observe(OpStat)
+	defer func() { done(err) }()
+
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.Bucket, Key: &key})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, operationError("stat", err)
+	}
+
+	info = ObjectInfo{
+		Key:         key,
+		Size:        out.ContentLength,
+		ContentType: stringValue(out.ContentType),
+	}
+	if out.LastModified != nil {
+		info.LastModified = *out.LastModified
+	}
+	return info, nil
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &s.Bucket,
+		Prefix: &prefix,
+	}
+
+	var objects []ObjectInfo
+	for {
+		resp, err := GetObjects(ctx, s.Client, input)
+		if err != nil {
+			return nil, operationError("list", err)
+		}
+		for _, obj := range resp.Contents {
+			info := ObjectInfo{Key: stringValue(obj.Key), Size: obj.Size}
+			if obj.LastModified != nil {
+				info.LastModified = *obj.LastModified
+			}
+			objects = append(objects, info)
+		}
+		if !resp.IsTruncated {
+			return objects, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Delete(ctx context.Context, prefix string) (int, error) {
+	done := s.observe(OpDelete)
+	remove := batchRemover(s.Client, s.Bucket)
+	if s.Cfg.StorageConfig.Provider == ProviderGCS {
+		remove = singleRemover(s.Client, s.Bucket)
+	}
+	n, bytes, err := deleteObjects(ctx, s.Client, s.Bucket, prefix, remove)
+	err = operationError("delete", err)
+	done(err)
+	// the objects removed before a failure are counted as well
+	s.stats().BytesTransferred(Deleted, outcome(err), bytes)
+	return n, err
+}
+
+func (s *S3Storage) Presign(ctx context.Context, key string, expires time.Duration, filename string) (string, error) {
+	input := &s3.GetObjectInput{Bucket: &s.Bucket, Key: &key}
+	if filename != "" {
+		disposition := attachment(filename)
+		input.ResponseContentDisposition = &disposition
+	}
+	presigner := s3.NewPresignClient(s.Client)
+	req, err := presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", operationError("presign", err)
+	}
+	return req.URL, nil
+}
+
+func (s *S3Storage) HealthCheck(ctx context.Context) error {
+	ctx, cancel := withOperationTimeout(ctx, s.Cfg)
+	defer cancel()
+
+	_, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &s.Bucket})
+	return operationError("health_check", err)
+}
+
+// observe records the start of an operation, the returned func its end.
+func (s *S3Storage) observe(op string) func(err error) {
+	return startOperation(s.Stats, op)
+}
+
+func (s *S3Storage) stats() Stats {
+	return orNoStats(s.Stats)
+}
+
+// startOperation records the start of an operation, the returned func its end.
+func startOperation(stats Stats, op string) func(err error) {
+	stats = orNoStats(stats)
+	stats.OperationStarted(op)
+	start := time.Now()
+	return func(err error) {
+		stats.OperationFinished(op, outcome(err), time.Since(start))
+	}
+}
+
+// orNoStats returns the stats, or ones that drop everything when they are nil.
+func orNoStats(stats Stats) Stats {
+	if stats == nil {
+		return noStats{}
+	}
+	return stats
+}
+
+// withOperationTimeout derives a context that expires after the configured operation
+// timeout, so that a storage operation can not outlive its deadline.
+func withOperationTimeout(ctx context.Context, cfg econfig.ExportConfig) (context.Context, context.CancelFunc) {
+	if cfg.StorageConfig.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.StorageConfig.OperationTimeout)
+}
+
+// cancelOnClose releases the context of a download once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
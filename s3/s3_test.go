@@ -0,0 +1,646 @@
+package s3_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+	"github.com/redhatinsights/export-service-go/s3"
+)
+
+var _ = Describe("Upload an object", func() {
+	var requests []*http.Request
+	var server *httptest.Server
+	var failWith string
+
+	BeforeEach(func() {
+		requests = nil
+		failWith = ""
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			if failWith != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(failWith))
+				return
+			}
+			w.Header().Set("ETag", `"etag"`)
+			w.WriteHeader(http.StatusOK)
+			if r.URL.Query().Has("uploads") {
+				_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>`))
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	storage := func(sseType, kmsKeyID string) *s3.S3Storage {
+		cfg := *config.Get()
+		cfg.StorageConfig.Endpoint = server.URL
+		cfg.StorageConfig.SSEType = sseType
+		cfg.StorageConfig.KMSKeyID = kmsKeyID
+		return &s3.S3Storage{Client: s3.NewS3Client(cfg, zap.NewNop().Sugar()), Bucket: "exports-bucket", Cfg: cfg}
+	}
+
+	upload := func(s *s3.S3Storage) error {
+		tags := s3.ObjectTags{OrgID: "10000001", ExportID: "1234"}
+		return s.Put(context.Background(), "org/export/source.json", strings.NewReader("[]"), "application/json", tags)
+	}
+
+	DescribeTable("sends the server-side encryption and tagging headers",
+		func(sseType, kmsKeyID, expectedSSE string) {
+			Expect(upload(storage(sseType, kmsKeyID))).To(Succeed())
+			Expect(requests).To(HaveLen(1))
+
+			header := requests[0].Header
+			Expect(header.Get("X-Amz-Server-Side-Encryption")).To(Equal(expectedSSE))
+			Expect(header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")).To(Equal(kmsKeyID))
+			Expect(header.Get("X-Amz-Tagging")).To(Equal("export_id=1234&org_id=10000001"))
+		},
+		Entry("without encryption", "", "", ""),
+		Entry("with SSE-S3", s3.SSES3, "", "AES256"),
+		Entry("with SSE-KMS", s3.SSEKMS, "arn:aws:kms:us-east-1:123456789012:key/example", "aws:kms"),
+	)
+
+	It("encrypts the multipart uploads with the kms key", func() {
+		kmsKeyID := "arn:aws:kms:us-east-1:123456789012:key/example"
+		uploadID, err := storage(s3.SSEKMS, kmsKeyID).CreateMultipartUpload(context.Background(), "org/export/source.json", "application/json", s3.ObjectTags{})
+		Expect(err).To(BeNil())
+		Expect(uploadID).To(Equal("upload-id"))
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Header.Get("X-Amz-Server-Side-Encryption")).To(Equal("aws:kms"))
+		Expect(requests[0].Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")).To(Equal(kmsKeyID))
+	})
+
+	It("rejects SSE-KMS without a key", func() {
+		Expect(upload(storage(s3.SSEKMS, ""))).ShouldNot(Succeed())
+		Expect(requests).To(BeEmpty())
+	})
+
+	DescribeTable("refuses to start with invalid server-side encryption",
+		func(sseType, kmsKeyID, expectedErr string) {
+			cfg := *config.Get()
+			cfg.StorageConfig.Endpoint = server.URL
+			cfg.StorageConfig.SSEType = sseType
+			cfg.StorageConfig.KMSKeyID = kmsKeyID
+			_, err := s3.NewS3Storage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+			Expect(err).Should(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("with SSE-KMS without a key", s3.SSEKMS, "", "server-side encryption SSE-KMS requires a kms key id"),
+		Entry("with an unknown type", "SSE-C", "", "unknown server-side encryption type: SSE-C"),
+	)
+
+	It("names the kms key when it can not be used", func() {
+		failWith = `<?xml version="1.0" encoding="UTF-8"?><Error><Code>KMS.NotFoundException</Code><Message>Invalid keyId</Message></Error>`
+
+		err := upload(storage(s3.SSEKMS, "arn:aws:kms:us-east-1:123456789012:key/missing"))
+		Expect(err).Should(MatchError(ContainSubstring("server-side encryption with kms key 'arn:aws:kms:us-east-1:123456789012:key/missing' failed: Invalid keyId")))
+	})
+})
+
+var _ = Describe("Storage timeouts", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("ETag", `"etag"`)
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	DescribeTable("produce a timeout error",
+		func(requestTimeout, operationTimeout time.Duration) {
+			cfg := *config.Get()
+			cfg.StorageConfig.Endpoint = server.URL
+			cfg.StorageConfig.RequestTimeout = requestTimeout
+			cfg.StorageConfig.OperationTimeout = operationTimeout
+			cfg.StorageConfig.MaxRetries = 0
+			s := &s3.S3Storage{Client: s3.NewS3Client(cfg, zap.NewNop().Sugar()), Bucket: "exports-bucket", Cfg: cfg}
+
+			err := s.Put(context.Background(), "org/export/source.json", strings.NewReader("[]"), "application/json", s3.ObjectTags{})
+			Expect(err).ShouldNot(BeNil())
+			Expect(s3.IsTimeout(err)).To(BeTrue())
+		},
+		Entry("when the endpoint does not respond in time", 50*time.Millisecond, time.Minute),
+		Entry("when the operation exceeds its deadline", time.Minute, 50*time.Millisecond),
+	)
+
+	It("does not treat other failures as timeouts", func() {
+		Expect(s3.IsTimeout(errors.New("access denied"))).To(BeFalse())
+	})
+})
+
+// statsRecorder keeps the stats of the storage, so that they can be asserted without
+// prometheus.
+type statsRecorder struct {
+	inFlight int
+	outcomes map[string][]string
+	// bytes are kept by direction, then by outcome
+	bytes map[string]map[string]int64
+}
+
+func (r *statsRecorder) OperationStarted(op string) {
+	r.inFlight++
+}
+
+func (r *statsRecorder) OperationFinished(op, outcome string, duration time.Duration) {
+	r.inFlight--
+	r.outcomes[op] = append(r.outcomes[op], outcome)
+}
+
+func (r *statsRecorder) BytesTransferred(direction, outcome string, n int64) {
+	if r.bytes[direction] == nil {
+		r.bytes[direction] = map[string]int64{}
+	}
+	r.bytes[direction][outcome] += n
+}
+
+var _ = Describe("Storage stats", func() {
+	var server *httptest.Server
+	var recorder *statsRecorder
+	var storage *s3.S3Storage
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/broken.json"):
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+			case r.Method == http.MethodPut:
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.Header().Set("ETag", `"etag"`)
+			case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>exports-bucket</Name><IsTruncated>false</IsTruncated>` +
+					`<Contents><Key>org/export/a.json</Key><Size>10</Size></Contents><Contents><Key>org/export/b.json</Key><Size>32</Size></Contents></ListBucketResult>`))
+			case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><DeleteResult></DeleteResult>`))
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/missing.json"):
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			case r.Method == http.MethodGet:
+				_, _ = w.Write([]byte("payload"))
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+
+		cfg := *config.Get()
+		cfg.StorageConfig.Endpoint = server.URL
+		cfg.StorageConfig.MaxRetries = 0
+		recorder = &statsRecorder{outcomes: map[string][]string{}, bytes: map[string]map[string]int64{}}
+		storage = &s3.S3Storage{Client: s3.NewS3Client(cfg, zap.NewNop().Sugar()), Bucket: "exports-bucket", Cfg: cfg, Stats: recorder}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("records the bytes and the outcomes of the operations", func() {
+		ctx := context.Background()
+
+		Expect(storage.Put(ctx, "org/export/source.json", strings.NewReader("[1, 2, 3]"), "application/json", s3.ObjectTags{})).To(Succeed())
+		Expect(recorder.bytes[s3.Uploaded]).To(Equal(map[string]int64{s3.OutcomeSuccess: int64(len("[1, 2, 3]"))}))
+
+		body, err := storage.Get(ctx, "org/export/source.json")
+		Expect(err).To(BeNil())
+		_, err = io.ReadAll(body)
+		Expect(err).To(BeNil())
+		Expect(body.Close()).To(Succeed())
+		Expect(recorder.bytes[s3.Downloaded]).To(Equal(map[string]int64{s3.OutcomeSuccess: int64(len("payload"))}))
+
+		_, err = storage.Get(ctx, "org/export/missing.json")
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+
+		n, err := storage.Delete(ctx, "org/export/")
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		Expect(recorder.bytes[s3.Deleted]).To(Equal(map[string]int64{s3.OutcomeSuccess: 42}))
+
+		Expect(recorder.outcomes).To(Equal(map[string][]string{
+			s3.OpPut:    {s3.OutcomeSuccess},
+			s3.OpGet:    {s3.OutcomeSuccess, s3.OutcomeNotFound},
+			s3.OpDelete: {s3.OutcomeSuccess},
+		}))
+		Expect(recorder.inFlight).To(BeZero())
+	})
+
+	It("records the bytes of the operations that failed by their outcome", func() {
+		err := storage.Put(context.Background(), "org/export/broken.json", strings.NewReader("[1, 2, 3]"), "application/json", s3.ObjectTags{})
+		Expect(err).ShouldNot(BeNil())
+
+		Expect(recorder.bytes[s3.Uploaded]).To(Equal(map[string]int64{s3.OutcomeError: int64(len("[1, 2, 3]"))}))
+		Expect(recorder.outcomes).To(Equal(map[string][]string{s3.OpPut: {s3.OutcomeError}}))
+	})
+})
+
+var _ = Describe("The gcs provider", func() {
+	var server *httptest.Server
+	var mu sync.Mutex
+	var requests []*http.Request
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r)
+			mu.Unlock()
+			switch {
+			case r.Method == http.MethodPut:
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.Header().Set("ETag", `"etag"`)
+			case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>exports-bucket</Name><IsTruncated>false</IsTruncated>` +
+					`<Contents><Key>org/export/a.json</Key><Size>10</Size></Contents><Contents><Key>org/export/b.json</Key><Size>32</Size></Contents></ListBucketResult>`))
+			case r.Method == http.MethodDelete:
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+
+		cfg = *config.Get()
+		cfg.StorageConfig.Provider = s3.ProviderGCS
+		cfg.StorageConfig.Endpoint = server.URL
+		cfg.StorageConfig.Bucket = "exports-bucket"
+		cfg.StorageConfig.AccessKey = "GOOG1EXAMPLE"
+		cfg.StorageConfig.SecretKey = "secret"
+		cfg.StorageConfig.SSEType = ""
+		cfg.StorageConfig.MaxRetries = 0
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("keeps the tags as metadata and deletes the objects one at a time", func() {
+		ctx := context.Background()
+		storage, err := s3.NewS3Storage(ctx, cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(BeNil())
+
+		tags := s3.ObjectTags{OrgID: "org", ExportID: "export", Type: s3.SourceObject}
+		Expect(storage.Put(ctx, "org/export/a.json", strings.NewReader("[1, 2, 3]"), "application/json", tags)).To(Succeed())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Header.Get("X-Amz-Tagging")).To(BeEmpty())
+		Expect(requests[0].Header.Get("X-Amz-Meta-Object_type")).To(Equal(s3.SourceObject))
+		Expect(requests[0].Header.Get("X-Amz-Meta-Org_id")).To(Equal("org"))
+		// the HMAC keys are signed for the region gcs expects
+		Expect(requests[0].Header.Get("Authorization")).To(ContainSubstring("/auto/s3/aws4_request"))
+
+		n, err := storage.Delete(ctx, "org/export/")
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		var deleted []string
+		for _, r := range requests[1:] {
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, r.URL.Path)
+			}
+			Expect(r.URL.Query().Has("delete")).To(BeFalse())
+		}
+		Expect(deleted).To(ConsistOf("/exports-bucket/org/export/a.json", "/exports-bucket/org/export/b.json"))
+	})
+
+	It("rejects the s3 only settings", func() {
+		cfg.StorageConfig.SSEType = s3.SSES3
+		_, err := s3.NewS3Storage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(HaveOccurred())
+
+		cfg.StorageConfig.SSEType = ""
+		client := s3.NewGCSClient(cfg, zap.NewNop().Sugar())
+		Expect(s3.BootstrapBucket(context.Background(), client, cfg, zap.NewNop().Sugar())).To(MatchError(ContainSubstring("not supported")))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("requires the HMAC keys", func() {
+		cfg.StorageConfig.SecretKey = ""
+		_, err := s3.NewStorage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(MatchError(ContainSubstring("HMAC keys")))
+	})
+})
+
+var _ = Describe("The azure provider", func() {
+	var server *httptest.Server
+	var mu sync.Mutex
+	var requests []*http.Request
+	var blobs map[string][]byte
+	var blocks map[string][]byte
+	var cfg config.ExportConfig
+
+	BeforeEach(func() {
+		requests = nil
+		blobs = map[string][]byte{}
+		blocks = map[string][]byte{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, r)
+			name := strings.TrimPrefix(r.URL.Path, "/exports/")
+			query := r.URL.Query()
+			switch {
+			case r.Method == http.MethodPut && query.Get("comp") == "block":
+				blocks[query.Get("blockid")], _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+				var list struct {
+					Latest []string `xml:"Latest"`
+				}
+				body, _ := io.ReadAll(r.Body)
+				Expect(xml.Unmarshal(body, &list)).To(Succeed())
+				var blob []byte
+				for _, id := range list.Latest {
+					blob = append(blob, blocks[id]...)
+				}
+				blobs[name] = blob
+				w.WriteHeader(http.StatusCreated)
+			case r.Method == http.MethodPut:
+				blobs[name], _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			case r.Method == http.MethodGet && query.Get("comp") == "list":
+				if query.Get("marker") == "" {
+					_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs><Blob><Name>org/export/a.json</Name>` +
+						`<Properties><Content-Length>10</Content-Length></Properties></Blob></Blobs><NextMarker>page-2</NextMarker></EnumerationResults>`))
+					return
+				}
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs><Blob><Name>org/export/b.json</Name>` +
+					`<Properties><Content-Length>32</Content-Length></Properties></Blob></Blobs><NextMarker /></EnumerationResults>`))
+			case r.Method == http.MethodGet || r.Method == http.MethodHead:
+				blob, ok := blobs[name]
+				if !ok {
+					w.Header().Set("x-ms-error-code", "BlobNotFound")
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+				_, _ = w.Write(blob)
+			case r.Method == http.MethodDelete:
+				w.WriteHeader(http.StatusAccepted)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+
+		cfg = *config.Get()
+		cfg.StorageConfig.Provider = s3.ProviderAzure
+		cfg.StorageConfig.SSEType = ""
+		cfg.StorageConfig.MaxRetries = 0
+		cfg.StorageConfig.Azure.Account = "exportsaccount"
+		cfg.StorageConfig.Azure.AccountKey = base64.StdEncoding.EncodeToString([]byte("account-key"))
+		cfg.StorageConfig.Azure.Container = "exports"
+		cfg.StorageConfig.Azure.Endpoint = server.URL
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("stores, reads and deletes the blobs", func() {
+		ctx := context.Background()
+		storage, err := s3.NewStorage(ctx, cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(BeNil())
+		azure := storage.(*s3.AzureStorage)
+		azure.BlockSize = 1024 * 1024
+
+		tags := s3.ObjectTags{OrgID: "org", ExportID: "export", Type: s3.SourceObject}
+		Expect(storage.Put(ctx, "org/export/small.json", strings.NewReader("[1]"), "application/json", tags)).To(Succeed())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Header.Get("x-ms-blob-type")).To(Equal("BlockBlob"))
+		Expect(requests[0].Header.Get("x-ms-blob-content-type")).To(Equal("application/json"))
+		Expect(requests[0].Header.Get("x-ms-version")).ToNot(BeEmpty())
+		Expect(requests[0].Header.Get("Authorization")).To(HavePrefix("SharedKey exportsaccount:"))
+		committedTags, err := url.ParseQuery(requests[0].Header.Get("x-ms-tags"))
+		Expect(err).To(BeNil())
+		expectedTags, _ := url.ParseQuery(tags.Encode())
+		Expect(committedTags).To(Equal(expectedTags))
+
+		// the larger objects are uploaded in several blocks
+		large := strings.Repeat("a", 1024*1024) + "bc"
+		Expect(storage.Put(ctx, "org/export/large.json", strings.NewReader(large), "application/json", tags)).To(Succeed())
+		Expect(requests).To(HaveLen(4))
+		Expect(requests[3].URL.Query().Get("comp")).To(Equal("blocklist"))
+		Expect(requests[3].Header.Get("x-ms-blob-content-type")).To(Equal("application/json"))
+
+		body, err := storage.Get(ctx, "org/export/large.json")
+		Expect(err).To(BeNil())
+		data, err := io.ReadAll(body)
+		Expect(err).To(BeNil())
+		Expect(body.Close()).To(Succeed())
+		Expect(string(data)).To(Equal(large))
+
+		info, err := storage.Stat(ctx, "org/export/small.json")
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(int64(3)))
+
+		_, err = storage.Get(ctx, "org/export/missing.json")
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+		_, err = storage.Stat(ctx, "org/export/missing.json")
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+
+		n, err := storage.Delete(ctx, "org/export/")
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		var deleted []string
+		for _, r := range requests {
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, r.URL.Path)
+			}
+		}
+		Expect(deleted).To(ConsistOf("/exports/org/export/a.json", "/exports/org/export/b.json"))
+	})
+
+	It("presigns the downloads with a service sas", func() {
+		storage, err := s3.NewStorage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(BeNil())
+
+		presigned, err := storage.Presign(context.Background(), "org/export/archive.zip", time.Hour, "")
+		Expect(err).To(BeNil())
+		presignedURL, err := url.Parse(presigned)
+		Expect(err).To(BeNil())
+		Expect(server.URL + presignedURL.Path).To(Equal(server.URL + "/exports/org/export/archive.zip"))
+		for _, param := range []string{"sv=", "sr=b", "sp=r", "se=", "sig="} {
+			Expect(presigned).To(ContainSubstring(param))
+		}
+		Expect(presigned).ToNot(ContainSubstring("rscd="))
+
+		// the archive is downloaded under its filename
+		presigned, err = storage.Presign(context.Background(), "org/export/archive.zip", time.Hour, "my export.zip")
+		Expect(err).To(BeNil())
+		Expect(presigned).To(ContainSubstring("rscd=" + url.QueryEscape(`attachment; filename="my export.zip"`)))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("requires the account and its key", func() {
+		cfg.StorageConfig.Azure.AccountKey = ""
+		_, err := s3.NewStorage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(MatchError(ContainSubstring("AZURE_STORAGE_KEY")))
+	})
+})
+
+var _ = Describe("The filesystem provider", func() {
+	var storage s3.Storage
+
+	BeforeEach(func() {
+		root, err := os.MkdirTemp("", "export-service-storage-")
+		Expect(err).To(BeNil())
+		DeferCleanup(func() { os.RemoveAll(root) })
+
+		cfg := *config.Get()
+		cfg.StorageConfig.Provider = s3.ProviderFilesystem
+		cfg.StorageConfig.FilesystemRoot = root
+		storage, err = s3.NewStorage(context.Background(), cfg, zap.NewNop().Sugar(), nil)
+		Expect(err).To(BeNil())
+	})
+
+	It("stores, reads and deletes the objects", func() {
+		ctx := context.Background()
+		tags := s3.ObjectTags{OrgID: "org", ExportID: "export", Type: s3.SourceObject}
+		Expect(storage.Put(ctx, "org/export/b.json", strings.NewReader("[1, 2, 3]"), "application/json", tags)).To(Succeed())
+		Expect(storage.Put(ctx, "org/export/a.csv", strings.NewReader("a,b"), "text/csv", tags)).To(Succeed())
+		Expect(storage.Put(ctx, "org/other/c.json", strings.NewReader("[]"), "application/json", tags)).To(Succeed())
+
+		body, err := storage.Get(ctx, "org/export/b.json")
+		Expect(err).To(BeNil())
+		data, err := io.ReadAll(body)
+		Expect(err).To(BeNil())
+		Expect(body.Close()).To(Succeed())
+		Expect(string(data)).To(Equal("[1, 2, 3]"))
+
+		info, err := storage.Stat(ctx, "org/export/a.csv")
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(int64(3)))
+		Expect(info.ContentType).To(Equal("text/csv"))
+
+		objects, err := storage.List(ctx, "org/export/")
+		Expect(err).To(BeNil())
+		Expect(objects).To(HaveLen(2))
+		Expect(objects[0].Key).To(Equal("org/export/a.csv"))
+		Expect(objects[1].Key).To(Equal("org/export/b.json"))
+
+		n, err := storage.Delete(ctx, "org/export/")
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		_, err = storage.Get(ctx, "org/export/b.json")
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+		_, err = storage.Stat(ctx, "org/export")
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+
+		objects, err = storage.List(ctx, "")
+		Expect(err).To(BeNil())
+		Expect(objects).To(HaveLen(1))
+		Expect(objects[0].Key).To(Equal("org/other/c.json"))
+	})
+
+	It("reads a range of an object", func() {
+		ctx := context.Background()
+		Expect(storage.Put(ctx, "org/export/a.csv", strings.NewReader("a,b\nc,d\n"), "text/csv", s3.ObjectTags{})).To(Succeed())
+
+		body, err := storage.GetRange(ctx, "org/export/a.csv", 4, 3)
+		Expect(err).To(BeNil())
+		data, err := io.ReadAll(body)
+		Expect(err).To(BeNil())
+		Expect(body.Close()).To(Succeed())
+		Expect(string(data)).To(Equal("c,d"))
+
+		_, err = storage.GetRange(ctx, "org/export/b.csv", 0, 1)
+		Expect(err).To(MatchError(s3.ErrObjectNotFound))
+	})
+
+	It("keeps the keys below the root", func() {
+		ctx := context.Background()
+		for _, key := range []string{"../escape.json", "/etc/passwd", "org/../../escape.json", ".meta/org.json"} {
+			Expect(storage.Put(ctx, key, strings.NewReader("{}"), "application/json", s3.ObjectTags{})).To(MatchError(ContainSubstring("invalid object key")), key)
+		}
+		_, err := storage.List(ctx, "../")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not presign urls", func() {
+		_, err := storage.Presign(context.Background(), "org/export/b.json", time.Hour, "")
+		Expect(err).To(MatchError(s3.ErrPresignNotSupported))
+	})
+})
+
+var _ = Describe("Multipart uploads", func() {
+	storages := map[string]func() s3.MultipartStorage{
+		"memory": func() s3.MultipartStorage { return s3.NewMemoryStorage() },
+		"filesystem": func() s3.MultipartStorage {
+			root, err := os.MkdirTemp("", "export-service-storage-")
+			Expect(err).To(BeNil())
+			DeferCleanup(func() { os.RemoveAll(root) })
+			return &s3.FilesystemStorage{Root: root}
+		},
+	}
+
+	for name, newStorage := range storages {
+		name, newStorage := name, newStorage
+
+		It("assembles the parts in the order of their numbers with the "+name+" storage", func() {
+			ctx := context.Background()
+			storage := newStorage()
+			tags := s3.ObjectTags{OrgID: "org", ExportID: "export", Type: s3.SourceObject}
+
+			uploadID, err := storage.CreateMultipartUpload(ctx, "org/export/a.csv", "text/csv", tags)
+			Expect(err).To(BeNil())
+			Expect(storage.UploadPart(ctx, "org/export/a.csv", uploadID, 2, strings.NewReader("c,d\n"))).To(Succeed())
+			Expect(storage.UploadPart(ctx, "org/export/a.csv", uploadID, 1, strings.NewReader("x,y\n"))).To(Succeed())
+			// uploading a part again replaces it
+			Expect(storage.UploadPart(ctx, "org/export/a.csv", uploadID, 1, strings.NewReader("a,b\n"))).To(Succeed())
+			Expect(storage.CompleteMultipartUpload(ctx, "org/export/a.csv", uploadID)).To(Succeed())
+
+			body, err := storage.(s3.Storage).Get(ctx, "org/export/a.csv")
+			Expect(err).To(BeNil())
+			data, err := io.ReadAll(body)
+			Expect(err).To(BeNil())
+			Expect(body.Close()).To(Succeed())
+			Expect(string(data)).To(Equal("a,b\nc,d\n"))
+
+			info, err := storage.(s3.Storage).Stat(ctx, "org/export/a.csv")
+			Expect(err).To(BeNil())
+			Expect(info.ContentType).To(Equal("text/csv"))
+
+			// the upload is gone once completed
+			Expect(storage.CompleteMultipartUpload(ctx, "org/export/a.csv", uploadID)).To(MatchError(s3.ErrUploadNotFound))
+		})
+
+		It("discards the aborted and the unknown uploads with the "+name+" storage", func() {
+			ctx := context.Background()
+			storage := newStorage()
+
+			uploadID, err := storage.CreateMultipartUpload(ctx, "org/export/a.json", "application/json", s3.ObjectTags{})
+			Expect(err).To(BeNil())
+			Expect(storage.CompleteMultipartUpload(ctx, "org/export/a.json", uploadID)).To(MatchError(s3.ErrInvalidParts))
+			Expect(storage.UploadPart(ctx, "org/export/a.json", uploadID, 1, strings.NewReader("[]"))).To(Succeed())
+			// the upload belongs to its key
+			Expect(storage.CompleteMultipartUpload(ctx, "org/export/b.json", uploadID)).To(MatchError(s3.ErrUploadNotFound))
+
+			Expect(storage.AbortMultipartUpload(ctx, "org/export/a.json", uploadID)).To(Succeed())
+			Expect(storage.UploadPart(ctx, "org/export/a.json", uploadID, 2, strings.NewReader("[]"))).To(MatchError(s3.ErrUploadNotFound))
+			Expect(storage.AbortMultipartUpload(ctx, "org/export/a.json", "unknown")).To(MatchError(s3.ErrUploadNotFound))
+
+			_, err = storage.(s3.Storage).Stat(ctx, "org/export/a.json")
+			Expect(err).To(MatchError(s3.ErrObjectNotFound))
+		})
+	}
+})
@@ -0,0 +1,109 @@
+package s3
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// The operations of the storage whose traffic and latency are recorded.
+const (
+	OpPut    = "put"
+	OpGet    = "get"
+	OpStat   = "stat"
+	OpDelete = "delete"
+)
+
+// The directions of the bytes moved by the storage.
+const (
+	Uploaded   = "uploaded"
+	Downloaded = "downloaded"
+	Deleted    = "deleted"
+)
+
+// The outcomes of the storage operations.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeNotFound = "not_found"
+	OutcomeTimeout  = "timeout"
+	OutcomeError    = "error"
+)
+
+// Stats records the operations of the storage and the bytes they move, so that the
+// traffic to the bucket is known without access to the metrics of the bucket.
+type Stats interface {
+	// OperationStarted and OperationFinished bracket every operation.
+	OperationStarted(op string)
+	OperationFinished(op, outcome string, duration time.Duration)
+	// BytesTransferred counts the bytes uploaded, downloaded or deleted by the outcome
+	// of their operation, the bytes moved before a failure are counted as well.
+	BytesTransferred(direction, outcome string, n int64)
+}
+
+// PrometheusStats exports the stats of the storage as prometheus metrics.
+type PrometheusStats struct{}
+
+func (PrometheusStats) OperationStarted(op string) {
+	storageInFlight.WithLabelValues(op).Inc()
+}
+
+func (PrometheusStats) OperationFinished(op, outcome string, duration time.Duration) {
+	storageInFlight.WithLabelValues(op).Dec()
+	storageDuration.WithLabelValues(op, outcome).Observe(duration.Seconds())
+}
+
+func (PrometheusStats) BytesTransferred(direction, outcome string, n int64) {
+	storageBytes.WithLabelValues(direction, outcome).Add(float64(n))
+}
+
+// noStats drops the stats of a storage built without a recorder.
+type noStats struct{}
+
+func (noStats) OperationStarted(op string)                                   {}
+func (noStats) OperationFinished(op, outcome string, duration time.Duration) {}
+func (noStats) BytesTransferred(direction, outcome string, n int64)          {}
+
+// outcome classifies the error an operation returned.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrObjectNotFound):
+		return OutcomeNotFound
+	case IsTimeout(err):
+		return OutcomeTimeout
+	default:
+		return OutcomeError
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// downloadCounter counts the bytes read from the body of a downloaded object as they
+// are streamed.
+type downloadCounter struct {
+	io.ReadCloser
+	stats Stats
+}
+
+func (d *downloadCounter) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if n > 0 {
+		result := OutcomeSuccess
+		if err != nil && err != io.EOF {
+			result = outcome(err)
+		}
+		d.stats.BytesTransferred(Downloaded, result, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+
+	"go.uber.org/zap"
+
+	econfig "github.com/redhatinsights/export-service-go/config"
+)
+
+const (
+	// ProviderMinio talks to an S3 compatible endpoint with static keys
+	ProviderMinio = "minio"
+	// ProviderAWS talks to AWS S3 with the credentials found by the default AWS
+	// credential chain, e.g. environment variables or IRSA web identity tokens
+	ProviderAWS = "aws"
+	// ProviderGCS talks to Google Cloud Storage through its S3 compatible XML api,
+	// with the HMAC keys of a service account. Service account keys and workload
+	// identity are not supported, the XML api does not take OAuth tokens here
+	ProviderGCS = "gcs"
+	// ProviderAzure talks to Azure Blob Storage with the shared key of a storage
+	// account
+	ProviderAzure = "azure"
+	// ProviderFilesystem keeps the objects in a local directory, for development
+	ProviderFilesystem = "filesystem"
+)
+
+// ErrObjectNotFound is returned when the requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrPresignNotSupported is returned by the storages that can not presign urls.
+var ErrPresignNotSupported = errors.New("the storage can not presign urls")
+
+// attachment returns the Content-Disposition of a presigned download named filename.
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Storage is the object store holding the raw source payloads and the packaged
+// export archives. Keys are relative to the configured bucket.
+type Storage interface {
+	// Put stores the body under the key, tagged with the given tags.
+	Put(ctx context.Context, key string, body io.Reader, contentType string, tags ObjectTags) error
+	// Get streams the object. The caller must close the returned body.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange streams length bytes of the object from the offset, which must be
+	// within the object. The caller must close the returned body.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns the object's metadata without reading the object.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns the objects whose keys start with the prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes every object whose key starts with the prefix and returns
+	// how many objects were removed.
+	Delete(ctx context.Context, prefix string) (int, error)
+	// Presign returns a url that allows downloading the object until it expires.
+	// The object is downloaded as an attachment named filename, when it is set.
+	Presign(ctx context.Context, key string, expires time.Duration, filename string) (string, error)
+	// HealthCheck reports whether the bucket can be reached.
+	HealthCheck(ctx context.Context) error
+}
+
+// NewStorage returns the storage for the configured STORAGE_PROVIDER, recording its
+// operations to the stats.
+func NewStorage(ctx context.Context, cfg econfig.ExportConfig, log *zap.SugaredLogger, stats Stats) (Storage, error) {
+	switch cfg.StorageConfig.Provider {
+	case ProviderAzure:
+		return NewAzureStorage(cfg, log, stats)
+	case ProviderFilesystem:
+		return NewFilesystemStorage(cfg, log, stats)
+	default:
+		return NewS3Storage(ctx, cfg, log, stats)
+	}
+}
+
+// NewS3Storage returns the storage for the S3 compatible STORAGE_PROVIDERs, recording
+// its operations to the stats.
+func NewS3Storage(ctx context.Context, cfg econfig.ExportConfig, log *zap.SugaredLogger, stats Stats) (*S3Storage, error) {
+	storage := &S3Storage{Bucket: cfg.StorageConfig.Bucket, Cfg: cfg, Stats: stats}
+	// a misconfigured encryption would otherwise fail every upload
+	if _, _, err := serverSideEncryption(cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.StorageConfig.Provider {
+	case ProviderMinio, "":
+		storage.Client = NewS3Client(cfg, log)
+	case ProviderAWS:
+		client, err := NewAWSClient(ctx, cfg, log)
+		if err != nil {
+			return nil, err
+		}
+		storage.Client = client
+	case ProviderGCS:
+		if cfg.StorageConfig.SSEType != "" {
+			return nil, fmt.Errorf("the %s provider does not support STORAGE_SSE_TYPE, gcs encrypts the objects at rest", ProviderGCS)
+		}
+		// the XML api only takes HMAC keys, there is no fallback to the credentials of
+		// the environment
+		if cfg.StorageConfig.AccessKey == "" || cfg.StorageConfig.SecretKey == "" {
+			return nil, fmt.Errorf("the %s provider requires the HMAC keys of a service account in AWS_ACCESS_KEY and AWS_SECRET_ACCESS_KEY, service account keys and workload identity are not supported", ProviderGCS)
+		}
+		storage.Client = NewGCSClient(cfg, log)
+	default:
+		return nil, fmt.Errorf("unknown storage provider: %s", cfg.StorageConfig.Provider)
+	}
+
+	return storage, nil
+}
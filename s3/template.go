@@ -13,11 +13,14 @@ import (
 
 // struct used to fill the README.md and meta.json files in the tar
 type ExportMeta struct {
+	ExportName  string           `json:"export_name,omitempty"`
 	ExportBy    string           `json:"exported_by"`
 	ExportDate  string           `json:"export_date"`
 	ExportOrgID string           `json:"export_org_id"`
 	FileMeta    []ExportFileMeta `json:"file_meta"`
 	HelpString  string           `json:"help_string"`
+	// Failures are written to the failures.json of the archive rather than meta.json
+	Failures []models.SourceFailure `json:"-"`
 }
 
 // details for each file in the tar
@@ -27,6 +30,8 @@ type ExportFileMeta struct {
 	Resource    string `json:"resource"`
 	// Filters are a key-value pair of the filters used to create the export
 	Filters map[string]string `json:"filters"`
+	// RowCount is the number of records of the file reported by its application
+	RowCount *int64 `json:"row_count,omitempty"`
 }
 
 const (
@@ -46,6 +51,7 @@ func findFileMeta(id, basename string, sources []models.Source) (*ExportFileMeta
 				Application: source.Application,
 				Resource:    source.Resource,
 				Filters:     filters,
+				RowCount:    source.RecordCount,
 			}, nil
 		}
 	}
@@ -73,12 +79,16 @@ func BuildReadme(meta *ExportMeta) (string, error) {
 		if filterDetails == "" {
 			filterDetails = "None"
 		}
+		rowCount := ""
+		if file.RowCount != nil {
+			rowCount = fmt.Sprintf("- **Rows**: %d\n", *file.RowCount)
+		}
 		dataDetails += fmt.Sprintf(`
 ### %s
 - **Application**: %s
 - **Resource**: %s
 - **Filters**: %s
-`, file.Filename, file.Application, file.Resource, filterDetails)
+%s`, file.Filename, file.Application, file.Resource, filterDetails, rowCount)
 	}
 
 	if dataDetails == "" {
@@ -86,26 +96,46 @@ func BuildReadme(meta *ExportMeta) (string, error) {
 No data was found.
 `
 	}
+	failureDetails := ""
+	if len(meta.Failures) > 0 {
+		failureDetails = `
+## Failed Sources
+The following data could not be exported, it is described in failures.json:
+`
+		for _, failure := range meta.Failures {
+			retry := ""
+			if failure.Retriable {
+				retry = ", may succeed in a new export"
+			}
+			failureDetails += fmt.Sprintf("- **%s** %s: %s (%d%s)\n", failure.Application, failure.Resource, failure.Message, failure.Code, retry)
+		}
+	}
+	exportName := ""
+	if meta.ExportName != "" {
+		exportName = fmt.Sprintf("- **Export Name**: %s\n", meta.ExportName)
+	}
 	// next, make a README.md file containing the ExportMeta data in a readable format
 	readme := fmt.Sprintf(`# Export Manifest
 
 ## Exported Information
-- **Exported by**: %s
+%s- **Exported by**: %s
 - **Org ID**: %s
 - **Export Date**: %s
 
 ## Data Details
 This archive contains the following data:
-%s
+%s%s
 ## Help and Support
 This service is owned by the ConsoleDot Pipeline team. If you have any questions, or need support with this service, please contact Red Hat Support.
 
 You can also raise an issue on the [Export Service GitHub repo](https://github.com/RedHatInsights/export-service-go/).
 `,
+		exportName,
 		meta.ExportBy,
 		meta.ExportOrgID,
 		meta.ExportDate,
 		dataDetails,
+		failureDetails,
 	)
 
 	return readme, nil
@@ -9,6 +9,8 @@ import (
 )
 
 var _ = Describe("Build files included in zip", func() {
+	rowCount := int64(42000)
+
 	It("should build the meta.json file provided ExportMeta struct", func() {
 		// Make the ExportMeta struct
 		meta := s3.ExportMeta{
@@ -33,6 +35,12 @@ var _ = Describe("Build files included in zip", func() {
 		Expect(err).To(BeNil())
 		Expect(metaDump).To(Equal([]byte(`{"exported_by":"user","export_date":"date","export_org_id":"org_id","file_meta":[{"filename":"filename","application":"application","resource":"resource","filters":{"filter_key":"filter_value"}}],"help_string":"Help me!"}`)))
 
+		// the row count is only included when the application reported it
+		meta.FileMeta[0].RowCount = &rowCount
+		metaDump, err = s3.BuildMeta(&meta)
+		Expect(err).To(BeNil())
+		Expect(string(metaDump)).To(ContainSubstring(`"row_count":42000`))
+
 		// Error should never occur, not even for nil case
 		_, err = s3.BuildMeta(nil)
 		Expect(err).To(BeNil())
@@ -84,6 +92,31 @@ No data was found.
 ## Help and Support
 This service is owned by the ConsoleDot Pipeline team. If you have any questions, or need support with this service, please contact Red Hat Support.
 
+You can also raise an issue on the [Export Service GitHub repo](https://github.com/RedHatInsights/export-service-go/).
+`,
+		),
+		Entry("A named export", s3.ExportMeta{
+			ExportName:  "systems 2024-01-01",
+			ExportBy:    "user",
+			ExportDate:  "date",
+			ExportOrgID: "org_id",
+		},
+			`# Export Manifest
+
+## Exported Information
+- **Export Name**: systems 2024-01-01
+- **Exported by**: user
+- **Org ID**: org_id
+- **Export Date**: date
+
+## Data Details
+This archive contains the following data:
+
+No data was found.
+
+## Help and Support
+This service is owned by the ConsoleDot Pipeline team. If you have any questions, or need support with this service, please contact Red Hat Support.
+
 You can also raise an issue on the [Export Service GitHub repo](https://github.com/RedHatInsights/export-service-go/).
 `,
 		),
@@ -119,6 +152,42 @@ This archive contains the following data:
 ## Help and Support
 This service is owned by the ConsoleDot Pipeline team. If you have any questions, or need support with this service, please contact Red Hat Support.
 
+You can also raise an issue on the [Export Service GitHub repo](https://github.com/RedHatInsights/export-service-go/).
+`,
+		),
+		Entry("A source with a row count", s3.ExportMeta{
+			ExportBy:    "user",
+			ExportDate:  "date",
+			ExportOrgID: "org_id",
+			FileMeta: []s3.ExportFileMeta{
+				{
+					Filename:    "filename",
+					Application: "application",
+					Resource:    "resource",
+					Filters:     map[string]string{},
+					RowCount:    &rowCount,
+				},
+			},
+		},
+			`# Export Manifest
+
+## Exported Information
+- **Exported by**: user
+- **Org ID**: org_id
+- **Export Date**: date
+
+## Data Details
+This archive contains the following data:
+
+### filename
+- **Application**: application
+- **Resource**: resource
+- **Filters**: None
+- **Rows**: 42000
+
+## Help and Support
+This service is owned by the ConsoleDot Pipeline team. If you have any questions, or need support with this service, please contact Red Hat Support.
+
 You can also raise an issue on the [Export Service GitHub repo](https://github.com/RedHatInsights/export-service-go/).
 `,
 		),
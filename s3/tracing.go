@@ -0,0 +1,34 @@
+package s3
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/redhatinsights/export-service-go/tracing"
+)
+
+// apiOptions are the middlewares of the stacks of every call of the storage clients.
+var apiOptions = []func(*middleware.Stack) error{traceOperations}
+
+// traceOperations starts a client span around every call of the storage client,
+// including its retries, named after the operation of the api, e.g. S3.PutObject.
+func traceOperations(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ExportServiceTracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		operation := awsmiddleware.GetOperationName(ctx)
+		ctx, span := tracing.Tracer().Start(ctx, "S3."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.RPCSystemKey.String("aws-api"),
+				semconv.RPCServiceKey.String("S3"),
+				semconv.RPCMethodKey.String(operation),
+			),
+		)
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		tracing.End(span, err)
+		return out, metadata, err
+	}), middleware.After)
+}
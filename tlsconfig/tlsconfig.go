@@ -0,0 +1,162 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// nextProtos are the protocols offered through ALPN, HTTP/2 is preferred.
+var nextProtos = []string{"h2", "http/1.1"}
+
+// MutualTLS serves a listener with mutual TLS from the certificates mounted as files:
+// the certificate and key of the server, and the CA the certificates of the clients
+// must be signed by. The files are reloaded once they change, so that the rotated
+// certificates are served without a restart.
+type MutualTLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Log          *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	config *tls.Config
+	// modTimes are the modification times of the loaded files, by path
+	modTimes map[string]time.Time
+}
+
+// New returns the mutual TLS of the files, once they are loaded.
+func New(certFile, keyFile, clientCAFile string, log *zap.SugaredLogger) (*MutualTLS, error) {
+	m := &MutualTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile, Log: log}
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load loads the files. The previous certificates keep being served when they can not
+// be loaded.
+func (m *MutualTLS) Load() error {
+	modTimes, err := m.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.CertFile, m.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse the server certificate: %w", err)
+	}
+	pem, err := os.ReadFile(m.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return errors.New("the client CA file holds no PEM certificate")
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		// http.Server only offers h2 on the config of the listener, the config of the
+		// client replaces it during the handshake
+		NextProtos: nextProtos,
+	}
+
+	m.mu.Lock()
+	m.config, m.modTimes = config, modTimes
+	m.mu.Unlock()
+	m.Log.Infow("loaded the mutual TLS certificates", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+	return nil
+}
+
+// stat returns the modification times of the files.
+func (m *MutualTLS) stat() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, path := range []string{m.CertFile, m.KeyFile, m.ClientCAFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// changed reports whether one of the files changed since they were loaded.
+func (m *MutualTLS) changed() bool {
+	modTimes, err := m.stat()
+	if err != nil {
+		m.Log.Errorw("failed to check the mutual TLS certificates", "error", err)
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for path, modTime := range modTimes {
+		if !modTime.Equal(m.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSConfig returns the config of the listener, each handshake uses the certificates
+// loaded last.
+func (m *MutualTLS) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return m.current(), nil
+		},
+		// the config of the client serves the certificate, http.Server only requires
+		// the listener to have one
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &m.current().Certificates[0], nil
+		},
+	}
+}
+
+func (m *MutualTLS) current() *tls.Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.config
+}
+
+// Watch reloads the files every interval they changed until the context is done.
+// Kubernetes replaces the files of the mounted secrets, their modification times
+// change with them.
+func (m *MutualTLS) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Load(); err != nil {
+				m.Log.Errorw("failed to reload the mutual TLS certificates, the previous ones are served", "error", err)
+			}
+		}
+	}
+}
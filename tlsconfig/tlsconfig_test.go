@@ -0,0 +1,179 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhatinsights/export-service-go/logger"
+	"github.com/redhatinsights/export-service-go/tlsconfig"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue returns a certificate of the name signed by the parent, self-signed when the
+// parent is nil.
+func issue(name string, parent *keyPair, isCA bool) keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).To(BeNil())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+	return keyPair{cert: cert, key: key}
+}
+
+func (p keyPair) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.cert.Raw})
+}
+
+func (p keyPair) keyPEM() []byte {
+	der, err := x509.MarshalECPrivateKey(p.key)
+	Expect(err).To(BeNil())
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (p keyPair) tlsCertificate() tls.Certificate {
+	cert, err := tls.X509KeyPair(p.certPEM(), p.keyPEM())
+	Expect(err).To(BeNil())
+	return cert
+}
+
+var _ = Describe("Serving with mutual TLS", func() {
+	var dir string
+	var ca keyPair
+	var addr string
+	var mtls *tlsconfig.MutualTLS
+
+	writeServerCert := func(server keyPair, modTime time.Time) {
+		Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), server.certPEM(), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "tls.key"), server.keyPEM(), 0o600)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(dir, "tls.crt"), modTime, modTime)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(dir, "tls.key"), modTime, modTime)).To(Succeed())
+	}
+
+	// handshake returns the certificate the server presented, the client presents
+	// its certificate when it has one
+	handshake := func(client *keyPair) (*x509.Certificate, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		config := &tls.Config{RootCAs: roots}
+		if client != nil {
+			config.Certificates = []tls.Certificate{client.tlsCertificate()}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := httpClient.Get("https://" + addr + "/")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return resp.TLS.PeerCertificates[0], nil
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		ca = issue("export-service-ca", nil, true)
+		Expect(os.WriteFile(filepath.Join(dir, "ca.crt"), ca.certPEM(), 0o600)).To(Succeed())
+		writeServerCert(issue("export-service", &ca, false), time.Now().Add(-time.Minute))
+
+		var err error
+		mtls, err = tlsconfig.New(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"), logger.Get())
+		Expect(err).To(BeNil())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		addr = listener.Addr().String()
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: mtls.TLSConfig()}
+		go func() { _ = server.ServeTLS(listener, "", "") }()
+		DeferCleanup(server.Close)
+	})
+
+	It("only accepts the clients with a certificate of the client CA", func() {
+		client := issue("exampleApp", &ca, false)
+		_, err := handshake(&client)
+		Expect(err).To(BeNil())
+
+		_, err = handshake(nil)
+		Expect(err).NotTo(BeNil())
+
+		stranger := issue("exampleApp", nil, false)
+		_, err = handshake(&stranger)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("negotiates HTTP/2 with the clients that offer it", func() {
+		client := issue("exampleApp", &ca, false)
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{client.tlsCertificate()},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		Expect(conn.ConnectionState().NegotiatedProtocol).To(Equal("h2"))
+	})
+
+	It("serves the rotated certificates once they are reloaded", func() {
+		client := issue("exampleApp", &ca, false)
+		rotated := issue("export-service-rotated", &ca, false)
+		writeServerCert(rotated, time.Now())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go mtls.Watch(ctx, 10*time.Millisecond)
+
+		Eventually(func() string {
+			cert, err := handshake(&client)
+			if err != nil {
+				return err.Error()
+			}
+			return cert.Subject.CommonName
+		}).Should(Equal("export-service-rotated"))
+	})
+
+	It("keeps serving the previous certificates when the rotated ones can not be loaded", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "tls.key"), []byte("not a key"), 0o600)).To(Succeed())
+		Expect(mtls.Load()).NotTo(Succeed())
+
+		client := issue("exampleApp", &ca, false)
+		cert, err := handshake(&client)
+		Expect(err).To(BeNil())
+		Expect(cert.Subject.CommonName).To(Equal("export-service"))
+	})
+})
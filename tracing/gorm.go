@@ -0,0 +1,88 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanKey keeps the span of a statement between its callbacks
+const spanKey = "export-service:span"
+
+// GormPlugin traces the statements of gorm, each statement is a span of the request
+// or the job that ran it. The values of the statements are not recorded.
+type GormPlugin struct{}
+
+// Name returns the name of the plugin.
+func (GormPlugin) Name() string {
+	return "export-service:tracing"
+}
+
+// Initialize registers the callbacks starting and ending the spans around every kind
+// of statement.
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register("export-service:start_create_span", startSpan("create")),
+		callbacks.Create().After("gorm:create").Register("export-service:end_create_span", endSpan),
+		callbacks.Query().Before("gorm:query").Register("export-service:start_query_span", startSpan("query")),
+		callbacks.Query().After("gorm:query").Register("export-service:end_query_span", endSpan),
+		callbacks.Update().Before("gorm:update").Register("export-service:start_update_span", startSpan("update")),
+		callbacks.Update().After("gorm:update").Register("export-service:end_update_span", endSpan),
+		callbacks.Delete().Before("gorm:delete").Register("export-service:start_delete_span", startSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("export-service:end_delete_span", endSpan),
+		callbacks.Row().Before("gorm:row").Register("export-service:start_row_span", startSpan("row")),
+		callbacks.Row().After("gorm:row").Register("export-service:end_row_span", endSpan),
+		callbacks.Raw().Before("gorm:raw").Register("export-service:start_raw_span", startSpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("export-service:end_raw_span", endSpan),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		_, span := Tracer().Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationKey.String(operation)),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		semconv.DBSQLTableKey.String(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// a missing record is an answer, not a failure of the database
+		err = nil
+	}
+	End(span, err)
+}
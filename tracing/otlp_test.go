@@ -0,0 +1,102 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/redhatinsights/export-service-go/tracing"
+)
+
+var _ = Describe("The OTLP exporter", func() {
+	var server *httptest.Server
+	var requests []*http.Request
+	var bodies []*coltracepb.ExportTraceServiceRequest
+	var status int
+
+	BeforeEach(func() {
+		requests, bodies, status = nil, nil, http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, _ := io.ReadAll(r.Body)
+			body := &coltracepb.ExportTraceServiceRequest{}
+			Expect(proto.Unmarshal(raw, body)).To(Succeed())
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	record := func(exporter sdktrace.SpanExporter) error {
+		provider := sdktrace.NewTracerProvider()
+		ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+		_, child := provider.Tracer("test").Start(ctx, "child")
+		child.SetAttributes(attribute.Int64("db.rows_affected", 3), attribute.String("db.sql.table", "exports"))
+		tracing.End(child, errors.New("connection refused"))
+		parent.End()
+
+		return exporter.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{child.(sdktrace.ReadOnlySpan), parent.(sdktrace.ReadOnlySpan)})
+	}
+
+	It("posts the spans as OTLP protobuf to the traces path of the collector", func() {
+		exporter, err := tracing.NewOTLPExporter(context.Background(), server.URL+"/", map[string]string{"Authorization": "Bearer token"}, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(record(exporter)).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/v1/traces"))
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+
+		resourceSpans := bodies[0].GetResourceSpans()
+		Expect(resourceSpans).To(HaveLen(1))
+		scopeSpans := resourceSpans[0].GetScopeSpans()
+		Expect(scopeSpans).To(HaveLen(1))
+		Expect(scopeSpans[0].GetScope().GetName()).To(Equal("test"))
+
+		spans := scopeSpans[0].GetSpans()
+		Expect(spans).To(HaveLen(2))
+		child, parent := spans[0], spans[1]
+		Expect(child.GetName()).To(Equal("child"))
+		Expect(child.GetTraceId()).To(HaveLen(16))
+		Expect(child.GetTraceId()).To(Equal(parent.GetTraceId()))
+		Expect(child.GetParentSpanId()).To(Equal(parent.GetSpanId()))
+		Expect(parent.GetParentSpanId()).To(BeEmpty())
+		Expect(child.GetStatus().GetCode()).To(Equal(tracepb.Status_STATUS_CODE_ERROR))
+		Expect(child.GetStatus().GetMessage()).To(Equal("connection refused"))
+		Expect(parent.GetStatus().GetCode()).To(Equal(tracepb.Status_STATUS_CODE_UNSET))
+		Expect(child.GetEvents()).To(HaveLen(1))
+		Expect(child.GetAttributes()).To(HaveLen(2))
+		Expect(child.GetAttributes()[0].GetKey()).To(Equal("db.rows_affected"))
+		Expect(child.GetAttributes()[0].GetValue().GetIntValue()).To(Equal(int64(3)))
+		Expect(child.GetAttributes()[1].GetKey()).To(Equal("db.sql.table"))
+		Expect(child.GetAttributes()[1].GetValue().GetStringValue()).To(Equal("exports"))
+	})
+
+	It("fails when the collector rejects the spans", func() {
+		status = http.StatusBadRequest
+		exporter, err := tracing.NewOTLPExporter(context.Background(), server.URL, nil, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(record(exporter)).To(MatchError(ContainSubstring("400 Bad Request")))
+	})
+
+	It("rejects an endpoint that is not an http url", func() {
+		_, err := tracing.NewOTLPExporter(context.Background(), "collector:4318", nil, time.Second)
+		Expect(err).To(MatchError(ContainSubstring("OTEL_EXPORTER_OTLP_ENDPOINT")))
+	})
+})
@@ -0,0 +1,79 @@
+/*
+Copyright 2022 Red Hat Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/redhatinsights/export-service-go/config"
+)
+
+// instrumentationName is the name of the tracer of the service
+const instrumentationName = "github.com/redhatinsights/export-service-go"
+
+// Tracer returns the tracer of the service, its spans are dropped unless Init
+// configured a collector.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Init propagates the W3C trace context of the requests and the messages, and exports
+// the spans to the configured collector. The returned func flushes the spans that
+// were not exported yet, it is called on shutdown.
+func Init(cfg *config.ExportConfig, log *zap.SugaredLogger) func(context.Context) error {
+	// the trace context of the callers is passed on even when the spans are dropped
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	tcfg := cfg.TracingConfig
+	if tcfg.Endpoint == "" {
+		log.Info("tracing is disabled, OTEL_EXPORTER_OTLP_ENDPOINT is not set")
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := NewOTLPExporter(context.Background(), tcfg.Endpoint, tcfg.Headers, tcfg.Timeout)
+	if err != nil {
+		log.Errorw("tracing is disabled, the exporter of the spans could not be created", "error", err)
+		return func(context.Context) error { return nil }
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(tcfg.ServiceName),
+			semconv.HostNameKey.String(cfg.Hostname),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tcfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Errorw("failed to export the spans", "error", err)
+	}))
+
+	log.Infow("exporting the spans", "endpoint", tcfg.Endpoint, "service_name", tcfg.ServiceName, "sample_ratio", tcfg.SampleRatio)
+	return provider.Shutdown
+}
+
+// Fail records the error on the span and marks it as failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}